@@ -1,4 +1,4 @@
-package kubernetescollector
+package filecollector
 
 import (
 	"encoding/json"
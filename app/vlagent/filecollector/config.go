@@ -0,0 +1,205 @@
+package filecollector
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// inputConfig represents a single entry at -fileCollector.config
+type inputConfig struct {
+	// Paths is a list of glob patterns for log files to read.
+	Paths []string `yaml:"paths"`
+
+	// Format is the format of log lines: plain, json or logfmt.
+	Format string `yaml:"format,omitempty"`
+
+	// TenantID is the tenant in the form accountID:projectID to ingest logs to.
+	TenantID string `yaml:"tenant_id,omitempty"`
+
+	StreamFields     []string          `yaml:"stream_fields,omitempty"`
+	MsgFields        []string          `yaml:"msg_fields,omitempty"`
+	TimeFields       []string          `yaml:"time_fields,omitempty"`
+	IgnoreFields     []string          `yaml:"ignore_fields,omitempty"`
+	DecolorizeFields []string          `yaml:"decolorize_fields,omitempty"`
+	ExtraFields      map[string]string `yaml:"extra_fields,omitempty"`
+
+	// Multiline contains optional config for merging multiple lines into a single log entry.
+	Multiline *multilineConfig `yaml:"multiline,omitempty"`
+}
+
+// multilineConfig contains rules for merging multiple lines into a single log entry.
+type multilineConfig struct {
+	// StartPattern is a regexp matching the first line of log entry.
+	StartPattern string `yaml:"start_pattern,omitempty"`
+
+	// ContinuationPattern is a regexp matching the non-first lines of log entry.
+	ContinuationPattern string `yaml:"continuation_pattern,omitempty"`
+
+	// MaxLines is the maximum number of lines in a single log entry.
+	MaxLines int `yaml:"max_lines,omitempty"`
+
+	// Timeout is the duration after which the pending log entry is flushed if no new lines are written to the file.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// input is a parsed inputConfig.
+type input struct {
+	paths []string
+
+	format   string
+	tenantID logstorage.TenantID
+
+	streamFields     []string
+	msgFields        []string
+	timeFields       []string
+	ignoreFields     []string
+	decolorizeFields []string
+	extraFields      []logstorage.Field
+
+	multiline *MultilineRules
+}
+
+// defaultStreamFields is a list of default _stream fields for logs collected from files.
+var defaultStreamFields = []string{"log.file.path"}
+
+var (
+	defaultMsgFields  = []string{"message", "msg", "log"}
+	defaultTimeFields = []string{"time", "timestamp", "ts"}
+)
+
+const (
+	defaultMultilineMaxLines = 500
+	defaultMultilineTimeout  = time.Second
+)
+
+// parseInputs parses -fileCollector.config from YAML data.
+func parseInputs(data []byte) ([]*input, error) {
+	var cfgs []inputConfig
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("missing inputs")
+	}
+
+	inputs := make([]*input, 0, len(cfgs))
+	for i := range cfgs {
+		in, err := cfgs[i].toInput()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse input #%d: %w", i+1, err)
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, nil
+}
+
+func (cfg *inputConfig) toInput() (*input, error) {
+	if len(cfg.Paths) == 0 {
+		return nil, fmt.Errorf("missing `paths`")
+	}
+	for _, p := range cfg.Paths {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("path %q must be absolute", p)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", p, err)
+		}
+	}
+
+	in := &input{
+		paths:            cfg.Paths,
+		streamFields:     fieldsOrDefault(cfg.StreamFields, defaultStreamFields),
+		msgFields:        fieldsOrDefault(cfg.MsgFields, defaultMsgFields),
+		timeFields:       fieldsOrDefault(cfg.TimeFields, defaultTimeFields),
+		ignoreFields:     cfg.IgnoreFields,
+		decolorizeFields: cfg.DecolorizeFields,
+	}
+
+	switch cfg.Format {
+	case "", "plain":
+		in.format = "plain"
+	case "json", "logfmt":
+		in.format = cfg.Format
+	default:
+		return nil, fmt.Errorf("unsupported format=%q; supported values: plain, json, logfmt", cfg.Format)
+	}
+
+	if cfg.TenantID != "" {
+		tenantID, err := logstorage.ParseTenantID(cfg.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant_id=%q: %w", cfg.TenantID, err)
+		}
+		in.tenantID = tenantID
+	}
+
+	for name, value := range cfg.ExtraFields {
+		in.extraFields = append(in.extraFields, logstorage.Field{
+			Name:  name,
+			Value: value,
+		})
+	}
+	slices.SortFunc(in.extraFields, func(a, b logstorage.Field) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	if cfg.Multiline != nil {
+		mr, err := cfg.Multiline.toRules()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `multiline`: %w", err)
+		}
+		in.multiline = mr
+	}
+
+	return in, nil
+}
+
+func (cfg *multilineConfig) toRules() (*MultilineRules, error) {
+	if cfg.MaxLines < 0 {
+		return nil, fmt.Errorf("max_lines=%d cannot be negative", cfg.MaxLines)
+	}
+	if cfg.Timeout < 0 {
+		return nil, fmt.Errorf("timeout=%s cannot be negative", cfg.Timeout)
+	}
+	maxLines := cmp.Or(cfg.MaxLines, defaultMultilineMaxLines)
+	timeout := cmp.Or(cfg.Timeout, defaultMultilineTimeout)
+	return NewMultilineRules(cfg.StartPattern, cfg.ContinuationPattern, maxLines, timeout)
+}
+
+func fieldsOrDefault(fields, defaultFields []string) []string {
+	if len(fields) == 0 {
+		return defaultFields
+	}
+	return fields
+}
+
+// getInputForPath returns the first input with a glob pattern matching the given path.
+func getInputForPath(inputs []*input, path string) *input {
+	for _, in := range inputs {
+		for _, p := range in.paths {
+			if ok, _ := filepath.Match(p, path); ok {
+				return in
+			}
+		}
+	}
+	return nil
+}
+
+func loadInputs(path string) ([]*input, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := parseInputs(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return inputs, nil
+}
@@ -0,0 +1,162 @@
+package filecollector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestParseInputsSuccess(t *testing.T) {
+	inputs, err := parseInputs([]byte(`
+- paths: [/var/log/nginx/*.log]
+- paths: [/var/log/app/*.log, /var/log/app2.log]
+  format: logfmt
+  tenant_id: "12:34"
+  stream_fields: [app]
+  extra_fields:
+    env: prod
+    dc: eu
+  multiline:
+    start_pattern: '^\S'
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("unexpected number of inputs; got %d; want 2", len(inputs))
+	}
+
+	in := inputs[0]
+	if in.format != "plain" {
+		t.Fatalf("unexpected format; got %q; want %q", in.format, "plain")
+	}
+	if !reflect.DeepEqual(in.streamFields, defaultStreamFields) {
+		t.Fatalf("unexpected stream fields; got %q; want %q", in.streamFields, defaultStreamFields)
+	}
+	if in.multiline != nil {
+		t.Fatalf("unexpected multiline rules")
+	}
+
+	in = inputs[1]
+	if in.format != "logfmt" {
+		t.Fatalf("unexpected format; got %q; want %q", in.format, "logfmt")
+	}
+	tenantID := logstorage.TenantID{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+	if in.tenantID != tenantID {
+		t.Fatalf("unexpected tenantID; got %v; want %v", in.tenantID, tenantID)
+	}
+	if !reflect.DeepEqual(in.streamFields, []string{"app"}) {
+		t.Fatalf("unexpected stream fields; got %q; want %q", in.streamFields, []string{"app"})
+	}
+	extraFieldsExpected := []logstorage.Field{
+		{
+			Name:  "dc",
+			Value: "eu",
+		},
+		{
+			Name:  "env",
+			Value: "prod",
+		},
+	}
+	if !reflect.DeepEqual(in.extraFields, extraFieldsExpected) {
+		t.Fatalf("unexpected extra fields; got %v; want %v", in.extraFields, extraFieldsExpected)
+	}
+	if in.multiline.maxLines != defaultMultilineMaxLines {
+		t.Fatalf("unexpected max_lines; got %d; want %d", in.multiline.maxLines, defaultMultilineMaxLines)
+	}
+	if in.multiline.timeout != time.Second {
+		t.Fatalf("unexpected timeout; got %s; want %s", in.multiline.timeout, time.Second)
+	}
+
+	// Verify matching files to inputs
+	f := func(path string, inputExpected *input) {
+		t.Helper()
+
+		in := getInputForPath(inputs, path)
+		if in != inputExpected {
+			t.Fatalf("unexpected input for %q", path)
+		}
+	}
+	f("/var/log/nginx/access.log", inputs[0])
+	f("/var/log/app/foo.log", inputs[1])
+	f("/var/log/app2.log", inputs[1])
+	f("/var/log/app/foo.log.1", nil)
+	f("/var/log/app/nested/foo.log", nil)
+}
+
+func TestParseInputsFailure(t *testing.T) {
+	f := func(data, errExpected string) {
+		t.Helper()
+
+		_, err := parseInputs([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want it to contain %q", err, errExpected)
+		}
+	}
+
+	// empty config
+	f(``, "missing inputs")
+
+	// unknown field
+	f(`
+- paths: [/var/log/*.log]
+  foo: bar
+`, "field foo not found")
+
+	// missing paths
+	f(`
+- format: json
+`, "missing `paths`")
+
+	// relative path
+	f(`
+- paths: [var/log/*.log]
+`, "must be absolute")
+
+	// invalid glob
+	f(`
+- paths: ['/var/log/[.log']
+`, "invalid glob pattern")
+
+	// unsupported format
+	f(`
+- paths: [/var/log/*.log]
+  format: xml
+`, "unsupported format")
+
+	// invalid tenant
+	f(`
+- paths: [/var/log/*.log]
+  tenant_id: foo
+`, "cannot parse tenant_id")
+
+	// empty multiline
+	f(`
+- paths: [/var/log/*.log]
+  multiline: {}
+`, "missing start pattern or continuation pattern")
+
+	// invalid regexp
+	f(`
+- paths: [/var/log/*.log]
+  multiline:
+    start_pattern: '('
+`, "cannot parse start pattern")
+
+	// negative max_lines
+	f(`
+- paths: [/var/log/*.log]
+  multiline:
+    continuation_pattern: '^\s'
+    max_lines: -1
+`, "cannot be negative")
+}
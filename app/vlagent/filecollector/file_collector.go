@@ -1,4 +1,4 @@
-package kubernetescollector
+package filecollector
 
 import (
 	"bytes"
@@ -15,10 +15,10 @@ import (
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 )
 
-// processor processes log lines from a single file.
+// Processor processes log lines from a single file.
 // Log lines can be accumulated within a single file without committing them to the checkpointsDB.
-type processor interface {
-	// tryAddLine processes a log line and returns true if it should be committed to the checkpointsDB.
+type Processor interface {
+	// TryAddLine processes a log line and returns true if it should be committed to the checkpointsDB.
 	// Returns true if the current line should be committed to checkpointsDB, false otherwise.
 	//
 	// This allows accumulating multiple lines within a file before committing, which is useful for:
@@ -26,27 +26,46 @@ type processor interface {
 	// - Batching multiple log lines for efficiency.
 	// - Custom log parsing that needs context from multiple lines.
 	//
-	// Note: when a log file is rotated, no checkpoint will be written until tryAddLine returns true,
+	// Note: when a log file is rotated, no checkpoint will be written until TryAddLine returns true,
 	// ensuring log entries spanning multiple files are handled correctly.
-	tryAddLine(line []byte) bool
+	TryAddLine(line []byte) bool
 
-	// mustClose releases all resources associated with the processor and ensures proper cleanup of internal states.
+	// MustClose releases all resources associated with the Processor and ensures proper cleanup of internal states.
 	// It must be called after the target log file is deleted or vlagent is shutting down.
-	mustClose()
+	MustClose()
 }
 
-type fileCollector struct {
+// MultilineProcessor is a Processor, which merges multiple log lines into a single log entry.
+//
+// The last log entry is kept pending until the next log entry starts, so MultilineProcessor provides additional information
+// for committing the read offset to the checkpointsDB.
+type MultilineProcessor interface {
+	Processor
+
+	// IsEntryStart must return true if the line passed to the last TryAddLine call starts a new pending log entry,
+	// while all the previous lines are already flushed.
+	// In this case the read offset is committed up to the beginning of this line.
+	IsEntryStart() bool
+
+	// FlushPending is called when there are no new lines in the log file.
+	// It must flush the pending log entry if it is pending for too long.
+	//
+	// FlushPending must return true if there are no pending lines after the call.
+	// In this case the current read offset is committed to the checkpointsDB.
+	FlushPending() bool
+}
+
+// Collector reads log lines from files and passes them to the Processor created per each file.
+type Collector struct {
 	logFiles     map[string]struct{}
 	logFilesLock sync.Mutex
 
 	// excludeFilter defines the criteria for excluding log files from processing.
 	// It matches against common metadata fields associated with the log source,
 	// such as 'kubernetes.container_name', 'kubernetes.pod_node_name', or 'kubernetes.pod_namespace'.
-	//
-	// See getCommonFields for the full list of available metadata fields.
 	excludeFilter *logstorage.Filter
 
-	newProcessor func(commonFields []logstorage.Field) processor
+	newProcessor func(commonFields []logstorage.Field) Processor
 
 	checkpointsDB *checkpointsDB
 
@@ -54,19 +73,19 @@ type fileCollector struct {
 	stopCh chan struct{}
 }
 
-// startFileCollector starts watching for new logs in a given directory.
-// The caller must call stop() when the fileCollector is no longer needed.
+// StartCollector returns a new Collector, which stores reading checkpoints at checkpointsPath.
+// The caller must call Stop() when the Collector is no longer needed.
 //
-// The fileCollector maintains a checkpoint file that serves as persistent state storage.
+// The Collector maintains a checkpoint file that serves as persistent state storage.
 // This allows resuming log reading from the exact position where it was interrupted
 // when vlagent is restarted, preventing duplication.
-func startFileCollector(checkpointsPath string, excludeFilter *logstorage.Filter, newProcessor func(commonFields []logstorage.Field) processor) *fileCollector {
+func StartCollector(checkpointsPath string, excludeFilter *logstorage.Filter, newProcessor func(commonFields []logstorage.Field) Processor) *Collector {
 	checkpointsDB, err := startCheckpointsDB(checkpointsPath)
 	if err != nil {
 		logger.Panicf("FATAL: cannot start checkpoints DB: %s", err)
 	}
 
-	return &fileCollector{
+	return &Collector{
 		logFiles:      make(map[string]struct{}),
 		excludeFilter: excludeFilter,
 		newProcessor:  newProcessor,
@@ -75,7 +94,10 @@ func startFileCollector(checkpointsPath string, excludeFilter *logstorage.Filter
 	}
 }
 
-func (fc *fileCollector) startRead(filepath string, commonFields []logstorage.Field) {
+// StartRead starts reading log lines from the file at filepath unless it is already being read.
+//
+// commonFields are passed to the newProcessor callback given to StartCollector.
+func (fc *Collector) StartRead(filepath string, commonFields []logstorage.Field) {
 	fc.logFilesLock.Lock()
 	_, ok := fc.logFiles[filepath]
 	fc.logFiles[filepath] = struct{}{}
@@ -91,7 +113,7 @@ func (fc *fileCollector) startRead(filepath string, commonFields []logstorage.Fi
 	})
 }
 
-func (fc *fileCollector) openLogFile(filepath string) *logFile {
+func (fc *Collector) openLogFile(filepath string) *logFile {
 	cp, ok := fc.checkpointsDB.get(filepath)
 	if !ok {
 		// No checkpoint found - start reading from the beginning of the file.
@@ -111,7 +133,7 @@ func tryResumeFromCheckpoint(filepath string, cp checkpoint) (*logFile, bool) {
 	if !ok {
 		// The file was deleted just after startRead was called.
 		logger.Warnf("log file %q was deleted before being fully read; "+
-			"this is expected if the Pod was deleted while vlagent was starting", filepath)
+			"this is expected if the file was removed (for example, together with its Pod) while vlagent was starting", filepath)
 		return nil, false
 	}
 
@@ -129,8 +151,8 @@ func tryResumeFromCheckpoint(filepath string, cp checkpoint) (*logFile, bool) {
 			// This means the file was rotated and potentially removed before we could process it.
 			logger.Warnf("skipping log file %q: rotated log file not found (inode=%d); "+
 				"some log lines may have been lost; "+
-				"this typically happens when Pod logs rotate faster than vlagent can process them during startup or downtime; "+
-				"consider increasing kubelet's --container-log-max-size to reduce log rotation frequency",
+				"this typically happens when logs rotate faster than vlagent can process them during startup or downtime; "+
+				"consider reducing log rotation frequency (for example, by increasing kubelet's --container-log-max-size in Kubernetes)",
 				filepath, cp.Inode)
 			return nil, false
 		}
@@ -141,8 +163,8 @@ func tryResumeFromCheckpoint(filepath string, cp checkpoint) (*logFile, bool) {
 		logger.Warnf("skipping log file %q: file content changed unexpectedly (expected fingerprint=%d, got=%d); "+
 			"log file was likely rotated and truncated before vlagent could finish reading; "+
 			"some log lines may have been lost; "+
-			"this typically happens when Pod logs rotate faster than vlagent can process them during startup or downtime; "+
-			"consider increasing kubelet's --container-log-max-size to reduce log rotation frequency",
+			"this typically happens when logs rotate faster than vlagent can process them during startup or downtime; "+
+			"consider reducing log rotation frequency (for example, by increasing kubelet's --container-log-max-size in Kubernetes)",
 			filepath, cp.Fingerprint, fp)
 		return nil, false
 	}
@@ -178,7 +200,7 @@ func getFileFingerprint(f *os.File) uint64 {
 	return fp
 }
 
-func (fc *fileCollector) process(lf *logFile, commonFields []logstorage.Field) {
+func (fc *Collector) process(lf *logFile, commonFields []logstorage.Field) {
 	defer lf.close()
 
 	if fc.excludeFilter != nil && fc.excludeFilter.MatchRow(commonFields) {
@@ -187,11 +209,11 @@ func (fc *fileCollector) process(lf *logFile, commonFields []logstorage.Field) {
 		return
 	}
 
-	bt := newBackoffTimer(time.Millisecond*100, time.Second*10)
-	defer bt.stop()
+	bt := NewBackoffTimer(time.Millisecond*100, time.Second*10)
+	defer bt.Stop()
 
 	proc := fc.newProcessor(commonFields)
-	defer proc.mustClose()
+	defer proc.MustClose()
 
 	for {
 		if needStop(fc.stopCh) {
@@ -202,28 +224,47 @@ func (fc *fileCollector) process(lf *logFile, commonFields []logstorage.Field) {
 		if ok {
 			// Some lines were read - update checkpoint and wait before checking again.
 			fc.checkpointsDB.set(lf.checkpoint())
-			bt.reset()
-			bt.wait(fc.stopCh)
+			bt.Reset()
+			bt.Wait(fc.stopCh)
 			continue
 		}
 
 		// No lines read - check the log file status.
 		switch lf.status() {
 		case logFileStatusNotRotated:
+			if mp, ok := proc.(MultilineProcessor); ok {
+				if mp.FlushPending() {
+					if lf.commit() {
+						fc.checkpointsDB.set(lf.checkpoint())
+					}
+				} else {
+					// Check pending lines frequently in order to flush them in a timely manner.
+					bt.Reset()
+				}
+			}
+
 			// No more lines to read and file hasn't rotated - wait before checking again.
-			bt.wait(fc.stopCh)
+			bt.Wait(fc.stopCh)
+			continue
+		case logFileStatusTruncated:
+			// The file was truncated in place (for example, by logrotate with copytruncate option).
+			// Start reading it from the beginning.
+			logger.Infof("log file %q was truncated; reading it from the beginning", lf.path)
+			lf.resetOffset()
 			continue
 		case logFileStatusRotated:
 			// Ensure all remaining lines are flushed to the rotated file and read from it.
 			// Do not use fc.stopCh here to finish reading from the rotated file even if vlagent is shutting down.
 			var neverStopCh chan struct{}
-			bt.reset()
-			bt.wait(neverStopCh)
+			bt.Reset()
+			bt.Wait(neverStopCh)
 			if lf.readLines(neverStopCh, proc) {
-				// Double-check: if there are still new lines, it's an unexpected situation.
-				bt.wait(neverStopCh)
+				// Double-check: if there are still new lines, then the writer didn't switch to the new file yet.
+				// This is unexpected for Container Runtime, but may happen for applications, which reopen their log files
+				// with some delay after the rotation.
+				bt.Wait(neverStopCh)
 				if lf.readLines(neverStopCh, proc) {
-					logger.Panicf("BUG: log file %q was appended after rotation", lf.path)
+					logger.Warnf("log file %q was appended after rotation; some lines written to it after the rotation may be lost", lf.path)
 				}
 			}
 
@@ -231,7 +272,7 @@ func (fc *fileCollector) process(lf *logFile, commonFields []logstorage.Field) {
 				fc.checkpointsDB.set(lf.checkpoint())
 			} else {
 				// Cannot reopen the file right now - wait before retrying.
-				bt.wait(fc.stopCh)
+				bt.Wait(fc.stopCh)
 			}
 			continue
 		case logFileStatusDeleted:
@@ -249,7 +290,7 @@ func (fc *fileCollector) process(lf *logFile, commonFields []logstorage.Field) {
 
 // forgetFile removes the given file from the tracking list and deletes its checkpoint.
 // It is called when the file is not expected to reappear, so its state no longer needs to be stored.
-func (fc *fileCollector) forgetFile(filePath string) {
+func (fc *Collector) forgetFile(filePath string) {
 	fc.checkpointsDB.delete(filePath)
 
 	fc.logFilesLock.Lock()
@@ -296,8 +337,8 @@ func findRenamedFile(logPath string, inode uint64) (*os.File, bool) {
 	return nil, false
 }
 
-// cleanupCheckpoints removes all checkpoints for files that are no longer being processed.
-func (fc *fileCollector) cleanupCheckpoints() {
+// CleanupCheckpoints removes all checkpoints for files that are no longer being processed.
+func (fc *Collector) CleanupCheckpoints() {
 	unusedCheckpoints := fc.getUnusedCheckpoints()
 	if len(unusedCheckpoints) == 0 {
 		return
@@ -308,11 +349,11 @@ func (fc *fileCollector) cleanupCheckpoints() {
 	}
 
 	logger.Warnf("%d log files were deleted before being fully read; "+
-		"this is expected if Pods were deleted while vlagent was restarting; "+
+		"this is expected if log files were removed (for example, together with their Pods) while vlagent was restarting; "+
 		"an example of such file: %q", len(unusedCheckpoints), unusedCheckpoints[0].Path)
 }
 
-func (fc *fileCollector) getUnusedCheckpoints() []checkpoint {
+func (fc *Collector) getUnusedCheckpoints() []checkpoint {
 	cps := fc.checkpointsDB.getAll()
 
 	fc.logFilesLock.Lock()
@@ -328,7 +369,8 @@ func (fc *fileCollector) getUnusedCheckpoints() []checkpoint {
 	return unused
 }
 
-func (fc *fileCollector) stop() {
+// Stop stops reading all the log files and persists the checkpoints.
+func (fc *Collector) Stop() {
 	close(fc.stopCh)
 	fc.wg.Wait()
 	fc.checkpointsDB.stop()
@@ -0,0 +1,432 @@
+package filecollector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestFileCollector(t *testing.T) {
+	checkpointsPath := filepath.Join(t.TempDir(), "checkpoints.json")
+	logFilePath, inode := createTestLogFile(t)
+
+	f := func(resultsExpected []string, inodeExpected uint64, offsetExpected int) {
+		t.Helper()
+
+		proc := newTestLogFileProcessor()
+		pw := newProcessorWrapper(proc, len(resultsExpected))
+		newProc := func(_ []logstorage.Field) Processor {
+			return pw
+		}
+
+		fc := StartCollector(checkpointsPath, nil, newProc)
+
+		fc.StartRead(logFilePath, nil)
+		pw.wait()
+		fc.Stop()
+
+		if err := proc.verify(resultsExpected); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		cpGot, ok := fc.checkpointsDB.get(logFilePath)
+		if !ok {
+			t.Fatalf("checkpoint for %q is missing", logFilePath)
+		}
+
+		if cpGot.Inode != inodeExpected {
+			t.Fatalf("unexpected inode in checkpoint; got %d; want %d", cpGot.Inode, inodeExpected)
+		}
+		if cpGot.Offset != int64(offsetExpected) {
+			t.Fatalf("unexpected offset in checkpoint; got %d; want %d", cpGot.Offset, offsetExpected)
+		}
+	}
+
+	// Test that the collector reads all log lines from the given log file.
+	in := []string{"line1", "line2", "line3", "line4", "line5"}
+	resultsExpected := in
+	offsetExpected := len("line1\nline2\nline3\nline4\nline5\n")
+	writeLinesToFile(t, logFilePath, in...)
+	f(resultsExpected, inode, offsetExpected)
+
+	// Test that the collector continues reading from the last read offset after restart.
+	writeLinesToFile(t, logFilePath, "line6", "line7")
+	resultsExpected = []string{"line6", "line7"}
+	offsetExpected = len("line1\nline2\nline3\nline4\nline5\nline6\nline7\n")
+	f(resultsExpected, inode, offsetExpected)
+
+	// Test that the collector switches to the next log file after rotation.
+	writeLinesToFile(t, logFilePath, "1", "22")
+	newInode := rotateLogFile(t, logFilePath)
+	writeLinesToFile(t, logFilePath, "333")
+	resultsExpected = []string{"1", "22", "333"}
+	offsetExpected = len("333\n")
+	f(resultsExpected, newInode, offsetExpected)
+}
+
+func TestCommitPartialLines(t *testing.T) {
+	checkpointsPath := filepath.Join(t.TempDir(), "checkpoints.json")
+	logFilePath, inode := createTestLogFile(t)
+
+	f := func(readLinesExpected int, resultsExpected []string, inodeExpected uint64, offsetExpected int) {
+		t.Helper()
+
+		proc := newTestLogFileProcessor()
+		pw := newProcessorWrapper(proc, readLinesExpected)
+		newProc := func(_ []logstorage.Field) Processor {
+			return pw
+		}
+
+		fc := StartCollector(checkpointsPath, nil, newProc)
+		fc.StartRead(logFilePath, nil)
+
+		pw.wait()
+		fc.Stop()
+
+		if err := proc.verify(resultsExpected); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		cpGot, ok := fc.checkpointsDB.get(logFilePath)
+		if !ok {
+			t.Fatalf("checkpoint for %q is missing", logFilePath)
+		}
+
+		if cpGot.Inode != inodeExpected {
+			t.Fatalf("unexpected inode in checkpoint; got %d; want %d", cpGot.Inode, inodeExpected)
+		}
+		if cpGot.Offset != int64(offsetExpected) {
+			t.Fatalf("unexpected offset in checkpoint; got %d; want %d", cpGot.Offset, offsetExpected)
+		}
+	}
+
+	// Verify that the collector commits only the full line to the checkpointsDB.
+	writeLinesToFile(t, logFilePath, "full line", `foo \`)
+	readLinesExpected := 2
+	resultsExpected := []string{"full line"}
+	offsetExpected := len("full line\n")
+	f(readLinesExpected, resultsExpected, inode, offsetExpected)
+
+	// Write another partial line to the rotated log file to ensure that the collector switches to the new file.
+	newInode := rotateLogFile(t, logFilePath)
+	writeLinesToFile(t, logFilePath, `bar \`)
+	readLinesExpected = 2
+	resultsExpected = []string{}
+	f(readLinesExpected, resultsExpected, inode, offsetExpected)
+
+	// Write a final line to the rotated log file and verify that the collector commits the full line to the checkpointsDB.
+	writeLinesToFile(t, logFilePath, "buz")
+	readLinesExpected = 3
+	resultsExpected = []string{"foo bar buz"}
+	offsetExpected = len("bar \\\n" + "buz\n")
+	f(readLinesExpected, resultsExpected, newInode, offsetExpected)
+}
+
+func TestFlushPendingLines(t *testing.T) {
+	checkpointsPath := filepath.Join(t.TempDir(), "checkpoints.json")
+	logFilePath, inode := createTestLogFile(t)
+
+	proc := newTestLogFileProcessor()
+	pw := newProcessorWrapper(proc, 2)
+	pw.flushPending = true
+	pw.flushes.Add(1)
+	newProc := func(_ []logstorage.Field) Processor {
+		return pw
+	}
+
+	writeLinesToFile(t, logFilePath, "foo", `bar \`)
+
+	fc := StartCollector(checkpointsPath, nil, newProc)
+	fc.StartRead(logFilePath, nil)
+	pw.wait()
+
+	// Wait until the collector flushes the pending line.
+	pw.flushes.Wait()
+	fc.Stop()
+
+	if err := proc.verify([]string{"foo", "bar "}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cpGot, ok := fc.checkpointsDB.get(logFilePath)
+	if !ok {
+		t.Fatalf("checkpoint for %q is missing", logFilePath)
+	}
+	offsetExpected := len("foo\nbar \\\n")
+	if cpGot.Inode != inode {
+		t.Fatalf("unexpected inode in checkpoint; got %d; want %d", cpGot.Inode, inode)
+	}
+	if cpGot.Offset != int64(offsetExpected) {
+		t.Fatalf("unexpected offset in checkpoint; got %d; want %d", cpGot.Offset, offsetExpected)
+	}
+}
+
+func TestReadTruncatedFile(t *testing.T) {
+	checkpointsPath := filepath.Join(t.TempDir(), "checkpoints.json")
+	logFilePath, inode := createTestLogFile(t)
+
+	proc := newTestLogFileProcessor()
+	pw := newProcessorWrapper(proc, 3)
+	newProc := func(_ []logstorage.Field) Processor {
+		return pw
+	}
+
+	writeLinesToFile(t, logFilePath, "foo", "bar")
+
+	fc := StartCollector(checkpointsPath, nil, newProc)
+	fc.StartRead(logFilePath, nil)
+
+	// Wait until the collector reads the written lines and truncate the file then.
+	deadline := time.Now().Add(5 * time.Second)
+	for proc.linesCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for log lines")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Truncate(logFilePath, 0); err != nil {
+		t.Fatalf("cannot truncate log file: %s", err)
+	}
+	writeLinesToFile(t, logFilePath, "baz")
+
+	pw.wait()
+	fc.Stop()
+
+	if err := proc.verify([]string{"foo", "bar", "baz"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cpGot, ok := fc.checkpointsDB.get(logFilePath)
+	if !ok {
+		t.Fatalf("checkpoint for %q is missing", logFilePath)
+	}
+	if cpGot.Inode != inode {
+		t.Fatalf("unexpected inode in checkpoint; got %d; want %d", cpGot.Inode, inode)
+	}
+	if cpGot.Offset != int64(len("baz\n")) {
+		t.Fatalf("unexpected offset in checkpoint; got %d; want %d", cpGot.Offset, len("baz\n"))
+	}
+}
+
+func TestRestoringFromFingerprint(t *testing.T) {
+	f := func(file1, file2 string, outExpected []string) {
+		t.Helper()
+
+		checkpointsPath := filepath.Join(t.TempDir(), "checkpoints.json")
+		logFilePath, _ := createTestLogFile(t)
+
+		proc := newTestLogFileProcessor()
+
+		for _, s := range []string{file1, file2} {
+			pw := newProcessorWrapper(proc, 1)
+			newProc := func(_ []logstorage.Field) Processor {
+				return pw
+			}
+
+			f, err := os.Create(logFilePath)
+			if err != nil {
+				t.Fatalf("failed to create log file: %s", err)
+			}
+			writeToFile(t, f, s)
+			_ = f.Sync()
+			_ = f.Close()
+
+			fc := StartCollector(checkpointsPath, nil, newProc)
+			fc.StartRead(logFilePath, nil)
+			pw.wait()
+			fc.Stop()
+		}
+
+		if err := proc.verify(outExpected); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// The same fingerprints.
+	file1 := "2025-10-16T15:37:36.1Z foo\n"
+	file2 := file1 + "2025-10-16T15:37:36.2Z bar\n"
+	f(file1, file2, []string{"2025-10-16T15:37:36.1Z foo", "2025-10-16T15:37:36.2Z bar"})
+
+	// The same fingerprints with empty lines.
+	file1 = "\n"
+	file2 = file1 + "\n"
+	f(file1, file2, []string{"", ""})
+
+	// Different fingerprints.
+	file1 = "2025-10-16T15:37:36.3Z foo\n"
+	file2 = "2025-10-16T15:37:36.4Z bar\n"
+	f(file1, file2, []string{"2025-10-16T15:37:36.3Z foo", "2025-10-16T15:37:36.4Z bar"})
+
+	// Different fingerprints with empty lines.
+	file1 = "2025-10-16T15:37:36.5Z foo\n"
+	file2 = "\n"
+	f(file1, file2, []string{"2025-10-16T15:37:36.5Z foo", ""})
+
+	// Content length more than maxFingerprintDataLen.
+	file1 = "2025-10-16T15:37:36.6Z foo bar buz 01234567890123456789001234567890012345678900123456789\n"
+	file2 = "2025-10-16T15:37:36.7Z bar\n"
+	f(file1, file2, []string{"2025-10-16T15:37:36.6Z foo bar buz 01234567890123456789001234567890012345678900123456789", "2025-10-16T15:37:36.7Z bar"})
+
+	// Content length exceeds MaxLogLineSize.
+	file1 = "2025-10-16T15:37:36.1Z " + strings.Repeat("a", MaxLogLineSize) + "\n" +
+		"2025-10-16T15:37:35.8Z foo\n"
+	file2 = "2025-10-16T15:37:36.9Z bar\n"
+	f(file1, file2, []string{"2025-10-16T15:37:35.8Z foo", "2025-10-16T15:37:36.9Z bar"})
+}
+
+type processorWrapper struct {
+	proc *testLogFileProcessor
+	wg   *sync.WaitGroup
+
+	// flushPending enables flushing pending lines at FlushPending calls.
+	flushPending bool
+	// flushes is decremented after the first flush of pending lines.
+	flushes     sync.WaitGroup
+	flushesDone bool
+}
+
+func newProcessorWrapper(proc *testLogFileProcessor, n int) *processorWrapper {
+	wg := &sync.WaitGroup{}
+	wg.Add(n)
+
+	return &processorWrapper{
+		proc: proc,
+		wg:   wg,
+	}
+}
+
+func (pw *processorWrapper) TryAddLine(line []byte) bool {
+	ok := pw.proc.TryAddLine(line)
+	pw.wg.Done()
+	return ok
+}
+
+func (pw *processorWrapper) IsEntryStart() bool {
+	return pw.proc.isEntryStart()
+}
+
+func (pw *processorWrapper) FlushPending() bool {
+	if !pw.flushPending {
+		return !pw.proc.hasPending()
+	}
+	if pw.proc.flush() && !pw.flushesDone {
+		pw.flushesDone = true
+		pw.flushes.Done()
+	}
+	return true
+}
+
+func (pw *processorWrapper) MustClose() {
+	pw.proc.MustClose()
+}
+
+func (pw *processorWrapper) wait() {
+	pw.wg.Wait()
+}
+
+// testLogFileProcessor collects log lines.
+//
+// Lines ending with a backslash are joined with the next line.
+type testLogFileProcessor struct {
+	mu         sync.Mutex
+	logLines   []string
+	pending    []byte
+	entryStart bool
+}
+
+func newTestLogFileProcessor() *testLogFileProcessor {
+	return &testLogFileProcessor{}
+}
+
+func (lfp *testLogFileProcessor) TryAddLine(line []byte) bool {
+	lfp.mu.Lock()
+	defer lfp.mu.Unlock()
+
+	if n := len(line); n > 0 && line[n-1] == '\\' {
+		lfp.entryStart = len(lfp.pending) == 0
+		lfp.pending = append(lfp.pending, line[:n-1]...)
+		return false
+	}
+	lfp.pending = append(lfp.pending, line...)
+	lfp.logLines = append(lfp.logLines, string(lfp.pending))
+	lfp.pending = lfp.pending[:0]
+	return true
+}
+
+// flush flushes the pending line and returns true if it was non-empty.
+func (lfp *testLogFileProcessor) flush() bool {
+	lfp.mu.Lock()
+	defer lfp.mu.Unlock()
+
+	if len(lfp.pending) == 0 {
+		return false
+	}
+	lfp.logLines = append(lfp.logLines, string(lfp.pending))
+	lfp.pending = lfp.pending[:0]
+	return true
+}
+
+func (lfp *testLogFileProcessor) isEntryStart() bool {
+	lfp.mu.Lock()
+	defer lfp.mu.Unlock()
+
+	return lfp.entryStart
+}
+
+func (lfp *testLogFileProcessor) hasPending() bool {
+	lfp.mu.Lock()
+	defer lfp.mu.Unlock()
+
+	return len(lfp.pending) > 0
+}
+
+func (lfp *testLogFileProcessor) MustClose() {}
+
+func (lfp *testLogFileProcessor) linesCount() int {
+	lfp.mu.Lock()
+	defer lfp.mu.Unlock()
+
+	return len(lfp.logLines)
+}
+
+func (lfp *testLogFileProcessor) verify(expected []string) error {
+	lfp.mu.Lock()
+	defer lfp.mu.Unlock()
+
+	got := strings.Join(lfp.logLines, "\n")
+	want := strings.Join(expected, "\n")
+	if got != want {
+		return fmt.Errorf("unexpected log lines;\ngot:\n%q\nwant:\n%q", got, want)
+	}
+
+	return nil
+}
+
+func rotateLogFile(t *testing.T, logFilePath string) uint64 {
+	t.Helper()
+
+	oldFileName := tryResolveSymlink(logFilePath)
+	newFileName := fmt.Sprintf("%s-%d", oldFileName, time.Now().UnixNano())
+	if err := os.Rename(oldFileName, newFileName); err != nil {
+		t.Fatalf("failed to rename log file: %s", err)
+	}
+	f, err := os.Create(oldFileName)
+	if err != nil {
+		t.Fatalf("failed to create new log file: %s", err)
+	}
+	defer f.Close()
+
+	stat, ok := mustStat(oldFileName)
+	if !ok {
+		t.Fatalf("failed to stat log file %q", oldFileName)
+	}
+	inode := getInode(stat)
+
+	return inode
+}
@@ -0,0 +1,142 @@
+package filecollector
+
+import (
+	"flag"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/remotewrite"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var (
+	enabled         = flag.Bool("fileCollector", false, "Whether to enable collecting logs from files matching glob patterns at -fileCollector.config")
+	configPath      = flag.String("fileCollector.config", "", "Path to YAML file with the list of log files to collect when -fileCollector is set. See https://docs.victoriametrics.com/victorialogs/vlagent/#collecting-logs-from-files")
+	checkpointsPath = flag.String("fileCollector.checkpointsPath", "",
+		"Path to file with checkpoints for log files. "+
+			"Checkpoints are used to persist the read offsets for log files collected via -fileCollector. "+
+			"When vlagent is restarted, it resumes reading logs from the stored offsets to avoid log duplication; "+
+			"if this flag isn't set, then checkpoints are saved into vlagent-file-checkpoints.json under -tmpDataPath directory")
+	scanInterval = flag.Duration("fileCollector.scanInterval", 10*time.Second, "Interval for checking for new files matching glob patterns at -fileCollector.config")
+)
+
+var collector *globCollector
+
+// Init starts collecting logs from files if -fileCollector is set.
+func Init(tmpDataPath string) {
+	if !*enabled {
+		return
+	}
+
+	if *configPath == "" {
+		logger.Fatalf("missing -fileCollector.config")
+	}
+	inputs, err := loadInputs(*configPath)
+	if err != nil {
+		logger.Fatalf("cannot load -fileCollector.config: %s", err)
+	}
+
+	path := *checkpointsPath
+	if len(path) == 0 {
+		path = filepath.Join(tmpDataPath, "vlagent-file-checkpoints.json")
+	}
+
+	collector = startGlobCollector(&remotewrite.Storage{}, inputs, path, *scanInterval)
+
+	logger.Infof("started collecting logs from files according to -fileCollector.config=%q", *configPath)
+}
+
+// Stop stops collecting logs from files.
+func Stop() {
+	if collector != nil {
+		collector.stop()
+		collector = nil
+	}
+}
+
+// globCollector collects logs from files matching glob patterns of the configured inputs.
+type globCollector struct {
+	inputs []*input
+
+	fc *Collector
+
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+}
+
+func startGlobCollector(storage insertutil.LogRowsStorage, inputs []*input, checkpointsPath string, scanInterval time.Duration) *globCollector {
+	newProcessor := func(commonFields []logstorage.Field) Processor {
+		// commonFields always contain log.file.path field.
+		in := getInputForPath(inputs, commonFields[0].Value)
+		return newLogFileProcessor(storage, in, commonFields)
+	}
+	fc := StartCollector(checkpointsPath, nil, newProcessor)
+
+	gc := &globCollector{
+		inputs: inputs,
+		fc:     fc,
+		stopCh: make(chan struct{}),
+	}
+
+	// Start reading existing files.
+	gc.scan()
+	// Cleanup checkpoints for deleted files.
+	fc.CleanupCheckpoints()
+
+	gc.wg.Go(func() {
+		ticker := time.NewTicker(scanInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-gc.stopCh:
+				return
+			case <-ticker.C:
+				gc.scan()
+			}
+		}
+	})
+
+	return gc
+}
+
+// scan starts reading all the files matching the configured glob patterns.
+//
+// Files, which are already being read, are skipped by Collector.StartRead.
+func (gc *globCollector) scan() {
+	for _, in := range gc.inputs {
+		for _, pattern := range in.paths {
+			paths, err := filepath.Glob(pattern)
+			if err != nil {
+				logger.Panicf("BUG: unexpected error for the verified glob pattern %q: %s", pattern, err)
+			}
+			for _, path := range paths {
+				if getInputForPath(gc.inputs, path) != in {
+					// The file is collected by the previous input.
+					continue
+				}
+				if fi, ok := mustStat(path); !ok || !fi.Mode().IsRegular() {
+					continue
+				}
+
+				commonFields := []logstorage.Field{
+					{
+						Name:  "log.file.path",
+						Value: path,
+					},
+				}
+				gc.fc.StartRead(path, commonFields)
+			}
+		}
+	}
+}
+
+func (gc *globCollector) stop() {
+	close(gc.stopCh)
+	gc.wg.Wait()
+	gc.fc.Stop()
+}
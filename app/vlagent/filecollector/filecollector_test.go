@@ -0,0 +1,82 @@
+package filecollector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGlobCollector(t *testing.T) {
+	dir := t.TempDir()
+	checkpointsPath := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	config := `
+- paths: [` + filepath.Join(dir, "*.json") + `]
+  format: json
+  stream_fields: [app]
+- paths: [` + filepath.Join(dir, "*.log") + `]
+`
+	inputs, err := parseInputs([]byte(config))
+	if err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+
+	jsonPath := filepath.Join(dir, "app.json")
+	plainPath := filepath.Join(dir, "app.log")
+	writeLinesToFile(t, jsonPath, `{"app":"foo","_msg":"bar"}`)
+	writeLinesToFile(t, plainPath, "baz")
+	writeLinesToFile(t, filepath.Join(dir, "app.log.1"), "rotated")
+
+	storage := newTestStorage()
+	gc := startGlobCollector(storage, inputs, checkpointsPath, 10*time.Millisecond)
+
+	waitForRows := func(rowsExpected []string) {
+		t.Helper()
+
+		slices.Sort(rowsExpected)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rows := storage.getRows()
+			slices.Sort(rows)
+			if reflect.DeepEqual(rows, rowsExpected) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected rows;\ngot\n%q\nwant\n%q", rows, rowsExpected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	rowsExpected := []string{
+		`{"log.file.path":"` + jsonPath + `","app":"foo","_msg":"bar"}`,
+		`{"log.file.path":"` + plainPath + `","_msg":"baz"}`,
+	}
+	waitForRows(rowsExpected)
+
+	// New files must be picked up by the periodic scan
+	newPath := filepath.Join(dir, "new.log")
+	writeLinesToFile(t, newPath, "qwe")
+	rowsExpected = append(rowsExpected, `{"log.file.path":"`+newPath+`","_msg":"qwe"}`)
+	waitForRows(rowsExpected)
+
+	// Files must be read after rotation
+	if err := os.Rename(plainPath, plainPath+".2"); err != nil {
+		t.Fatalf("cannot rename file: %s", err)
+	}
+	writeLinesToFile(t, plainPath, "after rotation")
+	rowsExpected = append(rowsExpected, `{"log.file.path":"`+plainPath+`","_msg":"after rotation"}`)
+	waitForRows(rowsExpected)
+
+	gc.stop()
+
+	// The restarted collector must resume from checkpoints
+	writeLinesToFile(t, jsonPath, `{"app":"foo","_msg":"after restart"}`)
+	rowsExpected = append(rowsExpected, `{"log.file.path":"`+jsonPath+`","app":"foo","_msg":"after restart"}`)
+	gc = startGlobCollector(storage, inputs, checkpointsPath, 10*time.Millisecond)
+	waitForRows(rowsExpected)
+	gc.stop()
+}
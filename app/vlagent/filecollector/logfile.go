@@ -1,4 +1,4 @@
-package kubernetescollector
+package filecollector
 
 import (
 	"bytes"
@@ -15,9 +15,9 @@ import (
 	"github.com/cespare/xxhash/v2"
 )
 
-// The maximum log line size that VictoriaLogs can accept.
+// MaxLogLineSize is the maximum log line size that VictoriaLogs can accept.
 // See https://docs.victoriametrics.com/victorialogs/faq/#what-length-a-log-record-is-expected-to-have
-const maxLogLineSize = 2 * 1024 * 1024
+const MaxLogLineSize = 2 * 1024 * 1024
 
 type logFile struct {
 	path string
@@ -87,7 +87,7 @@ var (
 	processConcurrencyCh = make(chan struct{}, cgroup.AvailableCPUs())
 )
 
-func (lf *logFile) readLines(stopCh <-chan struct{}, proc processor) bool {
+func (lf *logFile) readLines(stopCh <-chan struct{}, proc Processor) bool {
 	if lf.file == nil {
 		// This happens on the first read attempt.
 		// File may not exist in the case of races with Container Runtime or OS.
@@ -132,7 +132,7 @@ func (lf *logFile) readLines(stopCh <-chan struct{}, proc processor) bool {
 	}
 }
 
-func (lf *logFile) processLines(data []byte, p processor) {
+func (lf *logFile) processLines(data []byte, p Processor) {
 	if len(data) == 0 {
 		return
 	}
@@ -175,7 +175,7 @@ func (lf *logFile) tryCompleteTail(data []byte) ([]byte, []byte, bool) {
 	if n < 0 {
 		// Tail is not finished yet.
 		lf.tailSize += len(data)
-		if lf.tailSize <= maxLogLineSize {
+		if lf.tailSize <= MaxLogLineSize {
 			lf.tail.B = append(lf.tail.B, data...)
 		}
 		return nil, nil, false
@@ -185,14 +185,14 @@ func (lf *logFile) tryCompleteTail(data []byte) ([]byte, []byte, bool) {
 	data = data[n+1:]
 
 	lf.tailSize += len(tailEnd)
-	if lf.tailSize > maxLogLineSize {
+	if lf.tailSize > MaxLogLineSize {
 		// Discard the too large log line.
 		//
 		// This is unexpected in default Kubernetes installations since
 		// containerd splits log lines into 16 KiB chunks by default (criLine.partial will be true for such lines).
 		// See: https://github.com/containerd/containerd/blob/f37f951f5601b309e3b31fadf66991625370f7ba/docs/cri/config.md?plain=1#L399-L402
 		logger.Warnf("log line from file %q with size %d bytes exceeds maximum allowed size of %d MiB",
-			lf.path, lf.tailSize, maxLogLineSize/1024/1024)
+			lf.path, lf.tailSize, MaxLogLineSize/1024/1024)
 
 		if lf.offset == 0 {
 			// This is the first line of the current file.
@@ -239,17 +239,25 @@ func (lf *logFile) setTail(tail []byte) {
 
 var tailByteBufferPool bytesutil.ByteBufferPool
 
-func (lf *logFile) addLine(p processor, line []byte) {
+func (lf *logFile) addLine(p Processor, line []byte) {
 	if lf.offset == 0 {
 		// This is the first line of the current file.
 		lf.fingerprint = calcFingerprint(line)
 	}
+	lineOffset := lf.offset
 	lf.offset += int64(len(line) + len("\n"))
 
-	if p.tryAddLine(line) {
+	if p.TryAddLine(line) {
 		lf.commitInode = lf.inode
 		lf.commitFingerprint = lf.fingerprint
 		lf.commitOffset = lf.offset
+		return
+	}
+	if mp, ok := p.(MultilineProcessor); ok && mp.IsEntryStart() {
+		// All the lines before the current line are flushed.
+		lf.commitInode = lf.inode
+		lf.commitFingerprint = lf.fingerprint
+		lf.commitOffset = lineOffset
 	}
 }
 
@@ -275,6 +283,7 @@ type logFileStatus byte
 const (
 	logFileStatusNotRotated logFileStatus = iota
 	logFileStatusRotated
+	logFileStatusTruncated
 	logFileStatusDeleted
 )
 
@@ -294,6 +303,9 @@ func (lf *logFile) status() logFileStatus {
 
 	newInode := getInode(stat)
 	if lf.inode == newInode {
+		if stat.Size() < lf.offset {
+			return logFileStatusTruncated
+		}
 		return logFileStatusNotRotated
 	}
 	if stat.Size() == 0 {
@@ -319,6 +331,38 @@ func (lf *logFile) setOffset(offset int64) {
 	lf.commitOffset = offset
 }
 
+// resetOffset starts reading the current file from the beginning.
+//
+// It is used when the file is truncated in place.
+func (lf *logFile) resetOffset() {
+	if _, err := lf.file.Seek(0, io.SeekStart); err != nil {
+		logger.Panicf("FATAL: cannot seek to the beginning of file %q: %s", lf.file.Name(), err)
+	}
+	lf.fingerprint = 0
+	lf.offset = 0
+
+	lf.tailSize = 0
+	lf.setTail(nil)
+}
+
+// commit marks all the lines read so far as committed.
+//
+// It returns false if there is nothing to commit.
+func (lf *logFile) commit() bool {
+	if lf.commitInode == lf.inode && lf.commitOffset == lf.offset {
+		return false
+	}
+	if lf.fingerprint == 0 {
+		// Nothing has been read from the current file yet.
+		return false
+	}
+
+	lf.commitInode = lf.inode
+	lf.commitFingerprint = lf.fingerprint
+	lf.commitOffset = lf.offset
+	return true
+}
+
 func (lf *logFile) tryReopen() bool {
 	newFile, newInode, exists := openFileWithInode(lf.path)
 	if !exists {
@@ -1,4 +1,4 @@
-package kubernetescollector
+package filecollector
 
 import (
 	"fmt"
@@ -56,33 +56,33 @@ func TestReadLines(t *testing.T) {
 	f(in, expected, offset)
 
 	// Lines with maxLineSize
-	in = []string{strings.Repeat("a", maxLogLineSize)}
+	in = []string{strings.Repeat("a", MaxLogLineSize)}
 	expected = in
-	offset = maxLogLineSize + len("\n")
+	offset = MaxLogLineSize + len("\n")
 	f(in, expected, offset)
 
 	// Lines with maxLineSize in the middle
-	in = []string{"foo", strings.Repeat("b", maxLogLineSize), "bar"}
+	in = []string{"foo", strings.Repeat("b", MaxLogLineSize), "bar"}
 	expected = in
-	offset = len("foo\n") + maxLogLineSize + len("\n") + len("bar\n")
+	offset = len("foo\n") + MaxLogLineSize + len("\n") + len("bar\n")
 	f(in, expected, offset)
 
 	// Line exceeding maxLineSize
-	in = []string{"foo", strings.Repeat("b", maxLogLineSize+1), "bar"}
+	in = []string{"foo", strings.Repeat("b", MaxLogLineSize+1), "bar"}
 	expected = []string{"foo", "bar"}
-	offset = len("foo\n") + maxLogLineSize + 1 + len("\n") + len("bar\n")
+	offset = len("foo\n") + MaxLogLineSize + 1 + len("\n") + len("bar\n")
 	f(in, expected, offset)
 
 	// Multiple lines exceeding maxLineSize
-	in = []string{"foo", strings.Repeat("c", maxLogLineSize+10), strings.Repeat("d", maxLogLineSize+20), "bar"}
+	in = []string{"foo", strings.Repeat("c", MaxLogLineSize+10), strings.Repeat("d", MaxLogLineSize+20), "bar"}
 	expected = []string{"foo", "bar"}
-	offset = len("foo\n") + maxLogLineSize + 10 + len("\n") + maxLogLineSize + 20 + len("\n") + len("bar\n")
+	offset = len("foo\n") + MaxLogLineSize + 10 + len("\n") + MaxLogLineSize + 20 + len("\n") + len("bar\n")
 	f(in, expected, offset)
 
 	// Very long line
-	in = []string{strings.Repeat("e", maxLogLineSize*3), "end"}
+	in = []string{strings.Repeat("e", MaxLogLineSize*3), "end"}
 	expected = []string{"end"}
-	offset = maxLogLineSize*3 + len("\n") + len("end\n")
+	offset = MaxLogLineSize*3 + len("\n") + len("end\n")
 	f(in, expected, offset)
 }
 
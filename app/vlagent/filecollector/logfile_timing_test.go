@@ -1,4 +1,4 @@
-package kubernetescollector
+package filecollector
 
 import (
 	"path/filepath"
@@ -56,8 +56,8 @@ func benchmarkReadLines(b *testing.B, lineLen, count int) {
 
 type noopLogFileHandler struct{}
 
-func (noopLogFileHandler) tryAddLine(_ []byte) bool {
+func (noopLogFileHandler) TryAddLine(_ []byte) bool {
 	return true
 }
 
-func (noopLogFileHandler) mustClose() {}
+func (noopLogFileHandler) MustClose() {}
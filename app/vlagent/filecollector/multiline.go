@@ -0,0 +1,152 @@
+package filecollector
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// MultilineRules contains rules for merging multiple lines into a single log entry.
+type MultilineRules struct {
+	// startRe matches the first line of log entry.
+	startRe *regexp.Regexp
+
+	// continuationRe matches the non-first lines of log entry.
+	continuationRe *regexp.Regexp
+
+	// maxLines is the maximum number of lines in a single log entry.
+	maxLines int
+
+	// timeout is the duration after which the pending log entry is flushed if no new lines are added.
+	timeout time.Duration
+}
+
+// NewMultilineRules returns MultilineRules for the given args.
+//
+// At least one of startPattern and continuationPattern must be non-empty.
+func NewMultilineRules(startPattern, continuationPattern string, maxLines int, timeout time.Duration) (*MultilineRules, error) {
+	if startPattern == "" && continuationPattern == "" {
+		return nil, fmt.Errorf("missing start pattern or continuation pattern")
+	}
+	if maxLines <= 0 {
+		return nil, fmt.Errorf("max lines must be positive; got %d", maxLines)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive; got %s", timeout)
+	}
+
+	mr := &MultilineRules{
+		maxLines: maxLines,
+		timeout:  timeout,
+	}
+	if startPattern != "" {
+		re, err := regexp.Compile(startPattern)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse start pattern %q: %w", startPattern, err)
+		}
+		mr.startRe = re
+	}
+	if continuationPattern != "" {
+		re, err := regexp.Compile(continuationPattern)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse continuation pattern %q: %w", continuationPattern, err)
+		}
+		mr.continuationRe = re
+	}
+	return mr, nil
+}
+
+// isContinuation returns true if the line must be appended to the previous log entry.
+func (mr *MultilineRules) isContinuation(line []byte) bool {
+	if mr.startRe != nil && mr.startRe.Match(line) {
+		return false
+	}
+	if mr.continuationRe != nil {
+		return mr.continuationRe.Match(line)
+	}
+
+	// Only startRe is set and it doesn't match the line.
+	return true
+}
+
+// MultilineMerger merges multiple lines into log entries according to MultilineRules.
+type MultilineMerger struct {
+	rules *MultilineRules
+
+	// flushEntry is called for every merged log entry.
+	// It mustn't hold the entry after returning.
+	flushEntry func(entry []byte)
+
+	// pending contains the pending log entry.
+	pending []byte
+	// pendingLines is the number of lines in the pending log entry.
+	pendingLines int
+	// lastLineTime is the time when the last line was added to the pending log entry.
+	lastLineTime time.Time
+
+	// entryStart is set to true if the last added line started a new pending log entry.
+	entryStart bool
+}
+
+// NewMultilineMerger returns a new MultilineMerger, which calls flushEntry for every merged log entry.
+//
+// flushEntry mustn't hold the passed entry after returning.
+func NewMultilineMerger(rules *MultilineRules, flushEntry func(entry []byte)) *MultilineMerger {
+	return &MultilineMerger{
+		rules:      rules,
+		flushEntry: flushEntry,
+	}
+}
+
+// AddLine adds the line to the pending log entry or starts a new log entry with the line.
+//
+// The previous log entry is flushed if the line starts a new log entry.
+func (mm *MultilineMerger) AddLine(line []byte, now time.Time) {
+	mm.lastLineTime = now
+
+	if mm.pendingLines > 0 && mm.pendingLines < mm.rules.maxLines &&
+		len(mm.pending)+len("\n")+len(line) <= MaxLogLineSize && mm.rules.isContinuation(line) {
+		mm.pending = append(mm.pending, '\n')
+		mm.pending = append(mm.pending, line...)
+		mm.pendingLines++
+		mm.entryStart = false
+		return
+	}
+
+	mm.Flush()
+
+	mm.pending = append(mm.pending[:0], line...)
+	mm.pendingLines = 1
+	mm.entryStart = true
+}
+
+// IsEntryStart returns true if the line passed to the last AddLine call started a new log entry.
+func (mm *MultilineMerger) IsEntryStart() bool {
+	return mm.entryStart
+}
+
+// HasPending returns true if there is a pending log entry.
+func (mm *MultilineMerger) HasPending() bool {
+	return mm.pendingLines > 0
+}
+
+// FlushIfExpired flushes the pending log entry if no lines were added to it during the configured timeout.
+//
+// It returns true if there is no pending log entry after the call.
+func (mm *MultilineMerger) FlushIfExpired(now time.Time) bool {
+	if mm.pendingLines > 0 && now.Sub(mm.lastLineTime) >= mm.rules.timeout {
+		mm.Flush()
+	}
+	return mm.pendingLines == 0
+}
+
+// Flush flushes the pending log entry.
+func (mm *MultilineMerger) Flush() {
+	if mm.pendingLines == 0 {
+		return
+	}
+
+	mm.flushEntry(mm.pending)
+	mm.pending = mm.pending[:0]
+	mm.pendingLines = 0
+}
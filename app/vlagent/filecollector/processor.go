@@ -0,0 +1,149 @@
+package filecollector
+
+import (
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// logFileProcessor processes log lines from files matching the given input.
+type logFileProcessor struct {
+	storage insertutil.LogRowsStorage
+	lr      *logstorage.LogRows
+	in      *input
+
+	// commonFields are common fields for the given log file.
+	commonFields []logstorage.Field
+
+	// fieldsBuf is used for constructing log fields from commonFields and the actual log entry fields before sending them to VictoriaLogs.
+	fieldsBuf []logstorage.Field
+
+	// logfmtFields is used for parsing logfmt log entries.
+	logfmtFields []logstorage.Field
+
+	// mm merges multiple lines into a single log entry if multiline rules are configured for the input.
+	mm *MultilineMerger
+}
+
+// newLogFileProcessor returns a new logFileProcessor for the given storage.
+// commonFields must not be modified as they can be accessed from multiple goroutines.
+func newLogFileProcessor(storage insertutil.LogRowsStorage, in *input, commonFields []logstorage.Field) *logFileProcessor {
+	const defaultMsgValue = "missing _msg field; see https://docs.victoriametrics.com/victorialogs/keyconcepts/#message-field"
+	lr := logstorage.GetLogRows(in.streamFields, in.ignoreFields, in.decolorizeFields, in.extraFields, defaultMsgValue)
+
+	lfp := &logFileProcessor{
+		storage:      storage,
+		lr:           lr,
+		in:           in,
+		commonFields: commonFields,
+	}
+	if in.multiline != nil {
+		lfp.mm = NewMultilineMerger(in.multiline, lfp.addEntry)
+	}
+	return lfp
+}
+
+// TryAddLine implements Processor interface.
+func (lfp *logFileProcessor) TryAddLine(line []byte) bool {
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+
+	if lfp.mm == nil {
+		lfp.addEntry(line)
+		return true
+	}
+
+	if len(line) == 0 && !lfp.mm.HasPending() {
+		// Skip empty lines between log entries.
+		return true
+	}
+	lfp.mm.AddLine(line, time.Now())
+	return false
+}
+
+// IsEntryStart implements MultilineProcessor interface.
+func (lfp *logFileProcessor) IsEntryStart() bool {
+	return lfp.mm != nil && lfp.mm.IsEntryStart()
+}
+
+// FlushPending implements MultilineProcessor interface.
+func (lfp *logFileProcessor) FlushPending() bool {
+	if lfp.mm == nil {
+		return true
+	}
+	return lfp.mm.FlushIfExpired(time.Now())
+}
+
+// MustClose implements Processor interface.
+func (lfp *logFileProcessor) MustClose() {
+	if lfp.mm != nil {
+		lfp.mm.Flush()
+	}
+
+	logstorage.PutLogRows(lfp.lr)
+	lfp.lr = nil
+}
+
+func (lfp *logFileProcessor) addEntry(entry []byte) {
+	if len(entry) == 0 {
+		return
+	}
+
+	var fields []logstorage.Field
+	switch lfp.in.format {
+	case "json":
+		p := logstorage.GetJSONParser()
+		defer logstorage.PutJSONParser(p)
+
+		if err := p.ParseLogMessage(entry, nil); err != nil {
+			logger.Warnf("cannot parse JSON log entry from file %q: %s; entry contents: %q", lfp.getFilePath(), err, entry)
+			parseErrorsTotal.Inc()
+			return
+		}
+		fields = p.Fields
+	case "logfmt":
+		clear(lfp.logfmtFields)
+		lfp.logfmtFields = logstorage.ParseLogfmt(lfp.logfmtFields[:0], bytesutil.ToUnsafeString(entry))
+		fields = lfp.logfmtFields
+	default:
+		clear(lfp.logfmtFields)
+		lfp.logfmtFields = append(lfp.logfmtFields[:0], logstorage.Field{
+			Name:  "_msg",
+			Value: bytesutil.ToUnsafeString(entry),
+		})
+		fields = lfp.logfmtFields
+	}
+
+	timestamp, err := insertutil.ExtractTimestampFromFields(lfp.in.timeFields, fields)
+	if err != nil {
+		logger.Warnf("cannot parse timestamp in log entry from file %q: %s; entry contents: %q", lfp.getFilePath(), err, entry)
+		parseErrorsTotal.Inc()
+		return
+	}
+	logstorage.RenameField(fields, lfp.in.msgFields, "_msg")
+
+	clear(lfp.fieldsBuf)
+	lfp.fieldsBuf = append(lfp.fieldsBuf[:0], lfp.commonFields...)
+	lfp.fieldsBuf = append(lfp.fieldsBuf, fields...)
+
+	lfp.lr.MustAdd(lfp.in.tenantID, timestamp, lfp.fieldsBuf, -1)
+	lfp.storage.MustAddRows(lfp.lr)
+	lfp.lr.ResetKeepSettings()
+}
+
+func (lfp *logFileProcessor) getFilePath() string {
+	for _, f := range lfp.commonFields {
+		if f.Name == "log.file.path" {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+var parseErrorsTotal = metrics.NewCounter(`vlagent_file_collector_parse_errors_total`)
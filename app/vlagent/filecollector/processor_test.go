@@ -0,0 +1,213 @@
+package filecollector
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestProcessor(t *testing.T) {
+	f := func(config string, lines []string, rowsExpected []string) {
+		t.Helper()
+
+		inputs, err := parseInputs([]byte(config))
+		if err != nil {
+			t.Fatalf("cannot parse config: %s", err)
+		}
+
+		storage := newTestStorage()
+		lfp := newLogFileProcessor(storage, inputs[0], nil)
+		for _, line := range lines {
+			lfp.TryAddLine([]byte(line))
+		}
+		lfp.MustClose()
+
+		if err := storage.verify(rowsExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// plain format
+	f(`
+- paths: [/var/log/*.log]
+`, []string{"foo", "", "bar baz\r"}, []string{
+		`{"_msg":"foo"}`,
+		`{"_msg":"bar baz"}`,
+	})
+
+	// json format
+	f(`
+- paths: [/var/log/*.log]
+  format: json
+  msg_fields: [message]
+  ignore_fields: [password]
+  extra_fields:
+    env: prod
+`, []string{
+		`{"time":"2025-10-16T15:37:36Z","message":"foo","level":"info","password":"secret"}`,
+		`invalid json`,
+		`{"_msg":"bar"}`,
+	}, []string{
+		`2025-10-16T15:37:36Z {"_msg":"foo","level":"info","env":"prod"}`,
+		`{"_msg":"bar","env":"prod"}`,
+	})
+
+	// logfmt format
+	f(`
+- paths: [/var/log/*.log]
+  format: logfmt
+  time_fields: [ts]
+`, []string{
+		`ts=2025-10-16T15:37:36Z level=error msg="cannot open file" path=/foo/bar`,
+	}, []string{
+		`2025-10-16T15:37:36Z {"level":"error","_msg":"cannot open file","path":"/foo/bar"}`,
+	})
+
+	// multiline with start_pattern
+	f(`
+- paths: [/var/log/*.log]
+  multiline:
+    start_pattern: '^\d{4}-'
+`, []string{
+		"not matching first line",
+		"2025-10-16 ERROR foo",
+		"  at bar",
+		"  at baz",
+		"2025-10-16 INFO qwe",
+	}, []string{
+		`{"_msg":"not matching first line"}`,
+		`{"_msg":"2025-10-16 ERROR foo\n  at bar\n  at baz"}`,
+		`{"_msg":"2025-10-16 INFO qwe"}`,
+	})
+
+	// multiline with continuation_pattern
+	f(`
+- paths: [/var/log/*.log]
+  multiline:
+    continuation_pattern: '^\s'
+`, []string{
+		"foo",
+		"\tbar",
+		"",
+		"baz",
+		" qwe",
+	}, []string{
+		`{"_msg":"foo\n\tbar"}`,
+		`{"_msg":"baz\n qwe"}`,
+	})
+
+	// multiline with max_lines
+	f(`
+- paths: [/var/log/*.log]
+  multiline:
+    continuation_pattern: '^\s'
+    max_lines: 2
+`, []string{
+		"foo",
+		" a",
+		" b",
+		" c",
+	}, []string{
+		`{"_msg":"foo\n a"}`,
+		`{"_msg":" b\n c"}`,
+	})
+
+	// multiline json
+	f(`
+- paths: [/var/log/*.log]
+  format: json
+  multiline:
+    start_pattern: '^\{'
+`, []string{
+		`{"_msg":"foo",`,
+		`  "level":"info"}`,
+	}, []string{
+		`{"_msg":"foo","level":"info"}`,
+	})
+}
+
+func TestProcessorMultilineFlushPending(t *testing.T) {
+	inputs, err := parseInputs([]byte(`
+- paths: [/var/log/*.log]
+  multiline:
+    continuation_pattern: '^\s'
+    timeout: 1ms
+`))
+	if err != nil {
+		t.Fatalf("cannot parse config: %s", err)
+	}
+
+	storage := newTestStorage()
+	lfp := newLogFileProcessor(storage, inputs[0], nil)
+	defer lfp.MustClose()
+
+	if lfp.TryAddLine([]byte("foo")) {
+		t.Fatalf("the first line of multiline entry mustn't be committed")
+	}
+	if !lfp.IsEntryStart() {
+		t.Fatalf("the first line must start a new entry")
+	}
+	if lfp.TryAddLine([]byte(" bar")) {
+		t.Fatalf("the continuation line mustn't be committed")
+	}
+	if lfp.IsEntryStart() {
+		t.Fatalf("the continuation line mustn't start a new entry")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if !lfp.FlushPending() {
+		t.Fatalf("the pending entry must be flushed after the timeout")
+	}
+	if err := storage.verify([]string{`{"_msg":"foo\n bar"}`}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testStorage struct {
+	mu      sync.Mutex
+	logRows []string
+}
+
+func newTestStorage() *testStorage {
+	return &testStorage{}
+}
+
+// MustAddRows implements insertutil.LogRowsStorage interface
+func (s *testStorage) MustAddRows(lr *logstorage.LogRows) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lr.ForEachRow(func(_ uint64, r *logstorage.InsertRow) {
+		row := string(logstorage.MarshalFieldsToJSON(nil, r.Fields))
+		if r.Timestamp < time.Now().Add(-time.Hour).UnixNano() {
+			// The timestamp is read from the log entry, while entries without timestamps get the current time.
+			row = time.Unix(0, r.Timestamp).UTC().Format(time.RFC3339Nano) + " " + row
+		}
+		s.logRows = append(s.logRows, row)
+	})
+}
+
+// CanWriteData implements insertutil.LogRowsStorage interface
+func (s *testStorage) CanWriteData() error {
+	return nil
+}
+
+func (s *testStorage) getRows() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.logRows...)
+}
+
+func (s *testStorage) verify(rowsExpected []string) error {
+	got := strings.Join(s.getRows(), "\n")
+	want := strings.Join(rowsExpected, "\n")
+	if got != want {
+		return fmt.Errorf("unexpected rows\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	return nil
+}
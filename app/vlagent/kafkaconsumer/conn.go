@@ -0,0 +1,97 @@
+package kafkaconsumer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
+)
+
+// maxResponseSize is the maximum size of a single response from Kafka broker.
+const maxResponseSize = 256 * 1024 * 1024
+
+// brokerConn is a connection to a single Kafka broker.
+//
+// It isn't safe to use brokerConn from concurrently running goroutines.
+type brokerConn struct {
+	addr     string
+	clientID string
+	timeout  time.Duration
+
+	c             net.Conn
+	correlationID int32
+
+	buf []byte
+}
+
+func dialBroker(addr, clientID string, timeout time.Duration) (*brokerConn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to Kafka broker %q: %w", addr, err)
+	}
+	bc := &brokerConn{
+		addr:     addr,
+		clientID: clientID,
+		timeout:  timeout,
+		c:        c,
+	}
+	return bc, nil
+}
+
+func (bc *brokerConn) close() {
+	_ = bc.c.Close()
+}
+
+// roundTrip sends the request with the given apiKey, apiVersion and body to the broker and returns the response body.
+//
+// The returned response body is valid until the next roundTrip call.
+// extraTimeout is added to bc.timeout for requests, which may be delayed at the broker side such as Fetch or JoinGroup.
+func (bc *brokerConn) roundTrip(apiKey, apiVersion int16, body []byte, extraTimeout time.Duration) ([]byte, error) {
+	bc.correlationID++
+	correlationID := bc.correlationID
+
+	// Request header v1.
+	// See https://kafka.apache.org/protocol.html#protocol_messages
+	e := encoder{
+		b: bc.buf[:0],
+	}
+	e.int32(0) // placeholder for the message size
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(correlationID)
+	e.string(bc.clientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+	bc.buf = e.b
+
+	deadline := time.Now().Add(bc.timeout + extraTimeout)
+	if err := bc.c.SetDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("cannot set deadline for connection to Kafka broker %q: %w", bc.addr, err)
+	}
+	if _, err := bc.c.Write(e.b); err != nil {
+		return nil, fmt.Errorf("cannot send request with apiKey=%d to Kafka broker %q: %w", apiKey, bc.addr, err)
+	}
+
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(bc.c, sizeBuf[:]); err != nil {
+		return nil, fmt.Errorf("cannot read response size for request with apiKey=%d from Kafka broker %q: %w", apiKey, bc.addr, err)
+	}
+	size := binary.BigEndian.Uint32(sizeBuf[:])
+	if size < 4 || size > maxResponseSize {
+		return nil, fmt.Errorf("unexpected response size for request with apiKey=%d from Kafka broker %q: %d bytes", apiKey, bc.addr, size)
+	}
+	bc.buf = slicesutil.SetLength(bc.buf, int(size))
+	if _, err := io.ReadFull(bc.c, bc.buf); err != nil {
+		return nil, fmt.Errorf("cannot read response for request with apiKey=%d from Kafka broker %q: %w", apiKey, bc.addr, err)
+	}
+
+	// Response header v0.
+	respCorrelationID := int32(binary.BigEndian.Uint32(bc.buf))
+	if respCorrelationID != correlationID {
+		return nil, fmt.Errorf("unexpected correlation id in the response from Kafka broker %q; got %d; want %d", bc.addr, respCorrelationID, correlationID)
+	}
+	return bc.buf[4:], nil
+}
@@ -0,0 +1,672 @@
+package kafkaconsumer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// consumerConfig contains configuration for the Kafka consumer.
+type consumerConfig struct {
+	brokers  []string
+	topics   []string
+	groupID  string
+	clientID string
+
+	// initialOffset is the offset to start consuming partitions without committed offsets from.
+	//
+	// It must be either listOffsetsEarliest or listOffsetsLatest.
+	initialOffset int64
+
+	dialTimeout       time.Duration
+	sessionTimeout    time.Duration
+	rebalanceTimeout  time.Duration
+	heartbeatInterval time.Duration
+	commitInterval    time.Duration
+
+	fetchMaxWait           time.Duration
+	fetchMaxBytes          int32
+	fetchMaxPartitionBytes int32
+}
+
+// recordsHandler processes records fetched by the consumer.
+type recordsHandler interface {
+	// processRecord must process the record r from the given topic.
+	//
+	// processRecord mustn't hold references to r after returning.
+	processRecord(topic string, r *record)
+
+	// flush must durably store all the records passed to processRecord.
+	//
+	// It is called before committing the offsets for the processed records.
+	// The offsets aren't committed if flush returns an error.
+	flush() error
+}
+
+// consumer consumes Kafka topics as a member of consumer group.
+type consumer struct {
+	cfg *consumerConfig
+	rh  recordsHandler
+
+	// memberID is the member id assigned by the group coordinator.
+	//
+	// It is preserved between sessions in order to speed up re-joining the group.
+	memberID string
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	lagsLock sync.Mutex
+	lags     map[topicPartition]*atomic.Int64
+}
+
+// errStopped is returned when the consumer is stopped.
+var errStopped = errors.New("the consumer is stopped")
+
+// startConsumer starts consuming topics according to cfg and passes the fetched records to rh.
+//
+// stop() must be called when the consumer is no longer needed.
+func startConsumer(cfg *consumerConfig, rh recordsHandler) *consumer {
+	c := &consumer{
+		cfg:    cfg,
+		rh:     rh,
+		stopCh: make(chan struct{}),
+		lags:   make(map[topicPartition]*atomic.Int64),
+	}
+	c.wg.Go(c.run)
+	return c
+}
+
+// stop stops the consumer.
+//
+// It commits offsets for the processed records and leaves the consumer group before returning.
+func (c *consumer) stop() {
+	close(c.stopCh)
+	c.wg.Wait()
+}
+
+func (c *consumer) isStopped() bool {
+	select {
+	case <-c.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep sleeps for the given duration d. It returns false if the consumer is stopped during the sleep.
+func (c *consumer) sleep(d time.Duration) bool {
+	t := timerpool.Get(d)
+	defer timerpool.Put(t)
+
+	select {
+	case <-c.stopCh:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (c *consumer) run() {
+	const minBackoff = 200 * time.Millisecond
+	const maxBackoff = 30 * time.Second
+
+	backoff := minBackoff
+	for {
+		startTime := time.Now()
+		err := c.runSession()
+		if errors.Is(err, errStopped) {
+			return
+		}
+		errorsTotal.Inc()
+		if time.Since(startTime) > maxBackoff {
+			backoff = minBackoff
+		}
+		logger.Errorf("error when consuming Kafka topics %q in group %q: %s; retrying in %.3f seconds", c.cfg.topics, c.cfg.groupID, err, backoff.Seconds())
+		if !c.sleep(backoff) {
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session is a single session of the consumer in the consumer group.
+//
+// New session is started after unrecoverable errors such as broker connection errors or partition leader changes.
+type session struct {
+	c   *consumer
+	cfg *consumerConfig
+
+	// conns contains connections to brokers keyed by broker address.
+	conns map[string]*brokerConn
+
+	bootstrap   *brokerConn
+	coordinator *brokerConn
+
+	generationID int32
+
+	// assignment contains partitions assigned to the consumer in the current generation.
+	assignment []topicPartition
+
+	// leaders maps assigned partitions to leader broker addresses.
+	leaders map[topicPartition]string
+
+	// offsets contains offsets for the next records to fetch for the assigned partitions.
+	offsets map[topicPartition]int64
+
+	// committedOffsets contains the last committed offsets for the assigned partitions.
+	committedOffsets map[topicPartition]int64
+
+	rd recordsDecoder
+}
+
+func (c *consumer) runSession() error {
+	s := &session{
+		c:     c,
+		cfg:   c.cfg,
+		conns: make(map[string]*brokerConn),
+	}
+	defer s.closeConns()
+
+	err := s.run()
+	if err != nil && !errors.Is(err, errStopped) && s.generationID > 0 {
+		// Try committing offsets for the already processed records in order to reduce the number of duplicate records after re-joining the group.
+		if errCommit := s.commitOffsets(); errCommit != nil {
+			logger.Warnf("cannot commit offsets for Kafka group %q: %s", c.cfg.groupID, errCommit)
+		}
+	}
+	return err
+}
+
+func (s *session) run() error {
+	if err := s.connectBootstrap(); err != nil {
+		return err
+	}
+	if err := s.connectCoordinator(); err != nil {
+		return err
+	}
+
+	for {
+		if err := s.joinGroup(); err != nil {
+			return err
+		}
+		if err := s.initOffsets(); err != nil {
+			return err
+		}
+		logger.Infof("consuming %d partitions of Kafka topics %q in group %q; generation=%d, memberID=%q",
+			len(s.assignment), s.cfg.topics, s.cfg.groupID, s.generationID, s.c.memberID)
+
+		err := s.consume()
+		if !errors.Is(err, errRejoin) {
+			return err
+		}
+		logger.Infof("re-joining Kafka consumer group %q because of group rebalance", s.cfg.groupID)
+	}
+}
+
+// errRejoin is returned from session.consume when the consumer must re-join the group.
+var errRejoin = errors.New("the consumer must re-join the group")
+
+func (s *session) getConn(addr string) (*brokerConn, error) {
+	if bc := s.conns[addr]; bc != nil {
+		return bc, nil
+	}
+	bc, err := dialBroker(addr, s.cfg.clientID, s.cfg.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s.conns[addr] = bc
+	return bc, nil
+}
+
+func (s *session) closeConns() {
+	for _, bc := range s.conns {
+		bc.close()
+	}
+	s.conns = nil
+}
+
+func (s *session) connectBootstrap() error {
+	var errs []error
+	for _, addr := range s.cfg.brokers {
+		bc, err := s.getConn(addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.bootstrap = bc
+		return nil
+	}
+	return fmt.Errorf("cannot connect to any of Kafka brokers %q: %w", s.cfg.brokers, errors.Join(errs...))
+}
+
+func (s *session) connectCoordinator() error {
+	addr, err := s.bootstrap.findCoordinator(s.cfg.groupID)
+	if err != nil {
+		return err
+	}
+	bc, err := s.getConn(addr)
+	if err != nil {
+		return err
+	}
+	s.coordinator = bc
+	return nil
+}
+
+// getPartitionsCount returns the number of partitions for the given topics.
+func (s *session) getPartitionsCount(topics []string) (map[string]int32, error) {
+	mr, err := s.bootstrap.metadata(topics)
+	if err != nil {
+		return nil, err
+	}
+
+	partitionsCount := make(map[string]int32, len(mr.topics))
+	for _, tm := range mr.topics {
+		if tm.errCode != errCodeNone {
+			return nil, fmt.Errorf("cannot obtain metadata for Kafka topic %q: %w", tm.name, kafkaError(tm.errCode))
+		}
+		partitionsCount[tm.name] = int32(len(tm.partitions))
+	}
+	return partitionsCount, nil
+}
+
+// updateLeaders updates leaders for the assigned partitions.
+func (s *session) updateLeaders() error {
+	topics, _ := groupTopicPartitions(s.assignment)
+	mr, err := s.bootstrap.metadata(topics)
+	if err != nil {
+		return err
+	}
+
+	leaders := make(map[topicPartition]string, len(s.assignment))
+	for _, tm := range mr.topics {
+		for _, pm := range tm.partitions {
+			tp := topicPartition{
+				topic:     tm.name,
+				partition: pm.partition,
+			}
+			if pm.errCode != errCodeNone {
+				leaders[tp] = ""
+				continue
+			}
+			leaders[tp] = mr.brokers[pm.leader]
+		}
+	}
+	s.leaders = make(map[topicPartition]string, len(s.assignment))
+	for _, tp := range s.assignment {
+		addr := leaders[tp]
+		if addr == "" {
+			return fmt.Errorf("cannot find leader for Kafka partition %s", tp)
+		}
+		s.leaders[tp] = addr
+	}
+	return nil
+}
+
+func (s *session) joinGroup() error {
+	cfg := s.cfg
+	metadata := marshalMemberMetadata(cfg.topics)
+	for {
+		if s.c.isStopped() {
+			return errStopped
+		}
+
+		jgr, err := s.coordinator.joinGroup(cfg.groupID, s.c.memberID, cfg.sessionTimeout, cfg.rebalanceTimeout, rangeAssignor, metadata)
+		if err != nil {
+			return err
+		}
+		switch jgr.errCode {
+		case errCodeNone:
+		case errCodeUnknownMemberID:
+			// The member id is expired. Re-join the group with empty member id.
+			s.c.memberID = ""
+			continue
+		case errCodeRebalanceInProgress:
+			continue
+		default:
+			return fmt.Errorf("cannot join Kafka consumer group %q: %w", cfg.groupID, kafkaError(jgr.errCode))
+		}
+		if jgr.protocol != rangeAssignor {
+			return fmt.Errorf("unexpected partition assignment protocol selected by the Kafka group coordinator: %q; want %q", jgr.protocol, rangeAssignor)
+		}
+		s.c.memberID = jgr.memberID
+		s.generationID = jgr.generationID
+
+		var assignments map[string][]byte
+		if jgr.leaderID == jgr.memberID {
+			assignments, err = s.assignPartitions(jgr.members)
+			if err != nil {
+				return err
+			}
+		}
+
+		assignment, errCode, err := s.coordinator.syncGroup(cfg.groupID, s.generationID, s.c.memberID, assignments, cfg.rebalanceTimeout)
+		if err != nil {
+			return err
+		}
+		if errCode == errCodeRebalanceInProgress {
+			continue
+		}
+		if errCode != errCodeNone {
+			if isRejoinGroupError(errCode) {
+				if errCode == errCodeUnknownMemberID {
+					s.c.memberID = ""
+				}
+				continue
+			}
+			return fmt.Errorf("cannot sync Kafka consumer group %q: %w", cfg.groupID, kafkaError(errCode))
+		}
+
+		tps, err := unmarshalMemberAssignment(assignment)
+		if err != nil {
+			return err
+		}
+		s.assignment = tps
+		return s.updateLeaders()
+	}
+}
+
+// assignPartitions assigns partitions to group members. It is called only at the group leader.
+func (s *session) assignPartitions(members []joinGroupMember) (map[string][]byte, error) {
+	subscriptions := make(map[string][]string, len(members))
+	var allTopics []string
+	for _, m := range members {
+		topics, err := unmarshalMemberMetadata(m.metadata)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse metadata for member %q: %w", m.memberID, err)
+		}
+		subscriptions[m.memberID] = topics
+		allTopics = append(allTopics, topics...)
+	}
+	sort.Strings(allTopics)
+	allTopics = compactStrings(allTopics)
+
+	partitionsCount, err := s.getPartitionsCount(allTopics)
+	if err != nil {
+		return nil, err
+	}
+
+	tpsByMember := assignRange(subscriptions, partitionsCount)
+	assignments := make(map[string][]byte, len(tpsByMember))
+	for memberID, tps := range tpsByMember {
+		assignments[memberID] = marshalMemberAssignment(tps)
+	}
+	return assignments, nil
+}
+
+func compactStrings(a []string) []string {
+	result := a[:0]
+	for i, s := range a {
+		if i > 0 && s == a[i-1] {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// initOffsets initializes offsets for the assigned partitions from the committed offsets.
+//
+// Offsets for partitions without committed offsets are initialized according to cfg.initialOffset.
+func (s *session) initOffsets() error {
+	s.offsets = make(map[topicPartition]int64, len(s.assignment))
+	s.committedOffsets = make(map[topicPartition]int64, len(s.assignment))
+	if len(s.assignment) == 0 {
+		return nil
+	}
+
+	committed, err := s.coordinator.offsetFetch(s.cfg.groupID, s.assignment)
+	if err != nil {
+		return err
+	}
+	var missing []topicPartition
+	for _, tp := range s.assignment {
+		offset, ok := committed[tp]
+		if !ok || offset < 0 {
+			missing = append(missing, tp)
+			continue
+		}
+		s.offsets[tp] = offset
+		s.committedOffsets[tp] = offset
+	}
+	return s.resetOffsets(missing)
+}
+
+// resetOffsets sets offsets for tps according to cfg.initialOffset.
+func (s *session) resetOffsets(tps []topicPartition) error {
+	byLeader := make(map[string][]topicPartition)
+	for _, tp := range tps {
+		addr := s.leaders[tp]
+		byLeader[addr] = append(byLeader[addr], tp)
+	}
+	for addr, tps := range byLeader {
+		bc, err := s.getConn(addr)
+		if err != nil {
+			return err
+		}
+		offsets, err := bc.listOffsets(tps, s.cfg.initialOffset)
+		if err != nil {
+			return err
+		}
+		for _, tp := range tps {
+			offset, ok := offsets[tp]
+			if !ok {
+				return fmt.Errorf("missing offset for Kafka partition %s in ListOffsets response", tp)
+			}
+			s.offsets[tp] = offset
+		}
+	}
+	return nil
+}
+
+// consume fetches records for the assigned partitions until the consumer is stopped or an error occurs.
+//
+// errRejoin is returned if the consumer must re-join the group.
+func (s *session) consume() error {
+	cfg := s.cfg
+
+	lastHeartbeat := time.Now()
+	lastCommit := time.Now()
+
+	byLeader := make(map[string][]topicPartition)
+	for _, tp := range s.assignment {
+		addr := s.leaders[tp]
+		byLeader[addr] = append(byLeader[addr], tp)
+	}
+
+	for {
+		if s.c.isStopped() {
+			return s.shutdown()
+		}
+
+		if time.Since(lastCommit) >= cfg.commitInterval {
+			if err := s.commitOffsets(); err != nil {
+				return err
+			}
+			lastCommit = time.Now()
+		}
+
+		if time.Since(lastHeartbeat) >= cfg.heartbeatInterval {
+			errCode, err := s.coordinator.heartbeat(cfg.groupID, s.generationID, s.c.memberID)
+			if err != nil {
+				return err
+			}
+			if errCode != errCodeNone {
+				if isRejoinGroupError(errCode) {
+					if errCode == errCodeUnknownMemberID {
+						s.c.memberID = ""
+					}
+					// Commit offsets for the processed records before the partitions are re-assigned to other group members.
+					if err := s.commitOffsets(); err != nil {
+						logger.Warnf("cannot commit offsets before re-joining Kafka consumer group %q: %s", cfg.groupID, err)
+					}
+					return errRejoin
+				}
+				if isCoordinatorError(errCode) {
+					// Re-create the session in order to re-discover the group coordinator.
+					return fmt.Errorf("the group coordinator for Kafka consumer group %q is unavailable: %w", cfg.groupID, kafkaError(errCode))
+				}
+				return fmt.Errorf("cannot send heartbeat to Kafka consumer group %q: %w", cfg.groupID, kafkaError(errCode))
+			}
+			lastHeartbeat = time.Now()
+		}
+
+		if len(byLeader) == 0 {
+			// Nothing to fetch. Just wait for the next heartbeat.
+			if !s.c.sleep(cfg.heartbeatInterval) {
+				return s.shutdown()
+			}
+			continue
+		}
+
+		for addr, tps := range byLeader {
+			if err := s.fetch(addr, tps); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *session) fetch(addr string, tps []topicPartition) error {
+	cfg := s.cfg
+
+	bc, err := s.getConn(addr)
+	if err != nil {
+		return err
+	}
+	fprs, err := bc.fetch(tps, s.offsets, cfg.fetchMaxWait, cfg.fetchMaxBytes, cfg.fetchMaxPartitionBytes)
+	if err != nil {
+		return err
+	}
+
+	var outOfRange []topicPartition
+	for i := range fprs {
+		fpr := &fprs[i]
+		tp := fpr.tp
+		offset, ok := s.offsets[tp]
+		if !ok {
+			// The partition isn't assigned to the consumer
+			continue
+		}
+
+		switch fpr.errCode {
+		case errCodeNone:
+		case errCodeOffsetOutOfRange:
+			logger.Warnf("offset %d is out of range for Kafka partition %s; resetting it according to -kafkaConsumer.initialOffset", offset, tp)
+			outOfRange = append(outOfRange, tp)
+			continue
+		default:
+			// Partition leader may be changed. Re-create the session in order to refresh the metadata.
+			return fmt.Errorf("cannot fetch records from Kafka partition %s at broker %q: %w", tp, addr, kafkaError(fpr.errCode))
+		}
+
+		topic := tp.topic
+		recordsCount := 0
+		nextOffset, err := s.rd.decodeRecordBatches(fpr.records, offset, func(r *record) {
+			recordsCount++
+			s.c.rh.processRecord(topic, r)
+		})
+		if err != nil {
+			decodeErrorsTotal.Inc()
+			return fmt.Errorf("cannot decode records from Kafka partition %s: %w", tp, err)
+		}
+		s.offsets[tp] = nextOffset
+		recordsConsumedTotal.Add(recordsCount)
+		s.c.updateLag(tp, fpr.highWatermark-nextOffset)
+	}
+
+	if len(outOfRange) > 0 {
+		return s.resetOffsets(outOfRange)
+	}
+	return nil
+}
+
+// commitOffsets flushes the processed records and then commits offsets for them.
+func (s *session) commitOffsets() error {
+	offsets := make(map[topicPartition]int64)
+	for tp, offset := range s.offsets {
+		if committedOffset, ok := s.committedOffsets[tp]; ok && committedOffset == offset {
+			continue
+		}
+		offsets[tp] = offset
+	}
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	// Make sure the processed records are durably stored before committing offsets for them.
+	if err := s.c.rh.flush(); err != nil {
+		// Do not commit offsets for the records, which may be lost on crash.
+		// They are committed on the next call to commitOffsets after the records are stored.
+		commitsDelayedTotal.Inc()
+		commitDelayLogger.Warnf("delaying offsets commit for Kafka consumer group %q: %s", s.cfg.groupID, err)
+		return nil
+	}
+
+	errCode, err := s.coordinator.offsetCommit(s.cfg.groupID, s.generationID, s.c.memberID, offsets)
+	if err != nil {
+		return err
+	}
+	if errCode != errCodeNone {
+		commitErrorsTotal.Inc()
+		if isRejoinGroupError(errCode) {
+			return errRejoin
+		}
+		return fmt.Errorf("cannot commit offsets for Kafka consumer group %q: %w", s.cfg.groupID, kafkaError(errCode))
+	}
+	for tp, offset := range offsets {
+		s.committedOffsets[tp] = offset
+	}
+	commitsTotal.Inc()
+	return nil
+}
+
+// shutdown commits offsets for the processed records and leaves the consumer group.
+func (s *session) shutdown() error {
+	if err := s.commitOffsets(); err != nil {
+		logger.Errorf("cannot commit offsets for Kafka consumer group %q on shutdown: %s", s.cfg.groupID, err)
+	}
+	if err := s.coordinator.leaveGroup(s.cfg.groupID, s.c.memberID); err != nil {
+		logger.Warnf("cannot leave Kafka consumer group %q: %s", s.cfg.groupID, err)
+	}
+	s.c.memberID = ""
+	return errStopped
+}
+
+func (c *consumer) updateLag(tp topicPartition, lag int64) {
+	c.lagsLock.Lock()
+	v := c.lags[tp]
+	if v == nil {
+		v = &atomic.Int64{}
+		c.lags[tp] = v
+		name := fmt.Sprintf(`vlagent_kafka_consumer_lag{topic=%q,partition="%s"}`, tp.topic, strconv.Itoa(int(tp.partition)))
+		_ = metrics.GetOrCreateGauge(name, func() float64 {
+			return float64(v.Load())
+		})
+	}
+	c.lagsLock.Unlock()
+
+	v.Store(max(lag, 0))
+}
+
+var (
+	recordsConsumedTotal = metrics.NewCounter(`vlagent_kafka_records_consumed_total`)
+	commitsTotal         = metrics.NewCounter(`vlagent_kafka_offset_commits_total`)
+	commitErrorsTotal    = metrics.NewCounter(`vlagent_kafka_offset_commit_errors_total`)
+	commitsDelayedTotal  = metrics.NewCounter(`vlagent_kafka_offset_commits_delayed_total`)
+	decodeErrorsTotal    = metrics.NewCounter(`vlagent_kafka_decode_errors_total`)
+	errorsTotal          = metrics.NewCounter(`vlagent_kafka_errors_total`)
+)
+
+var commitDelayLogger = logger.WithThrottler("kafkaCommitDelay", 5*time.Second)
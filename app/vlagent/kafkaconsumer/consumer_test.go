@@ -0,0 +1,184 @@
+package kafkaconsumer
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testRecordsHandler struct {
+	mu sync.Mutex
+
+	// values contains values for all the processed records
+	values []string
+
+	// pendingRecords is the number of records processed since the last flush.
+	pendingRecords int
+}
+
+func (rh *testRecordsHandler) processRecord(topic string, r *record) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	rh.values = append(rh.values, fmt.Sprintf("%s:%s", topic, r.value))
+	rh.pendingRecords++
+}
+
+func (rh *testRecordsHandler) flush() error {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	rh.pendingRecords = 0
+	return nil
+}
+
+func (rh *testRecordsHandler) getValues() []string {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	values := append([]string{}, rh.values...)
+	slices.Sort(values)
+	return values
+}
+
+func (rh *testRecordsHandler) waitForValues(t *testing.T, valuesExpected []string) {
+	t.Helper()
+
+	slices.Sort(valuesExpected)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		values := rh.getValues()
+		if reflect.DeepEqual(values, valuesExpected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected values consumed;\ngot\n%q\nwant\n%q", values, valuesExpected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForCommittedOffsets(t *testing.T, fb *fakeBroker, groupID string, offsetsExpected map[topicPartition]int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		offsets := fb.getCommittedOffsets(groupID)
+		if reflect.DeepEqual(offsets, offsetsExpected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected committed offsets;\ngot\n%v\nwant\n%v", offsets, offsetsExpected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestConsumerConfig(addr, groupID string, topics []string) *consumerConfig {
+	return &consumerConfig{
+		brokers:       []string{addr},
+		topics:        topics,
+		groupID:       groupID,
+		clientID:      "test",
+		initialOffset: listOffsetsEarliest,
+
+		dialTimeout:       time.Second,
+		sessionTimeout:    time.Second,
+		rebalanceTimeout:  time.Second,
+		heartbeatInterval: 50 * time.Millisecond,
+		commitInterval:    50 * time.Millisecond,
+
+		fetchMaxWait:           50 * time.Millisecond,
+		fetchMaxBytes:          1024 * 1024,
+		fetchMaxPartitionBytes: 1024 * 1024,
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	fb := newFakeBroker(t, map[string]int{
+		"logs":  2,
+		"other": 1,
+	})
+	defer fb.stop()
+
+	fb.produce("logs", 0, 1000, "a", "b")
+	fb.produce("logs", 1, 1000, "c")
+	fb.produce("other", 0, 1000, "d")
+
+	cfg := newTestConsumerConfig(fb.addr(), "group1", []string{"logs"})
+	rh := &testRecordsHandler{}
+	c := startConsumer(cfg, rh)
+	rh.waitForValues(t, []string{"logs:a", "logs:b", "logs:c"})
+
+	// Records produced after the start must be consumed
+	fb.produce("logs", 1, 2000, "e", "f")
+	rh.waitForValues(t, []string{"logs:a", "logs:b", "logs:c", "logs:e", "logs:f"})
+
+	c.stop()
+
+	if rh.pendingRecords != 0 {
+		t.Fatalf("unexpected number of unflushed records after the consumer stop; got %d; want 0", rh.pendingRecords)
+	}
+	waitForCommittedOffsets(t, fb, "group1", map[topicPartition]int64{
+		{topic: "logs", partition: 0}: 2,
+		{topic: "logs", partition: 1}: 3,
+	})
+
+	// The restarted consumer must resume from the committed offsets
+	fb.produce("logs", 0, 3000, "g")
+	rh = &testRecordsHandler{}
+	c = startConsumer(cfg, rh)
+	rh.waitForValues(t, []string{"logs:g"})
+	c.stop()
+
+	waitForCommittedOffsets(t, fb, "group1", map[topicPartition]int64{
+		{topic: "logs", partition: 0}: 3,
+		{topic: "logs", partition: 1}: 3,
+	})
+}
+
+func TestConsumerInitialOffsetNewest(t *testing.T) {
+	fb := newFakeBroker(t, map[string]int{
+		"logs": 1,
+	})
+	defer fb.stop()
+
+	fb.produce("logs", 0, 1000, "a", "b")
+
+	cfg := newTestConsumerConfig(fb.addr(), "group2", []string{"logs"})
+	cfg.initialOffset = listOffsetsLatest
+	rh := &testRecordsHandler{}
+	c := startConsumer(cfg, rh)
+	defer c.stop()
+
+	// Wait until the consumer commits the initial offset
+	waitForCommittedOffsets(t, fb, "group2", map[topicPartition]int64{
+		{topic: "logs", partition: 0}: 2,
+	})
+
+	fb.produce("logs", 0, 2000, "c")
+	rh.waitForValues(t, []string{"logs:c"})
+}
+
+func TestConsumerUnavailableBroker(t *testing.T) {
+	fb := newFakeBroker(t, map[string]int{
+		"logs": 1,
+	})
+	addr := fb.addr()
+	fb.stop()
+
+	cfg := newTestConsumerConfig(addr, "group3", []string{"logs"})
+	rh := &testRecordsHandler{}
+	c := startConsumer(cfg, rh)
+
+	// The consumer must be stopped without delays while it retries connecting to the unavailable broker.
+	time.Sleep(50 * time.Millisecond)
+	c.stop()
+
+	if values := rh.getValues(); len(values) > 0 {
+		t.Fatalf("unexpected values consumed: %q", values)
+	}
+}
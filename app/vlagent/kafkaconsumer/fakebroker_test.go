@@ -0,0 +1,577 @@
+package kafkaconsumer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRecord is a record stored at fakeBroker.
+type fakeRecord struct {
+	timestamp int64
+	value     string
+}
+
+// fakeBroker is an in-process single-node Kafka broker, which supports the subset of Kafka protocol used by the consumer.
+type fakeBroker struct {
+	t  *testing.T
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+
+	// partitions contains records per every topic partition.
+	partitions map[string][][]fakeRecord
+
+	// committed contains committed offsets per consumer group.
+	committed map[string]map[topicPartition]int64
+
+	generationID int32
+	members      map[string][]byte
+	assignments  map[string][]byte
+	nextMemberID int
+
+	conns map[net.Conn]struct{}
+}
+
+func newFakeBroker(t *testing.T, partitionsCount map[string]int) *fakeBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start fake Kafka broker: %s", err)
+	}
+	fb := &fakeBroker{
+		t:          t,
+		ln:         ln,
+		partitions: make(map[string][][]fakeRecord),
+		committed:  make(map[string]map[topicPartition]int64),
+		members:    make(map[string][]byte),
+		conns:      make(map[net.Conn]struct{}),
+	}
+	for topic, n := range partitionsCount {
+		fb.partitions[topic] = make([][]fakeRecord, n)
+	}
+	fb.wg.Go(fb.acceptConns)
+	return fb
+}
+
+func (fb *fakeBroker) addr() string {
+	return fb.ln.Addr().String()
+}
+
+func (fb *fakeBroker) stop() {
+	_ = fb.ln.Close()
+	fb.mu.Lock()
+	for c := range fb.conns {
+		_ = c.Close()
+	}
+	fb.mu.Unlock()
+	fb.wg.Wait()
+}
+
+func (fb *fakeBroker) produce(topic string, partition int, timestamp int64, values ...string) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	for _, v := range values {
+		fb.partitions[topic][partition] = append(fb.partitions[topic][partition], fakeRecord{
+			timestamp: timestamp,
+			value:     v,
+		})
+	}
+}
+
+func (fb *fakeBroker) getCommittedOffsets(groupID string) map[topicPartition]int64 {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	m := make(map[topicPartition]int64)
+	for tp, offset := range fb.committed[groupID] {
+		m[tp] = offset
+	}
+	return m
+}
+
+func (fb *fakeBroker) acceptConns() {
+	for {
+		c, err := fb.ln.Accept()
+		if err != nil {
+			return
+		}
+		fb.mu.Lock()
+		fb.conns[c] = struct{}{}
+		fb.mu.Unlock()
+
+		fb.wg.Go(func() {
+			fb.serveConn(c)
+		})
+	}
+}
+
+func (fb *fakeBroker) serveConn(c net.Conn) {
+	defer func() {
+		_ = c.Close()
+		fb.mu.Lock()
+		delete(fb.conns, c)
+		fb.mu.Unlock()
+	}()
+
+	var sizeBuf [4]byte
+	for {
+		if _, err := io.ReadFull(c, sizeBuf[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(sizeBuf[:]))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+
+		d := decoder{
+			b: req,
+		}
+		apiKey := d.int16()
+		_ = d.int16() // apiVersion
+		correlationID := d.int32()
+		_ = d.string() // clientID
+		if d.err != nil {
+			fb.t.Errorf("cannot decode request header: %s", d.err)
+			return
+		}
+
+		var e encoder
+		e.int32(0) // placeholder for the message size
+		e.int32(correlationID)
+		if err := fb.handleRequest(&e, apiKey, &d); err != nil {
+			fb.t.Errorf("cannot handle request with apiKey=%d: %s", apiKey, err)
+			return
+		}
+		binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+		if _, err := c.Write(e.b); err != nil {
+			return
+		}
+	}
+}
+
+func (fb *fakeBroker) handleRequest(e *encoder, apiKey int16, d *decoder) error {
+	switch apiKey {
+	case apiKeyMetadata:
+		fb.handleMetadata(e, d)
+	case apiKeyFindCoordinator:
+		fb.handleFindCoordinator(e, d)
+	case apiKeyJoinGroup:
+		fb.handleJoinGroup(e, d)
+	case apiKeySyncGroup:
+		fb.handleSyncGroup(e, d)
+	case apiKeyHeartbeat:
+		fb.handleHeartbeat(e, d)
+	case apiKeyLeaveGroup:
+		fb.handleLeaveGroup(e, d)
+	case apiKeyOffsetFetch:
+		fb.handleOffsetFetch(e, d)
+	case apiKeyOffsetCommit:
+		fb.handleOffsetCommit(e, d)
+	case apiKeyListOffsets:
+		fb.handleListOffsets(e, d)
+	case apiKeyFetch:
+		fb.handleFetch(e, d)
+	default:
+		return fmt.Errorf("unsupported apiKey")
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.b) > 0 {
+		return errors.New("unexpected trailing data in the request")
+	}
+	return nil
+}
+
+func (fb *fakeBroker) writeBrokerAddr(e *encoder) {
+	host, portStr, err := net.SplitHostPort(fb.addr())
+	if err != nil {
+		panic(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		panic(err)
+	}
+	e.string(host)
+	e.int32(int32(port))
+}
+
+func (fb *fakeBroker) handleMetadata(e *encoder, d *decoder) {
+	topics := d.strings()
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	e.arrayLen(1)
+	e.int32(1) // node_id
+	fb.writeBrokerAddr(e)
+	e.nullString() // rack
+	e.int32(1)     // controller_id
+	e.arrayLen(len(topics))
+	for _, topic := range topics {
+		partitions, ok := fb.partitions[topic]
+		if !ok {
+			e.int16(errCodeUnknownTopicOrPartition)
+		} else {
+			e.int16(errCodeNone)
+		}
+		e.string(topic)
+		e.bool(false) // is_internal
+		e.arrayLen(len(partitions))
+		for i := range partitions {
+			e.int16(errCodeNone)
+			e.int32(int32(i))
+			e.int32(1) // leader
+			e.int32s([]int32{1})
+			e.int32s([]int32{1})
+		}
+	}
+}
+
+func (fb *fakeBroker) handleFindCoordinator(e *encoder, d *decoder) {
+	_ = d.string() // key
+	_ = d.int8()   // key_type
+
+	e.int32(0) // throttle_time_ms
+	e.int16(errCodeNone)
+	e.nullString() // error_message
+	e.int32(1)     // node_id
+	fb.writeBrokerAddr(e)
+}
+
+func (fb *fakeBroker) handleJoinGroup(e *encoder, d *decoder) {
+	_ = d.string() // group_id
+	_ = d.int32()  // session_timeout_ms
+	_ = d.int32()  // rebalance_timeout_ms
+	memberID := d.string()
+	_ = d.string() // protocol_type
+	protocolsLen := d.arrayLen()
+	var protocol string
+	var metadata []byte
+	for range protocolsLen {
+		protocol = d.string()
+		metadata = append([]byte{}, d.bytes()...)
+	}
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if memberID == "" {
+		fb.nextMemberID++
+		memberID = fmt.Sprintf("member-%d", fb.nextMemberID)
+	} else if _, ok := fb.members[memberID]; !ok {
+		e.int32(0) // throttle_time_ms
+		e.int16(errCodeUnknownMemberID)
+		e.int32(-1)
+		e.string("")
+		e.string("")
+		e.string("")
+		e.arrayLen(0)
+		return
+	}
+	fb.members[memberID] = metadata
+	fb.generationID++
+	fb.assignments = nil
+
+	// The member, which joined the group last, becomes the leader, since the fake broker doesn't wait for other members.
+	e.int32(0) // throttle_time_ms
+	e.int16(errCodeNone)
+	e.int32(fb.generationID)
+	e.string(protocol)
+	e.string(memberID) // leader
+	e.string(memberID)
+	e.arrayLen(len(fb.members))
+	for id, metadata := range fb.members {
+		e.string(id)
+		e.bytes(metadata)
+	}
+}
+
+func (fb *fakeBroker) handleSyncGroup(e *encoder, d *decoder) {
+	_ = d.string() // group_id
+	generationID := d.int32()
+	memberID := d.string()
+	assignmentsLen := d.arrayLen()
+	assignments := make(map[string][]byte)
+	for range assignmentsLen {
+		id := d.string()
+		assignments[id] = append([]byte{}, d.bytes()...)
+	}
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	e.int32(0) // throttle_time_ms
+	if generationID != fb.generationID {
+		e.int16(errCodeIllegalGeneration)
+		e.bytes(nil)
+		return
+	}
+	if len(assignments) > 0 {
+		fb.assignments = assignments
+	}
+	e.int16(errCodeNone)
+	e.bytes(fb.assignments[memberID])
+}
+
+func (fb *fakeBroker) handleHeartbeat(e *encoder, d *decoder) {
+	_ = d.string() // group_id
+	generationID := d.int32()
+	memberID := d.string()
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	e.int32(0) // throttle_time_ms
+	switch {
+	case fb.members[memberID] == nil:
+		e.int16(errCodeUnknownMemberID)
+	case generationID != fb.generationID:
+		e.int16(errCodeRebalanceInProgress)
+	default:
+		e.int16(errCodeNone)
+	}
+}
+
+func (fb *fakeBroker) handleLeaveGroup(e *encoder, d *decoder) {
+	_ = d.string() // group_id
+	memberID := d.string()
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	delete(fb.members, memberID)
+	fb.generationID++
+
+	e.int32(0) // throttle_time_ms
+	e.int16(errCodeNone)
+}
+
+func (fb *fakeBroker) handleOffsetFetch(e *encoder, d *decoder) {
+	groupID := d.string()
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	topicsLen := d.arrayLen()
+	e.arrayLen(topicsLen)
+	for range topicsLen {
+		topic := d.string()
+		partitions := d.int32s()
+		e.string(topic)
+		e.arrayLen(len(partitions))
+		for _, partition := range partitions {
+			tp := topicPartition{
+				topic:     topic,
+				partition: partition,
+			}
+			offset, ok := fb.committed[groupID][tp]
+			if !ok {
+				offset = -1
+			}
+			e.int32(partition)
+			e.int64(offset)
+			e.nullString() // metadata
+			e.int16(errCodeNone)
+		}
+	}
+}
+
+func (fb *fakeBroker) handleOffsetCommit(e *encoder, d *decoder) {
+	groupID := d.string()
+	generationID := d.int32()
+	_ = d.string() // member_id
+	_ = d.int64()  // retention_time_ms
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	errCode := int16(errCodeNone)
+	if generationID != fb.generationID {
+		errCode = errCodeIllegalGeneration
+	}
+	if fb.committed[groupID] == nil {
+		fb.committed[groupID] = make(map[topicPartition]int64)
+	}
+
+	topicsLen := d.arrayLen()
+	e.arrayLen(topicsLen)
+	for range topicsLen {
+		topic := d.string()
+		e.string(topic)
+		partitionsLen := d.arrayLen()
+		e.arrayLen(partitionsLen)
+		for range partitionsLen {
+			partition := d.int32()
+			offset := d.int64()
+			_ = d.string() // committed_metadata
+			if errCode == errCodeNone {
+				tp := topicPartition{
+					topic:     topic,
+					partition: partition,
+				}
+				fb.committed[groupID][tp] = offset
+			}
+			e.int32(partition)
+			e.int16(errCode)
+		}
+	}
+}
+
+func (fb *fakeBroker) handleListOffsets(e *encoder, d *decoder) {
+	_ = d.int32() // replica_id
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	topicsLen := d.arrayLen()
+	e.arrayLen(topicsLen)
+	for range topicsLen {
+		topic := d.string()
+		e.string(topic)
+		partitionsLen := d.arrayLen()
+		e.arrayLen(partitionsLen)
+		for range partitionsLen {
+			partition := d.int32()
+			timestamp := d.int64()
+			offset := int64(0)
+			if timestamp == listOffsetsLatest {
+				offset = int64(len(fb.partitions[topic][partition]))
+			}
+			e.int32(partition)
+			e.int16(errCodeNone)
+			e.int64(-1) // timestamp
+			e.int64(offset)
+		}
+	}
+}
+
+func (fb *fakeBroker) handleFetch(e *encoder, d *decoder) {
+	_ = d.int32() // replica_id
+	maxWait := time.Duration(d.int32()) * time.Millisecond
+	_ = d.int32() // min_bytes
+	_ = d.int32() // max_bytes
+	_ = d.int8()  // isolation_level
+
+	type partitionRequest struct {
+		partition int32
+		offset    int64
+	}
+	type topicRequest struct {
+		topic      string
+		partitions []partitionRequest
+	}
+	var trs []topicRequest
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		tr := topicRequest{
+			topic: d.string(),
+		}
+		partitionsLen := d.arrayLen()
+		for range partitionsLen {
+			pr := partitionRequest{
+				partition: d.int32(),
+				offset:    d.int64(),
+			}
+			_ = d.int32() // partition_max_bytes
+			tr.partitions = append(tr.partitions, pr)
+		}
+		trs = append(trs, tr)
+	}
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	hasRecords := false
+	e.int32(0) // throttle_time_ms
+	e.arrayLen(len(trs))
+	for _, tr := range trs {
+		e.string(tr.topic)
+		e.arrayLen(len(tr.partitions))
+		for _, pr := range tr.partitions {
+			records := fb.partitions[tr.topic][pr.partition]
+			highWatermark := int64(len(records))
+			e.int32(pr.partition)
+			if pr.offset < 0 || pr.offset > highWatermark {
+				e.int16(errCodeOffsetOutOfRange)
+			} else {
+				e.int16(errCodeNone)
+			}
+			e.int64(highWatermark)
+			e.int64(highWatermark) // last_stable_offset
+			e.arrayLen(0)          // aborted_transactions
+			if pr.offset < 0 || pr.offset >= highWatermark {
+				e.bytes(nil)
+				continue
+			}
+			hasRecords = true
+			e.bytes(appendRecordBatch(nil, pr.offset, records[pr.offset:]))
+		}
+	}
+
+	if !hasRecords {
+		// Emulate waiting for new records at the broker side.
+		time.Sleep(min(maxWait, 10*time.Millisecond))
+	}
+}
+
+// appendRecordBatch appends uncompressed record batch v2 with the given records starting from baseOffset to dst.
+func appendRecordBatch(dst []byte, baseOffset int64, records []fakeRecord) []byte {
+	data := marshalFakeRecords(records)
+	return appendRawRecordBatch(dst, baseOffset, compressionNone, records[0].timestamp, records[len(records)-1].timestamp, int32(len(records)), data)
+}
+
+// marshalFakeRecords marshals records into the format used in record batch v2.
+func marshalFakeRecords(records []fakeRecord) []byte {
+	var data []byte
+	for i, r := range records {
+		var rec []byte
+		rec = append(rec, 0) // attributes
+		rec = binary.AppendVarint(rec, r.timestamp-records[0].timestamp)
+		rec = binary.AppendVarint(rec, int64(i))
+		rec = binary.AppendVarint(rec, -1) // key
+		rec = binary.AppendVarint(rec, int64(len(r.value)))
+		rec = append(rec, r.value...)
+		rec = binary.AppendVarint(rec, 0) // headers
+
+		data = binary.AppendVarint(data, int64(len(rec)))
+		data = append(data, rec...)
+	}
+	return data
+}
+
+// appendRawRecordBatch appends record batch v2 with the given attributes and the given encoded records data to dst.
+func appendRawRecordBatch(dst []byte, baseOffset int64, attributes int16, baseTimestamp, maxTimestamp int64, recordsCount int32, data []byte) []byte {
+	var crcData encoder
+	crcData.int16(attributes)
+	crcData.int32(recordsCount - 1) // lastOffsetDelta
+	crcData.int64(baseTimestamp)
+	crcData.int64(maxTimestamp)
+	crcData.int64(-1) // producerId
+	crcData.int16(-1) // producerEpoch
+	crcData.int32(-1) // baseSequence
+	crcData.int32(recordsCount)
+	crcData.b = append(crcData.b, data...)
+
+	e := encoder{
+		b: dst,
+	}
+	e.int64(baseOffset)
+	e.int32(int32(4 + 1 + 4 + len(crcData.b))) // batchLength
+	e.int32(0)                                 // partitionLeaderEpoch
+	e.int8(2)                                  // magic
+	e.int32(int32(crc32.Checksum(crcData.b, crc32cTable)))
+	e.b = append(e.b, crcData.b...)
+	return e.b
+}
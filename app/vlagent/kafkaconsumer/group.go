@@ -0,0 +1,133 @@
+package kafkaconsumer
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// rangeAssignor is the name of the partition assignment strategy used by the consumer.
+//
+// It is compatible with the default RangeAssignor in Java Kafka clients,
+// so vlagent instances can share consumer groups with other Kafka consumers using the same strategy.
+const rangeAssignor = "range"
+
+// marshalMemberMetadata returns ConsumerProtocolSubscription v0 for the given topics.
+//
+// See https://github.com/apache/kafka/blob/trunk/clients/src/main/resources/common/message/ConsumerProtocolSubscription.json
+func marshalMemberMetadata(topics []string) []byte {
+	var e encoder
+	e.int16(0) // version
+	e.strings(topics)
+	e.int32(-1) // user_data
+	return e.b
+}
+
+func unmarshalMemberMetadata(src []byte) ([]string, error) {
+	d := decoder{
+		b: src,
+	}
+	_ = d.int16() // version
+	topics := d.strings()
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode consumer group member metadata: %w", d.err)
+	}
+	return topics, nil
+}
+
+// marshalMemberAssignment returns ConsumerProtocolAssignment v0 for the given tps.
+//
+// See https://github.com/apache/kafka/blob/trunk/clients/src/main/resources/common/message/ConsumerProtocolAssignment.json
+func marshalMemberAssignment(tps []topicPartition) []byte {
+	topics, m := groupTopicPartitions(tps)
+
+	var e encoder
+	e.int16(0) // version
+	e.arrayLen(len(topics))
+	for _, topic := range topics {
+		e.string(topic)
+		e.int32s(m[topic])
+	}
+	e.int32(-1) // user_data
+	return e.b
+}
+
+func unmarshalMemberAssignment(src []byte) ([]topicPartition, error) {
+	if len(src) == 0 {
+		// Empty assignment
+		return nil, nil
+	}
+
+	d := decoder{
+		b: src,
+	}
+	_ = d.int16() // version
+	var tps []topicPartition
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		topic := d.string()
+		for _, partition := range d.int32s() {
+			tps = append(tps, topicPartition{
+				topic:     topic,
+				partition: partition,
+			})
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode consumer group member assignment: %w", d.err)
+	}
+	return tps, nil
+}
+
+// assignRange assigns partitions to group members according to the range strategy.
+//
+// subscriptions maps member id to the list of topics the member is subscribed to.
+// partitionsCount maps topic name to the number of partitions in the topic.
+//
+// Every topic is assigned independently: partitions are split into contiguous ranges, which are assigned to members sorted by member id.
+func assignRange(subscriptions map[string][]string, partitionsCount map[string]int32) map[string][]topicPartition {
+	topicMembers := make(map[string][]string)
+	for memberID, topics := range subscriptions {
+		for _, topic := range topics {
+			topicMembers[topic] = append(topicMembers[topic], memberID)
+		}
+	}
+
+	assignments := make(map[string][]topicPartition, len(subscriptions))
+	for memberID := range subscriptions {
+		assignments[memberID] = nil
+	}
+
+	topics := make([]string, 0, len(topicMembers))
+	for topic := range topicMembers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		members := topicMembers[topic]
+		slices.Sort(members)
+		members = slices.Compact(members)
+
+		n := partitionsCount[topic]
+		membersCount := int32(len(members))
+		perMember := n / membersCount
+		extra := n % membersCount
+
+		partition := int32(0)
+		for i, memberID := range members {
+			count := perMember
+			if int32(i) < extra {
+				count++
+			}
+			for range count {
+				assignments[memberID] = append(assignments[memberID], topicPartition{
+					topic:     topic,
+					partition: partition,
+				})
+				partition++
+			}
+		}
+	}
+	return assignments
+}
@@ -0,0 +1,128 @@
+package kafkaconsumer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemberMetadataMarshalUnmarshal(t *testing.T) {
+	f := func(topics []string) {
+		t.Helper()
+
+		data := marshalMemberMetadata(topics)
+		result, err := unmarshalMemberMetadata(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, topics) {
+			t.Fatalf("unexpected topics; got %q; want %q", result, topics)
+		}
+	}
+
+	f([]string{})
+	f([]string{"foo"})
+	f([]string{"foo", "bar"})
+}
+
+func TestMemberAssignmentMarshalUnmarshal(t *testing.T) {
+	f := func(tps []topicPartition) {
+		t.Helper()
+
+		data := marshalMemberAssignment(tps)
+		result, err := unmarshalMemberAssignment(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, tps) {
+			t.Fatalf("unexpected assignment; got %v; want %v", result, tps)
+		}
+	}
+
+	f(nil)
+	f([]topicPartition{
+		{topic: "foo", partition: 0},
+	})
+	f([]topicPartition{
+		{topic: "foo", partition: 0},
+		{topic: "foo", partition: 2},
+		{topic: "bar", partition: 1},
+	})
+
+	// empty assignment
+	tps, err := unmarshalMemberAssignment(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tps) != 0 {
+		t.Fatalf("unexpected non-empty assignment: %v", tps)
+	}
+}
+
+func TestAssignRange(t *testing.T) {
+	f := func(subscriptions map[string][]string, partitionsCount map[string]int32, resultExpected map[string][]topicPartition) {
+		t.Helper()
+
+		result := assignRange(subscriptions, partitionsCount)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected assignment;\ngot\n%v\nwant\n%v", result, resultExpected)
+		}
+	}
+
+	// single member
+	f(map[string][]string{
+		"m1": {"foo"},
+	}, map[string]int32{
+		"foo": 2,
+	}, map[string][]topicPartition{
+		"m1": {
+			{topic: "foo", partition: 0},
+			{topic: "foo", partition: 1},
+		},
+	})
+
+	// uneven split
+	f(map[string][]string{
+		"m2": {"foo"},
+		"m1": {"foo"},
+	}, map[string]int32{
+		"foo": 3,
+	}, map[string][]topicPartition{
+		"m1": {
+			{topic: "foo", partition: 0},
+			{topic: "foo", partition: 1},
+		},
+		"m2": {
+			{topic: "foo", partition: 2},
+		},
+	})
+
+	// more members than partitions
+	f(map[string][]string{
+		"m1": {"foo"},
+		"m2": {"foo"},
+	}, map[string]int32{
+		"foo": 1,
+	}, map[string][]topicPartition{
+		"m1": {
+			{topic: "foo", partition: 0},
+		},
+		"m2": nil,
+	})
+
+	// different subscriptions
+	f(map[string][]string{
+		"m1": {"foo", "bar"},
+		"m2": {"bar"},
+	}, map[string]int32{
+		"foo": 1,
+		"bar": 2,
+	}, map[string][]topicPartition{
+		"m1": {
+			{topic: "bar", partition: 0},
+			{topic: "foo", partition: 0},
+		},
+		"m2": {
+			{topic: "bar", partition: 1},
+		},
+	})
+}
@@ -0,0 +1,274 @@
+package kafkaconsumer
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/remotewrite"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var (
+	brokers = flagutil.NewArrayString("kafkaConsumer.brokers", "Comma-separated list of Kafka bootstrap brokers in the form host:port. "+
+		"vlagent consumes logs from -kafkaConsumer.topics if this flag is set. See https://docs.victoriametrics.com/victorialogs/vlagent/#kafka-consumer")
+	topics   = flagutil.NewArrayString("kafkaConsumer.topics", "Comma-separated list of Kafka topics to consume logs from")
+	groupID  = flag.String("kafkaConsumer.groupID", "vlagent", "Kafka consumer group to join. Partitions of -kafkaConsumer.topics are distributed among vlagent instances with the same group")
+	clientID = flag.String("kafkaConsumer.clientID", "vlagent", "Client id to send to Kafka brokers")
+	format   = flag.String("kafkaConsumer.format", "jsonline", "Format of Kafka messages. Supported values: jsonline, logfmt, plain. "+
+		"Every Kafka message may contain multiple newline-delimited log lines")
+	initialOffset = flag.String("kafkaConsumer.initialOffset", "oldest", "The offset to start consuming partitions without committed offsets from. "+
+		"Supported values: oldest, newest")
+
+	tenantID = flag.String("kafkaConsumer.tenantID", "0:0", "Tenant ID to store logs consumed from Kafka in format: <accountID>:<projectID>. "+
+		"See https://docs.victoriametrics.com/victorialogs/vlagent/#multitenancy")
+	msgField = flagutil.NewArrayString("kafkaConsumer.msgField", "Fields that may contain the _msg field for logs consumed from Kafka. "+
+		"See https://docs.victoriametrics.com/victorialogs/keyconcepts/#message-field")
+	timeField = flagutil.NewArrayString("kafkaConsumer.timeField", "Fields that may contain the _time field for logs consumed from Kafka. Default: _time. "+
+		"If none of the specified fields is found in the log line, then the Kafka message timestamp is used. "+
+		"See https://docs.victoriametrics.com/victorialogs/keyconcepts/#time-field")
+	streamFields = flagutil.NewArrayString("kafkaConsumer.streamFields", "Comma-separated list of fields to use as log stream fields for logs consumed from Kafka. "+
+		"See https://docs.victoriametrics.com/victorialogs/keyconcepts/#stream-fields")
+	ignoreFields     = flagutil.NewArrayString("kafkaConsumer.ignoreFields", "Fields to ignore across logs consumed from Kafka")
+	decolorizeFields = flagutil.NewArrayString("kafkaConsumer.decolorizeFields", "Fields to remove ANSI color codes across logs consumed from Kafka")
+	extraFields      = flag.String("kafkaConsumer.extraFields", "", "Extra fields to add to each log line consumed from Kafka in JSON format. "+
+		`For example: -kafkaConsumer.extraFields='{"source":"kafka","env":"production"}'`)
+
+	commitInterval = flag.Duration("kafkaConsumer.commitInterval", time.Second, "Interval for committing offsets of the consumed Kafka messages. "+
+		"Offsets are committed only after the consumed logs are accepted by all the -remoteWrite.url")
+	sessionTimeout = flag.Duration("kafkaConsumer.sessionTimeout", 30*time.Second, "Kafka consumer group session timeout. "+
+		"The partitions of vlagent instance are re-assigned to other group members if it doesn't send heartbeats during this timeout")
+	heartbeatInterval = flag.Duration("kafkaConsumer.heartbeatInterval", 3*time.Second, "Interval for sending heartbeats to Kafka consumer group coordinator. "+
+		"It must be smaller than -kafkaConsumer.sessionTimeout")
+	dialTimeout  = flag.Duration("kafkaConsumer.dialTimeout", 10*time.Second, "Timeout for connecting and sending requests to Kafka brokers")
+	fetchMaxWait = flag.Duration("kafkaConsumer.fetchMaxWait", 500*time.Millisecond, "The maximum duration Kafka broker waits for new messages before responding to fetch request. "+
+		"It must be smaller than -kafkaConsumer.heartbeatInterval")
+	fetchMaxBytes          = flagutil.NewBytes("kafkaConsumer.fetchMaxBytes", 16*1024*1024, "The maximum size of a single fetch response from Kafka broker")
+	fetchMaxPartitionBytes = flagutil.NewBytes("kafkaConsumer.fetchMaxPartitionBytes", 1024*1024, "The maximum size of messages to fetch per Kafka partition in a single fetch request")
+)
+
+var c *consumer
+
+// Init starts consuming logs from Kafka if -kafkaConsumer.brokers is set.
+//
+// Stop must be called when the consumer is no longer needed.
+func Init() {
+	if len(*brokers) == 0 {
+		return
+	}
+	if len(*topics) == 0 {
+		logger.Fatalf("-kafkaConsumer.topics must be set when -kafkaConsumer.brokers is set")
+	}
+	if *heartbeatInterval >= *sessionTimeout {
+		logger.Fatalf("-kafkaConsumer.heartbeatInterval=%s must be smaller than -kafkaConsumer.sessionTimeout=%s", *heartbeatInterval, *sessionTimeout)
+	}
+
+	cfg := &consumerConfig{
+		brokers:  *brokers,
+		topics:   *topics,
+		groupID:  *groupID,
+		clientID: *clientID,
+
+		dialTimeout:       *dialTimeout,
+		sessionTimeout:    *sessionTimeout,
+		rebalanceTimeout:  *sessionTimeout,
+		heartbeatInterval: *heartbeatInterval,
+		commitInterval:    *commitInterval,
+
+		fetchMaxWait:           *fetchMaxWait,
+		fetchMaxBytes:          int32(fetchMaxBytes.IntN()),
+		fetchMaxPartitionBytes: int32(fetchMaxPartitionBytes.IntN()),
+	}
+	switch *initialOffset {
+	case "oldest":
+		cfg.initialOffset = listOffsetsEarliest
+	case "newest":
+		cfg.initialOffset = listOffsetsLatest
+	default:
+		logger.Fatalf("unsupported -kafkaConsumer.initialOffset=%q; supported values: oldest, newest", *initialOffset)
+	}
+
+	cp, err := getCommonParams()
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+	if err := validateFormat(*format); err != nil {
+		logger.Fatalf("%s", err)
+	}
+	// Wait for the delivery of the consumed logs for up to -kafkaConsumer.heartbeatInterval, so the consumer doesn't miss heartbeats.
+	rh := newLogsHandler(cp, *format, func() error {
+		return remotewrite.FlushAndWait(*heartbeatInterval)
+	})
+
+	c = startConsumer(cfg, rh)
+	logger.Infof("started Kafka consumer for topics %q in group %q", cfg.topics, cfg.groupID)
+}
+
+// Stop stops consuming logs from Kafka.
+//
+// It commits offsets for the consumed logs before returning.
+func Stop() {
+	if c == nil {
+		return
+	}
+	c.stop()
+	c = nil
+	logger.Infof("stopped Kafka consumer")
+}
+
+func getCommonParams() (*insertutil.CommonParams, error) {
+	tid, err := logstorage.ParseTenantID(*tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -kafkaConsumer.tenantID=%q: %w", *tenantID, err)
+	}
+
+	timeFields := []string{"_time"}
+	if len(*timeField) > 0 {
+		timeFields = *timeField
+	}
+
+	var efs []logstorage.Field
+	if *extraFields != "" {
+		p := logstorage.GetJSONParser()
+		if err := p.ParseLogMessage([]byte(*extraFields), nil); err != nil {
+			return nil, fmt.Errorf("cannot parse -kafkaConsumer.extraFields=%q: %w", *extraFields, err)
+		}
+		// Do not return p to the pool, since efs refer to it.
+		efs = p.Fields
+	}
+
+	cp := &insertutil.CommonParams{
+		TenantID:         tid,
+		TimeFields:       timeFields,
+		MsgFields:        *msgField,
+		StreamFields:     *streamFields,
+		IgnoreFields:     *ignoreFields,
+		DecolorizeFields: *decolorizeFields,
+		ExtraFields:      efs,
+		IsTimeFieldSet:   len(*timeField) > 0,
+	}
+	return cp, nil
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case "jsonline", "logfmt", "plain":
+		return nil
+	default:
+		return fmt.Errorf("unsupported -kafkaConsumer.format=%q; supported values: jsonline, logfmt, plain", format)
+	}
+}
+
+// logsHandler converts Kafka records to logs and sends them to remotewrite.
+//
+// It isn't safe to use logsHandler from concurrently running goroutines.
+type logsHandler struct {
+	cp     *insertutil.CommonParams
+	format string
+
+	// flushStorage must durably store the logs added via insertutil.LogMessageProcessor.
+	flushStorage func() error
+
+	lmp insertutil.LogMessageProcessor
+
+	jp     *logstorage.JSONParser
+	fields []logstorage.Field
+}
+
+func newLogsHandler(cp *insertutil.CommonParams, format string, flushStorage func() error) *logsHandler {
+	return &logsHandler{
+		cp:           cp,
+		format:       format,
+		jp:           logstorage.GetJSONParser(),
+		flushStorage: flushStorage,
+		lmp:          cp.NewLogMessageProcessor("kafka", false),
+	}
+}
+
+func (lh *logsHandler) processRecord(topic string, r *record) {
+	data := r.value
+	for len(data) > 0 {
+		var line []byte
+		n := bytes.IndexByte(data, '\n')
+		if n < 0 {
+			line = data
+			data = nil
+		} else {
+			line = data[:n]
+			data = data[n+1:]
+		}
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		if len(line) == 0 {
+			continue
+		}
+		lh.processLine(topic, r.timestamp, line)
+	}
+}
+
+func (lh *logsHandler) processLine(topic string, recordTimestamp int64, line []byte) {
+	var fields []logstorage.Field
+	switch lh.format {
+	case "jsonline":
+		if err := lh.jp.ParseLogMessage(line, nil); err != nil {
+			logger.Warnf("cannot parse Kafka message from topic %q: %s; message contents: %q", topic, err, line)
+			parseErrorsTotal.Inc()
+			return
+		}
+		fields = lh.jp.Fields
+	case "logfmt":
+		clear(lh.fields)
+		lh.fields = logstorage.ParseLogfmt(lh.fields[:0], bytesutil.ToUnsafeString(line))
+		fields = lh.fields
+	default:
+		clear(lh.fields)
+		lh.fields = append(lh.fields[:0], logstorage.Field{
+			Name:  "_msg",
+			Value: bytesutil.ToUnsafeString(line),
+		})
+		fields = lh.fields
+	}
+
+	var ts int64
+	var err error
+	if hasTimeField(fields, lh.cp.TimeFields) {
+		ts, err = insertutil.ExtractTimestampFromFields(lh.cp.TimeFields, fields)
+		if err != nil {
+			logger.Warnf("cannot parse timestamp in Kafka message from topic %q: %s; message contents: %q", topic, err, line)
+			parseErrorsTotal.Inc()
+			return
+		}
+	} else {
+		ts = recordTimestamp * 1e6
+	}
+	logstorage.RenameField(fields, lh.cp.MsgFields, "_msg")
+	lh.lmp.AddRow(ts, fields, -1)
+}
+
+func hasTimeField(fields []logstorage.Field, timeFields []string) bool {
+	for _, f := range fields {
+		for _, timeField := range timeFields {
+			if f.Name == timeField {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flush flushes the consumed logs to remotewrite queues and waits until they are delivered to remote storage.
+func (lh *logsHandler) flush() error {
+	lh.lmp.MustClose()
+	lh.lmp = lh.cp.NewLogMessageProcessor("kafka", false)
+	return lh.flushStorage()
+}
+
+var parseErrorsTotal = metrics.NewCounter(`vlagent_kafka_parse_errors_total`)
@@ -0,0 +1,71 @@
+package kafkaconsumer
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestLogsHandler(t *testing.T) {
+	f := func(format string, timeFields, msgFields []string, values []string, timestampsExpected []int64, resultExpected string) {
+		t.Helper()
+
+		cp := &insertutil.CommonParams{
+			TimeFields: timeFields,
+			MsgFields:  msgFields,
+		}
+		tlp := &insertutil.TestLogMessageProcessor{}
+		lh := &logsHandler{
+			cp:     cp,
+			format: format,
+			jp:     logstorage.GetJSONParser(),
+			lmp:    tlp,
+		}
+		for _, v := range values {
+			r := &record{
+				timestamp: 1_700_000_000_000,
+				value:     []byte(v),
+			}
+			lh.processRecord("logs", r)
+		}
+
+		if err := tlp.Verify(timestampsExpected, resultExpected); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// jsonline with the time field
+	f("jsonline", []string{"_time"}, nil, []string{
+		`{"_time":"2025-01-02T03:04:05Z","_msg":"foo","level":"info"}`,
+	}, []int64{1735787045000000000}, `{"_msg":"foo","level":"info"}`)
+
+	// jsonline without the time field uses the Kafka message timestamp
+	f("jsonline", []string{"_time"}, []string{"message"}, []string{
+		`{"message":"foo"}`,
+	}, []int64{1_700_000_000_000_000_000}, `{"_msg":"foo"}`)
+
+	// multiple lines per message
+	f("jsonline", []string{"ts"}, nil, []string{
+		"{\"ts\":\"1735787045\",\"_msg\":\"foo\"}\n\r\n{\"ts\":\"1735787046\",\"_msg\":\"bar\"}\r\n",
+		`{"ts":"1735787047","_msg":"baz"}`,
+	}, []int64{1735787045000000000, 1735787046000000000, 1735787047000000000}, `{"_msg":"foo"}
+{"_msg":"bar"}
+{"_msg":"baz"}`)
+
+	// invalid json lines are skipped
+	f("jsonline", []string{"_time"}, nil, []string{
+		"foobar\n{\"_msg\":\"foo\"}",
+	}, []int64{1_700_000_000_000_000_000}, `{"_msg":"foo"}`)
+
+	// logfmt
+	f("logfmt", []string{"time"}, []string{"msg"}, []string{
+		`time=2025-01-02T03:04:05Z level=error msg="cannot open file" path=/foo/bar`,
+	}, []int64{1735787045000000000}, `{"level":"error","_msg":"cannot open file","path":"/foo/bar"}`)
+
+	// plain
+	f("plain", []string{"_time"}, nil, []string{
+		"foo bar\nbaz",
+	}, []int64{1_700_000_000_000_000_000, 1_700_000_000_000_000_000}, `{"_msg":"foo bar"}
+{"_msg":"baz"}`)
+}
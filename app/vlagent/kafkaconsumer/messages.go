@@ -0,0 +1,552 @@
+package kafkaconsumer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The consumer uses the oldest API versions supported by Kafka 4.x, since they do not need tagged fields.
+//
+// See https://cwiki.apache.org/confluence/display/KAFKA/KIP-896%3A+Remove+old+client+protocol+API+versions+in+Kafka+4.0
+const (
+	apiVersionFetch           = 4
+	apiVersionListOffsets     = 1
+	apiVersionMetadata        = 1
+	apiVersionOffsetCommit    = 2
+	apiVersionOffsetFetch     = 1
+	apiVersionFindCoordinator = 1
+	apiVersionJoinGroup       = 2
+	apiVersionHeartbeat       = 1
+	apiVersionLeaveGroup      = 1
+	apiVersionSyncGroup       = 1
+)
+
+// topicPartition identifies a single Kafka topic partition.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func (tp topicPartition) String() string {
+	return fmt.Sprintf("%s/%d", tp.topic, tp.partition)
+}
+
+// groupTopicPartitions groups tps by topic names.
+//
+// The order of topics and partitions is preserved.
+func groupTopicPartitions(tps []topicPartition) ([]string, map[string][]int32) {
+	var topics []string
+	m := make(map[string][]int32)
+	for _, tp := range tps {
+		if _, ok := m[tp.topic]; !ok {
+			topics = append(topics, tp.topic)
+		}
+		m[tp.topic] = append(m[tp.topic], tp.partition)
+	}
+	return topics, m
+}
+
+type partitionMetadata struct {
+	partition int32
+	leader    int32
+	errCode   int16
+}
+
+type topicMetadata struct {
+	name       string
+	errCode    int16
+	partitions []partitionMetadata
+}
+
+type metadataResponse struct {
+	// brokers maps broker node id to broker address
+	brokers map[int32]string
+
+	topics []topicMetadata
+}
+
+// metadata returns metadata for the given topics.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_Metadata
+func (bc *brokerConn) metadata(topics []string) (*metadataResponse, error) {
+	var e encoder
+	e.strings(topics)
+
+	resp, err := bc.roundTrip(apiKeyMetadata, apiVersionMetadata, e.b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	mr := &metadataResponse{
+		brokers: make(map[int32]string),
+	}
+	brokersLen := d.arrayLen()
+	for range brokersLen {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		_ = d.string() // rack
+		mr.brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	_ = d.int32() // controller_id
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		var tm topicMetadata
+		tm.errCode = d.int16()
+		tm.name = d.string()
+		_ = d.bool() // is_internal
+		partitionsLen := d.arrayLen()
+		for range partitionsLen {
+			var pm partitionMetadata
+			pm.errCode = d.int16()
+			pm.partition = d.int32()
+			pm.leader = d.int32()
+			_ = d.int32s() // replica_nodes
+			_ = d.int32s() // isr_nodes
+			tm.partitions = append(tm.partitions, pm)
+		}
+		mr.topics = append(mr.topics, tm)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode Metadata response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	return mr, nil
+}
+
+// findCoordinator returns the address of the coordinator for the given groupID.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_FindCoordinator
+func (bc *brokerConn) findCoordinator(groupID string) (string, error) {
+	var e encoder
+	e.string(groupID)
+	e.int8(0) // key_type=group
+
+	resp, err := bc.roundTrip(apiKeyFindCoordinator, apiVersionFindCoordinator, e.b, 0)
+	if err != nil {
+		return "", err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	_ = d.int32() // throttle_time_ms
+	errCode := d.int16()
+	errMsg := d.string()
+	_ = d.int32() // node_id
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return "", fmt.Errorf("cannot decode FindCoordinator response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	if errCode != errCodeNone {
+		return "", fmt.Errorf("cannot find coordinator for group %q: %w; error message: %q", groupID, kafkaError(errCode), errMsg)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+type joinGroupMember struct {
+	memberID string
+	metadata []byte
+}
+
+type joinGroupResponse struct {
+	errCode      int16
+	generationID int32
+	protocol     string
+	leaderID     string
+	memberID     string
+
+	// members is non-empty only for the group leader.
+	members []joinGroupMember
+}
+
+// joinGroup joins the given groupID with the given memberID.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_JoinGroup
+func (bc *brokerConn) joinGroup(groupID, memberID string, sessionTimeout, rebalanceTimeout time.Duration, protocol string, metadata []byte) (*joinGroupResponse, error) {
+	var e encoder
+	e.string(groupID)
+	e.int32(int32(sessionTimeout.Milliseconds()))
+	e.int32(int32(rebalanceTimeout.Milliseconds()))
+	e.string(memberID)
+	e.string("consumer")
+	e.arrayLen(1)
+	e.string(protocol)
+	e.bytes(metadata)
+
+	// JoinGroup response may be delayed by the coordinator for up to rebalanceTimeout while waiting for other members.
+	resp, err := bc.roundTrip(apiKeyJoinGroup, apiVersionJoinGroup, e.b, rebalanceTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	var jgr joinGroupResponse
+	_ = d.int32() // throttle_time_ms
+	jgr.errCode = d.int16()
+	jgr.generationID = d.int32()
+	jgr.protocol = d.string()
+	jgr.leaderID = d.string()
+	jgr.memberID = d.string()
+	membersLen := d.arrayLen()
+	for range membersLen {
+		var m joinGroupMember
+		m.memberID = d.string()
+		m.metadata = append([]byte{}, d.bytes()...)
+		jgr.members = append(jgr.members, m)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode JoinGroup response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	return &jgr, nil
+}
+
+// syncGroup sends the given assignments to the coordinator and returns the assignment for the memberID.
+//
+// assignments must be non-empty only for the group leader.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_SyncGroup
+func (bc *brokerConn) syncGroup(groupID string, generationID int32, memberID string, assignments map[string][]byte, rebalanceTimeout time.Duration) ([]byte, int16, error) {
+	var e encoder
+	e.string(groupID)
+	e.int32(generationID)
+	e.string(memberID)
+	e.arrayLen(len(assignments))
+	for id, assignment := range assignments {
+		e.string(id)
+		e.bytes(assignment)
+	}
+
+	resp, err := bc.roundTrip(apiKeySyncGroup, apiVersionSyncGroup, e.b, rebalanceTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	_ = d.int32() // throttle_time_ms
+	errCode := d.int16()
+	assignment := append([]byte{}, d.bytes()...)
+	if d.err != nil {
+		return nil, 0, fmt.Errorf("cannot decode SyncGroup response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	return assignment, errCode, nil
+}
+
+// heartbeat sends heartbeat to the group coordinator and returns the error code from the response.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_Heartbeat
+func (bc *brokerConn) heartbeat(groupID string, generationID int32, memberID string) (int16, error) {
+	var e encoder
+	e.string(groupID)
+	e.int32(generationID)
+	e.string(memberID)
+
+	resp, err := bc.roundTrip(apiKeyHeartbeat, apiVersionHeartbeat, e.b, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	_ = d.int32() // throttle_time_ms
+	errCode := d.int16()
+	if d.err != nil {
+		return 0, fmt.Errorf("cannot decode Heartbeat response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	return errCode, nil
+}
+
+// leaveGroup leaves the given groupID.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_LeaveGroup
+func (bc *brokerConn) leaveGroup(groupID, memberID string) error {
+	var e encoder
+	e.string(groupID)
+	e.string(memberID)
+
+	resp, err := bc.roundTrip(apiKeyLeaveGroup, apiVersionLeaveGroup, e.b, 0)
+	if err != nil {
+		return err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	_ = d.int32() // throttle_time_ms
+	errCode := d.int16()
+	if d.err != nil {
+		return fmt.Errorf("cannot decode LeaveGroup response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	if errCode != errCodeNone {
+		return fmt.Errorf("cannot leave group %q: %w", groupID, kafkaError(errCode))
+	}
+	return nil
+}
+
+// offsetFetch returns committed offsets for the given tps in the given groupID.
+//
+// -1 is returned for partitions without committed offsets.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_OffsetFetch
+func (bc *brokerConn) offsetFetch(groupID string, tps []topicPartition) (map[topicPartition]int64, error) {
+	topics, m := groupTopicPartitions(tps)
+
+	var e encoder
+	e.string(groupID)
+	e.arrayLen(len(topics))
+	for _, topic := range topics {
+		e.string(topic)
+		e.int32s(m[topic])
+	}
+
+	resp, err := bc.roundTrip(apiKeyOffsetFetch, apiVersionOffsetFetch, e.b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	offsets := make(map[topicPartition]int64, len(tps))
+	var firstErr error
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		topic := d.string()
+		partitionsLen := d.arrayLen()
+		for range partitionsLen {
+			partition := d.int32()
+			offset := d.int64()
+			_ = d.string() // metadata
+			errCode := d.int16()
+			tp := topicPartition{
+				topic:     topic,
+				partition: partition,
+			}
+			if errCode != errCodeNone && firstErr == nil {
+				firstErr = fmt.Errorf("cannot fetch committed offset for %s in group %q: %w", tp, groupID, kafkaError(errCode))
+			}
+			offsets[tp] = offset
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode OffsetFetch response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return offsets, nil
+}
+
+// offsetCommit commits the given offsets for the given groupID.
+//
+// It returns the first non-zero error code from the response.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_OffsetCommit
+func (bc *brokerConn) offsetCommit(groupID string, generationID int32, memberID string, offsets map[topicPartition]int64) (int16, error) {
+	tps := make([]topicPartition, 0, len(offsets))
+	for tp := range offsets {
+		tps = append(tps, tp)
+	}
+	topics, m := groupTopicPartitions(tps)
+
+	var e encoder
+	e.string(groupID)
+	e.int32(generationID)
+	e.string(memberID)
+	e.int64(-1) // retention_time_ms - use broker default
+	e.arrayLen(len(topics))
+	for _, topic := range topics {
+		e.string(topic)
+		partitions := m[topic]
+		e.arrayLen(len(partitions))
+		for _, partition := range partitions {
+			tp := topicPartition{
+				topic:     topic,
+				partition: partition,
+			}
+			e.int32(partition)
+			e.int64(offsets[tp])
+			e.nullString() // committed_metadata
+		}
+	}
+
+	resp, err := bc.roundTrip(apiKeyOffsetCommit, apiVersionOffsetCommit, e.b, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	var firstErrCode int16
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		_ = d.string() // name
+		partitionsLen := d.arrayLen()
+		for range partitionsLen {
+			_ = d.int32() // partition_index
+			errCode := d.int16()
+			if errCode != errCodeNone && firstErrCode == errCodeNone {
+				firstErrCode = errCode
+			}
+		}
+	}
+	if d.err != nil {
+		return 0, fmt.Errorf("cannot decode OffsetCommit response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	return firstErrCode, nil
+}
+
+// Special timestamps for listOffsets.
+const (
+	listOffsetsLatest   = -1
+	listOffsetsEarliest = -2
+)
+
+// listOffsets returns offsets for the given tps at the given timestamp.
+//
+// The timestamp can be either listOffsetsLatest or listOffsetsEarliest.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_ListOffsets
+func (bc *brokerConn) listOffsets(tps []topicPartition, timestamp int64) (map[topicPartition]int64, error) {
+	topics, m := groupTopicPartitions(tps)
+
+	var e encoder
+	e.int32(-1) // replica_id
+	e.arrayLen(len(topics))
+	for _, topic := range topics {
+		e.string(topic)
+		partitions := m[topic]
+		e.arrayLen(len(partitions))
+		for _, partition := range partitions {
+			e.int32(partition)
+			e.int64(timestamp)
+		}
+	}
+
+	resp, err := bc.roundTrip(apiKeyListOffsets, apiVersionListOffsets, e.b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	offsets := make(map[topicPartition]int64, len(tps))
+	var firstErr error
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		topic := d.string()
+		partitionsLen := d.arrayLen()
+		for range partitionsLen {
+			partition := d.int32()
+			errCode := d.int16()
+			_ = d.int64() // timestamp
+			offset := d.int64()
+			tp := topicPartition{
+				topic:     topic,
+				partition: partition,
+			}
+			if errCode != errCodeNone && firstErr == nil {
+				firstErr = fmt.Errorf("cannot list offsets for %s: %w", tp, kafkaError(errCode))
+			}
+			offsets[tp] = offset
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode ListOffsets response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return offsets, nil
+}
+
+type fetchPartitionResponse struct {
+	tp            topicPartition
+	errCode       int16
+	highWatermark int64
+
+	// records contains record batches for the partition.
+	//
+	// It refers to the response buffer, so it is valid until the next request to the broker.
+	records []byte
+}
+
+// fetch fetches records for the given tps starting from the given offsets.
+//
+// The returned responses are valid until the next request to the broker.
+//
+// See https://kafka.apache.org/protocol.html#The_Messages_Fetch
+func (bc *brokerConn) fetch(tps []topicPartition, offsets map[topicPartition]int64, maxWait time.Duration, maxBytes, maxPartitionBytes int32) ([]fetchPartitionResponse, error) {
+	topics, m := groupTopicPartitions(tps)
+
+	var e encoder
+	e.int32(-1) // replica_id
+	e.int32(int32(maxWait.Milliseconds()))
+	e.int32(1) // min_bytes
+	e.int32(maxBytes)
+	e.int8(0) // isolation_level=READ_UNCOMMITTED
+	e.arrayLen(len(topics))
+	for _, topic := range topics {
+		e.string(topic)
+		partitions := m[topic]
+		e.arrayLen(len(partitions))
+		for _, partition := range partitions {
+			tp := topicPartition{
+				topic:     topic,
+				partition: partition,
+			}
+			e.int32(partition)
+			e.int64(offsets[tp])
+			e.int32(maxPartitionBytes)
+		}
+	}
+
+	resp, err := bc.roundTrip(apiKeyFetch, apiVersionFetch, e.b, maxWait)
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{
+		b: resp,
+	}
+	var fprs []fetchPartitionResponse
+	_ = d.int32() // throttle_time_ms
+	topicsLen := d.arrayLen()
+	for range topicsLen {
+		topic := d.string()
+		partitionsLen := d.arrayLen()
+		for range partitionsLen {
+			var fpr fetchPartitionResponse
+			fpr.tp = topicPartition{
+				topic:     topic,
+				partition: d.int32(),
+			}
+			fpr.errCode = d.int16()
+			fpr.highWatermark = d.int64()
+			_ = d.int64() // last_stable_offset
+			abortedTransactionsLen := d.arrayLen()
+			for range abortedTransactionsLen {
+				_ = d.int64() // producer_id
+				_ = d.int64() // first_offset
+			}
+			fpr.records = d.bytes()
+			fprs = append(fprs, fpr)
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("cannot decode Fetch response from Kafka broker %q: %w", bc.addr, d.err)
+	}
+	return fprs, nil
+}
@@ -0,0 +1,295 @@
+package kafkaconsumer
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Kafka API keys used by the consumer.
+//
+// See https://kafka.apache.org/protocol.html#protocol_api_keys
+const (
+	apiKeyFetch           = 1
+	apiKeyListOffsets     = 2
+	apiKeyMetadata        = 3
+	apiKeyOffsetCommit    = 8
+	apiKeyOffsetFetch     = 9
+	apiKeyFindCoordinator = 10
+	apiKeyJoinGroup       = 11
+	apiKeyHeartbeat       = 12
+	apiKeyLeaveGroup      = 13
+	apiKeySyncGroup       = 14
+)
+
+// Kafka error codes handled by the consumer.
+//
+// See https://kafka.apache.org/protocol.html#protocol_error_codes
+const (
+	errCodeNone                      = 0
+	errCodeOffsetOutOfRange          = 1
+	errCodeUnknownTopicOrPartition   = 3
+	errCodeLeaderNotAvailable        = 5
+	errCodeNotLeaderForPartition     = 6
+	errCodeCoordinatorLoadInProgress = 14
+	errCodeCoordinatorNotAvailable   = 15
+	errCodeNotCoordinator            = 16
+	errCodeIllegalGeneration         = 22
+	errCodeUnknownMemberID           = 25
+	errCodeRebalanceInProgress       = 27
+)
+
+// kafkaError is an error code returned by Kafka broker.
+type kafkaError int16
+
+func (ke kafkaError) Error() string {
+	if s, ok := kafkaErrorNames[int16(ke)]; ok {
+		return fmt.Sprintf("kafka error %d (%s)", int16(ke), s)
+	}
+	return fmt.Sprintf("kafka error %d; see https://kafka.apache.org/protocol.html#protocol_error_codes", int16(ke))
+}
+
+var kafkaErrorNames = map[int16]string{
+	errCodeOffsetOutOfRange:          "OFFSET_OUT_OF_RANGE",
+	errCodeUnknownTopicOrPartition:   "UNKNOWN_TOPIC_OR_PARTITION",
+	errCodeLeaderNotAvailable:        "LEADER_NOT_AVAILABLE",
+	errCodeNotLeaderForPartition:     "NOT_LEADER_OR_FOLLOWER",
+	errCodeCoordinatorLoadInProgress: "COORDINATOR_LOAD_IN_PROGRESS",
+	errCodeCoordinatorNotAvailable:   "COORDINATOR_NOT_AVAILABLE",
+	errCodeNotCoordinator:            "NOT_COORDINATOR",
+	errCodeIllegalGeneration:         "ILLEGAL_GENERATION",
+	errCodeUnknownMemberID:           "UNKNOWN_MEMBER_ID",
+	errCodeRebalanceInProgress:       "REBALANCE_IN_PROGRESS",
+}
+
+// isCoordinatorError returns true if the group coordinator must be re-discovered after the given error code.
+func isCoordinatorError(code int16) bool {
+	switch code {
+	case errCodeCoordinatorLoadInProgress, errCodeCoordinatorNotAvailable, errCodeNotCoordinator:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRejoinGroupError returns true if the consumer must re-join the group after the given error code.
+func isRejoinGroupError(code int16) bool {
+	switch code {
+	case errCodeRebalanceInProgress, errCodeIllegalGeneration, errCodeUnknownMemberID:
+		return true
+	default:
+		return false
+	}
+}
+
+// encoder encodes Kafka protocol primitive types.
+//
+// See https://kafka.apache.org/protocol.html#protocol_types
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.b = append(e.b, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.b = binary.BigEndian.AppendUint16(e.b, uint16(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.b = binary.BigEndian.AppendUint32(e.b, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.b = binary.BigEndian.AppendUint64(e.b, uint64(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.int8(1)
+	} else {
+		e.int8(0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// nullString encodes null string.
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) arrayLen(n int) {
+	e.int32(int32(n))
+}
+
+func (e *encoder) strings(a []string) {
+	e.arrayLen(len(a))
+	for _, s := range a {
+		e.string(s)
+	}
+}
+
+func (e *encoder) int32s(a []int32) {
+	e.arrayLen(len(a))
+	for _, v := range a {
+		e.int32(v)
+	}
+}
+
+// decoder decodes Kafka protocol primitive types.
+//
+// The first decoding error is stored in err. All the subsequent calls return zero values after the error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) setErr(what string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("cannot decode %s: unexpected end of data", what)
+	}
+	d.b = nil
+}
+
+func (d *decoder) int8() int8 {
+	if len(d.b) < 1 {
+		d.setErr("int8")
+		return 0
+	}
+	v := int8(d.b[0])
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) int16() int16 {
+	if len(d.b) < 2 {
+		d.setErr("int16")
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(d.b))
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) int32() int32 {
+	if len(d.b) < 4 {
+		d.setErr("int32")
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(d.b))
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *decoder) int64() int64 {
+	if len(d.b) < 8 {
+		d.setErr("int64")
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+// string decodes string or nullable string. Null string is decoded as empty string.
+func (d *decoder) string() string {
+	n := int(d.int16())
+	if n < 0 {
+		return ""
+	}
+	if len(d.b) < n {
+		d.setErr("string")
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// bytes decodes bytes or nullable bytes. Null bytes are decoded as nil.
+//
+// The returned bytes refer to the decoded data.
+func (d *decoder) bytes() []byte {
+	n := int(d.int32())
+	if n < 0 {
+		return nil
+	}
+	if len(d.b) < n {
+		d.setErr("bytes")
+		return nil
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	return b
+}
+
+// arrayLen decodes array length. Null array is decoded as zero length.
+func (d *decoder) arrayLen() int {
+	n := int(d.int32())
+	if n < 0 {
+		return 0
+	}
+	// Every array item occupies at least one byte. This protects from huge allocations on malformed data.
+	if n > len(d.b) {
+		d.setErr("array")
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) strings() []string {
+	n := d.arrayLen()
+	a := make([]string, 0, n)
+	for range n {
+		a = append(a, d.string())
+	}
+	return a
+}
+
+func (d *decoder) int32s() []int32 {
+	n := d.arrayLen()
+	a := make([]int32, 0, n)
+	for range n {
+		a = append(a, d.int32())
+	}
+	return a
+}
+
+// varint decodes zigzag-encoded variable-length integer used in record batches.
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.setErr("varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// varBytes decodes bytes with varint length used in record batches. Null bytes are decoded as nil.
+func (d *decoder) varBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	if int64(len(d.b)) < n {
+		d.setErr("varBytes")
+		return nil
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	return b
+}
@@ -0,0 +1,257 @@
+package kafkaconsumer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/gzip"
+)
+
+// maxDecompressedBatchSize is the maximum size of decompressed records in a single record batch.
+const maxDecompressedBatchSize = 256 * 1024 * 1024
+
+// Record batch attributes.
+//
+// See https://kafka.apache.org/documentation/#recordbatch
+const (
+	batchAttrCompressionMask = 0x07
+	batchAttrLogAppendTime   = 0x08
+	batchAttrControl         = 0x20
+)
+
+// Compression codecs for record batches.
+const (
+	compressionNone   = 0
+	compressionGzip   = 1
+	compressionSnappy = 2
+	compressionLZ4    = 3
+	compressionZstd   = 4
+)
+
+// recordBatchHeaderSize is the size of the record batch header up to and including the records count.
+const recordBatchHeaderSize = 61
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// record is a single Kafka record.
+type record struct {
+	offset int64
+
+	// timestamp is the record timestamp in milliseconds.
+	timestamp int64
+
+	key   []byte
+	value []byte
+}
+
+// recordsDecoder decodes Kafka record batches.
+type recordsDecoder struct {
+	// buf is used for decompressing records.
+	buf []byte
+}
+
+// decodeRecordBatches calls f for every record with offset >= minOffset in record batches at src.
+//
+// It returns the offset for the next fetch request. The returned offset equals to minOffset if src doesn't contain complete record batches.
+//
+// src may end with a partial record batch, which is ignored. See https://kafka.apache.org/protocol.html#The_Messages_Fetch
+//
+// f mustn't hold references to r after returning.
+func (rd *recordsDecoder) decodeRecordBatches(src []byte, minOffset int64, f func(r *record)) (int64, error) {
+	nextOffset := minOffset
+	for len(src) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(src))
+		batchLen := int32(binary.BigEndian.Uint32(src[8:]))
+		if batchLen < 0 {
+			return nextOffset, fmt.Errorf("unexpected negative record batch length %d at offset %d", batchLen, baseOffset)
+		}
+		if len(src) < 12+int(batchLen) {
+			// Partial record batch
+			break
+		}
+		batch := src[12 : 12+batchLen]
+		src = src[12+batchLen:]
+
+		lastOffset, err := rd.decodeRecordBatch(baseOffset, batch, minOffset, f)
+		if err != nil {
+			return nextOffset, fmt.Errorf("cannot decode record batch at offset %d: %w", baseOffset, err)
+		}
+		if lastOffset+1 > nextOffset {
+			nextOffset = lastOffset + 1
+		}
+	}
+	return nextOffset, nil
+}
+
+// decodeRecordBatch decodes a single record batch with the given baseOffset and returns the last offset in the batch.
+func (rd *recordsDecoder) decodeRecordBatch(baseOffset int64, batch []byte, minOffset int64, f func(r *record)) (int64, error) {
+	if len(batch) < recordBatchHeaderSize-12 {
+		return 0, fmt.Errorf("too short record batch: %d bytes", len(batch))
+	}
+	d := decoder{
+		b: batch,
+	}
+	_ = d.int32() // partitionLeaderEpoch
+	magic := d.int8()
+	if magic != 2 {
+		return 0, fmt.Errorf("unsupported record batch format with magic=%d; only magic=2 is supported; it is used by Kafka 0.11 and newer", magic)
+	}
+	crc := uint32(d.int32())
+	if crcExpected := crc32.Checksum(d.b, crc32cTable); crc != crcExpected {
+		return 0, fmt.Errorf("unexpected record batch crc; got %d; want %d", crc, crcExpected)
+	}
+	attributes := d.int16()
+	lastOffsetDelta := d.int32()
+	baseTimestamp := d.int64()
+	maxTimestamp := d.int64()
+	_ = d.int64() // producerId
+	_ = d.int16() // producerEpoch
+	_ = d.int32() // baseSequence
+	recordsCount := d.int32()
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	lastOffset := baseOffset + int64(lastOffsetDelta)
+	if attributes&batchAttrControl != 0 || lastOffset < minOffset {
+		// Skip control batches and batches with already processed records
+		return lastOffset, nil
+	}
+
+	data, err := rd.decompress(int(attributes&batchAttrCompressionMask), d.b)
+	if err != nil {
+		return 0, err
+	}
+
+	d = decoder{
+		b: data,
+	}
+	var r record
+	for range recordsCount {
+		recordLen := d.varint()
+		if d.err != nil {
+			return 0, d.err
+		}
+		if recordLen < 0 || recordLen > int64(len(d.b)) {
+			return 0, fmt.Errorf("unexpected record length %d; remaining data length: %d bytes", recordLen, len(d.b))
+		}
+		rdec := decoder{
+			b: d.b[:recordLen],
+		}
+		d.b = d.b[recordLen:]
+
+		_ = rdec.int8() // attributes
+		timestampDelta := rdec.varint()
+		offsetDelta := rdec.varint()
+		r.key = rdec.varBytes()
+		r.value = rdec.varBytes()
+		// headers are ignored
+		if rdec.err != nil {
+			return 0, fmt.Errorf("cannot decode record: %w", rdec.err)
+		}
+
+		r.offset = baseOffset + offsetDelta
+		if r.offset < minOffset {
+			continue
+		}
+		if attributes&batchAttrLogAppendTime != 0 {
+			r.timestamp = maxTimestamp
+		} else {
+			r.timestamp = baseTimestamp + timestampDelta
+		}
+		f(&r)
+	}
+	return lastOffset, nil
+}
+
+func (rd *recordsDecoder) decompress(codec int, src []byte) ([]byte, error) {
+	var err error
+	switch codec {
+	case compressionNone:
+		return src, nil
+	case compressionGzip:
+		rd.buf, err = decompressGzip(rd.buf[:0], src)
+	case compressionSnappy:
+		rd.buf, err = decompressSnappy(rd.buf[:0], src)
+	case compressionZstd:
+		rd.buf, err = zstd.DecompressLimited(rd.buf[:0], src, maxDecompressedBatchSize)
+	case compressionLZ4:
+		return nil, fmt.Errorf("lz4 compression isn't supported; use gzip, snappy or zstd compression at Kafka producers")
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %d", codec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress records: %w", err)
+	}
+	return rd.buf, nil
+}
+
+func decompressGzip(dst, src []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return dst, err
+	}
+	bb := bytes.NewBuffer(dst)
+	n, err := io.Copy(bb, io.LimitReader(zr, maxDecompressedBatchSize+1))
+	if err != nil {
+		return dst, err
+	}
+	if n > maxDecompressedBatchSize {
+		return dst, fmt.Errorf("too big decompressed data; it mustn't exceed %d bytes", maxDecompressedBatchSize)
+	}
+	return bb.Bytes(), nil
+}
+
+// xerialSnappyHeader is the header for snappy-compressed data produced by Java Kafka clients.
+//
+// See https://github.com/xerial/snappy-java
+var xerialSnappyHeader = []byte("\x82SNAPPY\x00")
+
+func decompressSnappy(dst, src []byte) ([]byte, error) {
+	if !bytes.HasPrefix(src, xerialSnappyHeader) {
+		return decodeSnappyBlock(dst, src)
+	}
+
+	// Skip the header, version and compatible version
+	if len(src) < 16 {
+		return dst, fmt.Errorf("too short xerial snappy header")
+	}
+	src = src[16:]
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return dst, fmt.Errorf("cannot read xerial snappy block size")
+		}
+		n := int(binary.BigEndian.Uint32(src))
+		src = src[4:]
+		if n > len(src) {
+			return dst, fmt.Errorf("too big xerial snappy block size: %d bytes; remaining data: %d bytes", n, len(src))
+		}
+		var err error
+		dst, err = decodeSnappyBlock(dst, src[:n])
+		if err != nil {
+			return dst, err
+		}
+		src = src[n:]
+	}
+	return dst, nil
+}
+
+func decodeSnappyBlock(dst, src []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return dst, err
+	}
+	if len(dst)+n > maxDecompressedBatchSize {
+		return dst, fmt.Errorf("too big decompressed data; it mustn't exceed %d bytes", maxDecompressedBatchSize)
+	}
+	b, err := snappy.Decode(nil, src)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
@@ -0,0 +1,151 @@
+package kafkaconsumer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestDecodeRecordBatches(t *testing.T) {
+	f := func(src []byte, minOffset int64, resultExpected []string, nextOffsetExpected int64) {
+		t.Helper()
+
+		var rd recordsDecoder
+		var result []string
+		nextOffset, err := rd.decodeRecordBatches(src, minOffset, func(r *record) {
+			result = append(result, fmt.Sprintf("%d:%d:%s", r.offset, r.timestamp, r.value))
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected records;\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+		if nextOffset != nextOffsetExpected {
+			t.Fatalf("unexpected next offset; got %d; want %d", nextOffset, nextOffsetExpected)
+		}
+	}
+
+	records := []fakeRecord{
+		{
+			timestamp: 1000,
+			value:     "foo",
+		},
+		{
+			timestamp: 1005,
+			value:     "bar",
+		},
+	}
+
+	// empty data
+	f(nil, 10, nil, 10)
+
+	// single batch
+	batch := appendRecordBatch(nil, 10, records)
+	f(batch, 10, []string{"10:1000:foo", "11:1005:bar"}, 12)
+
+	// skip already processed records
+	f(batch, 11, []string{"11:1005:bar"}, 12)
+
+	// multiple batches
+	data := appendRecordBatch(batch, 12, records[:1])
+	f(data, 10, []string{"10:1000:foo", "11:1005:bar", "12:1000:foo"}, 13)
+
+	// partial batch at the end
+	data = appendRecordBatch(nil, 10, records)
+	data = append(data, appendRecordBatch(nil, 12, records)[:20]...)
+	f(data, 10, []string{"10:1000:foo", "11:1005:bar"}, 12)
+
+	// partial batch only
+	f(batch[:len(batch)-1], 10, nil, 10)
+
+	// control batch
+	data = appendRawRecordBatch(nil, 10, batchAttrControl, 1000, 1000, 2, marshalFakeRecords(records))
+	f(data, 10, nil, 12)
+
+	// log append time
+	data = appendRawRecordBatch(nil, 10, batchAttrLogAppendTime, 1000, 2000, 2, marshalFakeRecords(records))
+	f(data, 10, []string{"10:2000:foo", "11:2000:bar"}, 12)
+
+	// compressed batches
+	recordsData := marshalFakeRecords(records)
+
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	if _, err := zw.Write(recordsData); err != nil {
+		t.Fatalf("cannot compress data: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close gzip writer: %s", err)
+	}
+	data = appendRawRecordBatch(nil, 10, compressionGzip, 1000, 1005, 2, bb.Bytes())
+	f(data, 10, []string{"10:1000:foo", "11:1005:bar"}, 12)
+
+	data = appendRawRecordBatch(nil, 10, compressionSnappy, 1000, 1005, 2, snappy.Encode(nil, recordsData))
+	f(data, 10, []string{"10:1000:foo", "11:1005:bar"}, 12)
+
+	xerial := append([]byte{}, xerialSnappyHeader...)
+	xerial = binary.BigEndian.AppendUint32(xerial, 1) // version
+	xerial = binary.BigEndian.AppendUint32(xerial, 1) // compatible version
+	for _, chunk := range [][]byte{recordsData[:5], recordsData[5:]} {
+		block := snappy.Encode(nil, chunk)
+		xerial = binary.BigEndian.AppendUint32(xerial, uint32(len(block)))
+		xerial = append(xerial, block...)
+	}
+	data = appendRawRecordBatch(nil, 10, compressionSnappy, 1000, 1005, 2, xerial)
+	f(data, 10, []string{"10:1000:foo", "11:1005:bar"}, 12)
+
+	data = appendRawRecordBatch(nil, 10, compressionZstd, 1000, 1005, 2, zstd.CompressLevel(nil, recordsData, 1))
+	f(data, 10, []string{"10:1000:foo", "11:1005:bar"}, 12)
+}
+
+func TestDecodeRecordBatchesFailure(t *testing.T) {
+	f := func(src []byte, errExpected string) {
+		t.Helper()
+
+		var rd recordsDecoder
+		_, err := rd.decodeRecordBatches(src, 0, func(_ *record) {})
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want it to contain %q", err, errExpected)
+		}
+	}
+
+	records := []fakeRecord{
+		{
+			timestamp: 1000,
+			value:     "foo",
+		},
+	}
+
+	// invalid crc
+	data := appendRecordBatch(nil, 0, records)
+	data[len(data)-1]++
+	f(data, "unexpected record batch crc")
+
+	// unsupported magic
+	data = appendRecordBatch(nil, 0, records)
+	data[16] = 1
+	f(data, "unsupported record batch format with magic=1")
+
+	// unsupported compression
+	data = appendRawRecordBatch(nil, 0, compressionLZ4, 1000, 1000, 1, marshalFakeRecords(records))
+	f(data, "lz4 compression isn't supported")
+
+	// invalid compressed data
+	data = appendRawRecordBatch(nil, 0, compressionZstd, 1000, 1000, 1, []byte("foobar"))
+	f(data, "cannot decompress records")
+
+	// too many records in the batch
+	data = appendRawRecordBatch(nil, 0, compressionNone, 1000, 1000, 2, marshalFakeRecords(records))
+	f(data, "cannot decode varint")
+}
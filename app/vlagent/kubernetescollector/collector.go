@@ -15,6 +15,7 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/filecollector"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/remotewrite"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 )
@@ -34,7 +35,7 @@ type kubernetesCollector struct {
 	// This directory contains symlinks with specific filenames to actual files.
 	logsPath string
 
-	fileCollector *fileCollector
+	fileCollector *filecollector.Collector
 }
 
 // startKubernetesCollector starts watching Kubernetes cluster on the given node and starts collecting container logs.
@@ -63,10 +64,10 @@ func startKubernetesCollector(client *kubeAPIClient, currentNodeName, logsPath,
 	}
 
 	storage := &remotewrite.Storage{}
-	newProcessor := func(commonFields []logstorage.Field) processor {
+	newProcessor := func(commonFields []logstorage.Field) filecollector.Processor {
 		return newLogFileProcessor(storage, commonFields)
 	}
-	fc := startFileCollector(checkpointsPath, excludeFilter, newProcessor)
+	fc := filecollector.StartCollector(checkpointsPath, excludeFilter, newProcessor)
 	kc.fileCollector = fc
 
 	pl, err := client.getNodePods(ctx, currentNodeName)
@@ -80,7 +81,7 @@ func startKubernetesCollector(client *kubeAPIClient, currentNodeName, logsPath,
 		kc.startReadPodLogs(pod)
 	}
 	// Cleanup checkpoints for deleted Pods.
-	fc.cleanupCheckpoints()
+	fc.CleanupCheckpoints()
 
 	// Begin watching for new Pods and start reading their logs.
 	kc.wg.Go(func() {
@@ -95,15 +96,15 @@ func startKubernetesCollector(client *kubeAPIClient, currentNodeName, logsPath,
 func (kc *kubernetesCollector) watchForPodsUpdates(ctx context.Context, resourceVersion string) {
 	currentNodeName := kc.currentNode.Metadata.Name
 
-	bt := newBackoffTimer(time.Millisecond*200, time.Second*30)
-	defer bt.stop()
+	bt := filecollector.NewBackoffTimer(time.Millisecond*200, time.Second*30)
+	defer bt.Stop()
 
 	errorFired := false
 
 	handleEvent := func(event watchEvent) error {
 		switch event.Type {
 		case "ADDED", "MODIFIED":
-			bt.reset()
+			bt.Reset()
 
 			if errorFired {
 				logger.Infof("successfully re-established watching Pods on Node %q", currentNodeName)
@@ -156,8 +157,8 @@ func (kc *kubernetesCollector) watchForPodsUpdates(ctx context.Context, resource
 
 			errorFired = true
 
-			logger.Errorf("failed to start watching Pods on node %q: %s; will retry in %s", currentNodeName, err, bt.currentDelay())
-			bt.wait(stopCh)
+			logger.Errorf("failed to start watching Pods on node %q: %s; will retry in %s", currentNodeName, err, bt.CurrentDelay())
+			bt.Wait(stopCh)
 			continue
 		}
 
@@ -179,8 +180,8 @@ func (kc *kubernetesCollector) watchForPodsUpdates(ctx context.Context, resource
 
 			errorFired = true
 
-			logger.Errorf("failed to read Pod events from the Kubernetes API: %s; will retry in %s", err, bt.currentDelay())
-			bt.wait(stopCh)
+			logger.Errorf("failed to read Pod events from the Kubernetes API: %s; will retry in %s", err, bt.CurrentDelay())
+			bt.Wait(stopCh)
 			continue
 		}
 	}
@@ -192,7 +193,7 @@ func (kc *kubernetesCollector) startReadPodLogs(pod pod) {
 
 		filePath := kc.getLogFilePath(pod, pc, cs)
 
-		kc.fileCollector.startRead(filePath, commonFields)
+		kc.fileCollector.StartRead(filePath, commonFields)
 	}
 
 	for _, pc := range pod.Spec.Containers {
@@ -267,5 +268,5 @@ func (kc *kubernetesCollector) getLogFilePath(p pod, pc podContainer, cs contain
 func (kc *kubernetesCollector) stop() {
 	kc.cancel()
 	kc.wg.Wait()
-	kc.fileCollector.stop()
+	kc.fileCollector.Stop()
 }
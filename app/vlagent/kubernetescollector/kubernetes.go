@@ -66,6 +66,8 @@ func Init(tmpDataPath string) {
 		}
 	}
 
+	initMultilineRules()
+
 	kc, err := startKubernetesCollector(c, currentNodeName, *logsPath, path, excludeF)
 	if err != nil {
 		logger.Fatalf("cannot start kubernetes collector: %s", err)
@@ -0,0 +1,162 @@
+package kubernetescollector
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/filecollector"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// Pod annotations for configuring multiline log entries.
+//
+// Every annotation can be suffixed with ".<container_name>" in order to apply it only to the given container.
+// Container-specific annotations have priority over Pod-wide annotations.
+const (
+	multilineStartPatternAnnotation        = "vlagent.victoriametrics.com/multiline-start-pattern"
+	multilineContinuationPatternAnnotation = "vlagent.victoriametrics.com/multiline-continuation-pattern"
+	multilineMaxLinesAnnotation            = "vlagent.victoriametrics.com/multiline-max-lines"
+	multilineTimeoutAnnotation             = "vlagent.victoriametrics.com/multiline-timeout"
+)
+
+// multilineConfig contains multiline settings for a single container.
+type multilineConfig struct {
+	startPattern        string
+	continuationPattern string
+	maxLines            int
+	timeout             time.Duration
+}
+
+// getMultilineRules returns multiline rules for the container with the given commonFields.
+//
+// It returns nil if multiline log entries aren't configured for the container.
+func getMultilineRules(commonFields []logstorage.Field) *filecollector.MultilineRules {
+	cfg, err := getMultilineConfig(commonFields)
+	if err != nil {
+		container, pod, namespace := getContainerInfo(commonFields)
+		logger.Errorf("ignoring invalid multiline config for container %q in Pod %q in namespace %q: %s", container, pod, namespace, err)
+		return nil
+	}
+	if cfg.startPattern == "" && cfg.continuationPattern == "" {
+		return nil
+	}
+
+	mr, err := getCompiledMultilineRules(cfg)
+	if err != nil {
+		container, pod, namespace := getContainerInfo(commonFields)
+		logger.Errorf("ignoring invalid multiline config for container %q in Pod %q in namespace %q: %s", container, pod, namespace, err)
+		return nil
+	}
+	return mr
+}
+
+func getMultilineConfig(commonFields []logstorage.Field) (*multilineConfig, error) {
+	container, _, _ := getContainerInfo(commonFields)
+	getAnnotation := func(name string) (string, bool) {
+		// Container-specific annotation has priority over Pod-wide annotation.
+		if v, ok := getField(commonFields, "kubernetes.pod_annotations."+name+"."+container); ok {
+			return v, true
+		}
+		return getField(commonFields, "kubernetes.pod_annotations."+name)
+	}
+
+	cfg := &multilineConfig{
+		startPattern:        *multilineStartPattern,
+		continuationPattern: *multilineContinuationPattern,
+		maxLines:            *multilineMaxLines,
+		timeout:             *multilineTimeout,
+	}
+
+	startPattern, hasStartPattern := getAnnotation(multilineStartPatternAnnotation)
+	continuationPattern, hasContinuationPattern := getAnnotation(multilineContinuationPatternAnnotation)
+	if hasStartPattern || hasContinuationPattern {
+		// Do not mix patterns from annotations with patterns from command-line flags.
+		cfg.startPattern = startPattern
+		cfg.continuationPattern = continuationPattern
+	}
+
+	if v, ok := getAnnotation(multilineMaxLinesAnnotation); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s=%q: %w", multilineMaxLinesAnnotation, v, err)
+		}
+		cfg.maxLines = n
+	}
+	if v, ok := getAnnotation(multilineTimeoutAnnotation); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s=%q: %w", multilineTimeoutAnnotation, v, err)
+		}
+		cfg.timeout = d
+	}
+
+	return cfg, nil
+}
+
+var (
+	multilineRulesCache     = make(map[multilineConfig]*filecollector.MultilineRules)
+	multilineRulesCacheLock sync.Mutex
+)
+
+// multilineRulesCacheMaxSize is the maximum number of entries in multilineRulesCache.
+//
+// The cache is reset when it reaches this size, since the configs from annotations of already removed Pods
+// could occupy it. Log file processors keep using the rules obtained before the reset.
+const multilineRulesCacheMaxSize = 1000
+
+// getCompiledMultilineRules returns multiline rules for the given cfg.
+//
+// Rules are cached, since the same config is usually shared among many containers.
+func getCompiledMultilineRules(cfg *multilineConfig) (*filecollector.MultilineRules, error) {
+	multilineRulesCacheLock.Lock()
+	defer multilineRulesCacheLock.Unlock()
+
+	if mr, ok := multilineRulesCache[*cfg]; ok {
+		return mr, nil
+	}
+	mr, err := filecollector.NewMultilineRules(cfg.startPattern, cfg.continuationPattern, cfg.maxLines, cfg.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(multilineRulesCache) >= multilineRulesCacheMaxSize {
+		clear(multilineRulesCache)
+	}
+	multilineRulesCache[*cfg] = mr
+	return mr, nil
+}
+
+// initMultilineRules verifies multiline rules set via command-line flags.
+func initMultilineRules() {
+	if *multilineStartPattern == "" && *multilineContinuationPattern == "" {
+		return
+	}
+	cfg := &multilineConfig{
+		startPattern:        *multilineStartPattern,
+		continuationPattern: *multilineContinuationPattern,
+		maxLines:            *multilineMaxLines,
+		timeout:             *multilineTimeout,
+	}
+	if _, err := getCompiledMultilineRules(cfg); err != nil {
+		logger.Fatalf("invalid -kubernetesCollector.multiline* flags: %s", err)
+	}
+}
+
+func getContainerInfo(commonFields []logstorage.Field) (container, pod, namespace string) {
+	container, _ = getField(commonFields, "kubernetes.container_name")
+	pod, _ = getField(commonFields, "kubernetes.pod_name")
+	namespace, _ = getField(commonFields, "kubernetes.pod_namespace")
+	return container, pod, namespace
+}
+
+func getField(fields []logstorage.Field, name string) (string, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
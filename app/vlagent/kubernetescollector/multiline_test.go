@@ -0,0 +1,135 @@
+package kubernetescollector
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestGetMultilineConfig(t *testing.T) {
+	f := func(annotations map[string]string, cfgExpected *multilineConfig) {
+		t.Helper()
+
+		commonFields := []logstorage.Field{
+			{
+				Name:  "kubernetes.container_name",
+				Value: "app",
+			},
+		}
+		for k, v := range annotations {
+			commonFields = append(commonFields, logstorage.Field{
+				Name:  "kubernetes.pod_annotations." + k,
+				Value: v,
+			})
+		}
+
+		cfg, err := getMultilineConfig(commonFields)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(cfg, cfgExpected) {
+			t.Fatalf("unexpected config;\ngot\n%+v\nwant\n%+v", cfg, cfgExpected)
+		}
+	}
+
+	// no annotations
+	f(nil, &multilineConfig{
+		maxLines: 500,
+		timeout:  time.Second,
+	})
+
+	// Pod-wide annotations
+	f(map[string]string{
+		"vlagent.victoriametrics.com/multiline-start-pattern": `^\d`,
+		"vlagent.victoriametrics.com/multiline-max-lines":     "10",
+		"vlagent.victoriametrics.com/multiline-timeout":       "5s",
+	}, &multilineConfig{
+		startPattern: `^\d`,
+		maxLines:     10,
+		timeout:      5 * time.Second,
+	})
+
+	// container-specific annotations override Pod-wide annotations
+	f(map[string]string{
+		"vlagent.victoriametrics.com/multiline-start-pattern":            `^\d`,
+		"vlagent.victoriametrics.com/multiline-continuation-pattern.app": `^\s`,
+		"vlagent.victoriametrics.com/multiline-start-pattern.other":      `^foo`,
+	}, &multilineConfig{
+		startPattern:        `^\d`,
+		continuationPattern: `^\s`,
+		maxLines:            500,
+		timeout:             time.Second,
+	})
+}
+
+func TestGetMultilineConfigFailure(t *testing.T) {
+	f := func(annotation, value, errExpected string) {
+		t.Helper()
+
+		commonFields := []logstorage.Field{
+			{
+				Name:  "kubernetes.pod_annotations." + annotation,
+				Value: value,
+			},
+		}
+		_, err := getMultilineConfig(commonFields)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want it to contain %q", err, errExpected)
+		}
+	}
+
+	f(multilineMaxLinesAnnotation, "foo", "cannot parse")
+	f(multilineTimeoutAnnotation, "foo", "cannot parse")
+}
+
+func TestGetMultilineRules(t *testing.T) {
+	// Invalid regexp must disable multiline mode for the container
+	commonFields := []logstorage.Field{
+		{
+			Name:  "kubernetes.pod_annotations." + multilineStartPatternAnnotation,
+			Value: "(",
+		},
+	}
+	if mr := getMultilineRules(commonFields); mr != nil {
+		t.Fatalf("expecting nil rules for invalid regexp")
+	}
+
+	// The same config must return the same rules
+	commonFields = []logstorage.Field{
+		{
+			Name:  "kubernetes.pod_annotations." + multilineStartPatternAnnotation,
+			Value: `^\S`,
+		},
+	}
+	mr1 := getMultilineRules(commonFields)
+	mr2 := getMultilineRules(commonFields)
+	if mr1 == nil || mr1 != mr2 {
+		t.Fatalf("expecting the same non-nil rules for the same config")
+	}
+
+	// The cache size must be limited for distinct configs
+	for i := 0; i < 2*multilineRulesCacheMaxSize; i++ {
+		commonFields = []logstorage.Field{
+			{
+				Name:  "kubernetes.pod_annotations." + multilineStartPatternAnnotation,
+				Value: fmt.Sprintf(`^pod_%d`, i),
+			},
+		}
+		if mr := getMultilineRules(commonFields); mr == nil {
+			t.Fatalf("expecting non-nil rules for valid config")
+		}
+	}
+	multilineRulesCacheLock.Lock()
+	n := len(multilineRulesCache)
+	multilineRulesCacheLock.Unlock()
+	if n > multilineRulesCacheMaxSize {
+		t.Fatalf("unexpected number of cached rules; got %d; want up to %d", n, multilineRulesCacheMaxSize)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/valyala/fastjson"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/filecollector"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 )
@@ -45,6 +46,15 @@ var (
 		"Even this setting is disabled, Node labels are available for filtering via -kubernetes.excludeFilter flag")
 	includeNodeAnnotations = flag.Bool("kubernetesCollector.includeNodeAnnotations", false, "Include Node annotations as additional fields in the log entries. "+
 		"Even this setting is disabled, Node annotations are available for filtering via -kubernetes.excludeFilter flag")
+
+	multilineStartPattern = flag.String("kubernetesCollector.multilineStartPattern", "", "Optional regexp matching the first line of multiline log entries in container logs. "+
+		"It can be overridden per container via Pod annotations. See https://docs.victoriametrics.com/victorialogs/vlagent/#multiline-kubernetes-logs")
+	multilineContinuationPattern = flag.String("kubernetesCollector.multilineContinuationPattern", "", "Optional regexp matching the non-first lines of multiline log entries in container logs. "+
+		"It can be overridden per container via Pod annotations. See https://docs.victoriametrics.com/victorialogs/vlagent/#multiline-kubernetes-logs")
+	multilineMaxLines = flag.Int("kubernetesCollector.multilineMaxLines", 500, "The maximum number of lines in a single multiline log entry in container logs. "+
+		"See https://docs.victoriametrics.com/victorialogs/vlagent/#multiline-kubernetes-logs")
+	multilineTimeout = flag.Duration("kubernetesCollector.multilineTimeout", time.Second, "The duration after which the last multiline log entry is flushed if no new lines are written by the container. "+
+		"See https://docs.victoriametrics.com/victorialogs/vlagent/#multiline-kubernetes-logs")
 )
 
 type logFileProcessor struct {
@@ -63,11 +73,22 @@ type logFileProcessor struct {
 	partialCRIContent *bytesutil.ByteBuffer
 	// partialCRIContentSize tracks the actual size of the partialCRIContent.
 	partialCRIContentSize int
+
+	// mm merges multiple lines into a single log entry if multiline rules are configured for the container.
+	mm *filecollector.MultilineMerger
+	// pendingTimestamp is the Container Runtime timestamp of the first line of the pending multiline log entry.
+	pendingTimestamp int64
+
+	// entryStart is set to true if the line passed to the last TryAddLine call starts a new pending log entry.
+	entryStart bool
 }
 
 // newLogFileProcessor returns a new logFileProcessor for the given storage.
 // commonFields must not be modified as they can be accessed from multiple goroutines.
 func newLogFileProcessor(storage insertutil.LogRowsStorage, commonFields []logstorage.Field) *logFileProcessor {
+	// Obtain multiline rules before excluding Pod annotations from commonFields.
+	mr := getMultilineRules(commonFields)
+
 	// Exclude labels or annotations if they should not be included.
 	if !*includePodLabels || !*includePodAnnotations || !*includeNodeLabels || !*includeNodeAnnotations {
 		var fields []logstorage.Field
@@ -89,17 +110,23 @@ func newLogFileProcessor(storage insertutil.LogRowsStorage, commonFields []logst
 	const defaultMsgValue = "missing _msg field; see https://docs.victoriametrics.com/victorialogs/keyconcepts/#message-field"
 	lr := logstorage.GetLogRows(sfs, *ignoreFields, *decolorizeFields, efs, defaultMsgValue)
 
-	return &logFileProcessor{
+	lfp := &logFileProcessor{
 		storage:      storage,
 		lr:           lr,
 		tenantID:     getTenantID(),
 		commonFields: commonFields,
 	}
+	if mr != nil {
+		lfp.mm = filecollector.NewMultilineMerger(mr, lfp.flushMultilineEntry)
+	}
+	return lfp
 }
 
-func (lfp *logFileProcessor) tryAddLine(logLine []byte) bool {
+func (lfp *logFileProcessor) TryAddLine(logLine []byte) bool {
+	lfp.entryStart = false
+
 	if len(logLine) == 0 {
-		return true
+		return !lfp.hasPending()
 	}
 
 	if logLine[0] == '{' {
@@ -113,9 +140,9 @@ func (lfp *logFileProcessor) tryAddLine(logLine []byte) bool {
 			logger.Panicf("FATAL: cannot parse 'json-file' log content: %s; content: %q", err, logLine)
 		}
 
-		lfp.addLineInternal(criLine.timestamp, criLine.content)
+		lfp.addContent(criLine.timestamp, criLine.content, true)
 
-		return true
+		return !lfp.hasPending()
 	}
 
 	criLine, err := parseCRILine(logLine)
@@ -123,25 +150,70 @@ func (lfp *logFileProcessor) tryAddLine(logLine []byte) bool {
 		logger.Panicf("FATAL: cannot parse Container Runtime Interface log line: %s; content: %q", err, logLine)
 	}
 
+	isFirstPart := lfp.partialCRIContentSize == 0
 	timestamp, content, ok := lfp.joinPartialLines(criLine)
 	if !ok {
 		// The log content is not yet complete.
+		lfp.entryStart = isFirstPart && (lfp.mm == nil || !lfp.mm.HasPending())
 		return false
 	}
 	if len(content) == 0 {
 		// The log content is truncated or empty.
 		// Skip such lines.
-		return true
+		return !lfp.hasPending()
 	}
 
-	lfp.addLineInternal(timestamp, content)
+	lfp.addContent(timestamp, content, isFirstPart)
 
 	if lfp.partialCRIContent != nil {
 		partialCRIContentBufPool.Put(lfp.partialCRIContent)
 		lfp.partialCRIContent = nil
 	}
 
-	return true
+	return !lfp.hasPending()
+}
+
+// addContent adds the log content written by Container Runtime at the given timestamp.
+//
+// isSingleLine must be set to true if the content is obtained from a single line of the log file.
+func (lfp *logFileProcessor) addContent(timestamp int64, content []byte, isSingleLine bool) {
+	if lfp.mm == nil {
+		lfp.addLineInternal(timestamp, content)
+		return
+	}
+
+	// The previous multiline log entry may be flushed by AddLine, so update pendingTimestamp after the call.
+	lfp.mm.AddLine(content, time.Now())
+	if lfp.mm.IsEntryStart() {
+		lfp.pendingTimestamp = timestamp
+		lfp.entryStart = isSingleLine
+	}
+}
+
+func (lfp *logFileProcessor) flushMultilineEntry(entry []byte) {
+	lfp.addLineInternal(lfp.pendingTimestamp, entry)
+}
+
+// hasPending returns true if the processor has log lines, which aren't flushed yet.
+func (lfp *logFileProcessor) hasPending() bool {
+	return lfp.partialCRIContentSize > 0 || lfp.mm != nil && lfp.mm.HasPending()
+}
+
+// IsEntryStart implements filecollector.MultilineProcessor interface.
+func (lfp *logFileProcessor) IsEntryStart() bool {
+	return lfp.entryStart
+}
+
+// FlushPending implements filecollector.MultilineProcessor interface.
+func (lfp *logFileProcessor) FlushPending() bool {
+	if lfp.partialCRIContentSize > 0 {
+		// Container Runtime didn't finish writing the log line yet.
+		return false
+	}
+	if lfp.mm == nil {
+		return true
+	}
+	return lfp.mm.FlushIfExpired(time.Now())
 }
 
 func (lfp *logFileProcessor) joinPartialLines(criLine criLine) (int64, []byte, bool) {
@@ -154,7 +226,7 @@ func (lfp *logFileProcessor) joinPartialLines(criLine criLine) (int64, []byte, b
 		}
 
 		lfp.partialCRIContentSize += len(criLine.content)
-		if lfp.partialCRIContentSize <= maxLogLineSize {
+		if lfp.partialCRIContentSize <= filecollector.MaxLogLineSize {
 			lfp.partialCRIContent.MustWrite(criLine.content)
 		}
 		return 0, nil, false
@@ -168,7 +240,7 @@ func (lfp *logFileProcessor) joinPartialLines(criLine criLine) (int64, []byte, b
 	// The final part of the split log line received.
 
 	lfp.partialCRIContentSize += len(criLine.content)
-	if lfp.partialCRIContentSize > maxLogLineSize {
+	if lfp.partialCRIContentSize > filecollector.MaxLogLineSize {
 		// Discard the too large log line.
 		reportLogRowSizeExceeded(lfp.commonFields, lfp.partialCRIContentSize)
 
@@ -408,7 +480,11 @@ func fieldIndex(fields []logstorage.Field, names []string) int {
 	return -1
 }
 
-func (lfp *logFileProcessor) mustClose() {
+func (lfp *logFileProcessor) MustClose() {
+	if lfp.mm != nil {
+		lfp.mm.Flush()
+	}
+
 	logstorage.PutLogRows(lfp.lr)
 	lfp.lr = nil
 }
@@ -597,5 +673,5 @@ func reportLogRowSizeExceeded(commonFields []logstorage.Field, size int) {
 		}
 	}
 	logger.Warnf("skipping log entry from Pod %q in namespace %q: entry size of %.2f MiB exceeds the maximum allowed size of %d MiB",
-		pod, namespace, float64(size)/1024/1024, maxLogLineSize/1024/1024)
+		pod, namespace, float64(size)/1024/1024, filecollector.MaxLogLineSize/1024/1024)
 }
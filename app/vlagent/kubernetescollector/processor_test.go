@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/filecollector"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 )
 
@@ -17,7 +18,7 @@ func TestProcessor(t *testing.T) {
 		proc := newLogFileProcessor(storage, nil)
 
 		for _, s := range in {
-			proc.tryAddLine([]byte(s))
+			proc.TryAddLine([]byte(s))
 		}
 
 		expected := strings.Join(resultsExpected, "\n")
@@ -73,8 +74,8 @@ func TestProcessor(t *testing.T) {
 	f(in, expectedContents)
 
 	// Max log line size
-	firstLine := strings.Repeat("a", maxLogLineSize/2-len("2025-10-16T15:37:36Z stderr P "))
-	secondLine := strings.Repeat("b", maxLogLineSize/2-len("2025-10-16T15:37:36.330062387Z stderr F "))
+	firstLine := strings.Repeat("a", filecollector.MaxLogLineSize/2-len("2025-10-16T15:37:36Z stderr P "))
+	secondLine := strings.Repeat("b", filecollector.MaxLogLineSize/2-len("2025-10-16T15:37:36.330062387Z stderr F "))
 	in = []string{
 		`2025-10-16T15:37:36Z stderr P ` + firstLine,
 		`2025-10-16T15:37:36.330062387Z stderr F ` + secondLine,
@@ -86,8 +87,8 @@ func TestProcessor(t *testing.T) {
 
 	// Too long partial line
 	in = []string{
-		`2025-10-16T15:37:36Z stderr P ` + strings.Repeat("a", maxLogLineSize),
-		`2025-10-16T15:37:36.330062387Z stderr F ` + strings.Repeat("b", maxLogLineSize),
+		`2025-10-16T15:37:36Z stderr P ` + strings.Repeat("a", filecollector.MaxLogLineSize),
+		`2025-10-16T15:37:36.330062387Z stderr F ` + strings.Repeat("b", filecollector.MaxLogLineSize),
 		`2025-10-16T15:37:36.4Z stderr F complete line`,
 	}
 	expectedContents = []string{`{"_msg":"complete line","_stream":"{}","_time":"2025-10-16T15:37:36.4Z"}`}
@@ -244,6 +245,119 @@ func TestParseCRILine(t *testing.T) {
 }
 
 // Storage implements insertutil.LogRowsStorage interface
+func TestProcessorMultiline(t *testing.T) {
+	f := func(startPattern, continuationPattern string, in []string, resultsExpected []string) {
+		t.Helper()
+
+		var commonFields []logstorage.Field
+		if startPattern != "" {
+			commonFields = append(commonFields, logstorage.Field{
+				Name:  "kubernetes.pod_annotations." + multilineStartPatternAnnotation,
+				Value: startPattern,
+			})
+		}
+		if continuationPattern != "" {
+			commonFields = append(commonFields, logstorage.Field{
+				Name:  "kubernetes.pod_annotations." + multilineContinuationPatternAnnotation,
+				Value: continuationPattern,
+			})
+		}
+
+		storage := newTestStorage()
+		proc := newLogFileProcessor(storage, commonFields)
+		for _, s := range in {
+			proc.TryAddLine([]byte(s))
+		}
+		proc.MustClose()
+
+		expected := strings.Join(resultsExpected, "\n")
+		if err := storage.verify(expected); err != nil {
+			t.Fatalf("unexpected result: %s", err)
+		}
+	}
+
+	// Java stack trace
+	in := []string{
+		`2025-10-16T15:37:36.1Z stderr F Exception in thread "main" java.lang.IllegalStateException: foo`,
+		`2025-10-16T15:37:36.2Z stderr F 	at com.example.Foo.bar(Foo.java:10)`,
+		`2025-10-16T15:37:36.3Z stderr F 	at com.example.Foo.main(Foo.java:5)`,
+		`2025-10-16T15:37:36.4Z stderr F next line`,
+	}
+	expectedContents := []string{
+		`{"_msg":"Exception in thread \"main\" java.lang.IllegalStateException: foo\n\tat com.example.Foo.bar(Foo.java:10)\n\tat com.example.Foo.main(Foo.java:5)","_stream":"{}","_time":"2025-10-16T15:37:36.1Z"}`,
+		`{"_msg":"next line","_stream":"{}","_time":"2025-10-16T15:37:36.4Z"}`,
+	}
+	f("", `^\s`, in, expectedContents)
+
+	// Python traceback split into partial CRI lines
+	in = []string{
+		`2025-10-16T15:37:36.1Z stderr F 2025-10-16 ERROR failure`,
+		`2025-10-16T15:37:36.2Z stderr F Traceback (most recent call last):`,
+		`2025-10-16T15:37:36.3Z stderr P   File "foo.py", `,
+		`2025-10-16T15:37:36.3Z stderr F line 1, in <module>`,
+		`2025-10-16T15:37:36.4Z stderr F ValueError: bar`,
+		`2025-10-16T15:37:36.5Z stderr F 2025-10-16 INFO ok`,
+	}
+	expectedContents = []string{
+		`{"_msg":"2025-10-16 ERROR failure\nTraceback (most recent call last):\n  File \"foo.py\", line 1, in \u003cmodule>\nValueError: bar","_stream":"{}","_time":"2025-10-16T15:37:36.1Z"}`,
+		`{"_msg":"2025-10-16 INFO ok","_stream":"{}","_time":"2025-10-16T15:37:36.5Z"}`,
+	}
+	f(`^\d{4}-\d{2}-\d{2} `, "", in, expectedContents)
+}
+
+func TestProcessorMultilineCommit(t *testing.T) {
+	commonFields := []logstorage.Field{
+		{
+			Name:  "kubernetes.pod_annotations." + multilineContinuationPatternAnnotation,
+			Value: `^\s`,
+		},
+		{
+			Name:  "kubernetes.pod_annotations." + multilineTimeoutAnnotation,
+			Value: "1ms",
+		},
+	}
+
+	storage := newTestStorage()
+	proc := newLogFileProcessor(storage, commonFields)
+	defer proc.MustClose()
+
+	f := func(line string, commitExpected, entryStartExpected bool) {
+		t.Helper()
+
+		commit := proc.TryAddLine([]byte(line))
+		if commit != commitExpected {
+			t.Fatalf("unexpected result for line %q; got %v; want %v", line, commit, commitExpected)
+		}
+		if !commit {
+			if entryStart := proc.IsEntryStart(); entryStart != entryStartExpected {
+				t.Fatalf("unexpected IsEntryStart() for line %q; got %v; want %v", line, entryStart, entryStartExpected)
+			}
+		}
+	}
+
+	f(`2025-10-16T15:37:36.1Z stderr F foo`, false, true)
+	f(`2025-10-16T15:37:36.2Z stderr F  bar`, false, false)
+	f(`2025-10-16T15:37:36.3Z stderr P ba`, false, false)
+	// The entry started at the partial line, so it cannot be committed at the start of the final line
+	f(`2025-10-16T15:37:36.3Z stderr F z`, false, false)
+
+	time.Sleep(10 * time.Millisecond)
+	if !proc.FlushPending() {
+		t.Fatalf("expecting flushed multiline entry")
+	}
+	expected := `{"_msg":"foo\n bar","_stream":"{}","_time":"2025-10-16T15:37:36.1Z"}
+{"_msg":"baz","_stream":"{}","_time":"2025-10-16T15:37:36.3Z"}`
+	if err := storage.verify(expected); err != nil {
+		t.Fatalf("unexpected result: %s", err)
+	}
+
+	// Partial line must start a new entry if nothing is pending
+	f(`2025-10-16T15:37:36.4Z stderr P qwe`, false, true)
+	if proc.FlushPending() {
+		t.Fatalf("partial lines mustn't be flushed")
+	}
+}
+
 type testStorage struct {
 	logRows []string
 }
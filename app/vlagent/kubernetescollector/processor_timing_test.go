@@ -76,9 +76,9 @@ func benchmarkProcessor(b *testing.B, logLines []string) {
 		for pb.Next() {
 			proc := newLogFileProcessor(storage, commonFields)
 			for _, line := range rawLines {
-				proc.tryAddLine(line)
+				proc.TryAddLine(line)
 			}
-			proc.mustClose()
+			proc.MustClose()
 		}
 	})
 }
@@ -15,6 +15,8 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/pushmetrics"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/filecollector"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/kafkaconsumer"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/kubernetescollector"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlagent/remotewrite"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert"
@@ -51,6 +53,8 @@ func main() {
 	remotewrite.Init(*tmpDataPath)
 
 	kubernetescollector.Init(*tmpDataPath)
+	filecollector.Init(*tmpDataPath)
+	kafkaconsumer.Init()
 	vlinsert.Init()
 
 	go httpserver.Serve(listenAddrs, requestHandler, httpserver.ServeOptions{
@@ -70,6 +74,8 @@ func main() {
 	}
 	vlinsert.Stop()
 	kubernetescollector.Stop()
+	filecollector.Stop()
+	kafkaconsumer.Stop()
 	remotewrite.Stop()
 	logger.Infof("successfully shut down the webservice in %.3f seconds", time.Since(startTime).Seconds())
 	logger.Infof("successfully stopped vlagent in %.3f seconds", time.Since(startTime).Seconds())
@@ -10,8 +10,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
@@ -76,8 +78,31 @@ type client struct {
 	sendBlock func(block []byte) bool
 	authCfg   *promauth.Config
 
+	// isUnavailable is set to true if the last attempt to send data to remoteWriteURL has failed.
+	//
+	// It is used for re-routing logs to the remaining -remoteWrite.url when -remoteWrite.shardByURL is set.
+	isUnavailable atomic.Bool
+
 	rl *ratelimiter.RateLimiter
 
+	// readLock serializes reading blocks from fq, so the read blocks are numbered in the queue order.
+	readLock sync.Mutex
+
+	// deliveryLock protects blocksRead, inflightBlocks and flushWaiters.
+	deliveryLock sync.Mutex
+
+	// blocksRead is the number of data blocks read from fq.
+	blocksRead uint64
+
+	// inflightBlocks contains sequence numbers for the read blocks, which aren't delivered to remoteWriteURL yet.
+	inflightBlocks map[uint64]struct{}
+
+	// flushWaiters contains waiters for the flush markers put into fq by waitForDelivery.
+	flushWaiters map[uint64]*flushWaiter
+
+	// flushMarkerID is used for generating unique ids for flush markers.
+	flushMarkerID atomic.Uint64
+
 	bytesSent       *metrics.Counter
 	blocksSent      *metrics.Counter
 	requestDuration *metrics.Histogram
@@ -128,6 +153,8 @@ func newHTTPClient(argIdx int, remoteWriteURL, sanitizedURL string, fq *persiste
 		hc:               hc,
 		retryMinInterval: retryMinInterval.GetOptionalArg(argIdx),
 		retryMaxTime:     retryMaxTime.GetOptionalArg(argIdx),
+		inflightBlocks:   make(map[uint64]struct{}),
+		flushWaiters:     make(map[uint64]*flushWaiter),
 		stopCh:           make(chan struct{}),
 	}
 	c.sendBlock = c.sendBlockHTTP
@@ -234,12 +261,13 @@ func (c *client) runWorker() {
 	var block []byte
 	ch := make(chan bool, 1)
 	for {
-		block, ok = c.fq.MustReadBlock(block[:0])
+		var seq uint64
+		block, seq, ok = c.readBlock(block[:0])
 		if !ok {
 			return
 		}
 		if len(block) == 0 {
-			// skip empty data blocks from sending
+			// skip empty data blocks and flush markers from sending
 			continue
 		}
 		go func() {
@@ -251,6 +279,7 @@ func (c *client) runWorker() {
 		case ok := <-ch:
 			if ok {
 				// The block has been sent successfully
+				c.markBlockDelivered(seq)
 				continue
 			}
 			// Return unsent block to the queue.
@@ -261,7 +290,9 @@ func (c *client) runWorker() {
 			graceDuration := 5 * time.Second
 			select {
 			case ok := <-ch:
-				if !ok {
+				if ok {
+					c.markBlockDelivered(seq)
+				} else {
 					// Return unsent block to the queue.
 					c.fq.MustWriteBlockIgnoreDisabledPQ(block)
 				}
@@ -274,6 +305,136 @@ func (c *client) runWorker() {
 	}
 }
 
+// readBlock reads the next block from c.fq into dst and returns it together with its sequence number.
+//
+// Empty blocks and flush markers are returned as empty blocks. They mustn't be sent to remoteWriteURL.
+// Non-empty blocks must be passed to markBlockDelivered after they are sent to remoteWriteURL.
+//
+// false is returned if c.fq is closed.
+func (c *client) readBlock(dst []byte) ([]byte, uint64, bool) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	dst, ok := c.fq.MustReadBlock(dst)
+	if !ok {
+		return dst, 0, false
+	}
+
+	c.deliveryLock.Lock()
+	defer c.deliveryLock.Unlock()
+
+	if id, ok := parseFlushMarker(dst); ok {
+		// All the blocks put into c.fq before the marker are already read.
+		// The marker is reached by the waiter when all these blocks are delivered.
+		// Markers without waiters may remain in the persistent queue after restart, so just skip them.
+		if fw := c.flushWaiters[id]; fw != nil {
+			fw.isRead = true
+			fw.blocksBefore = c.blocksRead
+			c.notifyFlushWaitersLocked()
+		}
+		return dst[:0], 0, true
+	}
+	if len(dst) == 0 {
+		return dst, 0, true
+	}
+
+	seq := c.blocksRead
+	c.blocksRead++
+	c.inflightBlocks[seq] = struct{}{}
+	return dst, seq, true
+}
+
+// markBlockDelivered marks the block with the given sequence number returned from readBlock as delivered to remoteWriteURL.
+func (c *client) markBlockDelivered(seq uint64) {
+	c.deliveryLock.Lock()
+	defer c.deliveryLock.Unlock()
+
+	delete(c.inflightBlocks, seq)
+	c.notifyFlushWaitersLocked()
+}
+
+func (c *client) notifyFlushWaitersLocked() {
+	// All the blocks with sequence numbers smaller than delivered are delivered to remoteWriteURL.
+	delivered := c.blocksRead
+	for seq := range c.inflightBlocks {
+		delivered = min(delivered, seq)
+	}
+
+	for id, fw := range c.flushWaiters {
+		if fw.isRead && fw.blocksBefore <= delivered {
+			close(fw.doneCh)
+			delete(c.flushWaiters, id)
+		}
+	}
+}
+
+// flushWaiter waits until all the blocks put into the queue before the flush marker are delivered to remoteWriteURL.
+type flushWaiter struct {
+	// isRead is set to true when the flush marker is read from the queue.
+	isRead bool
+
+	// blocksBefore is the number of blocks read from the queue before the flush marker.
+	blocksBefore uint64
+
+	// doneCh is closed when all the blocks before the flush marker are delivered.
+	doneCh chan struct{}
+}
+
+// waitForDelivery waits until all the blocks put into c.fq before the call are delivered to remoteWriteURL.
+//
+// An error is returned if the blocks aren't delivered during the given timeout.
+func (c *client) waitForDelivery(timeout time.Duration) error {
+	id := c.flushMarkerID.Add(1)
+	fw := &flushWaiter{
+		doneCh: make(chan struct{}),
+	}
+
+	c.deliveryLock.Lock()
+	c.flushWaiters[id] = fw
+	c.deliveryLock.Unlock()
+
+	// The blocks are read from c.fq in the order they were put there,
+	// so the marker is read after all the previously put blocks.
+	marker := marshalFlushMarker(nil, id)
+	c.fq.MustWriteBlockIgnoreDisabledPQ(marker)
+
+	t := timerpool.Get(timeout)
+	defer timerpool.Put(t)
+
+	var err error
+	select {
+	case <-fw.doneCh:
+		return nil
+	case <-c.stopCh:
+		err = fmt.Errorf("the client is stopped")
+	case <-t.C:
+		err = fmt.Errorf("the pending data isn't delivered in %s", timeout)
+	}
+
+	c.deliveryLock.Lock()
+	delete(c.flushWaiters, id)
+	c.deliveryLock.Unlock()
+
+	return err
+}
+
+// flushMarkerPrefix is the prefix for flush markers put into the queue by waitForDelivery.
+//
+// zstd-compressed blocks cannot start with zero byte, so flush markers cannot be confused with data blocks.
+const flushMarkerPrefix = "\x00vlagent_flush_marker:"
+
+func marshalFlushMarker(dst []byte, id uint64) []byte {
+	dst = append(dst, flushMarkerPrefix...)
+	return encoding.MarshalUint64(dst, id)
+}
+
+func parseFlushMarker(block []byte) (uint64, bool) {
+	if len(block) != len(flushMarkerPrefix)+8 || string(block[:len(flushMarkerPrefix)]) != flushMarkerPrefix {
+		return 0, false
+	}
+	return encoding.UnmarshalUint64(block[len(flushMarkerPrefix):]), true
+}
+
 func (c *client) doRequest(url string, body []byte) (*http.Response, error) {
 	req, err := c.newRequest(url, body)
 	if err != nil {
@@ -334,6 +495,7 @@ again:
 	resp, err := c.doRequest(c.remoteWriteURL, block)
 	c.requestDuration.UpdateDuration(startTime)
 	if err != nil {
+		c.isUnavailable.Store(true)
 		c.errorsCount.Inc()
 		retryDuration *= 2
 		if retryDuration > maxRetryDuration {
@@ -355,6 +517,7 @@ again:
 
 	statusCode := resp.StatusCode
 	if statusCode/100 == 2 {
+		c.isUnavailable.Store(false)
 		_ = resp.Body.Close()
 		c.requestsOKCount.Inc()
 		c.bytesSent.Add(len(block))
@@ -364,12 +527,14 @@ again:
 
 	metrics.GetOrCreateCounter(fmt.Sprintf(`vlagent_remotewrite_requests_total{url=%q, status_code="%d"}`, c.sanitizedURL, statusCode)).Inc()
 	if statusCode == 400 || statusCode == 404 {
+		c.isUnavailable.Store(false)
 		logBlockRejected(block, c.sanitizedURL, resp)
 		_ = resp.Body.Close()
 		c.packetsDropped.Inc()
 		return true
 	}
 	// Unexpected status code returned
+	c.isUnavailable.Store(true)
 	retriesCount++
 	retryAfterHeader := parseRetryAfterHeader(resp.Header.Get("Retry-After"))
 	retryDuration = getRetryDuration(retryAfterHeader, retryDuration, maxRetryDuration)
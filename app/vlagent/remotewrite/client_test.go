@@ -3,8 +3,12 @@ package remotewrite
 import (
 	"math"
 	"net/http"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/persistentqueue"
+	"github.com/VictoriaMetrics/metrics"
 )
 
 func TestCalculateRetryDuration(t *testing.T) {
@@ -97,3 +101,62 @@ func helper(d time.Duration) time.Duration {
 
 	return d + dv
 }
+
+func TestClientWaitForDelivery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	fq := persistentqueue.MustOpenFastQueue(path, "test", 10, 0, false)
+
+	// Blocks are sent only after the corresponding value is received from allowSend.
+	allowSend := make(chan struct{})
+	c := &client{
+		fq:             fq,
+		inflightBlocks: make(map[uint64]struct{}),
+		flushWaiters:   make(map[uint64]*flushWaiter),
+		sendDuration:   metrics.NewSet().NewFloatCounter(`vlagent_remotewrite_send_duration_seconds_total`),
+		stopCh:         make(chan struct{}),
+	}
+	c.sendBlock = func(_ []byte) bool {
+		select {
+		case <-allowSend:
+			return true
+		case <-c.stopCh:
+			return false
+		}
+	}
+	for i := 0; i < 2; i++ {
+		c.wg.Go(c.runWorker)
+	}
+
+	// There is no pending data.
+	if err := c.waitForDelivery(5 * time.Second); err != nil {
+		t.Fatalf("unexpected error for empty queue: %s", err)
+	}
+
+	// The pending blocks aren't sent yet.
+	for i := 0; i < 3; i++ {
+		if !fq.TryWriteBlock([]byte("foobar")) {
+			t.Fatalf("cannot write block to the queue")
+		}
+	}
+	if err := c.waitForDelivery(100 * time.Millisecond); err == nil {
+		t.Fatalf("expecting non-nil error when the pending blocks aren't sent")
+	}
+
+	// Only a part of the pending blocks is sent.
+	allowSend <- struct{}{}
+	allowSend <- struct{}{}
+	if err := c.waitForDelivery(100 * time.Millisecond); err == nil {
+		t.Fatalf("expecting non-nil error when only a part of the pending blocks is sent")
+	}
+
+	// All the pending blocks are sent.
+	allowSend <- struct{}{}
+	if err := c.waitForDelivery(5 * time.Second); err != nil {
+		t.Fatalf("unexpected error after sending all the pending blocks: %s", err)
+	}
+
+	fq.UnblockAllReaders()
+	close(c.stopCh)
+	c.wg.Wait()
+	fq.MustClose()
+}
@@ -69,6 +69,12 @@ func (pl *pendingLogs) addLogRow(r *logstorage.InsertRow) {
 	bbPool.Put(bb)
 }
 
+func (pl *pendingLogs) flush() {
+	pl.mu.Lock()
+	pl.mustFlushLocked()
+	pl.mu.Unlock()
+}
+
 func (pl *pendingLogs) mustFlushLocked() {
 	pl.lastFlushTime.Store(fasttime.UnixTimestamp())
 	pl.wr.push(func(b []byte) {
@@ -0,0 +1,77 @@
+package remotewrite
+
+import (
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// mustLoadRowsProcessor loads pipes from -remoteWrite.pipesFile for the -remoteWrite.url with the given argIdx.
+//
+// It returns nil if -remoteWrite.pipesFile isn't set for the given -remoteWrite.url.
+func mustLoadRowsProcessor(argIdx int, sanitizedURL string) *logstorage.RowsProcessor {
+	path := pipesFile.GetOptionalArg(argIdx)
+	if path == "" {
+		return nil
+	}
+
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		logger.Fatalf("cannot read -remoteWrite.pipesFile=%q for -remoteWrite.url=%q: %s", path, sanitizedURL, err)
+	}
+	rp, err := logstorage.ParseRowsProcessor(string(data))
+	if err != nil {
+		logger.Fatalf("cannot parse -remoteWrite.pipesFile=%q for -remoteWrite.url=%q: %s", path, sanitizedURL, err)
+	}
+	logger.Infof("applying pipes [%s] from -remoteWrite.pipesFile=%q to logs sent to -remoteWrite.url=%q", rp, path, sanitizedURL)
+	return rp
+}
+
+// rowsWriter writes rows to the pendingLogs of remoteWriteCtx after applying -remoteWrite.pipesFile pipes to them.
+type rowsWriter struct {
+	rwctx *remoteWriteCtx
+	pl    *pendingLogs
+
+	// rpc is nil if there are no pipes for rwctx.
+	rpc *logstorage.RowsProcessorContext
+}
+
+func (rwctx *remoteWriteCtx) newRowsWriter() *rowsWriter {
+	rw := &rowsWriter{
+		rwctx: rwctx,
+		pl:    rwctx.getPendingLogs(),
+	}
+	if rwctx.rp != nil {
+		rw.rpc = rwctx.rp.NewContext(rw.addProcessedRow)
+	}
+	return rw
+}
+
+func (rw *rowsWriter) addRow(streamHash uint64, r *logstorage.InsertRow) {
+	if rw.rpc == nil {
+		rw.pl.addLogRow(r)
+		return
+	}
+	rw.rwctx.pipesInputRows.Inc()
+	rw.rpc.AddRow(streamHash, r)
+}
+
+func (rw *rowsWriter) addProcessedRow(_ uint64, r *logstorage.InsertRow) {
+	rw.rwctx.pipesOutputRows.Inc()
+	rw.pl.addLogRow(r)
+}
+
+func (rw *rowsWriter) flush() {
+	if rw.rpc == nil {
+		return
+	}
+	if err := rw.rpc.Flush(); err != nil {
+		rw.rwctx.pipesErrors.Inc()
+		remoteWritePipesLogger.Errorf("cannot apply -remoteWrite.pipesFile pipes to logs for -remoteWrite.url=%q: %s", rw.rwctx.sanitizedURL, err)
+	}
+}
+
+var remoteWritePipesLogger = logger.WithThrottler("remoteWritePipes", 5*time.Second)
@@ -0,0 +1,106 @@
+package remotewrite
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/persistentqueue"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestRemoteWriteCtxPushWithPipes(t *testing.T) {
+	f := func(pipes string, rows []string, rowsExpected []string) {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), "pipes.txt")
+		if err := os.WriteFile(path, []byte(pipes), 0o644); err != nil {
+			t.Fatalf("cannot write pipes file: %s", err)
+		}
+		origPipesFile := *pipesFile
+		*pipesFile = flagutil.ArrayString{path}
+		defer func() {
+			*pipesFile = origPipesFile
+		}()
+
+		fq := persistentqueue.MustOpenFastQueue(filepath.Join(t.TempDir(), "queue"), "test", 10, 0, false)
+		defer fq.MustClose()
+
+		pl := newPendingLogs(fq)
+		defer pl.mustStop()
+
+		rwctx := &remoteWriteCtx{
+			fq:              fq,
+			c:               &client{},
+			rp:              mustLoadRowsProcessor(0, "test"),
+			sanitizedURL:    "test",
+			pls:             []*pendingLogs{pl},
+			pipesInputRows:  metrics.NewCounter(`test_pipes_input_rows_total`),
+			pipesOutputRows: metrics.NewCounter(`test_pipes_output_rows_total`),
+			pipesErrors:     metrics.NewCounter(`test_pipes_errors_total`),
+		}
+		defer metrics.UnregisterMetric(`test_pipes_input_rows_total`)
+		defer metrics.UnregisterMetric(`test_pipes_output_rows_total`)
+		defer metrics.UnregisterMetric(`test_pipes_errors_total`)
+
+		lr := logstorage.GetLogRows(nil, nil, nil, nil, "")
+		defer logstorage.PutLogRows(lr)
+
+		p := logstorage.GetJSONParser()
+		defer logstorage.PutJSONParser(p)
+		for _, r := range rows {
+			if err := p.ParseLogMessage([]byte(r), nil); err != nil {
+				t.Fatalf("cannot parse %q: %s", r, err)
+			}
+			lr.MustAdd(logstorage.TenantID{}, 1, p.Fields, -1)
+		}
+
+		rwctx.push(lr)
+		pl.flush()
+
+		var result []string
+		if fq.GetPendingBytes() > 0 {
+			block, ok := fq.MustReadBlock(nil)
+			if !ok {
+				t.Fatalf("cannot read block from the queue")
+			}
+			data, err := zstd.Decompress(nil, block)
+			if err != nil {
+				t.Fatalf("cannot decompress block: %s", err)
+			}
+			var r logstorage.InsertRow
+			for len(data) > 0 {
+				data, err = r.UnmarshalInplace(data)
+				if err != nil {
+					t.Fatalf("cannot unmarshal row: %s", err)
+				}
+				result = append(result, string(logstorage.MarshalFieldsToJSON(nil, r.Fields)))
+			}
+		}
+		if !reflect.DeepEqual(result, rowsExpected) {
+			t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", result, rowsExpected)
+		}
+	}
+
+	rows := []string{
+		`{"_msg":"foo","level":"info","password":"secret"}`,
+		`{"_msg":"bar","level":"debug"}`,
+	}
+
+	// drop debug logs and redact secrets
+	f(`
+# drop debug logs
+filter level:!debug
+| delete password
+`, rows, []string{
+		`{"_msg":"foo","level":"info"}`,
+	})
+
+	// drop all the logs
+	f(`filter level:=error`, rows, nil)
+}
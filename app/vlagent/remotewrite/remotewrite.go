@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
@@ -24,7 +25,8 @@ import (
 var (
 	remoteWriteURLs = flagutil.NewArrayString("remoteWrite.url", "Remote storage URL to write data to. It must support VictoriaLogs native protocol. "+
 		"Example url: http://<victorialogs-host>:9428/insert/native. "+
-		"Pass multiple -remoteWrite.url options in order to replicate the collected data to multiple remote storage systems.")
+		"Pass multiple -remoteWrite.url options in order to replicate the collected data to multiple remote storage systems. "+
+		"See also -remoteWrite.shardByURL")
 	maxPendingBytesPerURL = flagutil.NewArrayBytes("remoteWrite.maxDiskUsagePerURL", 0, "The maximum file-based buffer size in bytes at -remoteWrite.tmpDataPath "+
 		"for each -remoteWrite.url. When buffer size reaches the configured maximum, then old data is dropped when adding new data to the buffer. "+
 		"Buffered data is stored in ~500MB chunks. It is recommended to set the value for this flag to a multiple of the block size 500MB. "+
@@ -37,6 +39,16 @@ var (
 		"isn't enough for sending high volume of collected data to remote storage. "+
 		"Default value depends on the number of available CPU cores. It should work fine in most cases since it minimizes resource usage")
 
+	shardByURL = flag.Bool("remoteWrite.shardByURL", false, "Whether to shard the collected logs among all the configured -remoteWrite.url instead of replicating them to every -remoteWrite.url. "+
+		"Logs from the same log stream are sent to the same -remoteWrite.url. Logs are re-routed to the remaining -remoteWrite.url if some of them are unavailable. "+
+		"See also -remoteWrite.shardByURL.fields and https://docs.victoriametrics.com/victorialogs/vlagent/#sharding")
+	shardByURLFields = flagutil.NewArrayString("remoteWrite.shardByURL.fields", "Optional list of log fields to use for sharding logs among -remoteWrite.url when -remoteWrite.shardByURL is set. "+
+		"By default, logs are sharded by log stream. See https://docs.victoriametrics.com/victorialogs/vlagent/#sharding")
+
+	pipesFile = flagutil.NewArrayString("remoteWrite.pipesFile", "Optional path to file with LogsQL pipes to apply to the collected logs before sending them to the corresponding -remoteWrite.url. "+
+		"The path can point either to local file or to http url. "+
+		"See https://docs.victoriametrics.com/victorialogs/vlagent/#processing-logs-before-sending")
+
 	showRemoteWriteURL = flag.Bool("remoteWrite.showURL", false, "Whether to show -remoteWrite.url in the exported metrics. "+
 		"It is hidden by default, since it can contain sensitive info such as auth key")
 )
@@ -104,6 +116,34 @@ func Stop() {
 	rwctxsGlobal = nil
 }
 
+// Flush flushes the pending data for all the configured -remoteWrite.url to the corresponding queues at -remoteWrite.tmpDataPath.
+//
+// The data passed to Storage.MustAddRows before the call to Flush is stored in the queues after returning from Flush.
+// The queues are persisted to disk on graceful shutdown, so the data isn't lost if the remote storage is unavailable.
+func Flush() {
+	for _, rwctx := range rwctxsGlobal {
+		for _, pl := range rwctx.pls {
+			pl.flush()
+		}
+	}
+}
+
+// FlushAndWait flushes the pending data for all the configured -remoteWrite.url and waits until it is delivered to them.
+//
+// The data passed to Storage.MustAddRows before the call to FlushAndWait is accepted by all the -remoteWrite.url if nil is returned.
+// An error is returned if the data isn't delivered during the given timeout. The data remains in the queues in this case.
+func FlushAndWait(timeout time.Duration) error {
+	Flush()
+
+	deadline := time.Now().Add(timeout)
+	for _, rwctx := range rwctxsGlobal {
+		if err := rwctx.c.waitForDelivery(time.Until(deadline)); err != nil {
+			return fmt.Errorf("cannot deliver the pending data to -remoteWrite.url=%q: %w", rwctx.sanitizedURL, err)
+		}
+	}
+	return nil
+}
+
 func dropDanglingQueues(tmpDataPath string) {
 	// Remove dangling persistent queues, if any.
 	// This is required for the case when the number of queues has been changed or URL have been changed.
@@ -169,6 +209,10 @@ func initRemoteWriteCtxs(tmpDataPath string, urls []string) {
 
 func pushToRemoteStorages(lr *logstorage.LogRows) {
 	rwctxs := rwctxsGlobal
+	if *shardByURL {
+		pushToRemoteStoragesSharded(rwctxs, lr)
+		return
+	}
 	if len(rwctxs) == 1 {
 		// fast path
 		rwctxs[0].push(lr)
@@ -180,7 +224,6 @@ func pushToRemoteStorages(lr *logstorage.LogRows) {
 	for _, rwctx := range rwctxs {
 		wg.Go(func() {
 			rwctx.push(lr)
-
 		})
 	}
 	wg.Wait()
@@ -191,6 +234,17 @@ type remoteWriteCtx struct {
 	fq  *persistentqueue.FastQueue
 	c   *client
 
+	// urlHash is the hash of -remoteWrite.url without query args. It is used for sharding logs among -remoteWrite.url.
+	urlHash uint64
+
+	// rp contains pipes from -remoteWrite.pipesFile for the given -remoteWrite.url. It is nil if no pipes are configured.
+	rp           *logstorage.RowsProcessor
+	sanitizedURL string
+
+	pipesInputRows  *metrics.Counter
+	pipesOutputRows *metrics.Counter
+	pipesErrors     *metrics.Counter
+
 	pls        []*pendingLogs
 	pssNextIdx atomic.Uint64
 }
@@ -250,19 +304,45 @@ func newRemoteWriteCtx(argIdx int, remoteWriteURL *url.URL, maxInmemoryBlocks in
 	}
 
 	rwctx := &remoteWriteCtx{
-		idx: argIdx,
-		fq:  fq,
-		c:   c,
-		pls: pls,
+		idx:          argIdx,
+		fq:           fq,
+		c:            c,
+		urlHash:      h,
+		rp:           mustLoadRowsProcessor(argIdx, sanitizedURL),
+		sanitizedURL: sanitizedURL,
+		pls:          pls,
+	}
+	if rwctx.rp != nil {
+		rwctx.pipesInputRows = metrics.GetOrCreateCounter(fmt.Sprintf(`vlagent_remotewrite_pipes_input_rows_total{url=%q}`, sanitizedURL))
+		rwctx.pipesOutputRows = metrics.GetOrCreateCounter(fmt.Sprintf(`vlagent_remotewrite_pipes_output_rows_total{url=%q}`, sanitizedURL))
+		rwctx.pipesErrors = metrics.GetOrCreateCounter(fmt.Sprintf(`vlagent_remotewrite_pipes_errors_total{url=%q}`, sanitizedURL))
 	}
 
 	return rwctx
 }
 
 func (rwctx *remoteWriteCtx) push(lr *logstorage.LogRows) {
+	if rwctx.rp == nil {
+		// Fast path - there are no pipes to apply.
+		pl := rwctx.getPendingLogs()
+		pl.add(lr)
+		return
+	}
+
+	rw := rwctx.newRowsWriter()
+	lr.ForEachRow(rw.addRow)
+	rw.flush()
+}
+
+func (rwctx *remoteWriteCtx) getPendingLogs() *pendingLogs {
 	pls := rwctx.pls
 	idx := rwctx.pssNextIdx.Add(1) % uint64(len(pls))
-	pls[idx].add(lr)
+	return pls[idx]
+}
+
+// isAvailable returns true if rwctx can accept new logs.
+func (rwctx *remoteWriteCtx) isAvailable() bool {
+	return !rwctx.c.isUnavailable.Load() && !rwctx.fq.IsWriteBlocked()
 }
 
 func (rwctx *remoteWriteCtx) mustStop() {
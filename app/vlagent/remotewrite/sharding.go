@@ -0,0 +1,119 @@
+package remotewrite
+
+import (
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// pushToRemoteStoragesSharded distributes rows from lr among rwctxs according to the hash of their log stream
+// or the hash of -remoteWrite.shardByURL.fields.
+//
+// Rows are re-routed to the remaining available rwctxs if the selected rwctx is unavailable.
+func pushToRemoteStoragesSharded(rwctxs []*remoteWriteCtx, lr *logstorage.LogRows) {
+	// rws contains rowsWriter for every rwctx, which received rows during the call.
+	// This reduces contention on rwctx.pssNextIdx when lr contains many rows.
+	rws := make([]*rowsWriter, len(rwctxs))
+
+	lr.ForEachRow(func(streamHash uint64, r *logstorage.InsertRow) {
+		h := streamHash
+		if len(*shardByURLFields) > 0 {
+			h = getFieldsHash(r.Fields, *shardByURLFields)
+		}
+
+		idx, rerouted := getShardIdx(rwctxs, h)
+		if rerouted {
+			reroutedRowsTotal.Inc()
+		}
+
+		rw := rws[idx]
+		if rw == nil {
+			rw = rwctxs[idx].newRowsWriter()
+			rws[idx] = rw
+		}
+		rw.addRow(streamHash, r)
+	})
+
+	for _, rw := range rws {
+		if rw != nil {
+			rw.flush()
+		}
+	}
+}
+
+// getShardIdx returns the index of rwctx in rwctxs for the row with the given hash h.
+//
+// Shards are selected via rendezvous hashing, so only the rows from the removed or unavailable shard
+// are re-distributed among the remaining shards when the list of shards changes.
+//
+// rerouted is set to true if the row is re-routed from the unavailable shard to another shard.
+// If all the shards are unavailable, then the row is sent to its primary shard, where it is buffered at -remoteWrite.tmpDataPath.
+func getShardIdx(rwctxs []*remoteWriteCtx, h uint64) (idx int, rerouted bool) {
+	if len(rwctxs) == 1 {
+		// Fast path - nothing to shard.
+		return 0, false
+	}
+
+	primaryIdx := -1
+	var primaryScore uint64
+	availableIdx := -1
+	var availableScore uint64
+	for i, rwctx := range rwctxs {
+		score := getShardScore(h, rwctx.urlHash)
+		if primaryIdx < 0 || score > primaryScore {
+			primaryIdx = i
+			primaryScore = score
+		}
+		if rwctx.isAvailable() && (availableIdx < 0 || score > availableScore) {
+			availableIdx = i
+			availableScore = score
+		}
+	}
+	if availableIdx < 0 {
+		// All the shards are unavailable.
+		return primaryIdx, false
+	}
+	return availableIdx, availableIdx != primaryIdx
+}
+
+// getShardScore returns the rendezvous hashing score for the row hash h at the shard with the given shardHash.
+func getShardScore(h, shardHash uint64) uint64 {
+	// Mix the hashes with splitmix64 finalizer in order to obtain uniformly distributed scores.
+	x := h ^ shardHash
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// getFieldsHash returns the hash of values for the given fieldNames at fields.
+//
+// Missing fields are treated as fields with empty values.
+func getFieldsHash(fields []logstorage.Field, fieldNames []string) uint64 {
+	bb := bbPool.Get()
+	b := bb.B
+	for _, name := range fieldNames {
+		b = append(b, name...)
+		b = append(b, '=')
+		if name == "_msg" {
+			// _msg field is stored with an empty name in the row.
+			name = ""
+		}
+		for _, f := range fields {
+			if f.Name == name {
+				b = append(b, f.Value...)
+				break
+			}
+		}
+		b = append(b, 0)
+	}
+	h := xxhash.Sum64(b)
+	bb.B = b
+	bbPool.Put(bb)
+	return h
+}
+
+var reroutedRowsTotal = metrics.NewCounter(`vlagent_remotewrite_shard_rerouted_rows_total`)
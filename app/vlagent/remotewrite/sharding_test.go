@@ -0,0 +1,137 @@
+package remotewrite
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/persistentqueue"
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func newTestRemoteWriteCtxs(t *testing.T, n int) []*remoteWriteCtx {
+	t.Helper()
+
+	rwctxs := make([]*remoteWriteCtx, n)
+	for i := range rwctxs {
+		name := fmt.Sprintf("http://victoria-logs-%d:9428/insert/native", i)
+		fq := persistentqueue.MustOpenFastQueue(filepath.Join(t.TempDir(), "queue"), name, 10, 0, false)
+		t.Cleanup(fq.MustClose)
+
+		rwctxs[i] = &remoteWriteCtx{
+			idx:     i,
+			fq:      fq,
+			c:       &client{},
+			urlHash: xxhash.Sum64String(name),
+		}
+	}
+	return rwctxs
+}
+
+func TestGetShardIdxDistribution(t *testing.T) {
+	const shardsCount = 4
+	const rowsCount = 100_000
+
+	rwctxs := newTestRemoteWriteCtxs(t, shardsCount)
+
+	var counts [shardsCount]int
+	for i := range rowsCount {
+		idx, rerouted := getShardIdx(rwctxs, xxhash.Sum64String(fmt.Sprintf("stream-%d", i)))
+		if rerouted {
+			t.Fatalf("unexpected re-routing when all the shards are available")
+		}
+		counts[idx]++
+	}
+
+	// Every shard must receive roughly the same number of rows.
+	expected := rowsCount / shardsCount
+	for idx, n := range counts {
+		if n < expected*9/10 || n > expected*11/10 {
+			t.Fatalf("unexpected number of rows at shard #%d; got %d; want %d +- 10%%", idx, n, expected)
+		}
+	}
+}
+
+func TestGetShardIdxStability(t *testing.T) {
+	rwctxs := newTestRemoteWriteCtxs(t, 4)
+
+	// Removing a shard must move only the rows from the removed shard.
+	rwctxsWithoutLast := rwctxs[:3]
+	for i := range 10_000 {
+		h := xxhash.Sum64String(fmt.Sprintf("stream-%d", i))
+		idx, _ := getShardIdx(rwctxs, h)
+		idxWithoutLast, _ := getShardIdx(rwctxsWithoutLast, h)
+		if idx < 3 && idx != idxWithoutLast {
+			t.Fatalf("unexpected shard for the row %d after removing the last shard; got %d; want %d", i, idxWithoutLast, idx)
+		}
+	}
+}
+
+func TestGetShardIdxReroute(t *testing.T) {
+	rwctxs := newTestRemoteWriteCtxs(t, 3)
+
+	h := xxhash.Sum64String("stream")
+	primaryIdx, rerouted := getShardIdx(rwctxs, h)
+	if rerouted {
+		t.Fatalf("unexpected re-routing when all the shards are available")
+	}
+
+	// The row must be re-routed to another shard if the primary shard is unavailable.
+	rwctxs[primaryIdx].c.isUnavailable.Store(true)
+	idx, rerouted := getShardIdx(rwctxs, h)
+	if !rerouted {
+		t.Fatalf("expecting re-routing when the primary shard is unavailable")
+	}
+	if idx == primaryIdx {
+		t.Fatalf("the row mustn't be sent to the unavailable shard #%d", idx)
+	}
+
+	// The row must be sent to the primary shard if all the shards are unavailable.
+	for _, rwctx := range rwctxs {
+		rwctx.c.isUnavailable.Store(true)
+	}
+	idx, rerouted = getShardIdx(rwctxs, h)
+	if rerouted {
+		t.Fatalf("unexpected re-routing when all the shards are unavailable")
+	}
+	if idx != primaryIdx {
+		t.Fatalf("unexpected shard when all the shards are unavailable; got %d; want %d", idx, primaryIdx)
+	}
+
+	// The row must return to the primary shard when it becomes available.
+	rwctxs[primaryIdx].c.isUnavailable.Store(false)
+	idx, rerouted = getShardIdx(rwctxs, h)
+	if rerouted || idx != primaryIdx {
+		t.Fatalf("unexpected shard after the primary shard becomes available; got %d (rerouted=%v); want %d", idx, rerouted, primaryIdx)
+	}
+}
+
+func TestGetFieldsHash(t *testing.T) {
+	f := func(fields []logstorage.Field, fieldNames []string, hashExpected uint64) {
+		t.Helper()
+
+		h := getFieldsHash(fields, fieldNames)
+		if h != hashExpected {
+			t.Fatalf("unexpected hash; got %d; want %d", h, hashExpected)
+		}
+	}
+
+	fields := []logstorage.Field{
+		{
+			Name:  "host",
+			Value: "foo",
+		},
+		{
+			Name:  "",
+			Value: "some message",
+		},
+	}
+
+	f(fields, []string{"host"}, xxhash.Sum64String("host=foo\x00"))
+	f(fields, []string{"_msg", "host"}, xxhash.Sum64String("_msg=some message\x00host=foo\x00"))
+
+	// missing field
+	f(fields, []string{"app"}, xxhash.Sum64String("app=\x00"))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/backup"
+)
+
+var (
+	snapshotCreateURL = flag.String("snapshot.createURL", "http://localhost:9428/internal/partition/snapshot/create", "VictoriaLogs URL for creating partition snapshots; "+
+		"authKey query arg must be added to the url if VictoriaLogs runs with -partitionManageAuthKey; "+
+		"see https://docs.victoriametrics.com/victorialogs/#partitions-lifecycle")
+	snapshotDeleteURL = flag.String("snapshot.deleteURL", "http://localhost:9428/internal/partition/snapshot/delete", "VictoriaLogs URL for deleting partition snapshots "+
+		"after they are backed up; authKey query arg must be added to the url if VictoriaLogs runs with -partitionManageAuthKey")
+	partitionPrefix = flag.String("partitionPrefix", "", "Optional prefix for per-day partitions to backup in the form YYYYMMDD, YYYYMM or YYYY. "+
+		"By default all the partitions are backed up")
+	dst = flag.String("dst", "", "Where to put the backup. Supported formats: fs:///path/to/local/backup/dir, s3://bucket/path/to/backup/dir. "+
+		"Only new files are uploaded to -dst, so backups are incremental")
+	backupName = flag.String("backupName", "", "The name of the backup to create at -dst. The backup can be restored via vlrestore by this name. "+
+		"By default the current UTC time in the YYYYMMDDhhmmss format is used")
+	concurrency = flag.Int("concurrency", 10, "The number of concurrent workers. Higher concurrency may reduce backup duration")
+)
+
+func main() {
+	// Write flags and help message to stdout, since it is easier to grep or pipe.
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = usage
+	envflag.Parse()
+	buildinfo.Init()
+	logger.Init()
+
+	if *dst == "" {
+		logger.Fatalf("missing -dst command-line flag")
+	}
+	dstFS, err := backup.NewRemoteFS(*dst)
+	if err != nil {
+		logger.Fatalf("cannot parse -dst=%q: %s", *dst, err)
+	}
+	name := *backupName
+	if name == "" {
+		name = time.Now().UTC().Format("20060102150405")
+	}
+
+	startTime := time.Now()
+	snapshotPaths, err := createSnapshots()
+	if err != nil {
+		logger.Fatalf("cannot create snapshots via -snapshot.createURL: %s", err)
+	}
+	m, err := backupSnapshots(snapshotPaths, dstFS, name)
+	deleteSnapshots(snapshotPaths)
+	if err != nil {
+		logger.Fatalf("cannot create backup %q at %s: %s", name, dstFS, err)
+	}
+
+	logger.Infof("successfully created backup %q with %d partitions and %d files at %s in %.3f seconds",
+		name, len(m.Partitions), len(m.Files), dstFS, time.Since(startTime).Seconds())
+}
+
+func backupSnapshots(snapshotPaths []string, dstFS backup.RemoteFS, name string) (*backup.Manifest, error) {
+	snapshots := make([]backup.Snapshot, len(snapshotPaths))
+	for i, snapshotPath := range snapshotPaths {
+		// Snapshot paths have the <-storageDataPath>/partitions/YYYYMMDD/snapshots/<snapshotName> format.
+		snapshots[i] = backup.Snapshot{
+			Partition: filepath.Base(filepath.Dir(filepath.Dir(snapshotPath))),
+			Path:      snapshotPath,
+		}
+	}
+	return backup.Backup(snapshots, dstFS, name, *concurrency)
+}
+
+func createSnapshots() ([]string, error) {
+	args := url.Values{}
+	if *partitionPrefix != "" {
+		args.Set("partition_prefix", *partitionPrefix)
+	}
+	data, err := doRequest(*snapshotCreateURL, args, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshotPaths []string
+	if err := json.Unmarshal(data, &snapshotPaths); err != nil {
+		return nil, fmt.Errorf("cannot parse response from -snapshot.createURL=%q: %w", *snapshotCreateURL, err)
+	}
+	logger.Infof("created %d partition snapshots", len(snapshotPaths))
+	return snapshotPaths, nil
+}
+
+func deleteSnapshots(snapshotPaths []string) {
+	for _, snapshotPath := range snapshotPaths {
+		args := url.Values{}
+		args.Set("path", snapshotPath)
+		if _, err := doRequest(*snapshotDeleteURL, args, http.StatusNoContent); err != nil {
+			logger.Errorf("cannot delete snapshot %q via -snapshot.deleteURL; delete it manually in order to free up disk space: %s", snapshotPath, err)
+		}
+	}
+}
+
+func doRequest(requestURL string, args url.Values, expectedStatusCode int) ([]byte, error) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", requestURL, err)
+	}
+	qs := u.Query()
+	for k, vs := range args {
+		qs[k] = vs
+	}
+	u.RawQuery = qs.Encode()
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response from %q: %w", u.Redacted(), err)
+	}
+	if resp.StatusCode != expectedStatusCode {
+		return nil, fmt.Errorf("unexpected status code returned from %q: %d; want %d; response body: %q", u.Redacted(), resp.StatusCode, expectedStatusCode, data)
+	}
+	return data, nil
+}
+
+func usage() {
+	const s = `
+vlbackup creates incremental backups for VictoriaLogs partitions.
+
+See the docs at https://docs.victoriametrics.com/victorialogs/#backup-and-restore
+`
+	flagutil.Usage(s)
+}
@@ -41,6 +41,12 @@ type CommonParams struct {
 	// The TimeFields has default value `_time`. It's not empty even if the IsTimeFieldSet is false.
 	IsTimeFieldSet bool
 
+	// SkipTenantLimits disables per-tenant limits for the ingested rows.
+	//
+	// It is set for rows, which were already checked against tenant limits at the ingestion entry point.
+	// See https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits
+	SkipTenantLimits bool
+
 	Debug           bool
 	DebugRequestURI string
 	DebugRemoteAddr string
@@ -194,6 +200,17 @@ func CanWriteData() error {
 	return logRowsStorage.CanWriteData()
 }
 
+// CanWriteData returns non-nil error if data cannot be written to the underlying storage for the cp.TenantID.
+//
+// In addition to CanWriteData() checks, it verifies whether the cp.TenantID didn't exceed its rate limits.
+// See https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits
+func (cp *CommonParams) CanWriteData() error {
+	if err := CanWriteData(); err != nil {
+		return err
+	}
+	return CheckTenantLimits(cp.TenantID)
+}
+
 // LogMessageProcessor is an interface for log message processors.
 type LogMessageProcessor interface {
 	// AddRow must add row to the LogMessageProcessor with the given timestamp and fields.
@@ -320,6 +337,9 @@ func (lmp *logMessageProcessor) AddInsertRow(r *logstorage.InsertRow) {
 func (lmp *logMessageProcessor) flushLocked() {
 	start := time.Now()
 	lmp.lastFlushTime = start
+	if !lmp.cp.SkipTenantLimits {
+		applyTenantLimits(lmp.lr)
+	}
 	logRowsStorage.MustAddRows(lmp.lr)
 	lmp.lr.ResetKeepSettings()
 
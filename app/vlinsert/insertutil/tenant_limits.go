@@ -0,0 +1,592 @@
+package insertutil
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var tenantLimitsFile = flag.String("insert.tenantLimitsFile", "", "Optional path to a file with per-tenant limits on the ingestion rate and on the number of log streams. "+
+	"The file is re-read on SIGHUP signal. See https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits")
+
+var (
+	tenantLimitsReloads      = metrics.NewCounter(`vl_tenant_limits_config_reloads_total`)
+	tenantLimitsReloadErrors = metrics.NewCounter(`vl_tenant_limits_config_reloads_errors_total`)
+)
+
+// TenantLimits contains limits for the ingested logs per every tenant.
+//
+// Zero limit means there is no limit.
+type TenantLimits struct {
+	// MaxRowsPerSecond is the maximum number of log entries per second, which can be ingested into the tenant.
+	MaxRowsPerSecond int64 `yaml:"max_rows_per_second,omitempty"`
+
+	// MaxBytesPerSecond is the maximum number of bytes per second, which can be ingested into the tenant.
+	//
+	// The number of bytes is calculated as the length of the ingested log entry in JSON.
+	MaxBytesPerSecond int64 `yaml:"max_bytes_per_second,omitempty"`
+
+	// MaxActiveStreams is the maximum number of unique log streams, which can be ingested into the tenant during the current hour.
+	MaxActiveStreams int `yaml:"max_active_streams,omitempty"`
+
+	// MaxNewStreamsPerDay is the maximum number of unique log streams, which can be ingested into the tenant during the current day.
+	MaxNewStreamsPerDay int `yaml:"max_new_streams_per_day,omitempty"`
+}
+
+func (tl *TenantLimits) isEmpty() bool {
+	return tl.MaxRowsPerSecond <= 0 && tl.MaxBytesPerSecond <= 0 && tl.MaxActiveStreams <= 0 && tl.MaxNewStreamsPerDay <= 0
+}
+
+func (tl *TenantLimits) hasStreamLimits() bool {
+	return tl.MaxActiveStreams > 0 || tl.MaxNewStreamsPerDay > 0
+}
+
+// tenantLimitsConfig represents the contents of -insert.tenantLimitsFile
+type tenantLimitsConfig struct {
+	// Default contains limits for tenants without explicitly configured limits at Tenants.
+	Default *TenantLimits `yaml:"default,omitempty"`
+
+	// Tenants contains limits per tenant in the form accountID:projectID.
+	Tenants map[string]*TenantLimits `yaml:"tenants,omitempty"`
+}
+
+// parsedTenantLimits contains parsed tenantLimitsConfig.
+type parsedTenantLimits struct {
+	defaultLimits *TenantLimits
+	tenants       map[logstorage.TenantID]*TenantLimits
+}
+
+func (ptl *parsedTenantLimits) getLimits(tenantID logstorage.TenantID) *TenantLimits {
+	if tl, ok := ptl.tenants[tenantID]; ok {
+		return tl
+	}
+	return ptl.defaultLimits
+}
+
+func parseTenantLimits(data []byte) (*parsedTenantLimits, error) {
+	var cfg tenantLimitsConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+
+	ptl := &parsedTenantLimits{
+		tenants: make(map[logstorage.TenantID]*TenantLimits, len(cfg.Tenants)),
+	}
+	if cfg.Default != nil && !cfg.Default.isEmpty() {
+		ptl.defaultLimits = cfg.Default
+	}
+	for s, tl := range cfg.Tenants {
+		tenantID, err := logstorage.ParseTenantID(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant %q: %w", s, err)
+		}
+		if _, ok := ptl.tenants[tenantID]; ok {
+			return nil, fmt.Errorf("duplicate limits for the tenant %q", s)
+		}
+		if tl == nil || tl.isEmpty() {
+			// Explicitly disable limits for the given tenant.
+			tl = nil
+		}
+		ptl.tenants[tenantID] = tl
+	}
+	return ptl, nil
+}
+
+func loadTenantLimits(path string) (*parsedTenantLimits, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, err
+	}
+	ptl, err := parseTenantLimits(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return ptl, nil
+}
+
+var (
+	// tenantLimitersLock serializes setTenantLimits calls.
+	tenantLimitersLock sync.Mutex
+
+	// tenantLimiters contains the current snapshot of per-tenant limiters. It is nil if tenant limits are disabled.
+	tenantLimiters atomic.Pointer[tenantLimitersSnapshot]
+
+	tenantLimitsReloaderStopCh chan struct{}
+	tenantLimitsReloaderWG     sync.WaitGroup
+)
+
+// tenantLimitersSnapshot contains per-tenant limiters for the given limits.
+//
+// A new snapshot is created on every reload of -insert.tenantLimitsFile, so readers do not need locks.
+type tenantLimitersSnapshot struct {
+	limits *parsedTenantLimits
+
+	// limiters contains *tenantLimiter per logstorage.TenantID.
+	//
+	// It contains nil *tenantLimiter for tenants without limits, so the lookup for limits isn't repeated for such tenants.
+	limiters sync.Map
+}
+
+// MustInitTenantLimits initializes per-tenant limits from -insert.tenantLimitsFile.
+//
+// MustStopTenantLimits must be called when the per-tenant limits are no longer needed.
+func MustInitTenantLimits() {
+	if *tenantLimitsFile == "" {
+		return
+	}
+
+	ptl, err := loadTenantLimits(*tenantLimitsFile)
+	if err != nil {
+		logger.Fatalf("cannot load -insert.tenantLimitsFile: %s", err)
+	}
+	setTenantLimits(ptl)
+
+	sighupCh := procutil.NewSighupChan()
+	tenantLimitsReloaderStopCh = make(chan struct{})
+	tenantLimitsReloaderWG.Go(func() {
+		for {
+			select {
+			case <-tenantLimitsReloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -insert.tenantLimitsFile=%q", *tenantLimitsFile)
+			tenantLimitsReloads.Inc()
+			ptl, err := loadTenantLimits(*tenantLimitsFile)
+			if err != nil {
+				tenantLimitsReloadErrors.Inc()
+				logger.Errorf("cannot reload -insert.tenantLimitsFile; continuing using the previously loaded config; error: %s", err)
+				continue
+			}
+			setTenantLimits(ptl)
+			logger.Infof("successfully reloaded -insert.tenantLimitsFile=%q", *tenantLimitsFile)
+		}
+	})
+}
+
+// MustStopTenantLimits stops per-tenant limits initialized via MustInitTenantLimits.
+func MustStopTenantLimits() {
+	if tenantLimitsReloaderStopCh == nil {
+		return
+	}
+	close(tenantLimitsReloaderStopCh)
+	tenantLimitsReloaderWG.Wait()
+	tenantLimitsReloaderStopCh = nil
+
+	setTenantLimits(nil)
+}
+
+func setTenantLimits(ptl *parsedTenantLimits) {
+	tenantLimitersLock.Lock()
+	defer tenantLimitersLock.Unlock()
+
+	prev := tenantLimiters.Load()
+	if ptl == nil {
+		tenantLimiters.Store(nil)
+	} else {
+		tenantLimiters.Store(&tenantLimitersSnapshot{
+			limits: ptl,
+		})
+	}
+	if prev == nil {
+		return
+	}
+
+	// Move the existing limiters to the new snapshot, so they continue tracking log streams for tenants.
+	tls := tenantLimiters.Load()
+	prev.limiters.Range(func(k, v any) bool {
+		tlr := v.(*tenantLimiter)
+		if tlr == nil {
+			return true
+		}
+		var tl *TenantLimits
+		if tls != nil {
+			tl = tls.limits.getLimits(tlr.tenantID)
+		}
+		tlr.setLimits(tl)
+		if tl != nil {
+			tls.limiters.Store(tlr.tenantID, tlr)
+		}
+		return true
+	})
+}
+
+// getTenantLimiter returns limiter for the given tenantID.
+//
+// nil is returned if there are no limits for the given tenantID.
+func getTenantLimiter(tenantID logstorage.TenantID) *tenantLimiter {
+	tls := tenantLimiters.Load()
+	if tls == nil {
+		// Fast path - tenant limits are disabled.
+		return nil
+	}
+	return tls.getLimiter(tenantID)
+}
+
+func (tls *tenantLimitersSnapshot) getLimiter(tenantID logstorage.TenantID) *tenantLimiter {
+	if v, ok := tls.limiters.Load(tenantID); ok {
+		// Fast path - the limiter for the tenantID has been already created.
+		return v.(*tenantLimiter)
+	}
+
+	// Slow path - create the limiter for the tenantID.
+	var tlr *tenantLimiter
+	if tl := tls.limits.getLimits(tenantID); tl != nil {
+		tlr = newTenantLimiter(tenantID, tl)
+	}
+	v, _ := tls.limiters.LoadOrStore(tenantID, tlr)
+	return v.(*tenantLimiter)
+}
+
+// CheckTenantLimits returns non-nil error if logs cannot be ingested into the given tenantID because of exceeded per-tenant limits.
+//
+// The returned error contains http.StatusTooManyRequests status code, which can be passed to httpserver.Errorf.
+//
+// See https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits
+func CheckTenantLimits(tenantID logstorage.TenantID) error {
+	tlr := getTenantLimiter(tenantID)
+	if tlr == nil {
+		return nil
+	}
+	return tlr.checkRates()
+}
+
+// tenantLimiter enforces TenantLimits for a single tenant.
+type tenantLimiter struct {
+	tenantID logstorage.TenantID
+
+	mu sync.Mutex
+
+	// limits are the limits for the tenant. They may be nil if limits were removed during config reload.
+	limits *TenantLimits
+
+	// rowsBudget and bytesBudget are token buckets for rows and bytes rate limits.
+	//
+	// They are refilled at the rate of the corresponding limits per second. They may become negative
+	// if the ingested request exceeds the remaining budget. New requests are rejected until the budget becomes positive.
+	rowsBudget     float64
+	bytesBudget    float64
+	lastRefillTime time.Time
+
+	// hourlyStreams contains unique streams seen during the current hour.
+	hourlyStreams     map[uint64]struct{}
+	hourlyStreamsHour uint64
+
+	// dailyStreams contains unique streams seen during the current day.
+	dailyStreams    map[uint64]struct{}
+	dailyStreamsDay uint64
+
+	requestsRejectedRowsRate  *metrics.Counter
+	requestsRejectedBytesRate *metrics.Counter
+
+	rowsDroppedRowsRate      *metrics.Counter
+	rowsDroppedBytesRate     *metrics.Counter
+	rowsDroppedActiveStreams *metrics.Counter
+	rowsDroppedNewStreams    *metrics.Counter
+}
+
+func newTenantLimiter(tenantID logstorage.TenantID, tl *TenantLimits) *tenantLimiter {
+	labels := func(limit string) string {
+		return fmt.Sprintf(`{accountID="%d",projectID="%d",limit=%q}`, tenantID.AccountID, tenantID.ProjectID, limit)
+	}
+	requestsRejected := func(limit string) *metrics.Counter {
+		return metrics.GetOrCreateCounter(`vl_requests_rejected_by_tenant_limits_total` + labels(limit))
+	}
+	rowsDropped := func(limit string) *metrics.Counter {
+		return metrics.GetOrCreateCounter(`vl_rows_dropped_by_tenant_limits_total` + labels(limit))
+	}
+
+	tlr := &tenantLimiter{
+		tenantID: tenantID,
+
+		requestsRejectedRowsRate:  requestsRejected("max_rows_per_second"),
+		requestsRejectedBytesRate: requestsRejected("max_bytes_per_second"),
+
+		rowsDroppedRowsRate:      rowsDropped("max_rows_per_second"),
+		rowsDroppedBytesRate:     rowsDropped("max_bytes_per_second"),
+		rowsDroppedActiveStreams: rowsDropped("max_active_streams"),
+		rowsDroppedNewStreams:    rowsDropped("max_new_streams_per_day"),
+	}
+	tlr.setLimits(tl)
+	return tlr
+}
+
+func (tlr *tenantLimiter) setLimits(tl *TenantLimits) {
+	tlr.mu.Lock()
+	defer tlr.mu.Unlock()
+
+	tlr.limits = tl
+	tlr.lastRefillTime = time.Now()
+	if tl == nil {
+		return
+	}
+
+	// Start with the budget for a single second.
+	tlr.rowsBudget = float64(tl.MaxRowsPerSecond)
+	tlr.bytesBudget = float64(tl.MaxBytesPerSecond)
+}
+
+// refillLocked refills rate limit budgets according to the time passed since the previous refill.
+func (tlr *tenantLimiter) refillLocked() {
+	tl := tlr.limits
+
+	now := time.Now()
+	secs := now.Sub(tlr.lastRefillTime).Seconds()
+	tlr.lastRefillTime = now
+
+	// Do not allow accumulating the budget for more than a second, since this may result in big bursts.
+	if tl.MaxRowsPerSecond > 0 {
+		tlr.rowsBudget = min(tlr.rowsBudget+secs*float64(tl.MaxRowsPerSecond), float64(tl.MaxRowsPerSecond))
+	}
+	if tl.MaxBytesPerSecond > 0 {
+		tlr.bytesBudget = min(tlr.bytesBudget+secs*float64(tl.MaxBytesPerSecond), float64(tl.MaxBytesPerSecond))
+	}
+}
+
+// checkRates returns an error if the tenant exhausted its rate limit budget.
+func (tlr *tenantLimiter) checkRates() error {
+	tlr.mu.Lock()
+	defer tlr.mu.Unlock()
+
+	tl := tlr.limits
+	if tl == nil {
+		return nil
+	}
+	tlr.refillLocked()
+
+	if tl.MaxRowsPerSecond > 0 && tlr.rowsBudget <= 0 {
+		tlr.requestsRejectedRowsRate.Inc()
+		return tlr.newRateLimitError("max_rows_per_second", tl.MaxRowsPerSecond)
+	}
+	if tl.MaxBytesPerSecond > 0 && tlr.bytesBudget <= 0 {
+		tlr.requestsRejectedBytesRate.Inc()
+		return tlr.newRateLimitError("max_bytes_per_second", tl.MaxBytesPerSecond)
+	}
+	return nil
+}
+
+func (tlr *tenantLimiter) newRateLimitError(limitName string, limit int64) error {
+	err := fmt.Errorf("the tenant %d:%d exceeded %s=%d limit; try again later; see https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits",
+		tlr.tenantID.AccountID, tlr.tenantID.ProjectID, limitName, limit)
+	return &httpserver.ErrorWithStatusCode{
+		Err:        err,
+		StatusCode: http.StatusTooManyRequests,
+	}
+}
+
+// hasStreamLimits returns true if tlr has limits on log streams.
+func (tlr *tenantLimiter) hasStreamLimits() bool {
+	tlr.mu.Lock()
+	defer tlr.mu.Unlock()
+
+	return tlr.limits != nil && tlr.limits.hasStreamLimits()
+}
+
+// allowRows returns true if the given number of log entries with the given total length can be ingested into the tenant.
+//
+// All these log entries must be dropped if false is returned.
+func (tlr *tenantLimiter) allowRows(rowsCount, bytesCount int) bool {
+	tlr.mu.Lock()
+	defer tlr.mu.Unlock()
+
+	tl := tlr.limits
+	if tl == nil {
+		return true
+	}
+	if tl.MaxRowsPerSecond <= 0 && tl.MaxBytesPerSecond <= 0 {
+		return true
+	}
+
+	tlr.refillLocked()
+
+	// Allow the budget to become negative within a single second, so the already accepted requests are ingested in full.
+	// This allows clients to retry requests rejected with 429 status code without data loss.
+	// Drop log entries if the budget is exceeded for more than a second. This may happen for streaming ingestion protocols
+	// such as syslog or jsonline stream, since they are checked via CheckTenantLimits only once at the stream start.
+	if tl.MaxRowsPerSecond > 0 && tlr.rowsBudget <= -float64(tl.MaxRowsPerSecond) {
+		tlr.rowsDroppedRowsRate.Add(rowsCount)
+		tlr.logDroppedRows(rowsCount, "max_rows_per_second", tl.MaxRowsPerSecond)
+		return false
+	}
+	if tl.MaxBytesPerSecond > 0 && tlr.bytesBudget <= -float64(tl.MaxBytesPerSecond) {
+		tlr.rowsDroppedBytesRate.Add(rowsCount)
+		tlr.logDroppedRows(rowsCount, "max_bytes_per_second", tl.MaxBytesPerSecond)
+		return false
+	}
+	tlr.rowsBudget -= float64(rowsCount)
+	tlr.bytesBudget -= float64(bytesCount)
+	return true
+}
+
+func (tlr *tenantLimiter) logDroppedRows(rowsCount int, limitName string, limit int64) {
+	tenantLimitsLogger.Warnf("dropping %d log entries for the tenant %d:%d, since it exceeds %s=%d limit; "+
+		"see https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits", rowsCount, tlr.tenantID.AccountID, tlr.tenantID.ProjectID, limitName, limit)
+}
+
+// allowStream returns true if the log entry for the log stream with the given streamHash can be ingested into the tenant.
+//
+// The log entry must be dropped if false is returned.
+func (tlr *tenantLimiter) allowStream(streamHash uint64) bool {
+	tlr.mu.Lock()
+	defer tlr.mu.Unlock()
+
+	tl := tlr.limits
+	if tl == nil || !tl.hasStreamLimits() {
+		return true
+	}
+	return tlr.allowStreamLocked(streamHash)
+}
+
+func (tlr *tenantLimiter) allowStreamLocked(streamHash uint64) bool {
+	tl := tlr.limits
+	currentTime := fasttime.UnixTimestamp()
+
+	hour := currentTime / 3600
+	if hour != tlr.hourlyStreamsHour || tlr.hourlyStreams == nil {
+		tlr.hourlyStreams = make(map[uint64]struct{})
+		tlr.hourlyStreamsHour = hour
+	}
+	day := currentTime / (24 * 3600)
+	if day != tlr.dailyStreamsDay || tlr.dailyStreams == nil {
+		tlr.dailyStreams = make(map[uint64]struct{})
+		tlr.dailyStreamsDay = day
+	}
+
+	if _, ok := tlr.hourlyStreams[streamHash]; ok {
+		// Fast path - the stream has been already seen during the current hour.
+		return true
+	}
+
+	if tl.MaxActiveStreams > 0 && len(tlr.hourlyStreams) >= tl.MaxActiveStreams {
+		tlr.rowsDroppedActiveStreams.Inc()
+		tenantLimitsLogger.Warnf("dropping log entry for the tenant %d:%d, since it exceeds max_active_streams=%d limit; "+
+			"see https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits", tlr.tenantID.AccountID, tlr.tenantID.ProjectID, tl.MaxActiveStreams)
+		return false
+	}
+	if _, ok := tlr.dailyStreams[streamHash]; !ok {
+		if tl.MaxNewStreamsPerDay > 0 && len(tlr.dailyStreams) >= tl.MaxNewStreamsPerDay {
+			tlr.rowsDroppedNewStreams.Inc()
+			tenantLimitsLogger.Warnf("dropping log entry for the tenant %d:%d, since it exceeds max_new_streams_per_day=%d limit; "+
+				"see https://docs.victoriametrics.com/victorialogs/data-ingestion/#tenant-limits", tlr.tenantID.AccountID, tlr.tenantID.ProjectID, tl.MaxNewStreamsPerDay)
+			return false
+		}
+		tlr.dailyStreams[streamHash] = struct{}{}
+	}
+	tlr.hourlyStreams[streamHash] = struct{}{}
+	return true
+}
+
+var tenantLimitsLogger = logger.WithThrottler("tenant_limits", 5*time.Second)
+
+var rowsDroppedTotalTenantLimits = metrics.NewCounter(`vl_rows_dropped_total{reason="tenant_limits"}`)
+
+// tenantBlockLimits contains the state for applying per-tenant limits to log entries for a single tenant at a single block.
+type tenantBlockLimits struct {
+	tenantID logstorage.TenantID
+	tlr      *tenantLimiter
+
+	checkStreams   bool
+	allowedStreams map[uint64]struct{}
+
+	rowsCount  int
+	bytesCount int
+
+	allowed bool
+}
+
+func (tbl *tenantBlockLimits) allowStream(streamHash uint64) bool {
+	if _, ok := tbl.allowedStreams[streamHash]; ok {
+		// Fast path - the log stream has been already allowed for the block.
+		return true
+	}
+	if !tbl.tlr.allowStream(streamHash) {
+		return false
+	}
+	if tbl.allowedStreams == nil {
+		tbl.allowedStreams = make(map[uint64]struct{})
+	}
+	tbl.allowedStreams[streamHash] = struct{}{}
+	return true
+}
+
+// applyTenantLimits drops log entries from lr, which exceed per-tenant limits.
+//
+// Rate limits are checked once per every tenant in lr, so either all the log entries for the tenant in lr are ingested or all of them are dropped.
+// Limits on log streams are checked once per every log stream in lr.
+func applyTenantLimits(lr *logstorage.LogRows) {
+	tls := tenantLimiters.Load()
+	if tls == nil {
+		// Fast path - tenant limits are disabled.
+		return
+	}
+
+	var tbls []*tenantBlockLimits
+	var tbl *tenantBlockLimits
+	getTenantBlockLimits := func(tenantID logstorage.TenantID) *tenantBlockLimits {
+		if tbl != nil && tbl.tenantID.Equal(&tenantID) {
+			// Fast path - log entries for the same tenant are usually stored together.
+			return tbl
+		}
+		for _, tbl = range tbls {
+			if tbl.tenantID.Equal(&tenantID) {
+				return tbl
+			}
+		}
+		tlr := tls.getLimiter(tenantID)
+		tbl = &tenantBlockLimits{
+			tenantID:     tenantID,
+			tlr:          tlr,
+			checkStreams: tlr != nil && tlr.hasStreamLimits(),
+			allowed:      true,
+		}
+		tbls = append(tbls, tbl)
+		return tbl
+	}
+
+	// Apply limits on log streams and collect the number of log entries with their lengths per every tenant.
+	rowsDropped := 0
+	lr.FilterRows(func(streamHash uint64, r *logstorage.InsertRow) bool {
+		tbl := getTenantBlockLimits(r.TenantID)
+		if tbl.tlr == nil {
+			return true
+		}
+		if tbl.checkStreams && !tbl.allowStream(streamHash) {
+			rowsDropped++
+			return false
+		}
+		tbl.rowsCount++
+		tbl.bytesCount += logstorage.EstimatedJSONRowLen(r.Fields)
+		return true
+	})
+
+	// Apply rate limits per every tenant.
+	hasDroppedTenants := false
+	for _, tbl := range tbls {
+		if tbl.tlr != nil && !tbl.tlr.allowRows(tbl.rowsCount, tbl.bytesCount) {
+			tbl.allowed = false
+			hasDroppedTenants = true
+			rowsDropped += tbl.rowsCount
+		}
+	}
+	if hasDroppedTenants {
+		lr.FilterRows(func(_ uint64, r *logstorage.InsertRow) bool {
+			return getTenantBlockLimits(r.TenantID).allowed
+		})
+	}
+
+	rowsDroppedTotalTenantLimits.Add(rowsDropped)
+}
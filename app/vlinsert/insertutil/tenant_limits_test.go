@@ -0,0 +1,307 @@
+package insertutil
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestParseTenantLimitsSuccess(t *testing.T) {
+	f := func(data string, tenantID logstorage.TenantID, limitsExpected *TenantLimits) {
+		t.Helper()
+
+		ptl, err := parseTenantLimits([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		limits := ptl.getLimits(tenantID)
+		if limitsExpected == nil {
+			if limits != nil {
+				t.Fatalf("expecting nil limits; got %+v", limits)
+			}
+			return
+		}
+		if limits == nil {
+			t.Fatalf("expecting non-nil limits %+v", limitsExpected)
+		}
+		if *limits != *limitsExpected {
+			t.Fatalf("unexpected limits\ngot\n%+v\nwant\n%+v", limits, limitsExpected)
+		}
+	}
+
+	tenantID := logstorage.TenantID{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+
+	f(``, tenantID, nil)
+
+	data := `
+default:
+  max_rows_per_second: 1000
+  max_active_streams: 100
+tenants:
+  "12:34":
+    max_bytes_per_second: 1000000
+    max_new_streams_per_day: 500
+  "5": {}
+`
+	f(data, tenantID, &TenantLimits{
+		MaxBytesPerSecond:   1000000,
+		MaxNewStreamsPerDay: 500,
+	})
+	f(data, logstorage.TenantID{}, &TenantLimits{
+		MaxRowsPerSecond: 1000,
+		MaxActiveStreams: 100,
+	})
+
+	// Empty limits for the tenant disable default limits
+	f(data, logstorage.TenantID{AccountID: 5}, nil)
+}
+
+func TestParseTenantLimitsFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		ptl, err := parseTenantLimits([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if ptl != nil {
+			t.Fatalf("expecting nil result; got %v", ptl)
+		}
+	}
+
+	// invalid YAML
+	f(`foo`)
+
+	// unknown field
+	f(`
+default:
+  foo: 123
+`)
+
+	// invalid limit
+	f(`
+default:
+  max_rows_per_second: foo
+`)
+
+	// invalid tenant
+	f(`
+tenants:
+  "foo:bar":
+    max_rows_per_second: 10
+`)
+
+	// duplicate tenant
+	f(`
+tenants:
+  "1":
+    max_rows_per_second: 10
+  "1:0":
+    max_rows_per_second: 20
+`)
+}
+
+func TestTenantLimiterRates(t *testing.T) {
+	tlr := newTenantLimiter(logstorage.TenantID{AccountID: 1}, &TenantLimits{
+		MaxRowsPerSecond: 100,
+	})
+
+	if err := tlr.checkRates(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The budget may become negative within a single second.
+	for i := range 3 {
+		if !tlr.allowRows(67, 670) {
+			t.Fatalf("unexpected dropped rows at the block #%d", i)
+		}
+	}
+
+	// The exhausted budget must result in rejected requests and dropped rows.
+	err := tlr.checkRates()
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	var esc *httpserver.ErrorWithStatusCode
+	if !errors.As(err, &esc) {
+		t.Fatalf("expecting error with status code; got %T", err)
+	}
+	if esc.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code; got %d; want %d", esc.StatusCode, http.StatusTooManyRequests)
+	}
+	if tlr.allowRows(1, 10) {
+		t.Fatalf("expecting dropped rows")
+	}
+
+	// Removed limits must allow everything.
+	tlr.setLimits(nil)
+	if err := tlr.checkRates(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !tlr.allowRows(1, 10) {
+		t.Fatalf("unexpected dropped rows")
+	}
+}
+
+func TestTenantLimiterStreams(t *testing.T) {
+	f := func(tl *TenantLimits, streamHashes []uint64, resultsExpected []bool) {
+		t.Helper()
+
+		tlr := newTenantLimiter(logstorage.TenantID{AccountID: 2}, tl)
+		if !tlr.hasStreamLimits() {
+			t.Fatalf("expecting hasStreamLimits() to return true")
+		}
+		for i, h := range streamHashes {
+			result := tlr.allowStream(h)
+			if result != resultsExpected[i] {
+				t.Fatalf("unexpected result for stream #%d (hash=%d); got %v; want %v", i, h, result, resultsExpected[i])
+			}
+		}
+	}
+
+	f(&TenantLimits{
+		MaxActiveStreams: 2,
+	}, []uint64{1, 2, 1, 3, 2, 4}, []bool{true, true, true, false, true, false})
+
+	f(&TenantLimits{
+		MaxNewStreamsPerDay: 1,
+	}, []uint64{1, 1, 2, 1}, []bool{true, true, false, true})
+}
+
+func TestApplyTenantLimits(t *testing.T) {
+	ptl, err := parseTenantLimits([]byte(`
+tenants:
+  "1":
+    max_rows_per_second: 2
+  "2":
+    max_active_streams: 1
+`))
+	if err != nil {
+		t.Fatalf("cannot parse tenant limits: %s", err)
+	}
+	setTenantLimits(ptl)
+	defer setTenantLimits(nil)
+
+	f := func(rows []string, resultExpected []string) {
+		t.Helper()
+
+		lr := logstorage.GetLogRows([]string{"host"}, nil, nil, nil, "")
+		defer logstorage.PutLogRows(lr)
+
+		p := logstorage.GetJSONParser()
+		defer logstorage.PutJSONParser(p)
+
+		for i, r := range rows {
+			if err := p.ParseLogMessage([]byte(r), nil); err != nil {
+				t.Fatalf("unexpected error when parsing %q: %s", r, err)
+			}
+			accountID, err := strconv.ParseUint(p.Fields[0].Value, 10, 32)
+			if err != nil {
+				t.Fatalf("cannot parse accountID: %s", err)
+			}
+			tenantID := logstorage.TenantID{
+				AccountID: uint32(accountID),
+			}
+			lr.MustAdd(tenantID, int64(i), p.Fields[1:], -1)
+		}
+
+		applyTenantLimits(lr)
+
+		var result []string
+		lr.ForEachRow(func(_ uint64, r *logstorage.InsertRow) {
+			result = append(result, r.Fields[len(r.Fields)-1].Value)
+		})
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected rows left after applying tenant limits\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	// The first block for the tenant 1 is accepted, since the rows budget may become negative within a single second.
+	// The second block for the tenant 1 is dropped, since the rows budget is exhausted.
+	// The tenant 2 may ingest logs only for a single log stream.
+	// The tenant 3 has no limits.
+	f([]string{
+		`{"account_id":"1","host":"a","_msg":"1a"}`,
+		`{"account_id":"1","host":"b","_msg":"1b"}`,
+		`{"account_id":"1","host":"c","_msg":"1c"}`,
+		`{"account_id":"1","host":"d","_msg":"1d"}`,
+		`{"account_id":"1","host":"e","_msg":"1e"}`,
+		`{"account_id":"2","host":"a","_msg":"2a"}`,
+		`{"account_id":"2","host":"b","_msg":"2b"}`,
+		`{"account_id":"2","host":"a","_msg":"2a"}`,
+		`{"account_id":"3","host":"a","_msg":"3a"}`,
+	}, []string{"1a", "1b", "1c", "1d", "1e", "2a", "2a", "3a"})
+	f([]string{
+		`{"account_id":"1","host":"a","_msg":"1a"}`,
+		`{"account_id":"2","host":"c","_msg":"2c"}`,
+		`{"account_id":"2","host":"a","_msg":"2a"}`,
+		`{"account_id":"3","host":"c","_msg":"3c"}`,
+	}, []string{"2a", "3c"})
+}
+
+func TestLogMessageProcessorSkipTenantLimits(t *testing.T) {
+	ptl, err := parseTenantLimits([]byte(`
+default:
+  max_active_streams: 1
+`))
+	if err != nil {
+		t.Fatalf("cannot parse tenant limits: %s", err)
+	}
+	setTenantLimits(ptl)
+	defer setTenantLimits(nil)
+
+	var s countingStorage
+	SetLogRowsStorage(&s)
+	defer SetLogRowsStorage(nil)
+
+	f := func(skipTenantLimits bool, rowsExpected int) {
+		t.Helper()
+
+		s.rows = 0
+		cp := &CommonParams{
+			TenantID:         logstorage.TenantID{AccountID: 42},
+			StreamFields:     []string{"host"},
+			SkipTenantLimits: skipTenantLimits,
+		}
+		lmp := cp.NewLogMessageProcessor("test", false)
+		for _, host := range []string{"a", "b", "c"} {
+			fields := []logstorage.Field{
+				{Name: "host", Value: host},
+				{Name: "_msg", Value: "foo"},
+			}
+			lmp.AddRow(123, fields, -1)
+		}
+		lmp.MustClose()
+
+		if s.rows != rowsExpected {
+			t.Fatalf("unexpected number of stored rows; got %d; want %d", s.rows, rowsExpected)
+		}
+	}
+
+	// Rows forwarded from the ingestion entry point aren't limited again.
+	f(true, 3)
+
+	// Rows at the ingestion entry point are limited.
+	f(false, 1)
+}
+
+type countingStorage struct {
+	rows int
+}
+
+func (s *countingStorage) MustAddRows(lr *logstorage.LogRows) {
+	s.rows += lr.RowsCount()
+}
+
+func (s *countingStorage) CanWriteData() error {
+	return nil
+}
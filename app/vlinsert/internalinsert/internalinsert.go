@@ -58,6 +58,9 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) {
 	// Unconditionally reset cp.TimeFields, since the code below shouldn't depend on this field.
 	cp.TimeFields = nil
 
+	// Tenant limits are applied at the ingestion entry point, which forwarded the rows to /internal/insert.
+	cp.SkipTenantLimits = true
+
 	if len(cp.MsgFields) > 0 {
 		unsupportedOptionsLogger.Warnf("/internal/insert endpoint doesn't support setting msg fields via _msg_field query arg and via VL-Msg-Field request header; "+
 			"ignoring them; msgFields=%q", cp.MsgFields)
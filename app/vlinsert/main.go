@@ -10,6 +10,7 @@ import (
 
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/datadog"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/elasticsearch"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/internalinsert"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/journald"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/jsonline"
@@ -26,12 +27,16 @@ var (
 
 // Init initializes vlinsert
 func Init() {
+	insertutil.MustInitTenantLimits()
 	syslog.MustInit()
+	opentelemetry.MustInitGRPC()
 }
 
 // Stop stops vlinsert
 func Stop() {
+	opentelemetry.MustStopGRPC()
 	syslog.MustStop()
+	insertutil.MustStopTenantLimits()
 }
 
 // RequestHandler handles insert requests for VictoriaLogs
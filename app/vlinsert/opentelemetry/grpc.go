@@ -0,0 +1,420 @@
+package opentelemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/easyproto"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var (
+	grpcListenAddr = flag.String("opentelemetry.grpcListenAddr", "", "TCP address to listen to for OpenTelemetry logs sent via OTLP/gRPC protocol. "+
+		"For example, :4317 . By default OTLP/gRPC listener is disabled. "+
+		"See https://docs.victoriametrics.com/victorialogs/data-ingestion/opentelemetry/#otlpgrpc")
+	grpcTLS = flag.Bool("opentelemetry.grpc.tls", false, "Whether to enable TLS for receiving OTLP/gRPC requests at -opentelemetry.grpcListenAddr. "+
+		"-opentelemetry.grpc.tlsCertFile and -opentelemetry.grpc.tlsKeyFile must be set if -opentelemetry.grpc.tls is set")
+	grpcTLSCertFile = flag.String("opentelemetry.grpc.tlsCertFile", "", "Path to file with TLS certificate for -opentelemetry.grpcListenAddr if -opentelemetry.grpc.tls is set. "+
+		"The provided certificate file is automatically re-read every second, so it can be dynamically updated")
+	grpcTLSKeyFile = flag.String("opentelemetry.grpc.tlsKeyFile", "", "Path to file with TLS key for -opentelemetry.grpcListenAddr if -opentelemetry.grpc.tls is set. "+
+		"The provided key file is automatically re-read every second, so it can be dynamically updated")
+	grpcTLSMinVersion = flag.String("opentelemetry.grpc.tlsMinVersion", "TLS13", "The minimum TLS version to use for -opentelemetry.grpcListenAddr if -opentelemetry.grpc.tls is set. "+
+		"Supported values: TLS10, TLS11, TLS12, TLS13")
+)
+
+// grpcExportPath is the path for the Export method of the OTLP LogsService.
+//
+// See https://github.com/open-telemetry/opentelemetry-proto/blob/a5f0eac5b802f7ae51dfe41e5116fe5548955e64/opentelemetry/proto/collector/logs/v1/logs_service.proto
+const grpcExportPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// gRPC status codes.
+//
+// See https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	grpcCodeOK                = 0
+	grpcCodeInvalidArgument   = 3
+	grpcCodeResourceExhausted = 8
+	grpcCodeUnimplemented     = 12
+	grpcCodeInternal          = 13
+	grpcCodeUnavailable       = 14
+)
+
+var (
+	grpcServer   *http.Server
+	grpcServerWG sync.WaitGroup
+)
+
+// MustInitGRPC starts OTLP/gRPC listener at -opentelemetry.grpcListenAddr if it is set.
+//
+// MustStopGRPC must be called when the listener is no longer needed.
+func MustInitGRPC() {
+	if *grpcListenAddr == "" {
+		return
+	}
+	if grpcServer != nil {
+		logger.Panicf("BUG: MustInitGRPC() called twice without MustStopGRPC() call")
+	}
+
+	var tlsConfig *tls.Config
+	if *grpcTLS {
+		tc, err := netutil.GetServerTLSConfig(*grpcTLSCertFile, *grpcTLSKeyFile, *grpcTLSMinVersion, nil)
+		if err != nil {
+			logger.Fatalf("cannot load TLS cert from -opentelemetry.grpc.tlsCertFile=%q, -opentelemetry.grpc.tlsKeyFile=%q, -opentelemetry.grpc.tlsMinVersion=%q: %s",
+				*grpcTLSCertFile, *grpcTLSKeyFile, *grpcTLSMinVersion, err)
+		}
+		// gRPC requires HTTP/2, which must be negotiated via ALPN for TLS connections.
+		tc.NextProtos = []string{"h2"}
+		tlsConfig = tc
+	}
+	ln, err := netutil.NewTCPListener("opentelemetry_grpc", *grpcListenAddr, false, tlsConfig)
+	if err != nil {
+		logger.Fatalf("cannot start OTLP/gRPC listener at -opentelemetry.grpcListenAddr=%q: %s", *grpcListenAddr, err)
+	}
+
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	grpcServer = &http.Server{
+		Handler:           http.HandlerFunc(handleGRPCRequest),
+		Protocols:         &protocols,
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          logger.StdErrorLogger(),
+	}
+	srv := grpcServer
+	grpcServerWG.Go(func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("cannot serve OTLP/gRPC requests at -opentelemetry.grpcListenAddr=%q: %s", *grpcListenAddr, err)
+		}
+	})
+	logger.Infof("started accepting OTLP/gRPC logs at -opentelemetry.grpcListenAddr=%q", *grpcListenAddr)
+}
+
+// MustStopGRPC stops OTLP/gRPC listener started by MustInitGRPC.
+func MustStopGRPC() {
+	if grpcServer == nil {
+		return
+	}
+	if err := grpcServer.Shutdown(context.Background()); err != nil {
+		logger.Fatalf("cannot stop OTLP/gRPC listener at -opentelemetry.grpcListenAddr=%q: %s", *grpcListenAddr, err)
+	}
+	grpcServerWG.Wait()
+	grpcServer = nil
+	logger.Infof("finished accepting OTLP/gRPC logs at -opentelemetry.grpcListenAddr=%q", *grpcListenAddr)
+}
+
+func handleGRPCRequest(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "unsupported Content-Type; expecting application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.Method != http.MethodPost || r.URL.Path != grpcExportPath {
+		writeGRPCStatus(w, grpcCodeUnimplemented, fmt.Sprintf("unsupported method %s %q; only %q is supported", r.Method, r.URL.Path, grpcExportPath))
+		return
+	}
+
+	startTime := time.Now()
+	requestsGRPCTotal.Inc()
+
+	if err := processGRPCRequest(w, r); err != nil {
+		errorsGRPCTotal.Inc()
+		logger.Warnf("remoteAddr: %s; cannot process OTLP/gRPC request: %s", httpserver.GetQuotedRemoteAddr(r), err.msg)
+		writeGRPCStatus(w, err.code, err.msg)
+		return
+	}
+
+	// update requestGRPCDuration only for successfully parsed requests
+	requestGRPCDuration.UpdateDuration(startTime)
+}
+
+var (
+	requestsGRPCTotal = metrics.NewCounter(`vl_grpc_requests_total{method="` + grpcExportPath + `"}`)
+	errorsGRPCTotal   = metrics.NewCounter(`vl_grpc_errors_total{method="` + grpcExportPath + `"}`)
+
+	rejectedGRPCLogRecordsTotal = metrics.NewCounter(`vl_grpc_rejected_log_records_total{method="` + grpcExportPath + `"}`)
+
+	requestGRPCDuration = metrics.NewSummary(`vl_grpc_request_duration_seconds{method="` + grpcExportPath + `"}`)
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func processGRPCRequest(w http.ResponseWriter, r *http.Request) *grpcError {
+	// Metadata from gRPC request is passed in HTTP/2 headers, so the tenant and other ingestion params
+	// can be set via the same headers as for OTLP/HTTP requests.
+	cp, err := insertutil.GetCommonParams(r)
+	if err != nil {
+		return &grpcError{
+			code: grpcCodeInvalidArgument,
+			msg:  fmt.Sprintf("cannot parse common params from request: %s", err),
+		}
+	}
+	if err := cp.CanWriteData(); err != nil {
+		return &grpcError{
+			code: getGRPCCodeForError(err),
+			msg:  err.Error(),
+		}
+	}
+
+	encoding := r.Header.Get("Grpc-Encoding")
+	switch encoding {
+	case "", "identity":
+		encoding = ""
+	case "gzip", "zstd":
+	default:
+		w.Header().Set("Grpc-Accept-Encoding", "identity,gzip,zstd")
+		return &grpcError{
+			code: grpcCodeUnimplemented,
+			msg:  fmt.Sprintf("unsupported grpc-encoding=%q; supported encodings: identity, gzip, zstd", encoding),
+		}
+	}
+
+	msgReader, isCompressed, err := readGRPCMessageHeader(r.Body)
+	if err != nil {
+		return &grpcError{
+			code: getGRPCCodeForError(err),
+			msg:  err.Error(),
+		}
+	}
+	if !isCompressed {
+		encoding = ""
+	}
+
+	var rowsAdded, rowsRejected int
+	err = protoparserutil.ReadUncompressedData(msgReader, encoding, maxRequestSize, func(data []byte) error {
+		lmp := cp.NewLogMessageProcessor("opentelemetry_grpc", false)
+		clmp := &countingLogMessageProcessor{
+			lmp: lmp,
+		}
+		useDefaultStreamFields := len(cp.StreamFields) == 0
+		err := pushProtobufRequest(data, clmp, cp.MsgFields, useDefaultStreamFields)
+		lmp.MustClose()
+
+		rowsAdded = clmp.rowsAdded
+		if err != nil {
+			rowsRejected = countLogRecords(data) - rowsAdded
+		}
+		return err
+	})
+	if err != nil && rowsAdded == 0 {
+		return &grpcError{
+			code: getGRPCCodeForError(err),
+			msg:  fmt.Sprintf("cannot read OpenTelemetry protocol data: %s", err),
+		}
+	}
+
+	// Some of the log records were ingested. Report the remaining log records via partial_success response field,
+	// so the client doesn't retry the whole request and doesn't create duplicate logs.
+	// See https://opentelemetry.io/docs/specs/otlp/#partial-success
+	var errMsg string
+	if err != nil {
+		errMsg = err.Error()
+		if rowsRejected <= 0 {
+			// The number of rejected log records cannot be determined, since the request is malformed.
+			rowsRejected = 1
+		}
+		rejectedGRPCLogRecordsTotal.Add(rowsRejected)
+	}
+	writeGRPCResponse(w, marshalExportLogsServiceResponse(nil, rowsRejected, errMsg))
+	return nil
+}
+
+// countingLogMessageProcessor counts the number of rows passed to lmp.
+type countingLogMessageProcessor struct {
+	lmp       insertutil.LogMessageProcessor
+	rowsAdded int
+}
+
+func (clmp *countingLogMessageProcessor) AddRow(timestamp int64, fields []logstorage.Field, streamFieldsLen int) {
+	clmp.rowsAdded++
+	clmp.lmp.AddRow(timestamp, fields, streamFieldsLen)
+}
+
+func (clmp *countingLogMessageProcessor) MustClose() {
+	clmp.lmp.MustClose()
+}
+
+// readGRPCMessageHeader reads the Length-Prefixed-Message header from r and returns the reader for the message contents.
+//
+// It also returns whether the message is compressed.
+//
+// See https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md#requests
+func readGRPCMessageHeader(r io.Reader) (io.Reader, bool, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, false, &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("cannot read gRPC message header: %w", err),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	var isCompressed bool
+	switch header[0] {
+	case 0:
+	case 1:
+		isCompressed = true
+	default:
+		return nil, false, &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("unexpected Compressed-Flag=%d in gRPC message header; want 0 or 1", header[0]),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	msgLen := binary.BigEndian.Uint32(header[1:])
+	if int64(msgLen) > maxRequestSize.N {
+		return nil, false, &httpserver.ErrorWithStatusCode{
+			Err:        fmt.Errorf("too big gRPC message size: %d bytes; it mustn't exceed -opentelemetry.maxRequestSize=%d bytes", msgLen, maxRequestSize.N),
+			StatusCode: http.StatusRequestEntityTooLarge,
+		}
+	}
+	return io.LimitReader(r, int64(msgLen)), isCompressed, nil
+}
+
+// countLogRecords returns the number of LogRecord entries in the LogsData protobuf message at src.
+//
+// It returns the number of LogRecord entries found before the first malformed message.
+func countLogRecords(src []byte) int {
+	n := 0
+	var fcRL, fcSL, fcLR easyproto.FieldContext
+	var err error
+	for len(src) > 0 {
+		if src, err = fcRL.NextField(src); err != nil {
+			return n
+		}
+		if fcRL.FieldNum != 1 {
+			continue
+		}
+		rlData, ok := fcRL.MessageData()
+		if !ok {
+			return n
+		}
+		for len(rlData) > 0 {
+			if rlData, err = fcSL.NextField(rlData); err != nil {
+				return n
+			}
+			if fcSL.FieldNum != 2 {
+				continue
+			}
+			slData, ok := fcSL.MessageData()
+			if !ok {
+				return n
+			}
+			for len(slData) > 0 {
+				if slData, err = fcLR.NextField(slData); err != nil {
+					return n
+				}
+				if fcLR.FieldNum == 2 {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// marshalExportLogsServiceResponse appends ExportLogsServiceResponse protobuf message to dst and returns the result.
+//
+// See https://github.com/open-telemetry/opentelemetry-proto/blob/a5f0eac5b802f7ae51dfe41e5116fe5548955e64/opentelemetry/proto/collector/logs/v1/logs_service.proto#L43
+func marshalExportLogsServiceResponse(dst []byte, rejectedLogRecords int, errMsg string) []byte {
+	// message ExportLogsServiceResponse {
+	//   ExportLogsPartialSuccess partial_success = 1;
+	// }
+	//
+	// message ExportLogsPartialSuccess {
+	//   int64 rejected_log_records = 1;
+	//   string error_message = 2;
+	// }
+	m := mp.Get()
+	mm := m.MessageMarshaler()
+	if rejectedLogRecords > 0 || errMsg != "" {
+		ps := mm.AppendMessage(1)
+		ps.AppendInt64(1, int64(rejectedLogRecords))
+		ps.AppendString(2, errMsg)
+	}
+	dst = m.Marshal(dst)
+	mp.Put(m)
+	return dst
+}
+
+var mp easyproto.MarshalerPool
+
+// writeGRPCResponse writes successful gRPC response with the given protobuf message to w.
+func writeGRPCResponse(w http.ResponseWriter, msg []byte) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc+proto")
+	h.Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	_, _ = w.Write(header[:])
+	_, _ = w.Write(msg)
+
+	h.Set("Grpc-Status", strconv.Itoa(grpcCodeOK))
+}
+
+// writeGRPCStatus writes Trailers-Only gRPC response with the given status code and message to w.
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc+proto")
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", encodeGRPCMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// getGRPCCodeForError returns gRPC status code for the given err.
+//
+// See https://opentelemetry.io/docs/specs/otlp/#failures about retryable and non-retryable codes.
+func getGRPCCodeForError(err error) int {
+	var esc *httpserver.ErrorWithStatusCode
+	if !errors.As(err, &esc) {
+		return grpcCodeInvalidArgument
+	}
+	switch esc.StatusCode {
+	case http.StatusTooManyRequests:
+		return grpcCodeResourceExhausted
+	case http.StatusServiceUnavailable:
+		return grpcCodeUnavailable
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		// Too big requests must not be retried, since they will be rejected again.
+		return grpcCodeInvalidArgument
+	default:
+		return grpcCodeInternal
+	}
+}
+
+// encodeGRPCMessage percent-encodes msg according to gRPC spec for grpc-message header.
+//
+// See https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md#responses
+func encodeGRPCMessage(msg string) string {
+	const hexChars = "0123456789ABCDEF"
+
+	var b []byte
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b = append(b, c)
+			continue
+		}
+		b = append(b, '%', hexChars[c>>4], hexChars[c&15])
+	}
+	return string(b)
+}
@@ -0,0 +1,294 @@
+package opentelemetry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/easyproto"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlinsert/insertutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestHandleGRPCRequest(t *testing.T) {
+	storage := &testStorage{}
+	insertutil.SetLogRowsStorage(storage)
+	defer insertutil.SetLogRowsStorage(nil)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handleGRPCRequest))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Config.Protocols = &protocols
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Protocols: &protocols,
+		},
+	}
+
+	f := func(path, encoding string, msg []byte, statusExpected string, rowsExpected int, responseExpected []byte) {
+		t.Helper()
+
+		storage.reset()
+
+		var body []byte
+		if encoding == "gzip" {
+			var bb bytes.Buffer
+			zw := gzip.NewWriter(&bb)
+			if _, err := zw.Write(msg); err != nil {
+				t.Fatalf("cannot compress message: %s", err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatalf("cannot close gzip writer: %s", err)
+			}
+			body = append(body, 1)
+			body = binary.BigEndian.AppendUint32(body, uint32(bb.Len()))
+			body = append(body, bb.Bytes()...)
+		} else {
+			body = append(body, 0)
+			body = binary.BigEndian.AppendUint32(body, uint32(len(msg)))
+			body = append(body, msg...)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("cannot create request: %s", err)
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		if encoding != "" {
+			req.Header.Set("Grpc-Encoding", encoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("cannot read response body: %s", err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("unexpected protocol; got %s; want HTTP/2", resp.Proto)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code; got %d; want %d", resp.StatusCode, http.StatusOK)
+		}
+		status := resp.Header.Get("Grpc-Status")
+		if status == "" {
+			status = resp.Trailer.Get("Grpc-Status")
+		}
+		if status != statusExpected {
+			t.Fatalf("unexpected grpc-status; got %q; want %q; grpc-message: %q", status, statusExpected, resp.Header.Get("Grpc-Message"))
+		}
+		if n := storage.rowsCount(); n != rowsExpected {
+			t.Fatalf("unexpected number of ingested rows; got %d; want %d", n, rowsExpected)
+		}
+		if responseExpected == nil {
+			if len(respBody) > 0 {
+				t.Fatalf("unexpected non-empty response body: %X", respBody)
+			}
+			return
+		}
+		var respExpected []byte
+		respExpected = append(respExpected, 0)
+		respExpected = binary.BigEndian.AppendUint32(respExpected, uint32(len(responseExpected)))
+		respExpected = append(respExpected, responseExpected...)
+		if !bytes.Equal(respBody, respExpected) {
+			t.Fatalf("unexpected response body\ngot\n%X\nwant\n%X", respBody, respExpected)
+		}
+	}
+
+	ld := &logsData{
+		ResourceLogs: []resourceLogs{
+			{
+				ScopeLogs: []scopeLogs{
+					{
+						LogRecords: []logRecord{
+							{
+								TimeUnixNano: 1234,
+								Body: anyValue{
+									StringValue: ptrTo("foo"),
+								},
+							},
+							{
+								TimeUnixNano: 1235,
+								Body: anyValue{
+									StringValue: ptrTo("bar"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	msg := ld.marshalProtobuf(nil)
+
+	// successful requests
+	f(grpcExportPath, "", msg, "0", 2, []byte{})
+	f(grpcExportPath, "gzip", msg, "0", 2, []byte{})
+
+	// partially malformed request
+	msgPartial := appendMalformedResourceLogs(msg)
+	f(grpcExportPath, "", msgPartial, "0", 2, marshalExportLogsServiceResponse(nil, 1, "cannot decode LogsData request from 89 bytes: "+
+		"cannot decode ResourceLogs: cannot decode ScopeLogs: cannot decode LogRecord: cannot decode Body: "+
+		"cannot read the next field: cannot unmarshal field tag from uvarint"))
+
+	// fully malformed request
+	f(grpcExportPath, "", appendMalformedResourceLogs(nil), "3", 0, nil)
+
+	// unsupported encoding
+	f(grpcExportPath, "snappy", msg, "12", 0, nil)
+
+	// unsupported method
+	f("/opentelemetry.proto.collector.logs.v1.LogsService/Foo", "", msg, "12", 0, nil)
+}
+
+// appendMalformedResourceLogs appends ResourceLogs with a single malformed LogRecord to dst.
+func appendMalformedResourceLogs(dst []byte) []byte {
+	m := mp.Get()
+	mm := m.MessageMarshaler()
+	sl := mm.AppendMessage(1).AppendMessage(2)
+	// LogRecord with the body field containing truncated AnyValue message
+	sl.AppendBytes(2, []byte{0x2a, 0x01, 0xff})
+	dst = m.Marshal(dst)
+	mp.Put(m)
+	return dst
+}
+
+func TestCountLogRecords(t *testing.T) {
+	f := func(src []byte, nExpected int) {
+		t.Helper()
+
+		n := countLogRecords(src)
+		if n != nExpected {
+			t.Fatalf("unexpected number of log records; got %d; want %d", n, nExpected)
+		}
+	}
+
+	f(nil, 0)
+	f(getProtobufBody(1, 1, 1), 1)
+	f(getProtobufBody(3, 5, 2), 15)
+	f(appendMalformedResourceLogs(getProtobufBody(2, 3, 0)), 7)
+
+	// malformed message
+	f([]byte{0x0a, 0xff}, 0)
+}
+
+func TestMarshalExportLogsServiceResponse(t *testing.T) {
+	f := func(rejectedLogRecords int, errMsg string, rejectedExpected int64, errMsgExpected string, hasPartialSuccessExpected bool) {
+		t.Helper()
+
+		data := marshalExportLogsServiceResponse(nil, rejectedLogRecords, errMsg)
+		psData, ok, err := easyproto.GetMessageData(data, 1)
+		if err != nil {
+			t.Fatalf("cannot read partial_success: %s", err)
+		}
+		if ok != hasPartialSuccessExpected {
+			t.Fatalf("unexpected presence of partial_success; got %v; want %v", ok, hasPartialSuccessExpected)
+		}
+		if !ok {
+			return
+		}
+		rejected, _, err := easyproto.GetInt64(psData, 1)
+		if err != nil {
+			t.Fatalf("cannot read rejected_log_records: %s", err)
+		}
+		if rejected != rejectedExpected {
+			t.Fatalf("unexpected rejected_log_records; got %d; want %d", rejected, rejectedExpected)
+		}
+		msg, _, err := easyproto.GetString(psData, 2)
+		if err != nil {
+			t.Fatalf("cannot read error_message: %s", err)
+		}
+		if msg != errMsgExpected {
+			t.Fatalf("unexpected error_message; got %q; want %q", msg, errMsgExpected)
+		}
+	}
+
+	f(0, "", 0, "", false)
+	f(5, "", 5, "", true)
+	f(3, "some error", 3, "some error", true)
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	f := func(msg, resultExpected string) {
+		t.Helper()
+
+		result := encodeGRPCMessage(msg)
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %q; want %q", result, resultExpected)
+		}
+	}
+
+	f("", "")
+	f("foo bar", "foo bar")
+	f("100%", "100%25")
+	f("foo\nbar", "foo%0Abar")
+	f("привет", "%D0%BF%D1%80%D0%B8%D0%B2%D0%B5%D1%82")
+}
+
+func TestGetGRPCCodeForError(t *testing.T) {
+	f := func(err error, codeExpected int) {
+		t.Helper()
+
+		code := getGRPCCodeForError(err)
+		if code != codeExpected {
+			t.Fatalf("unexpected gRPC code for %v; got %d; want %d", err, code, codeExpected)
+		}
+	}
+
+	newErr := func(statusCode int) error {
+		return fmt.Errorf("cannot process request: %w", &httpserver.ErrorWithStatusCode{
+			Err:        errors.New("some error"),
+			StatusCode: statusCode,
+		})
+	}
+
+	f(errors.New("some error"), grpcCodeInvalidArgument)
+	f(newErr(http.StatusBadRequest), grpcCodeInvalidArgument)
+	f(newErr(http.StatusRequestEntityTooLarge), grpcCodeInvalidArgument)
+	f(newErr(http.StatusTooManyRequests), grpcCodeResourceExhausted)
+	f(newErr(http.StatusServiceUnavailable), grpcCodeUnavailable)
+	f(newErr(http.StatusInternalServerError), grpcCodeInternal)
+}
+
+type testStorage struct {
+	mu   sync.Mutex
+	rows int
+}
+
+func (s *testStorage) reset() {
+	s.mu.Lock()
+	s.rows = 0
+	s.mu.Unlock()
+}
+
+func (s *testStorage) rowsCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows
+}
+
+func (s *testStorage) MustAddRows(lr *logstorage.LogRows) {
+	s.mu.Lock()
+	s.rows += lr.RowsCount()
+	s.mu.Unlock()
+}
+
+func (*testStorage) CanWriteData() error {
+	return nil
+}
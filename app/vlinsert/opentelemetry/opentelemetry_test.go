@@ -376,8 +376,6 @@ func TestPushProtobufRequest(t *testing.T) {
 	f(data, timestampsExpected, resultsExpected)
 }
 
-var mp easyproto.MarshalerPool
-
 // logsData represents the corresponding OTEL protobuf message.
 type logsData struct {
 	ResourceLogs []resourceLogs `json:"resourceLogs,omitzero"`
@@ -413,7 +413,7 @@ func serveStreamListener(ln net.Listener, cfg *configs) {
 
 // processStream parses a stream of syslog messages from r and ingests them into vlstorage.
 func processStream(protocol string, r io.Reader, compressMethod string, useLocalTimestamp bool, remoteIP string, cp *insertutil.CommonParams) error {
-	if err := insertutil.CanWriteData(); err != nil {
+	if err := cp.CanWriteData(); err != nil {
 		return err
 	}
 
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/envflag"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/backup"
+)
+
+var (
+	src        = flag.String("src", "", "Source path with backups created by vlbackup. Supported formats: fs:///path/to/local/backup/dir, s3://bucket/path/to/backup/dir")
+	backupName = flag.String("backupName", "", "The name of the backup at -src to restore. "+
+		"The list of available backups is printed if this flag isn't set")
+	storageDataPath = flag.String("storageDataPath", "victoria-logs-data", "Destination path where backup must be restored. "+
+		"VictoriaLogs must be stopped when restoring from backup. -storageDataPath must be empty or missing")
+	concurrency = flag.Int("concurrency", 10, "The number of concurrent workers. Higher concurrency may reduce restore duration")
+)
+
+func main() {
+	// Write flags and help message to stdout, since it is easier to grep or pipe.
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = usage
+	envflag.Parse()
+	buildinfo.Init()
+	logger.Init()
+
+	if *src == "" {
+		logger.Fatalf("missing -src command-line flag")
+	}
+	srcFS, err := backup.NewRemoteFS(*src)
+	if err != nil {
+		logger.Fatalf("cannot parse -src=%q: %s", *src, err)
+	}
+
+	if *backupName == "" {
+		backupNames, err := backup.ListBackups(srcFS)
+		if err != nil {
+			logger.Fatalf("cannot list backups at %s: %s", srcFS, err)
+		}
+		logger.Fatalf("missing -backupName command-line flag; available backups at %s: %s", srcFS, strings.Join(backupNames, ", "))
+	}
+
+	// Prevent from starting VictoriaLogs at -storageDataPath while the backup is restored.
+	fs.MustMkdirIfNotExist(*storageDataPath)
+	flockF := fs.MustCreateFlockFile(*storageDataPath)
+
+	startTime := time.Now()
+	partitionsPath := filepath.Join(*storageDataPath, "partitions")
+	m, err := backup.Restore(srcFS, *backupName, partitionsPath, *concurrency)
+	if err != nil {
+		logger.Fatalf("cannot restore backup %q from %s: %s", *backupName, srcFS, err)
+	}
+	fs.MustClose(flockF)
+
+	logger.Infof("successfully restored backup %q with %d partitions and %d files to -storageDataPath=%q in %.3f seconds",
+		*backupName, len(m.Partitions), len(m.Files), *storageDataPath, time.Since(startTime).Seconds())
+}
+
+func usage() {
+	const s = `
+vlrestore restores VictoriaLogs data from backups created by vlbackup.
+
+See the docs at https://docs.victoriametrics.com/victorialogs/#backup-and-restore
+`
+	flagutil.Usage(s)
+}
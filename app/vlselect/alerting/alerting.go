@@ -0,0 +1,318 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	rulesFile = flag.String("alerting.rulesFile", "", "Optional path to a file with alerting rules over LogsQL stats queries. "+
+		"The file is re-read on SIGHUP signal. See https://docs.victoriametrics.com/victorialogs/vmalert/#built-in-alerting")
+	evaluationInterval = flag.Duration("alerting.evaluationInterval", time.Minute, "The default interval between evaluations of rules from -alerting.rulesFile. "+
+		"It can be overridden via interval option at the group level")
+	notifierURLs = flagutil.NewArrayString("alerting.notifierURL", "Optional URL of Alertmanager to send alerts generated by rules from -alerting.rulesFile to. "+
+		"Alerts are sent to <notifierURL>/api/v2/alerts")
+	notifierTimeout = flag.Duration("alerting.notifierTimeout", 10*time.Second, "Timeout for sending alerts to -alerting.notifierURL")
+)
+
+var (
+	rulesReloads      = metrics.NewCounter(`vl_alerting_config_reloads_total`)
+	rulesReloadErrors = metrics.NewCounter(`vl_alerting_config_reloads_errors_total`)
+
+	ruleEvaluations      = metrics.NewCounter(`vl_alerting_rule_evaluations_total`)
+	ruleEvaluationErrors = metrics.NewCounter(`vl_alerting_rule_evaluation_errors_total`)
+
+	_ = metrics.NewGauge(`vl_alerting_alerts_pending`, func() float64 {
+		return float64(getAlertsCount(statePending))
+	})
+	_ = metrics.NewGauge(`vl_alerting_alerts_firing`, func() float64 {
+		return float64(getAlertsCount(stateFiring))
+	})
+)
+
+var (
+	groupsLock sync.Mutex
+	groups     []*group
+
+	notifiers []*notifier
+
+	reloaderStopCh chan struct{}
+	reloaderWG     sync.WaitGroup
+)
+
+// Init starts evaluation of rules from -alerting.rulesFile.
+//
+// acquireConcurrencySlot must limit the number of concurrently executed rule queries together with the remaining select queries.
+// It must wait for a free slot until ctx is canceled and return the func for releasing the acquired slot.
+func Init(acquireConcurrencySlot func(ctx context.Context) (func(), error)) {
+	if *rulesFile == "" {
+		return
+	}
+	acquireQueryConcurrencySlot = acquireConcurrencySlot
+
+	gs, err := loadRulesFile(*rulesFile, *evaluationInterval)
+	if err != nil {
+		logger.Fatalf("cannot load -alerting.rulesFile: %s", err)
+	}
+	if len(*notifierURLs) == 0 {
+		logger.Warnf("-alerting.notifierURL isn't set, so alerts generated by rules from -alerting.rulesFile are available only at /select/alerting/alerts")
+	}
+	for _, u := range *notifierURLs {
+		notifiers = append(notifiers, newNotifier(u, *notifierTimeout))
+	}
+	startGroups(gs)
+
+	sighupCh := procutil.NewSighupChan()
+	reloaderStopCh = make(chan struct{})
+	reloaderWG.Go(func() {
+		for {
+			select {
+			case <-reloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -alerting.rulesFile=%q", *rulesFile)
+			rulesReloads.Inc()
+			gs, err := loadRulesFile(*rulesFile, *evaluationInterval)
+			if err != nil {
+				rulesReloadErrors.Inc()
+				logger.Errorf("cannot reload -alerting.rulesFile; continuing using the previously loaded rules; error: %s", err)
+				continue
+			}
+			startGroups(gs)
+			logger.Infof("successfully reloaded -alerting.rulesFile=%q", *rulesFile)
+		}
+	})
+}
+
+// Stop stops evaluation of rules from -alerting.rulesFile.
+func Stop() {
+	if reloaderStopCh == nil {
+		return
+	}
+	close(reloaderStopCh)
+	reloaderWG.Wait()
+	reloaderStopCh = nil
+
+	startGroups(nil)
+	for _, nf := range notifiers {
+		nf.stop()
+	}
+	notifiers = nil
+	acquireQueryConcurrencySlot = acquireQueryConcurrencySlotNoop
+}
+
+// startGroups stops the currently running groups and starts gs instead.
+//
+// The state of alerts is preserved for rules, which exist in both the old and the new groups.
+func startGroups(gs []*group) {
+	groupsLock.Lock()
+	gsPrev := groups
+	groupsLock.Unlock()
+
+	for _, g := range gsPrev {
+		g.stop()
+	}
+
+	rulesPrev := make(map[string]*rule)
+	for _, g := range gsPrev {
+		for _, r := range g.rules {
+			rulesPrev[r.key()] = r
+		}
+	}
+	for _, g := range gs {
+		for _, r := range g.rules {
+			if rPrev, ok := rulesPrev[r.key()]; ok {
+				r.restoreState(rPrev)
+			}
+		}
+	}
+
+	for _, g := range gs {
+		g.start(notifiers)
+	}
+
+	groupsLock.Lock()
+	groups = gs
+	groupsLock.Unlock()
+}
+
+func getGroups() []*group {
+	groupsLock.Lock()
+	defer groupsLock.Unlock()
+
+	return groups
+}
+
+func getAlertsCount(state alertState) int {
+	n := 0
+	for _, g := range getGroups() {
+		for _, r := range g.rules {
+			r.mu.Lock()
+			for _, a := range r.alerts {
+				if a.state == state {
+					n++
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+	return n
+}
+
+// ProcessRulesRequest handles /select/alerting/rules request.
+//
+// See https://docs.victoriametrics.com/victorialogs/vmalert/#built-in-alerting
+func ProcessRulesRequest(w http.ResponseWriter, r *http.Request) {
+	httpserver.EnableCORS(w, r)
+	writeJSONResponse(w, r, map[string]any{
+		"groups": getGroupsResponse(),
+	})
+}
+
+// ProcessAlertsRequest handles /select/alerting/alerts request.
+//
+// See https://docs.victoriametrics.com/victorialogs/vmalert/#built-in-alerting
+func ProcessAlertsRequest(w http.ResponseWriter, r *http.Request) {
+	httpserver.EnableCORS(w, r)
+	writeJSONResponse(w, r, map[string]any{
+		"alerts": getAlertsResponse(),
+	})
+}
+
+func writeJSONResponse(w http.ResponseWriter, r *http.Request, data any) {
+	resp := map[string]any{
+		"status": "success",
+		"data":   data,
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot marshal response: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// groupResponse is the group representation at /select/alerting/rules response.
+//
+// The response format is compatible with Prometheus /api/v1/rules.
+type groupResponse struct {
+	Name     string          `json:"name"`
+	Interval float64         `json:"interval"`
+	Tenant   string          `json:"tenant"`
+	Rules    []*ruleResponse `json:"rules"`
+}
+
+type ruleResponse struct {
+	Type           string            `json:"type"`
+	Name           string            `json:"name"`
+	Query          string            `json:"query"`
+	Duration       float64           `json:"duration"`
+	Labels         map[string]string `json:"labels"`
+	State          string            `json:"state"`
+	Health         string            `json:"health"`
+	LastError      string            `json:"lastError"`
+	LastEvaluation time.Time         `json:"lastEvaluation"`
+	EvaluationTime float64           `json:"evaluationTime"`
+	Alerts         []*alertResponse  `json:"alerts"`
+}
+
+// alertResponse is the alert representation at /select/alerting/* responses.
+type alertResponse struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	State       string            `json:"state"`
+	ActiveAt    time.Time         `json:"activeAt"`
+	Value       string            `json:"value"`
+}
+
+func getGroupsResponse() []*groupResponse {
+	gs := getGroups()
+	result := make([]*groupResponse, 0, len(gs))
+	for _, g := range gs {
+		gr := &groupResponse{
+			Name:     g.name,
+			Interval: g.interval.Seconds(),
+			Tenant:   g.tenantID.String(),
+		}
+		for _, r := range g.rules {
+			gr.Rules = append(gr.Rules, r.getResponse())
+		}
+		result = append(result, gr)
+	}
+	return result
+}
+
+func (r *rule) getResponse() *ruleResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The rule state is the highest state across its alerts.
+	state := stateInactive
+	for _, a := range r.alerts {
+		state = max(state, a.state)
+	}
+
+	rr := &ruleResponse{
+		Type:           "alerting",
+		Name:           r.name,
+		Query:          r.expr,
+		Duration:       r.forDuration.Seconds(),
+		Labels:         r.labels,
+		State:          state.String(),
+		Health:         "ok",
+		LastEvaluation: r.lastEvaluation,
+		EvaluationTime: r.evaluationTime.Seconds(),
+		Alerts:         getAlertsResponseLocked(r),
+	}
+	if r.lastEvaluation.IsZero() {
+		rr.Health = "unknown"
+	}
+	if r.lastError != nil {
+		rr.Health = "err"
+		rr.LastError = r.lastError.Error()
+	}
+	return rr
+}
+
+func getAlertsResponse() []*alertResponse {
+	result := []*alertResponse{}
+	for _, g := range getGroups() {
+		for _, r := range g.rules {
+			r.mu.Lock()
+			result = append(result, getAlertsResponseLocked(r)...)
+			r.mu.Unlock()
+		}
+	}
+	return result
+}
+
+func getAlertsResponseLocked(r *rule) []*alertResponse {
+	result := make([]*alertResponse, 0, len(r.alerts))
+	for _, a := range r.alerts {
+		result = append(result, &alertResponse{
+			Labels:      a.labels,
+			Annotations: a.annotations,
+			State:       a.state.String(),
+			ActiveAt:    a.activeAt,
+			Value:       a.value,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return getLabelsKey(result[i].Labels) < getLabelsKey(result[j].Labels)
+	})
+	return result
+}
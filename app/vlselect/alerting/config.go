@@ -0,0 +1,172 @@
+package alerting
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// rulesFileConfig represents the contents of -alerting.rulesFile
+type rulesFileConfig struct {
+	Groups []groupConfig `yaml:"groups"`
+}
+
+// groupConfig represents a group of rules at -alerting.rulesFile
+type groupConfig struct {
+	// Name is the group name. It must be unique across groups.
+	Name string `yaml:"name"`
+
+	// Interval is the interval between rules evaluations in the group. -alerting.evaluationInterval is used if it is empty.
+	Interval string `yaml:"interval,omitempty"`
+
+	// Tenant is the tenant to query in the form accountID:projectID.
+	Tenant string `yaml:"tenant,omitempty"`
+
+	Rules []ruleConfig `yaml:"rules"`
+}
+
+// ruleConfig represents a single alerting rule at -alerting.rulesFile
+type ruleConfig struct {
+	// Alert is the alert name.
+	Alert string `yaml:"alert"`
+
+	// Expr is LogsQL stats query. Every row returned by the query is an active alert.
+	Expr string `yaml:"expr"`
+
+	// For is the duration the alert must be active before it becomes firing.
+	For string `yaml:"for,omitempty"`
+
+	// Labels are added to the alerts generated by the rule.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Annotations are added to the alerts generated by the rule. They may contain templates.
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+func parseRulesFile(data []byte, defaultInterval time.Duration) ([]*group, error) {
+	var cfg rulesFileConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+
+	groupNames := make(map[string]struct{}, len(cfg.Groups))
+	groups := make([]*group, 0, len(cfg.Groups))
+	for i := range cfg.Groups {
+		gc := &cfg.Groups[i]
+		if gc.Name == "" {
+			return nil, fmt.Errorf("missing name for the group #%d", i+1)
+		}
+		if _, ok := groupNames[gc.Name]; ok {
+			return nil, fmt.Errorf("duplicate group name %q", gc.Name)
+		}
+		groupNames[gc.Name] = struct{}{}
+
+		g, err := gc.toGroup(defaultInterval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse group %q: %w", gc.Name, err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+func (gc *groupConfig) toGroup(defaultInterval time.Duration) (*group, error) {
+	interval := defaultInterval
+	if gc.Interval != "" {
+		nsecs, ok := logstorage.TryParseDuration(gc.Interval)
+		if !ok || nsecs <= 0 {
+			return nil, fmt.Errorf("cannot parse interval=%q", gc.Interval)
+		}
+		interval = time.Duration(nsecs)
+	}
+
+	tenantID, err := logstorage.ParseTenantID(gc.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse tenant=%q: %w", gc.Tenant, err)
+	}
+
+	if len(gc.Rules) == 0 {
+		return nil, fmt.Errorf("missing rules")
+	}
+	g := &group{
+		name:     gc.Name,
+		interval: interval,
+		tenantID: tenantID,
+	}
+	for i := range gc.Rules {
+		rc := &gc.Rules[i]
+		r, err := rc.toRule(g)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse rule #%d: %w", i+1, err)
+		}
+		g.rules = append(g.rules, r)
+	}
+	return g, nil
+}
+
+func (rc *ruleConfig) toRule(g *group) (*rule, error) {
+	if rc.Alert == "" {
+		return nil, fmt.Errorf("missing alert name")
+	}
+
+	q, err := logstorage.ParseQuery(rc.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse expr=%q for the alert %q: %w", rc.Expr, rc.Alert, err)
+	}
+	if _, err := q.GetStatsLabels(); err != nil {
+		return nil, fmt.Errorf("expr=%q for the alert %q must be a stats query: %w", rc.Expr, rc.Alert, err)
+	}
+
+	var forDuration time.Duration
+	if rc.For != "" {
+		nsecs, ok := logstorage.TryParseDuration(rc.For)
+		if !ok || nsecs < 0 {
+			return nil, fmt.Errorf("cannot parse for=%q for the alert %q", rc.For, rc.Alert)
+		}
+		forDuration = time.Duration(nsecs)
+	}
+
+	annotations := make(map[string]*template.Template, len(rc.Annotations))
+	for name, text := range rc.Annotations {
+		t, err := parseAnnotationTemplate(text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse annotation %q for the alert %q: %w", name, rc.Alert, err)
+		}
+		annotations[name] = t
+	}
+
+	r := &rule{
+		g:           g,
+		name:        rc.Alert,
+		expr:        rc.Expr,
+		forDuration: forDuration,
+		labels:      rc.Labels,
+		annotations: annotations,
+		alerts:      make(map[string]*alert),
+	}
+	return r, nil
+}
+
+// annotationTemplatePrefix allows referring the alert labels and values via $labels, $value and $values in annotations.
+const annotationTemplatePrefix = `{{ $labels := .Labels }}{{ $value := .Value }}{{ $values := .Values }}`
+
+func parseAnnotationTemplate(text string) (*template.Template, error) {
+	return template.New("").Option("missingkey=zero").Parse(annotationTemplatePrefix + text)
+}
+
+func loadRulesFile(path string, defaultInterval time.Duration) ([]*group, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseRulesFile(data, defaultInterval)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return groups, nil
+}
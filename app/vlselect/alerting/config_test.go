@@ -0,0 +1,194 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestParseRulesFileSuccess(t *testing.T) {
+	data := `
+groups:
+- name: errors
+  interval: 30s
+  tenant: "12:34"
+  rules:
+  - alert: TooManyErrors
+    expr: 'level:error | stats by (app) count() as errors | filter errors:>100'
+    for: 5m
+    labels:
+      severity: critical
+    annotations:
+      summary: 'too many errors for {{ $labels.app }}: {{ $value }}'
+- name: default
+  rules:
+  - alert: NoLogs
+    expr: '_time:5m | stats count() as logs | filter logs:=0'
+`
+	groups, err := parseRulesFile([]byte(data), time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("unexpected number of groups; got %d; want 2", len(groups))
+	}
+
+	g := groups[0]
+	if g.name != "errors" {
+		t.Fatalf("unexpected group name; got %q; want %q", g.name, "errors")
+	}
+	if g.interval != 30*time.Second {
+		t.Fatalf("unexpected interval; got %s; want 30s", g.interval)
+	}
+	tenantIDExpected := logstorage.TenantID{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+	if g.tenantID != tenantIDExpected {
+		t.Fatalf("unexpected tenant; got %s; want %s", g.tenantID, tenantIDExpected)
+	}
+	if len(g.rules) != 1 {
+		t.Fatalf("unexpected number of rules; got %d; want 1", len(g.rules))
+	}
+	r := g.rules[0]
+	if r.name != "TooManyErrors" {
+		t.Fatalf("unexpected alert name; got %q; want %q", r.name, "TooManyErrors")
+	}
+	if r.forDuration != 5*time.Minute {
+		t.Fatalf("unexpected for; got %s; want 5m", r.forDuration)
+	}
+	if r.labels["severity"] != "critical" {
+		t.Fatalf("unexpected labels: %v", r.labels)
+	}
+
+	// The default interval and tenant must be used if they are missing at the group.
+	g = groups[1]
+	if g.interval != time.Minute {
+		t.Fatalf("unexpected interval; got %s; want 1m", g.interval)
+	}
+	if g.tenantID != (logstorage.TenantID{}) {
+		t.Fatalf("unexpected tenant; got %s; want 0:0", g.tenantID)
+	}
+}
+
+func TestParseRulesFileFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		groups, err := parseRulesFile([]byte(data), time.Minute)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if groups != nil {
+			t.Fatalf("expecting nil groups")
+		}
+	}
+
+	// invalid YAML
+	f(`foo`)
+
+	// unknown field
+	f(`
+groups:
+- name: foo
+  rules:
+  - alert: bar
+    expr: '* | stats count()'
+    unknown: baz
+`)
+
+	// missing group name
+	f(`
+groups:
+- rules:
+  - alert: bar
+    expr: '* | stats count()'
+`)
+
+	// duplicate group name
+	f(`
+groups:
+- name: foo
+  rules:
+  - alert: bar
+    expr: '* | stats count()'
+- name: foo
+  rules:
+  - alert: baz
+    expr: '* | stats count()'
+`)
+
+	// missing rules
+	f(`
+groups:
+- name: foo
+`)
+
+	// invalid interval
+	f(`
+groups:
+- name: foo
+  interval: bar
+  rules:
+  - alert: bar
+    expr: '* | stats count()'
+`)
+
+	// invalid tenant
+	f(`
+groups:
+- name: foo
+  tenant: bar
+  rules:
+  - alert: bar
+    expr: '* | stats count()'
+`)
+
+	// missing alert name
+	f(`
+groups:
+- name: foo
+  rules:
+  - expr: '* | stats count()'
+`)
+
+	// invalid expr
+	f(`
+groups:
+- name: foo
+  rules:
+  - alert: bar
+    expr: '* | stats'
+`)
+
+	// non-stats expr
+	f(`
+groups:
+- name: foo
+  rules:
+  - alert: bar
+    expr: 'error'
+`)
+
+	// invalid for
+	f(`
+groups:
+- name: foo
+  rules:
+  - alert: bar
+    expr: '* | stats count()'
+    for: baz
+`)
+
+	// invalid annotation template
+	f(`
+groups:
+- name: foo
+  rules:
+  - alert: bar
+    expr: '* | stats count()'
+    annotations:
+      summary: '{{ $value'
+`)
+}
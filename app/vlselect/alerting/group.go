@@ -0,0 +1,135 @@
+package alerting
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/logsql"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// group is a group of rules, which are evaluated with the same interval.
+type group struct {
+	name     string
+	interval time.Duration
+	tenantID logstorage.TenantID
+	rules    []*rule
+
+	// stopCtx is canceled when the group is stopped.
+	stopCtx    context.Context
+	stopCancel func()
+	wg         sync.WaitGroup
+}
+
+// runStatsQuery executes the stats query for the rule.
+//
+// It is overridden in tests.
+var runStatsQuery = logsql.RunStatsQuery
+
+// acquireQueryConcurrencySlot limits the number of concurrently executed rule queries according to -search.maxConcurrentRequests.
+//
+// It is set at Init.
+var acquireQueryConcurrencySlot = acquireQueryConcurrencySlotNoop
+
+func acquireQueryConcurrencySlotNoop(_ context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+// start starts periodic evaluation of g rules and sends the resulting alerts to nfs.
+func (g *group) start(nfs []*notifier) {
+	g.stopCtx, g.stopCancel = context.WithCancel(context.Background())
+	g.wg.Go(func() {
+		t := time.NewTicker(g.interval)
+		defer t.Stop()
+
+		ts := time.Now()
+		for {
+			g.evalRules(ts, nfs)
+
+			select {
+			case <-g.stopCtx.Done():
+				return
+			case ts = <-t.C:
+			}
+		}
+	})
+}
+
+// stop stops g rules evaluation.
+func (g *group) stop() {
+	g.stopCancel()
+	g.wg.Wait()
+}
+
+// evalRules evaluates g rules at the given ts and sends the resulting alerts to nfs.
+func (g *group) evalRules(ts time.Time, nfs []*notifier) {
+	var alerts []*alert
+	for _, r := range g.rules {
+		alerts = append(alerts, g.evalRule(ts, r)...)
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	// Firing alerts expire at Alertmanager if they aren't re-sent during the next few evaluations.
+	endsAt := ts.Add(4 * g.interval)
+	for _, nf := range nfs {
+		nf.send(alerts, endsAt)
+	}
+}
+
+func (g *group) evalRule(ts time.Time, r *rule) []*alert {
+	ruleEvaluations.Inc()
+
+	// Do not allow the rule evaluation to take more than the interval between evaluations.
+	timeout := g.interval
+	if tql := vlstorage.GetTenantQueryLimits(g.tenantID); tql != nil && tql.MaxQueryDuration > 0 {
+		timeout = min(timeout, tql.MaxQueryDuration)
+	}
+	ctx, cancel := context.WithTimeout(g.stopCtx, timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	rows, err := g.runRuleQuery(ctx, ts, r)
+	evaluationTime := time.Since(startTime)
+
+	r.mu.Lock()
+	r.lastEvaluation = ts
+	r.evaluationTime = evaluationTime
+	r.lastError = err
+	r.mu.Unlock()
+
+	if err != nil {
+		if g.stopCtx.Err() != nil {
+			// The group is stopped.
+			return nil
+		}
+		ruleEvaluationErrors.Inc()
+		evalErrorLogger.Errorf("cannot evaluate the alert %q at the group %q; keeping the previous alerts state; error: %s", r.name, g.name, err)
+		return nil
+	}
+	return r.updateAlerts(ts, rows)
+}
+
+var evalErrorLogger = logger.WithThrottler("alerting_rule_evaluation", 5*time.Second)
+
+// runRuleQuery executes r query at ts under the same concurrency limits as the remaining select queries.
+func (g *group) runRuleQuery(ctx context.Context, ts time.Time, r *rule) ([]logsql.StatsRow, error) {
+	releaseTenantQuerySlot, err := vlstorage.AcquireTenantQuerySlot(g.tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseTenantQuerySlot()
+
+	releaseConcurrencySlot, err := acquireQueryConcurrencySlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseConcurrencySlot()
+
+	return runStatsQuery(ctx, g.tenantID, r.expr, ts.UnixNano())
+}
@@ -0,0 +1,213 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/logsql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestGroupEvalRules(t *testing.T) {
+	var received [][]alertmanagerAlert
+	var receivedLock sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("unexpected path; got %q; want %q", r.URL.Path, "/api/v2/alerts")
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("cannot read request body: %s", err)
+		}
+		var alerts []alertmanagerAlert
+		if err := json.Unmarshal(data, &alerts); err != nil {
+			t.Errorf("cannot unmarshal alerts: %s", err)
+		}
+		receivedLock.Lock()
+		received = append(received, alerts)
+		receivedLock.Unlock()
+	}))
+	defer srv.Close()
+
+	var queryRows []logsql.StatsRow
+	tenantIDExpected := logstorage.TenantID{
+		AccountID: 1,
+	}
+	origRunStatsQuery := runStatsQuery
+	runStatsQuery = func(_ context.Context, tenantID logstorage.TenantID, qStr string, _ int64) ([]logsql.StatsRow, error) {
+		if tenantID != tenantIDExpected {
+			t.Errorf("unexpected tenant; got %s; want %s", tenantID, tenantIDExpected)
+		}
+		if qStr != "level:error | stats by (app) count() as errors, count_uniq(host) as hosts" {
+			t.Errorf("unexpected query: %q", qStr)
+		}
+		return queryRows, nil
+	}
+	defer func() {
+		runStatsQuery = origRunStatsQuery
+	}()
+
+	r := newTestRule(t, "")
+	g := r.g
+	g.interval = time.Minute
+	g.tenantID = tenantIDExpected
+	g.rules = []*rule{r}
+	g.stopCtx = context.Background()
+	nf := newNotifier(srv.URL+"/", time.Second)
+	defer nf.stop()
+	nfs := []*notifier{nf}
+
+	var concurrentQueries int
+	origAcquireQueryConcurrencySlot := acquireQueryConcurrencySlot
+	acquireQueryConcurrencySlot = func(_ context.Context) (func(), error) {
+		concurrentQueries++
+		return func() {
+			concurrentQueries--
+		}, nil
+	}
+	defer func() {
+		acquireQueryConcurrencySlot = origAcquireQueryConcurrencySlot
+	}()
+
+	ts := time.Unix(1_700_000_000, 0).UTC()
+
+	// Nothing is sent if there are no alerts.
+	g.evalRules(ts, nfs)
+
+	// Firing alert
+	queryRows = newTestStatsRows("foo", "10", "2")
+	g.evalRules(ts.Add(time.Minute), nfs)
+
+	// Resolved alert
+	queryRows = nil
+	g.evalRules(ts.Add(2*time.Minute), nfs)
+
+	labels := map[string]string{
+		"alertname": "TooManyErrors",
+		"app":       "foo",
+		"severity":  "critical",
+	}
+	annotations := map[string]string{
+		"summary": "foo: 10 errors at 2 hosts",
+	}
+	receivedExpected := [][]alertmanagerAlert{
+		{
+			{
+				Labels:      labels,
+				Annotations: annotations,
+				StartsAt:    ts.Add(time.Minute),
+				EndsAt:      ts.Add(5 * time.Minute),
+			},
+		},
+		{
+			{
+				Labels:      labels,
+				Annotations: annotations,
+				StartsAt:    ts.Add(time.Minute),
+				EndsAt:      ts.Add(2 * time.Minute),
+			},
+		},
+	}
+
+	// Alerts are sent asynchronously, so wait until they are received.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		receivedLock.Lock()
+		n := len(received)
+		receivedLock.Unlock()
+		if n >= len(receivedExpected) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	receivedLock.Lock()
+	defer receivedLock.Unlock()
+	if !reflect.DeepEqual(received, receivedExpected) {
+		t.Fatalf("unexpected alerts received\ngot\n%+v\nwant\n%+v", received, receivedExpected)
+	}
+	if concurrentQueries != 0 {
+		t.Fatalf("unexpected number of concurrent queries after the evaluation; got %d; want 0", concurrentQueries)
+	}
+
+	if r.lastError != nil {
+		t.Fatalf("unexpected lastError: %s", r.lastError)
+	}
+	if !r.lastEvaluation.Equal(ts.Add(2 * time.Minute)) {
+		t.Fatalf("unexpected lastEvaluation; got %s; want %s", r.lastEvaluation, ts.Add(2*time.Minute))
+	}
+}
+
+func TestGroupEvalRulesConcurrencyLimit(t *testing.T) {
+	origRunStatsQuery := runStatsQuery
+	runStatsQuery = func(_ context.Context, _ logstorage.TenantID, _ string, _ int64) ([]logsql.StatsRow, error) {
+		t.Errorf("the query must not be executed when the concurrency limit is reached")
+		return nil, nil
+	}
+	defer func() {
+		runStatsQuery = origRunStatsQuery
+	}()
+
+	origAcquireQueryConcurrencySlot := acquireQueryConcurrencySlot
+	acquireQueryConcurrencySlot = func(ctx context.Context) (func(), error) {
+		<-ctx.Done()
+		return nil, fmt.Errorf("too many concurrent queries")
+	}
+	defer func() {
+		acquireQueryConcurrencySlot = origAcquireQueryConcurrencySlot
+	}()
+
+	r := newTestRule(t, "")
+	g := r.g
+	g.interval = 10 * time.Millisecond
+	g.rules = []*rule{r}
+	g.stopCtx = context.Background()
+
+	g.evalRules(time.Now(), nil)
+
+	if r.lastError == nil {
+		t.Fatalf("expecting non-nil lastError")
+	}
+}
+
+func TestNotifierSendQueueFull(t *testing.T) {
+	unblockCh := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblockCh:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(unblockCh)
+
+	nf := newNotifier(srv.URL+"/queue_full", time.Minute)
+	defer nf.stop()
+
+	alerts := []*alert{
+		{
+			labels: map[string]string{
+				"alertname": "foo",
+			},
+			state: stateFiring,
+		},
+	}
+
+	// The first notification is taken by the sender, which is blocked by the server.
+	// The next notifierQueueSize notifications fill the queue, while the remaining notifications must be dropped.
+	// send must not block in any case.
+	for i := 0; i < 2*notifierQueueSize+1; i++ {
+		nf.send(alerts, time.Now())
+	}
+
+	if n := nf.alertsDropped.Get(); n < notifierQueueSize {
+		t.Fatalf("unexpected number of dropped alerts; got %d; want at least %d", n, notifierQueueSize)
+	}
+}
@@ -0,0 +1,169 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// notifierQueueSize is the maximum number of pending notifications per notifier.
+//
+// Notifications are dropped if the queue is full, so slow notifiers do not block rules evaluation.
+const notifierQueueSize = 100
+
+// notifier sends alerts to Alertmanager-compatible webhook.
+type notifier struct {
+	// url is the url for sending alerts to.
+	url string
+
+	c *http.Client
+
+	// queue contains pending notifications, which are sent by a background worker.
+	queue chan *notification
+
+	// stopCtx is canceled when the notifier is stopped.
+	stopCtx    context.Context
+	stopCancel func()
+	wg         sync.WaitGroup
+
+	alertsSent       *metrics.Counter
+	alertsSendErrors *metrics.Counter
+	alertsDropped    *metrics.Counter
+}
+
+// notification is a pending request to notifier.
+type notification struct {
+	// data is JSON-encoded alerts.
+	data []byte
+
+	// alertsCount is the number of alerts at data.
+	alertsCount int
+}
+
+// newNotifier returns new notifier for the given notifierURL.
+//
+// Every request to notifierURL is limited by the given timeout.
+// Call stop when the notifier is no longer needed.
+func newNotifier(notifierURL string, timeout time.Duration) *notifier {
+	u := strings.TrimSuffix(notifierURL, "/") + "/api/v2/alerts"
+	nf := &notifier{
+		url: u,
+		c: &http.Client{
+			Timeout: timeout,
+		},
+		queue:            make(chan *notification, notifierQueueSize),
+		alertsSent:       metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alerting_alerts_sent_total{url=%q}`, notifierURL)),
+		alertsSendErrors: metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alerting_alerts_send_errors_total{url=%q}`, notifierURL)),
+		alertsDropped:    metrics.GetOrCreateCounter(fmt.Sprintf(`vl_alerting_alerts_dropped_total{url=%q}`, notifierURL)),
+	}
+	nf.stopCtx, nf.stopCancel = context.WithCancel(context.Background())
+	nf.wg.Go(nf.runSender)
+	return nf
+}
+
+// stop stops nf. Pending notifications are dropped.
+func (nf *notifier) stop() {
+	nf.stopCancel()
+	nf.wg.Wait()
+}
+
+func (nf *notifier) runSender() {
+	for {
+		select {
+		case <-nf.stopCtx.Done():
+			return
+		case n := <-nf.queue:
+			if err := nf.trySend(n.data); err != nil {
+				if nf.stopCtx.Err() != nil {
+					// The notifier is stopped.
+					return
+				}
+				nf.alertsSendErrors.Add(n.alertsCount)
+				sendErrorLogger.Errorf("cannot send %d alerts to %q: %s", n.alertsCount, nf.url, err)
+				continue
+			}
+			nf.alertsSent.Add(n.alertsCount)
+		}
+	}
+}
+
+// alertmanagerAlert is an alert in the format accepted by Alertmanager /api/v2/alerts endpoint.
+//
+// See https://github.com/prometheus/alertmanager/blob/main/api/v2/openapi.yaml
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// send queues alerts for sending to nf.
+//
+// endsAt is the expiration time for firing alerts. Resolved alerts expire at their resolve time.
+// The alerts are dropped if nf queue is full.
+func (nf *notifier) send(alerts []*alert, endsAt time.Time) {
+	data := marshalAlerts(alerts, endsAt)
+	n := &notification{
+		data:        data,
+		alertsCount: len(alerts),
+	}
+	select {
+	case nf.queue <- n:
+	default:
+		nf.alertsDropped.Add(len(alerts))
+		sendErrorLogger.Errorf("dropping %d alerts for %q, since %d notifications are already pending; "+
+			"check whether the notifier is available and responds in -alerting.notifierTimeout", len(alerts), nf.url, len(nf.queue))
+	}
+}
+
+// marshalAlerts marshals alerts into the JSON format accepted by Alertmanager.
+func marshalAlerts(alerts []*alert, endsAt time.Time) []byte {
+	ams := make([]alertmanagerAlert, len(alerts))
+	for i, a := range alerts {
+		am := &ams[i]
+		am.Labels = a.labels
+		am.Annotations = a.annotations
+		am.StartsAt = a.activeAt
+		am.EndsAt = endsAt
+		if a.state == stateInactive {
+			am.EndsAt = a.resolvedAt
+		}
+	}
+	data, err := json.Marshal(ams)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal alerts: %s", err)
+	}
+	return data
+}
+
+func (nf *notifier) trySend(data []byte) error {
+	req, err := http.NewRequestWithContext(nf.stopCtx, http.MethodPost, nf.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := nf.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response status code %d; response body: %q", resp.StatusCode, body)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+var sendErrorLogger = logger.WithThrottler("alerting_notifier_send", 5*time.Second)
@@ -0,0 +1,209 @@
+package alerting
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/logsql"
+)
+
+// alertState is the state of a single alert.
+type alertState int
+
+const (
+	// stateInactive is the state of the resolved alert.
+	stateInactive alertState = iota
+
+	// statePending is the state of the active alert, which is active for less than the `for` duration.
+	statePending
+
+	// stateFiring is the state of the active alert, which is active for at least the `for` duration.
+	stateFiring
+)
+
+func (s alertState) String() string {
+	switch s {
+	case statePending:
+		return "pending"
+	case stateFiring:
+		return "firing"
+	default:
+		return "inactive"
+	}
+}
+
+// alert is a single alert generated by the rule for a single row returned by the rule query.
+type alert struct {
+	// labels contains labels for the alert including alertname and rule labels.
+	labels map[string]string
+
+	// annotations contains the expanded rule annotations.
+	annotations map[string]string
+
+	state alertState
+
+	// value contains the value of the first stats result for the alert.
+	value string
+
+	// values contains values for all the stats results for the alert.
+	values map[string]string
+
+	// activeAt is the time when the alert became active.
+	activeAt time.Time
+
+	// resolvedAt is the time when the alert became inactive.
+	resolvedAt time.Time
+}
+
+// rule is a single alerting rule.
+type rule struct {
+	g *group
+
+	name        string
+	expr        string
+	forDuration time.Duration
+	labels      map[string]string
+	annotations map[string]*template.Template
+
+	mu sync.Mutex
+
+	// alerts contains active alerts by their labels key.
+	alerts map[string]*alert
+
+	lastEvaluation time.Time
+	evaluationTime time.Duration
+	lastError      error
+}
+
+// key returns a key, which uniquely identifies r across rules reloads.
+func (r *rule) key() string {
+	return r.g.name + "\x00" + r.name + "\x00" + r.expr
+}
+
+// restoreState moves the alerts state from rPrev to r.
+func (r *rule) restoreState(rPrev *rule) {
+	rPrev.mu.Lock()
+	alerts := rPrev.alerts
+	rPrev.alerts = make(map[string]*alert)
+	rPrev.mu.Unlock()
+
+	r.mu.Lock()
+	r.alerts = alerts
+	r.mu.Unlock()
+}
+
+// updateAlerts updates r alerts from the rows returned by r query at the given ts.
+//
+// It returns alerts, which must be sent to notifiers - firing alerts and the alerts resolved during the call.
+func (r *rule) updateAlerts(ts time.Time, rows []logsql.StatsRow) []*alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Group stats results by labels, since a single query row contains multiple stats results.
+	activeAlerts := make(map[string]*alert)
+	for _, row := range rows {
+		labels := make(map[string]string, len(row.Labels)+len(r.labels)+1)
+		for _, f := range row.Labels {
+			labels[f.Name] = f.Value
+		}
+		for k, v := range r.labels {
+			labels[k] = v
+		}
+		labels["alertname"] = r.name
+
+		key := getLabelsKey(labels)
+		a := activeAlerts[key]
+		if a == nil {
+			a = &alert{
+				labels: labels,
+				value:  row.Value,
+				values: make(map[string]string),
+			}
+			activeAlerts[key] = a
+		}
+		a.values[row.Name] = row.Value
+	}
+
+	var result []*alert
+	for key, a := range activeAlerts {
+		aPrev := r.alerts[key]
+		if aPrev == nil || aPrev.state == stateInactive {
+			a.activeAt = ts
+			a.state = statePending
+		} else {
+			a.activeAt = aPrev.activeAt
+			a.state = aPrev.state
+		}
+		if a.state == statePending && ts.Sub(a.activeAt) >= r.forDuration {
+			a.state = stateFiring
+		}
+		a.annotations = r.expandAnnotations(a)
+		r.alerts[key] = a
+
+		if a.state == stateFiring {
+			result = append(result, a)
+		}
+	}
+
+	for key, a := range r.alerts {
+		if _, ok := activeAlerts[key]; ok {
+			continue
+		}
+		delete(r.alerts, key)
+		if a.state == stateFiring {
+			// Notify about the resolved alert.
+			a.state = stateInactive
+			a.resolvedAt = ts
+			result = append(result, a)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return getLabelsKey(result[i].labels) < getLabelsKey(result[j].labels)
+	})
+	return result
+}
+
+func (r *rule) expandAnnotations(a *alert) map[string]string {
+	data := struct {
+		Labels map[string]string
+		Value  string
+		Values map[string]string
+	}{
+		Labels: a.labels,
+		Value:  a.value,
+		Values: a.values,
+	}
+
+	annotations := make(map[string]string, len(r.annotations))
+	var bb bytes.Buffer
+	for name, t := range r.annotations {
+		bb.Reset()
+		if err := t.Execute(&bb, data); err != nil {
+			annotations[name] = fmt.Sprintf("<error expanding template: %s>", err)
+			continue
+		}
+		annotations[name] = bb.String()
+	}
+	return annotations
+}
+
+// getLabelsKey returns a string, which uniquely identifies the given labels.
+func getLabelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%q=%q,", name, labels[name])
+	}
+	return sb.String()
+}
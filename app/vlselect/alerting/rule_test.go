@@ -0,0 +1,162 @@
+package alerting
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/logsql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func newTestRule(t *testing.T, forDuration string) *rule {
+	t.Helper()
+
+	rc := &ruleConfig{
+		Alert: "TooManyErrors",
+		Expr:  `level:error | stats by (app) count() as errors, count_uniq(host) as hosts`,
+		For:   forDuration,
+		Labels: map[string]string{
+			"severity": "critical",
+		},
+		Annotations: map[string]string{
+			"summary": `{{ $labels.app }}: {{ $value }} errors at {{ $values.hosts }} hosts`,
+		},
+	}
+	r, err := rc.toRule(&group{
+		name: "test",
+	})
+	if err != nil {
+		t.Fatalf("cannot create rule: %s", err)
+	}
+	return r
+}
+
+func newTestStatsRows(app, errors, hosts string) []logsql.StatsRow {
+	labels := []logstorage.Field{
+		{
+			Name:  "app",
+			Value: app,
+		},
+	}
+	return []logsql.StatsRow{
+		{
+			Name:   "errors",
+			Labels: labels,
+			Value:  errors,
+		},
+		{
+			Name:   "hosts",
+			Labels: labels,
+			Value:  hosts,
+		},
+	}
+}
+
+func TestRuleUpdateAlerts(t *testing.T) {
+	r := newTestRule(t, "2m")
+
+	f := func(ts time.Time, rows []logsql.StatsRow, alertsExpected []string, statesExpected map[string]string) {
+		t.Helper()
+
+		alerts := r.updateAlerts(ts, rows)
+		var result []string
+		for _, a := range alerts {
+			result = append(result, a.labels["app"]+" "+a.state.String()+" "+a.annotations["summary"])
+		}
+		if !reflect.DeepEqual(result, alertsExpected) {
+			t.Fatalf("unexpected alerts to send\ngot\n%q\nwant\n%q", result, alertsExpected)
+		}
+
+		states := make(map[string]string)
+		for _, a := range r.alerts {
+			states[a.labels["app"]] = a.state.String()
+		}
+		if !reflect.DeepEqual(states, statesExpected) {
+			t.Fatalf("unexpected alert states\ngot\n%v\nwant\n%v", states, statesExpected)
+		}
+	}
+
+	ts := time.Unix(1_700_000_000, 0)
+
+	// The alert becomes pending.
+	f(ts, newTestStatsRows("foo", "10", "2"), nil, map[string]string{
+		"foo": "pending",
+	})
+
+	// The alert stays pending until the `for` duration passes.
+	f(ts.Add(time.Minute), newTestStatsRows("foo", "20", "2"), nil, map[string]string{
+		"foo": "pending",
+	})
+
+	// The alert becomes firing after the `for` duration passes.
+	f(ts.Add(2*time.Minute), newTestStatsRows("foo", "30", "3"), []string{
+		"foo firing foo: 30 errors at 3 hosts",
+	}, map[string]string{
+		"foo": "firing",
+	})
+
+	// Firing alerts are re-sent on every evaluation, while new alerts become pending.
+	rows := append(newTestStatsRows("foo", "40", "3"), newTestStatsRows("bar", "1", "1")...)
+	f(ts.Add(3*time.Minute), rows, []string{
+		"foo firing foo: 40 errors at 3 hosts",
+	}, map[string]string{
+		"foo": "firing",
+		"bar": "pending",
+	})
+
+	// Missing alerts are resolved. Pending alerts are dropped without notifications.
+	f(ts.Add(4*time.Minute), nil, []string{
+		"foo inactive foo: 40 errors at 3 hosts",
+	}, map[string]string{})
+
+	// The resolved alert becomes pending again.
+	f(ts.Add(5*time.Minute), newTestStatsRows("foo", "50", "4"), nil, map[string]string{
+		"foo": "pending",
+	})
+}
+
+func TestRuleUpdateAlertsZeroFor(t *testing.T) {
+	r := newTestRule(t, "")
+
+	ts := time.Unix(1_700_000_000, 0)
+	alerts := r.updateAlerts(ts, newTestStatsRows("foo", "10", "2"))
+	if len(alerts) != 1 {
+		t.Fatalf("unexpected number of alerts; got %d; want 1", len(alerts))
+	}
+	a := alerts[0]
+	if a.state != stateFiring {
+		t.Fatalf("unexpected alert state; got %s; want firing", a.state)
+	}
+	if !a.activeAt.Equal(ts) {
+		t.Fatalf("unexpected activeAt; got %s; want %s", a.activeAt, ts)
+	}
+	labelsExpected := map[string]string{
+		"alertname": "TooManyErrors",
+		"app":       "foo",
+		"severity":  "critical",
+	}
+	if !reflect.DeepEqual(a.labels, labelsExpected) {
+		t.Fatalf("unexpected labels\ngot\n%v\nwant\n%v", a.labels, labelsExpected)
+	}
+}
+
+func TestRuleRestoreState(t *testing.T) {
+	rPrev := newTestRule(t, "")
+	ts := time.Unix(1_700_000_000, 0)
+	rPrev.updateAlerts(ts, newTestStatsRows("foo", "10", "2"))
+
+	// The firing alert must remain firing with the original activeAt after rules reload.
+	r := newTestRule(t, "")
+	if r.key() != rPrev.key() {
+		t.Fatalf("unexpected rule key; got %q; want %q", r.key(), rPrev.key())
+	}
+	r.restoreState(rPrev)
+	alerts := r.updateAlerts(ts.Add(time.Minute), newTestStatsRows("foo", "20", "2"))
+	if len(alerts) != 1 {
+		t.Fatalf("unexpected number of alerts; got %d; want 1", len(alerts))
+	}
+	if a := alerts[0]; a.state != stateFiring || !a.activeAt.Equal(ts) {
+		t.Fatalf("unexpected alert; state=%s, activeAt=%s; want state=firing, activeAt=%s", a.state, a.activeAt, ts)
+	}
+}
@@ -0,0 +1,91 @@
+package vlselect
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var geoIPDatabaseFiles = flagutil.NewArrayString("search.geoipDatabase", "Optional path to a GeoIP database in MaxMind DB format (for example, GeoLite2-City.mmdb or GeoLite2-ASN.mmdb), "+
+	"which is used by 'geoip' pipe. The flag can be specified multiple times; databases are queried in the given order. "+
+	"Files are re-read on SIGHUP signal. See https://docs.victoriametrics.com/victorialogs/logsql/#geoip-pipe")
+
+var (
+	geoIPDatabasesReloads      = metrics.NewCounter(`vl_geoip_databases_config_reloads_total`)
+	geoIPDatabasesReloadErrors = metrics.NewCounter(`vl_geoip_databases_config_reloads_errors_total`)
+)
+
+func mustLoadGeoIPDatabases() {
+	if len(*geoIPDatabaseFiles) == 0 {
+		return
+	}
+	if err := loadGeoIPDatabases(); err != nil {
+		logger.Fatalf("cannot load -search.geoipDatabase: %s", err)
+	}
+}
+
+func loadGeoIPDatabases() error {
+	var dbs []*logstorage.GeoIPDatabase
+	for _, path := range *geoIPDatabaseFiles {
+		data, err := fscore.ReadFileOrHTTP(path)
+		if err != nil {
+			return err
+		}
+		db, err := logstorage.OpenGeoIPDatabase(data)
+		if err != nil {
+			return fmt.Errorf("cannot open %q: %w", path, err)
+		}
+		logger.Infof("loaded GeoIP database %q of type %q", path, db.DatabaseType())
+		dbs = append(dbs, db)
+	}
+	logstorage.SetGeoIPDatabases(dbs)
+	return nil
+}
+
+var (
+	geoIPDatabasesReloaderStopCh chan struct{}
+	geoIPDatabasesReloaderWG     sync.WaitGroup
+)
+
+func startGeoIPDatabasesReloader() {
+	if len(*geoIPDatabaseFiles) == 0 {
+		return
+	}
+
+	sighupCh := procutil.NewSighupChan()
+	geoIPDatabasesReloaderStopCh = make(chan struct{})
+	geoIPDatabasesReloaderWG.Go(func() {
+		for {
+			select {
+			case <-geoIPDatabasesReloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -search.geoipDatabase=%q", *geoIPDatabaseFiles)
+			geoIPDatabasesReloads.Inc()
+			if err := loadGeoIPDatabases(); err != nil {
+				geoIPDatabasesReloadErrors.Inc()
+				logger.Errorf("cannot reload -search.geoipDatabase; continuing using the previously loaded databases; error: %s", err)
+				continue
+			}
+			logger.Infof("successfully reloaded -search.geoipDatabase=%q", *geoIPDatabaseFiles)
+		}
+	})
+}
+
+func stopGeoIPDatabasesReloader() {
+	if geoIPDatabasesReloaderStopCh == nil {
+		return
+	}
+	close(geoIPDatabasesReloaderStopCh)
+	geoIPDatabasesReloaderWG.Wait()
+	geoIPDatabasesReloaderStopCh = nil
+}
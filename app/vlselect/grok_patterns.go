@@ -0,0 +1,94 @@
+package vlselect
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var grokPatternsFiles = flagutil.NewArrayString("search.grokPatternsFile", "Optional path to a file with custom grok patterns in the form 'NAME regexp' per line, "+
+	"which can be referred by 'grok' pipe in addition to the built-in patterns. The flag can be specified multiple times. "+
+	"Files are re-read on SIGHUP signal. See https://docs.victoriametrics.com/victorialogs/logsql/#grok-pipe")
+
+var (
+	grokPatternsReloads      = metrics.NewCounter(`vl_grok_patterns_config_reloads_total`)
+	grokPatternsReloadErrors = metrics.NewCounter(`vl_grok_patterns_config_reloads_errors_total`)
+)
+
+func mustLoadGrokPatterns() {
+	if len(*grokPatternsFiles) == 0 {
+		return
+	}
+	if err := loadGrokPatterns(); err != nil {
+		logger.Fatalf("cannot load -search.grokPatternsFile: %s", err)
+	}
+}
+
+func loadGrokPatterns() error {
+	patterns := make(map[string]string)
+	for _, path := range *grokPatternsFiles {
+		data, err := fscore.ReadFileOrHTTP(path)
+		if err != nil {
+			return err
+		}
+		m, err := logstorage.ParseGrokPatterns(data)
+		if err != nil {
+			return fmt.Errorf("cannot parse %q: %w", path, err)
+		}
+		for name, pattern := range m {
+			if _, ok := patterns[name]; ok {
+				return fmt.Errorf("duplicate grok pattern %q at %q", name, path)
+			}
+			patterns[name] = pattern
+		}
+	}
+	return logstorage.SetGrokPatterns(patterns)
+}
+
+var (
+	grokPatternsReloaderStopCh chan struct{}
+	grokPatternsReloaderWG     sync.WaitGroup
+)
+
+func startGrokPatternsReloader() {
+	if len(*grokPatternsFiles) == 0 {
+		return
+	}
+
+	sighupCh := procutil.NewSighupChan()
+	grokPatternsReloaderStopCh = make(chan struct{})
+	grokPatternsReloaderWG.Go(func() {
+		for {
+			select {
+			case <-grokPatternsReloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -search.grokPatternsFile=%q", *grokPatternsFiles)
+			grokPatternsReloads.Inc()
+			if err := loadGrokPatterns(); err != nil {
+				grokPatternsReloadErrors.Inc()
+				logger.Errorf("cannot reload -search.grokPatternsFile; continuing using the previously loaded patterns; error: %s", err)
+				continue
+			}
+			logger.Infof("successfully reloaded -search.grokPatternsFile=%q", *grokPatternsFiles)
+		}
+	})
+}
+
+func stopGrokPatternsReloader() {
+	if grokPatternsReloaderStopCh == nil {
+		return
+	}
+	close(grokPatternsReloaderStopCh)
+	grokPatternsReloaderWG.Wait()
+	grokPatternsReloaderStopCh = nil
+}
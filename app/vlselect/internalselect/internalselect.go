@@ -97,6 +97,14 @@ func processQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return err
 	}
 
+	var streamOwnerFilter *logstorage.StreamOwnerFilter
+	if s := r.FormValue("stream_owner_filter"); s != "" {
+		streamOwnerFilter, err = logstorage.UnmarshalStreamOwnerFilterFromJSON([]byte(s))
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal stream_owner_filter=%q: %w", s, err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/octet-stream")
 
 	var wLock sync.Mutex
@@ -157,6 +165,7 @@ func processQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 	}
 
 	qctx := cp.NewQueryContext(ctx)
+	qctx.StreamOwnerFilter = streamOwnerFilter
 	defer cp.UpdatePerQueryStatsMetrics()
 
 	if err := vlstorage.RunQuery(qctx, writeBlock); err != nil {
@@ -421,7 +430,9 @@ type commonParams struct {
 }
 
 func (cp *commonParams) NewQueryContext(ctx context.Context) *logstorage.QueryContext {
-	return logstorage.NewQueryContext(ctx, &cp.qs, cp.TenantIDs, cp.Query, cp.AllowPartialResponse, cp.HiddenFieldsFilters)
+	qctx := logstorage.NewQueryContext(ctx, &cp.qs, cp.TenantIDs, cp.Query, cp.AllowPartialResponse, cp.HiddenFieldsFilters)
+	qctx.Limits = vlstorage.GetQueryLimits(cp.TenantIDs)
+	return qctx
 }
 
 func (cp *commonParams) UpdatePerQueryStatsMetrics() {
@@ -0,0 +1,394 @@
+package logsql
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// arrowRecordBatchMaxSize is the maximum size of values per record batch buffered by a single worker.
+//
+// It must be smaller than math.MaxInt32, since Arrow Utf8 columns use int32 offsets.
+const arrowRecordBatchMaxSize = 4 * 1024 * 1024
+
+// The subset of constants from https://github.com/apache/arrow/tree/main/format
+const (
+	arrowMetadataVersionV5 = 4
+
+	arrowMessageHeaderSchema      = 1
+	arrowMessageHeaderRecordBatch = 3
+
+	arrowTypeUtf8 = 5
+)
+
+// arrowQueryResponseWriter writes the response in Apache Arrow IPC streaming format.
+//
+// All the fields are written as non-nullable Utf8 columns. Missing fields are written as empty strings.
+// Every worker buffers rows until arrowRecordBatchMaxSize and then writes them as a separate record batch.
+//
+// See https://arrow.apache.org/docs/format/Columnar.html#ipc-streaming-format
+type arrowQueryResponseWriter struct {
+	fields []string
+	sw     *syncWriter
+
+	shards atomicutil.Slice[arrowQueryResponseWriterShard]
+}
+
+type arrowQueryResponseWriterShard struct {
+	columns [][]string
+
+	// offsets and data contain Utf8 column buffers per every field.
+	offsets [][]byte
+	data    [][]byte
+
+	dataLen   int
+	rowsCount int
+
+	buf []byte
+}
+
+func newArrowQueryResponseWriter(fields []string, sw *syncWriter) *arrowQueryResponseWriter {
+	aw := &arrowQueryResponseWriter{
+		fields: fields,
+		sw:     sw,
+	}
+	aw.shards.Init = func(shard *arrowQueryResponseWriterShard) {
+		shard.offsets = make([][]byte, len(fields))
+		shard.data = make([][]byte, len(fields))
+		for i := range shard.offsets {
+			shard.offsets[i] = binary.LittleEndian.AppendUint32(shard.offsets[i], 0)
+		}
+	}
+	return aw
+}
+
+func (aw *arrowQueryResponseWriter) contentType() string {
+	return "application/vnd.apache.arrow.stream"
+}
+
+func (aw *arrowQueryResponseWriter) writeHeader() {
+	var fb flatBuilder
+
+	fieldOffsets := make([]uint32, len(aw.fields))
+	for i, field := range aw.fields {
+		nameOffset := fb.createString(field)
+
+		fb.startTable(0)
+		utf8Offset := fb.endTable()
+
+		fb.startVector(4, 0, 4)
+		childrenOffset := fb.endVector(0)
+
+		// Field table
+		fb.startTable(6)
+		fb.addUOffset(0, nameOffset)
+		fb.addBool(1, false)
+		fb.addUint8(2, arrowTypeUtf8)
+		fb.addUOffset(3, utf8Offset)
+		fb.addUOffset(5, childrenOffset)
+		fieldOffsets[i] = fb.endTable()
+	}
+	fb.startVector(4, len(fieldOffsets), 4)
+	for i := len(fieldOffsets) - 1; i >= 0; i-- {
+		fb.prependUOffset(fieldOffsets[i])
+	}
+	fieldsOffset := fb.endVector(len(fieldOffsets))
+
+	// Schema table
+	fb.startTable(2)
+	fb.addInt16(0, 0)
+	fb.addUOffset(1, fieldsOffset)
+	schemaOffset := fb.endTable()
+
+	b := appendArrowMessage(nil, &fb, arrowMessageHeaderSchema, schemaOffset, nil)
+	_, _ = aw.sw.Write(b)
+}
+
+func (aw *arrowQueryResponseWriter) writeBlock(workerID uint, db *logstorage.DataBlock) {
+	rowsCount := db.RowsCount()
+
+	shard := aw.shards.Get(workerID)
+	shard.columns = getColumnsByFields(shard.columns[:0], db, aw.fields)
+	for i, values := range shard.columns {
+		offsets := shard.offsets[i]
+		data := shard.data[i]
+		for rowIdx := 0; rowIdx < rowsCount; rowIdx++ {
+			v := getColumnValue(values, rowIdx)
+			data = append(data, v...)
+			offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+			shard.dataLen += len(v)
+		}
+		shard.offsets[i] = offsets
+		shard.data[i] = data
+	}
+	shard.rowsCount += rowsCount
+	clear(shard.columns)
+
+	if shard.dataLen >= arrowRecordBatchMaxSize {
+		aw.writeRecordBatch(shard)
+	}
+}
+
+func (aw *arrowQueryResponseWriter) flushRows() {
+	for _, shard := range aw.shards.All() {
+		aw.writeRecordBatch(shard)
+	}
+}
+
+func (aw *arrowQueryResponseWriter) writeFooter() {
+	// Write end-of-stream marker.
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, math.MaxUint32)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	_, _ = aw.sw.Write(b)
+}
+
+// writeRecordBatch writes rows buffered at shard as a single record batch and resets the shard.
+func (aw *arrowQueryResponseWriter) writeRecordBatch(shard *arrowQueryResponseWriterShard) {
+	if shard.rowsCount == 0 {
+		return
+	}
+
+	// Prepare the message body. Every column consists of empty validity buffer, offsets buffer and data buffer.
+	var body []byte
+	var buffers [][2]int64
+	addBuffer := func(b []byte) {
+		buffers = append(buffers, [2]int64{int64(len(body)), int64(len(b))})
+		body = append(body, b...)
+		body = appendArrowPadding(body)
+	}
+	for i := range aw.fields {
+		addBuffer(nil)
+		addBuffer(shard.offsets[i])
+		addBuffer(shard.data[i])
+	}
+
+	var fb flatBuilder
+
+	// FieldNode structs
+	fb.startVector(16, len(aw.fields), 8)
+	for range aw.fields {
+		fb.prependInt64(0)
+		fb.prependInt64(int64(shard.rowsCount))
+	}
+	nodesOffset := fb.endVector(len(aw.fields))
+
+	// Buffer structs
+	fb.startVector(16, len(buffers), 8)
+	for i := len(buffers) - 1; i >= 0; i-- {
+		fb.prependInt64(buffers[i][1])
+		fb.prependInt64(buffers[i][0])
+	}
+	buffersOffset := fb.endVector(len(buffers))
+
+	// RecordBatch table
+	fb.startTable(3)
+	fb.addInt64(0, int64(shard.rowsCount))
+	fb.addUOffset(1, nodesOffset)
+	fb.addUOffset(2, buffersOffset)
+	recordBatchOffset := fb.endTable()
+
+	shard.buf = appendArrowMessage(shard.buf[:0], &fb, arrowMessageHeaderRecordBatch, recordBatchOffset, body)
+	_, _ = aw.sw.Write(shard.buf)
+
+	for i := range shard.offsets {
+		shard.offsets[i] = shard.offsets[i][:4]
+		shard.data[i] = shard.data[i][:0]
+	}
+	shard.dataLen = 0
+	shard.rowsCount = 0
+}
+
+// appendArrowMessage appends encapsulated Arrow IPC message with the given header and body to dst and returns the result.
+//
+// See https://arrow.apache.org/docs/format/Columnar.html#encapsulated-message-format
+func appendArrowMessage(dst []byte, fb *flatBuilder, headerType uint8, headerOffset uint32, body []byte) []byte {
+	// Message table
+	fb.startTable(4)
+	fb.addInt64(3, int64(len(body)))
+	fb.addUOffset(2, headerOffset)
+	fb.addInt16(0, arrowMetadataVersionV5)
+	fb.addUint8(1, headerType)
+	metadata := fb.finish(fb.endTable())
+
+	// The metadata size must include padding, so the body starts at 8-byte boundary.
+	metadataLen := len(metadata) + (8-len(metadata)%8)%8
+	dst = binary.LittleEndian.AppendUint32(dst, math.MaxUint32)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(metadataLen))
+	dst = append(dst, metadata...)
+	dst = appendArrowPadding(dst)
+	return append(dst, body...)
+}
+
+func appendArrowPadding(dst []byte) []byte {
+	for len(dst)%8 != 0 {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+// flatBuilder builds FlatBuffers from the end to the start like the reference FlatBuffers implementation does.
+//
+// See https://flatbuffers.dev/internals/
+type flatBuilder struct {
+	// buf contains the built data at buf[head:].
+	buf  []byte
+	head int
+
+	// minAlign is the maximum alignment seen during building.
+	minAlign int
+
+	// vtable contains offsets for the fields of the currently built table. Zero offset means missing field.
+	vtable []uint32
+
+	// tableStart is the offset for the start of the currently built table.
+	tableStart uint32
+}
+
+// offset returns the offset of the current head from the end of buffer.
+func (fb *flatBuilder) offset() uint32 {
+	return uint32(len(fb.buf) - fb.head)
+}
+
+// prep prepares the buffer for writing size-byte value after writing additionalBytes.
+func (fb *flatBuilder) prep(size, additionalBytes int) {
+	fb.minAlign = max(fb.minAlign, size)
+	alignSize := (-(len(fb.buf) - fb.head + additionalBytes)) & (size - 1)
+	fb.grow(alignSize + size + additionalBytes)
+	for range alignSize {
+		fb.head--
+		fb.buf[fb.head] = 0
+	}
+}
+
+func (fb *flatBuilder) grow(n int) {
+	if fb.head >= n {
+		return
+	}
+	newLen := max(2*len(fb.buf), len(fb.buf)+n, 64)
+	buf := make([]byte, newLen)
+	copy(buf[newLen-len(fb.buf):], fb.buf)
+	fb.head += newLen - len(fb.buf)
+	fb.buf = buf
+}
+
+func (fb *flatBuilder) prependUint8(v uint8) {
+	fb.prep(1, 0)
+	fb.head--
+	fb.buf[fb.head] = v
+}
+
+func (fb *flatBuilder) prependInt16(v int16) {
+	fb.prep(2, 0)
+	fb.head -= 2
+	binary.LittleEndian.PutUint16(fb.buf[fb.head:], uint16(v))
+}
+
+func (fb *flatBuilder) prependUint32(v uint32) {
+	fb.prep(4, 0)
+	fb.head -= 4
+	binary.LittleEndian.PutUint32(fb.buf[fb.head:], v)
+}
+
+func (fb *flatBuilder) prependInt64(v int64) {
+	fb.prep(8, 0)
+	fb.head -= 8
+	binary.LittleEndian.PutUint64(fb.buf[fb.head:], uint64(v))
+}
+
+// prependUOffset prepends the offset to the object at the given offset relative to the current position.
+func (fb *flatBuilder) prependUOffset(offset uint32) {
+	fb.prep(4, 0)
+	fb.prependUint32(fb.offset() - offset + 4)
+}
+
+func (fb *flatBuilder) createString(s string) uint32 {
+	fb.prep(4, len(s)+1)
+	fb.head -= len(s) + 1
+	copy(fb.buf[fb.head:], s)
+	fb.buf[fb.head+len(s)] = 0
+	fb.prependUint32(uint32(len(s)))
+	return fb.offset()
+}
+
+// startVector starts vector with n elements of elemSize bytes aligned to alignment.
+//
+// Elements must be prepended in reverse order and then the vector must be finished with endVector.
+func (fb *flatBuilder) startVector(elemSize, n, alignment int) {
+	fb.prep(4, elemSize*n)
+	fb.prep(alignment, elemSize*n)
+}
+
+func (fb *flatBuilder) endVector(n int) uint32 {
+	fb.prependUint32(uint32(n))
+	return fb.offset()
+}
+
+// startTable starts table with numFields fields. The table must be finished with endTable.
+func (fb *flatBuilder) startTable(numFields int) {
+	fb.vtable = append(fb.vtable[:0], make([]uint32, numFields)...)
+	fb.tableStart = fb.offset()
+}
+
+func (fb *flatBuilder) addBool(slot int, v bool) {
+	b := uint8(0)
+	if v {
+		b = 1
+	}
+	fb.addUint8(slot, b)
+}
+
+func (fb *flatBuilder) addUint8(slot int, v uint8) {
+	fb.prependUint8(v)
+	fb.vtable[slot] = fb.offset()
+}
+
+func (fb *flatBuilder) addInt16(slot int, v int16) {
+	fb.prependInt16(v)
+	fb.vtable[slot] = fb.offset()
+}
+
+func (fb *flatBuilder) addInt64(slot int, v int64) {
+	fb.prependInt64(v)
+	fb.vtable[slot] = fb.offset()
+}
+
+func (fb *flatBuilder) addUOffset(slot int, offset uint32) {
+	fb.prependUOffset(offset)
+	fb.vtable[slot] = fb.offset()
+}
+
+// endTable finishes the table started with startTable and returns its offset.
+func (fb *flatBuilder) endTable() uint32 {
+	// Write placeholder for the offset to vtable.
+	fb.prependUint32(0)
+	tableOffset := fb.offset()
+
+	// Write vtable: vtable size, table size and field offsets relative to the table start.
+	for i := len(fb.vtable) - 1; i >= 0; i-- {
+		off := uint16(0)
+		if fb.vtable[i] != 0 {
+			off = uint16(tableOffset - fb.vtable[i])
+		}
+		fb.prependInt16(int16(off))
+	}
+	fb.prependInt16(int16(tableOffset - fb.tableStart))
+	fb.prependInt16(int16((len(fb.vtable) + 2) * 2))
+
+	// The vtable is located before the table, so the signed offset to it is positive.
+	vtableOffset := fb.offset()
+	binary.LittleEndian.PutUint32(fb.buf[len(fb.buf)-int(tableOffset):], vtableOffset-tableOffset)
+
+	fb.vtable = fb.vtable[:0]
+	return tableOffset
+}
+
+// finish finishes the buffer with the given root table and returns the result.
+func (fb *flatBuilder) finish(rootOffset uint32) []byte {
+	fb.prep(fb.minAlign, 4)
+	fb.prependUOffset(rootOffset)
+	return fb.buf[fb.head:]
+}
@@ -0,0 +1,178 @@
+package logsql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQueryResponseWriterArrow(t *testing.T) {
+	f := func(fields []string, rowsExpected [][]string) {
+		t.Helper()
+
+		data := newTestQueryResponse(t, "arrow", fields, getTestDataBlocks()...)
+		columnNames, rows, err := readTestArrowStream(data)
+		if err != nil {
+			t.Fatalf("cannot read arrow stream: %s", err)
+		}
+		if !reflect.DeepEqual(columnNames, fields) {
+			t.Fatalf("unexpected columns\ngot\n%q\nwant\n%q", columnNames, fields)
+		}
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", rows, rowsExpected)
+		}
+	}
+
+	f([]string{"level", "_msg", "missing"}, [][]string{
+		{"info", "foo", ""},
+		{"error", "a \"quoted\", multiline\nmessage", ""},
+		{"", "tab\there", ""},
+	})
+}
+
+// readTestArrowStream reads column names and rows from Arrow IPC stream generated by arrowQueryResponseWriter.
+func readTestArrowStream(data []byte) ([]string, [][]string, error) {
+	var columnNames []string
+	var rows [][]string
+	for {
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("missing end-of-stream marker")
+		}
+		if binary.LittleEndian.Uint32(data) != math.MaxUint32 {
+			return nil, nil, fmt.Errorf("missing continuation marker")
+		}
+		metadataLen := int(binary.LittleEndian.Uint32(data[4:]))
+		if metadataLen == 0 {
+			// end-of-stream marker
+			if len(data) != 8 {
+				return nil, nil, fmt.Errorf("unexpected data after end-of-stream marker")
+			}
+			return columnNames, rows, nil
+		}
+		if (8+metadataLen)%8 != 0 {
+			return nil, nil, fmt.Errorf("unaligned metadata length: %d", metadataLen)
+		}
+		fb := testFlatBuffer(data[8 : 8+metadataLen])
+		data = data[8+metadataLen:]
+
+		msg := fb.root()
+		if version := fb.uint16Field(msg, 0); version != arrowMetadataVersionV5 {
+			return nil, nil, fmt.Errorf("unexpected metadata version: %d", version)
+		}
+		header := fb.tableField(msg, 2)
+		bodyLen := int(fb.uint64Field(msg, 3))
+		body := data[:bodyLen]
+		data = data[bodyLen:]
+
+		switch headerType := fb.uint8Field(msg, 1); headerType {
+		case arrowMessageHeaderSchema:
+			fieldsVec := fb.tableField(header, 1)
+			for i := range fb.vectorLen(fieldsVec) {
+				field := fb.deref(fieldsVec + 4 + 4*i)
+				if typ := fb.uint8Field(field, 2); typ != arrowTypeUtf8 {
+					return nil, nil, fmt.Errorf("unexpected field type: %d", typ)
+				}
+				if children := fb.tableField(field, 5); children == 0 {
+					return nil, nil, fmt.Errorf("missing field children")
+				}
+				columnNames = append(columnNames, fb.str(fb.tableField(field, 0)))
+			}
+		case arrowMessageHeaderRecordBatch:
+			rowsCount := int(fb.uint64Field(header, 0))
+			buffersVec := fb.tableField(header, 2)
+			buffer := func(i int) []byte {
+				p := buffersVec + 4 + 16*i
+				if p%8 != 0 {
+					panic(fmt.Errorf("unaligned buffer struct"))
+				}
+				offset := binary.LittleEndian.Uint64(fb[p:])
+				size := binary.LittleEndian.Uint64(fb[p+8:])
+				return body[offset : offset+size]
+			}
+			batchRows := make([][]string, rowsCount)
+			for i := range columnNames {
+				offsets := buffer(3*i + 1)
+				values := buffer(3*i + 2)
+				for j := range batchRows {
+					start := binary.LittleEndian.Uint32(offsets[4*j:])
+					end := binary.LittleEndian.Uint32(offsets[4*j+4:])
+					batchRows[j] = append(batchRows[j], string(values[start:end]))
+				}
+			}
+			rows = append(rows, batchRows...)
+		default:
+			return nil, nil, fmt.Errorf("unexpected message header type: %d", headerType)
+		}
+	}
+}
+
+// testFlatBuffer is a minimal FlatBuffers reader for tests.
+type testFlatBuffer []byte
+
+func (fb testFlatBuffer) deref(p int) int {
+	return p + int(binary.LittleEndian.Uint32(fb[p:]))
+}
+
+func (fb testFlatBuffer) root() int {
+	return fb.deref(0)
+}
+
+// fieldPos returns the position of the field at the given slot for the table at p. It returns 0 for missing field.
+func (fb testFlatBuffer) fieldPos(p, slot int) int {
+	vtable := p - int(int32(binary.LittleEndian.Uint32(fb[p:])))
+	vtableSize := int(binary.LittleEndian.Uint16(fb[vtable:]))
+	if 4+2*slot >= vtableSize {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(fb[vtable+4+2*slot:]))
+	if off == 0 {
+		return 0
+	}
+	return p + off
+}
+
+func (fb testFlatBuffer) tableField(p, slot int) int {
+	pos := fb.fieldPos(p, slot)
+	if pos == 0 {
+		return 0
+	}
+	return fb.deref(pos)
+}
+
+func (fb testFlatBuffer) uint8Field(p, slot int) uint8 {
+	pos := fb.fieldPos(p, slot)
+	if pos == 0 {
+		return 0
+	}
+	return fb[pos]
+}
+
+func (fb testFlatBuffer) uint16Field(p, slot int) uint16 {
+	pos := fb.fieldPos(p, slot)
+	if pos == 0 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(fb[pos:])
+}
+
+func (fb testFlatBuffer) uint64Field(p, slot int) uint64 {
+	pos := fb.fieldPos(p, slot)
+	if pos == 0 {
+		return 0
+	}
+	if pos%8 != 0 {
+		panic(fmt.Errorf("unaligned 8-byte field at slot %d", slot))
+	}
+	return binary.LittleEndian.Uint64(fb[pos:])
+}
+
+func (fb testFlatBuffer) vectorLen(p int) int {
+	return int(binary.LittleEndian.Uint32(fb[p:]))
+}
+
+func (fb testFlatBuffer) str(p int) string {
+	n := fb.vectorLen(p)
+	return string(fb[p+4 : p+4+n])
+}
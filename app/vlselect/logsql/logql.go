@@ -0,0 +1,517 @@
+package logsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// logqlQuery is a LogQL query translated into LogsQL.
+//
+// Only a practical subset of LogQL is supported. See https://docs.victoriametrics.com/victorialogs/querying/#loki-query-api
+type logqlQuery struct {
+	// filters contains LogsQL filters, which must be applied to all the selected logs.
+	//
+	// It contains the stream filter obtained from LogQL stream selector plus all the line filters
+	// and label filters, which go before the first parser stage.
+	filters []string
+
+	// pipes contains LogsQL pipes obtained from LogQL pipeline stages after the first parser stage.
+	pipes []string
+
+	// statsFunc contains LogsQL stats function for metric queries. It is empty for log queries.
+	statsFunc string
+
+	// by contains labels to group stats results by.
+	by []string
+
+	// window contains the range duration for metric queries such as `[5m]` in count_over_time({...}[5m]).
+	window string
+}
+
+// isMetric returns true if lq is a metric query.
+func (lq *logqlQuery) isMetric() bool {
+	return lq.statsFunc != ""
+}
+
+// logsql returns LogsQL query for lq.
+//
+// extraFilter is added to lq.filters if it is non-empty. extraPipes are added after lq.pipes and before the stats pipe.
+func (lq *logqlQuery) logsql(extraFilter string, extraPipes ...string) string {
+	a := append([]string{}, lq.filters...)
+	if extraFilter != "" {
+		a = append(a, extraFilter)
+	}
+	s := strings.Join(a, " ")
+	for _, pipe := range lq.pipes {
+		s += " | " + pipe
+	}
+	for _, pipe := range extraPipes {
+		s += " | " + pipe
+	}
+	if lq.statsFunc != "" {
+		if len(lq.by) > 0 {
+			s += fmt.Sprintf(" | stats by (%s) %s as value", strings.Join(lq.by, ", "), lq.statsFunc)
+		} else {
+			s += fmt.Sprintf(" | stats %s as value", lq.statsFunc)
+		}
+	}
+	return s
+}
+
+// parseLogQL parses LogQL query s and translates it into logqlQuery.
+func parseLogQL(s string) (*logqlQuery, error) {
+	tokens, err := tokenizeLogQL(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &logqlParser{
+		tokens: tokens,
+	}
+	lq, err := p.parseQuery()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse LogQL query [%s]: %w", s, err)
+	}
+	if !p.isEnd() {
+		return nil, fmt.Errorf("cannot parse LogQL query [%s]: unexpected tail %q", s, p.tail())
+	}
+	return lq, nil
+}
+
+type logqlParser struct {
+	tokens []logqlToken
+	pos    int
+}
+
+type logqlToken struct {
+	// s is the token value. It is unquoted for string tokens.
+	s string
+
+	// isString is set to true for quoted string tokens.
+	isString bool
+}
+
+func (p *logqlParser) isEnd() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *logqlParser) tail() string {
+	a := make([]string, 0, len(p.tokens)-p.pos)
+	for _, t := range p.tokens[p.pos:] {
+		if t.isString {
+			a = append(a, strconv.Quote(t.s))
+		} else {
+			a = append(a, t.s)
+		}
+	}
+	return strings.Join(a, " ")
+}
+
+// peek returns the current token value. It returns an empty string at the end of tokens.
+func (p *logqlParser) peek() string {
+	if p.isEnd() || p.tokens[p.pos].isString {
+		return ""
+	}
+	return p.tokens[p.pos].s
+}
+
+func (p *logqlParser) next() logqlToken {
+	if p.isEnd() {
+		return logqlToken{}
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *logqlParser) expect(s string) error {
+	if p.isEnd() {
+		return fmt.Errorf("missing %q at the end of query", s)
+	}
+	if p.peek() != s {
+		return fmt.Errorf("expecting %q; got %q", s, p.tail())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *logqlParser) nextString() (string, error) {
+	if p.isEnd() || !p.tokens[p.pos].isString {
+		return "", fmt.Errorf("expecting quoted string; got %q", p.tail())
+	}
+	return p.next().s, nil
+}
+
+func (p *logqlParser) parseQuery() (*logqlQuery, error) {
+	switch name := p.peek(); {
+	case name == "{":
+		return p.parseLogQuery()
+	case name == "sum":
+		return p.parseSum()
+	case logqlRangeFuncs[name] != "":
+		return p.parseRangeFunc()
+	case name == "":
+		return nil, fmt.Errorf("missing query")
+	default:
+		return nil, fmt.Errorf("unsupported expression %q; supported expressions: stream selector, sum, count_over_time, rate, bytes_over_time", name)
+	}
+}
+
+// logqlRangeFuncs maps LogQL range functions to the corresponding LogsQL stats functions.
+var logqlRangeFuncs = map[string]string{
+	"count_over_time": "count()",
+	"rate":            "rate()",
+	"bytes_over_time": "sum_len(_msg)",
+}
+
+// parseSum parses `sum [by (...)] (range_func) [by (...)]`.
+func (p *logqlParser) parseSum() (*logqlQuery, error) {
+	if err := p.expect("sum"); err != nil {
+		return nil, err
+	}
+
+	var by []string
+	hasBy := false
+	if p.peek() == "by" {
+		labels, err := p.parseBy()
+		if err != nil {
+			return nil, err
+		}
+		by = labels
+		hasBy = true
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	lq, err := p.parseRangeFunc()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	switch p.peek() {
+	case "by":
+		if hasBy {
+			return nil, fmt.Errorf("duplicate 'by' clause in sum()")
+		}
+		labels, err := p.parseBy()
+		if err != nil {
+			return nil, err
+		}
+		by = labels
+	case "without":
+		return nil, fmt.Errorf("'without' clause isn't supported in sum(); use 'by' clause instead")
+	}
+
+	lq.by = by
+	return lq, nil
+}
+
+func (p *logqlParser) parseBy() ([]string, error) {
+	if err := p.expect("by"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for p.peek() != ")" {
+		if len(labels) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		label := p.peek()
+		if !isLogQLIdent(label) {
+			return nil, fmt.Errorf("expecting label name inside 'by(...)'; got %q", p.tail())
+		}
+		p.pos++
+		labels = append(labels, label)
+	}
+	p.pos++
+	return labels, nil
+}
+
+// parseRangeFunc parses `range_func(log_query [window])`.
+//
+// The returned query groups results by _stream in the same way as Loki groups them by all the stream labels.
+func (p *logqlParser) parseRangeFunc() (*logqlQuery, error) {
+	name := p.peek()
+	statsFunc := logqlRangeFuncs[name]
+	if statsFunc == "" {
+		return nil, fmt.Errorf("unsupported range function %q; supported functions: count_over_time, rate, bytes_over_time", name)
+	}
+	p.pos++
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	lq, err := p.parseLogQuery()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	window := p.peek()
+	if window == "" || window == "]" {
+		return nil, fmt.Errorf("missing range duration in %s()", name)
+	}
+	p.pos++
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	lq.window = window
+	lq.statsFunc = statsFunc
+	lq.by = []string{"_stream"}
+	return lq, nil
+}
+
+// parseLogQuery parses `{stream_selector} stage1 ... stageN`.
+func (p *logqlParser) parseLogQuery() (*logqlQuery, error) {
+	selector, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	lq := &logqlQuery{
+		filters: []string{selector},
+	}
+	hasParser := false
+	addFilter := func(filter string) {
+		if hasParser {
+			lq.pipes = append(lq.pipes, "filter "+filter)
+		} else {
+			lq.filters = append(lq.filters, filter)
+		}
+	}
+
+	for {
+		switch op := p.peek(); op {
+		case "|=", "!=", "|~", "!~":
+			p.pos++
+			s, err := p.nextString()
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse line filter %q: %w", op, err)
+			}
+			addFilter(newLogQLLineFilter(op, s))
+		case "|":
+			p.pos++
+			switch stage := p.peek(); stage {
+			case "json", "logfmt":
+				p.pos++
+				if isLogQLIdent(p.peek()) {
+					return nil, fmt.Errorf("%s parser with arguments isn't supported", stage)
+				}
+				lq.pipes = append(lq.pipes, "unpack_"+stage+" keep_original_fields")
+				hasParser = true
+			case "line_format", "label_format", "unwrap", "pattern", "regexp", "unpack", "drop", "keep", "decolorize", "distinct":
+				return nil, fmt.Errorf("unsupported pipeline stage %q; supported stages: line filters, json, logfmt, label filters", stage)
+			default:
+				filter, err := p.parseLabelFilters()
+				if err != nil {
+					return nil, err
+				}
+				addFilter(filter)
+			}
+		default:
+			return lq, nil
+		}
+	}
+}
+
+func (p *logqlParser) parseSelector() (string, error) {
+	if err := p.expect("{"); err != nil {
+		return "", err
+	}
+	var matchers []string
+	for p.peek() != "}" {
+		if len(matchers) > 0 {
+			if err := p.expect(","); err != nil {
+				return "", err
+			}
+		}
+		label := p.peek()
+		if !isLogQLIdent(label) {
+			return "", fmt.Errorf("expecting label name in stream selector; got %q", p.tail())
+		}
+		p.pos++
+		op := p.peek()
+		switch op {
+		case "=", "!=", "=~", "!~":
+			p.pos++
+		default:
+			return "", fmt.Errorf("unexpected operator in stream selector after %q; got %q; want =, !=, =~ or !~", label, p.tail())
+		}
+		value, err := p.nextString()
+		if err != nil {
+			return "", fmt.Errorf("cannot parse value for %q in stream selector: %w", label, err)
+		}
+		matchers = append(matchers, label+op+strconv.Quote(value))
+	}
+	p.pos++
+	if len(matchers) == 0 {
+		return "*", nil
+	}
+	return "{" + strings.Join(matchers, ",") + "}", nil
+}
+
+func newLogQLLineFilter(op, s string) string {
+	switch op {
+	case "|=":
+		return "*" + strconv.Quote(s) + "*"
+	case "!=":
+		return "!*" + strconv.Quote(s) + "*"
+	case "|~":
+		return "~" + strconv.Quote(s)
+	default:
+		return "!~" + strconv.Quote(s)
+	}
+}
+
+// parseLabelFilters parses `label op value` filters joined with `and`, `or` or `,`.
+func (p *logqlParser) parseLabelFilters() (string, error) {
+	var a []string
+	for {
+		filter, err := p.parseLabelFilter()
+		if err != nil {
+			return "", err
+		}
+		a = append(a, filter)
+
+		switch p.peek() {
+		case "and", ",":
+			p.pos++
+		case "or":
+			p.pos++
+			a = append(a, "or")
+		default:
+			if len(a) == 1 {
+				return a[0], nil
+			}
+			return "(" + strings.Join(a, " ") + ")", nil
+		}
+	}
+}
+
+func (p *logqlParser) parseLabelFilter() (string, error) {
+	label := p.peek()
+	if !isLogQLIdent(label) {
+		return "", fmt.Errorf("expecting label filter; got %q", p.tail())
+	}
+	p.pos++
+	op := p.peek()
+	switch op {
+	case "=", "==", "!=", "=~", "!~", ">", ">=", "<", "<=":
+		p.pos++
+	default:
+		return "", fmt.Errorf("unexpected operator in label filter after %q; got %q", label, p.tail())
+	}
+	if p.isEnd() {
+		return "", fmt.Errorf("missing value for label filter %s%s", label, op)
+	}
+	t := p.next()
+	value := t.s
+	if !t.isString && !isLogQLNumber(value) {
+		return "", fmt.Errorf("unexpected value for label filter %s%s; got %q", label, op, value)
+	}
+
+	switch op {
+	case "=", "==":
+		return label + ":=" + strconv.Quote(value), nil
+	case "!=":
+		return "!" + label + ":=" + strconv.Quote(value), nil
+	case "=~":
+		return label + ":~" + strconv.Quote("^(?:"+value+")$"), nil
+	case "!~":
+		return "!" + label + ":~" + strconv.Quote("^(?:"+value+")$"), nil
+	default:
+		if t.isString {
+			return "", fmt.Errorf("label filter %s%s requires numeric value; got %q", label, op, value)
+		}
+		return label + ":" + op + value, nil
+	}
+}
+
+func isLogQLIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isLogQLNumber(s string) bool {
+	return s != "" && (s[0] >= '0' && s[0] <= '9' || s[0] == '-' || s[0] == '.')
+}
+
+// tokenizeLogQL splits LogQL query s into tokens.
+func tokenizeLogQL(s string) ([]logqlToken, error) {
+	var tokens []logqlToken
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return tokens, nil
+		}
+
+		switch c := s[0]; {
+		case c == '"':
+			prefix, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse quoted string at %q: %w", s, err)
+			}
+			v, err := strconv.Unquote(prefix)
+			if err != nil {
+				return nil, fmt.Errorf("cannot unquote %s: %w", prefix, err)
+			}
+			tokens = append(tokens, logqlToken{
+				s:        v,
+				isString: true,
+			})
+			s = s[len(prefix):]
+		case c == '`':
+			n := strings.IndexByte(s[1:], '`')
+			if n < 0 {
+				return nil, fmt.Errorf("missing closing backtick at %q", s)
+			}
+			tokens = append(tokens, logqlToken{
+				s:        s[1 : n+1],
+				isString: true,
+			})
+			s = s[n+2:]
+		case c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'):
+			n := 1
+			for n < len(s) && isLogQLIdentChar(s[n]) {
+				n++
+			}
+			tokens = append(tokens, logqlToken{
+				s: s[:n],
+			})
+			s = s[n:]
+		default:
+			op := s[:1]
+			if len(s) >= 2 {
+				switch s[:2] {
+				case "|=", "|~", "!=", "!~", "=~", "==", ">=", "<=":
+					op = s[:2]
+				}
+			}
+			if !strings.Contains("{}()[],|=<>", op) && len(op) == 1 {
+				return nil, fmt.Errorf("unexpected char %q at %q", op, s)
+			}
+			tokens = append(tokens, logqlToken{
+				s: op,
+			})
+			s = s[len(op):]
+		}
+	}
+}
+
+func isLogQLIdentChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,95 @@
+package logsql
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestParseLogQL_Success(t *testing.T) {
+	f := func(s, logsqlExpected, windowExpected string) {
+		t.Helper()
+
+		lq, err := parseLogQL(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := lq.logsql("")
+		if result != logsqlExpected {
+			t.Fatalf("unexpected LogsQL\ngot\n%s\nwant\n%s", result, logsqlExpected)
+		}
+		if lq.window != windowExpected {
+			t.Fatalf("unexpected window; got %q; want %q", lq.window, windowExpected)
+		}
+
+		// Verify that the translated query is valid LogsQL
+		if _, err := logstorage.ParseQuery(result); err != nil {
+			t.Fatalf("cannot parse the translated query [%s]: %s", result, err)
+		}
+	}
+
+	// stream selectors
+	f(`{}`, `*`, "")
+	f(`{app="nginx"}`, `{app="nginx"}`, "")
+	f(`{app="nginx", env!="dev",host=~"web-.+" , dc!~ "eu.*"}`, `{app="nginx",env!="dev",host=~"web-.+",dc!~"eu.*"}`, "")
+	f("{app=`a\\b`}", `{app="a\\b"}`, "")
+
+	// line filters
+	f(`{app="nginx"} |= "error" != "timeout" |~ "(?i)fail.+" !~ "debug"`,
+		`{app="nginx"} *"error"* !*"timeout"* ~"(?i)fail.+" !~"debug"`, "")
+
+	// label filters before parsers
+	f(`{app="nginx"} | level="error"`, `{app="nginx"} level:="error"`, "")
+
+	// parsers and label filters after them
+	f(`{app="nginx"} |= "GET" | json | status >= 500 and method=~"GET|POST" | path != "/health"`,
+		`{app="nginx"} *"GET"* | unpack_json keep_original_fields | filter (status:>=500 method:~"^(?:GET|POST)$") | filter !path:="/health"`, "")
+	f(`{app="nginx"} | logfmt | level="error" or level="warn" |= "disk"`,
+		`{app="nginx"} | unpack_logfmt keep_original_fields | filter (level:="error" or level:="warn") | filter *"disk"*`, "")
+	f(`{app="nginx"} | json | duration > 1.5s, status == 200`,
+		`{app="nginx"} | unpack_json keep_original_fields | filter (duration:>1.5s status:="200")`, "")
+
+	// metric queries
+	f(`count_over_time({app="nginx"}[5m])`, `{app="nginx"} | stats by (_stream) count() as value`, "5m")
+	f(`rate({app="nginx"} |= "error" [1m])`, `{app="nginx"} *"error"* | stats by (_stream) rate() as value`, "1m")
+	f(`bytes_over_time({app="nginx"}[1h])`, `{app="nginx"} | stats by (_stream) sum_len(_msg) as value`, "1h")
+	f(`sum(count_over_time({app="nginx"}[5m]))`, `{app="nginx"} | stats count() as value`, "5m")
+	f(`sum by (host, level) (rate({app="nginx"} | json [5m]))`,
+		`{app="nginx"} | unpack_json keep_original_fields | stats by (host, level) rate() as value`, "5m")
+	f(`sum(count_over_time({app="nginx"}[5m])) by (host)`, `{app="nginx"} | stats by (host) count() as value`, "5m")
+}
+
+func TestParseLogQL_Failure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		lq, err := parseLogQL(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error; got %q", lq.logsql(""))
+		}
+	}
+
+	f(``)
+	f(`app="nginx"`)
+	f(`{app="nginx"`)
+	f(`{app}`)
+	f(`{app=nginx}`)
+	f(`{app>"nginx"}`)
+	f(`{app="nginx"} |= error`)
+	f(`{app="nginx"} | line_format "{{.msg}}"`)
+	f(`{app="nginx"} | json foo="bar"`)
+	f(`{app="nginx"} | status >= "500"`)
+	f(`{app="nginx"} | status >= `)
+	f(`{app="nginx"} foo`)
+	f(`{app="nginx"} | logfmt | x="y" # comment`)
+
+	// unsupported metric queries
+	f(`count_over_time({app="nginx"})`)
+	f(`count_over_time({app="nginx"}[])`)
+	f(`avg_over_time({app="nginx"} | unwrap duration [5m])`)
+	f(`sum without (host) (count_over_time({app="nginx"}[5m]))`)
+	f(`sum(count_over_time({app="nginx"}[5m])) without (host)`)
+	f(`sum by (host) (count_over_time({app="nginx"}[5m])) by (level)`)
+	f(`topk(10, count_over_time({app="nginx"}[5m]))`)
+	f(`sum({app="nginx"})`)
+}
@@ -16,7 +16,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
@@ -706,6 +705,10 @@ func ProcessLiveTailRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	qctx := ca.newQueryContext(ctxWithCancel)
 	defer ca.updatePerQueryStatsMetrics()
 
+	// Do not apply per-tenant query limits to live tailing, since it executes the query periodically
+	// for very long time, while accumulating query stats across executions.
+	qctx.Limits = nil
+
 	q := ca.q
 	qOrig := q
 	for {
@@ -877,12 +880,38 @@ func ProcessStatsQueryRangeRequest(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	labelFields, err := ca.q.GetStatsLabelsAddGroupingByTime(step, offset)
+	// Execute the request.
+	startTime := time.Now()
+	var rows []*statsSeries
+	if httputil.GetBool(r, "nocache") {
+		rows, err = ca.getStatsSeries(ctx, step, offset)
+	} else {
+		rows, err = ca.getStatsSeriesCached(ctx, r.FormValue("query"), step, offset)
+	}
 	if err != nil {
 		httpserver.SendPrometheusError(w, r, err)
 		return
 	}
 
+	// Write response headers
+	h := w.Header()
+
+	h.Set("Content-Type", "application/json")
+	ca.writeResponseHeaders(h, startTime)
+
+	// Write response
+	WriteStatsQueryRangeResponse(w, rows)
+}
+
+// getStatsSeries executes the stats query at ca with the given step and offset and returns the results sorted by series key.
+//
+// Points in every returned series are sorted by timestamp.
+func (ca *commonArgs) getStatsSeries(ctx context.Context, step, offset int64) ([]*statsSeries, error) {
+	labelFields, err := ca.q.GetStatsLabelsAddGroupingByTime(step, offset)
+	if err != nil {
+		return nil, err
+	}
+
 	m := make(map[string]*statsSeries)
 	var mLock sync.Mutex
 
@@ -978,12 +1007,8 @@ func ProcessStatsQueryRangeRequest(ctx context.Context, w http.ResponseWriter, r
 	qctx := ca.newQueryContext(ctx)
 	defer ca.updatePerQueryStatsMetrics()
 
-	// Execute the request.
-	startTime := time.Now()
 	if err := vlstorage.RunQuery(qctx, writeBlock); err != nil {
-		err = fmt.Errorf("cannot execute query [%s]: %s", ca.q, err)
-		httpserver.SendPrometheusError(w, r, err)
-		return
+		return nil, fmt.Errorf("cannot execute query [%s]: %s", ca.q, err)
 	}
 
 	// Sort the collected stats by _time
@@ -998,15 +1023,7 @@ func ProcessStatsQueryRangeRequest(ctx context.Context, w http.ResponseWriter, r
 	sort.Slice(rows, func(i, j int) bool {
 		return rows[i].key < rows[j].key
 	})
-
-	// Write response headers
-	h := w.Header()
-
-	h.Set("Content-Type", "application/json")
-	ca.writeResponseHeaders(h, startTime)
-
-	// Write response
-	WriteStatsQueryRangeResponse(w, rows)
+	return rows, nil
 }
 
 type statsSeries struct {
@@ -1032,13 +1049,66 @@ func ProcessStatsQueryRequest(ctx context.Context, w http.ResponseWriter, r *htt
 		return
 	}
 
-	labelFields, err := ca.q.GetStatsLabels()
+	// Execute the query
+	startTime := time.Now()
+	rows, err := ca.getStatsRows(ctx)
 	if err != nil {
 		httpserver.SendPrometheusError(w, r, err)
 		return
 	}
 
-	var rows []statsRow
+	// Write response headers
+	h := w.Header()
+
+	h.Set("Content-Type", "application/json")
+	ca.writeResponseHeaders(h, startTime)
+
+	// Write response
+	WriteStatsQueryResponse(w, rows)
+}
+
+// StatsRow is a single result row returned from the stats query.
+//
+// See RunStatsQuery.
+type StatsRow struct {
+	Name      string
+	Labels    []logstorage.Field
+	Timestamp int64
+	Value     string
+}
+
+// RunStatsQuery executes the given LogsQL stats query qStr for the given tenantID at the given timestamp in nanoseconds.
+//
+// It returns the same results as /select/logsql/stats_query does.
+// See https://docs.victoriametrics.com/victorialogs/querying/#querying-log-stats
+func RunStatsQuery(ctx context.Context, tenantID logstorage.TenantID, qStr string, timestamp int64) ([]StatsRow, error) {
+	// decrease timestamp by one nanosecond in the same way as parseCommonArgs does.
+	timestamp--
+
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+	}
+	ca := &commonArgs{
+		q:         q,
+		tenantIDs: []logstorage.TenantID{tenantID},
+
+		allowPartialResponse: *allowPartialResponseFlag,
+
+		startAligned: math.MinInt64,
+		endAligned:   math.MaxInt64,
+	}
+	return ca.getStatsRows(ctx)
+}
+
+// getStatsRows executes the stats query at ca and returns the results.
+func (ca *commonArgs) getStatsRows(ctx context.Context) ([]StatsRow, error) {
+	labelFields, err := ca.q.GetStatsLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []StatsRow
 	var rowsLock sync.Mutex
 
 	timestamp := ca.q.GetTimestamp()
@@ -1073,7 +1143,7 @@ func ProcessStatsQueryRequest(ctx context.Context, w http.ResponseWriter, r *htt
 					var buckets []histogramBucket
 					if err := json.Unmarshal([]byte(v), &buckets); err == nil {
 						name := clonedColumnNames[j] + "_bucket"
-						bucketRows := make([]statsRow, 0, len(buckets))
+						bucketRows := make([]StatsRow, 0, len(buckets))
 						for _, bucket := range buckets {
 							bucketLabels := make([]logstorage.Field, 0, len(labels)+1)
 							bucketLabels = append(bucketLabels, labels...)
@@ -1081,7 +1151,7 @@ func ProcessStatsQueryRequest(ctx context.Context, w http.ResponseWriter, r *htt
 								Name:  "vmrange",
 								Value: bucket.VMRange,
 							})
-							bucketRows = append(bucketRows, statsRow{
+							bucketRows = append(bucketRows, StatsRow{
 								Name:      name,
 								Labels:    bucketLabels,
 								Timestamp: timestamp,
@@ -1096,7 +1166,7 @@ func ProcessStatsQueryRequest(ctx context.Context, w http.ResponseWriter, r *htt
 					}
 				}
 
-				r := statsRow{
+				r := StatsRow{
 					Name:      clonedColumnNames[j],
 					Labels:    labels,
 					Timestamp: timestamp,
@@ -1113,29 +1183,10 @@ func ProcessStatsQueryRequest(ctx context.Context, w http.ResponseWriter, r *htt
 	qctx := ca.newQueryContext(ctx)
 	defer ca.updatePerQueryStatsMetrics()
 
-	// Execute the query
-	startTime := time.Now()
 	if err := vlstorage.RunQuery(qctx, writeBlock); err != nil {
-		err = fmt.Errorf("cannot execute query [%s]: %s", ca.q, err)
-		httpserver.SendPrometheusError(w, r, err)
-		return
+		return nil, fmt.Errorf("cannot execute query [%s]: %s", ca.q, err)
 	}
-
-	// Write response headers
-	h := w.Header()
-
-	h.Set("Content-Type", "application/json")
-	ca.writeResponseHeaders(h, startTime)
-
-	// Write response
-	WriteStatsQueryResponse(w, rows)
-}
-
-type statsRow struct {
-	Name      string
-	Labels    []logstorage.Field
-	Timestamp int64
-	Value     string
+	return rows, nil
 }
 
 type histogramBucket struct {
@@ -1167,20 +1218,23 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Parse format and fields query args
+	format := r.FormValue("format")
+	fields, err := getStringSliceFromRequest(r, "fields")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
 	sw := &syncWriter{
 		w: w,
 	}
-
-	var bwShards atomicutil.Slice[bufferedWriter]
-	bwShards.Init = func(shard *bufferedWriter) {
-		shard.sw = sw
+	qw, err := newQueryResponseWriter(format, fields, sw)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
 	}
-	defer func() {
-		shards := bwShards.All()
-		for _, shard := range shards {
-			shard.FlushIgnoreErrors()
-		}
-	}()
+	defer qw.flushRows()
 
 	if limit > 0 {
 		// Add '| sort by (_time) desc | offset <offset> | limit <limit>' to the end of the query.
@@ -1196,25 +1250,18 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 		// Write response headers
 		h := w.Header()
 
-		h.Set("Content-Type", "application/stream+json")
+		h.Set("Content-Type", qw.contentType())
 		ca.writeResponseHeaders(h, startTime)
+
+		qw.writeHeader()
 	})
 
 	writeBlock := func(workerID uint, db *logstorage.DataBlock) {
 		writeResponseHeadersOnce()
-		rowsCount := db.RowsCount()
-		if rowsCount == 0 {
+		if db.RowsCount() == 0 {
 			return
 		}
-		columns := db.Columns
-
-		bw := bwShards.Get(workerID)
-		for i := 0; i < rowsCount; i++ {
-			WriteJSONRow(bw, columns, i)
-			if len(bw.buf) > 16*1024 {
-				bw.FlushIgnoreErrors()
-			}
-		}
+		qw.writeBlock(workerID, db)
 	}
 
 	qctx := ca.newQueryContext(ctx)
@@ -1228,6 +1275,77 @@ func ProcessQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	// This call is needed for the case when the response didn't return any results.
 	writeResponseHeadersOnce()
+
+	qw.flushRows()
+	qw.writeFooter()
+}
+
+// ProcessExplainRequest handles /select/logsql/explain request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#query-plan
+func ProcessExplainRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ca, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Parse offset query arg
+	offset, err := getPositiveInt(r, "offset")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Parse limit query arg
+	limit, err := getPositiveInt(r, "limit")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Parse analyze query arg
+	analyze := httputil.GetBool(r, "analyze")
+
+	if limit > 0 {
+		// Apply the same transformations as /select/logsql/query does, so the returned plan matches the executed query.
+		if ca.q.CanReturnLastNResults() {
+			ca.q.AddPipeSortByTimeDesc()
+		}
+		ca.q.AddPipeOffsetLimit(uint64(offset), uint64(limit))
+	}
+
+	qp := logstorage.NewQueryPlan(ca.q)
+	qp.Partitions = vlstorage.GetQueryPartitionsPlan(ca.q)
+
+	if analyze {
+		// Execute the query and collect the actual execution stats. The query results are dropped.
+		qctx := ca.newQueryContext(ctx)
+		qctx.StageStats = &logstorage.QueryStageStats{}
+		defer ca.updatePerQueryStatsMetrics()
+
+		writeBlock := func(_ uint, _ *logstorage.DataBlock) {}
+		if err := vlstorage.RunQuery(qctx, writeBlock); err != nil {
+			httpserver.Errorf(w, r, "cannot execute query [%s]: %s", ca.q, err)
+			return
+		}
+
+		qp.Stages = qctx.StageStats.GetStages()
+		qp.SetQueryStats(&ca.qs, qctx.QueryDurationNsecs())
+	}
+
+	data, err := json.Marshal(qp)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot marshal query plan to JSON: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		httpserver.Errorf(w, r, "cannot send response to the client: %s", err)
+		return
+	}
 }
 
 // ProcessTenantIDsRequest processes /select/tenant_ids request.
@@ -1337,6 +1455,9 @@ type commonArgs struct {
 	// Optional fields and field prefixes to hide during query execution.
 	hiddenFieldsFilters []string
 
+	// extraFilters contains the parsed extra_filters and extra_stream_filters, which are added to q.
+	extraFilters []*logstorage.Filter
+
 	// qs contains query execution statistics.
 	qs logstorage.QueryStats
 
@@ -1348,7 +1469,9 @@ type commonArgs struct {
 }
 
 func (ca *commonArgs) newQueryContext(ctx context.Context) *logstorage.QueryContext {
-	return logstorage.NewQueryContext(ctx, &ca.qs, ca.tenantIDs, ca.q, ca.allowPartialResponse, ca.hiddenFieldsFilters)
+	qctx := logstorage.NewQueryContext(ctx, &ca.qs, ca.tenantIDs, ca.q, ca.allowPartialResponse, ca.hiddenFieldsFilters)
+	qctx.Limits = vlstorage.GetQueryLimits(ca.tenantIDs)
+	return qctx
 }
 
 func (ca *commonArgs) updatePerQueryStatsMetrics() {
@@ -1447,12 +1570,14 @@ func parseCommonArgsWithConfig(r *http.Request, skipMaxRangeCheck bool) (*common
 	}
 
 	// Parse optional extra_filters
+	var allExtraFilters []*logstorage.Filter
 	for _, extraFiltersStr := range r.Form["extra_filters"] {
 		extraFilters, err := parseExtraFilters(extraFiltersStr)
 		if err != nil {
 			return nil, err
 		}
 		q.AddExtraFilters(extraFilters)
+		allExtraFilters = append(allExtraFilters, extraFilters)
 	}
 
 	// Parse optional extra_stream_filters
@@ -1462,6 +1587,7 @@ func parseCommonArgsWithConfig(r *http.Request, skipMaxRangeCheck bool) (*common
 			return nil, err
 		}
 		q.AddExtraFilters(extraStreamFilters)
+		allExtraFilters = append(allExtraFilters, extraStreamFilters)
 	}
 
 	if maxRange := maxQueryTimeRange.Duration(); maxRange > 0 && !skipMaxRangeCheck {
@@ -1492,6 +1618,7 @@ func parseCommonArgsWithConfig(r *http.Request, skipMaxRangeCheck bool) (*common
 
 		allowPartialResponse: allowPartialResponse,
 		hiddenFieldsFilters:  hiddenFieldsFilters,
+		extraFilters:         allExtraFilters,
 
 		startAligned: startAligned,
 		endAligned:   endAligned,
@@ -0,0 +1,662 @@
+package logsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// ProcessLokiQueryRangeRequest handles /select/loki/api/v1/query_range request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#loki-query-api
+func ProcessLokiQueryRangeRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	lq, err := parseLokiQuery(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if err := setLokiTimeRange(r, time.Hour); err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	if !lq.isMetric() {
+		// The step arg is ignored for log queries by Loki, while it is used for aligning the selected time range by parseCommonArgs.
+		r.Form.Del("step")
+		processLokiLogQuery(ctx, w, r, lq)
+		return
+	}
+
+	step, err := getLokiStep(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	window, ok := logstorage.TryParseDuration(lq.window)
+	if !ok || window <= 0 {
+		httpserver.Errorf(w, r, "cannot parse [%s] window in the query", lq.window)
+		return
+	}
+	if window != step {
+		processLokiSlidingWindowQuery(ctx, w, r, lq, step, window)
+		return
+	}
+	r.Form.Set("step", fmt.Sprintf("%dns", step))
+	r.Form.Set("query", lq.logsql(""))
+
+	ca, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	startTime := time.Now()
+	rows, err := ca.getStatsSeries(ctx, step, 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	result := make([]lokiMatrixSeries, 0, len(rows))
+	for _, ss := range rows {
+		values := make([][2]any, 0, len(ss.Points))
+		for _, p := range ss.Points {
+			values = append(values, [2]any{lokiTimestamp(p.Timestamp), p.Value})
+		}
+		result = append(result, lokiMatrixSeries{
+			Metric: getLokiLabels(ss.Labels),
+			Values: values,
+		})
+	}
+	writeLokiResponse(w, ca, startTime, lokiQueryData{
+		ResultType: "matrix",
+		Result:     result,
+	})
+}
+
+// ProcessLokiQueryRequest handles /select/loki/api/v1/query request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#loki-query-api
+func ProcessLokiQueryRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	lq, err := parseLokiQuery(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Instant queries are evaluated at the `time` query arg, so drop start and end args in the same way as Loki does.
+	r.Form.Del("start")
+	r.Form.Del("end")
+	r.Form.Del("step")
+
+	if !lq.isMetric() {
+		processLokiLogQuery(ctx, w, r, lq)
+		return
+	}
+
+	r.Form.Set("query", lq.logsql("_time:"+lq.window))
+	ca, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	startTime := time.Now()
+	rows, err := ca.getStatsRows(ctx)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	// Adjust the timestamp to the `time` query arg, since parseCommonArgs decreases it by one nanosecond.
+	timestamp := lokiTimestamp(ca.q.GetTimestamp() + 1)
+	result := make([]lokiVectorSample, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, lokiVectorSample{
+			Metric: getLokiLabels(row.Labels),
+			Value:  [2]any{timestamp, row.Value},
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key() < result[j].key()
+	})
+	writeLokiResponse(w, ca, startTime, lokiQueryData{
+		ResultType: "vector",
+		Result:     result,
+	})
+}
+
+// processLokiLogQuery executes LogQL log query lq and writes the results in Loki `streams` format to w.
+func processLokiLogQuery(ctx context.Context, w http.ResponseWriter, r *http.Request, lq *logqlQuery) {
+	limit, err := getPositiveInt(r, "limit")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if limit == 0 {
+		limit = 100
+	}
+
+	direction := r.FormValue("direction")
+	sortPipe := "sort by (_time) desc"
+	switch direction {
+	case "", "backward":
+	case "forward":
+		sortPipe = "sort by (_time)"
+	default:
+		httpserver.Errorf(w, r, "unexpected direction=%q; supported values: forward, backward", direction)
+		return
+	}
+
+	r.Form.Set("query", lq.logsql("", "fields _time, _stream, _msg", sortPipe, fmt.Sprintf("limit %d", limit)))
+	ca, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	m := make(map[string]*lokiStream)
+	var mLock sync.Mutex
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		var timestamps, streams, msgs []string
+		for _, c := range db.Columns {
+			switch c.Name {
+			case "_time":
+				timestamps = c.Values
+			case "_stream":
+				streams = c.Values
+			case "_msg":
+				msgs = c.Values
+			}
+		}
+
+		mLock.Lock()
+		defer mLock.Unlock()
+
+		for i := 0; i < db.RowsCount(); i++ {
+			streamStr := getColumnValue(streams, i)
+			ls := m[streamStr]
+			if ls == nil {
+				ls = &lokiStream{
+					key: strings.Clone(streamStr),
+				}
+				m[ls.key] = ls
+			}
+			nsec, ok := logstorage.TryParseTimestampRFC3339Nano(getColumnValue(timestamps, i))
+			if !ok {
+				continue
+			}
+			ls.entries = append(ls.entries, lokiEntry{
+				timestamp: nsec,
+				line:      strings.Clone(getColumnValue(msgs, i)),
+			})
+		}
+	}
+
+	qctx := ca.newQueryContext(ctx)
+	defer ca.updatePerQueryStatsMetrics()
+
+	startTime := time.Now()
+	if err := vlstorage.RunQuery(qctx, writeBlock); err != nil {
+		httpserver.Errorf(w, r, "cannot execute query [%s]: %s", ca.q, err)
+		return
+	}
+
+	result := make([]lokiStreamResult, 0, len(m))
+	for _, ls := range m {
+		entries := ls.entries
+		sort.Slice(entries, func(i, j int) bool {
+			if direction == "forward" {
+				return entries[i].timestamp < entries[j].timestamp
+			}
+			return entries[i].timestamp > entries[j].timestamp
+		})
+		values := make([][2]string, 0, len(entries))
+		for _, e := range entries {
+			values = append(values, [2]string{strconv.FormatInt(e.timestamp, 10), e.line})
+		}
+		result = append(result, lokiStreamResult{
+			Stream: parseLokiStream(ls.key),
+			Values: values,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key() < result[j].key()
+	})
+	writeLokiResponse(w, ca, startTime, lokiQueryData{
+		ResultType: "streams",
+		Result:     result,
+	})
+}
+
+// ProcessLokiLabelsRequest handles /select/loki/api/v1/labels request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#loki-query-api
+func ProcessLokiLabelsRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ca, err := parseLokiMetadataArgs(r, r.FormValue("query"))
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	qctx := ca.newQueryContext(ctx)
+	defer ca.updatePerQueryStatsMetrics()
+
+	startTime := time.Now()
+	names, err := vlstorage.GetStreamFieldNames(qctx)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain stream field names: %s", err)
+		return
+	}
+	writeLokiResponse(w, ca, startTime, getValuesFromHits(names))
+}
+
+// ProcessLokiLabelValuesRequest handles /select/loki/api/v1/label/<labelName>/values request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#loki-query-api
+func ProcessLokiLabelValuesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, labelName string) {
+	ca, err := parseLokiMetadataArgs(r, r.FormValue("query"))
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	qctx := ca.newQueryContext(ctx)
+	defer ca.updatePerQueryStatsMetrics()
+
+	startTime := time.Now()
+	values, err := vlstorage.GetStreamFieldValues(qctx, labelName, 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain stream field values: %s", err)
+		return
+	}
+	writeLokiResponse(w, ca, startTime, getValuesFromHits(values))
+}
+
+// ProcessLokiSeriesRequest handles /select/loki/api/v1/series request.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#loki-query-api
+func ProcessLokiSeriesRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// Populate r.Form
+	_ = r.FormValue("match[]")
+
+	matches := r.Form["match[]"]
+	if len(matches) == 0 {
+		matches = r.Form["match"]
+	}
+	if len(matches) == 0 {
+		httpserver.Errorf(w, r, "missing 'match[]' query arg")
+		return
+	}
+	ca, err := parseLokiMetadataArgs(r, matches...)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	qctx := ca.newQueryContext(ctx)
+	defer ca.updatePerQueryStatsMetrics()
+
+	startTime := time.Now()
+	streams, err := vlstorage.GetStreams(qctx, 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot obtain streams: %s", err)
+		return
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Value < streams[j].Value
+	})
+	series := make([]map[string]string, 0, len(streams))
+	for _, stream := range streams {
+		series = append(series, parseLokiStream(stream.Value))
+	}
+	writeLokiResponse(w, ca, startTime, series)
+}
+
+// parseLokiMetadataArgs parses common args for Loki metadata requests such as /labels, /label/<name>/values and /series.
+//
+// The selected logs are limited by the given LogQL selectors, which are joined with `or`.
+func parseLokiMetadataArgs(r *http.Request, selectors ...string) (*commonArgs, error) {
+	var filters []string
+	for _, selector := range selectors {
+		if selector == "" {
+			continue
+		}
+		lq, err := parseLogQL(selector)
+		if err != nil {
+			return nil, err
+		}
+		if lq.isMetric() || len(lq.pipes) > 0 {
+			return nil, fmt.Errorf("unsupported selector [%s]; it must contain only stream selector and optional line filters", selector)
+		}
+		filters = append(filters, "("+lq.logsql("")+")")
+	}
+	qStr := "*"
+	if len(filters) > 0 {
+		qStr = strings.Join(filters, " or ")
+	}
+
+	// Loki selects the last 6 hours by default for metadata requests.
+	if err := setLokiTimeRange(r, 6*time.Hour); err != nil {
+		return nil, err
+	}
+	r.Form.Set("query", qStr)
+	return parseCommonArgs(r)
+}
+
+// parseLokiQuery parses LogQL query from the `query` query arg.
+func parseLokiQuery(r *http.Request) (*logqlQuery, error) {
+	// r.FormValue populates r.Form, which is then modified before calling parseCommonArgs.
+	qStr := r.FormValue("query")
+	if qStr == "" {
+		return nil, fmt.Errorf("missing 'query' arg")
+	}
+	return parseLogQL(qStr)
+}
+
+// setLokiTimeRange sets the missing `start` query arg to `end` minus defaultRange in the same way as Loki does.
+func setLokiTimeRange(r *http.Request, defaultRange time.Duration) error {
+	if r.FormValue("start") != "" {
+		return nil
+	}
+	end, ok, err := getTimeNsec(r, "end")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		end = time.Now().UnixNano()
+	}
+	r.Form.Set("start", strconv.FormatInt(end-defaultRange.Nanoseconds(), 10))
+	return nil
+}
+
+// getLokiStep returns the `step` query arg in nanoseconds.
+//
+// Loki accepts step either as a duration such as `1m` or as a floating-point number of seconds.
+// If the step is missing, then it is calculated from the selected time range in the same way as Loki does.
+func getLokiStep(r *http.Request) (int64, error) {
+	stepStr := r.FormValue("step")
+	if stepStr == "" {
+		start, _, err := getTimeNsec(r, "start")
+		if err != nil {
+			return 0, err
+		}
+		end, ok, err := getTimeNsec(r, "end")
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			end = time.Now().UnixNano()
+		}
+		step := (end - start) / 250
+		step -= step % 1e9
+		return max(step, 1e9), nil
+	}
+
+	if f, err := strconv.ParseFloat(stepStr, 64); err == nil {
+		if f <= 0 || math.IsNaN(f) || f > math.MaxInt64/1e9 {
+			return 0, fmt.Errorf("'step' must be bigger than zero; got %q", stepStr)
+		}
+		return int64(f * 1e9), nil
+	}
+	step, ok := logstorage.TryParseDuration(stepStr)
+	if !ok {
+		return 0, fmt.Errorf("cannot parse 'step=%s'", stepStr)
+	}
+	if step <= 0 {
+		return 0, fmt.Errorf("'step' must be bigger than zero; got %q", stepStr)
+	}
+	return step, nil
+}
+
+// processLokiSlidingWindowQuery processes Loki metric query lq with the [window] in the range function, which differs from the given step.
+//
+// Every returned point at the timestamp T is calculated over the [T+step-window, T+step) time range in the same way
+// as points for the window equal to the step are calculated over the [T, T+step) time range. The query is executed over
+// gcd(window, step) buckets, which are then summed into the windows for every step. This works, since all the supported
+// range functions are additive.
+func processLokiSlidingWindowQuery(ctx context.Context, w http.ResponseWriter, r *http.Request, lq *logqlQuery, step, window int64) {
+	start, _, err := getTimeNsec(r, "start")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	end, ok, err := getTimeNsec(r, "end")
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+	if !ok {
+		end = time.Now().UnixNano()
+	}
+	if end != math.MinInt64 {
+		// Treat HTTP 'end' query arg as exclusive in the same way as parseCommonArgs does.
+		end--
+	}
+	start, end = alignStartEndToStep(start, end, step, 0)
+
+	bucket := gcdInt64(window, step)
+	queryStart := start - max(window-step, 0)
+	if buckets := (end - queryStart) / bucket; buckets > maxLokiWindowBuckets {
+		httpserver.Errorf(w, r, "too many buckets (%d) must be calculated for the [%s] window with the 'step=%s' on the selected time range; "+
+			"the maximum supported number of buckets is %d; use the window, which is a multiple of the step, or reduce the selected time range",
+			buckets, lq.window, time.Duration(step), maxLokiWindowBuckets)
+		return
+	}
+
+	// Rate is calculated over the whole window, so count logs in the buckets and divide the sum by the window duration.
+	lqBucket := *lq
+	isRate := lq.statsFunc == logqlRangeFuncs["rate"]
+	if isRate {
+		lqBucket.statsFunc = logqlRangeFuncs["count_over_time"]
+	}
+
+	r.Form.Set("start", strconv.FormatInt(queryStart, 10))
+	r.Form.Set("end", strconv.FormatInt(end+1, 10))
+	r.Form.Set("step", fmt.Sprintf("%dns", bucket))
+	r.Form.Set("query", lqBucket.logsql(""))
+
+	ca, err := parseCommonArgs(r)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	startTime := time.Now()
+	rows, err := ca.getStatsSeries(ctx, bucket, 0)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return
+	}
+
+	result := make([]lokiMatrixSeries, 0, len(rows))
+	for _, ss := range rows {
+		values, err := getLokiWindowValues(ss.Points, start, end, step, window, isRate)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return
+		}
+		if len(values) == 0 {
+			continue
+		}
+		result = append(result, lokiMatrixSeries{
+			Metric: getLokiLabels(ss.Labels),
+			Values: values,
+		})
+	}
+	writeLokiResponse(w, ca, startTime, lokiQueryData{
+		ResultType: "matrix",
+		Result:     result,
+	})
+}
+
+// maxLokiWindowBuckets is the maximum number of buckets, which can be calculated per series by processLokiSlidingWindowQuery.
+const maxLokiWindowBuckets = 100_000
+
+// getLokiWindowValues sums bucket points into Loki values at step-aligned timestamps on the [start, end] time range.
+//
+// The value at the timestamp T is the sum of points on the [T+step-window, T+step) time range.
+// The sum is divided by the window duration in seconds if isRate is set.
+func getLokiWindowValues(points []statsPoint, start, end, step, window int64, isRate bool) ([][2]any, error) {
+	sums := make(map[int64]float64)
+	for _, p := range points {
+		v, err := strconv.ParseFloat(p.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse value %q at the timestamp %d: %w", p.Value, p.Timestamp, err)
+		}
+
+		// The point belongs to the windows for timestamps on the (p.Timestamp-step, p.Timestamp+window-step] time range.
+		ts := p.Timestamp - ((p.Timestamp%step)+step)%step
+		for ; ts <= p.Timestamp+window-step; ts += step {
+			if ts >= start && ts <= end {
+				sums[ts] += v
+			}
+		}
+	}
+
+	timestamps := make([]int64, 0, len(sums))
+	for ts := range sums {
+		timestamps = append(timestamps, ts)
+	}
+	slices.Sort(timestamps)
+
+	values := make([][2]any, 0, len(timestamps))
+	for _, ts := range timestamps {
+		v := sums[ts]
+		if isRate {
+			v /= float64(window) / 1e9
+		}
+		values = append(values, [2]any{lokiTimestamp(ts), strconv.FormatFloat(v, 'f', -1, 64)})
+	}
+	return values, nil
+}
+
+func gcdInt64(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// lokiTimestamp converts nsec to Loki timestamp in seconds with millisecond precision.
+func lokiTimestamp(nsec int64) float64 {
+	return math.Round(float64(nsec)/1e6) / 1e3
+}
+
+// getLokiLabels converts stats labels to Loki labels.
+//
+// The _stream label is expanded into the individual stream labels.
+func getLokiLabels(fields []logstorage.Field) map[string]string {
+	labels := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if f.Name == "_stream" {
+			for k, v := range parseLokiStream(f.Value) {
+				labels[k] = v
+			}
+			continue
+		}
+		labels[f.Name] = f.Value
+	}
+	return labels
+}
+
+// parseLokiStream parses _stream value s into Loki labels.
+func parseLokiStream(s string) map[string]string {
+	labels := make(map[string]string)
+	fields, err := logstorage.ParseStreamFields(nil, s)
+	if err != nil {
+		return labels
+	}
+	for _, f := range fields {
+		labels[f.Name] = f.Value
+	}
+	return labels
+}
+
+func getValuesFromHits(vhs []logstorage.ValueWithHits) []string {
+	values := make([]string, 0, len(vhs))
+	for _, vh := range vhs {
+		values = append(values, vh.Value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func writeLokiResponse(w http.ResponseWriter, ca *commonArgs, startTime time.Time, data any) {
+	resp := lokiResponse{
+		Status: "success",
+		Data:   data,
+	}
+	b, err := json.Marshal(&resp)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal Loki response: %s", err)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	ca.writeResponseHeaders(h, startTime)
+
+	_, _ = w.Write(b)
+}
+
+type lokiResponse struct {
+	Status string `json:"status"`
+	Data   any    `json:"data"`
+}
+
+type lokiQueryData struct {
+	ResultType string `json:"resultType"`
+	Result     any    `json:"result"`
+}
+
+type lokiStream struct {
+	key     string
+	entries []lokiEntry
+}
+
+type lokiEntry struct {
+	timestamp int64
+	line      string
+}
+
+type lokiStreamResult struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (sr *lokiStreamResult) key() string {
+	return marshalLokiLabels(sr.Stream)
+}
+
+type lokiMatrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+type lokiVectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+func (vs *lokiVectorSample) key() string {
+	return marshalLokiLabels(vs.Metric)
+}
+
+func marshalLokiLabels(labels map[string]string) string {
+	b, _ := json.Marshal(labels)
+	return string(b)
+}
@@ -0,0 +1,204 @@
+package logsql
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestGetLokiStep(t *testing.T) {
+	f := func(args string, stepExpected int64) {
+		t.Helper()
+
+		r := newTestLokiRequest(t, args)
+		step, err := getLokiStep(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if step != stepExpected {
+			t.Fatalf("unexpected step; got %d; want %d", step, stepExpected)
+		}
+	}
+
+	f("step=60", 60e9)
+	f("step=0.5", 5e8)
+	f("step=5m", 300e9)
+	f("step=1h30m", 5400e9)
+
+	// The step is calculated from the selected time range
+	f("start=1700000000&end=1700003600", 14e9)
+	f("start=1700000000&end=1700000010", 1e9)
+
+	// invalid step
+	for _, args := range []string{"step=0", "step=-1", "step=foo"} {
+		r := newTestLokiRequest(t, args)
+		if _, err := getLokiStep(r); err == nil {
+			t.Fatalf("expecting non-nil error for %q", args)
+		}
+	}
+}
+
+func TestGetLokiWindowValues(t *testing.T) {
+	f := func(points []statsPoint, start, end, step, window int64, isRate bool, valuesExpected [][2]any) {
+		t.Helper()
+
+		values, err := getLokiWindowValues(points, start, end, step, window, isRate)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(values, valuesExpected) {
+			t.Fatalf("unexpected values\ngot\n%v\nwant\n%v", values, valuesExpected)
+		}
+	}
+
+	points := []statsPoint{
+		{Timestamp: 1700000040e9, Value: "1"},
+		{Timestamp: 1700000100e9, Value: "2"},
+		{Timestamp: 1700000160e9, Value: "4"},
+		{Timestamp: 1700000280e9, Value: "8"},
+	}
+
+	// window == step
+	f(points, 1700000040e9, 1700000339e9, 60e9, 60e9, false, [][2]any{
+		{1700000040.0, "1"},
+		{1700000100.0, "2"},
+		{1700000160.0, "4"},
+		{1700000280.0, "8"},
+	})
+
+	// window > step; the point at T covers [T-2m, T+1m)
+	f(points, 1700000040e9, 1700000339e9, 60e9, 180e9, false, [][2]any{
+		{1700000040.0, "1"},
+		{1700000100.0, "3"},
+		{1700000160.0, "7"},
+		{1700000220.0, "6"},
+		{1700000280.0, "12"},
+	})
+
+	// window > step; points outside the selected time range are used only for the windows inside it
+	f(points, 1700000160e9, 1700000219e9, 60e9, 180e9, false, [][2]any{
+		{1700000160.0, "7"},
+	})
+
+	// window < step; the point at T covers [T+1m, T+2m)
+	f(points, 1700000040e9, 1700000339e9, 120e9, 60e9, false, [][2]any{
+		{1700000040.0, "2"},
+	})
+
+	// window isn't a multiple of step
+	f(points, 1700000040e9, 1700000339e9, 120e9, 180e9, false, [][2]any{
+		{1700000040.0, "3"},
+		{1700000160.0, "6"},
+		{1700000280.0, "8"},
+	})
+
+	// rate
+	f(points, 1700000040e9, 1700000339e9, 60e9, 120e9, true, [][2]any{
+		{1700000040.0, "0.008333333333333333"},
+		{1700000100.0, "0.025"},
+		{1700000160.0, "0.05"},
+		{1700000220.0, "0.03333333333333333"},
+		{1700000280.0, "0.06666666666666667"},
+	})
+}
+
+func TestProcessLokiQueryRangeRequestInvalidWindow(t *testing.T) {
+	args := url.Values{
+		"query": {`count_over_time({app="nginx"}[foo])`},
+		"start": {"1700000000"},
+		"end":   {"1700003600"},
+		"step":  {"1m"},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/select/loki/api/v1/query_range?"+args.Encode(), nil)
+	w := httptest.NewRecorder()
+	ProcessLokiQueryRangeRequest(t.Context(), w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code; got %d; want %d; response: %s", w.Code, http.StatusBadRequest, w.Body.String())
+	}
+}
+
+func TestSetLokiTimeRange(t *testing.T) {
+	r := newTestLokiRequest(t, "end=1700003600")
+	if err := setLokiTimeRange(r, 3600e9); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if start := r.FormValue("start"); start != "1700000000000000000" {
+		t.Fatalf("unexpected start; got %q; want %q", start, "1700000000000000000")
+	}
+
+	// The start is left untouched if it is set
+	r = newTestLokiRequest(t, "start=1700000000&end=1700003600")
+	if err := setLokiTimeRange(r, 3600e9); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if start := r.FormValue("start"); start != "1700000000" {
+		t.Fatalf("unexpected start; got %q; want %q", start, "1700000000")
+	}
+}
+
+func TestGetLokiLabels(t *testing.T) {
+	f := func(fields []logstorage.Field, labelsExpected map[string]string) {
+		t.Helper()
+
+		labels := getLokiLabels(fields)
+		if !reflect.DeepEqual(labels, labelsExpected) {
+			t.Fatalf("unexpected labels\ngot\n%v\nwant\n%v", labels, labelsExpected)
+		}
+	}
+
+	f(nil, map[string]string{})
+	f([]logstorage.Field{
+		{
+			Name:  "_stream",
+			Value: `{app="nginx",host="a\"b"}`,
+		},
+	}, map[string]string{
+		"app":  "nginx",
+		"host": `a"b`,
+	})
+	f([]logstorage.Field{
+		{
+			Name:  "level",
+			Value: "error",
+		},
+		{
+			Name:  "host",
+			Value: "",
+		},
+	}, map[string]string{
+		"level": "error",
+		"host":  "",
+	})
+}
+
+func TestLokiTimestamp(t *testing.T) {
+	f := func(nsec int64, resultExpected float64) {
+		t.Helper()
+
+		result := lokiTimestamp(nsec)
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %v; want %v", result, resultExpected)
+		}
+	}
+
+	f(1700000000e9, 1700000000)
+	f(1700000000e9-1, 1700000000)
+	f(1700000000123456789, 1700000000.123)
+}
+
+func newTestLokiRequest(t *testing.T, args string) *http.Request {
+	t.Helper()
+
+	q, err := url.ParseQuery(args)
+	if err != nil {
+		t.Fatalf("cannot parse args: %s", err)
+	}
+	return &http.Request{
+		Form: q,
+	}
+}
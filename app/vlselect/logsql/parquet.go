@@ -0,0 +1,362 @@
+package logsql
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// parquetRowGroupMaxSize is the maximum size of uncompressed values per row group buffered by a single worker.
+const parquetRowGroupMaxSize = 8 * 1024 * 1024
+
+// parquetMagic is written at the start and at the end of Parquet file.
+const parquetMagic = "PAR1"
+
+// The subset of constants from https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	parquetFileMetadataVersion = 1
+
+	parquetTypeByteArray      = 6
+	parquetRepetitionRequired = 0
+	parquetConvertedTypeUTF8  = 0
+	parquetLogicalTypeString  = 1
+
+	parquetEncodingPlain   = 0
+	parquetEncodingRLE     = 3
+	parquetCompressionZSTD = 6
+
+	parquetPageTypeDataPage = 0
+)
+
+// parquetQueryResponseWriter writes the response in Apache Parquet format.
+//
+// All the fields are written as required UTF8 strings. Missing fields are written as empty strings.
+// Every worker buffers rows until parquetRowGroupMaxSize and then writes them as a separate row group.
+//
+// See https://parquet.apache.org/docs/file-format/
+type parquetQueryResponseWriter struct {
+	fields []string
+
+	shards atomicutil.Slice[parquetQueryResponseWriterShard]
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	sw *syncWriter
+
+	// offset is the number of bytes written to sw.
+	offset int64
+
+	// rowGroups contains metadata for the written row groups.
+	rowGroups []*parquetRowGroup
+}
+
+type parquetQueryResponseWriterShard struct {
+	columns [][]string
+
+	// values contains PLAIN-encoded values per every field.
+	values    [][]byte
+	valuesLen int
+
+	rowsCount int
+
+	compressBuf []byte
+}
+
+type parquetRowGroup struct {
+	rowsCount int64
+	totalSize int64
+	columns   []parquetColumnChunk
+}
+
+type parquetColumnChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+func newParquetQueryResponseWriter(fields []string, sw *syncWriter) *parquetQueryResponseWriter {
+	pw := &parquetQueryResponseWriter{
+		fields: fields,
+		sw:     sw,
+	}
+	pw.shards.Init = func(shard *parquetQueryResponseWriterShard) {
+		shard.values = make([][]byte, len(fields))
+	}
+	return pw
+}
+
+func (pw *parquetQueryResponseWriter) contentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+func (pw *parquetQueryResponseWriter) writeHeader() {
+	pw.writeLocked([]byte(parquetMagic))
+}
+
+func (pw *parquetQueryResponseWriter) writeBlock(workerID uint, db *logstorage.DataBlock) {
+	rowsCount := db.RowsCount()
+
+	shard := pw.shards.Get(workerID)
+	shard.columns = getColumnsByFields(shard.columns[:0], db, pw.fields)
+	for i, values := range shard.columns {
+		buf := shard.values[i]
+		for rowIdx := 0; rowIdx < rowsCount; rowIdx++ {
+			v := getColumnValue(values, rowIdx)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+			shard.valuesLen += 4 + len(v)
+		}
+		shard.values[i] = buf
+	}
+	shard.rowsCount += rowsCount
+	clear(shard.columns)
+
+	if shard.valuesLen >= parquetRowGroupMaxSize {
+		pw.writeRowGroup(shard)
+	}
+}
+
+func (pw *parquetQueryResponseWriter) flushRows() {
+	for _, shard := range pw.shards.All() {
+		pw.writeRowGroup(shard)
+	}
+}
+
+func (pw *parquetQueryResponseWriter) writeFooter() {
+	pw.mu.Lock()
+	footer := pw.marshalFileMetadata(nil)
+	pw.mu.Unlock()
+
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	pw.writeLocked(footer)
+}
+
+func (pw *parquetQueryResponseWriter) writeLocked(b []byte) {
+	pw.mu.Lock()
+	_, _ = pw.sw.Write(b)
+	pw.offset += int64(len(b))
+	pw.mu.Unlock()
+}
+
+// writeRowGroup writes rows buffered at shard as a single row group and resets the shard.
+func (pw *parquetQueryResponseWriter) writeRowGroup(shard *parquetQueryResponseWriterShard) {
+	if shard.rowsCount == 0 {
+		return
+	}
+
+	// Prepare column chunks outside the lock. Every column chunk consists of a single data page.
+	var chunks [][]byte
+	var uncompressedSizes []int64
+	for _, values := range shard.values {
+		shard.compressBuf = zstd.CompressLevel(shard.compressBuf[:0], values, 1)
+		compressed := shard.compressBuf
+
+		var tw thriftCompactWriter
+		tw.writeI32Field(1, parquetPageTypeDataPage)
+		tw.writeI32Field(2, int32(len(values)))
+		tw.writeI32Field(3, int32(len(compressed)))
+		tw.writeStructFieldBegin(5)
+		tw.writeI32Field(1, int32(shard.rowsCount))
+		tw.writeI32Field(2, parquetEncodingPlain)
+		tw.writeI32Field(3, parquetEncodingRLE)
+		tw.writeI32Field(4, parquetEncodingRLE)
+		tw.writeStructEnd()
+		tw.writeStructEnd()
+
+		chunks = append(chunks, append(tw.buf, compressed...))
+		uncompressedSizes = append(uncompressedSizes, int64(len(tw.buf)+len(values)))
+	}
+
+	rg := &parquetRowGroup{
+		rowsCount: int64(shard.rowsCount),
+	}
+
+	pw.mu.Lock()
+	for i, chunk := range chunks {
+		rg.columns = append(rg.columns, parquetColumnChunk{
+			offset:           pw.offset,
+			uncompressedSize: uncompressedSizes[i],
+			compressedSize:   int64(len(chunk)),
+		})
+		rg.totalSize += uncompressedSizes[i]
+		_, _ = pw.sw.Write(chunk)
+		pw.offset += int64(len(chunk))
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.mu.Unlock()
+
+	for i := range shard.values {
+		shard.values[i] = shard.values[i][:0]
+	}
+	shard.valuesLen = 0
+	shard.rowsCount = 0
+}
+
+// marshalFileMetadata appends FileMetaData thrift struct to dst and returns the result.
+func (pw *parquetQueryResponseWriter) marshalFileMetadata(dst []byte) []byte {
+	tw := thriftCompactWriter{
+		buf: dst,
+	}
+
+	tw.writeI32Field(1, parquetFileMetadataVersion)
+
+	// schema
+	tw.writeListFieldBegin(2, thriftTypeStruct, 1+len(pw.fields))
+	tw.writeStructBegin()
+	tw.writeBinaryField(4, "schema")
+	tw.writeI32Field(5, int32(len(pw.fields)))
+	tw.writeStructEnd()
+	for _, field := range pw.fields {
+		tw.writeStructBegin()
+		tw.writeI32Field(1, parquetTypeByteArray)
+		tw.writeI32Field(3, parquetRepetitionRequired)
+		tw.writeBinaryField(4, field)
+		tw.writeI32Field(6, parquetConvertedTypeUTF8)
+		tw.writeStructFieldBegin(10)
+		tw.writeStructFieldBegin(parquetLogicalTypeString)
+		tw.writeStructEnd()
+		tw.writeStructEnd()
+		tw.writeStructEnd()
+	}
+
+	rowsCount := int64(0)
+	for _, rg := range pw.rowGroups {
+		rowsCount += rg.rowsCount
+	}
+	tw.writeI64Field(3, rowsCount)
+
+	// row_groups
+	tw.writeListFieldBegin(4, thriftTypeStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		tw.writeStructBegin()
+		tw.writeListFieldBegin(1, thriftTypeStruct, len(rg.columns))
+		for i, cc := range rg.columns {
+			tw.writeStructBegin()
+			tw.writeI64Field(2, cc.offset)
+
+			// meta_data
+			tw.writeStructFieldBegin(3)
+			tw.writeI32Field(1, parquetTypeByteArray)
+			tw.writeListFieldBegin(2, thriftTypeI32, 1)
+			tw.writeVarint(parquetEncodingPlain)
+			tw.writeListFieldBegin(3, thriftTypeBinary, 1)
+			tw.writeBinary(pw.fields[i])
+			tw.writeI32Field(4, parquetCompressionZSTD)
+			tw.writeI64Field(5, rg.rowsCount)
+			tw.writeI64Field(6, cc.uncompressedSize)
+			tw.writeI64Field(7, cc.compressedSize)
+			tw.writeI64Field(9, cc.offset)
+			tw.writeStructEnd()
+
+			tw.writeStructEnd()
+		}
+		tw.writeI64Field(2, rg.totalSize)
+		tw.writeI64Field(3, rg.rowsCount)
+		tw.writeStructEnd()
+	}
+
+	tw.writeBinaryField(6, "VictoriaLogs "+buildinfo.Version)
+	tw.writeStructEnd()
+
+	return tw.buf
+}
+
+// The subset of Thrift compact protocol types.
+//
+// See https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+// thriftCompactWriter marshals Thrift structs with compact protocol.
+//
+// The top-level struct is started implicitly, so it must be finished with writeStructEnd.
+type thriftCompactWriter struct {
+	buf []byte
+
+	// lastFieldID is the id of the last written field at the current struct.
+	lastFieldID int16
+
+	// lastFieldIDs is the stack of lastFieldID values for parent structs.
+	lastFieldIDs []int16
+}
+
+func (tw *thriftCompactWriter) writeFieldHeader(id int16, typ byte) {
+	delta := id - tw.lastFieldID
+	if delta > 0 && delta <= 15 {
+		tw.buf = append(tw.buf, byte(delta)<<4|typ)
+	} else {
+		tw.buf = append(tw.buf, typ)
+		tw.writeVarint(int64(id))
+	}
+	tw.lastFieldID = id
+}
+
+func (tw *thriftCompactWriter) writeI32Field(id int16, v int32) {
+	tw.writeFieldHeader(id, thriftTypeI32)
+	tw.writeVarint(int64(v))
+}
+
+func (tw *thriftCompactWriter) writeI64Field(id int16, v int64) {
+	tw.writeFieldHeader(id, thriftTypeI64)
+	tw.writeVarint(v)
+}
+
+func (tw *thriftCompactWriter) writeBinaryField(id int16, s string) {
+	tw.writeFieldHeader(id, thriftTypeBinary)
+	tw.writeBinary(s)
+}
+
+// writeListFieldBegin writes the header for list field with n items of the given typ.
+//
+// The header must be followed by n items.
+func (tw *thriftCompactWriter) writeListFieldBegin(id int16, typ byte, n int) {
+	tw.writeFieldHeader(id, thriftTypeList)
+	if n < 15 {
+		tw.buf = append(tw.buf, byte(n)<<4|typ)
+	} else {
+		tw.buf = append(tw.buf, 0xf0|typ)
+		tw.buf = binary.AppendUvarint(tw.buf, uint64(n))
+	}
+}
+
+// writeStructFieldBegin starts struct field. It must be finished with writeStructEnd.
+func (tw *thriftCompactWriter) writeStructFieldBegin(id int16) {
+	tw.writeFieldHeader(id, thriftTypeStruct)
+	tw.writeStructBegin()
+}
+
+// writeStructBegin starts struct list item. It must be finished with writeStructEnd.
+func (tw *thriftCompactWriter) writeStructBegin() {
+	tw.lastFieldIDs = append(tw.lastFieldIDs, tw.lastFieldID)
+	tw.lastFieldID = 0
+}
+
+func (tw *thriftCompactWriter) writeStructEnd() {
+	tw.buf = append(tw.buf, 0)
+	if n := len(tw.lastFieldIDs); n > 0 {
+		tw.lastFieldID = tw.lastFieldIDs[n-1]
+		tw.lastFieldIDs = tw.lastFieldIDs[:n-1]
+	}
+}
+
+// writeVarint writes zigzag-encoded v.
+func (tw *thriftCompactWriter) writeVarint(v int64) {
+	tw.buf = binary.AppendVarint(tw.buf, v)
+}
+
+func (tw *thriftCompactWriter) writeBinary(s string) {
+	tw.buf = binary.AppendUvarint(tw.buf, uint64(len(s)))
+	tw.buf = append(tw.buf, s...)
+}
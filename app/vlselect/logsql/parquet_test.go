@@ -0,0 +1,166 @@
+package logsql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding/zstd"
+)
+
+func TestQueryResponseWriterParquet(t *testing.T) {
+	f := func(fields []string, rowsExpected [][]string) {
+		t.Helper()
+
+		data := newTestQueryResponse(t, "parquet", fields, getTestDataBlocks()...)
+		columnNames, rows, err := readTestParquetFile(data)
+		if err != nil {
+			t.Fatalf("cannot read parquet file: %s", err)
+		}
+		if !reflect.DeepEqual(columnNames, fields) {
+			t.Fatalf("unexpected columns\ngot\n%q\nwant\n%q", columnNames, fields)
+		}
+		if !reflect.DeepEqual(rows, rowsExpected) {
+			t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", rows, rowsExpected)
+		}
+	}
+
+	f([]string{"level", "_msg", "missing"}, [][]string{
+		{"info", "foo", ""},
+		{"error", "a \"quoted\", multiline\nmessage", ""},
+		{"", "tab\there", ""},
+	})
+
+	// Empty response
+	data := newTestQueryResponse(t, "parquet", []string{"_msg"})
+	columnNames, rows, err := readTestParquetFile(data)
+	if err != nil {
+		t.Fatalf("cannot read parquet file: %s", err)
+	}
+	if !reflect.DeepEqual(columnNames, []string{"_msg"}) {
+		t.Fatalf("unexpected columns: %q", columnNames)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("unexpected rows: %q", rows)
+	}
+}
+
+// readTestParquetFile reads column names and rows from parquet file data generated by parquetQueryResponseWriter.
+func readTestParquetFile(data []byte) ([]string, [][]string, error) {
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		return nil, nil, fmt.Errorf("missing %q magic", parquetMagic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	fileMetadata, _, err := readTestThriftStruct(footer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read file metadata: %w", err)
+	}
+
+	var columnNames []string
+	for _, se := range fileMetadata[2].([]any)[1:] {
+		columnNames = append(columnNames, se.(map[int16]any)[4].(string))
+	}
+
+	var rows [][]string
+	for _, rg := range fileMetadata[4].([]any) {
+		rg := rg.(map[int16]any)
+		rowsCount := int(rg[3].(int64))
+		rgRows := make([][]string, rowsCount)
+		for _, cc := range rg[1].([]any) {
+			md := cc.(map[int16]any)[3].(map[int16]any)
+			if codec := md[4].(int64); codec != parquetCompressionZSTD {
+				return nil, nil, fmt.Errorf("unexpected codec: %d", codec)
+			}
+			offset := md[9].(int64)
+			pageHeader, n, err := readTestThriftStruct(data[offset:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot read page header: %w", err)
+			}
+			compressedSize := pageHeader[3].(int64)
+			if n+int(compressedSize) != int(md[7].(int64)) {
+				return nil, nil, fmt.Errorf("unexpected total_compressed_size")
+			}
+			pageData := data[int(offset)+n : int(offset)+n+int(compressedSize)]
+			values, err := zstd.Decompress(nil, pageData)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot decompress page: %w", err)
+			}
+			for i := range rgRows {
+				valueLen := binary.LittleEndian.Uint32(values)
+				rgRows[i] = append(rgRows[i], string(values[4:4+valueLen]))
+				values = values[4+valueLen:]
+			}
+		}
+		rows = append(rows, rgRows...)
+	}
+	return columnNames, rows, nil
+}
+
+// readTestThriftStruct reads Thrift struct encoded with compact protocol from src.
+//
+// It returns struct fields by their ids and the number of bytes read.
+func readTestThriftStruct(src []byte) (map[int16]any, int, error) {
+	m := make(map[int16]any)
+	n := 0
+	lastFieldID := int16(0)
+	for {
+		if n >= len(src) {
+			return nil, 0, fmt.Errorf("unexpected end of struct")
+		}
+		b := src[n]
+		n++
+		if b == 0 {
+			return m, n, nil
+		}
+		typ := b & 0x0f
+		if delta := b >> 4; delta != 0 {
+			lastFieldID += int16(delta)
+		} else {
+			id, k := binary.Varint(src[n:])
+			n += k
+			lastFieldID = int16(id)
+		}
+		v, k, err := readTestThriftValue(src[n:], typ)
+		if err != nil {
+			return nil, 0, err
+		}
+		n += k
+		m[lastFieldID] = v
+	}
+}
+
+func readTestThriftValue(src []byte, typ byte) (any, int, error) {
+	switch typ {
+	case thriftTypeI32, thriftTypeI64:
+		v, n := binary.Varint(src)
+		return v, n, nil
+	case thriftTypeBinary:
+		size, n := binary.Uvarint(src)
+		return string(src[n : n+int(size)]), n + int(size), nil
+	case thriftTypeList:
+		size := int(src[0] >> 4)
+		elemType := src[0] & 0x0f
+		n := 1
+		if size == 15 {
+			sizeU, k := binary.Uvarint(src[1:])
+			size = int(sizeU)
+			n += k
+		}
+		a := make([]any, 0, size)
+		for range size {
+			v, k, err := readTestThriftValue(src[n:], elemType)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += k
+			a = append(a, v)
+		}
+		return a, n, nil
+	case thriftTypeStruct:
+		return readTestThriftStruct(src)
+	default:
+		return nil, 0, fmt.Errorf("unsupported type: %d", typ)
+	}
+}
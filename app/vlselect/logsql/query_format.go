@@ -0,0 +1,263 @@
+package logsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+// queryResponseWriter writes /select/logsql/query response in the format specified via `format` query arg.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#querying-logs
+type queryResponseWriter interface {
+	// contentType returns Content-Type for the response.
+	contentType() string
+
+	// writeHeader writes the response header. It is called once before writeBlock calls.
+	writeHeader()
+
+	// writeBlock writes rows from db to the response.
+	//
+	// It may be called concurrently by multiple workers identified by workerID.
+	writeBlock(workerID uint, db *logstorage.DataBlock)
+
+	// flushRows writes the buffered rows to the response.
+	//
+	// It must be called on all the return paths, so the buffered rows aren't lost on errors.
+	flushRows()
+
+	// writeFooter writes the response footer. It is called once after the successful flushRows call.
+	writeFooter()
+}
+
+// newQueryResponseWriter returns queryResponseWriter for the given format.
+//
+// fields contains the list of columns to write. It must be non-empty for all the formats except of json.
+func newQueryResponseWriter(format string, fields []string, sw *syncWriter) (queryResponseWriter, error) {
+	if format == "" || format == "json" {
+		if len(fields) > 0 {
+			return nil, fmt.Errorf("'fields' query arg cannot be used with format=json; use 'fields' pipe instead; " +
+				"see https://docs.victoriametrics.com/victorialogs/logsql/#fields-pipe")
+		}
+		return newJSONQueryResponseWriter(sw), nil
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing 'fields' query arg; it must contain the list of fields to return in format=%s", format)
+	}
+	for i, field := range fields {
+		if field == "" {
+			return nil, fmt.Errorf("'fields' query arg cannot contain empty field names")
+		}
+		for _, prevField := range fields[:i] {
+			if field == prevField {
+				return nil, fmt.Errorf("'fields' query arg cannot contain duplicate field %q", field)
+			}
+		}
+	}
+
+	switch format {
+	case "csv":
+		return newCSVQueryResponseWriter(fields, sw, ','), nil
+	case "tsv":
+		return newCSVQueryResponseWriter(fields, sw, '\t'), nil
+	case "parquet":
+		return newParquetQueryResponseWriter(fields, sw), nil
+	case "arrow":
+		return newArrowQueryResponseWriter(fields, sw), nil
+	default:
+		return nil, fmt.Errorf("unsupported format=%q; supported values: json, csv, tsv, parquet, arrow", format)
+	}
+}
+
+// getColumnsByFields returns columns from db in the order of the given fields.
+//
+// Missing columns are returned as nil Values, which must be treated as empty values.
+func getColumnsByFields(dst [][]string, db *logstorage.DataBlock, fields []string) [][]string {
+	for _, field := range fields {
+		var values []string
+		for _, c := range db.Columns {
+			if c.Name == field {
+				values = c.Values
+				break
+			}
+		}
+		dst = append(dst, values)
+	}
+	return dst
+}
+
+// getColumnValue returns the value at rowIdx for the column obtained via getColumnsByFields.
+func getColumnValue(values []string, rowIdx int) string {
+	if values == nil {
+		return ""
+	}
+	return values[rowIdx]
+}
+
+type jsonQueryResponseWriter struct {
+	bwShards atomicutil.Slice[bufferedWriter]
+}
+
+func newJSONQueryResponseWriter(sw *syncWriter) *jsonQueryResponseWriter {
+	jw := &jsonQueryResponseWriter{}
+	jw.bwShards.Init = func(shard *bufferedWriter) {
+		shard.sw = sw
+	}
+	return jw
+}
+
+func (jw *jsonQueryResponseWriter) contentType() string {
+	return "application/stream+json"
+}
+
+func (jw *jsonQueryResponseWriter) writeHeader() {
+	// Nothing to do - JSON lines do not have a header.
+}
+
+func (jw *jsonQueryResponseWriter) writeBlock(workerID uint, db *logstorage.DataBlock) {
+	rowsCount := db.RowsCount()
+	columns := db.Columns
+
+	bw := jw.bwShards.Get(workerID)
+	for i := 0; i < rowsCount; i++ {
+		WriteJSONRow(bw, columns, i)
+		if len(bw.buf) > 16*1024 {
+			bw.FlushIgnoreErrors()
+		}
+	}
+}
+
+func (jw *jsonQueryResponseWriter) flushRows() {
+	shards := jw.bwShards.All()
+	for _, shard := range shards {
+		shard.FlushIgnoreErrors()
+	}
+}
+
+func (jw *jsonQueryResponseWriter) writeFooter() {
+	// Nothing to do - JSON lines do not have a footer.
+}
+
+// csvQueryResponseWriter writes the response in CSV or TSV format.
+//
+// CSV values are quoted according to RFC 4180. TSV values have escaped tabs, newlines and backslashes.
+type csvQueryResponseWriter struct {
+	fields    []string
+	sw        *syncWriter
+	separator byte
+
+	shards atomicutil.Slice[csvQueryResponseWriterShard]
+}
+
+type csvQueryResponseWriterShard struct {
+	bw      bufferedWriter
+	columns [][]string
+}
+
+func newCSVQueryResponseWriter(fields []string, sw *syncWriter, separator byte) *csvQueryResponseWriter {
+	cw := &csvQueryResponseWriter{
+		fields:    fields,
+		sw:        sw,
+		separator: separator,
+	}
+	cw.shards.Init = func(shard *csvQueryResponseWriterShard) {
+		shard.bw.sw = sw
+	}
+	return cw
+}
+
+func (cw *csvQueryResponseWriter) contentType() string {
+	if cw.separator == '\t' {
+		return "text/tab-separated-values; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+func (cw *csvQueryResponseWriter) writeHeader() {
+	var b []byte
+	for i, field := range cw.fields {
+		if i > 0 {
+			b = append(b, cw.separator)
+		}
+		b = cw.appendValue(b, field)
+	}
+	b = append(b, '\n')
+	_, _ = cw.sw.Write(b)
+}
+
+func (cw *csvQueryResponseWriter) writeBlock(workerID uint, db *logstorage.DataBlock) {
+	rowsCount := db.RowsCount()
+
+	shard := cw.shards.Get(workerID)
+	shard.columns = getColumnsByFields(shard.columns[:0], db, cw.fields)
+
+	bw := &shard.bw
+	for rowIdx := 0; rowIdx < rowsCount; rowIdx++ {
+		for i, values := range shard.columns {
+			if i > 0 {
+				bw.buf = append(bw.buf, cw.separator)
+			}
+			bw.buf = cw.appendValue(bw.buf, getColumnValue(values, rowIdx))
+		}
+		bw.buf = append(bw.buf, '\n')
+		if len(bw.buf) > 16*1024 {
+			bw.FlushIgnoreErrors()
+		}
+	}
+
+	clear(shard.columns)
+}
+
+func (cw *csvQueryResponseWriter) flushRows() {
+	shards := cw.shards.All()
+	for _, shard := range shards {
+		shard.bw.FlushIgnoreErrors()
+	}
+}
+
+func (cw *csvQueryResponseWriter) writeFooter() {
+	// Nothing to do - CSV and TSV do not have a footer.
+}
+
+func (cw *csvQueryResponseWriter) appendValue(dst []byte, v string) []byte {
+	if cw.separator == '\t' {
+		return appendTSVValue(dst, v)
+	}
+	return appendCSVValue(dst, v)
+}
+
+func appendCSVValue(dst []byte, v string) []byte {
+	if !strings.ContainsAny(v, ",\"\r\n") {
+		return append(dst, v...)
+	}
+	dst = append(dst, '"')
+	for i := 0; i < len(v); i++ {
+		if v[i] == '"' {
+			dst = append(dst, '"')
+		}
+		dst = append(dst, v[i])
+	}
+	return append(dst, '"')
+}
+
+func appendTSVValue(dst []byte, v string) []byte {
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '\t':
+			dst = append(dst, `\t`...)
+		case '\n':
+			dst = append(dst, `\n`...)
+		case '\r':
+			dst = append(dst, `\r`...)
+		case '\\':
+			dst = append(dst, `\\`...)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
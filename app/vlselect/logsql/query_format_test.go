@@ -0,0 +1,119 @@
+package logsql
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func newTestDataBlock(columns ...logstorage.BlockColumn) *logstorage.DataBlock {
+	return &logstorage.DataBlock{
+		Columns: columns,
+	}
+}
+
+func newTestQueryResponse(t *testing.T, format string, fields []string, dbs ...*logstorage.DataBlock) []byte {
+	t.Helper()
+
+	var bb bytes.Buffer
+	sw := &syncWriter{
+		w: &bb,
+	}
+	qw, err := newQueryResponseWriter(format, fields, sw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	qw.writeHeader()
+	for i, db := range dbs {
+		// Spread blocks among workers in order to verify merging of per-worker data.
+		qw.writeBlock(uint(i%2), db)
+	}
+	qw.flushRows()
+	qw.writeFooter()
+
+	return bb.Bytes()
+}
+
+func getTestDataBlocks() []*logstorage.DataBlock {
+	return []*logstorage.DataBlock{
+		newTestDataBlock(
+			logstorage.BlockColumn{
+				Name:   "_msg",
+				Values: []string{"foo", `a "quoted", multiline` + "\nmessage"},
+			},
+			logstorage.BlockColumn{
+				Name:   "level",
+				Values: []string{"info", "error"},
+			},
+		),
+		newTestDataBlock(
+			logstorage.BlockColumn{
+				Name:   "_msg",
+				Values: []string{"tab\there"},
+			},
+		),
+	}
+}
+
+func TestNewQueryResponseWriterFailure(t *testing.T) {
+	f := func(format string, fields []string) {
+		t.Helper()
+
+		sw := &syncWriter{}
+		if _, err := newQueryResponseWriter(format, fields, sw); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// unsupported format
+	f("xml", []string{"_msg"})
+
+	// missing fields
+	f("csv", nil)
+	f("parquet", nil)
+
+	// empty field
+	f("tsv", []string{"_msg", ""})
+
+	// duplicate fields
+	f("arrow", []string{"_msg", "level", "_msg"})
+
+	// fields for json format
+	f("json", []string{"_msg"})
+}
+
+func TestQueryResponseWriterJSON(t *testing.T) {
+	data := newTestQueryResponse(t, "", nil, getTestDataBlocks()...)
+	resultExpected := `{"_msg":"foo","level":"info"}
+{"_msg":"a \"quoted\", multiline\nmessage","level":"error"}
+{"_msg":"tab\there"}
+`
+	if string(data) != resultExpected {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", data, resultExpected)
+	}
+}
+
+func TestQueryResponseWriterCSV(t *testing.T) {
+	data := newTestQueryResponse(t, "csv", []string{"level", "_msg", "missing,field"}, getTestDataBlocks()...)
+	resultExpected := `level,_msg,"missing,field"
+info,foo,
+error,"a ""quoted"", multiline
+message",
+,tab	here,
+`
+	if string(data) != resultExpected {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", data, resultExpected)
+	}
+}
+
+func TestQueryResponseWriterTSV(t *testing.T) {
+	data := newTestQueryResponse(t, "tsv", []string{"level", "_msg"}, getTestDataBlocks()...)
+	resultExpected := "level\t_msg\n" +
+		"info\tfoo\n" +
+		"error\ta \"quoted\", multiline\\nmessage\n" +
+		"\ttab\\there\n"
+	if string(data) != resultExpected {
+		t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", data, resultExpected)
+	}
+}
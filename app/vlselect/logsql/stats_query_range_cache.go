@@ -0,0 +1,361 @@
+package logsql
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var (
+	enableCache = flag.Bool("search.enableCache", false, "Whether to enable response caching for /select/logsql/stats_query_range. "+
+		"The cache may return stale results after the already stored logs are deleted by retention filters or moved by partitions rebalancing, "+
+		"and after ingesting logs with timestamps in the past. See https://docs.victoriametrics.com/victorialogs/querying/#caching")
+	cacheTimestampOffset = flag.Duration("search.cacheTimestampOffset", 5*time.Minute, "The maximum duration since the current time for response data, "+
+		"which is always queried from the storage and is never cached in the response cache for /select/logsql/stats_query_range. "+
+		"This allows ingesting logs with slightly delayed timestamps without the need to reset the cache. "+
+		"See https://docs.victoriametrics.com/victorialogs/querying/#caching")
+)
+
+// ResetStatsQueryRangeCache resets the response cache for /select/logsql/stats_query_range.
+//
+// It must be called when the already stored logs are modified, e.g. after the logs deletion.
+func ResetStatsQueryRangeCache() {
+	getStatsQueryRangeCache().reset()
+}
+
+var getStatsQueryRangeCache = sync.OnceValue(func() *statsQueryRangeCache {
+	return newStatsQueryRangeCache(memory.Allowed() / 20)
+})
+
+var (
+	statsQueryRangeCacheFullHits    = metrics.NewCounter(`vl_stats_query_range_cache_full_hits_total`)
+	statsQueryRangeCachePartialHits = metrics.NewCounter(`vl_stats_query_range_cache_partial_hits_total`)
+	statsQueryRangeCacheMisses      = metrics.NewCounter(`vl_stats_query_range_cache_misses_total`)
+
+	_ = metrics.NewGauge(`vl_stats_query_range_cache_entries`, func() float64 {
+		entries, _ := getStatsQueryRangeCache().stats()
+		return float64(entries)
+	})
+	_ = metrics.NewGauge(`vl_stats_query_range_cache_size_bytes`, func() float64 {
+		_, sizeBytes := getStatsQueryRangeCache().stats()
+		return float64(sizeBytes)
+	})
+)
+
+// getStatsSeriesCached works the same as getStatsSeries, but uses the response cache for the time buckets older than -search.cacheTimestampOffset.
+//
+// qStr must contain the original query string passed to the request.
+func (ca *commonArgs) getStatsSeriesCached(ctx context.Context, qStr string, step, offset int64) ([]*statsSeries, error) {
+	if !ca.canUseStatsQueryRangeCache() {
+		return ca.getStatsSeries(ctx, step, offset)
+	}
+
+	// cacheEnd is the end of the time range, which can be stored in the cache. It is aligned to step.
+	deadline := time.Now().UnixNano() - cacheTimestampOffset.Nanoseconds()
+	deadlineAligned, _ := alignStartEndToStep(deadline, deadline, step, offset)
+	cacheEnd := min(ca.endAligned+1, deadlineAligned)
+	if cacheEnd <= ca.startAligned {
+		// The whole selected time range is too fresh for caching.
+		return ca.getStatsSeries(ctx, step, offset)
+	}
+
+	key, err := ca.getStatsQueryRangeCacheKey(qStr, step, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	c := getStatsQueryRangeCache()
+
+	// Obtain the cached series for the beginning of the selected time range.
+	freshStart := ca.startAligned
+	var seriesCached []*statsSeries
+	e := c.get(key)
+	if e != nil && e.start <= ca.startAligned && e.end > ca.startAligned {
+		freshStart = min(e.end, ca.endAligned+1)
+		seriesCached = filterStatsSeriesByTimeRange(e.series, ca.startAligned, freshStart)
+	}
+
+	// Obtain the remaining series from the storage.
+	var seriesFresh []*statsSeries
+	if freshStart <= ca.endAligned {
+		caFresh := ca
+		if freshStart > ca.startAligned {
+			statsQueryRangeCachePartialHits.Inc()
+
+			caFresh = ca.withTimeRange(freshStart, ca.endAligned)
+		} else {
+			statsQueryRangeCacheMisses.Inc()
+		}
+		seriesFresh, err = caFresh.getStatsSeries(ctx, step, offset)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		statsQueryRangeCacheFullHits.Inc()
+	}
+
+	series := mergeStatsSeries(seriesCached, seriesFresh)
+
+	if e == nil || e.start != ca.startAligned || e.end < cacheEnd {
+		eNew := &statsQueryRangeCacheEntry{
+			start:  ca.startAligned,
+			end:    cacheEnd,
+			series: filterStatsSeriesByTimeRange(series, ca.startAligned, cacheEnd),
+		}
+		c.set(key, eNew)
+	}
+
+	return series, nil
+}
+
+func (ca *commonArgs) canUseStatsQueryRangeCache() bool {
+	if !*enableCache {
+		return false
+	}
+	if ca.startAligned == math.MinInt64 || ca.endAligned == math.MaxInt64 {
+		// The response cache works only for the explicitly set time range.
+		return false
+	}
+	if ca.allowPartialResponse {
+		// Partial responses mustn't be cached.
+		return false
+	}
+	return ca.q.CanCacheStatsRangeResults()
+}
+
+// withTimeRange returns a copy of ca with the query limited to the given [start, end] time range.
+func (ca *commonArgs) withTimeRange(start, end int64) *commonArgs {
+	caCopy := &commonArgs{
+		q:         ca.q.CloneWithTimeFilter(ca.q.GetTimestamp(), start, end),
+		tenantIDs: ca.tenantIDs,
+
+		allowPartialResponse: ca.allowPartialResponse,
+		hiddenFieldsFilters:  ca.hiddenFieldsFilters,
+		extraFilters:         ca.extraFilters,
+
+		startAligned: start,
+		endAligned:   end,
+	}
+	return caCopy
+}
+
+// getStatsQueryRangeCacheKey returns the cache key for the given qStr, step and offset.
+//
+// The key doesn't depend on the selected time range, so the cached entry can be re-used for distinct time ranges.
+func (ca *commonArgs) getStatsQueryRangeCacheKey(qStr string, step, offset int64) (string, error) {
+	q, err := logstorage.ParseQueryAtTimestamp(qStr, ca.q.GetTimestamp())
+	if err != nil {
+		return "", fmt.Errorf("cannot parse query [%s]: %s", qStr, err)
+	}
+	for _, f := range ca.extraFilters {
+		q.AddExtraFilters(f)
+	}
+
+	var b []byte
+	b = encoding.MarshalVarUint64(b, uint64(len(ca.tenantIDs)))
+	for _, tenantID := range ca.tenantIDs {
+		b = encoding.MarshalUint32(b, tenantID.AccountID)
+		b = encoding.MarshalUint32(b, tenantID.ProjectID)
+	}
+	b = encoding.MarshalVarUint64(b, uint64(len(ca.hiddenFieldsFilters)))
+	for _, filter := range ca.hiddenFieldsFilters {
+		b = encoding.MarshalBytes(b, []byte(filter))
+	}
+	b = encoding.MarshalInt64(b, step)
+	b = encoding.MarshalInt64(b, offset)
+	b = append(b, q.String()...)
+	return string(b), nil
+}
+
+// filterStatsSeriesByTimeRange returns series with points on the time range [start, end).
+//
+// The returned series do not share points with the original series.
+func filterStatsSeriesByTimeRange(series []*statsSeries, start, end int64) []*statsSeries {
+	result := make([]*statsSeries, 0, len(series))
+	for _, ss := range series {
+		var points []statsPoint
+		for _, p := range ss.Points {
+			if p.Timestamp >= start && p.Timestamp < end {
+				points = append(points, p)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		result = append(result, &statsSeries{
+			key:    ss.key,
+			Name:   ss.Name,
+			Labels: ss.Labels,
+			Points: points,
+		})
+	}
+	return result
+}
+
+// mergeStatsSeries merges a and b series with non-overlapping points.
+//
+// The returned series are sorted by series key, while points in every series are sorted by timestamp.
+func mergeStatsSeries(a, b []*statsSeries) []*statsSeries {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+
+	m := make(map[string]*statsSeries, len(a)+len(b))
+	for _, ss := range a {
+		m[ss.key] = ss
+	}
+	for _, ss := range b {
+		ssPrev := m[ss.key]
+		if ssPrev == nil {
+			m[ss.key] = ss
+			continue
+		}
+		points := make([]statsPoint, 0, len(ssPrev.Points)+len(ss.Points))
+		points = append(points, ssPrev.Points...)
+		points = append(points, ss.Points...)
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Timestamp < points[j].Timestamp
+		})
+		m[ss.key] = &statsSeries{
+			key:    ss.key,
+			Name:   ss.Name,
+			Labels: ss.Labels,
+			Points: points,
+		}
+	}
+
+	result := make([]*statsSeries, 0, len(m))
+	for _, ss := range m {
+		result = append(result, ss)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+// statsQueryRangeCache caches /select/logsql/stats_query_range responses.
+//
+// The cache consists of two generations. New entries are put into the current generation.
+// The current generation becomes the previous generation when its size exceeds the half of maxSizeBytes,
+// while the previous generation is dropped. Entries read from the previous generation are moved to the current generation.
+type statsQueryRangeCache struct {
+	maxSizeBytes int
+
+	mu sync.Mutex
+
+	curr          map[string]*statsQueryRangeCacheEntry
+	currSizeBytes int
+
+	prev          map[string]*statsQueryRangeCacheEntry
+	prevSizeBytes int
+}
+
+// statsQueryRangeCacheEntry contains the cached series for the [start, end) time range.
+//
+// Both start and end are aligned to the step of the cached query.
+// The entry mustn't be modified after it is put into the cache, since it may be accessed concurrently.
+type statsQueryRangeCacheEntry struct {
+	start  int64
+	end    int64
+	series []*statsSeries
+
+	// sizeBytes is the approximate size of the entry together with its key. It is initialized at statsQueryRangeCache.set.
+	sizeBytes int
+}
+
+func (e *statsQueryRangeCacheEntry) calcSizeBytes() int {
+	n := 64
+	for _, ss := range e.series {
+		n += 64 + len(ss.key) + len(ss.Name)
+		for _, label := range ss.Labels {
+			n += 32 + len(label.Name) + len(label.Value)
+		}
+		for _, p := range ss.Points {
+			n += 24 + len(p.Value)
+		}
+	}
+	return n
+}
+
+func newStatsQueryRangeCache(maxSizeBytes int) *statsQueryRangeCache {
+	return &statsQueryRangeCache{
+		maxSizeBytes: maxSizeBytes,
+		curr:         make(map[string]*statsQueryRangeCacheEntry),
+		prev:         make(map[string]*statsQueryRangeCacheEntry),
+	}
+}
+
+func (c *statsQueryRangeCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.curr = make(map[string]*statsQueryRangeCacheEntry)
+	c.currSizeBytes = 0
+	c.prev = make(map[string]*statsQueryRangeCacheEntry)
+	c.prevSizeBytes = 0
+}
+
+func (c *statsQueryRangeCache) stats() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.curr) + len(c.prev), c.currSizeBytes + c.prevSizeBytes
+}
+
+func (c *statsQueryRangeCache) get(key string) *statsQueryRangeCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.curr[key]; e != nil {
+		return e
+	}
+	e := c.prev[key]
+	if e != nil {
+		// Move the entry to the current generation, so it survives the next rotation.
+		delete(c.prev, key)
+		c.prevSizeBytes -= e.sizeBytes
+		c.setLocked(key, e)
+	}
+	return e
+}
+
+func (c *statsQueryRangeCache) set(key string, e *statsQueryRangeCacheEntry) {
+	e.sizeBytes = len(key) + e.calcSizeBytes()
+	if e.sizeBytes > c.maxSizeBytes/2 {
+		// The entry is too big for caching.
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(key, e)
+}
+
+func (c *statsQueryRangeCache) setLocked(key string, e *statsQueryRangeCacheEntry) {
+	if ePrev := c.curr[key]; ePrev != nil {
+		c.currSizeBytes -= ePrev.sizeBytes
+	}
+	if c.currSizeBytes+e.sizeBytes > c.maxSizeBytes/2 {
+		c.prev = c.curr
+		c.prevSizeBytes = c.currSizeBytes
+		c.curr = make(map[string]*statsQueryRangeCacheEntry)
+		c.currSizeBytes = 0
+	}
+	c.curr[key] = e
+	c.currSizeBytes += e.sizeBytes
+}
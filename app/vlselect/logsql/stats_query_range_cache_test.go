@@ -0,0 +1,158 @@
+package logsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestMergeStatsSeries(t *testing.T) {
+	f := func(a, b, resultExpected []*statsSeries) {
+		t.Helper()
+
+		result := mergeStatsSeries(a, b)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", statsSeriesToString(result), statsSeriesToString(resultExpected))
+		}
+	}
+
+	f(nil, nil, nil)
+
+	a := []*statsSeries{
+		newTestStatsSeries("hits", "h1", 10, 20),
+		newTestStatsSeries("hits", "h2", 10),
+	}
+	f(a, nil, a)
+	f(nil, a, a)
+
+	b := []*statsSeries{
+		newTestStatsSeries("hits", "h0", 30),
+		newTestStatsSeries("hits", "h1", 40, 30),
+	}
+	f(a, b, []*statsSeries{
+		newTestStatsSeries("hits", "h0", 30),
+		newTestStatsSeries("hits", "h1", 10, 20, 30, 40),
+		newTestStatsSeries("hits", "h2", 10),
+	})
+}
+
+func TestFilterStatsSeriesByTimeRange(t *testing.T) {
+	f := func(series []*statsSeries, start, end int64, resultExpected []*statsSeries) {
+		t.Helper()
+
+		result := filterStatsSeriesByTimeRange(series, start, end)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", statsSeriesToString(result), statsSeriesToString(resultExpected))
+		}
+	}
+
+	series := []*statsSeries{
+		newTestStatsSeries("hits", "h1", 10, 20, 30),
+		newTestStatsSeries("hits", "h2", 30, 40),
+	}
+	f(series, 0, 100, series)
+	f(series, 10, 30, []*statsSeries{
+		newTestStatsSeries("hits", "h1", 10, 20),
+	})
+	f(series, 30, 31, []*statsSeries{
+		newTestStatsSeries("hits", "h1", 30),
+		newTestStatsSeries("hits", "h2", 30),
+	})
+	f(series, 50, 100, []*statsSeries{})
+}
+
+func TestStatsQueryRangeCache(t *testing.T) {
+	e := &statsQueryRangeCacheEntry{
+		start: 10,
+		end:   30,
+		series: []*statsSeries{
+			newTestStatsSeries("hits", "h1", 10, 20),
+		},
+	}
+	entrySize := len("key1") + e.calcSizeBytes()
+
+	// The cache fits two entries per generation
+	c := newStatsQueryRangeCache(4*entrySize + 1)
+
+	if eResult := c.get("key1"); eResult != nil {
+		t.Fatalf("unexpected entry found in empty cache")
+	}
+
+	c.set("key1", e)
+	if eResult := c.get("key1"); eResult != e {
+		t.Fatalf("missing entry for key1")
+	}
+
+	// Override the entry
+	e2 := *e
+	e2.end = 40
+	c.set("key1", &e2)
+	if eResult := c.get("key1"); eResult != &e2 {
+		t.Fatalf("the entry for key1 hasn't been updated")
+	}
+	if entries, _ := c.stats(); entries != 1 {
+		t.Fatalf("unexpected number of entries; got %d; want 1", entries)
+	}
+
+	// Fill the cache, so key1 moves to the previous generation and then is moved back to the current generation on access.
+	e3 := *e
+	c.set("key2", &e3)
+	e4 := *e
+	c.set("key3", &e4)
+	if eResult := c.get("key1"); eResult != &e2 {
+		t.Fatalf("missing entry for key1")
+	}
+	if eResult := c.get("key2"); eResult != &e3 {
+		t.Fatalf("missing entry for key2")
+	}
+	if entries, sizeBytes := c.stats(); entries != 3 || sizeBytes != 3*entrySize {
+		t.Fatalf("unexpected cache stats; got entries=%d, sizeBytes=%d; want entries=3, sizeBytes=%d", entries, sizeBytes, 3*entrySize)
+	}
+
+	c.reset()
+	if entries, sizeBytes := c.stats(); entries != 0 || sizeBytes != 0 {
+		t.Fatalf("unexpected cache stats after reset; got entries=%d, sizeBytes=%d; want zeros", entries, sizeBytes)
+	}
+
+	// Too big entries mustn't be cached
+	c = newStatsQueryRangeCache(entrySize)
+	c.set("key1", e)
+	if eResult := c.get("key1"); eResult != nil {
+		t.Fatalf("unexpected too big entry found in the cache")
+	}
+}
+
+func newTestStatsSeries(name, host string, timestamps ...int64) *statsSeries {
+	labels := []logstorage.Field{
+		{
+			Name:  "host",
+			Value: host,
+		},
+	}
+	key := string(logstorage.MarshalFieldsToJSON([]byte(name), labels))
+
+	points := make([]statsPoint, len(timestamps))
+	for i, ts := range timestamps {
+		points[i] = statsPoint{
+			Timestamp: ts,
+			Value:     "1",
+		}
+	}
+	return &statsSeries{
+		key:    key,
+		Name:   name,
+		Labels: labels,
+		Points: points,
+	}
+}
+
+func statsSeriesToString(series []*statsSeries) string {
+	var a []string
+	for _, ss := range series {
+		a = append(a, fmt.Sprintf("%s: %v", ss.key, ss.Points))
+	}
+	return strings.Join(a, "\n")
+}
@@ -20,7 +20,7 @@ var (
 )
 
 //line app/vlselect/logsql/stats_query_response.qtpl:4
-func StreamStatsQueryResponse(qw422016 *qt422016.Writer, rows []statsRow) {
+func StreamStatsQueryResponse(qw422016 *qt422016.Writer, rows []StatsRow) {
 //line app/vlselect/logsql/stats_query_response.qtpl:4
 	qw422016.N().S(`{"status":"success","data":{"resultType":"vector","result":[`)
 //line app/vlselect/logsql/stats_query_response.qtpl:10
@@ -46,7 +46,7 @@ func StreamStatsQueryResponse(qw422016 *qt422016.Writer, rows []statsRow) {
 }
 
 //line app/vlselect/logsql/stats_query_response.qtpl:20
-func WriteStatsQueryResponse(qq422016 qtio422016.Writer, rows []statsRow) {
+func WriteStatsQueryResponse(qq422016 qtio422016.Writer, rows []StatsRow) {
 //line app/vlselect/logsql/stats_query_response.qtpl:20
 	qw422016 := qt422016.AcquireWriter(qq422016)
 //line app/vlselect/logsql/stats_query_response.qtpl:20
@@ -57,7 +57,7 @@ func WriteStatsQueryResponse(qq422016 qtio422016.Writer, rows []statsRow) {
 }
 
 //line app/vlselect/logsql/stats_query_response.qtpl:20
-func StatsQueryResponse(rows []statsRow) string {
+func StatsQueryResponse(rows []StatsRow) string {
 //line app/vlselect/logsql/stats_query_response.qtpl:20
 	qb422016 := qt422016.AcquireByteBuffer()
 //line app/vlselect/logsql/stats_query_response.qtpl:20
@@ -72,7 +72,7 @@ func StatsQueryResponse(rows []statsRow) string {
 }
 
 //line app/vlselect/logsql/stats_query_response.qtpl:22
-func streamformatStatsRow(qw422016 *qt422016.Writer, r *statsRow) {
+func streamformatStatsRow(qw422016 *qt422016.Writer, r *StatsRow) {
 //line app/vlselect/logsql/stats_query_response.qtpl:22
 	qw422016.N().S(`{"metric":{"__name__":`)
 //line app/vlselect/logsql/stats_query_response.qtpl:25
@@ -107,7 +107,7 @@ func streamformatStatsRow(qw422016 *qt422016.Writer, r *statsRow) {
 }
 
 //line app/vlselect/logsql/stats_query_response.qtpl:34
-func writeformatStatsRow(qq422016 qtio422016.Writer, r *statsRow) {
+func writeformatStatsRow(qq422016 qtio422016.Writer, r *StatsRow) {
 //line app/vlselect/logsql/stats_query_response.qtpl:34
 	qw422016 := qt422016.AcquireWriter(qq422016)
 //line app/vlselect/logsql/stats_query_response.qtpl:34
@@ -118,7 +118,7 @@ func writeformatStatsRow(qq422016 qtio422016.Writer, r *statsRow) {
 }
 
 //line app/vlselect/logsql/stats_query_response.qtpl:34
-func formatStatsRow(r *statsRow) string {
+func formatStatsRow(r *StatsRow) string {
 //line app/vlselect/logsql/stats_query_response.qtpl:34
 	qb422016 := qt422016.AcquireByteBuffer()
 //line app/vlselect/logsql/stats_query_response.qtpl:34
@@ -0,0 +1,180 @@
+package vlselect
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var (
+	lookupTablesDir = flag.String("search.lookupTablesDir", "", "Optional path to a directory with lookup tables for 'lookup' pipe. "+
+		"Every *.csv and *.json file in the directory is loaded as a lookup table with the name of the file without the extension. "+
+		"The directory is checked for changes every -search.lookupTablesCheckInterval. See https://docs.victoriametrics.com/victorialogs/logsql/#lookup-pipe")
+	lookupTablesCheckInterval = flag.Duration("search.lookupTablesCheckInterval", 30*time.Second, "Interval for checking for changes in -search.lookupTablesDir")
+)
+
+var (
+	lookupTablesReloads      = metrics.NewCounter(`vl_lookup_tables_reloads_total`)
+	lookupTablesReloadErrors = metrics.NewCounter(`vl_lookup_tables_reloads_errors_total`)
+)
+
+func mustLoadLookupTables() {
+	if *lookupTablesDir == "" {
+		return
+	}
+	signature, err := getLookupTablesSignature(*lookupTablesDir)
+	if err != nil {
+		logger.Fatalf("cannot read -search.lookupTablesDir: %s", err)
+	}
+	if err := loadLookupTables(*lookupTablesDir); err != nil {
+		logger.Fatalf("cannot load -search.lookupTablesDir: %s", err)
+	}
+	lookupTablesSignature = signature
+}
+
+// loadLookupTables loads lookup tables from dir and makes them available to `lookup` pipe.
+func loadLookupTables(dir string) error {
+	paths, err := getLookupTablePaths(dir)
+	if err != nil {
+		return err
+	}
+
+	tables := make(map[string]*logstorage.LookupTable, len(paths))
+	for _, path := range paths {
+		name, ext := getLookupTableName(path)
+		if _, ok := tables[name]; ok {
+			return fmt.Errorf("duplicate lookup table %q at %q", name, dir)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read lookup table: %w", err)
+		}
+		var lt *logstorage.LookupTable
+		if ext == ".csv" {
+			lt, err = logstorage.ParseLookupTableCSV(data)
+		} else {
+			lt, err = logstorage.ParseLookupTableJSON(data)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot parse lookup table %q: %w", path, err)
+		}
+		tables[name] = lt
+	}
+
+	logstorage.SetLookupTables(tables)
+	logger.Infof("loaded %d lookup tables from -search.lookupTablesDir=%q", len(tables), dir)
+	return nil
+}
+
+func getLookupTablePaths(dir string) ([]string, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory: %w", err)
+	}
+	var paths []string
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+		name := de.Name()
+		if strings.HasPrefix(name, ".") {
+			// Skip hidden files, which may be created by editors.
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".csv", ".json":
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths, nil
+}
+
+func getLookupTableName(path string) (string, string) {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	return name, ext
+}
+
+// getLookupTablesSignature returns a signature for lookup table files at dir.
+//
+// The signature changes when lookup table files are added, deleted or modified.
+func getLookupTablesSignature(dir string) (string, error) {
+	paths, err := getLookupTablePaths(dir)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot stat lookup table: %w", err)
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d\n", path, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return sb.String(), nil
+}
+
+var (
+	lookupTablesSignature string
+
+	lookupTablesWatcherStopCh chan struct{}
+	lookupTablesWatcherWG     sync.WaitGroup
+)
+
+func startLookupTablesWatcher() {
+	if *lookupTablesDir == "" {
+		return
+	}
+
+	lookupTablesWatcherStopCh = make(chan struct{})
+	lookupTablesWatcherWG.Go(func() {
+		t := time.NewTicker(*lookupTablesCheckInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-lookupTablesWatcherStopCh:
+				return
+			case <-t.C:
+			}
+
+			signature, err := getLookupTablesSignature(*lookupTablesDir)
+			if err != nil {
+				logger.Errorf("cannot check -search.lookupTablesDir for changes: %s", err)
+				continue
+			}
+			if signature == lookupTablesSignature {
+				continue
+			}
+
+			logger.Infof("lookup tables at -search.lookupTablesDir=%q have been changed; reloading them", *lookupTablesDir)
+			lookupTablesReloads.Inc()
+			if err := loadLookupTables(*lookupTablesDir); err != nil {
+				lookupTablesReloadErrors.Inc()
+				logger.Errorf("cannot reload -search.lookupTablesDir; continuing using the previously loaded lookup tables; error: %s", err)
+			}
+
+			// Update the signature even on error, in order to avoid reloading the same broken files over and over.
+			lookupTablesSignature = signature
+		}
+	})
+}
+
+func stopLookupTablesWatcher() {
+	if lookupTablesWatcherStopCh == nil {
+		return
+	}
+	close(lookupTablesWatcherStopCh)
+	lookupTablesWatcherWG.Wait()
+	lookupTablesWatcherStopCh = nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/metrics"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/alerting"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/internalselect"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlselect/logsql"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vlstorage"
@@ -57,12 +58,24 @@ func getDefaultMaxConcurrentRequests() int {
 func Init() {
 	concurrencyLimitCh = make(chan struct{}, *maxConcurrentRequests)
 
+	mustLoadGrokPatterns()
+	startGrokPatternsReloader()
+	mustLoadLookupTables()
+	startLookupTablesWatcher()
+	mustLoadGeoIPDatabases()
+	startGeoIPDatabasesReloader()
+
 	internalselect.Init()
+	alerting.Init(acquireAlertingConcurrencySlot)
 }
 
 // Stop stops vlselect
 func Stop() {
+	alerting.Stop()
 	internalselect.Stop()
+	stopGrokPatternsReloader()
+	stopLookupTablesWatcher()
+	stopGeoIPDatabasesReloader()
 
 	concurrencyLimitCh = nil
 }
@@ -180,6 +193,18 @@ func selectHandler(w http.ResponseWriter, r *http.Request, path string) bool {
 		return true
 	}
 
+	// Do not apply concurrency limit to alerting requests, since they return the in-memory state of alerting rules.
+	switch path {
+	case "/select/alerting/rules":
+		alertingRulesRequests.Inc()
+		alerting.ProcessRulesRequest(w, r)
+		return true
+	case "/select/alerting/alerts":
+		alertingAlertsRequests.Inc()
+		alerting.ProcessAlertsRequest(w, r)
+		return true
+	}
+
 	if path == "/select/logsql/tail" {
 		logsqlTailRequests.Inc()
 		// Process live tailing request without timeout, since it is OK to run live tailing requests for very long time.
@@ -195,6 +220,24 @@ func selectHandler(w http.ResponseWriter, r *http.Request, path string) bool {
 		httpserver.Errorf(w, r, "%s", err)
 		return true
 	}
+	timeoutHint := fmt.Sprintf("possible solutions: to increase -search.maxQueryDuration=%s; to pass bigger value to 'timeout' query arg", maxQueryDuration)
+
+	// Apply per-tenant limits. Invalid tenantID is reported later by the request handler.
+	releaseTenantQuerySlot := func() {}
+	if tenantID, err := logstorage.GetTenantIDFromRequest(r); err == nil {
+		if tql := vlstorage.GetTenantQueryLimits(tenantID); tql != nil && tql.MaxQueryDuration > 0 && d > tql.MaxQueryDuration {
+			d = tql.MaxQueryDuration
+			timeoutHint = fmt.Sprintf("the query exceeds max_query_duration=%s limit for the tenant %d:%d; "+
+				"see https://docs.victoriametrics.com/victorialogs/querying/#tenant-limits", tql.MaxQueryDuration, tenantID.AccountID, tenantID.ProjectID)
+		}
+		releaseTenantQuerySlot, err = vlstorage.AcquireTenantQuerySlot(tenantID)
+		if err != nil {
+			httpserver.Errorf(w, r, "%s", err)
+			return true
+		}
+	}
+	defer releaseTenantQuerySlot()
+
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, d)
 	defer cancel()
 
@@ -220,11 +263,11 @@ func selectHandler(w http.ResponseWriter, r *http.Request, path string) bool {
 		}
 	}
 
-	logRequestErrorIfNeeded(ctxWithTimeout, w, r, startTime)
+	logRequestErrorIfNeeded(ctxWithTimeout, w, r, startTime, timeoutHint)
 	return true
 }
 
-func logRequestErrorIfNeeded(ctx context.Context, w http.ResponseWriter, r *http.Request, startTime time.Time) {
+func logRequestErrorIfNeeded(ctx context.Context, w http.ResponseWriter, r *http.Request, startTime time.Time, timeoutHint string) {
 	err := ctx.Err()
 	switch err {
 	case nil:
@@ -233,8 +276,7 @@ func logRequestErrorIfNeeded(ctx context.Context, w http.ResponseWriter, r *http
 		// do not log canceled requests, since they are expected and legal.
 	case context.DeadlineExceeded:
 		err = &httpserver.ErrorWithStatusCode{
-			Err: fmt.Errorf("the request couldn't be executed in %.3f seconds; possible solutions: "+
-				"to increase -search.maxQueryDuration=%s; to pass bigger value to 'timeout' query arg", time.Since(startTime).Seconds(), maxQueryDuration),
+			Err:        fmt.Errorf("the request couldn't be executed in %.3f seconds; %s", time.Since(startTime).Seconds(), timeoutHint),
 			StatusCode: http.StatusServiceUnavailable,
 		}
 		httpserver.Errorf(w, r, "%s", err)
@@ -283,6 +325,29 @@ func decRequestConcurrency() {
 	<-concurrencyLimitCh
 }
 
+// acquireAlertingConcurrencySlot waits until the alerting rule query can be executed according to -search.maxConcurrentRequests.
+//
+// It returns the func for releasing the acquired slot.
+func acquireAlertingConcurrencySlot(ctx context.Context) (func(), error) {
+	startTime := time.Now()
+	select {
+	case concurrencyLimitCh <- struct{}{}:
+		return decRequestConcurrency, nil
+	default:
+		concurrencyLimitReached.Inc()
+		select {
+		case concurrencyLimitCh <- struct{}{}:
+			return decRequestConcurrency, nil
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				concurrencyLimitTimeout.Inc()
+			}
+			return nil, fmt.Errorf("couldn't start executing the query in %.3f seconds, since -search.maxConcurrentRequests=%d concurrent requests are executed",
+				time.Since(startTime).Seconds(), *maxConcurrentRequests)
+		}
+	}
+}
+
 func processSelectRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, path string) bool {
 	httpserver.EnableCORS(w, r)
 	startTime := time.Now()
@@ -291,6 +356,11 @@ func processSelectRequest(ctx context.Context, w http.ResponseWriter, r *http.Re
 		logsqlQueryTimeRangeRequests.Inc()
 		logsql.ProcessQueryTimeRangeRequest(ctx, w, r)
 		return true
+	case "/select/logsql/explain":
+		logsqlExplainRequests.Inc()
+		logsql.ProcessExplainRequest(ctx, w, r)
+		logsqlExplainDuration.UpdateDuration(startTime)
+		return true
 	case "/select/logsql/facets":
 		logsqlFacetsRequests.Inc()
 		logsql.ProcessFacetsRequest(ctx, w, r)
@@ -351,7 +421,36 @@ func processSelectRequest(ctx context.Context, w http.ResponseWriter, r *http.Re
 		logsql.ProcessTenantIDsRequest(ctx, w, r)
 		tenantIDsDuration.UpdateDuration(startTime)
 		return true
+	case "/select/loki/api/v1/query_range":
+		lokiQueryRangeRequests.Inc()
+		logsql.ProcessLokiQueryRangeRequest(ctx, w, r)
+		lokiQueryRangeDuration.UpdateDuration(startTime)
+		return true
+	case "/select/loki/api/v1/query":
+		lokiQueryRequests.Inc()
+		logsql.ProcessLokiQueryRequest(ctx, w, r)
+		lokiQueryDuration.UpdateDuration(startTime)
+		return true
+	case "/select/loki/api/v1/labels", "/select/loki/api/v1/label":
+		lokiLabelsRequests.Inc()
+		logsql.ProcessLokiLabelsRequest(ctx, w, r)
+		lokiLabelsDuration.UpdateDuration(startTime)
+		return true
+	case "/select/loki/api/v1/series":
+		lokiSeriesRequests.Inc()
+		logsql.ProcessLokiSeriesRequest(ctx, w, r)
+		lokiSeriesDuration.UpdateDuration(startTime)
+		return true
 	default:
+		// Handle /select/loki/api/v1/label/<labelName>/values
+		if s, ok := strings.CutPrefix(path, "/select/loki/api/v1/label/"); ok {
+			if labelName, ok := strings.CutSuffix(s, "/values"); ok && labelName != "" {
+				lokiLabelValuesRequests.Inc()
+				logsql.ProcessLokiLabelValuesRequest(ctx, w, r, labelName)
+				lokiLabelValuesDuration.UpdateDuration(startTime)
+				return true
+			}
+		}
 		return false
 	}
 }
@@ -398,6 +497,9 @@ func processDeleteRunTaskRequest(ctx context.Context, w http.ResponseWriter, r *
 		return
 	}
 
+	// Reset the response cache, since it may contain the results for the deleted logs.
+	logsql.ResetStatsQueryRangeCache()
+
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"task_id":%q}`, taskID)
 }
@@ -449,6 +551,9 @@ func getMaxQueryDuration(r *http.Request) (time.Duration, error) {
 }
 
 var (
+	logsqlExplainRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/explain"}`)
+	logsqlExplainDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/logsql/explain"}`)
+
 	logsqlFacetsRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/facets"}`)
 	logsqlFacetsDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/logsql/facets"}`)
 
@@ -485,9 +590,28 @@ var (
 	tenantIDsRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/tenant_ids"}`)
 	tenantIDsDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/tenant_ids"}`)
 
+	lokiQueryRangeRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/loki/api/v1/query_range"}`)
+	lokiQueryRangeDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/loki/api/v1/query_range"}`)
+
+	lokiQueryRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/loki/api/v1/query"}`)
+	lokiQueryDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/loki/api/v1/query"}`)
+
+	lokiLabelsRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/loki/api/v1/labels"}`)
+	lokiLabelsDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/loki/api/v1/labels"}`)
+
+	lokiLabelValuesRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/loki/api/v1/label/{}/values"}`)
+	lokiLabelValuesDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/loki/api/v1/label/{}/values"}`)
+
+	lokiSeriesRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/loki/api/v1/series"}`)
+	lokiSeriesDuration = metrics.NewSummary(`vl_http_request_duration_seconds{path="/select/loki/api/v1/series"}`)
+
 	// no need to track duration for tail requests, as they usually take long time
 	logsqlTailRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/tail"}`)
 
+	// no need to track the duration for alerting requests, since they are instant
+	alertingRulesRequests  = metrics.NewCounter(`vl_http_requests_total{path="/select/alerting/rules"}`)
+	alertingAlertsRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/alerting/alerts"}`)
+
 	// no need to track the duration for query_time_range requests, since they are instant
 	logsqlQueryTimeRangeRequests = metrics.NewCounter(`vl_http_requests_total{path="/select/logsql/query_time_range"}`)
 
@@ -8,6 +8,7 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
@@ -45,6 +46,12 @@ var (
 		"See https://docs.victoriametrics.com/victorialogs/#how-to-remove-snapshots")
 	storageDataPath = flag.String("storageDataPath", "victoria-logs-data", "Path to directory where to store VictoriaLogs data; "+
 		"see https://docs.victoriametrics.com/victorialogs/#storage")
+	storageDataPathCold = flag.String("storageDataPath.cold", "", "Optional path to directory where to move per-day partitions older than -storageDataPath.coldAfter; "+
+		"this path is usually located on cheaper and slower storage than -storageDataPath; the moved partitions remain available for querying; "+
+		"see https://docs.victoriametrics.com/victorialogs/#tiered-storage")
+	storageDataPathColdAfter = flagutil.NewRetentionDuration("storageDataPath.coldAfter", "0", "Per-day partitions older than the given duration are moved "+
+		"from -storageDataPath to -storageDataPath.cold; partitions aren't moved if it is set to 0; "+
+		"see https://docs.victoriametrics.com/victorialogs/#tiered-storage")
 	inmemoryDataFlushInterval = flag.Duration("inmemoryDataFlushInterval", 5*time.Second, "The interval for guaranteed saving of in-memory data to disk. "+
 		"The saved data survives unclean shutdowns such as OOM crash, hardware reset, SIGKILL, etc. "+
 		"Bigger intervals may help increase the lifetime of flash storage with limited write cycles (e.g. Raspberry PI). "+
@@ -55,6 +62,10 @@ var (
 		"see https://docs.victoriametrics.com/victorialogs/data-ingestion/ ; see also -logNewStreams")
 	minFreeDiskSpaceBytes = flagutil.NewBytes("storage.minFreeDiskSpaceBytes", 10e6, "The minimum free disk space at -storageDataPath after which "+
 		"the storage stops accepting new data")
+	disableSpillToDisk = flag.Bool("search.disableSpillToDisk", false, "Whether to disable spilling the state of 'sort' and 'stats' pipes to temporary files at -storageDataPath "+
+		"when the state doesn't fit the memory limit. Spilling is always disabled when -storageNode is set. "+
+		"If spilling is disabled, then such queries fail with the memory limit error; "+
+		"see https://docs.victoriametrics.com/victorialogs/logsql/#sort-pipe and https://docs.victoriametrics.com/victorialogs/logsql/#stats-pipe")
 
 	logNewStreamsAuthKey = flagutil.NewPassword("logNewStreamsAuthKey", "authKey, which must be passed in query string to /internal/log_new_streams . It overrides -httpAuth.* . "+
 		"See https://docs.victoriametrics.com/victorialogs/#logging-new-streams")
@@ -66,8 +77,14 @@ var (
 	partitionManageAuthKey = flagutil.NewPassword("partitionManageAuthKey", "authKey, which must be passed in query string to /internal/partition/* . It overrides -httpAuth.* . "+
 		"See https://docs.victoriametrics.com/victorialogs/#partitions-lifecycle")
 
+	rebalanceAuthKey = flagutil.NewPassword("rebalanceAuthKey", "authKey, which must be passed in query string to /internal/rebalance/* . It overrides -httpAuth.* . "+
+		"See https://docs.victoriametrics.com/victorialogs/cluster/#rebalancing")
+
 	storageNodeAddrs = flagutil.NewArrayString("storageNode", "Comma-separated list of TCP addresses for storage nodes to route the ingested logs to and to send select queries to. "+
 		"If the list is empty, then the ingested logs are stored and queried locally from -storageDataPath")
+	replicationFactor = flag.Int("replicationFactor", 1, "The number of distinct -storageNode nodes to store every ingested log entry to. "+
+		"Queries return full responses if less than -replicationFactor storage nodes are unavailable. The same value must be passed to vlinsert and vlselect. "+
+		"See https://docs.victoriametrics.com/victorialogs/cluster/#replication")
 	insertConcurrency        = flag.Int("insert.concurrency", 2, "The average number of concurrent data ingestion requests, which can be sent to every -storageNode")
 	insertDisableCompression = flag.Bool("insert.disableCompression", false, "Whether to disable compression when sending the ingested data to -storageNode nodes. "+
 		"Disabled compression reduces CPU usage at the cost of higher network usage")
@@ -103,15 +120,33 @@ var netstorageInsert *netinsert.Storage
 
 var netstorageSelect *netselect.Storage
 
+// storageNodeAuthCfgs and storageNodeIsTLSs contain auth configs and TLS settings for the corresponding -storageNode nodes.
+var (
+	storageNodeAuthCfgs []*promauth.Config
+	storageNodeIsTLSs   []bool
+)
+
 // Init initializes vlstorage.
 //
 // Stop must be called when vlstorage is no longer needed
 func Init() {
 	if len(*storageNodeAddrs) == 0 {
+		initQueryTempDir()
 		initLocalStorage()
 	} else {
 		initNetworkStorage()
 	}
+	mustInitTenantQueryLimits()
+}
+
+// initQueryTempDir initializes the directory for temporary files at -storageDataPath.
+//
+// It must be called only when the local storage is used, since -storageDataPath isn't used by vlselect in cluster mode.
+func initQueryTempDir() {
+	if *disableSpillToDisk {
+		return
+	}
+	logstorage.SetQueryTempDir(filepath.Join(*storageDataPath, "tmp"))
 }
 
 func initLocalStorage() {
@@ -129,8 +164,16 @@ func initLocalStorage() {
 	if *maxDiskUsagePercent < 0 || *maxDiskUsagePercent > 100 {
 		logger.Fatalf("-retention.maxDiskUsagePercent must be between 1 and 100; got %d", *maxDiskUsagePercent)
 	}
+	if storageDataPathColdAfter.Duration() > 0 && *storageDataPathCold == "" {
+		logger.Fatalf("-storageDataPath.cold must be set when -storageDataPath.coldAfter is set")
+	}
+	if *storageDataPathCold != "" && filepath.Clean(*storageDataPathCold) == filepath.Clean(*storageDataPath) {
+		logger.Fatalf("-storageDataPath.cold must differ from -storageDataPath=%s", *storageDataPath)
+	}
 	cfg := &logstorage.StorageConfig{
 		Retention:              retentionPeriod.Duration(),
+		ColdPath:               *storageDataPathCold,
+		ColdAfter:              storageDataPathColdAfter.Duration(),
 		DefaultParallelReaders: *defaultParallelReaders,
 		MaxDiskSpaceUsageBytes: maxDiskSpaceUsageBytes.N,
 		MaxDiskUsagePercent:    *maxDiskUsagePercent,
@@ -141,10 +184,12 @@ func initLocalStorage() {
 		LogNewStreams:          *logNewStreams,
 		LogIngestedRows:        *logIngestedRows,
 		MinFreeDiskSpaceBytes:  minFreeDiskSpaceBytes.N,
+		RetentionFilters:       mustLoadRetentionFilters(),
 	}
 	logger.Infof("opening storage at -storageDataPath=%s", *storageDataPath)
 	startTime := time.Now()
 	localStorage = logstorage.MustOpenStorage(*storageDataPath, cfg)
+	mustOpenMaterializedViews(cfg)
 
 	var ss logstorage.StorageStats
 	localStorage.UpdateStats(&ss)
@@ -157,8 +202,14 @@ func initLocalStorage() {
 	localStorageMetrics = metrics.NewSet()
 	localStorageMetrics.RegisterMetricsWriter(func(w io.Writer) {
 		writeStorageMetrics(w, localStorage)
+		if localMaterializedViews != nil {
+			writeMaterializedViewsMetrics(w, localMaterializedViews)
+		}
 	})
 	metrics.RegisterSet(localStorageMetrics)
+
+	startRetentionFiltersReloader(localStorage)
+	startMaterializedViewsReloader(localMaterializedViews)
 }
 
 func initNetworkStorage() {
@@ -166,18 +217,25 @@ func initNetworkStorage() {
 		logger.Panicf("BUG: initNetworkStorage() has been already called")
 	}
 
+	if *replicationFactor < 1 || *replicationFactor > len(*storageNodeAddrs) {
+		logger.Fatalf("-replicationFactor must be in the range [1..%d], where %d is the number of -storageNode nodes; got %d",
+			len(*storageNodeAddrs), len(*storageNodeAddrs), *replicationFactor)
+	}
+
 	authCfgs := make([]*promauth.Config, len(*storageNodeAddrs))
 	isTLSs := make([]bool, len(*storageNodeAddrs))
 	for i := range authCfgs {
 		authCfgs[i] = newAuthConfigForStorageNode(i)
 		isTLSs[i] = storageNodeTLS.GetOptionalArg(i)
 	}
+	storageNodeAuthCfgs = authCfgs
+	storageNodeIsTLSs = isTLSs
 
 	logger.Infof("starting insert service for nodes %s", *storageNodeAddrs)
-	netstorageInsert = netinsert.NewStorage(*storageNodeAddrs, authCfgs, isTLSs, *insertConcurrency, *insertDisableCompression)
+	netstorageInsert = netinsert.NewStorage(*storageNodeAddrs, authCfgs, isTLSs, *insertConcurrency, *insertDisableCompression, *replicationFactor)
 
 	logger.Infof("initializing select service for nodes %s", *storageNodeAddrs)
-	netstorageSelect = netselect.NewStorage(*storageNodeAddrs, authCfgs, isTLSs, *selectDisableCompression)
+	netstorageSelect = netselect.NewStorage(*storageNodeAddrs, authCfgs, isTLSs, *selectDisableCompression, *replicationFactor)
 
 	logger.Infof("initialized all the network services")
 }
@@ -224,13 +282,22 @@ func newAuthConfigForStorageNode(argIdx int) *promauth.Config {
 
 // Stop stops vlstorage.
 func Stop() {
+	mustStopTenantQueryLimits()
+
 	if localStorage != nil {
+		stopRetentionFiltersReloader()
+		stopMaterializedViewsReloader()
+
 		metrics.UnregisterSet(localStorageMetrics, true)
 		localStorageMetrics = nil
 
+		mustCloseMaterializedViews()
+
 		localStorage.MustClose()
 		localStorage = nil
 	} else {
+		stopRebalance()
+
 		netstorageInsert.MustStop()
 		netstorageInsert = nil
 
@@ -263,6 +330,12 @@ func RequestHandler(w http.ResponseWriter, r *http.Request) bool {
 		return processPartitionSnapshotDelete(w, r)
 	case "/internal/partition/snapshot/delete_stale":
 		return processPartitionSnapshotDeleteStale(w, r)
+	case "/internal/rebalance/start":
+		return processRebalanceStart(w, r)
+	case "/internal/rebalance/status":
+		return processRebalanceStatus(w, r)
+	case "/internal/rebalance/stop":
+		return processRebalanceStop(w, r)
 	}
 	return false
 }
@@ -326,6 +399,9 @@ func processForceFlush(w http.ResponseWriter, r *http.Request) bool {
 	}
 
 	localStorage.DebugFlush()
+	if localMaterializedViews != nil {
+		localMaterializedViews.DebugFlush()
+	}
 	return true
 }
 
@@ -544,6 +620,9 @@ func (*Storage) MustAddRows(lr *logstorage.LogRows) {
 	if localStorage != nil {
 		// Store lr in the local storage.
 		localStorage.MustAddRows(lr)
+		if localMaterializedViews != nil {
+			localMaterializedViews.AddRows(lr)
+		}
 	} else {
 		// Store lr across the remote storage nodes.
 		lr.ForEachRow(netstorageInsert.AddRow)
@@ -559,11 +638,28 @@ func RunQuery(qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBloc
 	}
 
 	if localStorage != nil {
+		// Materialized views cannot be used for queries limited to log streams owned by the current node,
+		// since they contain aggregated results for all the log streams stored at the node.
+		if localMaterializedViews != nil && qctx.StreamOwnerFilter == nil {
+			if ok, err := localMaterializedViews.RunQuery(qctx, localStorage, writeBlock); ok {
+				return err
+			}
+		}
 		return localStorage.RunQuery(qctx, writeBlock)
 	}
 	return netstorageSelect.RunQuery(qctx, writeBlock)
 }
 
+// GetQueryPartitionsPlan returns the estimated partitions to scan for the given q.
+//
+// It returns nil in VictoriaLogs cluster, since the partitions are located at vlstorage nodes.
+func GetQueryPartitionsPlan(q *logstorage.Query) []logstorage.PartitionPlan {
+	if localStorage != nil {
+		return localStorage.GetQueryPartitionsPlan(q)
+	}
+	return nil
+}
+
 // GetFieldNames executes qctx and returns field names seen in results.
 func GetFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
 	if localStorage != nil {
@@ -627,7 +723,14 @@ func DeleteRunTask(ctx context.Context, taskID string, timestamp int64, tenantID
 	logger.Infof("starting deleting logs for task_id=%q, filter=%q, tenantIDs=%s", taskID, f, tenantIDs)
 
 	if localStorage != nil {
-		return localStorage.DeleteRunTask(ctx, taskID, timestamp, tenantIDs, f)
+		if err := localStorage.DeleteRunTask(ctx, taskID, timestamp, tenantIDs, f); err != nil {
+			return err
+		}
+		if localMaterializedViews != nil {
+			// Materialized views continue counting the deleted logs, so they mustn't be used for queries over the deleted logs.
+			localMaterializedViews.ResetReadyTime(timestamp)
+		}
+		return nil
 	}
 	return netstorageSelect.DeleteRunTask(ctx, taskID, timestamp, tenantIDs, f)
 }
@@ -673,6 +776,10 @@ func writeStorageMetrics(w io.Writer, strg *logstorage.Storage) {
 	}
 	metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_free_disk_space_bytes{path=%q}`, *storageDataPath), fs.MustGetFreeSpace(*storageDataPath))
 	metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_total_disk_space_bytes{path=%q}`, *storageDataPath), fs.MustGetTotalSpace(*storageDataPath))
+	if *storageDataPathCold != "" {
+		metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_free_disk_space_bytes{path=%q}`, *storageDataPathCold), fs.MustGetFreeSpace(*storageDataPathCold))
+		metrics.WriteGaugeUint64(w, fmt.Sprintf(`vl_total_disk_space_bytes{path=%q}`, *storageDataPathCold), fs.MustGetTotalSpace(*storageDataPathCold))
+	}
 
 	isReadOnly := uint64(0)
 	if ss.IsReadOnly {
@@ -714,6 +821,7 @@ func writeStorageMetrics(w io.Writer, strg *logstorage.Storage) {
 	metrics.WriteGaugeUint64(w, `vl_pending_rows{type="indexdb"}`, ss.IndexdbPendingItems)
 
 	metrics.WriteGaugeUint64(w, `vl_partitions`, ss.PartitionsCount)
+	metrics.WriteGaugeUint64(w, `vl_cold_partitions`, ss.ColdPartitionsCount)
 	metrics.WriteCounterUint64(w, `vl_streams_created_total`, ss.StreamsCreatedTotal)
 
 	metrics.WriteGaugeUint64(w, `vl_indexdb_rows`, ss.IndexdbItemsCount)
@@ -0,0 +1,152 @@
+package vlstorage
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var materializedViewsConfigFile = flag.String("materializedViews.configFile", "", "Optional path to a file with materialized views. "+
+	"Materialized views are stats queries, which are evaluated over the ingested logs, so matching /select/logsql/stats_query_range queries "+
+	"are served from the pre-aggregated results. The file is re-read on SIGHUP signal. "+
+	"See https://docs.victoriametrics.com/victorialogs/#materialized-views")
+
+var (
+	materializedViewsReloads      = metrics.NewCounter(`vl_materialized_views_config_reloads_total`)
+	materializedViewsReloadErrors = metrics.NewCounter(`vl_materialized_views_config_reloads_errors_total`)
+)
+
+var localMaterializedViews *logstorage.MaterializedViews
+
+// materializedViewConfig represents a single entry at -materializedViews.configFile
+type materializedViewConfig struct {
+	// Name is the unique name of the view.
+	Name string `yaml:"name"`
+
+	// Query is LogsQL stats query for the view.
+	Query string `yaml:"query"`
+}
+
+// parseMaterializedViews parses materialized views from YAML data.
+func parseMaterializedViews(data []byte) ([]*logstorage.MaterializedView, error) {
+	var cfgs []materializedViewConfig
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+
+	views := make([]*logstorage.MaterializedView, 0, len(cfgs))
+	names := make(map[string]struct{}, len(cfgs))
+	for i, cfg := range cfgs {
+		if _, ok := names[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate name %q for materialized view #%d", cfg.Name, i+1)
+		}
+		names[cfg.Name] = struct{}{}
+
+		mv, err := logstorage.NewMaterializedView(cfg.Name, cfg.Query)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse materialized view #%d: %w", i+1, err)
+		}
+		views = append(views, mv)
+	}
+	return views, nil
+}
+
+func mustOpenMaterializedViews(cfg *logstorage.StorageConfig) {
+	path := filepath.Join(*storageDataPath, "materialized_views")
+	if *materializedViewsConfigFile == "" {
+		// The previously used materialized views do not cover logs ingested while they are disabled.
+		logstorage.MustResetMaterializedViewsState(path)
+		return
+	}
+	views, err := loadMaterializedViews(*materializedViewsConfigFile)
+	if err != nil {
+		logger.Fatalf("cannot load -materializedViews.configFile: %s", err)
+	}
+
+	localMaterializedViews = logstorage.MustOpenMaterializedViews(path, cfg, views)
+	logger.Infof("opened %d materialized views from -materializedViews.configFile=%q", len(views), *materializedViewsConfigFile)
+}
+
+func mustCloseMaterializedViews() {
+	if localMaterializedViews == nil {
+		return
+	}
+	localMaterializedViews.MustClose()
+	localMaterializedViews = nil
+}
+
+func loadMaterializedViews(path string) ([]*logstorage.MaterializedView, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, err
+	}
+	views, err := parseMaterializedViews(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return views, nil
+}
+
+var (
+	materializedViewsReloaderStopCh chan struct{}
+	materializedViewsReloaderWG     sync.WaitGroup
+)
+
+func startMaterializedViewsReloader(mvs *logstorage.MaterializedViews) {
+	if mvs == nil {
+		return
+	}
+
+	sighupCh := procutil.NewSighupChan()
+	materializedViewsReloaderStopCh = make(chan struct{})
+	materializedViewsReloaderWG.Go(func() {
+		for {
+			select {
+			case <-materializedViewsReloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -materializedViews.configFile=%q", *materializedViewsConfigFile)
+			materializedViewsReloads.Inc()
+			views, err := loadMaterializedViews(*materializedViewsConfigFile)
+			if err != nil {
+				materializedViewsReloadErrors.Inc()
+				logger.Errorf("cannot reload -materializedViews.configFile; continuing using the previously loaded config; error: %s", err)
+				continue
+			}
+			mvs.UpdateViews(views)
+			logger.Infof("successfully reloaded %d materialized views from -materializedViews.configFile=%q", len(views), *materializedViewsConfigFile)
+		}
+	})
+}
+
+func stopMaterializedViewsReloader() {
+	if materializedViewsReloaderStopCh == nil {
+		return
+	}
+	close(materializedViewsReloaderStopCh)
+	materializedViewsReloaderWG.Wait()
+	materializedViewsReloaderStopCh = nil
+}
+
+func writeMaterializedViewsMetrics(w io.Writer, mvs *logstorage.MaterializedViews) {
+	var ms logstorage.MaterializedViewsStats
+	mvs.UpdateStats(&ms)
+
+	metrics.WriteGaugeUint64(w, `vl_materialized_views`, ms.ViewsCount)
+	metrics.WriteGaugeUint64(w, `vl_materialized_views_pending_groups`, ms.PendingGroups)
+	metrics.WriteCounterUint64(w, `vl_materialized_views_matched_rows_total`, ms.RowsMatched)
+	metrics.WriteCounterUint64(w, `vl_materialized_views_flushed_rows_total`, ms.RowsFlushed)
+	metrics.WriteCounterUint64(w, `vl_materialized_views_queries_total`, ms.QueriesServed)
+}
@@ -0,0 +1,77 @@
+package vlstorage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMaterializedViewsSuccess(t *testing.T) {
+	f := func(data string, resultExpected string) {
+		t.Helper()
+
+		views, err := parseMaterializedViews([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		a := make([]string, len(views))
+		for i := range views {
+			a[i] = views[i].String()
+		}
+		result := strings.Join(a, "\n")
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(``, ``)
+	f(`
+- name: hits
+  query: '* | stats by (_time:1m, service, level) count()'
+- name: nginx_bytes
+  query: '{app="nginx"} | stats by (_time:1h) sum(bytes) bytes, max(duration)'
+`, `{name="hits", query="* | stats by (_time:1m, service, level) count(*) as \"count(*)\""}
+{name="nginx_bytes", query="{app=\"nginx\"} | stats by (_time:1h) sum(bytes) as bytes, max(duration) as \"max(duration)\""}`)
+}
+
+func TestParseMaterializedViewsFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		views, err := parseMaterializedViews([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if views != nil {
+			t.Fatalf("expecting nil result; got %v", views)
+		}
+	}
+
+	// invalid YAML
+	f(`foobar`)
+
+	// unknown field
+	f(`
+- name: foo
+  query: '* | stats by (_time:1m) count()'
+  step: 1m
+`)
+
+	// missing name
+	f(`
+- query: '* | stats by (_time:1m) count()'
+`)
+
+	// duplicate name
+	f(`
+- name: foo
+  query: '* | stats by (_time:1m) count()'
+- name: foo
+  query: 'error | stats by (_time:1m) count()'
+`)
+
+	// unsupported query
+	f(`
+- name: foo
+  query: '* | stats by (_time:1m) avg(duration)'
+`)
+}
@@ -38,6 +38,9 @@ type Storage struct {
 
 	disableCompression bool
 
+	// replicationFactor is the number of distinct storage nodes to write every log row to.
+	replicationFactor int
+
 	srt *streamRowsTracker
 
 	pendingDataBuffers chan *bytesutil.ByteBuffer
@@ -62,6 +65,11 @@ type storageNode struct {
 	// ac is auth config used for setting request headers such as Authorization and Host.
 	ac *promauth.Config
 
+	// sendLock is read-locked while sending data blocks to storage nodes.
+	//
+	// It is locked by flush in order to wait until all the data blocks are sent.
+	sendLock sync.RWMutex
+
 	// pendingData contains pending data, which must be sent to the storage node at the addr.
 	pendingDataMu        sync.Mutex
 	pendingData          *bytesutil.ByteBuffer
@@ -144,28 +152,28 @@ func (sn *storageNode) flushPendingData(force bool) {
 	sn.mustSendInsertRequest(pendingData)
 }
 
-func (sn *storageNode) debugFlush() {
-	// Send pending samples to sn.
-	sn.flushPendingData(true)
+// sendPendingData sends pending data to sn and waits until the data blocks, which are being sent to sn by concurrent goroutines, are delivered.
+func (sn *storageNode) sendPendingData() {
+	// Wait until the concurrent sends are finished.
+	sn.sendLock.Lock()
+	sn.pendingDataMu.Lock()
+	pendingData := sn.grabPendingDataForFlushLocked()
+	sn.pendingDataMu.Unlock()
+	sn.sendLock.Unlock()
 
-	// Instruct sn to convert the recevied samples into searchable parts.
-	if err := sn.doRequest("/internal/force_flush", nil); err != nil {
-		logger.Errorf("cannot convert pending samples into searchable parts: %s", err)
-	}
+	sn.mustSendInsertRequest(pendingData)
 }
 
-func (sn *storageNode) addRow(r *logstorage.InsertRow) {
-	bb := bbPool.Get()
-	b := bb.B
-
-	b = r.Marshal(b)
-
-	if len(b) > maxInsertBlockSize {
-		logger.Warnf("skipping too long log entry, since its length exceeds %d bytes; the actual log entry length is %d bytes; log entry contents: %s", maxInsertBlockSize, len(b), b)
-		bbPool.Put(bb)
-		return
+// forceFlush instructs sn to convert the received samples into searchable parts.
+func (sn *storageNode) forceFlush() error {
+	if err := sn.doRequest("/internal/force_flush", nil); err != nil {
+		return fmt.Errorf("cannot convert pending samples into searchable parts at %q: %w", sn.addr, err)
 	}
+	return nil
+}
 
+// addRow adds the marshaled log row b to sn.
+func (sn *storageNode) addRow(b []byte) {
 	var pendingData *bytesutil.ByteBuffer
 	sn.pendingDataMu.Lock()
 	if sn.pendingData.Len()+len(b) > maxInsertBlockSize {
@@ -174,9 +182,6 @@ func (sn *storageNode) addRow(r *logstorage.InsertRow) {
 	sn.pendingData.MustWrite(b)
 	sn.pendingDataMu.Unlock()
 
-	bb.B = b
-	bbPool.Put(bb)
-
 	if pendingData != nil {
 		sn.mustSendInsertRequest(pendingData)
 	}
@@ -193,6 +198,9 @@ func (sn *storageNode) grabPendingDataForFlushLocked() *bytesutil.ByteBuffer {
 }
 
 func (sn *storageNode) mustSendInsertRequest(pendingData *bytesutil.ByteBuffer) {
+	sn.sendLock.RLock()
+	defer sn.sendLock.RUnlock()
+
 	defer func() {
 		pendingData.Reset()
 		sn.s.pendingDataBuffers <- pendingData
@@ -203,6 +211,14 @@ func (sn *storageNode) mustSendInsertRequest(pendingData *bytesutil.ByteBuffer)
 		return
 	}
 
+	if sn.s.replicationFactor > 1 {
+		// Do not re-route the data block to other storage nodes, since they may already hold replicas for log streams from the block.
+		// Re-routed rows would be stored twice at the node owning the log stream, or they would be hidden by logstorage.StreamOwnerFilter
+		// at the node, which doesn't own the log stream. So re-try sending the data block to the same node until it becomes available.
+		sn.mustRetrySendInsertRequest(pendingData, err)
+		return
+	}
+
 	if !errors.Is(err, errTemporarilyDisabled) {
 		logger.Warnf("%s; re-routing the data block to the remaining nodes", err)
 	}
@@ -221,6 +237,35 @@ func (sn *storageNode) mustSendInsertRequest(pendingData *bytesutil.ByteBuffer)
 	}
 }
 
+// mustRetrySendInsertRequest re-tries sending pendingData to sn until it succeeds or the storage is stopped.
+//
+// err is the error for the previous attempt to send pendingData to sn.
+func (sn *storageNode) mustRetrySendInsertRequest(pendingData *bytesutil.ByteBuffer, err error) {
+	for {
+		if !errors.Is(err, errTemporarilyDisabled) {
+			logger.Warnf("%s; re-trying to send the data block to the same node in %s, since it may contain replicated logs", err, retrySendInterval)
+		}
+
+		t := timerpool.Get(retrySendInterval)
+		select {
+		case <-sn.s.stopCh:
+			timerpool.Put(t)
+			logger.Errorf("dropping %d bytes of data, since the storage node %q is unavailable", pendingData.Len(), sn.addr)
+			return
+		case <-t.C:
+			timerpool.Put(t)
+		}
+
+		err = sn.sendInsertRequest(pendingData)
+		if err == nil {
+			return
+		}
+	}
+}
+
+// retrySendInterval is the interval between attempts to send data blocks to unavailable storage nodes.
+var retrySendInterval = time.Second
+
 func (sn *storageNode) sendInsertRequest(pendingData *bytesutil.ByteBuffer) error {
 	dataLen := pendingData.Len()
 	if dataLen == 0 {
@@ -303,13 +348,15 @@ func (sn *storageNode) getRequestURL(path string) string {
 }
 
 func (sn *storageNode) setDisableTemporarily() {
-	// Disable sending data to this sn for 10 seconds.
-	sn.disabledUntil.Store(fasttime.UnixTimestamp() + 10)
+	sn.disabledUntil.Store(fasttime.UnixTimestamp() + disableNodeSeconds)
 
 	sn.sendErrors.Inc()
 	sn.isReachable.Store(false)
 }
 
+// disableNodeSeconds is the duration in seconds for disabling data sending to the storage node after the failed request.
+var disableNodeSeconds uint64 = 10
+
 var zstdBufPool bytesutil.ByteBufferPool
 
 // NewStorage returns new Storage for the given addrs with the given authCfgs.
@@ -318,8 +365,14 @@ var zstdBufPool bytesutil.ByteBufferPool
 //
 // If disableCompression is set, then the data is sent uncompressed to the remote storage.
 //
+// Every log row is written to replicationFactor distinct addrs.
+//
 // Call MustStop on the returned storage when it is no longer needed.
-func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, concurrency int, disableCompression bool) *Storage {
+func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, concurrency int, disableCompression bool, replicationFactor int) *Storage {
+	if replicationFactor < 1 || replicationFactor > len(addrs) {
+		logger.Panicf("BUG: replicationFactor must be in the range [1..%d]; got %d", len(addrs), replicationFactor)
+	}
+
 	pendingDataBuffers := make(chan *bytesutil.ByteBuffer, concurrency*len(addrs))
 	for i := 0; i < cap(pendingDataBuffers); i++ {
 		pendingDataBuffers <- &bytesutil.ByteBuffer{}
@@ -327,6 +380,7 @@ func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, conc
 
 	s := &Storage{
 		disableCompression: disableCompression,
+		replicationFactor:  replicationFactor,
 		pendingDataBuffers: pendingDataBuffers,
 		stopCh:             make(chan struct{}),
 	}
@@ -364,18 +418,60 @@ func (s *Storage) MustStop() {
 
 // DebugFlush flushes pending samples to s, so they become visible for querying.
 func (s *Storage) DebugFlush() {
+	if err := s.Flush(); err != nil {
+		logger.Errorf("%s", err)
+	}
+}
+
+// Flush sends pending samples to storage nodes and makes them visible for querying.
+//
+// It waits until all the samples passed to AddRow before the call are delivered to storage nodes.
+func (s *Storage) Flush() error {
 	var wg sync.WaitGroup
 	for _, sn := range s.sns {
-		wg.Go(sn.debugFlush)
+		wg.Go(sn.sendPendingData)
 	}
+	// Data blocks may be re-routed to other storage nodes on errors, so make them searchable only after all the sends are finished.
 	wg.Wait()
+
+	errs := make([]error, len(s.sns))
+	for i, sn := range s.sns {
+		wg.Go(func() {
+			errs[i] = sn.forceFlush()
+		})
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
 // AddRow adds the given log row into s.
+//
+// The row is written to s.replicationFactor distinct storage nodes.
 func (s *Storage) AddRow(streamHash uint64, r *logstorage.InsertRow) {
-	idx := s.srt.getNodeIdx(streamHash)
-	sn := s.sns[idx]
-	sn.addRow(r)
+	bb := bbPool.Get()
+	b := r.Marshal(bb.B[:0])
+	bb.B = b
+	defer bbPool.Put(bb)
+
+	if len(b) > maxInsertBlockSize {
+		logger.Warnf("skipping too long log entry, since its length exceeds %d bytes; the actual log entry length is %d bytes; log entry contents: %s", maxInsertBlockSize, len(b), b)
+		return
+	}
+
+	var idx uint64
+	if s.replicationFactor > 1 {
+		// Always write replicated log streams to the same storage nodes, since every log stream is queried
+		// only at the first available storage node among these nodes. See logstorage.StreamOwnerFilter.
+		idx = streamHash % uint64(len(s.sns))
+	} else {
+		idx = s.srt.getNodeIdx(streamHash)
+	}
+	for i := 0; i < s.replicationFactor; i++ {
+		// Write replicas to the storage nodes following the selected node, so every replica is stored at a distinct node.
+		sn := s.sns[(idx+uint64(i))%uint64(len(s.sns))]
+		sn.addRow(b)
+	}
 }
 
 func (s *Storage) sendInsertRequestToAnyNode(pendingData *bytesutil.ByteBuffer) bool {
@@ -2,11 +2,20 @@ package netinsert
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
 	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
 )
 
 func TestStreamRowsTracker(t *testing.T) {
@@ -55,3 +64,157 @@ func TestStreamRowsTracker(t *testing.T) {
 	nodesCount = 9
 	f(rowsCount, streamsCount, nodesCount)
 }
+
+func TestStorageAddRowReplication(t *testing.T) {
+	f := func(nodesCount, replicationFactor, rowsCount int) {
+		t.Helper()
+
+		insertedBytes := make([]atomic.Int64, nodesCount)
+		addrs := make([]string, nodesCount)
+		authCfgs := make([]*promauth.Config, nodesCount)
+		isTLSs := make([]bool, nodesCount)
+		for i := 0; i < nodesCount; i++ {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/internal/insert" {
+					data, _ := io.ReadAll(r.Body)
+					insertedBytes[i].Add(int64(len(data)))
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			addrs[i] = strings.TrimPrefix(srv.URL, "http://")
+			ac, err := (&promauth.Options{}).NewConfig()
+			if err != nil {
+				t.Fatalf("cannot create auth config: %s", err)
+			}
+			authCfgs[i] = ac
+		}
+
+		s := NewStorage(addrs, authCfgs, isTLSs, 1, true, replicationFactor)
+
+		r := &logstorage.InsertRow{
+			StreamTagsCanonical: "{}",
+			Timestamp:           123,
+			Fields: []logstorage.Field{
+				{
+					Name:  "_msg",
+					Value: "foo bar",
+				},
+			},
+		}
+		streamHash := r.GetStreamHash()
+		for i := 0; i < rowsCount; i++ {
+			s.AddRow(streamHash, r)
+		}
+		s.DebugFlush()
+		s.MustStop()
+
+		// Verify that the rows are written to replicationFactor distinct nodes starting from the node owning the stream.
+		nodesWithData := 0
+		for i := range insertedBytes {
+			if insertedBytes[i].Load() > 0 {
+				nodesWithData++
+			}
+		}
+		if nodesWithData != replicationFactor {
+			t.Fatalf("unexpected number of nodes with the inserted rows; got %d; want %d", nodesWithData, replicationFactor)
+		}
+		for i := 0; i < replicationFactor; i++ {
+			idx := (int(streamHash%uint64(nodesCount)) + i) % nodesCount
+			if insertedBytes[idx].Load() == 0 {
+				t.Fatalf("missing rows at the node #%d", idx)
+			}
+		}
+	}
+
+	f(1, 1, 1)
+	f(3, 1, 1)
+
+	// Replicated log streams must be always written to the same nodes.
+	f(3, 2, 2000)
+	f(3, 3, 2000)
+	f(5, 3, 2000)
+}
+
+func TestStorageAddRowReplicationNodeOutage(t *testing.T) {
+	origDisableNodeSeconds := disableNodeSeconds
+	origRetrySendInterval := retrySendInterval
+	disableNodeSeconds = 0
+	retrySendInterval = 10 * time.Millisecond
+	defer func() {
+		disableNodeSeconds = origDisableNodeSeconds
+		retrySendInterval = origRetrySendInterval
+	}()
+
+	const nodesCount = 3
+	const replicationFactor = 2
+	const rowsCount = 1000
+
+	r := &logstorage.InsertRow{
+		StreamTagsCanonical: "{}",
+		Timestamp:           123,
+		Fields: []logstorage.Field{
+			{
+				Name:  "_msg",
+				Value: "foo bar",
+			},
+		},
+	}
+	streamHash := r.GetStreamHash()
+	ownerIdx := int(streamHash % nodesCount)
+	failedIdx := (ownerIdx + 1) % nodesCount
+
+	// The node with the second replica is unavailable for the first few requests.
+	var failedRequests atomic.Int64
+	insertedBytes := make([]atomic.Int64, nodesCount)
+	addrs := make([]string, nodesCount)
+	authCfgs := make([]*promauth.Config, nodesCount)
+	isTLSs := make([]bool, nodesCount)
+	for i := 0; i < nodesCount; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/internal/insert" {
+				data, _ := io.ReadAll(r.Body)
+				if i == failedIdx && failedRequests.Add(1) <= 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				insertedBytes[i].Add(int64(len(data)))
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+
+		addrs[i] = strings.TrimPrefix(srv.URL, "http://")
+		ac, err := (&promauth.Options{}).NewConfig()
+		if err != nil {
+			t.Fatalf("cannot create auth config: %s", err)
+		}
+		authCfgs[i] = ac
+	}
+
+	s := NewStorage(addrs, authCfgs, isTLSs, 1, true, replicationFactor)
+	for i := 0; i < rowsCount; i++ {
+		s.AddRow(streamHash, r)
+	}
+	s.DebugFlush()
+	s.MustStop()
+
+	if n := failedRequests.Load(); n <= 3 {
+		t.Fatalf("expecting more than 3 requests to the failed node; got %d requests", n)
+	}
+
+	// Verify that every replica is stored exactly once at the nodes holding replicas for the log stream,
+	// so the rows are neither duplicated at the owner node nor hidden at the node, which doesn't own the log stream.
+	rowSize := int64(len(r.Marshal(nil)))
+	for i := range insertedBytes {
+		n := insertedBytes[i].Load()
+		nExpected := int64(0)
+		if i == ownerIdx || i == failedIdx {
+			nExpected = rowSize * rowsCount
+		}
+		if n != nExpected {
+			t.Fatalf("unexpected number of bytes at the node #%d; got %d; want %d", i, n, nExpected)
+		}
+	}
+}
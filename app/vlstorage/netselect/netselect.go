@@ -9,13 +9,17 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/contextutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
@@ -60,7 +64,7 @@ const (
 	// QueryProtocolVersion is the version of the protocol used for /internal/select/query HTTP endpoint.
 	//
 	// It must be updated every time the protocol changes.
-	QueryProtocolVersion = "v4"
+	QueryProtocolVersion = "v5"
 
 	// DeleteRunTaskProtocolVersion is the version of the protocol used for /internal/delete/run_task HTTP endpoint.
 	//
@@ -83,6 +87,12 @@ type Storage struct {
 	sns []*storageNode
 
 	disableCompression bool
+
+	// replicationFactor is the number of distinct storage nodes every log row is written to.
+	//
+	// Every log stream is queried at a single storage node, which owns it, if replicationFactor > 1.
+	// See logstorage.StreamOwnerFilter.
+	replicationFactor int
 }
 
 type storageNode struct {
@@ -103,6 +113,11 @@ type storageNode struct {
 
 	// sendErrors counts failed send attempts for this storage node.
 	sendErrors *metrics.Counter
+
+	// unavailableUntil is the unix timestamp in seconds until the storage node is considered unavailable for owning log streams.
+	//
+	// It is used only if replicationFactor > 1.
+	unavailableUntil atomic.Uint64
 }
 
 func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *storageNode {
@@ -131,6 +146,9 @@ func newStorageNode(s *Storage, addr string, ac *promauth.Config, isTLS bool) *s
 
 func (sn *storageNode) runQuery(qctx *logstorage.QueryContext, processBlock func(db *logstorage.DataBlock)) error {
 	args := sn.getCommonArgs(QueryProtocolVersion, qctx)
+	if qctx.StreamOwnerFilter != nil {
+		args.Set("stream_owner_filter", string(logstorage.MarshalStreamOwnerFilterToJSON(qctx.StreamOwnerFilter)))
+	}
 
 	qsLocal := &logstorage.QueryStats{}
 	defer qctx.QueryStats.UpdateAtomic(qsLocal)
@@ -361,10 +379,19 @@ func (sn *storageNode) getRequestURL(path string) string {
 //
 // If disableCompression is set, then uncompressed responses are received from storage nodes.
 //
+// replicationFactor must be set to the number of distinct addrs every log row is written to.
+// Up to replicationFactor-1 unavailable addrs are ignored during querying, since the remaining addrs contain all the logs in this case.
+// Every log stream is queried at a single addr, which owns it, so query pipes are executed at addrs.
+//
 // Call MustStop on the returned storage when it is no longer needed.
-func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, disableCompression bool) *Storage {
+func NewStorage(addrs []string, authCfgs []*promauth.Config, isTLSs []bool, disableCompression bool, replicationFactor int) *Storage {
+	if replicationFactor < 1 || replicationFactor > len(addrs) {
+		logger.Panicf("BUG: replicationFactor must be in the range [1..%d]; got %d", len(addrs), replicationFactor)
+	}
+
 	s := &Storage{
 		disableCompression: disableCompression,
+		replicationFactor:  replicationFactor,
 	}
 
 	sns := make([]*storageNode, len(addrs))
@@ -398,6 +425,10 @@ func (s *Storage) RunQuery(qctx *logstorage.QueryContext, writeBlock logstorage.
 }
 
 func (s *Storage) runQuery(stopCh <-chan struct{}, qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBlockFunc) error {
+	if s.replicationFactor > 1 {
+		return s.runReplicatedQuery(stopCh, qctx, writeBlock)
+	}
+
 	ctxWithCancel, cancel := contextutil.NewStopChanContext(stopCh)
 	defer cancel()
 
@@ -420,8 +451,112 @@ func (s *Storage) runQuery(stopCh <-chan struct{}, qctx *logstorage.QueryContext
 	return getFirstError(errs, qctx.AllowPartialResponse)
 }
 
+// runReplicatedQuery runs qctx at storage nodes, which contain replicated logs.
+//
+// Every log stream is queried only at the storage node, which owns it. See logstorage.StreamOwnerFilter.
+// If some storage node becomes unavailable before returning any data, then the log streams owned by this node
+// are queried at the next storage nodes with the replicas of these log streams.
+func (s *Storage) runReplicatedQuery(stopCh <-chan struct{}, qctx *logstorage.QueryContext, writeBlock logstorage.WriteDataBlockFunc) error {
+	ctxWithCancel, cancel := contextutil.NewStopChanContext(stopCh)
+	defer cancel()
+
+	unavailableNodes := s.getUnavailableNodeIdxs()
+	if len(unavailableNodes) >= s.replicationFactor {
+		// Some of the unavailable nodes may become available. Re-check all the nodes then.
+		unavailableNodes = nil
+	}
+
+	var failedNodes []int
+	for round := 0; ; round++ {
+		// Use distinct workerID per every node and round, since query pipes may expect that the data for the same workerID
+		// is received from a single storage node. See pipeStats.
+		workerIDOffset := uint(round * len(s.sns))
+
+		errs := make([]error, len(s.sns))
+		var newFailedNodes []int
+		var newFailedNodesLock sync.Mutex
+
+		var wg sync.WaitGroup
+		for nodeIdx := range s.sns {
+			if slices.Contains(unavailableNodes, nodeIdx) || slices.Contains(failedNodes, nodeIdx) {
+				continue
+			}
+			wg.Go(func() {
+				sn := s.sns[nodeIdx]
+				qctxLocal := qctx.WithContext(ctxWithCancel)
+				qctxLocal.StreamOwnerFilter = &logstorage.StreamOwnerFilter{
+					NodesCount:        len(s.sns),
+					ReplicationFactor: s.replicationFactor,
+					NodeIdx:           nodeIdx,
+					UnavailableNodes:  unavailableNodes,
+					FailedNodes:       failedNodes,
+				}
+
+				blocksReceived := false
+				err := sn.runQuery(qctxLocal, func(db *logstorage.DataBlock) {
+					blocksReceived = true
+					writeBlock(workerIDOffset+uint(nodeIdx), db)
+				})
+				if err != nil && !blocksReceived && isUnavailableBackendError(err) && ctxWithCancel.Err() == nil {
+					// Query the log streams owned by sn at other storage nodes.
+					sn.sendErrors.Inc()
+					sn.markUnavailable()
+
+					newFailedNodesLock.Lock()
+					newFailedNodes = append(newFailedNodes, nodeIdx)
+					newFailedNodesLock.Unlock()
+					return
+				}
+				errs[nodeIdx] = sn.handleError(ctxWithCancel, cancel, err, qctx.AllowPartialResponse)
+			})
+		}
+		wg.Wait()
+
+		if err := getFirstError(errs, qctx.AllowPartialResponse); err != nil {
+			return err
+		}
+		if len(newFailedNodes) == 0 {
+			return nil
+		}
+
+		unavailableNodes = append(unavailableNodes, failedNodes...)
+		failedNodes = newFailedNodes
+		unavailableNodesCount := len(unavailableNodes) + len(failedNodes)
+		if unavailableNodesCount >= len(s.sns) || (unavailableNodesCount >= s.replicationFactor && !qctx.AllowPartialResponse) {
+			return &httpserver.ErrorWithStatusCode{
+				Err: fmt.Errorf("cannot query all the logs, since %d out of %d vlstorage nodes are unavailable; -replicationFactor=%d",
+					unavailableNodesCount, len(s.sns), s.replicationFactor),
+				StatusCode: http.StatusBadGateway,
+			}
+		}
+	}
+}
+
+// getUnavailableNodeIdxs returns indexes of storage nodes, which were recently unavailable.
+func (s *Storage) getUnavailableNodeIdxs() []int {
+	var idxs []int
+	ct := fasttime.UnixTimestamp()
+	for nodeIdx, sn := range s.sns {
+		if sn.unavailableUntil.Load() > ct {
+			idxs = append(idxs, nodeIdx)
+		}
+	}
+	return idxs
+}
+
+// markUnavailable marks sn as unavailable for owning log streams during the next 10 seconds.
+func (sn *storageNode) markUnavailable() {
+	sn.unavailableUntil.Store(fasttime.UnixTimestamp() + 10)
+}
+
 // GetFieldNames executes qctx and returns field names seen in results.
 func (s *Storage) GetFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
+	if s.replicationFactor > 1 {
+		q := qctx.Query.Clone(qctx.Query.GetTimestamp())
+		q.AddFieldNamesPipe()
+		return s.runValuesWithHitsQuery(qctx.WithQuery(q), 0, false)
+	}
+
 	return s.getValuesWithHits(qctx, 0, false, func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error) {
 		qctxLocal := qctx.WithContext(ctx)
 		return sn.getFieldNames(qctxLocal)
@@ -432,6 +567,12 @@ func (s *Storage) GetFieldNames(qctx *logstorage.QueryContext) ([]logstorage.Val
 //
 // If limit > 0, then up to limit unique values are returned.
 func (s *Storage) GetFieldValues(qctx *logstorage.QueryContext, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
+	if s.replicationFactor > 1 {
+		q := qctx.Query.Clone(qctx.Query.GetTimestamp())
+		q.AddFieldValuesPipe(fieldName, limit)
+		return s.runValuesWithHitsQuery(qctx.WithQuery(q), limit, true)
+	}
+
 	return s.getValuesWithHits(qctx, limit, true, func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error) {
 		qctxLocal := qctx.WithContext(ctx)
 		return sn.getFieldValues(qctxLocal, fieldName, limit)
@@ -440,6 +581,14 @@ func (s *Storage) GetFieldValues(qctx *logstorage.QueryContext, fieldName string
 
 // GetStreamFieldNames executes qctx and returns stream field names seen in results.
 func (s *Storage) GetStreamFieldNames(qctx *logstorage.QueryContext) ([]logstorage.ValueWithHits, error) {
+	if s.replicationFactor > 1 {
+		streams, err := s.GetStreams(qctx, math.MaxUint64)
+		if err != nil {
+			return nil, err
+		}
+		return logstorage.GetStreamFieldNamesFromStreams(streams), nil
+	}
+
 	return s.getValuesWithHits(qctx, 0, false, func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error) {
 		qctxLocal := qctx.WithContext(ctx)
 		return sn.getStreamFieldNames(qctxLocal)
@@ -450,6 +599,14 @@ func (s *Storage) GetStreamFieldNames(qctx *logstorage.QueryContext) ([]logstora
 //
 // If limit > 0, then up to limit unique stream field values are returned.
 func (s *Storage) GetStreamFieldValues(qctx *logstorage.QueryContext, fieldName string, limit uint64) ([]logstorage.ValueWithHits, error) {
+	if s.replicationFactor > 1 {
+		streams, err := s.GetStreams(qctx, math.MaxUint64)
+		if err != nil {
+			return nil, err
+		}
+		return logstorage.GetStreamFieldValuesFromStreams(streams, fieldName, limit), nil
+	}
+
 	return s.getValuesWithHits(qctx, limit, true, func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error) {
 		qctxLocal := qctx.WithContext(ctx)
 		return sn.getStreamFieldValues(qctxLocal, fieldName, limit)
@@ -460,6 +617,10 @@ func (s *Storage) GetStreamFieldValues(qctx *logstorage.QueryContext, fieldName
 //
 // If limit > 0, then up to limit unique streams are returned.
 func (s *Storage) GetStreams(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.ValueWithHits, error) {
+	if s.replicationFactor > 1 {
+		return s.GetFieldValues(qctx, "_stream", limit)
+	}
+
 	return s.getValuesWithHits(qctx, limit, true, func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error) {
 		qctxLocal := qctx.WithContext(ctx)
 		return sn.getStreams(qctxLocal, limit)
@@ -470,6 +631,10 @@ func (s *Storage) GetStreams(qctx *logstorage.QueryContext, limit uint64) ([]log
 //
 // If limit > 0, then up to limit unique streamIDs are returned.
 func (s *Storage) GetStreamIDs(qctx *logstorage.QueryContext, limit uint64) ([]logstorage.ValueWithHits, error) {
+	if s.replicationFactor > 1 {
+		return s.GetFieldValues(qctx, "_stream_id", limit)
+	}
+
 	return s.getValuesWithHits(qctx, limit, true, func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error) {
 		qctxLocal := qctx.WithContext(ctx)
 		return sn.getStreamIDs(qctxLocal, limit)
@@ -587,8 +752,7 @@ func (s *Storage) getTenantIDs(ctx context.Context, start, end int64) ([]logstor
 	results := make([][]logstorage.TenantID, len(s.sns))
 	errs := make([]error, len(s.sns))
 
-	// Return an error to the caller when at least a single storage node is unavailable,
-	// since this may result in incomplete list of the returned tenantIDs, which may mislead the caller.
+	// Return an error to the caller when the returned tenantIDs may be incomplete, since this may mislead the caller.
 	allowPartialResponse := false
 
 	var wg sync.WaitGroup
@@ -597,17 +761,12 @@ func (s *Storage) getTenantIDs(ctx context.Context, start, end int64) ([]logstor
 			sn := s.sns[nodeIdx]
 			tenantIDs, err := sn.getTenantIDs(ctxWithCancel, start, end)
 			results[nodeIdx] = tenantIDs
-			errs[nodeIdx] = sn.handleError(ctxWithCancel, cancel, err, allowPartialResponse)
-
-			if err != nil {
-				// Cancel the remaining parallel requests
-				cancel()
-			}
+			errs[nodeIdx] = sn.handleError(ctxWithCancel, cancel, err, s.canSkipUnavailableNodes(allowPartialResponse))
 		})
 	}
 	wg.Wait()
 
-	if err := getFirstError(errs, allowPartialResponse); err != nil {
+	if err := s.getFirstQueryError(errs, allowPartialResponse); err != nil {
 		return nil, err
 	}
 
@@ -627,6 +786,48 @@ func (s *Storage) getTenantIDs(ctx context.Context, start, end int64) ([]logstor
 	return tenantIDs, nil
 }
 
+// runValuesWithHitsQuery runs qctx, which must return (value, hits) columns, via RunQuery.
+//
+// This is used instead of getValuesWithHits when the rows must be deduplicated among replicated storage nodes,
+// since hits calculated at storage nodes cannot be deduplicated.
+func (s *Storage) runValuesWithHitsQuery(qctx *logstorage.QueryContext, limit uint64, resetHitsOnLimitExceeded bool) ([]logstorage.ValueWithHits, error) {
+	var results [][]logstorage.ValueWithHits
+	var resultsLock sync.Mutex
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		rowsCount := db.RowsCount()
+		if rowsCount == 0 {
+			return
+		}
+		if len(db.Columns) != 2 {
+			logger.Panicf("BUG: expecting two columns; got %d columns", len(db.Columns))
+		}
+
+		columnValues := db.Columns[0].Values
+		columnHits := db.Columns[1].Values
+
+		vhs := make([]logstorage.ValueWithHits, rowsCount)
+		for i := range vhs {
+			hits, _ := strconv.ParseUint(columnHits[i], 10, 64)
+			vhs[i] = logstorage.ValueWithHits{
+				Value: strings.Clone(columnValues[i]),
+				Hits:  hits,
+			}
+		}
+
+		resultsLock.Lock()
+		results = append(results, vhs)
+		resultsLock.Unlock()
+	}
+
+	if err := s.RunQuery(qctx, writeBlock); err != nil {
+		return nil, err
+	}
+
+	vhs := logstorage.MergeValuesWithHits(results, limit, resetHitsOnLimitExceeded)
+
+	return vhs, nil
+}
+
 func (s *Storage) getValuesWithHits(qctx *logstorage.QueryContext, limit uint64, resetHitsOnLimitExceeded bool,
 	callback func(ctx context.Context, sn *storageNode) ([]logstorage.ValueWithHits, error)) ([]logstorage.ValueWithHits, error) {
 
@@ -642,12 +843,12 @@ func (s *Storage) getValuesWithHits(qctx *logstorage.QueryContext, limit uint64,
 			sn := s.sns[nodeIdx]
 			vhs, err := callback(ctxWithCancel, sn)
 			results[nodeIdx] = vhs
-			errs[nodeIdx] = sn.handleError(ctxWithCancel, cancel, err, qctx.AllowPartialResponse)
+			errs[nodeIdx] = sn.handleError(ctxWithCancel, cancel, err, s.canSkipUnavailableNodes(qctx.AllowPartialResponse))
 		})
 	}
 	wg.Wait()
 
-	if err := getFirstError(errs, qctx.AllowPartialResponse); err != nil {
+	if err := s.getFirstQueryError(errs, qctx.AllowPartialResponse); err != nil {
 		return nil, err
 	}
 
@@ -748,6 +949,35 @@ func (sn *storageNode) handleError(ctx context.Context, cancel func(), err error
 	return err
 }
 
+// canSkipUnavailableNodes returns true if the query may continue when some of storage nodes are unavailable.
+func (s *Storage) canSkipUnavailableNodes(allowPartialResponse bool) bool {
+	return allowPartialResponse || s.replicationFactor > 1
+}
+
+// getFirstQueryError returns the first error from errs obtained from the query executed at all the storage nodes.
+//
+// It ignores errors from up to replicationFactor-1 unavailable storage nodes, since the remaining storage nodes
+// contain copies of all the logs in this case.
+func (s *Storage) getFirstQueryError(errs []error, allowPartialResponse bool) error {
+	if s.replicationFactor > 1 && !allowPartialResponse {
+		unavailableNodes := 0
+		for _, err := range errs {
+			if err == nil {
+				continue
+			}
+			if !isUnavailableBackendError(err) {
+				return err
+			}
+			unavailableNodes++
+		}
+		if unavailableNodes < s.replicationFactor {
+			return nil
+		}
+	}
+
+	return getFirstError(errs, allowPartialResponse)
+}
+
 func getFirstError(errs []error, allowPartialResponse bool) error {
 	if len(errs) == 0 {
 		logger.Panicf("BUG: len(errs) must be bigger than 0")
@@ -0,0 +1,712 @@
+package vlstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httputil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/promauth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/ratelimiter"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+	"github.com/VictoriaMetrics/metrics"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlstorage/netinsert"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vlstorage/netselect"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var (
+	activeRebalances          = metrics.NewCounter(`vl_active_rebalances`)
+	rebalancePartitionsMoved  = metrics.NewCounter(`vl_rebalance_partitions_moved_total`)
+	rebalanceRowsMoved        = metrics.NewCounter(`vl_rebalance_rows_moved_total`)
+	rebalanceBytesMoved       = metrics.NewCounter(`vl_rebalance_bytes_moved_total`)
+	rebalanceRateLimitReached = metrics.NewCounter(`vl_rebalance_rate_limit_reached_total`)
+	rebalanceErrors           = metrics.NewCounter(`vl_rebalance_errors_total`)
+)
+
+const nsecsPerDay = 24 * 3600 * 1e9
+
+// rebalanceDeleteTaskCheckInterval is the interval for checking whether the delete task for the moved logs is finished at src.
+const rebalanceDeleteTaskCheckInterval = time.Second
+
+var (
+	rebalanceLock sync.Mutex
+
+	// rebalanceLast is the last started rebalance task. It is used for reporting rebalance status.
+	rebalanceLast *rebalanceTask
+)
+
+// rebalanceTask moves per-day partitions from the src storage node to the dst storage nodes.
+type rebalanceTask struct {
+	src string
+	dst []string
+
+	partitionPrefix         string
+	maxRowsPerSecond        int
+	includeActivePartitions bool
+
+	// ctx is canceled when the rebalance must be stopped immediately, e.g. on graceful shutdown.
+	ctx    context.Context
+	cancel func()
+
+	// stopRequested is set when the rebalance must be stopped after the current partition for the current tenant is moved.
+	stopRequested atomic.Bool
+
+	doneCh chan struct{}
+
+	statusLock sync.Mutex
+	status     rebalanceStatus
+}
+
+// rebalanceStatus is the status of the rebalance returned from /internal/rebalance/status.
+type rebalanceStatus struct {
+	// State is one of running, stopping, stopped, finished or failed.
+	State string `json:"state"`
+
+	Src                     string   `json:"src"`
+	Dst                     []string `json:"dst"`
+	PartitionPrefix         string   `json:"partition_prefix,omitempty"`
+	MaxRowsPerSecond        int      `json:"max_rows_per_second,omitempty"`
+	IncludeActivePartitions bool     `json:"include_active_partitions,omitempty"`
+
+	StartTime  time.Time `json:"start_time"`
+	FinishTime time.Time `json:"finish_time,omitzero"`
+
+	PartitionsTotal  int    `json:"partitions_total"`
+	PartitionsMoved  int    `json:"partitions_moved"`
+	CurrentPartition string `json:"current_partition,omitempty"`
+	RowsMoved        uint64 `json:"rows_moved"`
+	BytesMoved       uint64 `json:"bytes_moved"`
+
+	Error string `json:"error,omitempty"`
+}
+
+var errRebalanceStopped = errors.New("the rebalance has been stopped")
+
+func processRebalanceStart(w http.ResponseWriter, r *http.Request) bool {
+	if netstorageSelect == nil {
+		// Rebalancing is supported only in cluster mode
+		return false
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, rebalanceAuthKey) {
+		return true
+	}
+
+	maxRowsPerSecond, err := httputil.GetInt(r, "max_rows_per_second")
+	if err != nil {
+		httpserver.Errorf(w, r, "cannot parse 'max_rows_per_second' query arg: %s", err)
+		return true
+	}
+	includeActivePartitions := httputil.GetBool(r, "include_active_partitions")
+
+	rt, err := newRebalanceTask(r.FormValue("src"), r.FormValue("dst"), r.FormValue("partition_prefix"), maxRowsPerSecond, includeActivePartitions)
+	if err != nil {
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+	if err := startRebalance(rt); err != nil {
+		rt.cancel()
+		httpserver.Errorf(w, r, "%s", err)
+		return true
+	}
+
+	writeJSONResponse(w, rt.getStatus())
+	return true
+}
+
+func processRebalanceStatus(w http.ResponseWriter, r *http.Request) bool {
+	if netstorageSelect == nil {
+		// Rebalancing is supported only in cluster mode
+		return false
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, rebalanceAuthKey) {
+		return true
+	}
+
+	rebalanceLock.Lock()
+	rt := rebalanceLast
+	rebalanceLock.Unlock()
+
+	if rt == nil {
+		writeJSONResponse(w, &rebalanceStatus{
+			State: "idle",
+		})
+		return true
+	}
+
+	writeJSONResponse(w, rt.getStatus())
+	return true
+}
+
+func processRebalanceStop(w http.ResponseWriter, r *http.Request) bool {
+	if netstorageSelect == nil {
+		// Rebalancing is supported only in cluster mode
+		return false
+	}
+
+	if !httpserver.CheckAuthFlag(w, r, rebalanceAuthKey) {
+		return true
+	}
+
+	rebalanceLock.Lock()
+	rt := rebalanceLast
+	rebalanceLock.Unlock()
+
+	if rt == nil {
+		httpserver.Errorf(w, r, "there is no running rebalance")
+		return true
+	}
+
+	rt.requestStop()
+	writeJSONResponse(w, rt.getStatus())
+	return true
+}
+
+// newRebalanceTask returns new rebalance task for moving partitions from src to dst.
+//
+// dst must contain comma-separated list of storage nodes. All the -storageNode nodes except src are used if dst is empty.
+//
+// The rebalancing isn't supported with -replicationFactor > 1, since the moved logs land at dst nodes, which do not own their log streams.
+func newRebalanceTask(src, dst, partitionPrefix string, maxRowsPerSecond int, includeActivePartitions bool) (*rebalanceTask, error) {
+	if *replicationFactor > 1 {
+		return nil, fmt.Errorf("the rebalancing cannot be used with -replicationFactor=%d, since queries would miss the moved logs; "+
+			"see https://docs.victoriametrics.com/victorialogs/cluster/#online-rebalancing", *replicationFactor)
+	}
+	if src == "" {
+		return nil, fmt.Errorf("missing `src` query arg; it must contain the -storageNode address to move logs from")
+	}
+	if !slices.Contains(*storageNodeAddrs, src) {
+		return nil, fmt.Errorf("src=%q must be in the -storageNode list %q", src, *storageNodeAddrs)
+	}
+
+	var dstAddrs []string
+	if dst == "" {
+		for _, addr := range *storageNodeAddrs {
+			if addr != src {
+				dstAddrs = append(dstAddrs, addr)
+			}
+		}
+	} else {
+		for _, addr := range strings.Split(dst, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr == src {
+				return nil, fmt.Errorf("dst=%q cannot contain src=%q", dst, src)
+			}
+			if !slices.Contains(*storageNodeAddrs, addr) {
+				return nil, fmt.Errorf("dst node %q must be in the -storageNode list %q", addr, *storageNodeAddrs)
+			}
+			if !slices.Contains(dstAddrs, addr) {
+				dstAddrs = append(dstAddrs, addr)
+			}
+		}
+	}
+	if len(dstAddrs) == 0 {
+		return nil, fmt.Errorf("there are no dst nodes to move logs to from src=%q", src)
+	}
+
+	if maxRowsPerSecond < 0 {
+		return nil, fmt.Errorf("max_rows_per_second cannot be negative; got %d", maxRowsPerSecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := &rebalanceTask{
+		src:                     src,
+		dst:                     dstAddrs,
+		partitionPrefix:         partitionPrefix,
+		maxRowsPerSecond:        maxRowsPerSecond,
+		includeActivePartitions: includeActivePartitions,
+
+		ctx:    ctx,
+		cancel: cancel,
+		doneCh: make(chan struct{}),
+	}
+	rt.status = rebalanceStatus{
+		State:                   "running",
+		Src:                     src,
+		Dst:                     dstAddrs,
+		PartitionPrefix:         partitionPrefix,
+		MaxRowsPerSecond:        maxRowsPerSecond,
+		IncludeActivePartitions: includeActivePartitions,
+		StartTime:               time.Now().UTC(),
+	}
+	return rt, nil
+}
+
+func startRebalance(rt *rebalanceTask) error {
+	rebalanceLock.Lock()
+	defer rebalanceLock.Unlock()
+
+	if rebalanceLast != nil && !rebalanceLast.isDone() {
+		return fmt.Errorf("cannot start new rebalance, since the previous rebalance from src=%q is still running; "+
+			"stop it via /internal/rebalance/stop or wait until it is finished", rebalanceLast.src)
+	}
+	rebalanceLast = rt
+
+	go rt.run()
+	return nil
+}
+
+// stopRebalance stops the running rebalance immediately.
+//
+// It is called on graceful shutdown.
+func stopRebalance() {
+	rebalanceLock.Lock()
+	rt := rebalanceLast
+	rebalanceLock.Unlock()
+
+	if rt == nil {
+		return
+	}
+	rt.requestStop()
+	rt.cancel()
+	<-rt.doneCh
+}
+
+func (rt *rebalanceTask) isDone() bool {
+	select {
+	case <-rt.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rt *rebalanceTask) requestStop() {
+	rt.stopRequested.Store(true)
+
+	rt.statusLock.Lock()
+	if rt.status.State == "running" {
+		rt.status.State = "stopping"
+	}
+	rt.statusLock.Unlock()
+}
+
+func (rt *rebalanceTask) getStatus() *rebalanceStatus {
+	rt.statusLock.Lock()
+	status := rt.status
+	rt.statusLock.Unlock()
+
+	return &status
+}
+
+func (rt *rebalanceTask) run() {
+	activeRebalances.Inc()
+	defer activeRebalances.Dec()
+	defer rt.cancel()
+
+	logger.Infof("started rebalance from src=%q to dst=%q for partition_prefix=%q", rt.src, rt.dst, rt.partitionPrefix)
+	startTime := time.Now()
+
+	err := rt.runInternal()
+
+	rt.statusLock.Lock()
+	rt.status.FinishTime = time.Now().UTC()
+	rt.status.CurrentPartition = ""
+	switch {
+	case err == nil:
+		rt.status.State = "finished"
+		logger.Infof("rebalance from src=%q to dst=%q has been finished in %.3f seconds; moved %d partitions with %d rows",
+			rt.src, rt.dst, time.Since(startTime).Seconds(), rt.status.PartitionsMoved, rt.status.RowsMoved)
+	case errors.Is(err, errRebalanceStopped):
+		rt.status.State = "stopped"
+		logger.Infof("rebalance from src=%q to dst=%q has been stopped after %.3f seconds; moved %d partitions with %d rows",
+			rt.src, rt.dst, time.Since(startTime).Seconds(), rt.status.PartitionsMoved, rt.status.RowsMoved)
+	default:
+		rebalanceErrors.Inc()
+		rt.status.State = "failed"
+		rt.status.Error = err.Error()
+		logger.Errorf("rebalance from src=%q to dst=%q has been failed after %.3f seconds: %s", rt.src, rt.dst, time.Since(startTime).Seconds(), err)
+	}
+	rt.statusLock.Unlock()
+
+	close(rt.doneCh)
+}
+
+func (rt *rebalanceTask) runInternal() error {
+	srcIdx := slices.Index(*storageNodeAddrs, rt.src)
+	srcStorage := netselect.NewStorage([]string{rt.src}, []*promauth.Config{storageNodeAuthCfgs[srcIdx]}, []bool{storageNodeIsTLSs[srcIdx]},
+		*selectDisableCompression, 1)
+	defer srcStorage.MustStop()
+
+	dstAuthCfgs := make([]*promauth.Config, len(rt.dst))
+	dstIsTLSs := make([]bool, len(rt.dst))
+	for i, addr := range rt.dst {
+		idx := slices.Index(*storageNodeAddrs, addr)
+		dstAuthCfgs[i] = storageNodeAuthCfgs[idx]
+		dstIsTLSs[i] = storageNodeIsTLSs[idx]
+	}
+
+	// Every log entry is moved to a single dst node, since the remaining replicas (if any) stay at their nodes.
+	dstStorage := netinsert.NewStorage(rt.dst, dstAuthCfgs, dstIsTLSs, *insertConcurrency, *insertDisableCompression, 1)
+	defer dstStorage.MustStop()
+
+	// dstQueryStorage is used for verifying that the moved logs are stored at dst nodes before deleting them from src.
+	dstQueryStorage := netselect.NewStorage(rt.dst, dstAuthCfgs, dstIsTLSs, *selectDisableCompression, 1)
+	defer dstQueryStorage.MustStop()
+
+	// Verify that the moved logs can be deleted from src before copying them to dst.
+	if _, err := srcStorage.DeleteActiveTasks(rt.ctx); err != nil {
+		return fmt.Errorf("cannot access delete API at src=%q; make sure it runs with -internaldelete.enable command-line flag: %w", rt.src, err)
+	}
+
+	rl := ratelimiter.New(int64(rt.maxRowsPerSecond), rebalanceRateLimitReached, rt.ctx.Done())
+
+	partitions, err := rt.getPartitions(srcStorage)
+	if err != nil {
+		return fmt.Errorf("cannot obtain partitions to move from src=%q: %w", rt.src, err)
+	}
+
+	rt.statusLock.Lock()
+	rt.status.PartitionsTotal = len(partitions)
+	rt.statusLock.Unlock()
+
+	for _, pt := range partitions {
+		rt.statusLock.Lock()
+		rt.status.CurrentPartition = pt.name
+		rt.statusLock.Unlock()
+
+		for _, tenantID := range pt.tenantIDs {
+			if rt.stopRequested.Load() {
+				return errRebalanceStopped
+			}
+			if err := rt.moveTenantPartition(srcStorage, dstStorage, dstQueryStorage, rl, pt, tenantID); err != nil {
+				return fmt.Errorf("cannot move logs for tenant %s at partition %s: %w", tenantID, pt.name, err)
+			}
+		}
+
+		rebalancePartitionsMoved.Inc()
+		rt.statusLock.Lock()
+		rt.status.PartitionsMoved++
+		rt.statusLock.Unlock()
+
+		logger.Infof("moved partition %s from src=%q to dst=%q", pt.name, rt.src, rt.dst)
+	}
+	return nil
+}
+
+// rebalancePartition is a per-day partition to move.
+type rebalancePartition struct {
+	// name is the partition name in the YYYYMMDD format.
+	name string
+
+	// day is the start of the day for the partition in nanoseconds.
+	day int64
+
+	// tenantIDs contains tenants with logs at the partition.
+	tenantIDs []logstorage.TenantID
+}
+
+// getFilter returns LogsQL filter for selecting all the logs at pt.
+func (pt *rebalancePartition) getFilter() string {
+	start := time.Unix(0, pt.day).UTC().Format(time.RFC3339)
+	end := time.Unix(0, pt.day+nsecsPerDay).UTC().Format(time.RFC3339)
+	return fmt.Sprintf("_time:[%s, %s)", start, end)
+}
+
+// getPartitions returns partitions to move from src ordered by day.
+func (rt *rebalanceTask) getPartitions(src *netselect.Storage) ([]*rebalancePartition, error) {
+	tenantIDs, err := src.GetTenantIDs(rt.ctx, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain tenants: %w", err)
+	}
+	sort.Slice(tenantIDs, func(i, j int) bool {
+		a, b := &tenantIDs[i], &tenantIDs[j]
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		return a.ProjectID < b.ProjectID
+	})
+
+	// Active partitions may receive newly ingested logs during the move, so they are skipped by default.
+	minActiveDay := time.Now().UnixNano() / nsecsPerDay * nsecsPerDay
+
+	m := make(map[int64]*rebalancePartition)
+	for _, tenantID := range tenantIDs {
+		var days []int64
+		err := runRebalanceQuery(rt.ctx, src, tenantID, "* | stats by (_time:1d) count() rows", func(db *logstorage.DataBlock) error {
+			c := db.GetColumnByName("_time")
+			if c == nil {
+				return fmt.Errorf("missing _time column in the response")
+			}
+			for _, v := range c.Values {
+				day, ok := logstorage.TryParseTimestampRFC3339Nano(v)
+				if !ok {
+					return fmt.Errorf("cannot parse _time=%q", v)
+				}
+				days = append(days, day)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain partitions for tenant %s: %w", tenantID, err)
+		}
+
+		for _, day := range days {
+			if day >= minActiveDay && !rt.includeActivePartitions {
+				continue
+			}
+			name := time.Unix(0, day).UTC().Format("20060102")
+			if !strings.HasPrefix(name, rt.partitionPrefix) {
+				continue
+			}
+			pt := m[day]
+			if pt == nil {
+				pt = &rebalancePartition{
+					name: name,
+					day:  day,
+				}
+				m[day] = pt
+			}
+			pt.tenantIDs = append(pt.tenantIDs, tenantID)
+		}
+	}
+
+	partitions := make([]*rebalancePartition, 0, len(m))
+	for _, pt := range m {
+		partitions = append(partitions, pt)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].day < partitions[j].day
+	})
+	return partitions, nil
+}
+
+// moveTenantPartition moves logs for the given tenantID at the given pt from src to dst.
+//
+// Logs are copied to dst at first. Then they are deleted from src if the number of logs at src didn't change during the copying
+// and all the copied logs are available for querying at dst. dstQuery must be used for querying the same nodes as dst.
+func (rt *rebalanceTask) moveTenantPartition(src *netselect.Storage, dst *netinsert.Storage, dstQuery *netselect.Storage, rl *ratelimiter.RateLimiter,
+	pt *rebalancePartition, tenantID logstorage.TenantID) error {
+	filter := pt.getFilter()
+
+	// dst nodes may already contain logs for the given tenant and partition, so take them into account when verifying the copied logs.
+	rowsAtDstBefore, err := getRebalanceRowsCount(rt.ctx, dstQuery, tenantID, filter)
+	if err != nil {
+		return fmt.Errorf("cannot obtain the number of logs at dst: %w", err)
+	}
+
+	var rowsCopied atomic.Uint64
+	err = runRebalanceQuery(rt.ctx, src, tenantID, filter, func(db *logstorage.DataBlock) error {
+		rowsCount := db.RowsCount()
+		rl.Register(rowsCount)
+
+		var r logstorage.InsertRow
+		bytesCopied := 0
+		for rowIdx := 0; rowIdx < rowsCount; rowIdx++ {
+			if err := initInsertRowFromDataBlock(&r, tenantID, db, rowIdx); err != nil {
+				return err
+			}
+			dst.AddRow(r.GetStreamHash(), &r)
+
+			for _, f := range r.Fields {
+				bytesCopied += len(f.Name) + len(f.Value)
+			}
+		}
+		rowsCopied.Add(uint64(rowsCount))
+
+		rebalanceRowsMoved.Add(rowsCount)
+		rebalanceBytesMoved.Add(bytesCopied)
+		rt.statusLock.Lock()
+		rt.status.RowsMoved += uint64(rowsCount)
+		rt.status.BytesMoved += uint64(bytesCopied)
+		rt.statusLock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot copy logs: %w", err)
+	}
+
+	// Make sure the copied logs are delivered to dst and are available for querying there before deleting them from src.
+	if err := dst.Flush(); err != nil {
+		return fmt.Errorf("cannot flush the copied logs to dst: %w", err)
+	}
+
+	// Verify that src didn't receive new logs for the given partition while copying it.
+	// Otherwise these logs would be lost after the deletion.
+	n := rowsCopied.Load()
+	rowsAtSrc, err := getRebalanceRowsCount(rt.ctx, src, tenantID, filter)
+	if err != nil {
+		return fmt.Errorf("cannot obtain the number of logs at src: %w", err)
+	}
+	if rowsAtSrc != n {
+		return fmt.Errorf("the number of logs at src=%q has been changed from %d to %d during the move; "+
+			"make sure src doesn't receive new logs for the moved partitions; the copied logs aren't deleted from src, so they may be duplicated at dst", rt.src, n, rowsAtSrc)
+	}
+
+	// Verify that all the copied logs are stored at dst. Otherwise they would be lost after the deletion from src.
+	rowsAtDst, err := getRebalanceRowsCount(rt.ctx, dstQuery, tenantID, filter)
+	if err != nil {
+		return fmt.Errorf("cannot obtain the number of logs at dst: %w", err)
+	}
+	if rowsAtDstExpected := rowsAtDstBefore + n; rowsAtDst != rowsAtDstExpected {
+		return fmt.Errorf("unexpected number of logs at dst=%q after copying %d logs; got %d; want %d; "+
+			"make sure dst doesn't receive new logs for the moved partitions; the copied logs aren't deleted from src, so they may be duplicated at dst", rt.dst, n, rowsAtDst, rowsAtDstExpected)
+	}
+
+	f, err := logstorage.ParseFilter(filter)
+	if err != nil {
+		logger.Panicf("BUG: cannot parse filter [%s]: %s", filter, err)
+	}
+	taskID := fmt.Sprintf("rebalance_%s_%d_%d_%d", pt.name, tenantID.AccountID, tenantID.ProjectID, time.Now().UnixNano())
+	if err := src.DeleteRunTask(rt.ctx, taskID, time.Now().UnixNano(), []logstorage.TenantID{tenantID}, f); err != nil {
+		return fmt.Errorf("cannot start deleting the moved logs from src: %w", err)
+	}
+	return waitForDeleteTask(rt.ctx, src, taskID)
+}
+
+// waitForDeleteTask waits until the delete task with the given taskID is finished at s.
+func waitForDeleteTask(ctx context.Context, s *netselect.Storage, taskID string) error {
+	for {
+		tasks, err := s.DeleteActiveTasks(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot obtain active delete tasks: %w", err)
+		}
+		isActive := slices.ContainsFunc(tasks, func(dt *logstorage.DeleteTask) bool {
+			return dt.TaskID == taskID
+		})
+		if !isActive {
+			return nil
+		}
+
+		t := timerpool.Get(rebalanceDeleteTaskCheckInterval)
+		select {
+		case <-ctx.Done():
+			timerpool.Put(t)
+			return ctx.Err()
+		case <-t.C:
+			timerpool.Put(t)
+		}
+	}
+}
+
+func getRebalanceRowsCount(ctx context.Context, s *netselect.Storage, tenantID logstorage.TenantID, filter string) (uint64, error) {
+	var rowsCount uint64
+	err := runRebalanceQuery(ctx, s, tenantID, filter+" | stats count() rows", func(db *logstorage.DataBlock) error {
+		c := db.GetColumnByName("rows")
+		if c == nil {
+			return fmt.Errorf("missing `rows` column in the response")
+		}
+		for _, v := range c.Values {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("cannot parse rows=%q: %w", v, err)
+			}
+			rowsCount += n
+		}
+		return nil
+	})
+	return rowsCount, err
+}
+
+// runRebalanceQuery runs the given LogsQL query for the given tenantID at s and calls processBlock for the returned data blocks.
+//
+// processBlock may be called concurrently. The first error returned from processBlock is returned from runRebalanceQuery.
+func runRebalanceQuery(ctx context.Context, s *netselect.Storage, tenantID logstorage.TenantID, query string, processBlock func(db *logstorage.DataBlock) error) error {
+	q, err := logstorage.ParseQuery(query)
+	if err != nil {
+		logger.Panicf("BUG: cannot parse query [%s]: %s", query, err)
+	}
+
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errLock sync.Mutex
+	var firstErr error
+	writeBlock := func(_ uint, db *logstorage.DataBlock) {
+		if db.RowsCount() == 0 {
+			return
+		}
+		errLock.Lock()
+		hasErr := firstErr != nil
+		errLock.Unlock()
+		if hasErr {
+			return
+		}
+
+		if err := processBlock(db); err != nil {
+			errLock.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errLock.Unlock()
+			cancel()
+		}
+	}
+
+	var qs logstorage.QueryStats
+	qctx := logstorage.NewQueryContext(ctxWithCancel, &qs, []logstorage.TenantID{tenantID}, q, false, nil)
+	err = s.RunQuery(qctx, writeBlock)
+
+	errLock.Lock()
+	defer errLock.Unlock()
+	if firstErr != nil {
+		return firstErr
+	}
+	return err
+}
+
+// initInsertRowFromDataBlock initializes r from the row at rowIdx in db returned by `*` query.
+//
+// r refers db contents, so it must be used before db is changed.
+func initInsertRowFromDataBlock(r *logstorage.InsertRow, tenantID logstorage.TenantID, db *logstorage.DataBlock, rowIdx int) error {
+	r.Reset()
+	r.TenantID = tenantID
+
+	hasTime := false
+	hasStream := false
+	for _, c := range db.Columns {
+		v := c.Values[rowIdx]
+		switch c.Name {
+		case "_time":
+			ts, ok := logstorage.TryParseTimestampRFC3339Nano(v)
+			if !ok {
+				return fmt.Errorf("cannot parse _time=%q", v)
+			}
+			r.Timestamp = ts
+			hasTime = true
+		case "_stream":
+			streamFields, err := logstorage.ParseStreamFields(nil, v)
+			if err != nil {
+				return fmt.Errorf("cannot parse _stream=%q: %w", v, err)
+			}
+			st := logstorage.GetStreamTags()
+			for _, f := range streamFields {
+				st.Add(f.Name, f.Value)
+			}
+			r.StreamTagsCanonical = string(st.MarshalCanonical(nil))
+			logstorage.PutStreamTags(st)
+			hasStream = true
+		case "_stream_id":
+			// _stream_id is generated from _stream at the dst node.
+		default:
+			if v != "" {
+				r.Fields = append(r.Fields, logstorage.Field{
+					Name:  c.Name,
+					Value: v,
+				})
+			}
+		}
+	}
+
+	if !hasTime {
+		return fmt.Errorf("missing _time field")
+	}
+	if !hasStream {
+		return fmt.Errorf("missing _stream field")
+	}
+	return nil
+}
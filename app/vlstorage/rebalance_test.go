@@ -0,0 +1,230 @@
+package vlstorage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestInitInsertRowFromDataBlockSuccess(t *testing.T) {
+	f := func(columns []logstorage.BlockColumn, rowIdx int, rowExpected *logstorage.InsertRow) {
+		t.Helper()
+
+		db := &logstorage.DataBlock{
+			Columns: columns,
+		}
+		var r logstorage.InsertRow
+		if err := initInsertRowFromDataBlock(&r, rowExpected.TenantID, db, rowIdx); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(&r, rowExpected) {
+			t.Fatalf("unexpected row\ngot\n%+v\nwant\n%+v", &r, rowExpected)
+		}
+	}
+
+	streamTagsCanonical := func(fields ...logstorage.Field) string {
+		st := logstorage.GetStreamTags()
+		defer logstorage.PutStreamTags(st)
+		for _, f := range fields {
+			st.Add(f.Name, f.Value)
+		}
+		return string(st.MarshalCanonical(nil))
+	}
+
+	columns := []logstorage.BlockColumn{
+		{
+			Name:   "_time",
+			Values: []string{"2026-10-17T10:20:30.123456789Z", "2026-10-17T10:20:31Z"},
+		},
+		{
+			Name:   "_stream_id",
+			Values: []string{"0000000000000000e934a84adb05276890d7f7bfcadabe92", "0000000000000000e934a84adb05276890d7f7bfcadabe92"},
+		},
+		{
+			Name:   "_stream",
+			Values: []string{`{app="nginx",host="foo"}`, `{app="nginx",host="foo"}`},
+		},
+		{
+			Name:   "_msg",
+			Values: []string{"GET /", "POST /api"},
+		},
+		{
+			Name:   "app",
+			Values: []string{"nginx", "nginx"},
+		},
+		{
+			Name:   "host",
+			Values: []string{"foo", "foo"},
+		},
+		{
+			Name:   "status",
+			Values: []string{"", "500"},
+		},
+	}
+
+	f(columns, 0, &logstorage.InsertRow{
+		TenantID: logstorage.TenantID{
+			AccountID: 12,
+			ProjectID: 34,
+		},
+		StreamTagsCanonical: streamTagsCanonical(logstorage.Field{Name: "app", Value: "nginx"}, logstorage.Field{Name: "host", Value: "foo"}),
+		Timestamp:           1792232430123456789,
+		Fields: []logstorage.Field{
+			{Name: "_msg", Value: "GET /"},
+			{Name: "app", Value: "nginx"},
+			{Name: "host", Value: "foo"},
+		},
+	})
+	f(columns, 1, &logstorage.InsertRow{
+		StreamTagsCanonical: streamTagsCanonical(logstorage.Field{Name: "host", Value: "foo"}, logstorage.Field{Name: "app", Value: "nginx"}),
+		Timestamp:           1792232431000000000,
+		Fields: []logstorage.Field{
+			{Name: "_msg", Value: "POST /api"},
+			{Name: "app", Value: "nginx"},
+			{Name: "host", Value: "foo"},
+			{Name: "status", Value: "500"},
+		},
+	})
+
+	// empty stream
+	f([]logstorage.BlockColumn{
+		{
+			Name:   "_stream",
+			Values: []string{"{}"},
+		},
+		{
+			Name:   "_time",
+			Values: []string{"2026-10-17T00:00:00Z"},
+		},
+	}, 0, &logstorage.InsertRow{
+		StreamTagsCanonical: streamTagsCanonical(),
+		Timestamp:           1792195200000000000,
+	})
+}
+
+func TestInitInsertRowFromDataBlockFailure(t *testing.T) {
+	f := func(columns []logstorage.BlockColumn) {
+		t.Helper()
+
+		db := &logstorage.DataBlock{
+			Columns: columns,
+		}
+		var r logstorage.InsertRow
+		if err := initInsertRowFromDataBlock(&r, logstorage.TenantID{}, db, 0); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// missing _time
+	f([]logstorage.BlockColumn{
+		{
+			Name:   "_stream",
+			Values: []string{"{}"},
+		},
+	})
+
+	// missing _stream
+	f([]logstorage.BlockColumn{
+		{
+			Name:   "_time",
+			Values: []string{"2026-10-17T00:00:00Z"},
+		},
+	})
+
+	// invalid _time
+	f([]logstorage.BlockColumn{
+		{
+			Name:   "_time",
+			Values: []string{"foobar"},
+		},
+		{
+			Name:   "_stream",
+			Values: []string{"{}"},
+		},
+	})
+
+	// invalid _stream
+	f([]logstorage.BlockColumn{
+		{
+			Name:   "_time",
+			Values: []string{"2026-10-17T00:00:00Z"},
+		},
+		{
+			Name:   "_stream",
+			Values: []string{"foobar"},
+		},
+	})
+}
+
+func TestRebalancePartitionGetFilter(t *testing.T) {
+	pt := &rebalancePartition{
+		name: "20261017",
+		day:  1792195200000000000,
+	}
+	filter := pt.getFilter()
+	filterExpected := "_time:[2026-10-17T00:00:00Z, 2026-10-18T00:00:00Z)"
+	if filter != filterExpected {
+		t.Fatalf("unexpected filter; got %q; want %q", filter, filterExpected)
+	}
+	if _, err := logstorage.ParseFilter(filter); err != nil {
+		t.Fatalf("cannot parse filter %q: %s", filter, err)
+	}
+}
+
+func TestNewRebalanceTask(t *testing.T) {
+	addrsOrig := *storageNodeAddrs
+	*storageNodeAddrs = []string{"node1:9428", "node2:9428", "node3:9428"}
+	defer func() {
+		*storageNodeAddrs = addrsOrig
+	}()
+
+	fSuccess := func(src, dst string, dstExpected []string) {
+		t.Helper()
+
+		rt, err := newRebalanceTask(src, dst, "", 0, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		rt.cancel()
+		if !reflect.DeepEqual(rt.dst, dstExpected) {
+			t.Fatalf("unexpected dst nodes; got %q; want %q", rt.dst, dstExpected)
+		}
+	}
+
+	fSuccess("node1:9428", "", []string{"node2:9428", "node3:9428"})
+	fSuccess("node2:9428", "node3:9428", []string{"node3:9428"})
+	fSuccess("node2:9428", "node3:9428, node1:9428,node3:9428", []string{"node3:9428", "node1:9428"})
+
+	fFailure := func(src, dst string, maxRowsPerSecond int) {
+		t.Helper()
+
+		if _, err := newRebalanceTask(src, dst, "", maxRowsPerSecond, false); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// missing src
+	fFailure("", "", 0)
+
+	// unknown src
+	fFailure("node4:9428", "", 0)
+
+	// unknown dst
+	fFailure("node1:9428", "node4:9428", 0)
+
+	// dst contains src
+	fFailure("node1:9428", "node2:9428,node1:9428", 0)
+
+	// negative max_rows_per_second
+	fFailure("node1:9428", "", -1)
+
+	// replication is enabled
+	replicationFactorOrig := *replicationFactor
+	*replicationFactor = 2
+	defer func() {
+		*replicationFactor = replicationFactorOrig
+	}()
+	fFailure("node1:9428", "", 0)
+	fFailure("node1:9428", "node2:9428", 0)
+}
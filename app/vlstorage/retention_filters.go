@@ -0,0 +1,155 @@
+package vlstorage
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var retentionFiltersFile = flag.String("retention.filtersFile", "", "Optional path to a file with per-tenant and per-filter retention configs. "+
+	"Logs matching the configured filters are deleted before -retentionPeriod. The file is re-read on SIGHUP signal. "+
+	"See https://docs.victoriametrics.com/victorialogs/#retention-filters")
+
+var (
+	retentionFiltersReloads      = metrics.NewCounter(`vl_retention_filters_config_reloads_total`)
+	retentionFiltersReloadErrors = metrics.NewCounter(`vl_retention_filters_config_reloads_errors_total`)
+)
+
+// retentionFilterConfig represents a single entry at -retention.filtersFile
+type retentionFilterConfig struct {
+	// Tenants is an optional list of tenants in the form accountID:projectID the retention is applied to.
+	Tenants []string `yaml:"tenants,omitempty"`
+
+	// Filter is an optional LogsQL filter for logs the retention is applied to.
+	Filter string `yaml:"filter,omitempty"`
+
+	// Retention is the retention for logs matching Tenants and Filter.
+	Retention string `yaml:"retention"`
+}
+
+// parseRetentionFilters parses retention filters from YAML data.
+func parseRetentionFilters(data []byte) ([]logstorage.RetentionFilter, error) {
+	var cfgs []retentionFilterConfig
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+
+	rfs := make([]logstorage.RetentionFilter, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		rf, err := cfg.toRetentionFilter()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse retention filter #%d: %w", i+1, err)
+		}
+		rfs = append(rfs, rf)
+	}
+	return rfs, nil
+}
+
+func (cfg *retentionFilterConfig) toRetentionFilter() (logstorage.RetentionFilter, error) {
+	var rf logstorage.RetentionFilter
+
+	for _, s := range cfg.Tenants {
+		tenantID, err := logstorage.ParseTenantID(s)
+		if err != nil {
+			return rf, fmt.Errorf("cannot parse tenant %q: %w", s, err)
+		}
+		rf.TenantIDs = append(rf.TenantIDs, tenantID)
+	}
+
+	if cfg.Filter != "" {
+		f, err := logstorage.ParseFilter(cfg.Filter)
+		if err != nil {
+			return rf, fmt.Errorf("cannot parse filter [%s]: %w", cfg.Filter, err)
+		}
+		rf.Filter = f
+	}
+
+	if cfg.Retention == "" {
+		return rf, fmt.Errorf("missing `retention`")
+	}
+	nsecs, ok := logstorage.TryParseDuration(cfg.Retention)
+	if !ok {
+		return rf, fmt.Errorf("cannot parse retention=%q", cfg.Retention)
+	}
+	if nsecs < 24*time.Hour.Nanoseconds() {
+		return rf, fmt.Errorf("retention=%q cannot be smaller than a day", cfg.Retention)
+	}
+	rf.Retention = time.Duration(nsecs)
+
+	return rf, nil
+}
+
+func mustLoadRetentionFilters() []logstorage.RetentionFilter {
+	if *retentionFiltersFile == "" {
+		return nil
+	}
+	rfs, err := loadRetentionFilters(*retentionFiltersFile)
+	if err != nil {
+		logger.Fatalf("cannot load -retention.filtersFile: %s", err)
+	}
+	return rfs
+}
+
+func loadRetentionFilters(path string) ([]logstorage.RetentionFilter, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, err
+	}
+	rfs, err := parseRetentionFilters(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return rfs, nil
+}
+
+var (
+	retentionFiltersReloaderStopCh chan struct{}
+	retentionFiltersReloaderWG     sync.WaitGroup
+)
+
+func startRetentionFiltersReloader(s *logstorage.Storage) {
+	if *retentionFiltersFile == "" {
+		return
+	}
+
+	sighupCh := procutil.NewSighupChan()
+	retentionFiltersReloaderStopCh = make(chan struct{})
+	retentionFiltersReloaderWG.Go(func() {
+		for {
+			select {
+			case <-retentionFiltersReloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -retention.filtersFile=%q", *retentionFiltersFile)
+			retentionFiltersReloads.Inc()
+			rfs, err := loadRetentionFilters(*retentionFiltersFile)
+			if err != nil {
+				retentionFiltersReloadErrors.Inc()
+				logger.Errorf("cannot reload -retention.filtersFile; continuing using the previously loaded config; error: %s", err)
+				continue
+			}
+			s.UpdateRetentionFilters(rfs)
+			logger.Infof("successfully reloaded %d retention filters from -retention.filtersFile=%q", len(rfs), *retentionFiltersFile)
+		}
+	})
+}
+
+func stopRetentionFiltersReloader() {
+	if retentionFiltersReloaderStopCh == nil {
+		return
+	}
+	close(retentionFiltersReloaderStopCh)
+	retentionFiltersReloaderWG.Wait()
+	retentionFiltersReloaderStopCh = nil
+}
@@ -0,0 +1,88 @@
+package vlstorage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRetentionFiltersSuccess(t *testing.T) {
+	f := func(data string, resultExpected string) {
+		t.Helper()
+
+		rfs, err := parseRetentionFilters([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		a := make([]string, len(rfs))
+		for i := range rfs {
+			a[i] = rfs[i].String()
+		}
+		result := strings.Join(a, "\n")
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(``, ``)
+	f(`
+- retention: 3d
+`, `{tenants="*", filter="*", retention=72h0m0s}`)
+	f(`
+- tenants: ["12:34", "5"]
+  retention: 1w
+- tenants: ["0:0"]
+  filter: '{app="nginx"} level:debug'
+  retention: 2d
+`, `{tenants="12:34,5:0", filter="*", retention=168h0m0s}
+{tenants="0:0", filter="{app=\"nginx\"} level:debug", retention=48h0m0s}`)
+}
+
+func TestParseRetentionFiltersFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		rfs, err := parseRetentionFilters([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if rfs != nil {
+			t.Fatalf("expecting nil result; got %v", rfs)
+		}
+	}
+
+	// invalid YAML
+	f(`foo`)
+
+	// unknown field
+	f(`
+- retention: 3d
+  foo: bar
+`)
+
+	// missing retention
+	f(`
+- tenants: ["1:2"]
+`)
+
+	// invalid retention
+	f(`
+- retention: foo
+`)
+
+	// too small retention
+	f(`
+- retention: 1h
+`)
+
+	// invalid tenant
+	f(`
+- tenants: ["foo:bar"]
+  retention: 3d
+`)
+
+	// invalid filter
+	f(`
+- filter: 'foo | count()'
+  retention: 3d
+`)
+}
@@ -0,0 +1,321 @@
+package vlstorage
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs/fscore"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+	"gopkg.in/yaml.v2"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+var tenantQueryLimitsFile = flag.String("search.tenantLimitsFile", "", "Optional path to a file with per-tenant limits for query execution such as the number of concurrent queries, "+
+	"query duration, the number of bytes and blocks read by a single query and the memory used by a single query. The file is re-read on SIGHUP signal. "+
+	"See https://docs.victoriametrics.com/victorialogs/querying/#tenant-limits")
+
+var (
+	tenantQueryLimitsReloads      = metrics.NewCounter(`vl_tenant_query_limits_config_reloads_total`)
+	tenantQueryLimitsReloadErrors = metrics.NewCounter(`vl_tenant_query_limits_config_reloads_errors_total`)
+)
+
+// tenantQueryLimitsConfig represents limits for a single tenant at -search.tenantLimitsFile
+type tenantQueryLimitsConfig struct {
+	MaxConcurrentQueries int    `yaml:"max_concurrent_queries,omitempty"`
+	MaxQueryDuration     string `yaml:"max_query_duration,omitempty"`
+	MaxBytesRead         uint64 `yaml:"max_bytes_read,omitempty"`
+	MaxBlocksProcessed   uint64 `yaml:"max_blocks_processed,omitempty"`
+	MaxMemoryBytes       int64  `yaml:"max_memory_bytes,omitempty"`
+}
+
+// tenantQueryLimitsFileConfig represents the contents of -search.tenantLimitsFile
+type tenantQueryLimitsFileConfig struct {
+	// Default contains limits for tenants without explicitly configured limits at Tenants.
+	Default *tenantQueryLimitsConfig `yaml:"default,omitempty"`
+
+	// Tenants contains limits per tenant in the form accountID:projectID.
+	Tenants map[string]*tenantQueryLimitsConfig `yaml:"tenants,omitempty"`
+}
+
+// TenantQueryLimits contains limits for queries for a single tenant.
+//
+// Zero limit means there is no limit.
+type TenantQueryLimits struct {
+	// MaxConcurrentQueries is the maximum number of concurrently executed queries for the tenant.
+	MaxConcurrentQueries int
+
+	// MaxQueryDuration is the maximum query duration for the tenant.
+	MaxQueryDuration time.Duration
+
+	// QueryLimits contains limits for a single query execution for the tenant.
+	QueryLimits logstorage.QueryLimits
+}
+
+func (cfg *tenantQueryLimitsConfig) toTenantQueryLimits() (*TenantQueryLimits, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	tql := &TenantQueryLimits{
+		MaxConcurrentQueries: cfg.MaxConcurrentQueries,
+		QueryLimits: logstorage.QueryLimits{
+			MaxBytesRead:       cfg.MaxBytesRead,
+			MaxBlocksProcessed: cfg.MaxBlocksProcessed,
+			MaxMemoryBytes:     cfg.MaxMemoryBytes,
+		},
+	}
+	if cfg.MaxConcurrentQueries < 0 {
+		return nil, fmt.Errorf("max_concurrent_queries cannot be negative; got %d", cfg.MaxConcurrentQueries)
+	}
+	if cfg.MaxMemoryBytes < 0 {
+		return nil, fmt.Errorf("max_memory_bytes cannot be negative; got %d", cfg.MaxMemoryBytes)
+	}
+	if cfg.MaxQueryDuration != "" {
+		nsecs, ok := logstorage.TryParseDuration(cfg.MaxQueryDuration)
+		if !ok || nsecs < 0 {
+			return nil, fmt.Errorf("cannot parse max_query_duration=%q", cfg.MaxQueryDuration)
+		}
+		tql.MaxQueryDuration = time.Duration(nsecs)
+	}
+	if tql.isEmpty() {
+		return nil, nil
+	}
+	return tql, nil
+}
+
+func (tql *TenantQueryLimits) isEmpty() bool {
+	ql := &tql.QueryLimits
+	return tql.MaxConcurrentQueries == 0 && tql.MaxQueryDuration == 0 && ql.MaxBytesRead == 0 && ql.MaxBlocksProcessed == 0 && ql.MaxMemoryBytes == 0
+}
+
+// parsedTenantQueryLimits contains parsed tenantQueryLimitsFileConfig.
+type parsedTenantQueryLimits struct {
+	defaultLimits *TenantQueryLimits
+	tenants       map[logstorage.TenantID]*TenantQueryLimits
+}
+
+func (ptql *parsedTenantQueryLimits) getLimits(tenantID logstorage.TenantID) *TenantQueryLimits {
+	if tql, ok := ptql.tenants[tenantID]; ok {
+		return tql
+	}
+	return ptql.defaultLimits
+}
+
+func parseTenantQueryLimits(data []byte) (*parsedTenantQueryLimits, error) {
+	var cfg tenantQueryLimitsFileConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML: %w", err)
+	}
+
+	defaultLimits, err := cfg.Default.toTenantQueryLimits()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse default limits: %w", err)
+	}
+	ptql := &parsedTenantQueryLimits{
+		defaultLimits: defaultLimits,
+		tenants:       make(map[logstorage.TenantID]*TenantQueryLimits, len(cfg.Tenants)),
+	}
+	for s, tqlCfg := range cfg.Tenants {
+		tenantID, err := logstorage.ParseTenantID(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant %q: %w", s, err)
+		}
+		if _, ok := ptql.tenants[tenantID]; ok {
+			return nil, fmt.Errorf("duplicate limits for the tenant %q", s)
+		}
+		tql, err := tqlCfg.toTenantQueryLimits()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse limits for the tenant %q: %w", s, err)
+		}
+		// nil tql explicitly disables default limits for the given tenant.
+		ptql.tenants[tenantID] = tql
+	}
+	return ptql, nil
+}
+
+func loadTenantQueryLimits(path string) (*parsedTenantQueryLimits, error) {
+	data, err := fscore.ReadFileOrHTTP(path)
+	if err != nil {
+		return nil, err
+	}
+	ptql, err := parseTenantQueryLimits(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	return ptql, nil
+}
+
+var (
+	tenantQueryLimitsLock sync.Mutex
+	tenantQueryLimits     *parsedTenantQueryLimits
+
+	// tenantConcurrentQueries contains the number of concurrently executed queries per tenant.
+	tenantConcurrentQueries map[logstorage.TenantID]int
+
+	tenantQueryLimitsReloaderStopCh chan struct{}
+	tenantQueryLimitsReloaderWG     sync.WaitGroup
+)
+
+func mustInitTenantQueryLimits() {
+	if *tenantQueryLimitsFile == "" {
+		return
+	}
+
+	ptql, err := loadTenantQueryLimits(*tenantQueryLimitsFile)
+	if err != nil {
+		logger.Fatalf("cannot load -search.tenantLimitsFile: %s", err)
+	}
+	setTenantQueryLimits(ptql)
+
+	sighupCh := procutil.NewSighupChan()
+	tenantQueryLimitsReloaderStopCh = make(chan struct{})
+	tenantQueryLimitsReloaderWG.Go(func() {
+		for {
+			select {
+			case <-tenantQueryLimitsReloaderStopCh:
+				return
+			case <-sighupCh:
+			}
+
+			logger.Infof("SIGHUP received; reloading -search.tenantLimitsFile=%q", *tenantQueryLimitsFile)
+			tenantQueryLimitsReloads.Inc()
+			ptql, err := loadTenantQueryLimits(*tenantQueryLimitsFile)
+			if err != nil {
+				tenantQueryLimitsReloadErrors.Inc()
+				logger.Errorf("cannot reload -search.tenantLimitsFile; continuing using the previously loaded config; error: %s", err)
+				continue
+			}
+			setTenantQueryLimits(ptql)
+			logger.Infof("successfully reloaded -search.tenantLimitsFile=%q", *tenantQueryLimitsFile)
+		}
+	})
+}
+
+func mustStopTenantQueryLimits() {
+	if tenantQueryLimitsReloaderStopCh == nil {
+		return
+	}
+	close(tenantQueryLimitsReloaderStopCh)
+	tenantQueryLimitsReloaderWG.Wait()
+	tenantQueryLimitsReloaderStopCh = nil
+
+	setTenantQueryLimits(nil)
+}
+
+func setTenantQueryLimits(ptql *parsedTenantQueryLimits) {
+	tenantQueryLimitsLock.Lock()
+	tenantQueryLimits = ptql
+	tenantQueryLimitsLock.Unlock()
+}
+
+// GetTenantQueryLimits returns query limits for the given tenantID.
+//
+// nil is returned if there are no limits for the given tenantID.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#tenant-limits
+func GetTenantQueryLimits(tenantID logstorage.TenantID) *TenantQueryLimits {
+	if *tenantQueryLimitsFile == "" {
+		// Fast path - tenant limits are disabled.
+		return nil
+	}
+
+	tenantQueryLimitsLock.Lock()
+	defer tenantQueryLimitsLock.Unlock()
+
+	if tenantQueryLimits == nil {
+		return nil
+	}
+	return tenantQueryLimits.getLimits(tenantID)
+}
+
+// GetQueryLimits returns limits for a single query over the given tenantIDs.
+//
+// If multiple tenantIDs have limits, then the strictest limits are returned.
+// nil is returned if there are no limits for the given tenantIDs.
+func GetQueryLimits(tenantIDs []logstorage.TenantID) *logstorage.QueryLimits {
+	var result *logstorage.QueryLimits
+	for _, tenantID := range tenantIDs {
+		tql := GetTenantQueryLimits(tenantID)
+		if tql == nil {
+			continue
+		}
+		ql := &tql.QueryLimits
+		if result == nil {
+			qlCopy := *ql
+			result = &qlCopy
+			continue
+		}
+		result.MaxBytesRead = minNonZero(result.MaxBytesRead, ql.MaxBytesRead)
+		result.MaxBlocksProcessed = minNonZero(result.MaxBlocksProcessed, ql.MaxBlocksProcessed)
+		result.MaxMemoryBytes = minNonZero(result.MaxMemoryBytes, ql.MaxMemoryBytes)
+	}
+	return result
+}
+
+func minNonZero[T int64 | uint64](a, b T) T {
+	if a == 0 {
+		return b
+	}
+	if b == 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// AcquireTenantQuerySlot registers a new concurrently executed query for the given tenantID.
+//
+// It returns an error with http.StatusTooManyRequests status code if the tenant exceeds max_concurrent_queries limit.
+// Otherwise the returned release func must be called when the query is finished.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#tenant-limits
+func AcquireTenantQuerySlot(tenantID logstorage.TenantID) (func(), error) {
+	tql := GetTenantQueryLimits(tenantID)
+	if tql == nil || tql.MaxConcurrentQueries <= 0 {
+		return releaseTenantQuerySlotNoop, nil
+	}
+
+	tenantQueryLimitsLock.Lock()
+	defer tenantQueryLimitsLock.Unlock()
+
+	n := tenantConcurrentQueries[tenantID]
+	if n >= tql.MaxConcurrentQueries {
+		tenantQueriesRejectedTotal(tenantID).Inc()
+		err := fmt.Errorf("the tenant %d:%d exceeds max_concurrent_queries=%d limit; try again later; "+
+			"see https://docs.victoriametrics.com/victorialogs/querying/#tenant-limits", tenantID.AccountID, tenantID.ProjectID, tql.MaxConcurrentQueries)
+		return nil, &httpserver.ErrorWithStatusCode{
+			Err:        err,
+			StatusCode: http.StatusTooManyRequests,
+		}
+	}
+	if tenantConcurrentQueries == nil {
+		tenantConcurrentQueries = make(map[logstorage.TenantID]int)
+	}
+	tenantConcurrentQueries[tenantID] = n + 1
+
+	release := func() {
+		tenantQueryLimitsLock.Lock()
+		defer tenantQueryLimitsLock.Unlock()
+
+		n := tenantConcurrentQueries[tenantID] - 1
+		if n <= 0 {
+			delete(tenantConcurrentQueries, tenantID)
+		} else {
+			tenantConcurrentQueries[tenantID] = n
+		}
+	}
+	return release, nil
+}
+
+func releaseTenantQuerySlotNoop() {}
+
+func tenantQueriesRejectedTotal(tenantID logstorage.TenantID) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`vl_queries_rejected_by_tenant_limits_total{accountID="%d",projectID="%d",limit="max_concurrent_queries"}`,
+		tenantID.AccountID, tenantID.ProjectID))
+}
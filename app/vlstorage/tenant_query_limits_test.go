@@ -0,0 +1,209 @@
+package vlstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logstorage"
+)
+
+func TestParseTenantQueryLimitsSuccess(t *testing.T) {
+	f := func(data string, tenantID logstorage.TenantID, limitsExpected *TenantQueryLimits) {
+		t.Helper()
+
+		ptql, err := parseTenantQueryLimits([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		limits := ptql.getLimits(tenantID)
+		if limitsExpected == nil {
+			if limits != nil {
+				t.Fatalf("expecting nil limits; got %+v", limits)
+			}
+			return
+		}
+		if limits == nil {
+			t.Fatalf("expecting non-nil limits %+v", limitsExpected)
+		}
+		if *limits != *limitsExpected {
+			t.Fatalf("unexpected limits\ngot\n%+v\nwant\n%+v", limits, limitsExpected)
+		}
+	}
+
+	tenantID := logstorage.TenantID{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+
+	f(``, tenantID, nil)
+
+	data := `
+default:
+  max_concurrent_queries: 4
+  max_query_duration: 10s
+tenants:
+  "12:34":
+    max_bytes_read: 1000000
+    max_blocks_processed: 100
+    max_memory_bytes: 2000000
+  "5": {}
+`
+	f(data, tenantID, &TenantQueryLimits{
+		QueryLimits: logstorage.QueryLimits{
+			MaxBytesRead:       1000000,
+			MaxBlocksProcessed: 100,
+			MaxMemoryBytes:     2000000,
+		},
+	})
+	f(data, logstorage.TenantID{}, &TenantQueryLimits{
+		MaxConcurrentQueries: 4,
+		MaxQueryDuration:     10 * time.Second,
+	})
+
+	// Empty limits for the tenant disable default limits
+	f(data, logstorage.TenantID{AccountID: 5}, nil)
+}
+
+func TestParseTenantQueryLimitsFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		ptql, err := parseTenantQueryLimits([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if ptql != nil {
+			t.Fatalf("expecting nil result; got %v", ptql)
+		}
+	}
+
+	// invalid YAML
+	f(`foo`)
+
+	// unknown field
+	f(`
+default:
+  foo: 123
+`)
+
+	// invalid duration
+	f(`
+default:
+  max_query_duration: foo
+`)
+
+	// negative limits
+	f(`
+default:
+  max_concurrent_queries: -1
+`)
+	f(`
+default:
+  max_memory_bytes: -1
+`)
+
+	// invalid tenant
+	f(`
+tenants:
+  "foo:bar":
+    max_concurrent_queries: 10
+`)
+
+	// duplicate tenant
+	f(`
+tenants:
+  "1":
+    max_concurrent_queries: 10
+  "1:0":
+    max_concurrent_queries: 20
+`)
+}
+
+func TestTenantQueryLimits(t *testing.T) {
+	ptql, err := parseTenantQueryLimits([]byte(`
+default:
+  max_concurrent_queries: 2
+  max_bytes_read: 1000
+tenants:
+  "1":
+    max_bytes_read: 100
+    max_memory_bytes: 500
+  "2": {}
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	origTenantQueryLimitsFile := *tenantQueryLimitsFile
+	*tenantQueryLimitsFile = "test"
+	setTenantQueryLimits(ptql)
+	defer func() {
+		*tenantQueryLimitsFile = origTenantQueryLimitsFile
+		setTenantQueryLimits(nil)
+	}()
+
+	// Verify GetQueryLimits
+	f := func(tenantIDs []logstorage.TenantID, limitsExpected *logstorage.QueryLimits) {
+		t.Helper()
+
+		limits := GetQueryLimits(tenantIDs)
+		if limitsExpected == nil {
+			if limits != nil {
+				t.Fatalf("expecting nil limits; got %+v", limits)
+			}
+			return
+		}
+		if limits == nil {
+			t.Fatalf("expecting non-nil limits %+v", limitsExpected)
+		}
+		if *limits != *limitsExpected {
+			t.Fatalf("unexpected limits\ngot\n%+v\nwant\n%+v", limits, limitsExpected)
+		}
+	}
+
+	tenant0 := logstorage.TenantID{}
+	tenant1 := logstorage.TenantID{AccountID: 1}
+	tenant2 := logstorage.TenantID{AccountID: 2}
+
+	f(nil, nil)
+	f([]logstorage.TenantID{tenant2}, nil)
+	f([]logstorage.TenantID{tenant0}, &logstorage.QueryLimits{
+		MaxBytesRead: 1000,
+	})
+	f([]logstorage.TenantID{tenant0, tenant1, tenant2}, &logstorage.QueryLimits{
+		MaxBytesRead:   100,
+		MaxMemoryBytes: 500,
+	})
+
+	// Verify AcquireTenantQuerySlot
+	release1, err := AcquireTenantQuerySlot(tenant0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	release2, err := AcquireTenantQuerySlot(tenant0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := AcquireTenantQuerySlot(tenant0); err == nil {
+		t.Fatalf("expecting non-nil error when exceeding max_concurrent_queries")
+	}
+
+	// Other tenants must be unaffected
+	release3, err := AcquireTenantQuerySlot(tenant1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	release3()
+
+	release1()
+	release3, err = AcquireTenantQuerySlot(tenant0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	release2()
+	release3()
+
+	if n := len(tenantConcurrentQueries); n != 0 {
+		t.Fatalf("unexpected number of tenants with concurrent queries; got %d; want 0", n)
+	}
+}
@@ -0,0 +1,364 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// The layout of backups at RemoteFS:
+//
+//   - data/YYYYMMDD/... - immutable files for per-day partitions. These files are shared among all the backups.
+//     Only new files are uploaded during every backup, so backups are incremental.
+//   - backups/<backupName>/data/YYYYMMDD/... - mutable files for per-day partitions such as parts.json,
+//     which may differ among backups.
+//   - backups/<backupName>/manifest.json - the list of files for the given backup. It is written after all the files for the backup are uploaded,
+//     so backups without manifest.json are incomplete.
+const (
+	dataDirname      = "data"
+	backupsDirname   = "backups"
+	manifestFilename = "manifest.json"
+)
+
+// Manifest describes a single backup.
+type Manifest struct {
+	// CreatedAt is the backup creation time.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Partitions contains names for per-day partitions in the backup.
+	Partitions []string `json:"partitions"`
+
+	// Files contains the list of files in the backup.
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a single file in the backup.
+type ManifestFile struct {
+	// Path is the path to the file relative to the <-storageDataPath>/partitions directory.
+	Path string `json:"path"`
+
+	// RemotePath is the path to the file at RemoteFS.
+	RemotePath string `json:"remotePath"`
+
+	// Size is the file size in bytes.
+	Size int64 `json:"size"`
+}
+
+// Snapshot is a snapshot for a per-day partition.
+type Snapshot struct {
+	// Partition is the partition name in the YYYYMMDD format.
+	Partition string
+
+	// Path is the path to the snapshot directory.
+	Path string
+}
+
+// Backup uploads the given snapshots to dst under the given backupName.
+//
+// Only files missing at dst are uploaded. concurrency is the number of concurrent uploads.
+func Backup(snapshots []Snapshot, dst RemoteFS, backupName string, concurrency int) (*Manifest, error) {
+	if err := validateBackupName(backupName); err != nil {
+		return nil, err
+	}
+	manifestPath := getManifestPath(backupName)
+	fis, err := dst.ListFiles(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) > 0 {
+		return nil, fmt.Errorf("backup %q already exists at %s", backupName, dst)
+	}
+
+	m := &Manifest{
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, snapshot := range snapshots {
+		files, err := getSnapshotFiles(snapshot, backupName)
+		if err != nil {
+			return nil, err
+		}
+		m.Partitions = append(m.Partitions, snapshot.Partition)
+		m.Files = append(m.Files, files...)
+	}
+	sort.Strings(m.Partitions)
+
+	// Upload only files, which are missing at dst.
+	fis, err = dst.ListFiles(dataDirname + "/")
+	if err != nil {
+		return nil, err
+	}
+	existingFiles := make(map[string]int64, len(fis))
+	for _, fi := range fis {
+		existingFiles[fi.Path] = fi.Size
+	}
+	snapshotPaths := make(map[string]string, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotPaths[snapshot.Partition] = snapshot.Path
+	}
+
+	var filesToUpload []ManifestFile
+	var bytesToUpload int64
+	for _, f := range m.Files {
+		if size, ok := existingFiles[f.RemotePath]; ok && size == f.Size {
+			continue
+		}
+		filesToUpload = append(filesToUpload, f)
+		bytesToUpload += f.Size
+	}
+	logger.Infof("uploading %d out of %d files (%d bytes) for backup %q to %s", len(filesToUpload), len(m.Files), bytesToUpload, backupName, dst)
+
+	err = runParallel(filesToUpload, concurrency, func(f ManifestFile) error {
+		partition, relPath, _ := strings.Cut(f.Path, "/")
+		srcPath := filepath.Join(snapshotPaths[partition], filepath.FromSlash(relPath))
+		return uploadFile(dst, srcPath, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Write manifest at the end, so the backup becomes visible only after all its' files are uploaded.
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal backup manifest: %s", err)
+	}
+	if err := dst.WriteFile(manifestPath, bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, fmt.Errorf("cannot write backup manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+func getSnapshotFiles(snapshot Snapshot, backupName string) ([]ManifestFile, error) {
+	var files []ManifestFile
+	err := filepath.WalkDir(snapshot.Path, func(p string, de iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(snapshot.Path, p)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+
+		filePath := path.Join(snapshot.Partition, relPath)
+		files = append(files, ManifestFile{
+			Path:       filePath,
+			RemotePath: getRemotePath(filePath, backupName),
+			Size:       fi.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read snapshot files for partition %q at %q: %w", snapshot.Partition, snapshot.Path, err)
+	}
+	return files, nil
+}
+
+// getRemotePath returns the path at RemoteFS for the file at the given filePath relative to partitions directory.
+func getRemotePath(filePath, backupName string) string {
+	// Files inside part directories (YYYYMMDD/{datadb,indexdb}/<part>/<file>) are immutable, so they can be shared among backups.
+	// Other files such as YYYYMMDD/{datadb,indexdb}/parts.json may change over time, so they are stored per each backup.
+	if strings.Count(filePath, "/") >= 3 {
+		return path.Join(dataDirname, filePath)
+	}
+	return path.Join(backupsDirname, backupName, dataDirname, filePath)
+}
+
+func uploadFile(dst RemoteFS, srcPath string, f ManifestFile) error {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := dst.WriteFile(f.RemotePath, file, f.Size); err != nil {
+		return fmt.Errorf("cannot upload %q: %w", srcPath, err)
+	}
+	return nil
+}
+
+// Restore restores the backup with the given backupName from src to the given partitionsPath.
+//
+// The partitionsPath must be missing or empty. concurrency is the number of concurrent downloads.
+func Restore(src RemoteFS, backupName, partitionsPath string, concurrency int) (*Manifest, error) {
+	m, err := ReadManifest(src, backupName)
+	if err != nil {
+		return nil, err
+	}
+
+	if des, err := os.ReadDir(partitionsPath); err == nil && len(des) > 0 {
+		return nil, fmt.Errorf("cannot restore backup to non-empty directory %q", partitionsPath)
+	}
+
+	// Restore the backup to a temporary directory at first and then rename it to partitionsPath.
+	// This guarantees that partitionsPath contains either the full backup or nothing.
+	tmpPath := partitionsPath + tmpFileSuffix
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return nil, err
+	}
+
+	var bytesToDownload int64
+	for _, f := range m.Files {
+		bytesToDownload += f.Size
+	}
+	logger.Infof("downloading %d files (%d bytes) for backup %q from %s", len(m.Files), bytesToDownload, backupName, src)
+
+	err = runParallel(m.Files, concurrency, func(f ManifestFile) error {
+		dstPath := filepath.Join(tmpPath, filepath.FromSlash(f.Path))
+		return downloadFile(src, dstPath, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, partition := range m.Partitions {
+		// Make sure that partitions without files are restored too.
+		if err := os.MkdirAll(filepath.Join(tmpPath, partition), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.RemoveAll(partitionsPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, partitionsPath); err != nil {
+		return nil, err
+	}
+	fs.MustSyncPathAndParentDir(partitionsPath)
+
+	return m, nil
+}
+
+func downloadFile(src RemoteFS, dstPath string, f ManifestFile) error {
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	r, err := src.ReadFile(f.RemotePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	file, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, r)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("cannot download %q to %q: %w", f.RemotePath, dstPath, err)
+	}
+	if n != f.Size {
+		_ = file.Close()
+		return fmt.Errorf("unexpected size for the downloaded file %q; got %d bytes; want %d bytes", f.RemotePath, n, f.Size)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadManifest reads manifest for the backup with the given backupName from src.
+func ReadManifest(src RemoteFS, backupName string) (*Manifest, error) {
+	if err := validateBackupName(backupName); err != nil {
+		return nil, err
+	}
+	r, err := src.ReadFile(getManifestPath(backupName))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest for backup %q at %s: %w", backupName, src, err)
+	}
+	defer r.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest for backup %q at %s: %w", backupName, src, err)
+	}
+	return &m, nil
+}
+
+// ListBackups returns sorted names of complete backups at src.
+func ListBackups(src RemoteFS) ([]string, error) {
+	fis, err := src.ListFiles(backupsDirname + "/")
+	if err != nil {
+		return nil, err
+	}
+	var backupNames []string
+	for _, fi := range fis {
+		backupName, ok := strings.CutSuffix(strings.TrimPrefix(fi.Path, backupsDirname+"/"), "/"+manifestFilename)
+		if ok && !strings.Contains(backupName, "/") {
+			backupNames = append(backupNames, backupName)
+		}
+	}
+	sort.Strings(backupNames)
+	return backupNames, nil
+}
+
+func getManifestPath(backupName string) string {
+	return path.Join(backupsDirname, backupName, manifestFilename)
+}
+
+func validateBackupName(backupName string) error {
+	if backupName == "" {
+		return fmt.Errorf("backup name cannot be empty")
+	}
+	if strings.ContainsAny(backupName, `/\`) || backupName == "." || backupName == ".." {
+		return fmt.Errorf("backup name %q cannot contain slashes and cannot be equal to . or ..", backupName)
+	}
+	return nil
+}
+
+// runParallel calls fn for every item in files using the given concurrency.
+//
+// It returns the first error returned by fn. The remaining files are skipped after the first error.
+func runParallel(files []ManifestFile, concurrency int, fn func(f ManifestFile) error) error {
+	workCh := make(chan ManifestFile, len(files))
+	for _, f := range files {
+		workCh <- f
+	}
+	close(workCh)
+
+	var errLock sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < max(concurrency, 1); i++ {
+		wg.Go(func() {
+			for f := range workCh {
+				errLock.Lock()
+				hasErr := firstErr != nil
+				errLock.Unlock()
+				if hasErr {
+					continue
+				}
+
+				if err := fn(f); err != nil {
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errLock.Unlock()
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	snapshotsPath := filepath.Join(t.TempDir(), "snapshots")
+	backupsPath := filepath.Join(t.TempDir(), "backups")
+
+	dst, err := NewRemoteFS("fs://" + backupsPath)
+	if err != nil {
+		t.Fatalf("cannot create RemoteFS: %s", err)
+	}
+
+	// Create the first backup
+	files1 := map[string]string{
+		"20261017/indexdb/parts.json":         `["A"]`,
+		"20261017/indexdb/A/items.bin":        "indexdb items",
+		"20261017/datadb/parts.json":          `["B","C"]`,
+		"20261017/datadb/B/values.bin":        "values for B",
+		"20261017/datadb/B/metadata.json":     `{"RowsCount":1}`,
+		"20261017/datadb/C/values.bin":        "values for C",
+		"20261018/indexdb/parts.json":         `[]`,
+		"20261018/datadb/parts.json":          `["D"]`,
+		"20261018/datadb/D/timestamps.bin":    "timestamps for D",
+		"20261018/datadb/D/empty_column.bin":  "",
+		"20261018/datadb/D/message_bloom.bin": "bloom",
+	}
+	snapshots1 := createTestSnapshots(t, filepath.Join(snapshotsPath, "1"), files1)
+	m, err := Backup(snapshots1, dst, "backup1", 3)
+	if err != nil {
+		t.Fatalf("cannot create backup: %s", err)
+	}
+	if !reflect.DeepEqual(m.Partitions, []string{"20261017", "20261018"}) {
+		t.Fatalf("unexpected partitions in the backup: %q", m.Partitions)
+	}
+	if n := len(m.Files); n != len(files1) {
+		t.Fatalf("unexpected number of files in the backup; got %d; want %d", n, len(files1))
+	}
+
+	// The backup with the same name cannot be created
+	if _, err := Backup(snapshots1, dst, "backup1", 3); err == nil {
+		t.Fatalf("expecting non-nil error when creating backup with duplicate name")
+	}
+
+	// Create the second backup, where part C is merged into part E, and a new part F is added
+	files2 := map[string]string{
+		"20261017/indexdb/parts.json":         `["A"]`,
+		"20261017/indexdb/A/items.bin":        "indexdb items",
+		"20261017/datadb/parts.json":          `["B","E"]`,
+		"20261017/datadb/B/values.bin":        "values for B",
+		"20261017/datadb/B/metadata.json":     `{"RowsCount":1}`,
+		"20261017/datadb/E/values.bin":        "values for E",
+		"20261018/indexdb/parts.json":         `[]`,
+		"20261018/datadb/parts.json":          `["D","F"]`,
+		"20261018/datadb/D/timestamps.bin":    "timestamps for D",
+		"20261018/datadb/D/empty_column.bin":  "",
+		"20261018/datadb/D/message_bloom.bin": "bloom",
+		"20261018/datadb/F/values.bin":        "values for F",
+	}
+	snapshots2 := createTestSnapshots(t, filepath.Join(snapshotsPath, "2"), files2)
+	if _, err := Backup(snapshots2, dst, "backup2", 3); err != nil {
+		t.Fatalf("cannot create backup: %s", err)
+	}
+
+	// Verify that only new parts and mutable files are uploaded for the second backup
+	fis, err := dst.ListFiles("data/")
+	if err != nil {
+		t.Fatalf("cannot list files: %s", err)
+	}
+	var sharedFiles []string
+	for _, fi := range fis {
+		sharedFiles = append(sharedFiles, fi.Path)
+	}
+	sharedFilesExpected := []string{
+		"data/20261017/datadb/B/metadata.json",
+		"data/20261017/datadb/B/values.bin",
+		"data/20261017/datadb/C/values.bin",
+		"data/20261017/datadb/E/values.bin",
+		"data/20261017/indexdb/A/items.bin",
+		"data/20261018/datadb/D/empty_column.bin",
+		"data/20261018/datadb/D/message_bloom.bin",
+		"data/20261018/datadb/D/timestamps.bin",
+		"data/20261018/datadb/F/values.bin",
+	}
+	if !reflect.DeepEqual(sharedFiles, sharedFilesExpected) {
+		t.Fatalf("unexpected shared files\ngot\n%q\nwant\n%q", sharedFiles, sharedFilesExpected)
+	}
+
+	backupNames, err := ListBackups(dst)
+	if err != nil {
+		t.Fatalf("cannot list backups: %s", err)
+	}
+	if !reflect.DeepEqual(backupNames, []string{"backup1", "backup2"}) {
+		t.Fatalf("unexpected backups: %q", backupNames)
+	}
+
+	// Restore both backups and verify they match the original snapshots
+	f := func(backupName string, filesExpected map[string]string) {
+		t.Helper()
+
+		partitionsPath := filepath.Join(t.TempDir(), "partitions")
+		if _, err := Restore(dst, backupName, partitionsPath, 3); err != nil {
+			t.Fatalf("cannot restore backup %q: %s", backupName, err)
+		}
+		files := readTestFiles(t, partitionsPath)
+		if !reflect.DeepEqual(files, filesExpected) {
+			t.Fatalf("unexpected files restored from backup %q\ngot\n%v\nwant\n%v", backupName, files, filesExpected)
+		}
+
+		// Restoring to non-empty directory must fail
+		if _, err := Restore(dst, backupName, partitionsPath, 3); err == nil {
+			t.Fatalf("expecting non-nil error when restoring to non-empty directory")
+		}
+	}
+	f("backup1", files1)
+	f("backup2", files2)
+
+	// Restoring missing backup must fail
+	if _, err := Restore(dst, "missing", filepath.Join(t.TempDir(), "partitions"), 3); err == nil {
+		t.Fatalf("expecting non-nil error when restoring missing backup")
+	}
+}
+
+func TestNewRemoteFSFailure(t *testing.T) {
+	f := func(uri string) {
+		t.Helper()
+
+		if _, err := NewRemoteFS(uri); err == nil {
+			t.Fatalf("expecting non-nil error for %q", uri)
+		}
+	}
+
+	f("")
+	f("/path/to/dir")
+	f("fs://")
+	f("gcs://bucket/dir")
+	f("s3:///dir")
+}
+
+func createTestSnapshots(t *testing.T, dir string, files map[string]string) []Snapshot {
+	t.Helper()
+
+	var snapshots []Snapshot
+	seen := make(map[string]bool)
+	for filePath, data := range files {
+		partition, relPath, _ := strings.Cut(filePath, "/")
+		snapshotPath := filepath.Join(dir, partition, "snapshots", "20261018102030-0000000000000001")
+		if !seen[partition] {
+			seen[partition] = true
+			snapshots = append(snapshots, Snapshot{
+				Partition: partition,
+				Path:      snapshotPath,
+			})
+		}
+		path := filepath.Join(snapshotPath, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("cannot create directory: %s", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("cannot write file: %s", err)
+		}
+	}
+	return snapshots
+}
+
+func readTestFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, de os.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read files at %q: %s", dir, err)
+	}
+	return files
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localFS is RemoteFS for local filesystem.
+type localFS struct {
+	dir string
+}
+
+func newLocalFS(dir string) *localFS {
+	return &localFS{
+		dir: filepath.Clean(dir),
+	}
+}
+
+// String implements RemoteFS interface.
+func (lfs *localFS) String() string {
+	return "fs://" + lfs.dir
+}
+
+// ListFiles implements RemoteFS interface.
+func (lfs *localFS) ListFiles(prefix string) ([]FileInfo, error) {
+	var fis []FileInfo
+	err := filepath.WalkDir(lfs.dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !de.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(lfs.dir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !strings.HasPrefix(relPath, prefix) || isTemporaryFile(relPath) {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		fis = append(fis, FileInfo{
+			Path: relPath,
+			Size: fi.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list files at %s: %w", lfs, err)
+	}
+	return fis, nil
+}
+
+// ReadFile implements RemoteFS interface.
+func (lfs *localFS) ReadFile(path string) (io.ReadCloser, error) {
+	return os.Open(lfs.getFullPath(path))
+}
+
+// WriteFile implements RemoteFS interface.
+func (lfs *localFS) WriteFile(path string, r io.ReaderAt, size int64) error {
+	fullPath := lfs.getFullPath(path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+
+	// Write the file under temporary name at first and then rename it to the final name.
+	// This guarantees that the file is either fully written or missing.
+	tmpPath := fullPath + tmpFileSuffix
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.NewSectionReader(r, 0, size)); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cannot write data to %q: %w", tmpPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cannot sync %q: %w", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fullPath)
+}
+
+func (lfs *localFS) getFullPath(path string) string {
+	return filepath.Join(lfs.dir, filepath.FromSlash(path))
+}
+
+// tmpFileSuffix is the suffix for files, which are being written by localFS.WriteFile.
+const tmpFileSuffix = ".tmp"
+
+func isTemporaryFile(path string) bool {
+	return strings.HasSuffix(path, tmpFileSuffix)
+}
@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RemoteFS is a storage for backups.
+//
+// Paths passed to RemoteFS methods are relative to the root of the RemoteFS and use forward slashes as separators.
+type RemoteFS interface {
+	// String returns human-readable description for the RemoteFS.
+	String() string
+
+	// ListFiles returns all the files with paths starting with the given prefix.
+	ListFiles(prefix string) ([]FileInfo, error)
+
+	// ReadFile returns a reader for the file at the given path.
+	//
+	// The caller must close the returned reader when it is no longer needed.
+	ReadFile(path string) (io.ReadCloser, error)
+
+	// WriteFile atomically writes size bytes from r to the file at the given path.
+	//
+	// The file is overwritten if it already exists.
+	WriteFile(path string, r io.ReaderAt, size int64) error
+}
+
+// FileInfo contains information about a file stored at RemoteFS.
+type FileInfo struct {
+	// Path is the path to the file relative to the RemoteFS root.
+	Path string
+
+	// Size is the file size in bytes.
+	Size int64
+}
+
+// NewRemoteFS returns RemoteFS for the given uri.
+//
+// The following uri formats are supported:
+//
+//   - fs:///path/to/local/dir - for storing backups at local filesystem, including NFS and other mounted filesystems.
+//   - s3://bucket/path/to/dir - for storing backups at S3-compatible object storage.
+func NewRemoteFS(uri string) (RemoteFS, error) {
+	scheme, path, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("missing scheme in %q; supported schemes: fs://, s3://", uri)
+	}
+	switch scheme {
+	case "fs":
+		if path == "" {
+			return nil, fmt.Errorf("missing directory path in %q", uri)
+		}
+		return newLocalFS(path), nil
+	case "s3":
+		bucket, prefix, _ := strings.Cut(path, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("missing bucket name in %q", uri)
+		}
+		return newS3FS(bucket, prefix)
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q in %q; supported schemes: fs://, s3://", scheme, uri)
+	}
+}
@@ -0,0 +1,412 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+var (
+	customS3Endpoint = flag.String("customS3Endpoint", "", "Custom S3 endpoint for use with S3-compatible storages (e.g. MinIO). "+
+		"By default https://s3.<s3Region>.amazonaws.com is used")
+	s3Region         = flag.String("s3Region", "us-east-1", "S3 region to use for signing requests to S3-compatible storage")
+	s3ForcePathStyle = flag.Bool("s3ForcePathStyle", true, "Whether to use path-style requests to S3-compatible storage (e.g. https://endpoint/bucket/key) "+
+		"instead of virtual-hosted-style requests (e.g. https://bucket.endpoint/key)")
+)
+
+// s3FS is RemoteFS for S3-compatible object storage.
+//
+// Credentials are read from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type s3FS struct {
+	bucket string
+	prefix string
+
+	endpoint *url.URL
+	region   string
+
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+
+	c *http.Client
+}
+
+func newS3FS(bucket, prefix string) (*s3FS, error) {
+	endpoint := *customS3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", *s3Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -customS3Endpoint=%q: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme in -customS3Endpoint=%q; supported schemes: http, https", endpoint)
+	}
+	if !*s3ForcePathStyle {
+		u.Host = bucket + "." + u.Host
+	}
+
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("missing S3 credentials; set them via AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &s3FS{
+		bucket:          bucket,
+		prefix:          prefix,
+		endpoint:        u,
+		region:          *s3Region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		c:               &http.Client{},
+	}, nil
+}
+
+// String implements RemoteFS interface.
+func (sfs *s3FS) String() string {
+	return "s3://" + sfs.bucket + "/" + sfs.prefix
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// ListFiles implements RemoteFS interface.
+func (sfs *s3FS) ListFiles(prefix string) ([]FileInfo, error) {
+	var fis []FileInfo
+	continuationToken := ""
+	for {
+		args := url.Values{}
+		args.Set("list-type", "2")
+		args.Set("prefix", sfs.prefix+prefix)
+		if continuationToken != "" {
+			args.Set("continuation-token", continuationToken)
+		}
+		data, err := sfs.doRequest(http.MethodGet, "", args, nil, 0, emptyPayloadHash, http.StatusOK)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list files at %s: %w", sfs, err)
+		}
+
+		var lbr s3ListBucketResult
+		if err := xml.Unmarshal(data, &lbr); err != nil {
+			return nil, fmt.Errorf("cannot parse response for listing files at %s: %w", sfs, err)
+		}
+		for _, c := range lbr.Contents {
+			fis = append(fis, FileInfo{
+				Path: strings.TrimPrefix(c.Key, sfs.prefix),
+				Size: c.Size,
+			})
+		}
+		if !lbr.IsTruncated {
+			return fis, nil
+		}
+		continuationToken = lbr.NextContinuationToken
+	}
+}
+
+// ReadFile implements RemoteFS interface.
+func (sfs *s3FS) ReadFile(path string) (io.ReadCloser, error) {
+	req := sfs.newRequest(http.MethodGet, sfs.prefix+path, nil, nil, 0, emptyPayloadHash)
+	resp, err := sfs.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %q from %s: %w", path, sfs, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := newS3Error(req, resp)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("cannot read %q from %s: %w", path, sfs, err)
+	}
+	return resp.Body, nil
+}
+
+// s3MaxSinglePutSize is the maximum size of the file, which is uploaded to S3 with a single request.
+//
+// Bigger files are uploaded via multipart upload.
+const s3MaxSinglePutSize = 1 << 30
+
+// s3MaxParts is the maximum number of parts in S3 multipart upload.
+const s3MaxParts = 10000
+
+// WriteFile implements RemoteFS interface.
+func (sfs *s3FS) WriteFile(path string, r io.ReaderAt, size int64) error {
+	key := sfs.prefix + path
+	if size <= s3MaxSinglePutSize {
+		body := io.NewSectionReader(r, 0, size)
+		if _, err := sfs.doRequest(http.MethodPut, key, nil, body, size, unsignedPayloadHash, http.StatusOK); err != nil {
+			return fmt.Errorf("cannot write %q to %s: %w", path, sfs, err)
+		}
+		return nil
+	}
+	if err := sfs.writeFileMultipart(key, r, size); err != nil {
+		return fmt.Errorf("cannot write %q to %s: %w", path, sfs, err)
+	}
+	return nil
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+type s3CompletePart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (sfs *s3FS) writeFileMultipart(key string, r io.ReaderAt, size int64) error {
+	partSize := max(int64(s3MaxSinglePutSize)/4, (size+s3MaxParts-1)/s3MaxParts)
+
+	args := url.Values{}
+	args.Set("uploads", "")
+	data, err := sfs.doRequest(http.MethodPost, key, args, nil, 0, emptyPayloadHash, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("cannot initiate multipart upload: %w", err)
+	}
+	var imur s3InitiateMultipartUploadResult
+	if err := xml.Unmarshal(data, &imur); err != nil {
+		return fmt.Errorf("cannot parse response for multipart upload initiation: %w", err)
+	}
+
+	var cmu s3CompleteMultipartUpload
+	for offset := int64(0); offset < size; offset += partSize {
+		n := min(partSize, size-offset)
+		partNumber := len(cmu.Parts) + 1
+
+		args := url.Values{}
+		args.Set("partNumber", strconv.Itoa(partNumber))
+		args.Set("uploadId", imur.UploadID)
+		req := sfs.newRequest(http.MethodPut, key, args, io.NewSectionReader(r, offset, n), n, unsignedPayloadHash)
+		resp, err := sfs.c.Do(req)
+		if err != nil {
+			sfs.abortMultipartUpload(key, imur.UploadID)
+			return fmt.Errorf("cannot upload part #%d: %w", partNumber, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := newS3Error(req, resp)
+			_ = resp.Body.Close()
+			sfs.abortMultipartUpload(key, imur.UploadID)
+			return fmt.Errorf("cannot upload part #%d: %w", partNumber, err)
+		}
+		_ = resp.Body.Close()
+
+		cmu.Parts = append(cmu.Parts, s3CompletePart{
+			PartNumber: partNumber,
+			ETag:       resp.Header.Get("ETag"),
+		})
+	}
+
+	body, err := xml.Marshal(&cmu)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal CompleteMultipartUpload: %s", err)
+	}
+	args = url.Values{}
+	args.Set("uploadId", imur.UploadID)
+	if _, err := sfs.doRequest(http.MethodPost, key, args, bytes.NewReader(body), int64(len(body)), getPayloadHash(body), http.StatusOK); err != nil {
+		sfs.abortMultipartUpload(key, imur.UploadID)
+		return fmt.Errorf("cannot complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+func (sfs *s3FS) abortMultipartUpload(key, uploadID string) {
+	args := url.Values{}
+	args.Set("uploadId", uploadID)
+	if _, err := sfs.doRequest(http.MethodDelete, key, args, nil, 0, emptyPayloadHash, http.StatusNoContent); err != nil {
+		logger.Warnf("cannot abort multipart upload for %q at %s: %s", key, sfs, err)
+	}
+}
+
+// doRequest performs the request to S3 and returns the response body.
+//
+// An error is returned if the response status code differs from expectedStatusCode.
+func (sfs *s3FS) doRequest(method, key string, args url.Values, body io.Reader, bodySize int64, payloadHash string, expectedStatusCode int) ([]byte, error) {
+	req := sfs.newRequest(method, key, args, body, bodySize, payloadHash)
+	resp, err := sfs.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatusCode {
+		return nil, newS3Error(req, resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response from %s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	return data, nil
+}
+
+func (sfs *s3FS) newRequest(method, key string, args url.Values, body io.Reader, bodySize int64, payloadHash string) *http.Request {
+	path := "/"
+	if *s3ForcePathStyle {
+		path += sfs.bucket + "/"
+	}
+	path += key
+
+	u := *sfs.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = s3EncodePath(u.Path)
+	u.RawQuery = s3EncodeQuery(args)
+
+	if bodySize == 0 {
+		// Go HTTP client treats zero ContentLength with non-nil body as unknown length.
+		body = nil
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		logger.Panicf("BUG: cannot create request for %q: %s", u.String(), err)
+	}
+	req.ContentLength = bodySize
+	if sfs.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sfs.sessionToken)
+	}
+	signRequestV4(req, payloadHash, sfs.accessKeyID, sfs.secretAccessKey, sfs.region, time.Now())
+	return req
+}
+
+func newS3Error(req *http.Request, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4*1024))
+	return fmt.Errorf("unexpected status code returned from %s %s: %d; response body: %q", req.Method, req.URL.Redacted(), resp.StatusCode, data)
+}
+
+const (
+	emptyPayloadHash    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayloadHash = "UNSIGNED-PAYLOAD"
+)
+
+func getPayloadHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// signRequestV4 signs req according to AWS Signature Version 4.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signRequestV4(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign all the request headers together with the Host header.
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for k := range headers {
+		headerNames = append(headerNames, k)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range headerNames {
+		canonicalHeaders.WriteString(k)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(headers[k])
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		getPayloadHash([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EncodePath encodes path according to S3 rules for canonical URI.
+func s3EncodePath(path string) string {
+	return s3Encode(path, false)
+}
+
+// s3EncodeQuery encodes args according to S3 rules for canonical query string.
+//
+// Args are sorted by name, so the result can be used both in the request url and in the canonical request.
+func s3EncodeQuery(args url.Values) string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var a []string
+	for _, k := range keys {
+		for _, v := range args[k] {
+			a = append(a, s3Encode(k, true)+"="+s3Encode(v, true))
+		}
+	}
+	return strings.Join(a, "&")
+}
+
+func s3Encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -24,4 +24,5 @@ const (
 	datadbDirname     = "datadb"
 	partitionsDirname = "partitions"
 	snapshotsDirname  = "snapshots"
+	tmpDirname        = "tmp"
 )
@@ -0,0 +1,153 @@
+package logstorage
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/mmdb"
+)
+
+// GeoIPDatabase is a MaxMind DB database, which can be used by `geoip` pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#geoip-pipe
+type GeoIPDatabase struct {
+	r *mmdb.Reader
+}
+
+// OpenGeoIPDatabase opens GeoIP database from data in MaxMind DB format.
+//
+// The data must remain unchanged while the returned database is in use.
+func OpenGeoIPDatabase(data []byte) (*GeoIPDatabase, error) {
+	r, err := mmdb.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	db := &GeoIPDatabase{
+		r: r,
+	}
+	return db, nil
+}
+
+// DatabaseType returns the database type such as GeoLite2-City or GeoLite2-ASN.
+func (db *GeoIPDatabase) DatabaseType() string {
+	return db.r.Metadata.DatabaseType
+}
+
+var geoIPDatabases atomic.Pointer[[]*GeoIPDatabase]
+
+// SetGeoIPDatabases sets GeoIP databases for `geoip` pipe.
+//
+// Databases are queried in the given order. The first non-empty value for every field is used.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#geoip-pipe
+func SetGeoIPDatabases(dbs []*GeoIPDatabase) {
+	geoIPDatabases.Store(&dbs)
+}
+
+func getGeoIPDatabases() []*GeoIPDatabase {
+	p := geoIPDatabases.Load()
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// geoIPFields contains field names, which can be returned by `geoip` pipe.
+var geoIPFields = []string{
+	"country",
+	"country_name",
+	"continent",
+	"region",
+	"city",
+	"latitude",
+	"longitude",
+	"asn",
+	"as_org",
+}
+
+// geoIPResult contains values for geoIPFields.
+type geoIPResult [9]string
+
+var emptyGeoIPResult geoIPResult
+
+// lookupGeoIP returns geoIPResult for the given addr from dbs.
+func lookupGeoIP(dbs []*GeoIPDatabase, addr netip.Addr) (*geoIPResult, error) {
+	var result geoIPResult
+	for _, db := range dbs {
+		offset, ok, err := db.r.Lookup(addr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot look up %s at %s database: %w", addr, db.DatabaseType(), err)
+		}
+		if !ok {
+			continue
+		}
+		v, err := db.r.Decode(offset)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode data for %s at %s database: %w", addr, db.DatabaseType(), err)
+		}
+		result.update(v)
+	}
+	return &result, nil
+}
+
+func (result *geoIPResult) update(v any) {
+	set := func(idx int, v string) {
+		if result[idx] == "" {
+			result[idx] = v
+		}
+	}
+
+	country := getGeoIPString(v, "country", "iso_code")
+	if country == "" {
+		country = getGeoIPString(v, "registered_country", "iso_code")
+	}
+	set(0, country)
+
+	countryName := getGeoIPString(v, "country", "names", "en")
+	if countryName == "" {
+		countryName = getGeoIPString(v, "registered_country", "names", "en")
+	}
+	set(1, countryName)
+
+	set(2, getGeoIPString(v, "continent", "code"))
+
+	if m, ok := v.(map[string]any); ok {
+		if a, ok := m["subdivisions"].([]any); ok && len(a) > 0 {
+			set(3, getGeoIPString(a[0], "names", "en"))
+		}
+	}
+
+	set(4, getGeoIPString(v, "city", "names", "en"))
+	set(5, getGeoIPString(v, "location", "latitude"))
+	set(6, getGeoIPString(v, "location", "longitude"))
+	set(7, getGeoIPString(v, "autonomous_system_number"))
+	set(8, getGeoIPString(v, "autonomous_system_organization"))
+}
+
+// getGeoIPString returns string representation for the value at the given path in v.
+func getGeoIPString(v any, path ...string) string {
+	for _, key := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t
+	case uint64:
+		return strconv.FormatUint(t, 10)
+	case int32:
+		return strconv.FormatInt(int64(t), 10)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32)
+	default:
+		return ""
+	}
+}
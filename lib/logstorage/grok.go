@@ -0,0 +1,358 @@
+package logstorage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// grokBuiltinPatterns contains the standard library of grok patterns.
+//
+// The patterns are based on https://github.com/logstash-plugins/logstash-patterns-core/tree/main/patterns/legacy .
+// They are adapted to RE2 syntax supported by Go regexp package - lookarounds and atomic groups are removed from the original patterns.
+var grokBuiltinPatterns = map[string]string{
+	// generic patterns
+	"USERNAME":       `[a-zA-Z0-9._-]+`,
+	"USER":           `%{USERNAME}`,
+	"EMAILLOCALPART": `[a-zA-Z0-9!#$%&'*+\-/=?^_{|}~]{1,64}(?:\.[a-zA-Z0-9!#$%&'*+\-/=?^_{|}~]+)*`,
+	"EMAILADDRESS":   `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":            `(?:[+-]?(?:[0-9]+))`,
+	"BASE10NUM":      `(?:[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+))`,
+	"NUMBER":         `(?:%{BASE10NUM})`,
+	"BASE16NUM":      `(?:[+-]?(?:0x)?(?:[0-9A-Fa-f]+))`,
+	"BASE16FLOAT":    `\b(?:[+-]?(?:0x)?(?:(?:[0-9A-Fa-f]+(?:\.[0-9A-Fa-f]*)?)|(?:\.[0-9A-Fa-f]+)))\b`,
+	"POSINT":         `\b(?:[1-9][0-9]*)\b`,
+	"NONNEGINT":      `\b(?:[0-9]+)\b`,
+	"WORD":           `\b\w+\b`,
+	"NOTSPACE":       `\S+`,
+	"SPACE":          `\s*`,
+	"DATA":           `.*?`,
+	"GREEDYDATA":     `.*`,
+	"QUOTEDSTRING":   "(?:\"(?:\\\\.|[^\\\\\"])*\"|'(?:\\\\.|[^\\\\'])*'|`(?:\\\\.|[^\\\\`])*`)",
+	"QS":             `%{QUOTEDSTRING}`,
+	"UUID":           `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"URN":            `urn:[0-9A-Za-z][0-9A-Za-z-]{0,31}:(?:%[0-9a-fA-F]{2}|[0-9A-Za-z()+,.:=@;$_!*'/?#-])+`,
+
+	// network patterns
+	"MAC":        `(?:%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC})`,
+	"CISCOMAC":   `(?:(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4})`,
+	"WINDOWSMAC": `(?:(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2})`,
+	"COMMONMAC":  `(?:(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2})`,
+	"IPV6": `(?:(?:(?:[0-9A-Fa-f]{1,4}:){7}(?:[0-9A-Fa-f]{1,4}|:))|(?:(?:[0-9A-Fa-f]{1,4}:){6}(?::[0-9A-Fa-f]{1,4}|%{IPV4}|:))|` +
+		`(?:(?:[0-9A-Fa-f]{1,4}:){5}(?:(?:(?::[0-9A-Fa-f]{1,4}){1,2})|:%{IPV4}|:))|` +
+		`(?:(?:[0-9A-Fa-f]{1,4}:){4}(?:(?:(?::[0-9A-Fa-f]{1,4}){1,3})|(?:(?::[0-9A-Fa-f]{1,4})?:%{IPV4})|:))|` +
+		`(?:(?:[0-9A-Fa-f]{1,4}:){3}(?:(?:(?::[0-9A-Fa-f]{1,4}){1,4})|(?:(?::[0-9A-Fa-f]{1,4}){0,2}:%{IPV4})|:))|` +
+		`(?:(?:[0-9A-Fa-f]{1,4}:){2}(?:(?:(?::[0-9A-Fa-f]{1,4}){1,5})|(?:(?::[0-9A-Fa-f]{1,4}){0,3}:%{IPV4})|:))|` +
+		`(?:(?:[0-9A-Fa-f]{1,4}:){1}(?:(?:(?::[0-9A-Fa-f]{1,4}){1,6})|(?:(?::[0-9A-Fa-f]{1,4}){0,4}:%{IPV4})|:))|` +
+		`(?::(?:(?:(?::[0-9A-Fa-f]{1,4}){1,7})|(?:(?::[0-9A-Fa-f]{1,4}){0,5}:%{IPV4})|:)))(?:%.+)?`,
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|[0-1]?[0-9]{1,2})\.){3}(?:25[0-5]|2[0-4][0-9]|[0-1]?[0-9]{1,2})`,
+	"IP":       `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME": `\b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*(?:\.?|\b)`,
+	"IPORHOST": `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	// paths and URIs
+	"PATH":         `(?:%{UNIXPATH}|%{WINPATH})`,
+	"UNIXPATH":     `(?:/(?:[\w_%!$@:.,+~-]+|\\.)*)+`,
+	"TTY":          `(?:/dev/(?:pts|tty(?:[pq])?)(?:\w+)?/?(?:[0-9]+))`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"URIPROTO":     `[A-Za-z](?:[A-Za-z0-9+\-.]+)+`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIQUERY":     `[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPARAM":     `\?%{URIQUERY}`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATH}(?:%{URIPARAM})?)?`,
+
+	// dates and times
+	"MONTH": `\b(?:[Jj]an(?:uary|uar)?|[Ff]eb(?:ruary|ruar)?|[Mm](?:a|ä)?r(?:ch|z)?|[Aa]pr(?:il)?|[Mm]a(?:y|i)?|[Jj]un(?:e|i)?|[Jj]ul(?:y|i)?|` +
+		`[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo](?:c|k)?t(?:ober)?|[Nn]ov(?:ember)?|[Dd]e(?:c|z)(?:ember)?)\b`,
+	"MONTHNUM":           `(?:0?[1-9]|1[0-2])`,
+	"MONTHNUM2":          `(?:0[1-9]|1[0-2])`,
+	"MONTHDAY":           `(?:(?:0[1-9])|(?:[12][0-9])|(?:3[01])|[1-9])`,
+	"DAY":                `(?:Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?)`,
+	"YEAR":               `(?:\d\d){1,2}`,
+	"HOUR":               `(?:2[0123]|[01]?[0-9])`,
+	"MINUTE":             `(?:[0-5][0-9])`,
+	"SECOND":             `(?:(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?)`,
+	"TIME":               `(?:%{HOUR}:%{MINUTE}(?::%{SECOND}))`,
+	"DATE_US":            `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":            `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":   `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"ISO8601_SECOND":     `%{SECOND}`,
+	"TIMESTAMP_ISO8601":  `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"DATE":               `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":          `%{DATE}[- ]%{TIME}`,
+	"TZ":                 `(?:[APMCE][SD]T|UTC)`,
+	"DATESTAMP_RFC822":   `%{DAY} %{MONTH} %{MONTHDAY} %{YEAR} %{TIME} %{TZ}`,
+	"DATESTAMP_RFC2822":  `%{DAY}, %{MONTHDAY} %{MONTH} %{YEAR} %{TIME} %{ISO8601_TIMEZONE}`,
+	"DATESTAMP_OTHER":    `%{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{TZ} %{YEAR}`,
+	"DATESTAMP_EVENTLOG": `%{YEAR}%{MONTHNUM2}%{MONTHDAY}%{HOUR}%{MINUTE}%{SECOND}`,
+	"HTTPDATE":           `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+
+	// syslog
+	"SYSLOGTIMESTAMP": `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"PROG":            `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":      `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST":      `%{IPORHOST}`,
+	"SYSLOGFACILITY":  `<%{NONNEGINT:facility}.%{NONNEGINT:priority}>`,
+	"SYSLOGBASE":      `%{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"LOGLEVEL": `(?:[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo?(?:rmation)?|INFO?(?:RMATION)?|` +
+		`[Ww]arn?(?:ing)?|WARN?(?:ING)?|[Ee]rr?(?:or)?|ERR?(?:OR)?|[Cc]rit?(?:ical)?|CRIT?(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|EMERG(?:ENCY)?|[Ee]merg(?:ency)?)`,
+
+	// Apache and nginx access logs
+	"HTTPDUSER":       `%{EMAILADDRESS}|%{USER}`,
+	"HTTPDERROR_DATE": `%{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{YEAR}`,
+	"COMMONAPACHELOG": `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{HTTPDUSER:auth} \[%{HTTPDATE:timestamp}\] ` +
+		`"(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+	"HTTPD_COMMONLOG":   `%{COMMONAPACHELOG}`,
+	"HTTPD_COMBINEDLOG": `%{COMBINEDAPACHELOG}`,
+
+	// HAProxy logs
+	"HAPROXYTIME":                    `%{HOUR}:%{MINUTE}(?::%{SECOND})`,
+	"HAPROXYDATE":                    `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{HAPROXYTIME}.%{INT}`,
+	"HAPROXYCAPTUREDREQUESTHEADERS":  `%{DATA:captured_request_headers}`,
+	"HAPROXYCAPTUREDRESPONSEHEADERS": `%{DATA:captured_response_headers}`,
+	"HAPROXYHTTPBASE": `%{IP:client_ip}:%{INT:client_port} \[%{HAPROXYDATE:accept_date}\] %{NOTSPACE:frontend_name} %{NOTSPACE:backend_name}/%{NOTSPACE:server_name} ` +
+		`%{INT:time_request}/%{INT:time_queue}/%{INT:time_backend_connect}/%{INT:time_backend_response}/%{NOTSPACE:time_duration} %{INT:http_status_code} ` +
+		`%{NOTSPACE:bytes_read} %{DATA:captured_request_cookie} %{DATA:captured_response_cookie} %{NOTSPACE:termination_state} ` +
+		`%{INT:actconn}/%{INT:feconn}/%{INT:beconn}/%{INT:srvconn}/%{NOTSPACE:retries} %{INT:srv_queue}/%{INT:backend_queue} ` +
+		`(?:\{%{HAPROXYCAPTUREDREQUESTHEADERS}\})?(?: )?(?:\{%{HAPROXYCAPTUREDRESPONSEHEADERS}\})?(?: )?` +
+		`"(?:<BADREQ>|(?:%{WORD:http_verb} (?:%{URIPROTO:http_proto}://)?(?:%{USER:http_user}(?::[^@]*)?@)?(?:%{URIHOST:http_host})?` +
+		`(?:%{URIPATHPARAM:http_request})?(?: HTTP/%{NUMBER:http_version})?))?"?`,
+	"HAPROXYHTTP": `(?:%{SYSLOGTIMESTAMP:syslog_timestamp}|%{TIMESTAMP_ISO8601:timestamp8601}) %{IPORHOST:syslog_server} %{SYSLOGPROG}: %{HAPROXYHTTPBASE}`,
+	"HAPROXYTCP": `(?:%{SYSLOGTIMESTAMP:syslog_timestamp}|%{TIMESTAMP_ISO8601:timestamp8601}) %{IPORHOST:syslog_server} %{SYSLOGPROG}: ` +
+		`%{IP:client_ip}:%{INT:client_port} \[%{HAPROXYDATE:accept_date}\] %{NOTSPACE:frontend_name} %{NOTSPACE:backend_name}/%{NOTSPACE:server_name} ` +
+		`%{INT:time_queue}/%{INT:time_backend_connect}/%{NOTSPACE:time_duration} %{NOTSPACE:bytes_read} %{NOTSPACE:termination_state} ` +
+		`%{INT:actconn}/%{INT:feconn}/%{INT:beconn}/%{INT:srvconn}/%{NOTSPACE:retries} %{INT:srv_queue}/%{INT:backend_queue}`,
+
+	// PostgreSQL logs
+	"POSTGRESQL": `%{DATESTAMP:timestamp} %{TZ} %{DATA:user_id} %{GREEDYDATA:connection_id} %{POSINT:pid}`,
+}
+
+// grokCustomPatterns contains user-supplied grok patterns set via SetGrokPatterns.
+var grokCustomPatterns atomic.Pointer[map[string]string]
+
+// SetGrokPatterns sets user-supplied grok patterns, which can be referred by grok pipe in addition to the built-in patterns.
+//
+// The patterns override the built-in patterns with the same names. An error is returned if some of the patterns cannot be compiled;
+// the previously set patterns remain active in this case.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#grok-pipe
+func SetGrokPatterns(patterns map[string]string) error {
+	for name := range patterns {
+		if !isGrokPatternName(name) {
+			return fmt.Errorf("invalid grok pattern name %q; it must contain only alphanumeric chars and underscores", name)
+		}
+		if _, err := compileGrokPattern("%{"+name+"}", patterns); err != nil {
+			return fmt.Errorf("cannot compile grok pattern %q: %w", name, err)
+		}
+	}
+	grokCustomPatterns.Store(&patterns)
+	return nil
+}
+
+// ParseGrokPatterns parses grok patterns from data in Logstash patterns file format.
+//
+// Every non-empty line must contain pattern name followed by whitespace and the pattern itself. Lines starting with # are ignored.
+func ParseGrokPatterns(data []byte) (map[string]string, error) {
+	patterns := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n := strings.IndexAny(line, " \t")
+		if n < 0 {
+			return nil, fmt.Errorf("line %d: missing pattern after the name %q", i+1, line)
+		}
+		name := line[:n]
+		if !isGrokPatternName(name) {
+			return nil, fmt.Errorf("line %d: invalid pattern name %q; it must contain only alphanumeric chars and underscores", i+1, name)
+		}
+		if _, ok := patterns[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate pattern name %q", i+1, name)
+		}
+		patterns[name] = strings.TrimSpace(line[n:])
+	}
+	return patterns, nil
+}
+
+func getGrokPattern(name string, customPatterns map[string]string) (string, bool) {
+	if p, ok := customPatterns[name]; ok {
+		return p, true
+	}
+	p, ok := grokBuiltinPatterns[name]
+	return p, ok
+}
+
+func isGrokPatternName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// grokPattern is a compiled grok pattern.
+type grokPattern struct {
+	// re is the regexp obtained from the grok pattern.
+	re *regexp.Regexp
+
+	// fields contains field names per every capturing group in re. Groups with duplicate names have empty field names.
+	fields []string
+
+	// hasFields is set to true if the pattern captures at least a single field.
+	hasFields bool
+
+	// aliases contains the index of the first capturing group with the same field name per every capturing group in re.
+	// It contains -1 for groups without duplicates.
+	aliases []int
+
+	// types contains optional type conversion hints per every capturing group in re.
+	types []string
+}
+
+// grokGroupPrefix is the prefix for regexp capturing groups generated for %{PATTERN:field} references.
+const grokGroupPrefix = "__grok_"
+
+var grokReferenceRe = regexp.MustCompile(`%\{(\w+)(?::([^:{}]+))?(?::(\w+))?\}`)
+
+// compileGrokPattern compiles grok pattern s with the given customPatterns.
+//
+// s may refer to built-in patterns and to customPatterns via %{PATTERN}, %{PATTERN:field} and %{PATTERN:field:type} syntax.
+func compileGrokPattern(s string, customPatterns map[string]string) (*grokPattern, error) {
+	var refFields, refTypes []string
+	var expand func(s string, stack []string) (string, error)
+	expand = func(s string, stack []string) (string, error) {
+		var err error
+		result := grokReferenceRe.ReplaceAllStringFunc(s, func(ref string) string {
+			if err != nil {
+				return ""
+			}
+			m := grokReferenceRe.FindStringSubmatch(ref)
+			name, field, typ := m[1], m[2], m[3]
+
+			for _, prev := range stack {
+				if prev == name {
+					err = fmt.Errorf("recursive reference to %%{%s}", name)
+					return ""
+				}
+			}
+			if len(stack) >= 32 {
+				err = fmt.Errorf("too deep nesting of %%{%s}", name)
+				return ""
+			}
+			p, ok := getGrokPattern(name, customPatterns)
+			if !ok {
+				err = fmt.Errorf("unknown pattern %%{%s}", name)
+				return ""
+			}
+			switch typ {
+			case "", "int", "float":
+			default:
+				err = fmt.Errorf("unsupported type %q in %s; supported types: int, float", typ, ref)
+				return ""
+			}
+
+			expanded, errLocal := expand(p, append(stack, name))
+			if errLocal != nil {
+				err = errLocal
+				return ""
+			}
+			if field == "" {
+				return "(?:" + expanded + ")"
+			}
+			groupName := grokGroupPrefix + strconv.Itoa(len(refFields))
+			refFields = append(refFields, getGrokFieldName(field))
+			refTypes = append(refTypes, typ)
+			return "(?P<" + groupName + ">" + expanded + ")"
+		})
+		return result, err
+	}
+
+	reStr, err := expand(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexpCompile(reStr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile regexp obtained from the pattern: %w", err)
+	}
+
+	names := re.SubexpNames()
+	gp := &grokPattern{
+		re:      re,
+		fields:  make([]string, len(names)),
+		aliases: make([]int, len(names)),
+		types:   make([]string, len(names)),
+	}
+	firstIdxs := make(map[string]int)
+	for i, name := range names {
+		gp.aliases[i] = -1
+		if name == "" {
+			continue
+		}
+		field := name
+		if n, ok := strings.CutPrefix(name, grokGroupPrefix); ok {
+			refIdx, err := strconv.Atoi(n)
+			if err != nil || refIdx >= len(refFields) {
+				return nil, fmt.Errorf("unexpected capturing group name %q", name)
+			}
+			field = refFields[refIdx]
+			gp.types[i] = refTypes[refIdx]
+		}
+		if firstIdx, ok := firstIdxs[field]; ok {
+			gp.aliases[i] = firstIdx
+			continue
+		}
+		firstIdxs[field] = i
+		gp.fields[i] = field
+		gp.hasFields = true
+	}
+	return gp, nil
+}
+
+// getGrokFieldName converts grok field name to VictoriaLogs field name.
+//
+// Logstash-style nested field names such as [http][request][method] are converted to http.request.method.
+func getGrokFieldName(s string) string {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	return strings.ReplaceAll(s, "][", ".")
+}
+
+// convertGrokValue converts v according to the given type hint.
+//
+// Empty string is returned if v cannot be converted to the given type.
+func convertGrokValue(typ, v string) string {
+	switch typ {
+	case "int":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			f, ok := tryParseFloat64Exact(v)
+			if !ok {
+				return ""
+			}
+			return strconv.FormatInt(int64(f), 10)
+		}
+		return strconv.FormatInt(n, 10)
+	case "float":
+		f, ok := tryParseFloat64Exact(v)
+		if !ok {
+			return ""
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	default:
+		return v
+	}
+}
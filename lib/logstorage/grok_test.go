@@ -0,0 +1,208 @@
+package logstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGrokBuiltinPatterns(t *testing.T) {
+	for name := range grokBuiltinPatterns {
+		if _, err := compileGrokPattern("%{"+name+"}", nil); err != nil {
+			t.Fatalf("cannot compile built-in pattern %q: %s", name, err)
+		}
+	}
+}
+
+func TestGrokBuiltinPatternsMatch(t *testing.T) {
+	f := func(name, s string, fieldsExpected map[string]string) {
+		t.Helper()
+
+		gp, err := compileGrokPattern("^%{"+name+"}$", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		m := gp.re.FindStringSubmatch(s)
+		if m == nil {
+			t.Fatalf("pattern %s doesn't match %q", name, s)
+		}
+		fields := make(map[string]string)
+		for i, field := range gp.fields {
+			if field != "" && m[i] != "" {
+				fields[field] = m[i]
+			}
+		}
+		if !reflect.DeepEqual(fields, fieldsExpected) {
+			t.Fatalf("unexpected fields for %s\ngot\n%v\nwant\n%v", name, fields, fieldsExpected)
+		}
+	}
+
+	f("IPV4", "192.168.10.255", map[string]string{})
+	f("IPV6", "2001:db8::ff00:42:8329", map[string]string{})
+	f("IPV6", "::ffff:192.0.2.128", map[string]string{})
+	f("IPORHOST", "my-host.example.com", map[string]string{})
+	f("UUID", "123e4567-e89b-12d3-a456-426614174000", map[string]string{})
+	f("TIMESTAMP_ISO8601", "2024-01-02T03:04:05.123Z", map[string]string{})
+	f("HTTPDATE", "10/Oct/2000:13:55:36 -0700", map[string]string{})
+	f("URI", "https://user@example.com:8080/path/to?a=b&c=d", map[string]string{})
+	f("LOGLEVEL", "WARNING", map[string]string{})
+	f("SYSLOGBASE", "Mar  7 12:01:02 web-1 sshd[1234]:", map[string]string{
+		"timestamp": "Mar  7 12:01:02",
+		"logsource": "web-1",
+		"program":   "sshd",
+		"pid":       "1234",
+	})
+	f("POSTGRESQL", "01/02/2024 03:04:05 UTC postgres [unknown] 12345", map[string]string{
+		"timestamp":     "01/02/2024 03:04:05",
+		"user_id":       "postgres",
+		"connection_id": "[unknown]",
+		"pid":           "12345",
+	})
+	f("HAPROXYHTTP", `Sep 14 02:01:37 lb haproxy[14387]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"`, map[string]string{
+		"syslog_timestamp":         "Sep 14 02:01:37",
+		"syslog_server":            "lb",
+		"program":                  "haproxy",
+		"pid":                      "14387",
+		"client_ip":                "10.0.1.2",
+		"client_port":              "33317",
+		"accept_date":              "06/Feb/2009:12:14:14.655",
+		"frontend_name":            "http-in",
+		"backend_name":             "static",
+		"server_name":              "srv1",
+		"time_request":             "10",
+		"time_queue":               "0",
+		"time_backend_connect":     "30",
+		"time_backend_response":    "69",
+		"time_duration":            "109",
+		"http_status_code":         "200",
+		"bytes_read":               "2750",
+		"captured_request_cookie":  "-",
+		"captured_response_cookie": "-",
+		"termination_state":        "----",
+		"actconn":                  "1",
+		"feconn":                   "1",
+		"beconn":                   "1",
+		"srvconn":                  "1",
+		"retries":                  "0",
+		"srv_queue":                "0",
+		"backend_queue":            "0",
+		"captured_request_headers": "1wt.eu",
+		"http_verb":                "GET",
+		"http_request":             "/index.html",
+		"http_version":             "1.1",
+	})
+}
+
+func TestCompileGrokPatternFailure(t *testing.T) {
+	f := func(s string, customPatterns map[string]string) {
+		t.Helper()
+
+		if _, err := compileGrokPattern(s, customPatterns); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	f("%{UNKNOWN}", nil)
+	f("%{INT:x:bool}", nil)
+	f("%{A:x}", map[string]string{
+		"A": "%{B}",
+		"B": "%{A}",
+	})
+	f("%{A:x}", map[string]string{
+		"A": "(?<=foo)bar",
+	})
+}
+
+func TestParseGrokPatternsSuccess(t *testing.T) {
+	data := `
+# comment
+MYAPP_ID [a-z]{3}-\d+
+MYAPP_LINE	%{TIMESTAMP_ISO8601:time} id=%{MYAPP_ID:id}
+`
+	patterns, err := ParseGrokPatterns([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	patternsExpected := map[string]string{
+		"MYAPP_ID":   `[a-z]{3}-\d+`,
+		"MYAPP_LINE": `%{TIMESTAMP_ISO8601:time} id=%{MYAPP_ID:id}`,
+	}
+	if !reflect.DeepEqual(patterns, patternsExpected) {
+		t.Fatalf("unexpected patterns\ngot\n%v\nwant\n%v", patterns, patternsExpected)
+	}
+}
+
+func TestParseGrokPatternsFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := ParseGrokPatterns([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for %q", data)
+		}
+	}
+
+	f("FOO")
+	f("FOO-BAR \\d+")
+	f("FOO \\d+\nFOO \\w+")
+}
+
+func TestSetGrokPatterns(t *testing.T) {
+	defer grokCustomPatterns.Store(nil)
+
+	if err := SetGrokPatterns(map[string]string{
+		"MYAPP_ID": `[a-z]{3}-\d+`,
+		"INT":      `\d+`,
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectPipeResults(t, `grok "id=%{MYAPP_ID:id} n=%{INT:n}"`, [][]Field{
+		{
+			{"_msg", "id=abc-123 n=-5"},
+		},
+		{
+			{"_msg", "id=abc-123 n=5"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", "id=abc-123 n=-5"},
+			{"id", ""},
+			{"n", ""},
+		},
+		{
+			{"_msg", "id=abc-123 n=5"},
+			{"id", "abc-123"},
+			{"n", "5"},
+		},
+	})
+
+	// invalid patterns do not override the previously set patterns
+	if err := SetGrokPatterns(map[string]string{
+		"MYAPP_ID": `%{UNKNOWN}`,
+	}); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if p := grokCustomPatterns.Load(); p == nil || (*p)["MYAPP_ID"] != `[a-z]{3}-\d+` {
+		t.Fatalf("unexpected custom patterns after failed update")
+	}
+}
+
+func TestConvertGrokValue(t *testing.T) {
+	f := func(typ, v, resultExpected string) {
+		t.Helper()
+
+		result := convertGrokValue(typ, v)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for convertGrokValue(%q, %q); got %q; want %q", typ, v, result, resultExpected)
+		}
+	}
+
+	f("", "foo", "foo")
+	f("int", "123", "123")
+	f("int", "+007", "7")
+	f("int", "-12.9", "-12")
+	f("int", "foo", "")
+	f("float", "1.50", "1.5")
+	f("float", "1e3", "")
+	f("float", "-0.25", "-0.25")
+	f("float", "bar", "")
+}
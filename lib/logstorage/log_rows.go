@@ -245,6 +245,47 @@ func (lr *LogRows) ForEachRow(callback func(streamHash uint64, r *InsertRow)) {
 	PutInsertRow(r)
 }
 
+// FilterRows leaves only rows for which keep returns true.
+//
+// The callback mustn't hold references to r after returning.
+func (lr *LogRows) FilterRows(keep func(streamHash uint64, r *InsertRow) bool) {
+	r := GetInsertRow()
+	dstIdx := 0
+	for i, timestamp := range lr.timestamps {
+		sid := &lr.streamIDs[i]
+
+		streamHash := sid.id.lo ^ sid.id.hi
+
+		r.TenantID = sid.tenantID
+		r.StreamTagsCanonical = lr.streamTagsCanonicals[i]
+		r.Timestamp = timestamp
+		r.Fields = lr.rows[i]
+
+		if !keep(streamHash, r) {
+			continue
+		}
+
+		lr.streamIDs[dstIdx] = lr.streamIDs[i]
+		lr.streamTagsCanonicals[dstIdx] = lr.streamTagsCanonicals[i]
+		lr.timestamps[dstIdx] = timestamp
+		lr.rows[dstIdx] = lr.rows[i]
+		dstIdx++
+	}
+	// remove reference to logRows fields
+	// since reset of r can modify actual LogRows
+	r.Fields = nil
+	PutInsertRow(r)
+
+	clear(lr.streamTagsCanonicals[dstIdx:])
+	lr.streamTagsCanonicals = lr.streamTagsCanonicals[:dstIdx]
+
+	lr.streamIDs = lr.streamIDs[:dstIdx]
+	lr.timestamps = lr.timestamps[:dstIdx]
+
+	clear(lr.rows[dstIdx:])
+	lr.rows = lr.rows[:dstIdx]
+}
+
 // Reset resets lr with all its settings.
 //
 // Call ResetKeepSettings() for resetting lr without resetting its settings.
@@ -680,6 +721,14 @@ func (r *InsertRow) Reset() {
 	r.Fields = r.Fields[:0]
 }
 
+// GetStreamHash returns the hash for the log stream r belongs to.
+//
+// The returned hash is the same as the streamHash passed to the callback at LogRows.ForEachRow() for r added via LogRows.MustAddInsertRow().
+func (r *InsertRow) GetStreamHash() uint64 {
+	id := hash128(bytesutil.ToUnsafeBytes(r.StreamTagsCanonical))
+	return id.lo ^ id.hi
+}
+
 // Marshal appends marshaled r to dst and returns the result.
 func (r *InsertRow) Marshal(dst []byte) []byte {
 	dst = r.TenantID.marshal(dst)
@@ -2,6 +2,7 @@ package logstorage
 
 import (
 	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -348,3 +349,72 @@ func TestInsertRow_MarshalUnmarshal(t *testing.T) {
 		t.Fatalf("unexpected tail left after unmarshaling InsertRow; len(tail)=%d; tail=%X", len(tail), tail)
 	}
 }
+
+func TestLogRows_FilterRows(t *testing.T) {
+	f := func(rows []string, keepMsgs []string, resultExpected []string) {
+		t.Helper()
+
+		lr := GetLogRows([]string{"host"}, nil, nil, nil, "")
+		defer PutLogRows(lr)
+
+		p := GetJSONParser()
+		defer PutJSONParser(p)
+
+		tid := TenantID{
+			AccountID: 123,
+			ProjectID: 456,
+		}
+		for i, r := range rows {
+			if err := p.ParseLogMessage([]byte(r), nil); err != nil {
+				t.Fatalf("unexpected error when parsing %q: %s", r, err)
+			}
+			lr.MustAdd(tid, int64(i), p.Fields, -1)
+		}
+
+		streamHashes := make(map[string]uint64)
+		lr.ForEachRow(func(streamHash uint64, r *InsertRow) {
+			streamHashes[r.Fields[len(r.Fields)-1].Value] = streamHash
+		})
+
+		lr.FilterRows(func(streamHash uint64, r *InsertRow) bool {
+			msg := r.Fields[len(r.Fields)-1].Value
+			if streamHash != streamHashes[msg] {
+				t.Fatalf("unexpected streamHash for the row %q; got %d; want %d", msg, streamHash, streamHashes[msg])
+			}
+			if streamHash != r.GetStreamHash() {
+				t.Fatalf("unexpected streamHash for the row %q; got %d; want %d", msg, streamHash, r.GetStreamHash())
+			}
+			return slices.Contains(keepMsgs, msg)
+		})
+
+		var result []string
+		for i := 0; i < lr.RowsCount(); i++ {
+			result = append(result, lr.GetRowString(i))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	rows := []string{
+		`{"host":"foo","_msg":"a"}`,
+		`{"host":"bar","_msg":"b"}`,
+		`{"host":"foo","_msg":"c"}`,
+	}
+
+	// keep all the rows
+	f(rows, []string{"a", "b", "c"}, []string{
+		`{"_msg":"a","_stream":"{host=\"foo\"}","_time":"1970-01-01T00:00:00Z","host":"foo"}`,
+		`{"_msg":"b","_stream":"{host=\"bar\"}","_time":"1970-01-01T00:00:00.000000001Z","host":"bar"}`,
+		`{"_msg":"c","_stream":"{host=\"foo\"}","_time":"1970-01-01T00:00:00.000000002Z","host":"foo"}`,
+	})
+
+	// drop some rows
+	f(rows, []string{"b", "c"}, []string{
+		`{"_msg":"b","_stream":"{host=\"bar\"}","_time":"1970-01-01T00:00:00.000000001Z","host":"bar"}`,
+		`{"_msg":"c","_stream":"{host=\"foo\"}","_time":"1970-01-01T00:00:00.000000002Z","host":"foo"}`,
+	})
+
+	// drop all the rows
+	f(rows, nil, nil)
+}
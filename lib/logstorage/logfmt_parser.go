@@ -87,3 +87,14 @@ func putLogfmtParser(p *logfmtParser) {
 }
 
 var logfmtParserPool sync.Pool
+
+// ParseLogfmt parses logfmt-encoded s and appends the parsed fields to dst.
+//
+// The returned fields may refer to s.
+func ParseLogfmt(dst []Field, s string) []Field {
+	p := getLogfmtParser()
+	p.parse(s)
+	dst = append(dst, p.fields...)
+	putLogfmtParser(p)
+	return dst
+}
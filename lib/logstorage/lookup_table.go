@@ -0,0 +1,176 @@
+package logstorage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// LookupTable is a static table, which can be used by `lookup` pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#lookup-pipe
+type LookupTable struct {
+	// columns contains column names for the table
+	columns []string
+
+	// rows contains table rows. Every row contains values for all the columns.
+	rows [][]string
+}
+
+// RowsCount returns the number of rows in lt.
+func (lt *LookupTable) RowsCount() int {
+	return len(lt.rows)
+}
+
+// getColumnValues returns rows with the values for the given columns.
+func (lt *LookupTable) getColumnValues(columns []string) ([][]string, error) {
+	idxs := make([]int, len(columns))
+	for i, column := range columns {
+		idx := slices.Index(lt.columns, column)
+		if idx < 0 {
+			return nil, fmt.Errorf("missing column %q; available columns: %s", column, fieldNamesString(lt.columns))
+		}
+		idxs[i] = idx
+	}
+
+	rows := make([][]string, len(lt.rows))
+	for i, row := range lt.rows {
+		values := make([]string, len(idxs))
+		for j, idx := range idxs {
+			values[j] = row[idx]
+		}
+		rows[i] = values
+	}
+	return rows, nil
+}
+
+// ParseLookupTableCSV parses lookup table from CSV data.
+//
+// The first line must contain column names.
+func ParseLookupTableCSV(data []byte) (*LookupTable, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.ReuseRecord = false
+
+	columns, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing header with column names")
+		}
+		return nil, fmt.Errorf("cannot read header with column names: %w", err)
+	}
+	for i, column := range columns {
+		columns[i] = strings.TrimSpace(column)
+	}
+	if err := checkLookupTableColumns(columns); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("cannot read row #%d: %w", len(rows)+1, err)
+		}
+		rows = append(rows, row)
+	}
+
+	lt := &LookupTable{
+		columns: columns,
+		rows:    rows,
+	}
+	return lt, nil
+}
+
+// ParseLookupTableJSON parses lookup table from JSON lines data.
+//
+// Every line must contain JSON object. Nested objects are flattened in the same way as for the ingested logs.
+// Missing fields are treated as empty values.
+func ParseLookupTableJSON(data []byte) (*LookupTable, error) {
+	p := GetJSONParser()
+	defer PutJSONParser(p)
+
+	var columns []string
+	var rowsFields [][]Field
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := p.ParseLogMessage(line, nil); err != nil {
+			return nil, fmt.Errorf("cannot parse line #%d: %w", n+1, err)
+		}
+		fields := make([]Field, len(p.Fields))
+		for i, f := range p.Fields {
+			name := strings.Clone(f.Name)
+			fields[i] = Field{
+				Name:  name,
+				Value: strings.Clone(f.Value),
+			}
+			if !slices.Contains(columns, name) {
+				columns = append(columns, name)
+			}
+		}
+		rowsFields = append(rowsFields, fields)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("missing columns")
+	}
+	if err := checkLookupTableColumns(columns); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, len(rowsFields))
+	for i, fields := range rowsFields {
+		row := make([]string, len(columns))
+		for _, f := range fields {
+			row[slices.Index(columns, f.Name)] = f.Value
+		}
+		rows[i] = row
+	}
+
+	lt := &LookupTable{
+		columns: columns,
+		rows:    rows,
+	}
+	return lt, nil
+}
+
+func checkLookupTableColumns(columns []string) error {
+	for i, column := range columns {
+		if column == "" {
+			return fmt.Errorf("column #%d has empty name", i+1)
+		}
+		if slices.Contains(columns[:i], column) {
+			return fmt.Errorf("duplicate column %q", column)
+		}
+	}
+	return nil
+}
+
+var lookupTables atomic.Pointer[map[string]*LookupTable]
+
+// SetLookupTables sets lookup tables, which can be referred by name at `lookup` pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#lookup-pipe
+func SetLookupTables(tables map[string]*LookupTable) {
+	lookupTables.Store(&tables)
+}
+
+func getLookupTable(name string) (*LookupTable, error) {
+	p := lookupTables.Load()
+	if p == nil {
+		return nil, fmt.Errorf("unknown lookup table %q; lookup tables must be put into the directory specified via -search.lookupTablesDir command-line flag", name)
+	}
+	lt, ok := (*p)[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown lookup table %q", name)
+	}
+	return lt, nil
+}
@@ -0,0 +1,102 @@
+package logstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLookupTableCSVSuccess(t *testing.T) {
+	f := func(data string, columnsExpected []string, rowsExpected [][]string) {
+		t.Helper()
+
+		lt, err := ParseLookupTableCSV([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(lt.columns, columnsExpected) {
+			t.Fatalf("unexpected columns\ngot\n%q\nwant\n%q", lt.columns, columnsExpected)
+		}
+		if !reflect.DeepEqual(lt.rows, rowsExpected) {
+			t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", lt.rows, rowsExpected)
+		}
+	}
+
+	f("host", []string{"host"}, nil)
+	f("host, dc\nh1,dc1\nh2,\"dc,2\"\n", []string{"host", "dc"}, [][]string{
+		{"h1", "dc1"},
+		{"h2", "dc,2"},
+	})
+}
+
+func TestParseLookupTableCSVFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := ParseLookupTableCSV([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// missing header
+	f("")
+
+	// empty column name
+	f("host,,dc")
+
+	// duplicate column name
+	f("host,dc,host")
+
+	// unexpected number of values
+	f("host,dc\nh1")
+	f("host,dc\nh1,dc1,foo")
+
+	// invalid quoting
+	f("host,dc\nh1,\"dc1")
+}
+
+func TestParseLookupTableJSONSuccess(t *testing.T) {
+	f := func(data string, columnsExpected []string, rowsExpected [][]string) {
+		t.Helper()
+
+		lt, err := ParseLookupTableJSON([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(lt.columns, columnsExpected) {
+			t.Fatalf("unexpected columns\ngot\n%q\nwant\n%q", lt.columns, columnsExpected)
+		}
+		if !reflect.DeepEqual(lt.rows, rowsExpected) {
+			t.Fatalf("unexpected rows\ngot\n%q\nwant\n%q", lt.rows, rowsExpected)
+		}
+	}
+
+	f(`{"host":"h1","dc":"dc1"}`, []string{"host", "dc"}, [][]string{
+		{"h1", "dc1"},
+	})
+	f(`
+{"host":"h1","dc":"dc1"}
+
+{"host":"h2","location":{"rack":12}}
+`, []string{"host", "dc", "location.rack"}, [][]string{
+		{"h1", "dc1", ""},
+		{"h2", "", "12"},
+	})
+}
+
+func TestParseLookupTableJSONFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		if _, err := ParseLookupTableJSON([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// missing columns
+	f("")
+	f("{}")
+
+	// invalid JSON
+	f(`{"host":"h1"`)
+	f(`["h1"]`)
+}
@@ -0,0 +1,955 @@
+package logstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// materializedViewStreamField is the name of the log stream field, which holds the view name at pre-aggregated rows.
+const materializedViewStreamField = "_materialized_view"
+
+// MaterializedView is a stats query, which is evaluated incrementally over the ingested logs.
+//
+// The query must have the form `<filter> | stats by (_time:step, field1, ..., fieldN) <funcs>`,
+// where <funcs> may contain count(), count(field), sum(field), min(field) and max(field).
+//
+// See https://docs.victoriametrics.com/victorialogs/#materialized-views
+type MaterializedView struct {
+	// Name is the name of the view.
+	Name string
+
+	// q is the parsed view query.
+	q *Query
+
+	// f is the filter for logs the view is applied to.
+	f filter
+
+	// filterFields contains the fields needed by f.
+	filterFields prefixfilter.Filter
+
+	// filterStr is the string representation of f.
+	filterStr string
+
+	// step is the _time bucket size in nanoseconds.
+	step int64
+
+	// byFields contains `by (...)` fields except of _time.
+	byFields []string
+
+	// funcs contains stats functions for the view.
+	funcs []materializedViewFunc
+}
+
+type materializedViewFuncKind int
+
+const (
+	materializedViewFuncCount = materializedViewFuncKind(iota)
+	materializedViewFuncSum
+	materializedViewFuncMin
+	materializedViewFuncMax
+)
+
+type materializedViewFunc struct {
+	kind materializedViewFuncKind
+
+	// field is the field the func is applied to. It is empty for count().
+	field string
+
+	// resultName is the name of the field with the pre-aggregated func result.
+	resultName string
+}
+
+// NewMaterializedView returns new MaterializedView with the given name for the given LogsQL stats query.
+func NewMaterializedView(name, query string) (*MaterializedView, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing view name")
+	}
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse query [%s]: %w", query, err)
+	}
+
+	if q.isTimestampDependent {
+		return nil, fmt.Errorf("the query [%s] mustn't contain relative time filters", q)
+	}
+	if q.opts.timeOffset != 0 || q.opts.ignoreGlobalTimeFilter != nil {
+		return nil, fmt.Errorf("the query [%s] mustn't contain time_offset and ignore_global_time_filter options", q)
+	}
+	if hasTimeFilters(q.f) {
+		return nil, fmt.Errorf("the query [%s] mustn't contain `_time` filters, since the view is applied to all the ingested logs", q)
+	}
+	if getSubqueriesCount(q) > 1 {
+		return nil, fmt.Errorf("the query [%s] mustn't contain subqueries", q)
+	}
+	if len(q.pipes) != 1 {
+		return nil, fmt.Errorf("the query [%s] must contain a single `| stats ...` pipe", q)
+	}
+	ps, ok := q.pipes[0].(*pipeStats)
+	if !ok {
+		return nil, fmt.Errorf("the query [%s] must contain a single `| stats ...` pipe; got `| %s`", q, q.pipes[0])
+	}
+
+	mv := &MaterializedView{
+		Name:      name,
+		q:         q,
+		f:         q.f,
+		filterStr: getNonTimeFilterString(q.f),
+	}
+	q.f.updateNeededFields(&mv.filterFields)
+
+	for _, bf := range ps.byFields {
+		if bf.name == "_time" {
+			if mv.step > 0 {
+				return nil, fmt.Errorf("the query [%s] contains duplicate `_time` field at `by (...)`", q)
+			}
+			if bf.bucketSize <= 0 || bf.bucketOffset != 0 {
+				return nil, fmt.Errorf("the query [%s] must contain `_time:step` with fixed step and without offset at `by (...)`; got `%s`", q, bf)
+			}
+			mv.step = int64(bf.bucketSize)
+			continue
+		}
+		if bf.bucketSizeStr != "" {
+			return nil, fmt.Errorf("the query [%s] mustn't contain buckets for non-time fields at `by (...)`; got `%s`", q, bf)
+		}
+		if isReservedMaterializedViewField(bf.name) || slices.Contains(mv.byFields, bf.name) {
+			return nil, fmt.Errorf("the query [%s] cannot contain the field %q at `by (...)`", q, bf.name)
+		}
+		mv.byFields = append(mv.byFields, bf.name)
+	}
+	if mv.step <= 0 {
+		return nil, fmt.Errorf("the query [%s] must contain `_time:step` at `by (...)`", q)
+	}
+
+	for _, f := range ps.funcs {
+		if f.iff != nil {
+			return nil, fmt.Errorf("the query [%s] mustn't contain `if (...)` filters at stats functions", q)
+		}
+		kind, field, ok := getMaterializedViewFunc(f.f)
+		if !ok {
+			return nil, fmt.Errorf("unsupported stats function `%s` in the query [%s]; supported functions: count(), count(field), sum(field), min(field), max(field)", f.f, q)
+		}
+		if isReservedMaterializedViewField(f.resultName) || f.resultName == "_time" || slices.Contains(mv.byFields, f.resultName) {
+			return nil, fmt.Errorf("the query [%s] cannot contain the result name %q", q, f.resultName)
+		}
+		mv.funcs = append(mv.funcs, materializedViewFunc{
+			kind:       kind,
+			field:      field,
+			resultName: f.resultName,
+		})
+	}
+
+	return mv, nil
+}
+
+// String returns human-readable representation of mv.
+func (mv *MaterializedView) String() string {
+	return fmt.Sprintf("{name=%q, query=%q}", mv.Name, mv.q)
+}
+
+// Query returns the query for mv.
+func (mv *MaterializedView) Query() string {
+	return mv.q.String()
+}
+
+func isReservedMaterializedViewField(name string) bool {
+	switch name {
+	case "_stream", "_stream_id", "_msg", materializedViewStreamField:
+		return true
+	default:
+		return false
+	}
+}
+
+func getMaterializedViewFunc(sf statsFunc) (materializedViewFuncKind, string, bool) {
+	switch t := sf.(type) {
+	case *statsCount:
+		if prefixfilter.MatchAll(t.fieldFilters) {
+			return materializedViewFuncCount, "", true
+		}
+		if isSingleField(t.fieldFilters) {
+			return materializedViewFuncCount, t.fieldFilters[0], true
+		}
+	case *statsSum:
+		if isSingleField(t.fieldFilters) {
+			return materializedViewFuncSum, t.fieldFilters[0], true
+		}
+	case *statsMin:
+		if isSingleField(t.fieldFilters) {
+			return materializedViewFuncMin, t.fieldFilters[0], true
+		}
+	case *statsMax:
+		if isSingleField(t.fieldFilters) {
+			return materializedViewFuncMax, t.fieldFilters[0], true
+		}
+	}
+	return 0, "", false
+}
+
+func hasTimeFilters(f filter) bool {
+	return visitFilterRecursive(f, func(f filter) bool {
+		switch f.(type) {
+		case *filterTime, *filterDayRange, *filterWeekRange:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func getSubqueriesCount(q *Query) int {
+	n := 0
+	q.visitSubqueries(func(_ *Query) {
+		n++
+	})
+	return n
+}
+
+// getNonTimeFilterString returns string representation of f without top-level `_time` filters.
+func getNonTimeFilterString(f filter) string {
+	fa, ok := f.(*filterAnd)
+	if !ok {
+		if _, ok := f.(*filterTime); ok {
+			return "*"
+		}
+		return f.String()
+	}
+
+	var filters []filter
+	for _, f := range fa.filters {
+		if _, ok := f.(*filterTime); !ok {
+			filters = append(filters, f)
+		}
+	}
+	switch len(filters) {
+	case 0:
+		return "*"
+	case 1:
+		return filters[0].String()
+	default:
+		fa := &filterAnd{
+			filters: filters,
+		}
+		return fa.String()
+	}
+}
+
+// MaterializedViews evaluates materialized views over the ingested logs and stores pre-aggregated rows into a dedicated Storage.
+//
+// MaterializedViews must be closed via MustClose when no longer needed.
+type MaterializedViews struct {
+	path string
+
+	// s is the storage for pre-aggregated rows.
+	s *Storage
+
+	flushInterval time.Duration
+
+	// flushDelay is the maximum duration between adding logs to the views and making the pre-aggregated rows for these logs visible for search.
+	//
+	// It consists of the flusher interval with jitter plus the flush interval for the storage with pre-aggregated rows.
+	flushDelay time.Duration
+
+	// statesLock serializes views' updates.
+	statesLock sync.Mutex
+
+	// states contains the currently active views.
+	states atomic.Pointer[[]*materializedViewState]
+
+	rowsMatched   atomic.Uint64
+	rowsFlushed   atomic.Uint64
+	queriesServed atomic.Uint64
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// MaterializedViewsStats contains stats for MaterializedViews.
+type MaterializedViewsStats struct {
+	// ViewsCount is the number of active views.
+	ViewsCount uint64
+
+	// PendingGroups is the number of in-memory groups waiting to be flushed to the storage.
+	PendingGroups uint64
+
+	// RowsMatched is the number of ingested logs matching the views.
+	RowsMatched uint64
+
+	// RowsFlushed is the number of pre-aggregated rows flushed to the storage.
+	RowsFlushed uint64
+
+	// QueriesServed is the number of queries served from pre-aggregated rows.
+	QueriesServed uint64
+}
+
+type materializedViewState struct {
+	mv *MaterializedView
+
+	// readyTime is the start of the first _time bucket, which contains all the matching logs.
+	readyTime atomic.Int64
+
+	mu sync.Mutex
+
+	// m holds in-memory groups, which weren't flushed to the storage yet.
+	m map[string]*materializedViewGroup
+
+	// isDropped is set when the view is removed from the config.
+	isDropped bool
+}
+
+type materializedViewGroup struct {
+	tenantID  TenantID
+	timestamp int64
+	byValues  []string
+	states    []materializedViewFuncState
+}
+
+type materializedViewFuncState struct {
+	count    uint64
+	sum      float64
+	value    string
+	hasItems bool
+}
+
+// materializedViewStateEntry is an entry persisted at materializedViewsStateFilename.
+type materializedViewStateEntry struct {
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	ReadyTime int64  `json:"ready_time"`
+
+	// LastIngestTime is the time when the last logs were ingested into the view before the view was closed.
+	//
+	// It is persisted together with the final flush of in-memory groups at MustClose. It is zero while the view is open,
+	// so zero LastIngestTime means that the in-memory groups could be lost, e.g. because of unclean shutdown.
+	LastIngestTime int64 `json:"last_ingest_time,omitempty"`
+}
+
+const materializedViewsStateFilename = "views.json"
+
+// MustOpenMaterializedViews opens MaterializedViews at the given path with the given views.
+//
+// The storage for pre-aggregated rows uses Retention, FutureRetention, FlushInterval, DefaultParallelReaders
+// and MinFreeDiskSpaceBytes from cfg.
+func MustOpenMaterializedViews(path string, cfg *StorageConfig, views []*MaterializedView) *MaterializedViews {
+	cfgLocal := &StorageConfig{
+		Retention:              cfg.Retention,
+		FutureRetention:        cfg.FutureRetention,
+		FlushInterval:          cfg.FlushInterval,
+		DefaultParallelReaders: cfg.DefaultParallelReaders,
+		MinFreeDiskSpaceBytes:  cfg.MinFreeDiskSpaceBytes,
+	}
+	s := MustOpenStorage(path, cfgLocal)
+
+	flushInterval := cfg.FlushInterval
+	if flushInterval < time.Second {
+		flushInterval = time.Second
+	}
+
+	mvs := &MaterializedViews{
+		path:          path,
+		s:             s,
+		flushInterval: flushInterval,
+		flushDelay:    flushInterval + flushInterval/10 + cfg.FlushInterval,
+		stopCh:        make(chan struct{}),
+	}
+
+	entries := mustReadMaterializedViewsState(filepath.Join(path, materializedViewsStateFilename))
+	states := make([]*materializedViewState, 0, len(views))
+	now := time.Now().UnixNano()
+	for _, mv := range views {
+		readyTime := getMaterializedViewReadyTime(mv, now)
+		for _, e := range entries {
+			if e.Name != mv.Name || e.Query != mv.Query() {
+				continue
+			}
+			if e.LastIngestTime <= 0 {
+				// The view state doesn't cover the logs ingested until now, since the in-memory groups
+				// could be lost before the previous shutdown. So the pre-aggregated rows can be used only for the next full bucket.
+				tsf := TimeFormatter(readyTime)
+				logger.Warnf("materialized view %q wasn't closed properly; it will be used for queries only starting from %s",
+					mv.Name, &tsf)
+				break
+			}
+			readyTime = e.ReadyTime
+			break
+		}
+		states = append(states, newMaterializedViewState(mv, readyTime))
+	}
+	mvs.states.Store(&states)
+	mvs.mustSaveState(0)
+
+	mvs.wg.Go(mvs.runFlusher)
+
+	return mvs
+}
+
+// MustClose flushes the pending pre-aggregated rows and closes mvs.
+func (mvs *MaterializedViews) MustClose() {
+	close(mvs.stopCh)
+	mvs.wg.Wait()
+
+	for _, st := range mvs.getStates() {
+		mvs.flushState(st)
+	}
+	mvs.s.MustClose()
+	mvs.s = nil
+
+	// Persist the last ingest time only after all the in-memory groups are flushed to the storage,
+	// so the next MustOpenMaterializedViews knows the persisted state covers all the ingested logs.
+	mvs.mustSaveState(time.Now().UnixNano())
+}
+
+// UpdateViews replaces views at mvs with the given views.
+//
+// Views with unchanged names and queries keep their pre-aggregated rows. New and changed views
+// are used for queries only after the start of the next `_time` bucket.
+func (mvs *MaterializedViews) UpdateViews(views []*MaterializedView) {
+	mvs.statesLock.Lock()
+	defer mvs.statesLock.Unlock()
+
+	statesOld := mvs.getStates()
+	states := make([]*materializedViewState, 0, len(views))
+	now := time.Now().UnixNano()
+	for _, mv := range views {
+		idx := slices.IndexFunc(statesOld, func(st *materializedViewState) bool {
+			return st.mv.Name == mv.Name && st.mv.Query() == mv.Query()
+		})
+		if idx >= 0 {
+			states = append(states, statesOld[idx])
+		} else {
+			readyTime := getMaterializedViewReadyTime(mv, now)
+			states = append(states, newMaterializedViewState(mv, readyTime))
+		}
+	}
+	mvs.states.Store(&states)
+	mvs.mustSaveState(0)
+
+	for _, st := range statesOld {
+		if !slices.Contains(states, st) {
+			st.mu.Lock()
+			st.isDropped = true
+			st.mu.Unlock()
+			mvs.flushState(st)
+		}
+	}
+}
+
+// ResetReadyTime resets the ready time for views at mvs, so they are used for queries only starting from the first full bucket after deleteTime.
+//
+// It must be called when logs with timestamps up to deleteTime are deleted, since the pre-aggregated rows continue counting the deleted logs.
+// All the views are reset, since the deleted logs may match any view.
+func (mvs *MaterializedViews) ResetReadyTime(deleteTime int64) {
+	mvs.statesLock.Lock()
+	defer mvs.statesLock.Unlock()
+
+	deleteTime = max(deleteTime, time.Now().UnixNano())
+	for _, st := range mvs.getStates() {
+		readyTime := getMaterializedViewReadyTime(st.mv, deleteTime)
+		if readyTime > st.readyTime.Load() {
+			st.readyTime.Store(readyTime)
+		}
+	}
+	mvs.mustSaveState(0)
+}
+
+// Views returns the currently active views at mvs.
+func (mvs *MaterializedViews) Views() []*MaterializedView {
+	states := mvs.getStates()
+	views := make([]*MaterializedView, len(states))
+	for i, st := range states {
+		views[i] = st.mv
+	}
+	return views
+}
+
+// UpdateStats updates ms with stats from mvs.
+func (mvs *MaterializedViews) UpdateStats(ms *MaterializedViewsStats) {
+	states := mvs.getStates()
+	ms.ViewsCount += uint64(len(states))
+	for _, st := range states {
+		st.mu.Lock()
+		ms.PendingGroups += uint64(len(st.m))
+		st.mu.Unlock()
+	}
+	ms.RowsMatched += mvs.rowsMatched.Load()
+	ms.RowsFlushed += mvs.rowsFlushed.Load()
+	ms.QueriesServed += mvs.queriesServed.Load()
+}
+
+// DebugFlush flushes all the pending pre-aggregated rows, so they become visible for search.
+//
+// This function is for debugging and testing purposes only, since it is slow.
+func (mvs *MaterializedViews) DebugFlush() {
+	for _, st := range mvs.getStates() {
+		mvs.flushState(st)
+	}
+	mvs.s.DebugFlush()
+}
+
+func (mvs *MaterializedViews) getStates() []*materializedViewState {
+	return *mvs.states.Load()
+}
+
+func (mvs *MaterializedViews) runFlusher() {
+	d := timeutil.AddJitterToDuration(mvs.flushInterval)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mvs.stopCh:
+			return
+		case <-ticker.C:
+		}
+		for _, st := range mvs.getStates() {
+			mvs.flushState(st)
+		}
+	}
+}
+
+func (mvs *MaterializedViews) flushState(st *materializedViewState) {
+	st.mu.Lock()
+	m := st.m
+	st.m = make(map[string]*materializedViewGroup)
+	st.mu.Unlock()
+
+	if len(m) == 0 {
+		return
+	}
+
+	mv := st.mv
+	lr := GetLogRows(nil, nil, nil, nil, "")
+	fields := make([]Field, 0, 1+len(mv.byFields)+len(mv.funcs))
+	var buf []byte
+	for _, g := range m {
+		fields = append(fields[:0], Field{
+			Name:  materializedViewStreamField,
+			Value: mv.Name,
+		})
+		for i, v := range g.byValues {
+			if v != "" {
+				fields = append(fields, Field{
+					Name:  mv.byFields[i],
+					Value: v,
+				})
+			}
+		}
+		buf = buf[:0]
+		for i, f := range mv.funcs {
+			bufLen := len(buf)
+			buf = g.states[i].marshalResult(buf, f.kind)
+			if len(buf) > bufLen {
+				fields = append(fields, Field{
+					Name:  f.resultName,
+					Value: bytesutil.ToUnsafeString(buf[bufLen:]),
+				})
+			}
+		}
+		lr.MustAdd(g.tenantID, g.timestamp, fields, 1)
+		if lr.NeedFlush() {
+			mvs.s.MustAddRows(lr)
+			lr.ResetKeepSettings()
+		}
+	}
+	mvs.s.MustAddRows(lr)
+	PutLogRows(lr)
+
+	mvs.rowsFlushed.Add(uint64(len(m)))
+}
+
+// mustSaveState persists the state of mvs views with the given lastIngestTime.
+//
+// lastIngestTime must be zero while mvs is open.
+func (mvs *MaterializedViews) mustSaveState(lastIngestTime int64) {
+	states := mvs.getStates()
+	entries := make([]materializedViewStateEntry, len(states))
+	for i, st := range states {
+		entries[i] = materializedViewStateEntry{
+			Name:           st.mv.Name,
+			Query:          st.mv.Query(),
+			ReadyTime:      st.readyTime.Load(),
+			LastIngestTime: lastIngestTime,
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal materialized views state: %s", err)
+	}
+	fs.MustWriteAtomic(filepath.Join(mvs.path, materializedViewsStateFilename), data, true)
+}
+
+// MustResetMaterializedViewsState resets the state of materialized views at the given path.
+//
+// It must be called when logs are ingested without updating materialized views, so the views at the given path
+// do not cover the ingested logs anymore. Such views are used for queries only starting from the next full bucket after they are opened.
+func MustResetMaterializedViewsState(path string) {
+	statePath := filepath.Join(path, materializedViewsStateFilename)
+	if fs.IsPathExist(statePath) {
+		fs.MustRemovePath(statePath)
+	}
+}
+
+func mustReadMaterializedViewsState(path string) []materializedViewStateEntry {
+	if !fs.IsPathExist(path) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read %q: %s", path, err)
+	}
+	var entries []materializedViewStateEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logger.Panicf("FATAL: cannot parse %q: %s", path, err)
+	}
+	return entries
+}
+
+// getMaterializedViewReadyTime returns the start of the first `_time` bucket for mv, which is fully covered by logs ingested after now.
+func getMaterializedViewReadyTime(mv *MaterializedView, now int64) int64 {
+	return getMaterializedViewBucket(now, mv.step) + mv.step
+}
+
+func getMaterializedViewBucket(timestamp, step int64) int64 {
+	d := timestamp % step
+	if d < 0 {
+		d += step
+	}
+	return timestamp - d
+}
+
+func newMaterializedViewState(mv *MaterializedView, readyTime int64) *materializedViewState {
+	st := &materializedViewState{
+		mv: mv,
+		m:  make(map[string]*materializedViewGroup),
+	}
+	st.readyTime.Store(readyTime)
+	return st
+}
+
+// AddRows updates the views at mvs with the logs from lr.
+func (mvs *MaterializedViews) AddRows(lr *LogRows) {
+	for _, st := range mvs.getStates() {
+		n := st.addRows(lr)
+		mvs.rowsMatched.Add(uint64(n))
+	}
+}
+
+func (st *materializedViewState) addRows(lr *LogRows) int {
+	mv := st.mv
+
+	var rowIdxs []int
+	tmpFields := GetFields()
+	var buf []byte
+	prevStreamTagsCanonical := ""
+	prevStream := ""
+	for i, timestamp := range lr.timestamps {
+		buf = buf[:0]
+		tmpFields.Fields = tmpFields.Fields[:0]
+		if mv.filterFields.MatchString("_stream") {
+			if stc := lr.streamTagsCanonicals[i]; stc != prevStreamTagsCanonical || prevStream == "" {
+				prevStreamTagsCanonical = stc
+				prevStream = getStreamTagsString(stc)
+			}
+			tmpFields.Add("_stream", prevStream)
+		}
+		if mv.filterFields.MatchString("_stream_id") {
+			bufLen := len(buf)
+			buf = lr.streamIDs[i].marshalString(buf)
+			tmpFields.Add("_stream_id", bytesutil.ToUnsafeString(buf[bufLen:]))
+		}
+		if mv.filterFields.MatchString("_time") {
+			bufLen := len(buf)
+			buf = marshalTimestampISO8601String(buf, timestamp)
+			tmpFields.Add("_time", bytesutil.ToUnsafeString(buf[bufLen:]))
+		}
+		for _, f := range lr.rows[i] {
+			tmpFields.Fields = addFieldIfNeeded(tmpFields.Fields, &mv.filterFields, f.Name, f.Value)
+		}
+		if mv.f.matchRow(tmpFields.Fields) {
+			rowIdxs = append(rowIdxs, i)
+		}
+	}
+	PutFields(tmpFields)
+
+	if len(rowIdxs) == 0 {
+		return 0
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.isDropped {
+		return 0
+	}
+
+	for _, i := range rowIdxs {
+		tenantID := lr.streamIDs[i].tenantID
+		timestamp := getMaterializedViewBucket(lr.timestamps[i], mv.step)
+		row := lr.rows[i]
+
+		buf = tenantID.marshal(buf[:0])
+		buf = encoding.MarshalInt64(buf, timestamp)
+		for _, name := range mv.byFields {
+			v := getRowFieldValue(row, name)
+			buf = encoding.MarshalBytes(buf, bytesutil.ToUnsafeBytes(v))
+		}
+
+		g := st.m[string(buf)]
+		if g == nil {
+			byValues := make([]string, len(mv.byFields))
+			for j, name := range mv.byFields {
+				byValues[j] = strings.Clone(getRowFieldValue(row, name))
+			}
+			g = &materializedViewGroup{
+				tenantID:  tenantID,
+				timestamp: timestamp,
+				byValues:  byValues,
+				states:    make([]materializedViewFuncState, len(mv.funcs)),
+			}
+			st.m[string(buf)] = g
+		}
+		for j, f := range mv.funcs {
+			g.states[j].update(f, row)
+		}
+	}
+
+	return len(rowIdxs)
+}
+
+func getRowFieldValue(row []Field, name string) string {
+	for _, f := range row {
+		if getCanonicalColumnName(f.Name) == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func (fss *materializedViewFuncState) update(f materializedViewFunc, row []Field) {
+	switch f.kind {
+	case materializedViewFuncCount:
+		if f.field == "" || getRowFieldValue(row, f.field) != "" {
+			fss.count++
+		}
+	case materializedViewFuncSum:
+		v, ok := tryParseNumber(getRowFieldValue(row, f.field))
+		if !ok {
+			return
+		}
+		if fss.hasItems {
+			fss.sum += v
+		} else {
+			fss.sum = v
+			fss.hasItems = true
+		}
+	case materializedViewFuncMin:
+		v := getRowFieldValue(row, f.field)
+		if !fss.hasItems || lessString(v, fss.value) {
+			fss.value = strings.Clone(v)
+			fss.hasItems = true
+		}
+	case materializedViewFuncMax:
+		v := getRowFieldValue(row, f.field)
+		if !fss.hasItems || lessString(fss.value, v) {
+			fss.value = strings.Clone(v)
+			fss.hasItems = true
+		}
+	default:
+		logger.Panicf("BUG: unexpected materialized view func kind: %d", f.kind)
+	}
+}
+
+func (fss *materializedViewFuncState) marshalResult(dst []byte, kind materializedViewFuncKind) []byte {
+	switch kind {
+	case materializedViewFuncCount:
+		return marshalUint64String(dst, fss.count)
+	case materializedViewFuncSum:
+		if !fss.hasItems || math.IsNaN(fss.sum) {
+			// Do not store empty sums, since they break the sum of pre-aggregated rows.
+			return dst
+		}
+		return marshalFloat64String(dst, fss.sum)
+	case materializedViewFuncMin, materializedViewFuncMax:
+		return append(dst, fss.value...)
+	default:
+		logger.Panicf("BUG: unexpected materialized view func kind: %d", kind)
+		return dst
+	}
+}
+
+// RunQuery executes qctx over pre-aggregated rows of the matching view at mvs and passes the results to writeBlock.
+//
+// The newest `_time` buckets, which may be missing in the pre-aggregated rows yet, are executed over the original logs at s.
+//
+// It returns false if there are no views at mvs, which could be used for executing qctx.
+// In this case qctx must be executed over the original logs.
+func (mvs *MaterializedViews) RunQuery(qctx *QueryContext, s *Storage, writeBlock WriteDataBlockFunc) (bool, error) {
+	return mvs.runQuery(qctx, s, writeBlock, time.Now().UnixNano())
+}
+
+func (mvs *MaterializedViews) runQuery(qctx *QueryContext, s *Storage, writeBlock WriteDataBlockFunc, now int64) (bool, error) {
+	if len(qctx.HiddenFieldsFilters) > 0 {
+		// Hidden fields may change the results of the query.
+		return false, nil
+	}
+	qMV, qRaw := mvs.getQuery(qctx.Query, now)
+	if qMV == nil {
+		return false, nil
+	}
+
+	mvs.queriesServed.Add(1)
+	if err := mvs.s.RunQuery(qctx.WithQuery(qMV), writeBlock); err != nil {
+		return true, err
+	}
+	if qRaw == nil {
+		return true, nil
+	}
+	return true, s.RunQuery(qctx.WithQuery(qRaw), writeBlock)
+}
+
+// getQuery returns a query over pre-aggregated rows, which returns the same results as q at the given time now.
+//
+// qRaw is non-nil if the newest `_time` buckets for q must be executed over the original logs, since the pre-aggregated rows
+// for these buckets may be not visible for search yet. The results of qMV and qRaw do not overlap.
+//
+// nil qMV is returned if q cannot be executed over pre-aggregated rows.
+func (mvs *MaterializedViews) getQuery(q *Query, now int64) (qMV, qRaw *Query) {
+	if q.opts.timeOffset != 0 || q.opts.ignoreGlobalTimeFilter != nil {
+		return nil, nil
+	}
+	if len(q.pipes) == 0 {
+		return nil, nil
+	}
+	ps, ok := q.pipes[0].(*pipeStats)
+	if !ok || ps.mode != pipeStatsModeDefault {
+		return nil, nil
+	}
+	if getSubqueriesCount(q) > 1 {
+		return nil, nil
+	}
+
+	filterStr := getNonTimeFilterString(q.f)
+	start, end := q.GetFilterTimeRange()
+	flushedBefore := now - mvs.flushDelay.Nanoseconds()
+	for _, st := range mvs.getStates() {
+		if qMV, qRaw := st.getQuery(q, ps, filterStr, start, end, flushedBefore); qMV != nil {
+			return qMV, qRaw
+		}
+	}
+	return nil, nil
+}
+
+// getQuery returns a query over pre-aggregated rows for st, which returns the same results as q.
+//
+// Pre-aggregated rows are visible for search only for the buckets ending before flushedBefore.
+// The remaining buckets are returned in qRaw, which must be executed over the original logs.
+//
+// nil qMV is returned if q cannot be executed over pre-aggregated rows for st.
+func (st *materializedViewState) getQuery(q *Query, ps *pipeStats, filterStr string, start, end, flushedBefore int64) (qMV, qRaw *Query) {
+	mv := st.mv
+	if filterStr != mv.filterStr {
+		return nil, nil
+	}
+
+	// Verify that the selected time range starts at the full bucket, which contains all the matching logs.
+	if start < st.readyTime.Load() || start%mv.step != 0 {
+		return nil, nil
+	}
+
+	byFields := make([]string, 0, len(ps.byFields))
+	var timeField *byStatsField
+	for _, bf := range ps.byFields {
+		if bf.name == "_time" {
+			if bf.bucketSize <= 0 {
+				return nil, nil
+			}
+			if int64(bf.bucketSize)%mv.step != 0 || int64(bf.bucketOffset)%mv.step != 0 {
+				return nil, nil
+			}
+			timeField = bf
+		} else if bf.bucketSizeStr != "" || !slices.Contains(mv.byFields, bf.name) {
+			return nil, nil
+		}
+		byFields = append(byFields, bf.String())
+	}
+
+	// Verify that the selected time range ends at the full bucket, which is visible for search.
+	// Otherwise the newest buckets must be executed over the original logs. This is possible only if q groups results
+	// by _time buckets and has no pipes after the stats, since then the results for distinct buckets do not overlap.
+	viewEnd := end
+	rawStart := int64(math.MaxInt64)
+	if flushedEnd := getMaterializedViewBucket(flushedBefore, mv.step); end >= flushedEnd {
+		if timeField == nil || len(q.pipes) > 1 {
+			return nil, nil
+		}
+		rawStart = truncateTimestamp(flushedEnd, int64(timeField.bucketSize), int64(timeField.bucketOffset), timeField.bucketSizeStr)
+		if rawStart <= start {
+			return nil, nil
+		}
+		viewEnd = rawStart - 1
+	} else if (end+1)%mv.step != 0 {
+		return nil, nil
+	}
+
+	funcs := make([]string, 0, len(ps.funcs))
+	for _, f := range ps.funcs {
+		if f.iff != nil {
+			return nil, nil
+		}
+		kind, field, ok := getMaterializedViewFunc(f.f)
+		if !ok {
+			return nil, nil
+		}
+		idx := slices.IndexFunc(mv.funcs, func(vf materializedViewFunc) bool {
+			return vf.kind == kind && vf.field == field
+		})
+		if idx < 0 {
+			return nil, nil
+		}
+		funcName := "sum"
+		switch kind {
+		case materializedViewFuncMin:
+			funcName = "min"
+		case materializedViewFuncMax:
+			funcName = "max"
+		}
+		funcs = append(funcs, fmt.Sprintf("%s(%s) as %s", funcName, quoteTokenIfNeeded(mv.funcs[idx].resultName), quoteTokenIfNeeded(f.resultName)))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "{%s=%s} | stats", materializedViewStreamField, strconv.Quote(mv.Name))
+	if len(byFields) > 0 {
+		fmt.Fprintf(&b, " by (%s)", strings.Join(byFields, ", "))
+	}
+	fmt.Fprintf(&b, " %s", strings.Join(funcs, ", "))
+	for _, p := range q.pipes[1:] {
+		fmt.Fprintf(&b, " | %s", p)
+	}
+
+	qStr := b.String()
+	qMV, err := ParseQueryAtTimestamp(qStr, q.GetTimestamp())
+	if err != nil {
+		logger.Panicf("BUG: cannot parse query [%s] for materialized view %s: %s", qStr, mv, err)
+	}
+	qMV.AddTimeFilter(start, viewEnd)
+	if viewEnd < end {
+		qRaw = q.CloneWithTimeFilter(q.GetTimestamp(), rawStart, end)
+	}
+	return qMV, qRaw
+}
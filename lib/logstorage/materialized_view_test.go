@@ -0,0 +1,403 @@
+package logstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestNewMaterializedViewSuccess(t *testing.T) {
+	f := func(query, filterExpected string, stepExpected int64, byFieldsExpected []string) {
+		t.Helper()
+
+		mv, err := NewMaterializedView("foo", query)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if mv.filterStr != filterExpected {
+			t.Fatalf("unexpected filter; got %q; want %q", mv.filterStr, filterExpected)
+		}
+		if mv.step != stepExpected {
+			t.Fatalf("unexpected step; got %d; want %d", mv.step, stepExpected)
+		}
+		if strings.Join(mv.byFields, ",") != strings.Join(byFieldsExpected, ",") {
+			t.Fatalf("unexpected by fields; got %q; want %q", mv.byFields, byFieldsExpected)
+		}
+	}
+
+	f(`* | stats by (_time:1m) count()`, "*", time.Minute.Nanoseconds(), nil)
+	f(`{app="nginx"} error | stats by (_time:1h, service, level) count() hits, sum(bytes), min(duration), max(duration), count(user)`,
+		`{app="nginx"} error`, time.Hour.Nanoseconds(), []string{"service", "level"})
+}
+
+func TestNewMaterializedViewFailure(t *testing.T) {
+	f := func(name, query string) {
+		t.Helper()
+
+		if _, err := NewMaterializedView(name, query); err == nil {
+			t.Fatalf("expecting non-nil error for the query [%s]", query)
+		}
+	}
+
+	// missing name
+	f("", `* | stats by (_time:1m) count()`)
+
+	// invalid query
+	f("foo", `* | stats by (`)
+
+	// missing stats pipe
+	f("foo", `*`)
+	f("foo", `* | fields foo`)
+
+	// multiple pipes
+	f("foo", `* | stats by (_time:1m) count() | sort by (_time)`)
+	f("foo", `* | fields foo | stats by (_time:1m) count()`)
+
+	// missing _time bucket
+	f("foo", `* | stats by (service) count()`)
+	f("foo", `* | stats by (_time, service) count()`)
+	f("foo", `* | stats by (_time:month) count()`)
+
+	// _time bucket with offset
+	f("foo", `* | stats by (_time:1h offset 10m) count()`)
+
+	// bucket for non-time field
+	f("foo", `* | stats by (_time:1m, bytes:10) count()`)
+
+	// time filters
+	f("foo", `_time:5m | stats by (_time:1m) count()`)
+	f("foo", `_time:day_range[08:00, 18:00) | stats by (_time:1m) count()`)
+
+	// subqueries
+	f("foo", `user:in(* | fields user) | stats by (_time:1m) count()`)
+
+	// unsupported stats funcs
+	f("foo", `* | stats by (_time:1m) avg(bytes)`)
+	f("foo", `* | stats by (_time:1m) sum(bytes, size)`)
+	f("foo", `* | stats by (_time:1m) count_uniq(user)`)
+	f("foo", `* | stats by (_time:1m) count() if (error)`)
+
+	// reserved fields
+	f("foo", `* | stats by (_time:1m, _stream) count()`)
+	f("foo", `* | stats by (_time:1m) count() _materialized_view`)
+	f("foo", `* | stats by (_time:1m, service) count() service`)
+}
+
+func TestMaterializedViewsRunQuery(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+	cfg := &StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+	}
+	s := MustOpenStorage(filepath.Join(path, "logs"), cfg)
+
+	views := []*MaterializedView{
+		mustNewMaterializedView(t, "by_level", `* | stats by (_time:1m, service, level) count() hits, sum(bytes) bytes, min(bytes) bytes_min, max(bytes) bytes_max, count(user) users`),
+		mustNewMaterializedView(t, "errors", `{app="web"} error | stats by (_time:1m) count() errors`),
+	}
+	mvs := MustOpenMaterializedViews(filepath.Join(path, "views"), cfg, views)
+	for _, st := range mvs.getStates() {
+		// Mark views as ready for all the logs ingested below.
+		st.readyTime.Store(0)
+	}
+	mvs.mustSaveState(0)
+
+	tenantIDs := []TenantID{
+		{AccountID: 0, ProjectID: 0},
+		{AccountID: 12, ProjectID: 34},
+	}
+	step := time.Minute.Nanoseconds()
+	start := getMaterializedViewBucket(time.Now().Add(-time.Hour).UnixNano(), time.Hour.Nanoseconds())
+	end := start + 10*step - 1
+
+	lr := GetLogRows([]string{"app"}, nil, nil, nil, "")
+	for i := 0; i < 1000; i++ {
+		tenantID := tenantIDs[i%len(tenantIDs)]
+		timestamp := start + int64(i)*(end-start)/1000
+		app := "web"
+		if i%3 == 0 {
+			app = "db"
+		}
+		msg := "ok"
+		if i%7 == 0 {
+			msg = "some error"
+		}
+		fields := []Field{
+			{Name: "app", Value: app},
+			{Name: "_msg", Value: msg},
+			{Name: "service", Value: fmt.Sprintf("service_%d", i%4)},
+			{Name: "level", Value: []string{"info", "warn", ""}[i%3]},
+			{Name: "bytes", Value: fmt.Sprintf("%d", i*17%1000)},
+		}
+		if i%5 == 0 {
+			fields = append(fields, Field{Name: "user", Value: fmt.Sprintf("user_%d", i)})
+		}
+		lr.MustAdd(tenantID, timestamp, fields, -1)
+	}
+	s.MustAddRows(lr)
+	mvs.AddRows(lr)
+	PutLogRows(lr)
+
+	s.DebugFlush()
+	mvs.DebugFlush()
+
+	fSuccess := func(qStr string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query [%s]: %s", qStr, err)
+		}
+		q.AddTimeFilter(start, end)
+
+		for _, tenantID := range tenantIDs {
+			tids := []TenantID{tenantID}
+			resultExpected := runMaterializedViewTestQuery(t, tids, q, s.RunQuery)
+
+			ok := false
+			result := runMaterializedViewTestQuery(t, tids, q, func(qctx *QueryContext, writeBlock WriteDataBlockFunc) error {
+				var err error
+				ok, err = mvs.RunQuery(qctx, s, writeBlock)
+				return err
+			})
+			if !ok {
+				t.Fatalf("the query [%s] must be executed over materialized views", q)
+			}
+			if len(resultExpected) == 0 {
+				t.Fatalf("unexpected empty result for the query [%s]", q)
+			}
+			if result != resultExpected {
+				t.Fatalf("unexpected result for the query [%s]\ngot\n%s\nwant\n%s", q, result, resultExpected)
+			}
+		}
+	}
+
+	fSuccess(`* | stats by (_time:1m, service, level) count() hits`)
+	fSuccess(`* | stats by (_time:5m, level) count(), sum(bytes), min(bytes), max(bytes), count(user) x`)
+	fSuccess(`* | stats by (service) sum(bytes) total | sort by (service)`)
+	fSuccess(`* | stats count() rows`)
+	fSuccess(`{app="web"} error | stats by (_time:2m) count()`)
+
+	fFailure := func(qStr string, start, end int64) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query [%s]: %s", qStr, err)
+		}
+		q.AddTimeFilter(start, end)
+		if qMV, _ := mvs.getQuery(q, time.Now().UnixNano()); qMV != nil {
+			t.Fatalf("the query [%s] mustn't be executed over materialized views; got [%s]", q, qMV)
+		}
+	}
+
+	// unaligned time range
+	fFailure(`* | stats by (_time:1m) count()`, start+1, end)
+	fFailure(`* | stats by (_time:1m) count()`, start, end-1)
+
+	// non-matching filter
+	fFailure(`{app="db"} | stats by (_time:1m) count()`, start, end)
+	fFailure(`error | stats by (_time:1m) count()`, start, end)
+
+	// step isn't a multiple of the view step
+	fFailure(`* | stats by (_time:30s) count()`, start, end)
+
+	// unknown by field
+	fFailure(`* | stats by (_time:1m, user) count()`, start, end)
+
+	// unknown func
+	fFailure(`* | stats by (_time:1m) sum(size)`, start, end)
+	fFailure(`* | stats by (_time:1m) count_uniq(service)`, start, end)
+
+	// time range before the view is ready
+	st := mvs.getStates()[0]
+	st.readyTime.Store(start + step)
+	fFailure(`* | stats by (_time:1m) count()`, start, end)
+	st.readyTime.Store(0)
+
+	// Verify that the ready time is reset after deleting logs.
+	mvs.ResetReadyTime(end)
+	for _, st := range mvs.getStates() {
+		if st.readyTime.Load() <= time.Now().UnixNano() {
+			t.Fatalf("the ready time for view %s must be in the future after deleting logs; got %d", st.mv, st.readyTime.Load())
+		}
+	}
+	fFailure(`* | stats by (_time:1m) count()`, start, end)
+	for _, st := range mvs.getStates() {
+		st.readyTime.Store(0)
+	}
+	mvs.mustSaveState(0)
+
+	// Add logs for the last bucket only to the original logs, since the pre-aggregated rows for the newest buckets
+	// may be not visible for search yet. Such buckets must be executed over the original logs.
+	lr = GetLogRows([]string{"app"}, nil, nil, nil, "")
+	for i := 0; i < 100; i++ {
+		fields := []Field{
+			{Name: "app", Value: "web"},
+			{Name: "_msg", Value: "new error"},
+			{Name: "service", Value: fmt.Sprintf("service_%d", i%4)},
+			{Name: "level", Value: "info"},
+			{Name: "bytes", Value: fmt.Sprintf("%d", i)},
+		}
+		lr.MustAdd(tenantIDs[0], end-int64(i)*step/100, fields, -1)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.DebugFlush()
+
+	fSuccessNewest := func(qStr string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query [%s]: %s", qStr, err)
+		}
+		q.AddTimeFilter(start, end)
+
+		tids := []TenantID{tenantIDs[0]}
+		resultExpected := runMaterializedViewTestQuery(t, tids, q, s.RunQuery)
+
+		ok := false
+		result := runMaterializedViewTestQuery(t, tids, q, func(qctx *QueryContext, writeBlock WriteDataBlockFunc) error {
+			var err error
+			ok, err = mvs.runQuery(qctx, s, writeBlock, end+1)
+			return err
+		})
+		if !ok {
+			t.Fatalf("the query [%s] must be executed over materialized views", q)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result for the query [%s]\ngot\n%s\nwant\n%s", q, result, resultExpected)
+		}
+	}
+
+	fSuccessNewest(`* | stats by (_time:1m, service, level) count() hits`)
+	fSuccessNewest(`* | stats by (_time:5m, level) count(), sum(bytes), min(bytes), max(bytes)`)
+	fSuccessNewest(`{app="web"} error | stats by (_time:2m) count()`)
+
+	// The newest buckets cannot be executed over the original logs for queries without _time buckets or with pipes after the stats.
+	fFailureNewest := func(qStr string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query [%s]: %s", qStr, err)
+		}
+		q.AddTimeFilter(start, end)
+		if qMV, _ := mvs.getQuery(q, end+1); qMV != nil {
+			t.Fatalf("the query [%s] mustn't be executed over materialized views; got [%s]", q, qMV)
+		}
+	}
+
+	fFailureNewest(`* | stats count() rows`)
+	fFailureNewest(`* | stats by (service) sum(bytes) total | sort by (service)`)
+	fFailureNewest(`* | stats by (_time:1m) count() hits | sort by (hits)`)
+
+	// The whole time range consists of the newest buckets.
+	fFailureNewest(`* | stats by (_time:1h) count()`)
+
+	mvs.MustClose()
+	s.MustClose()
+
+	// Verify that the ready time is preserved after re-opening.
+	mvs = MustOpenMaterializedViews(filepath.Join(path, "views"), cfg, views)
+	for _, st := range mvs.getStates() {
+		if st.readyTime.Load() != 0 {
+			t.Fatalf("unexpected ready time for view %s; got %d; want 0", st.mv, st.readyTime.Load())
+		}
+	}
+
+	// Verify that the ready time is reset after the view update.
+	viewsNew := []*MaterializedView{
+		views[0],
+		mustNewMaterializedView(t, "errors", `{app="web"} | stats by (_time:1m) count() errors`),
+	}
+	mvs.UpdateViews(viewsNew)
+	states := mvs.getStates()
+	if states[0].readyTime.Load() != 0 {
+		t.Fatalf("unexpected ready time for unchanged view %s; got %d; want 0", states[0].mv, states[0].readyTime.Load())
+	}
+	if states[1].readyTime.Load() <= time.Now().UnixNano() {
+		t.Fatalf("the ready time for updated view %s must be in the future; got %d", states[1].mv, states[1].readyTime.Load())
+	}
+
+	// Simulate unclean shutdown by restoring the state persisted while the views were open.
+	statePath := filepath.Join(path, "views", materializedViewsStateFilename)
+	stateData, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("cannot read views state: %s", err)
+	}
+	mvs.MustClose()
+	fs.MustWriteSync(statePath, stateData)
+
+	// Verify that the ready time is reset after unclean shutdown, since the lost in-memory groups could contain the ingested logs.
+	mvs = MustOpenMaterializedViews(filepath.Join(path, "views"), cfg, viewsNew)
+	for _, st := range mvs.getStates() {
+		if st.readyTime.Load() <= time.Now().UnixNano() {
+			t.Fatalf("the ready time for view %s must be in the future after unclean shutdown; got %d", st.mv, st.readyTime.Load())
+		}
+		st.readyTime.Store(0)
+	}
+	mvs.mustSaveState(0)
+	mvs.MustClose()
+
+	// Verify that the ready time is reset after resetting the views state.
+	MustResetMaterializedViewsState(filepath.Join(path, "views"))
+	mvs = MustOpenMaterializedViews(filepath.Join(path, "views"), cfg, viewsNew)
+	for _, st := range mvs.getStates() {
+		if st.readyTime.Load() <= time.Now().UnixNano() {
+			t.Fatalf("the ready time for view %s must be in the future after the state reset; got %d", st.mv, st.readyTime.Load())
+		}
+	}
+	mvs.MustClose()
+
+	fs.MustRemoveDir(path)
+}
+
+func mustNewMaterializedView(t *testing.T, name, query string) *MaterializedView {
+	t.Helper()
+
+	mv, err := NewMaterializedView(name, query)
+	if err != nil {
+		t.Fatalf("cannot create view %q: %s", name, err)
+	}
+	return mv
+}
+
+func runMaterializedViewTestQuery(t *testing.T, tenantIDs []TenantID, q *Query, runQuery func(qctx *QueryContext, writeBlock WriteDataBlockFunc) error) string {
+	t.Helper()
+
+	var rows []string
+	var rowsLock sync.Mutex
+	writeBlock := func(_ uint, db *DataBlock) {
+		rowsLock.Lock()
+		defer rowsLock.Unlock()
+
+		for i := 0; i < db.RowsCount(); i++ {
+			var fields []Field
+			for _, c := range db.Columns {
+				fields = append(fields, Field{
+					Name:  c.Name,
+					Value: c.Values[i],
+				})
+			}
+			rows = append(rows, string(MarshalFieldsToJSON(nil, fields)))
+		}
+	}
+
+	var qs QueryStats
+	qctx := NewQueryContext(t.Context(), &qs, tenantIDs, q, false, nil)
+	if err := runQuery(qctx, writeBlock); err != nil {
+		t.Fatalf("unexpected error when running the query [%s]: %s", q, err)
+	}
+	sort.Strings(rows)
+	return strings.Join(rows, "\n")
+}
@@ -67,6 +67,8 @@ func TestSplitQueryToRemoteAndLocal(t *testing.T) {
 	f(`foo | pack_logfmt`, `foo | pack_logfmt`, ``)
 	f(`foo | query_stats`, `foo | query_stats`, `query_stats_local`)
 	f(`foo | rename x as y`, `foo | rename x as y`, ``)
+	f(`foo | patterns limit 10`, `foo | patterns_remote | fields hits, pattern, "sample"`, `patterns_local limit 10`)
+	f(`foo | patterns from x | fields pattern, hits`, `foo | patterns_remote from x | fields hits, pattern, "sample"`, `patterns_local | fields pattern, hits`)
 	f(`foo | replace ("x", "y")`, `foo | replace (x, y)`, ``)
 	f(`foo | replace_regexp ("x", "y")`, `foo | replace_regexp (x, y)`, ``)
 	f(`foo | running_stats by (x) sum(y) as z`, `foo | delete z`, `running_stats by (x) sum(y) as z`)
@@ -46,6 +46,11 @@ type lexer struct {
 	// It is used for proper initializing of _time filters with relative time ranges.
 	currentTimestamp int64
 
+	// isCurrentTimestampUsed is set to true if currentTimestamp has been used during parsing.
+	//
+	// This means the parsed query depends on the timestamp it is parsed at. For example, it contains _time:5m filter.
+	isCurrentTimestampUsed bool
+
 	// opts is a stack of options for nested parsed queries
 	optss []*queryOptions
 }
@@ -99,6 +104,12 @@ func newLexer(s string, timestamp int64) *lexer {
 	return lex
 }
 
+// getCurrentTimestamp returns the timestamp the query is parsed at and marks the query as dependent on this timestamp.
+func (lex *lexer) getCurrentTimestamp() int64 {
+	lex.isCurrentTimestampUsed = true
+	return lex.currentTimestamp
+}
+
 func (lex *lexer) isEnd() bool {
 	return len(lex.s) == 0 && len(lex.token) == 0 && len(lex.rawToken) == 0
 }
@@ -373,6 +384,11 @@ type Query struct {
 
 	// timestamp is the timestamp context used for parsing the query.
 	timestamp int64
+
+	// isTimestampDependent is set to true if the query depends on the timestamp context.
+	//
+	// For example, the query contains relative time filters such as _time:5m or _time:[now-1h, now].
+	isTimestampDependent bool
 }
 
 type queryOptions struct {
@@ -556,6 +572,17 @@ func (q *Query) AddFacetsPipe(limit, maxValuesPerField, maxValueLen int, keepCon
 	q.mustAppendPipe(s)
 }
 
+// AddFieldNamesPipe adds '| field_names' to the end of q.
+func (q *Query) AddFieldNamesPipe() {
+	q.mustAppendPipe("field_names")
+}
+
+// AddFieldValuesPipe adds '| field_values <fieldName> limit <limit>' to the end of q.
+func (q *Query) AddFieldValuesPipe(fieldName string, limit uint64) {
+	s := fmt.Sprintf("field_values %s limit %d", quoteTokenIfNeeded(fieldName), limit)
+	q.mustAppendPipe(s)
+}
+
 // AddCountByTimePipe adds '| stats by (_time:step offset off, field1, ..., fieldN) count() hits' to the end of q.
 func (q *Query) AddCountByTimePipe(step, off int64, fields []string) {
 	// Drop pipes from q, which modify or delete _time field, since they make impossible to calculate stats grouped by _time.
@@ -852,6 +879,9 @@ func (q *Query) AddExtraFilters(extraFilters *Filter) {
 	q.visitSubqueries(func(q *Query) {
 		q.addExtraFiltersNoSubqueries(filters)
 	})
+	if extraFilters.isTimestampDependent {
+		q.isTimestampDependent = true
+	}
 }
 
 func (q *Query) addExtraFiltersNoSubqueries(filters []filter) {
@@ -1034,6 +1064,43 @@ func mergeFiltersStreamInternal(fss []*filterStream) []*filterStream {
 	}
 }
 
+// CanCacheStatsRangeResults returns true if /select/logsql/stats_query_range results for q can be cached per every `_time` bucket.
+//
+// This means that the results for the time range [start, end] can be obtained by merging the results for non-overlapping
+// sub-ranges of [start, end] aligned to the bucket step.
+func (q *Query) CanCacheStatsRangeResults() bool {
+	if q.isTimestampDependent {
+		// Relative time filters such as _time:5m select different logs depending on the query timestamp.
+		return false
+	}
+	if q.opts.ignoreGlobalTimeFilter != nil && *q.opts.ignoreGlobalTimeFilter {
+		// The query results do not depend on the selected time range.
+		return false
+	}
+
+	subqueriesCount := 0
+	q.visitSubqueries(func(_ *Query) {
+		subqueriesCount++
+	})
+	if subqueriesCount > 1 {
+		// Subqueries are executed on the whole selected time range, so their results change when the time range is split.
+		return false
+	}
+
+	for _, p := range q.pipes {
+		if _, ok := p.(*pipeStats); ok {
+			// `stats` pipes are grouped by `_time` buckets.
+			continue
+		}
+		if !p.canReturnLastNResults() {
+			// The pipe may mix rows from distinct `_time` buckets.
+			return false
+		}
+	}
+
+	return true
+}
+
 // GetStatsLabels returns stats labels from q for /select/logsql/stats_query endpoint
 //
 // The remaining fields are considered metrics.
@@ -1704,6 +1771,7 @@ func ParseQueryAtTimestamp(s string, timestamp int64) (*Query, error) {
 	if !lex.isEnd() {
 		return nil, fmt.Errorf("unexpected unparsed tail after [%s]; context: [%s]; tail: [%s]", q, lex.context(), lex.rawToken+lex.s)
 	}
+	q.isTimestampDependent = lex.isCurrentTimestampUsed
 	q.optimize()
 	q.initStatsRateFuncsFromTimeFilter()
 
@@ -1809,6 +1877,9 @@ func parseQuery(lex *lexer) (*Query, error) {
 // See https://docs.victoriametrics.com/victorialogs/logsql/#filters
 type Filter struct {
 	f filter
+
+	// isTimestampDependent is set to true if f contains relative time filters such as _time:5m.
+	isTimestampDependent bool
 }
 
 // String returns string representation of f.
@@ -1836,7 +1907,8 @@ func ParseFilter(s string) (*Filter, error) {
 		return nil, fmt.Errorf("unexpected pipes after the filter [%s]; pipes: %s", q.f, q.pipes)
 	}
 	f := &Filter{
-		f: q.f,
+		f:                    q.f,
+		isTimestampDependent: q.isTimestampDependent,
 	}
 	return f, nil
 }
@@ -3293,7 +3365,7 @@ func parseFilterTimeRange(lex *lexer) (*filterTime, error) {
 	if lex.isKeyword("offset") {
 		ft := &filterTime{
 			minTimestamp: math.MinInt64,
-			maxTimestamp: lex.currentTimestamp,
+			maxTimestamp: lex.getCurrentTimestamp(),
 		}
 		offset, offsetStr, err := parseTimeOffset(lex)
 		if err != nil {
@@ -3447,7 +3519,7 @@ func parseFilterTimeGt(lex *lexer) (*filterTime, error) {
 	}
 	ft := &filterTime{
 		minTimestamp: math.MinInt64,
-		maxTimestamp: SubInt64NoOverflow(lex.currentTimestamp, d),
+		maxTimestamp: SubInt64NoOverflow(lex.getCurrentTimestamp(), d),
 
 		stringRepr: prefix + s,
 	}
@@ -3496,7 +3568,7 @@ func parseFilterTimeLt(lex *lexer) (*filterTime, error) {
 		d--
 	}
 	ft := &filterTime{
-		minTimestamp: SubInt64NoOverflow(lex.currentTimestamp, d),
+		minTimestamp: SubInt64NoOverflow(lex.getCurrentTimestamp(), d),
 		maxTimestamp: lex.currentTimestamp,
 
 		stringRepr: prefix + s,
@@ -3538,7 +3610,7 @@ func parseFilterTimeEq(lex *lexer) (*filterTime, error) {
 		d = -d
 	}
 	ft := &filterTime{
-		minTimestamp: SubInt64NoOverflow(lex.currentTimestamp, d),
+		minTimestamp: SubInt64NoOverflow(lex.getCurrentTimestamp(), d),
 		maxTimestamp: lex.currentTimestamp,
 
 		stringRepr: prefix + s,
@@ -3841,6 +3913,10 @@ func parseTime(lex *lexer) (int64, string, error) {
 	if err != nil {
 		return 0, "", err
 	}
+	if nsecsPrev, err := timeutil.ParseTimeAt(s, lex.currentTimestamp-1); err != nil || nsecsPrev != nsecs {
+		// s contains relative time such as now-1h
+		lex.isCurrentTimestampUsed = true
+	}
 	return nsecs, s, nil
 }
 
@@ -3719,6 +3719,62 @@ func TestQueryCanLiveTail(t *testing.T) {
 	f("* | sample 10", true)
 }
 
+func TestQueryCanCacheStatsRangeResults(t *testing.T) {
+	f := func(qStr string, resultExpected bool) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		result := q.CanCacheStatsRangeResults()
+		if result != resultExpected {
+			t.Fatalf("unexpected result for CanCacheStatsRangeResults(%q); got %v; want %v", qStr, result, resultExpected)
+		}
+	}
+
+	f("* | count()", true)
+	f("error | stats by (host) count() hits, count_uniq(user_id) users", true)
+	f("{app=nginx} | extract 'user=<user> ' | stats by (user) count() | filter hits:>10 | math hits*2 as x", true)
+	f("_time:[2024-01-01Z, 2024-02-01Z) | count()", true)
+	f("_time:day_range[08:00, 18:00) | count()", true)
+	f("options(time_offset=1h) error | count()", true)
+	f("* | stats count() x | stats count() y", true)
+
+	// relative time filters
+	f("_time:5m | count()", false)
+	f("_time:[now-1h, now] | count()", false)
+	f("_time:>1h | count()", false)
+	f("_time:offset 1h | count()", false)
+	f("* | count() if (_time:1h) hits", false)
+	f("* | count() | filter _time:1h", false)
+
+	// subqueries
+	f("user_id:in(error | fields user_id) | count()", false)
+
+	// ignore_global_time_filter
+	f("options(ignore_global_time_filter=true) * | count()", false)
+
+	// pipes, which may mix distinct time buckets
+	f("* | count() hits | sort by (hits) | limit 5", false)
+	f("* | count() hits | limit 5", false)
+	f("* | count() hits | running_stats sum(hits) total", false)
+
+	// relative time filters in extra filters
+	q, err := ParseQuery("* | count()")
+	if err != nil {
+		t.Fatalf("cannot parse query: %s", err)
+	}
+	extraFilters, err := ParseFilter("_time:1h")
+	if err != nil {
+		t.Fatalf("cannot parse extra filters: %s", err)
+	}
+	q.AddExtraFilters(extraFilters)
+	if q.CanCacheStatsRangeResults() {
+		t.Fatalf("unexpected CanCacheStatsRangeResults() result for query with relative time filter in extra filters")
+	}
+}
+
 func TestQueryDropAllPipes(t *testing.T) {
 	f := func(qStr, resultExpected string) {
 		t.Helper()
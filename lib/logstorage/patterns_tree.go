@@ -0,0 +1,293 @@
+package logstorage
+
+import (
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// patternsWildcard is the placeholder for variable tokens in log patterns.
+const patternsWildcard = "<*>"
+
+const (
+	// patternsTreeDepth is the number of leading tokens used for routing messages to clusters in patternsTree.
+	patternsTreeDepth = 1
+
+	// patternsTreeMaxChildren is the maximum number of children per patternsTree node.
+	//
+	// Messages with leading tokens, which do not fit the limit, are routed to the wildcard child.
+	patternsTreeMaxChildren = 100
+
+	// patternsSimilarityThreshold is the minimum share of matching tokens for adding a message to the existing cluster.
+	patternsSimilarityThreshold = 0.4
+)
+
+// patternsTree clusters log messages into patterns with Drain algorithm.
+//
+// See https://jiemingzhu.github.io/pub/pjhe_icws2017.pdf
+type patternsTree struct {
+	// roots contains root nodes per the number of tokens in log messages.
+	roots map[int]*patternsTreeNode
+
+	// clusters contains all the clusters in the tree.
+	clusters []*patternsCluster
+
+	// tokensBuf is a temporary buffer for message tokens.
+	tokensBuf []string
+}
+
+type patternsTreeNode struct {
+	// children contains child nodes by the token value.
+	children map[string]*patternsTreeNode
+
+	// clusters contains clusters for leaf nodes.
+	clusters []*patternsCluster
+}
+
+// patternsCluster is a single log pattern.
+type patternsCluster struct {
+	// tokens contains pattern tokens. Variable tokens are replaced with patternsWildcard.
+	tokens []string
+
+	// sample is the first log message seen for the cluster.
+	sample string
+
+	// hits is the number of log messages matching the cluster.
+	hits uint64
+}
+
+func (pc *patternsCluster) pattern() string {
+	return strings.Join(pc.tokens, " ")
+}
+
+// addMessage adds the given log message with the given hits to pt.
+//
+// It returns the increase of pt state size in bytes.
+func (pt *patternsTree) addMessage(msg string, hits uint64) int {
+	pt.tokensBuf = appendPatternTokens(pt.tokensBuf[:0], msg)
+	tokens := pt.tokensBuf
+
+	pc, stateSize := pt.addTokens(tokens, hits)
+	if pc == nil {
+		// Create new cluster with tokens pointing to the cloned message.
+		sample := strings.Clone(msg)
+		tokens = appendPatternTokens(nil, sample)
+		stateSize += pt.newCluster(tokens, sample, hits)
+	}
+	return stateSize
+}
+
+// addPattern adds the given pattern with the given sample and hits to pt.
+//
+// The pattern must be obtained via patternsCluster.pattern().
+// It returns the increase of pt state size in bytes.
+func (pt *patternsTree) addPattern(pattern, sample string, hits uint64) int {
+	pt.tokensBuf = appendPatternTokens(pt.tokensBuf[:0], pattern)
+	tokens := pt.tokensBuf
+
+	pc, stateSize := pt.addTokens(tokens, hits)
+	if pc == nil {
+		tokens = appendPatternTokens(nil, strings.Clone(pattern))
+		stateSize += len(pattern) + pt.newCluster(tokens, strings.Clone(sample), hits)
+		return stateSize
+	}
+
+	// Keep the smallest sample, so the returned sample doesn't depend on the order of merging states from multiple sources.
+	if sample < pc.sample {
+		stateSize += len(sample) - len(pc.sample)
+		pc.sample = strings.Clone(sample)
+	}
+	return stateSize
+}
+
+// mergeState merges src into pt.
+//
+// It returns the increase of pt state size in bytes.
+func (pt *patternsTree) mergeState(src *patternsTree, stopCh <-chan struct{}) int {
+	stateSize := 0
+	for _, pc := range src.clusters {
+		if needStop(stopCh) {
+			return stateSize
+		}
+		stateSize += pt.addPattern(pc.pattern(), pc.sample, pc.hits)
+	}
+	return stateSize
+}
+
+// addTokens adds hits for the cluster matching the given tokens and returns this cluster.
+//
+// nil cluster is returned if there are no matching clusters in pt. The leaf node for the given tokens is created in this case.
+// The increase of pt state size in bytes is returned as the second value.
+func (pt *patternsTree) addTokens(tokens []string, hits uint64) (*patternsCluster, int) {
+	leaf, stateSize := pt.getLeaf(tokens)
+
+	pc := getBestPatternsCluster(leaf.clusters, tokens)
+	if pc == nil {
+		return nil, stateSize
+	}
+
+	// Replace distinct tokens with wildcards.
+	for i, token := range pc.tokens {
+		if token != tokens[i] {
+			pc.tokens[i] = patternsWildcard
+		}
+	}
+	pc.hits += hits
+	return pc, stateSize
+}
+
+func (pt *patternsTree) newCluster(tokens []string, sample string, hits uint64) int {
+	pc := &patternsCluster{
+		tokens: tokens,
+		sample: sample,
+		hits:   hits,
+	}
+	pt.clusters = append(pt.clusters, pc)
+
+	leaf, _ := pt.getLeaf(tokens)
+	leaf.clusters = append(leaf.clusters, pc)
+
+	return len(sample) + len(tokens)*int(unsafe.Sizeof(tokens[0])) + int(unsafe.Sizeof(*pc)+2*unsafe.Sizeof(pc))
+}
+
+// getLeaf returns the leaf node for the given tokens.
+//
+// The leaf node is created if it is missing. The increase of pt state size in bytes is returned as the second value.
+func (pt *patternsTree) getLeaf(tokens []string) (*patternsTreeNode, int) {
+	stateSize := 0
+
+	if pt.roots == nil {
+		pt.roots = make(map[int]*patternsTreeNode)
+	}
+	n := pt.roots[len(tokens)]
+	if n == nil {
+		n = &patternsTreeNode{}
+		pt.roots[len(tokens)] = n
+		stateSize += int(unsafe.Sizeof(*n))
+	}
+
+	depth := min(len(tokens), patternsTreeDepth)
+	for _, token := range tokens[:depth] {
+		if hasDigits(token) {
+			token = patternsWildcard
+		}
+		child := n.children[token]
+		if child == nil {
+			if len(n.children) >= patternsTreeMaxChildren {
+				token = patternsWildcard
+				child = n.children[token]
+			}
+			if child == nil {
+				if n.children == nil {
+					n.children = make(map[string]*patternsTreeNode)
+				}
+				token = strings.Clone(token)
+				child = &patternsTreeNode{}
+				n.children[token] = child
+				stateSize += len(token) + int(unsafe.Sizeof(token)+unsafe.Sizeof(*child))
+			}
+		}
+		n = child
+	}
+	return n, stateSize
+}
+
+// getBestPatternsCluster returns the most similar cluster to the given tokens from pcs.
+//
+// nil is returned if pcs do not contain clusters with the similarity exceeding patternsSimilarityThreshold.
+func getBestPatternsCluster(pcs []*patternsCluster, tokens []string) *patternsCluster {
+	var best *patternsCluster
+	bestSimilarity := -1.0
+	bestWildcards := -1
+	for _, pc := range pcs {
+		similarity, wildcards := getPatternsSimilarity(pc.tokens, tokens)
+		if similarity > bestSimilarity || (similarity == bestSimilarity && wildcards > bestWildcards) {
+			best = pc
+			bestSimilarity = similarity
+			bestWildcards = wildcards
+		}
+	}
+	if best == nil || bestSimilarity < patternsSimilarityThreshold {
+		return nil
+	}
+	return best
+}
+
+// getPatternsSimilarity returns the share of tokens equal to patternTokens and the number of wildcards in patternTokens.
+//
+// It is expected that len(patternTokens) == len(tokens).
+func getPatternsSimilarity(patternTokens, tokens []string) (float64, int) {
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+
+	equal := 0
+	wildcards := 0
+	for i, token := range patternTokens {
+		if token == patternsWildcard {
+			wildcards++
+			continue
+		}
+		if token == tokens[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens)), wildcards
+}
+
+// getTopClusters returns clusters from pt sorted by hits in descending order.
+//
+// If limit > 0, then up to limit clusters are returned.
+func (pt *patternsTree) getTopClusters(limit uint64) []*patternsCluster {
+	pcs := pt.clusters
+	sort.Slice(pcs, func(i, j int) bool {
+		a, b := pcs[i], pcs[j]
+		if a.hits != b.hits {
+			return a.hits > b.hits
+		}
+		return a.pattern() < b.pattern()
+	})
+	if limit > 0 && uint64(len(pcs)) > limit {
+		pcs = pcs[:limit]
+	}
+	return pcs
+}
+
+// appendPatternTokens appends whitespace-delimited tokens from s to dst and returns the result.
+func appendPatternTokens(dst []string, s string) []string {
+	start := -1
+	for i := 0; i < len(s); i++ {
+		if isPatternsWhitespace(s[i]) {
+			if start >= 0 {
+				dst = append(dst, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		dst = append(dst, s[start:])
+	}
+	return dst
+}
+
+func isPatternsWhitespace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	default:
+		return false
+	}
+}
+
+func hasDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package logstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPatternsTree(t *testing.T) {
+	f := func(msgs []string, resultExpected []string) {
+		t.Helper()
+
+		var pt patternsTree
+		for _, msg := range msgs {
+			if n := pt.addMessage(msg, 1); n < 0 {
+				t.Fatalf("unexpected negative state size increase: %d", n)
+			}
+		}
+		var result []string
+		for _, pc := range pt.getTopClusters(0) {
+			result = append(result, pc.pattern()+" | "+pc.sample+" | "+string(marshalUint64String(nil, pc.hits)))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	// empty messages
+	f([]string{"", " "}, []string{
+		" |  | 2",
+	})
+
+	// distinct number of tokens
+	f([]string{"foo", "foo bar", "foo bar baz"}, []string{
+		"foo | foo | 1",
+		"foo bar | foo bar | 1",
+		"foo bar baz | foo bar baz | 1",
+	})
+
+	// variable tokens
+	f([]string{
+		"connected to 10.0.0.1 in 5ms",
+		"connected to 10.0.0.2 in 7ms",
+		"connected to 10.0.0.2  in 7ms",
+		"user foo logged in",
+		"user bar logged in",
+		"user foo logged out",
+	}, []string{
+		"connected to <*> in <*> | connected to 10.0.0.1 in 5ms | 3",
+		"user <*> logged <*> | user foo logged in | 3",
+	})
+
+	// dissimilar messages with the same leading tokens
+	f([]string{
+		"cannot open file a.txt: permission denied",
+		"cannot open file b.txt: not found",
+		"cannot open socket: too many open files",
+	}, []string{
+		"cannot open file <*> <*> <*> | cannot open file a.txt: permission denied | 2",
+		"cannot open socket: too many open files | cannot open socket: too many open files | 1",
+	})
+}
+
+func TestPatternsTreeMergeState(t *testing.T) {
+	var pt1, pt2 patternsTree
+	pt1.addMessage("request GET /foo status 200", 2)
+	pt1.addMessage("job 1 finished", 1)
+	pt2.addMessage("request GET /bar status 404", 3)
+	pt2.addMessage("job 2 finished", 1)
+	pt2.addMessage("shutting down", 1)
+
+	pt1.mergeState(&pt2, nil)
+
+	var result []string
+	for _, pc := range pt1.getTopClusters(0) {
+		result = append(result, pc.pattern()+" | "+pc.sample+" | "+string(marshalUint64String(nil, pc.hits)))
+	}
+	resultExpected := []string{
+		"request GET <*> status <*> | request GET /bar status 404 | 5",
+		"job <*> finished | job 1 finished | 2",
+		"shutting down | shutting down | 1",
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+	}
+}
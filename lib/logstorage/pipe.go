@@ -197,6 +197,8 @@ func initPipeParsers() {
 		"first":             parsePipeFirst,
 		"format":            parsePipeFormat,
 		"generate_sequence": parsePipeGenerateSequence,
+		"geoip":             parsePipeGeoIP,
+		"grok":              parsePipeGrok,
 		"hash":              parsePipeHash,
 		"join":              parsePipeJoin,
 		"json_array_len":    parsePipeJSONArrayLen,
@@ -205,12 +207,15 @@ func initPipeParsers() {
 		"last":              parsePipeLast,
 		"len":               parsePipeLen,
 		"limit":             parsePipeLimit,
+		"lookup":            parsePipeLookup,
 		"math":              parsePipeMath,
 		"mv":                parsePipeRename,
 		"offset":            parsePipeOffset,
 		"order":             parsePipeSort,
 		"pack_json":         parsePipePackJSON,
 		"pack_logfmt":       parsePipePackLogfmt,
+		"patterns":          parsePipePatterns,
+		"patterns_remote":   parsePipePatterns,
 		"query_stats":       parsePipeQueryStats,
 		"rename":            parsePipeRename,
 		"replace":           parsePipeReplace,
@@ -228,6 +233,7 @@ func initPipeParsers() {
 		"time_add":          parsePipeTimeAdd,
 		"top":               parsePipeTop,
 		"total_stats":       parsePipeTotalStats,
+		"transaction":       parsePipeTransaction,
 		"union":             parsePipeUnion,
 		"uniq":              parsePipeUniq,
 		"unpack_json":       parsePipeUnpackJSON,
@@ -25,6 +25,14 @@ type pipeExtractRegexp struct {
 	// reFields contains named capturing fields from the re.
 	reFields []string
 
+	// reFieldAliases contains the index of the first capturing group with the same field name per every capturing group in re.
+	// It contains -1 for groups without duplicates. It is set only by grok pipe, since grok patterns may capture the same field
+	// multiple times such as `%{IP:host}|%{HOSTNAME:host}`. Duplicate groups have empty names at reFields.
+	reFieldAliases []int
+
+	// reFieldTypes contains optional type conversion hints per every capturing group in re. It is set only by grok pipe.
+	reFieldTypes []string
+
 	keepOriginalFields bool
 	skipEmptyResults   bool
 
@@ -130,12 +138,12 @@ type pipeExtractRegexpProcessorShard struct {
 	fields []string
 }
 
-func (shard *pipeExtractRegexpProcessorShard) apply(re *regexp.Regexp, v string) {
+func (shard *pipeExtractRegexpProcessorShard) apply(pe *pipeExtractRegexp, v string) {
 	shard.fields = slicesutil.SetLength(shard.fields, len(shard.rcs))
 	fields := shard.fields
 	clear(fields)
 
-	locs := re.FindStringSubmatchIndex(v)
+	locs := pe.re.FindStringSubmatchIndex(v)
 	if locs == nil {
 		return
 	}
@@ -149,6 +157,17 @@ func (shard *pipeExtractRegexpProcessorShard) apply(re *regexp.Regexp, v string)
 		end := locs[2*i+1]
 		fields[i] = v[start:end]
 	}
+
+	for i, firstIdx := range pe.reFieldAliases {
+		if firstIdx >= 0 && fields[firstIdx] == "" {
+			fields[firstIdx] = fields[i]
+		}
+	}
+	for i, typ := range pe.reFieldTypes {
+		if typ != "" && fields[i] != "" {
+			fields[i] = convertGrokValue(typ, fields[i])
+		}
+	}
 }
 
 func (pep *pipeExtractRegexpProcessor) writeBlock(workerID uint, br *blockResult) {
@@ -200,7 +219,7 @@ func (pep *pipeExtractRegexpProcessor) writeBlock(workerID uint, br *blockResult
 				vPrev = v
 				needUpdates = false
 
-				shard.apply(pe.re, v)
+				shard.apply(pe, v)
 
 				for i, v := range shard.fields {
 					if reFields[i] == "" {
@@ -5,7 +5,6 @@ import (
 	"math"
 	"sort"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
@@ -107,13 +106,11 @@ func (pf *pipeFacets) newPipeProcessor(concurrency int, stopCh <-chan struct{},
 		stopCh:      stopCh,
 		cancel:      cancel,
 		ppNext:      ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	pfp.shards.Init = func(shard *pipeFacetsProcessorShard) {
 		shard.pfp = pfp
 	}
-	pfp.stateSizeBudget.Store(maxStateSize)
+	pfp.initStateSizeLimit(maxStateSize)
 
 	return pfp
 }
@@ -127,8 +124,7 @@ type pipeFacetsProcessor struct {
 
 	shards atomicutil.Slice[pipeFacetsProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
 }
 
 type pipeFacetsProcessorShard struct {
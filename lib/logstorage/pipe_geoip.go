@@ -0,0 +1,306 @@
+package logstorage
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// pipeGeoIP processes '| geoip ...' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#geoip-pipe
+type pipeGeoIP struct {
+	// field is the field with IPv4 or IPv6 address
+	field string
+
+	// fields contains the names of geoIPFields to return
+	fields []string
+
+	// resultPrefix is the prefix to add to the returned fields
+	resultPrefix string
+
+	// fieldIdxs contains indexes at geoIPResult for fields
+	fieldIdxs []int
+}
+
+const defaultGeoIPResultPrefix = "geo."
+
+func (pg *pipeGeoIP) String() string {
+	s := "geoip " + quoteTokenIfNeeded(pg.field)
+	if !slices.Equal(pg.fields, geoIPFields) {
+		s += " fields (" + fieldNamesString(pg.fields) + ")"
+	}
+	if pg.resultPrefix != defaultGeoIPResultPrefix {
+		s += " result_prefix " + quoteTokenIfNeeded(pg.resultPrefix)
+	}
+	return s
+}
+
+func (pg *pipeGeoIP) splitToRemoteAndLocal(_ int64) (pipe, []pipe) {
+	return pg, nil
+}
+
+func (pg *pipeGeoIP) canLiveTail() bool {
+	return true
+}
+
+func (pg *pipeGeoIP) canReturnLastNResults() bool {
+	for _, field := range pg.fields {
+		if pg.resultPrefix+field == "_time" {
+			return false
+		}
+	}
+	return true
+}
+
+func (pg *pipeGeoIP) updateNeededFields(pf *prefixfilter.Filter) {
+	needField := false
+	for _, field := range pg.fields {
+		resultField := pg.resultPrefix + field
+		if pf.MatchString(resultField) {
+			pf.AddDenyFilter(resultField)
+			needField = true
+		}
+	}
+	if needField {
+		pf.AddAllowFilter(pg.field)
+	}
+}
+
+func (pg *pipeGeoIP) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pg *pipeGeoIP) initFilterInValues(_ *inValuesCache, _ getFieldValuesFunc, _ bool) (pipe, error) {
+	return pg, nil
+}
+
+func (pg *pipeGeoIP) visitSubqueries(_ func(q *Query)) {
+	// nothing to do
+}
+
+func (pg *pipeGeoIP) newPipeProcessor(_ int, _ <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+	return &pipeGeoIPProcessor{
+		pg:     pg,
+		ppNext: ppNext,
+		dbs:    getGeoIPDatabases(),
+	}
+}
+
+type pipeGeoIPProcessor struct {
+	pg     *pipeGeoIP
+	ppNext pipeProcessor
+
+	// dbs contains GeoIP databases to use during the query execution.
+	dbs []*GeoIPDatabase
+
+	shards atomicutil.Slice[pipeGeoIPProcessorShard]
+}
+
+type pipeGeoIPProcessorShard struct {
+	rcs []resultColumn
+
+	// cache contains the results for the previously seen string values.
+	cache map[string]*geoIPResult
+
+	// cacheIPv4 contains the results for the previously seen IPv4 values stored with valueTypeIPv4.
+	cacheIPv4 map[uint32]*geoIPResult
+
+	// dictResults contains the results for dict values at valueTypeDict column.
+	dictResults []*geoIPResult
+}
+
+// maxGeoIPCacheSize is the maximum number of entries in per-shard caches for `geoip` pipe.
+const maxGeoIPCacheSize = 100_000
+
+func (pgp *pipeGeoIPProcessor) writeBlock(workerID uint, br *blockResult) {
+	if br.rowsLen == 0 {
+		return
+	}
+
+	pg := pgp.pg
+	shard := pgp.shards.Get(workerID)
+
+	shard.rcs = shard.rcs[:0]
+	for _, field := range pg.fields {
+		shard.rcs = appendResultColumnWithName(shard.rcs, pg.resultPrefix+field)
+	}
+
+	c := br.getColumnByName(pg.field)
+	switch {
+	case c.isConst:
+		result := shard.getResultForString(pgp.dbs, c.valuesEncoded[0])
+		shard.addResultColumnsConst(br, pg, result)
+	case c.isTime:
+		shard.addResultColumnsConst(br, pg, &emptyGeoIPResult)
+	default:
+		switch c.valueType {
+		case valueTypeIPv4:
+			// Fast path - IPv4 addresses are already parsed
+			var result *geoIPResult
+			valuesEncoded := c.getValuesEncoded(br)
+			for rowIdx, v := range valuesEncoded {
+				if rowIdx == 0 || v != valuesEncoded[rowIdx-1] {
+					result = shard.getResultForIPv4(pgp.dbs, unmarshalIPv4(v))
+				}
+				shard.addResult(pg, result)
+			}
+		case valueTypeDict:
+			// Fast path - look up only dict values
+			shard.dictResults = shard.dictResults[:0]
+			for _, v := range c.dictValues {
+				shard.dictResults = append(shard.dictResults, shard.getResultForString(pgp.dbs, v))
+			}
+			for _, v := range c.getValuesEncoded(br) {
+				shard.addResult(pg, shard.dictResults[v[0]])
+			}
+		case valueTypeString:
+			var result *geoIPResult
+			values := c.getValues(br)
+			for rowIdx, v := range values {
+				if rowIdx == 0 || v != values[rowIdx-1] {
+					result = shard.getResultForString(pgp.dbs, v)
+				}
+				shard.addResult(pg, result)
+			}
+		default:
+			// Numeric and timestamp values cannot contain IP addresses
+			shard.addResultColumnsConst(br, pg, &emptyGeoIPResult)
+			pgp.ppNext.writeBlock(workerID, br)
+			return
+		}
+		for i := range shard.rcs {
+			br.addResultColumn(shard.rcs[i])
+		}
+	}
+
+	pgp.ppNext.writeBlock(workerID, br)
+}
+
+func (shard *pipeGeoIPProcessorShard) addResult(pg *pipeGeoIP, result *geoIPResult) {
+	for i, idx := range pg.fieldIdxs {
+		shard.rcs[i].addValue(result[idx])
+	}
+}
+
+func (shard *pipeGeoIPProcessorShard) addResultColumnsConst(br *blockResult, pg *pipeGeoIP, result *geoIPResult) {
+	shard.addResult(pg, result)
+	for i := range shard.rcs {
+		br.addResultColumnConst(shard.rcs[i])
+	}
+}
+
+func (shard *pipeGeoIPProcessorShard) getResultForString(dbs []*GeoIPDatabase, v string) *geoIPResult {
+	if v == "" || len(dbs) == 0 {
+		return &emptyGeoIPResult
+	}
+	if result, ok := shard.cache[v]; ok {
+		return result
+	}
+
+	result := &emptyGeoIPResult
+	if n, ok := tryParseIPv4(v); ok {
+		result = shard.getResultForIPv4(dbs, n)
+	} else if addr, err := netip.ParseAddr(v); err == nil {
+		result = getGeoIPResult(dbs, addr)
+	}
+
+	if shard.cache == nil || len(shard.cache) >= maxGeoIPCacheSize {
+		shard.cache = make(map[string]*geoIPResult)
+	}
+	shard.cache[string(append([]byte{}, v...))] = result
+	return result
+}
+
+func (shard *pipeGeoIPProcessorShard) getResultForIPv4(dbs []*GeoIPDatabase, n uint32) *geoIPResult {
+	if len(dbs) == 0 {
+		return &emptyGeoIPResult
+	}
+	if result, ok := shard.cacheIPv4[n]; ok {
+		return result
+	}
+
+	addr := netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	result := getGeoIPResult(dbs, addr)
+
+	if shard.cacheIPv4 == nil || len(shard.cacheIPv4) >= maxGeoIPCacheSize {
+		shard.cacheIPv4 = make(map[uint32]*geoIPResult)
+	}
+	shard.cacheIPv4[n] = result
+	return result
+}
+
+func getGeoIPResult(dbs []*GeoIPDatabase, addr netip.Addr) *geoIPResult {
+	result, err := lookupGeoIP(dbs, addr)
+	if err != nil {
+		// The database is corrupted. Return empty result, since there is no way to return the error from the pipe processor.
+		return &emptyGeoIPResult
+	}
+	return result
+}
+
+func (pgp *pipeGeoIPProcessor) flush() error {
+	return nil
+}
+
+func parsePipeGeoIP(lex *lexer) (pipe, error) {
+	if !lex.isKeyword("geoip") {
+		return nil, fmt.Errorf("unexpected token: %q; want %q", lex.token, "geoip")
+	}
+	lex.nextToken()
+
+	if lex.isKeyword("from") {
+		lex.nextToken()
+	}
+	field, err := parseFieldName(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse field name with IP address: %w", err)
+	}
+
+	fields := geoIPFields
+	if lex.isKeyword("fields") {
+		lex.nextToken()
+		fs, err := parseFieldNamesInParens(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'fields(...)' at 'geoip': %w", err)
+		}
+		if len(fs) == 0 {
+			return nil, fmt.Errorf("'fields(...)' at 'geoip' must contain at least a single field")
+		}
+		fields = fs
+	}
+
+	fieldIdxs := make([]int, len(fields))
+	for i, f := range fields {
+		idx := slices.Index(geoIPFields, f)
+		if idx < 0 {
+			return nil, fmt.Errorf("unsupported field %q at 'geoip'; supported fields: %s", f, fieldNamesString(geoIPFields))
+		}
+		if slices.Contains(fields[:i], f) {
+			return nil, fmt.Errorf("duplicate field %q at 'geoip'", f)
+		}
+		fieldIdxs[i] = idx
+	}
+
+	resultPrefix := defaultGeoIPResultPrefix
+	if lex.isKeyword("result_prefix") {
+		lex.nextToken()
+		p, err := lex.nextCompoundToken()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'result_prefix': %w", err)
+		}
+		resultPrefix = p
+	}
+
+	pg := &pipeGeoIP{
+		field:        field,
+		fields:       fields,
+		resultPrefix: resultPrefix,
+		fieldIdxs:    fieldIdxs,
+	}
+	return pg, nil
+}
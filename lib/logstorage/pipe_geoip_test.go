@@ -0,0 +1,323 @@
+package logstorage
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/mmdb"
+)
+
+func TestParsePipeGeoIPSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`geoip ip`)
+	f(`geoip client.ip`)
+	f(`geoip "client ip"`)
+	f(`geoip ip fields (country)`)
+	f(`geoip ip fields (asn, as_org, country)`)
+	f(`geoip ip result_prefix foo_`)
+	f(`geoip ip result_prefix ""`)
+	f(`geoip ip fields (city) result_prefix "client geo."`)
+}
+
+func TestParsePipeGeoIPFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`geoip`)
+	f(`geoip from`)
+	f(`geoip *`)
+	f(`geoip ip fields`)
+	f(`geoip ip fields ()`)
+	f(`geoip ip fields (foo)`)
+	f(`geoip ip fields (country, country)`)
+	f(`geoip ip fields (*)`)
+	f(`geoip ip result_prefix`)
+}
+
+func TestPipeGeoIP(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	defer geoIPDatabases.Store(nil)
+
+	// no databases
+	f(`geoip ip fields (country, asn)`, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+		},
+	}, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+			{"geo.country", ""},
+			{"geo.asn", ""},
+		},
+	})
+
+	SetGeoIPDatabases(newTestGeoIPDatabases(t))
+
+	// all the fields
+	f(`geoip ip`, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+		},
+	}, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+			{"geo.country", "AU"},
+			{"geo.country_name", "Australia"},
+			{"geo.continent", "OC"},
+			{"geo.region", "New South Wales"},
+			{"geo.city", "Sydney"},
+			{"geo.latitude", "-33.8688"},
+			{"geo.longitude", "151.209"},
+			{"geo.asn", "13335"},
+			{"geo.as_org", "Cloudflare, Inc."},
+		},
+	})
+
+	// the optional 'from' keyword
+	f(`geoip from ip fields (country)`, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+		},
+	}, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+			{"geo.country", "AU"},
+		},
+	})
+
+	// multiple rows
+	f(`geoip ip fields (country, asn) result_prefix client_`, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+		},
+		{
+			{"ip", "1.2.3.5"},
+		},
+		{
+			{"ip", "2001:db8::1"},
+		},
+		{
+			{"ip", "::ffff:1.2.3.4"},
+		},
+		{
+			{"ip", "8.8.8.8"},
+		},
+		{
+			{"ip", "127.0.0.1"},
+		},
+		{
+			{"ip", "foobar"},
+		},
+		{
+			{"ip", ""},
+		},
+		{
+			{"ip", "123"},
+		},
+		{
+			{"_msg", "missing ip"},
+		},
+	}, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+			{"client_country", "AU"},
+			{"client_asn", "13335"},
+		},
+		{
+			{"ip", "1.2.3.5"},
+			{"client_country", "AU"},
+			{"client_asn", "13335"},
+		},
+		{
+			{"ip", "2001:db8::1"},
+			{"client_country", "DE"},
+			{"client_asn", ""},
+		},
+		{
+			{"ip", "::ffff:1.2.3.4"},
+			{"client_country", "AU"},
+			{"client_asn", "13335"},
+		},
+		{
+			{"ip", "8.8.8.8"},
+			{"client_country", ""},
+			{"client_asn", "15169"},
+		},
+		{
+			{"ip", "127.0.0.1"},
+			{"client_country", ""},
+			{"client_asn", ""},
+		},
+		{
+			{"ip", "foobar"},
+			{"client_country", ""},
+			{"client_asn", ""},
+		},
+		{
+			{"ip", ""},
+			{"client_country", ""},
+			{"client_asn", ""},
+		},
+		{
+			{"ip", "123"},
+			{"client_country", ""},
+			{"client_asn", ""},
+		},
+		{
+			{"_msg", "missing ip"},
+			{"client_country", ""},
+			{"client_asn", ""},
+		},
+	})
+
+	// the result field overrides the source field
+	f(`geoip ip fields (country) result_prefix "ip"`, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+			{"ipcountry", "old"},
+		},
+	}, [][]Field{
+		{
+			{"ip", "1.2.3.4"},
+			{"ipcountry", "AU"},
+		},
+	})
+}
+
+func TestPipeGeoIPProcessorShardIPv4(t *testing.T) {
+	dbs := newTestGeoIPDatabases(t)
+
+	var shard pipeGeoIPProcessorShard
+	f := func(ip string) {
+		t.Helper()
+
+		n, ok := tryParseIPv4(ip)
+		if !ok {
+			t.Fatalf("cannot parse IPv4 %q", ip)
+		}
+		result := shard.getResultForIPv4(dbs, n)
+
+		// Verify that the result for the encoded IPv4 matches the result for the string representation.
+		var shardStr pipeGeoIPProcessorShard
+		resultExpected := shardStr.getResultForString(dbs, ip)
+		if *result != *resultExpected {
+			t.Fatalf("unexpected result for %s; got %q; want %q", ip, result, resultExpected)
+		}
+
+		// Verify that the cached result is returned on the second call.
+		if resultCached := shard.getResultForIPv4(dbs, n); resultCached != result {
+			t.Fatalf("expecting cached result for %s", ip)
+		}
+	}
+
+	f("1.2.3.4")
+	f("8.8.8.8")
+	f("0.0.0.0")
+	f("255.255.255.255")
+}
+
+func TestPipeGeoIPUpdateNeededFields(t *testing.T) {
+	f := func(s string, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected)
+	}
+
+	// all the needed fields
+	f("geoip ip fields (country, asn)", "*", "", "*", "geo.asn,geo.country")
+
+	// unneeded fields do not intersect with the result fields
+	f("geoip ip fields (country, asn)", "*", "f1,f2", "*", "f1,f2,geo.asn,geo.country")
+
+	// unneeded fields intersect with the source field
+	f("geoip ip fields (country, asn)", "*", "f1,ip", "*", "f1,geo.asn,geo.country")
+
+	// unneeded fields cover all the result fields
+	f("geoip ip fields (country, asn)", "*", "ip,geo.*", "*", "geo.*,ip")
+
+	// needed fields do not intersect with the result fields
+	f("geoip ip fields (country, asn)", "f1,f2", "", "f1,f2", "")
+
+	// needed fields intersect with the result fields
+	f("geoip ip fields (country, asn)", "f1,geo.country", "", "f1,ip", "")
+	f("geoip ip fields (country, asn)", "f1,geo.*", "", "f1,geo.*,ip", "geo.asn,geo.country")
+}
+
+func newTestGeoIPDatabases(t *testing.T) []*GeoIPDatabase {
+	t.Helper()
+
+	city := mmdb.NewWriter(6, 28, "Test-City")
+	mustInsertGeoIPValue(t, city, "1.2.3.0/24", map[string]any{
+		"continent": map[string]any{
+			"code": "OC",
+		},
+		"country": map[string]any{
+			"iso_code": "AU",
+			"names": map[string]any{
+				"en": "Australia",
+			},
+		},
+		"subdivisions": []any{
+			map[string]any{
+				"names": map[string]any{
+					"en": "New South Wales",
+				},
+			},
+		},
+		"city": map[string]any{
+			"names": map[string]any{
+				"en": "Sydney",
+			},
+		},
+		"location": map[string]any{
+			"latitude":  -33.8688,
+			"longitude": 151.209,
+		},
+	})
+	mustInsertGeoIPValue(t, city, "2001:db8::/32", map[string]any{
+		"registered_country": map[string]any{
+			"iso_code": "DE",
+		},
+	})
+
+	asn := mmdb.NewWriter(4, 24, "Test-ASN")
+	mustInsertGeoIPValue(t, asn, "1.2.0.0/16", map[string]any{
+		"autonomous_system_number":       uint32(13335),
+		"autonomous_system_organization": "Cloudflare, Inc.",
+	})
+	mustInsertGeoIPValue(t, asn, "8.8.8.0/24", map[string]any{
+		"autonomous_system_number":       uint32(15169),
+		"autonomous_system_organization": "Google LLC",
+	})
+
+	var dbs []*GeoIPDatabase
+	for _, w := range []*mmdb.Writer{city, asn} {
+		data, err := w.Bytes()
+		if err != nil {
+			t.Fatalf("cannot build database: %s", err)
+		}
+		db, err := OpenGeoIPDatabase(data)
+		if err != nil {
+			t.Fatalf("cannot open database: %s", err)
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+func mustInsertGeoIPValue(t *testing.T, w *mmdb.Writer, prefix string, value any) {
+	t.Helper()
+
+	if err := w.Insert(netip.MustParsePrefix(prefix), value); err != nil {
+		t.Fatalf("cannot insert %s: %s", prefix, err)
+	}
+}
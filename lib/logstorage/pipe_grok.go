@@ -0,0 +1,149 @@
+package logstorage
+
+import (
+	"fmt"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// pipeGrok processes '| grok ...' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#grok-pipe
+type pipeGrok struct {
+	// pattern is the original grok pattern
+	pattern string
+
+	// pe is extract_regexp pipe, which is used for extracting fields with the regexp compiled from the pattern.
+	pe *pipeExtractRegexp
+}
+
+func (pg *pipeGrok) String() string {
+	pe := pg.pe
+	s := "grok"
+	if pe.iff != nil {
+		s += " " + pe.iff.String()
+	}
+	s += " " + quoteTokenIfNeeded(pg.pattern)
+	if !isMsgFieldName(pe.fromField) {
+		s += " from " + quoteTokenIfNeeded(pe.fromField)
+	}
+	if pe.keepOriginalFields {
+		s += " keep_original_fields"
+	}
+	if pe.skipEmptyResults {
+		s += " skip_empty_results"
+	}
+	return s
+}
+
+func (pg *pipeGrok) splitToRemoteAndLocal(_ int64) (pipe, []pipe) {
+	return pg, nil
+}
+
+func (pg *pipeGrok) canLiveTail() bool {
+	return true
+}
+
+func (pg *pipeGrok) canReturnLastNResults() bool {
+	return pg.pe.canReturnLastNResults()
+}
+
+func (pg *pipeGrok) hasFilterInWithQuery() bool {
+	return pg.pe.hasFilterInWithQuery()
+}
+
+func (pg *pipeGrok) initFilterInValues(cache *inValuesCache, getFieldValuesFunc getFieldValuesFunc, keepSubquery bool) (pipe, error) {
+	peNew, err := pg.pe.initFilterInValues(cache, getFieldValuesFunc, keepSubquery)
+	if err != nil {
+		return nil, err
+	}
+	pgNew := *pg
+	pgNew.pe = peNew.(*pipeExtractRegexp)
+	return &pgNew, nil
+}
+
+func (pg *pipeGrok) visitSubqueries(visitFunc func(q *Query)) {
+	pg.pe.visitSubqueries(visitFunc)
+}
+
+func (pg *pipeGrok) updateNeededFields(pf *prefixfilter.Filter) {
+	pg.pe.updateNeededFields(pf)
+}
+
+func (pg *pipeGrok) newPipeProcessor(concurrency int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
+	return pg.pe.newPipeProcessor(concurrency, stopCh, cancel, ppNext)
+}
+
+func parsePipeGrok(lex *lexer) (pipe, error) {
+	if !lex.isKeyword("grok") {
+		return nil, fmt.Errorf("unexpected token: %q; want %q", lex.token, "grok")
+	}
+	lex.nextToken()
+
+	// parse optional if (...)
+	var iff *ifFilter
+	if lex.isKeyword("if") {
+		f, err := parseIfFilter(lex)
+		if err != nil {
+			return nil, err
+		}
+		iff = f
+	}
+
+	// parse pattern
+	pattern, err := lex.nextCompoundToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read 'pattern': %w", err)
+	}
+	var customPatterns map[string]string
+	if p := grokCustomPatterns.Load(); p != nil {
+		customPatterns = *p
+	}
+	gp, err := compileGrokPattern(pattern, customPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse grok 'pattern' %q: %w", pattern, err)
+	}
+	if !gp.hasFields {
+		return nil, fmt.Errorf("the grok 'pattern' %q must contain at least a single field in the form %%{PATTERN:field}", pattern)
+	}
+
+	// parse optional 'from ...' part
+	fromField := "_msg"
+	if lex.isKeyword("from") {
+		lex.nextToken()
+		f, err := parseFieldName(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'from' field name: %w", err)
+		}
+		fromField = f
+	}
+
+	keepOriginalFields := false
+	skipEmptyResults := false
+	switch {
+	case lex.isKeyword("keep_original_fields"):
+		lex.nextToken()
+		keepOriginalFields = true
+	case lex.isKeyword("skip_empty_results"):
+		lex.nextToken()
+		skipEmptyResults = true
+	}
+
+	pe := &pipeExtractRegexp{
+		fromField:          fromField,
+		re:                 gp.re,
+		reStr:              gp.re.String(),
+		reFields:           gp.fields,
+		reFieldAliases:     gp.aliases,
+		reFieldTypes:       gp.types,
+		keepOriginalFields: keepOriginalFields,
+		skipEmptyResults:   skipEmptyResults,
+		iff:                iff,
+	}
+	pg := &pipeGrok{
+		pattern: pattern,
+		pe:      pe,
+	}
+
+	return pg, nil
+}
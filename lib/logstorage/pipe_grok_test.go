@@ -0,0 +1,224 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeGrokSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`grok "%{IP:ip}"`)
+	f(`grok "%{IP:ip} %{NUMBER:bytes:int}"`)
+	f(`grok "%{IP:ip}" skip_empty_results`)
+	f(`grok "%{IP:ip}" keep_original_fields`)
+	f(`grok "%{IP:ip}" from x`)
+	f(`grok "%{IP:ip}" from x skip_empty_results`)
+	f(`grok "%{IP:ip}" from x keep_original_fields`)
+	f(`grok if (x:y) "%{IP:ip}" from baz`)
+	f(`grok if (x:y) "%{IP:ip}" from baz skip_empty_results`)
+	f(`grok if (x:y) "%{IP:ip}" from baz keep_original_fields`)
+	f(`grok "%{COMBINEDAPACHELOG}"`)
+	f(`grok "user=(?P<user>\\w+)"`)
+}
+
+func TestParsePipeGrokFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`grok`)
+	f(`grok keep_original_fields`)
+	f(`grok skip_empty_results`)
+	f(`grok from`)
+	f(`grok from x`)
+	f(`grok from x "%{IP:ip}"`)
+	f(`grok if (x:y)`)
+	f(`grok "%{IP:ip}" if (x:y)`)
+
+	// missing fields
+	f(`grok "%{IP}"`)
+	f(`grok "foo"`)
+
+	// unknown pattern
+	f(`grok "%{UNKNOWN_PATTERN:x}"`)
+
+	// unsupported type
+	f(`grok "%{NUMBER:x:bool}"`)
+
+	// invalid regexp
+	f(`grok "(?P<x>foo"`)
+
+	// invalid from field
+	f(`grok "%{IP:ip}" from *`)
+	f(`grok "%{IP:ip}" from x*`)
+}
+
+func TestPipeGrok(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	// combined access log
+	f(`grok "%{COMBINEDAPACHELOG}"`, [][]Field{
+		{
+			{"_msg", `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`},
+			{"clientip", "127.0.0.1"},
+			{"ident", "-"},
+			{"auth", "frank"},
+			{"timestamp", "10/Oct/2000:13:55:36 -0700"},
+			{"verb", "GET"},
+			{"request", "/apache_pb.gif"},
+			{"httpversion", "1.0"},
+			{"rawrequest", ""},
+			{"response", "200"},
+			{"bytes", "2326"},
+			{"referrer", `"http://www.example.com/start.html"`},
+			{"agent", `"Mozilla/4.08"`},
+		},
+	})
+
+	// type conversion
+	f(`grok "took %{NUMBER:duration:float}s, status=%{NUMBER:status:int}"`, [][]Field{
+		{
+			{"_msg", `took 1.50s, status=0200`},
+		},
+		{
+			{"_msg", `took 3s, status=12.7`},
+		},
+		{
+			{"_msg", `foo`},
+			{"status", "abc"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `took 1.50s, status=0200`},
+			{"duration", "1.5"},
+			{"status", "200"},
+		},
+		{
+			{"_msg", `took 3s, status=12.7`},
+			{"duration", "3"},
+			{"status", "12"},
+		},
+		{
+			{"_msg", `foo`},
+			{"duration", ""},
+			{"status", ""},
+		},
+	})
+
+	// duplicate field names
+	f(`grok "^(?:%{IPV4:host}|%{HOSTNAME:host}):%{POSINT:port}$"`, [][]Field{
+		{
+			{"_msg", `10.0.0.1:80`},
+		},
+		{
+			{"_msg", `example.com:443`},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `10.0.0.1:80`},
+			{"host", "10.0.0.1"},
+			{"port", "80"},
+		},
+		{
+			{"_msg", `example.com:443`},
+			{"host", "example.com"},
+			{"port", "443"},
+		},
+	})
+
+	// nested field names and inline named groups
+	f(`grok "%{WORD:[http][method]} (?P<path>\\S+)" from req`, [][]Field{
+		{
+			{"req", `POST /api/v1`},
+		},
+	}, [][]Field{
+		{
+			{"req", `POST /api/v1`},
+			{"http.method", "POST"},
+			{"path", "/api/v1"},
+		},
+	})
+
+	// skip empty results
+	f(`grok "level=%{LOGLEVEL:level}" skip_empty_results`, [][]Field{
+		{
+			{"_msg", `level=ERROR foo`},
+			{"level", "x"},
+		},
+		{
+			{"_msg", `foo`},
+			{"level", "x"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `level=ERROR foo`},
+			{"level", "ERROR"},
+		},
+		{
+			{"_msg", `foo`},
+			{"level", "x"},
+		},
+	})
+
+	// single row, if match
+	f(`grok if (app:nginx) "%{IP:ip}"`, [][]Field{
+		{
+			{"_msg", `from 1.2.3.4`},
+			{"app", "nginx"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `from 1.2.3.4`},
+			{"app", "nginx"},
+			{"ip", "1.2.3.4"},
+		},
+	})
+
+	// single row, if mismatch
+	f(`grok if (app:nginx) "%{IP:ip}"`, [][]Field{
+		{
+			{"_msg", `from 5.6.7.8`},
+			{"app", "x"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", `from 5.6.7.8`},
+			{"app", "x"},
+		},
+	})
+}
+
+func TestPipeGrokUpdateNeededFields(t *testing.T) {
+	f := func(s string, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected)
+	}
+
+	// all the needed fields
+	f("grok '%{WORD:foo}' from x", "*", "", "*", "foo")
+	f("grok if (foo:bar) '%{WORD:foo}' from x", "*", "", "*", "")
+	f("grok '%{WORD:foo}' from x keep_original_fields", "*", "", "*", "")
+
+	// duplicate fields
+	f("grok '%{WORD:foo}|%{INT:foo}' from x", "*", "", "*", "foo")
+
+	// unneeded fields intersect with output fields
+	f("grok '%{WORD:foo} %{WORD:bar}' from x", "*", "f2,foo", "*", "bar,f2,foo")
+
+	// needed fields do not intersect with output fields
+	f("grok '%{WORD:foo}' from x", "f1,f2", "", "f1,f2", "")
+
+	// needed fields intersect with output fields
+	f("grok '%{WORD:foo}' from x", "f2,foo", "", "f2,x", "")
+}
@@ -0,0 +1,330 @@
+package logstorage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// pipeLookup processes '| lookup ...' pipe.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#lookup-pipe
+type pipeLookup struct {
+	// tableName is the name of the lookup table
+	tableName string
+
+	// byFields contains fields to match against the lookup table columns with the same names
+	byFields []string
+
+	// fields contains lookup table columns to add to the matching logs.
+	//
+	// If it is empty, then all the lookup table columns except of byFields are added.
+	fields []string
+
+	keepOriginalFields bool
+	skipEmptyResults   bool
+
+	// rows contains lookup table rows with byFields values followed by fields values.
+	//
+	// rows are automatically initialized from the lookup table during query execution.
+	// They are passed to remote storage nodes together with the query, so the lookup table is needed only at vlselect.
+	rows [][]string
+
+	// isInited is set to true after rows are initialized.
+	isInited bool
+
+	// m maps the marshaled byFields values to fields added to the matching logs
+	m map[string][]Field
+}
+
+func (pl *pipeLookup) String() string {
+	s := fmt.Sprintf("lookup %s by (%s)", quoteTokenIfNeeded(pl.tableName), fieldNamesString(pl.byFields))
+	if len(pl.fields) > 0 {
+		s += fmt.Sprintf(" fields (%s)", fieldNamesString(pl.fields))
+	}
+	if pl.isInited {
+		a := make([]string, len(pl.rows))
+		for i, row := range pl.rows {
+			values := make([]string, len(row))
+			for j, v := range row {
+				values[j] = quoteTokenIfNeeded(v)
+			}
+			a[i] = "(" + strings.Join(values, ", ") + ")"
+		}
+		s += " rows (" + strings.Join(a, ", ") + ")"
+	}
+	if pl.keepOriginalFields {
+		s += " keep_original_fields"
+	}
+	if pl.skipEmptyResults {
+		s += " skip_empty_results"
+	}
+	return s
+}
+
+func (pl *pipeLookup) splitToRemoteAndLocal(_ int64) (pipe, []pipe) {
+	return pl, nil
+}
+
+func (pl *pipeLookup) canLiveTail() bool {
+	return true
+}
+
+func (pl *pipeLookup) canReturnLastNResults() bool {
+	return len(pl.fields) > 0 && !slices.Contains(pl.fields, "_time")
+}
+
+func (pl *pipeLookup) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pl *pipeLookup) initFilterInValues(_ *inValuesCache, _ getFieldValuesFunc, _ bool) (pipe, error) {
+	return pl, nil
+}
+
+func (pl *pipeLookup) visitSubqueries(_ func(q *Query)) {
+	// nothing to do
+}
+
+func (pl *pipeLookup) initLookupRows(getLookupTable getLookupTableFunc) (pipe, error) {
+	if pl.isInited {
+		return pl, nil
+	}
+
+	lt, err := getLookupTable(pl.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize [%s]: %w", pl, err)
+	}
+
+	fields := pl.fields
+	if len(fields) == 0 {
+		for _, column := range lt.columns {
+			if !slices.Contains(pl.byFields, column) {
+				fields = append(fields, column)
+			}
+		}
+	}
+	columns := append(slices.Clip(pl.byFields), fields...)
+	rows, err := lt.getColumnValues(columns)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize [%s]: %w", pl, err)
+	}
+
+	plNew := *pl
+	plNew.fields = fields
+	plNew.setRows(rows)
+	return &plNew, nil
+}
+
+func (pl *pipeLookup) setRows(rows [][]string) {
+	m := make(map[string][]Field, len(rows))
+	var buf []byte
+	for _, row := range rows {
+		byValues := row[:len(pl.byFields)]
+		buf = marshalStrings(buf[:0], byValues)
+		if _, ok := m[string(buf)]; ok {
+			// The first matching row wins.
+			continue
+		}
+
+		values := row[len(pl.byFields):]
+		extraFields := make([]Field, len(values))
+		for i, v := range values {
+			extraFields[i] = Field{
+				Name:  pl.fields[i],
+				Value: v,
+			}
+		}
+		m[string(buf)] = extraFields
+	}
+
+	pl.rows = rows
+	pl.m = m
+	pl.isInited = true
+}
+
+func (pl *pipeLookup) updateNeededFields(pf *prefixfilter.Filter) {
+	pf.AddAllowFilters(pl.byFields)
+}
+
+func (pl *pipeLookup) newPipeProcessor(_ int, stopCh <-chan struct{}, _ func(), ppNext pipeProcessor) pipeProcessor {
+	return &pipeLookupProcessor{
+		pl:     pl,
+		stopCh: stopCh,
+		ppNext: ppNext,
+	}
+}
+
+type pipeLookupProcessor struct {
+	pl     *pipeLookup
+	stopCh <-chan struct{}
+	ppNext pipeProcessor
+
+	shards atomicutil.Slice[pipeLookupProcessorShard]
+}
+
+type pipeLookupProcessorShard struct {
+	wctx pipeUnpackWriteContext
+
+	byValues     []string
+	byValuesIdxs []int
+	tmpBuf       []byte
+}
+
+func (plp *pipeLookupProcessor) writeBlock(workerID uint, br *blockResult) {
+	if br.rowsLen == 0 {
+		return
+	}
+
+	pl := plp.pl
+	if !pl.isInited {
+		// The lookup rows weren't initialized. Pass the rows as is.
+		plp.ppNext.writeBlock(workerID, br)
+		return
+	}
+
+	shard := plp.shards.Get(workerID)
+	shard.wctx.init(workerID, plp.ppNext, pl.keepOriginalFields, pl.skipEmptyResults, br)
+
+	shard.byValues = slicesutil.SetLength(shard.byValues, len(pl.byFields))
+	byValues := shard.byValues
+
+	cs := br.getColumns()
+	shard.byValuesIdxs = slicesutil.SetLength(shard.byValuesIdxs, len(cs))
+	byValuesIdxs := shard.byValuesIdxs
+	for i := range cs {
+		byValuesIdxs[i] = slices.Index(pl.byFields, cs[i].name)
+	}
+
+	for rowIdx := 0; rowIdx < br.rowsLen; rowIdx++ {
+		if needStop(plp.stopCh) {
+			return
+		}
+
+		clear(byValues)
+		for j := range cs {
+			if cIdx := byValuesIdxs[j]; cIdx >= 0 {
+				byValues[cIdx] = cs[j].getValueAtRow(br, rowIdx)
+			}
+		}
+
+		shard.tmpBuf = marshalStrings(shard.tmpBuf[:0], byValues)
+		extraFields := pl.m[string(shard.tmpBuf)]
+		shard.wctx.writeRow(rowIdx, extraFields)
+	}
+
+	shard.wctx.flush()
+	shard.wctx.reset()
+}
+
+func (plp *pipeLookupProcessor) flush() error {
+	return nil
+}
+
+func parsePipeLookup(lex *lexer) (pipe, error) {
+	if !lex.isKeyword("lookup") {
+		return nil, fmt.Errorf("unexpected token: %q; want %q", lex.token, "lookup")
+	}
+	lex.nextToken()
+
+	tableName, err := lex.nextCompoundToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read lookup table name: %w", err)
+	}
+	if tableName == "" {
+		return nil, fmt.Errorf("lookup table name cannot be empty")
+	}
+
+	// parse by (...)
+	if lex.isKeyword("by", "on") {
+		lex.nextToken()
+	}
+	byFields, err := parseFieldNamesInParens(lex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse 'by(...)' at 'lookup': %w", err)
+	}
+	if len(byFields) == 0 {
+		return nil, fmt.Errorf("'by(...)' at 'lookup' must contain at least a single field")
+	}
+	if slices.Contains(byFields, "*") {
+		return nil, fmt.Errorf("lookup by '*' isn't supported")
+	}
+
+	pl := &pipeLookup{
+		tableName: tableName,
+		byFields:  byFields,
+	}
+
+	// parse optional fields (...)
+	if lex.isKeyword("fields") {
+		lex.nextToken()
+		fields, err := parseFieldNamesInParens(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'fields(...)' at 'lookup': %w", err)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("'fields(...)' at 'lookup' must contain at least a single field")
+		}
+		if slices.Contains(fields, "*") {
+			return nil, fmt.Errorf("lookup fields '*' isn't supported")
+		}
+		pl.fields = fields
+	}
+
+	// parse optional rows (...), which are set by vlselect when sending the query to vlstorage nodes
+	if lex.isKeyword("rows") {
+		lex.nextToken()
+		if len(pl.fields) == 0 {
+			return nil, fmt.Errorf("'rows(...)' at 'lookup' requires 'fields(...)'")
+		}
+		rows, err := parseLookupRows(lex, len(pl.byFields)+len(pl.fields))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'rows(...)' at 'lookup': %w", err)
+		}
+		pl.setRows(rows)
+	}
+
+	switch {
+	case lex.isKeyword("keep_original_fields"):
+		lex.nextToken()
+		pl.keepOriginalFields = true
+	case lex.isKeyword("skip_empty_results"):
+		lex.nextToken()
+		pl.skipEmptyResults = true
+	}
+
+	return pl, nil
+}
+
+func parseLookupRows(lex *lexer, columnsCount int) ([][]string, error) {
+	if !lex.isKeyword("(") {
+		return nil, fmt.Errorf("missing '('")
+	}
+	lex.nextToken()
+
+	var rows [][]string
+	for !lex.isKeyword(")") {
+		row, err := parseArgsInParens(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse row #%d: %w", len(rows)+1, err)
+		}
+		if len(row) != columnsCount {
+			return nil, fmt.Errorf("unexpected number of values at row #%d; got %d; want %d", len(rows)+1, len(row), columnsCount)
+		}
+		rows = append(rows, row)
+		if lex.isKeyword(")") {
+			break
+		}
+		if !lex.isKeyword(",") {
+			return nil, fmt.Errorf("missing ',' after row #%d; got %q instead", len(rows), lex.token)
+		}
+		lex.nextToken()
+	}
+	lex.nextToken()
+	return rows, nil
+}
@@ -0,0 +1,236 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeLookupSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`lookup hosts by (host)`)
+	f(`lookup hosts by (host, app)`)
+	f(`lookup hosts by (host) fields (dc)`)
+	f(`lookup hosts by (host) fields (dc, rack)`)
+	f(`lookup hosts by (host) keep_original_fields`)
+	f(`lookup hosts by (host) fields (dc) skip_empty_results`)
+	f(`lookup hosts by (host) fields (dc) rows ()`)
+	f(`lookup hosts by (host) fields (dc) rows ((h1, dc1))`)
+	f(`lookup hosts by (host) fields (dc, rack) rows ((h1, dc1, r1), (h2, "", "a b"))`)
+	f(`lookup hosts by (host) fields (dc) rows ((h1, dc1)) keep_original_fields`)
+	f(`lookup "foo-bar" by (host)`)
+}
+
+func TestParsePipeLookupFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`lookup`)
+	f(`lookup hosts`)
+	f(`lookup hosts by`)
+	f(`lookup hosts by ()`)
+	f(`lookup hosts by (*)`)
+	f(`lookup hosts by (host) fields`)
+	f(`lookup hosts by (host) fields ()`)
+	f(`lookup hosts by (host) fields (*)`)
+	f(`lookup hosts by (host) rows ((h1, dc1))`)
+	f(`lookup hosts by (host) fields (dc) rows`)
+	f(`lookup hosts by (host) fields (dc) rows (h1, dc1)`)
+	f(`lookup hosts by (host) fields (dc) rows ((h1))`)
+	f(`lookup hosts by (host) fields (dc) rows ((h1, dc1, x))`)
+	f(`lookup hosts by (host) fields (dc) rows ((h1, dc1) (h2, dc2))`)
+}
+
+func TestPipeLookup(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	// single field match
+	f(`lookup hosts by (host) fields (dc, rack) rows ((h1, dc1, r1), (h2, dc2, ""), (h1, dc3, r3))`, [][]Field{
+		{
+			{"_msg", "foo"},
+			{"host", "h1"},
+		},
+		{
+			{"_msg", "bar"},
+			{"host", "h2"},
+			{"rack", "r0"},
+		},
+		{
+			{"_msg", "baz"},
+			{"host", "h3"},
+		},
+		{
+			{"_msg", "abc"},
+		},
+	}, [][]Field{
+		{
+			{"_msg", "foo"},
+			{"host", "h1"},
+			{"dc", "dc1"},
+			{"rack", "r1"},
+		},
+		{
+			{"_msg", "bar"},
+			{"host", "h2"},
+			{"dc", "dc2"},
+			{"rack", ""},
+		},
+		{
+			{"_msg", "baz"},
+			{"host", "h3"},
+		},
+		{
+			{"_msg", "abc"},
+		},
+	})
+
+	// skip_empty_results
+	f(`lookup hosts by (host) fields (dc, rack) rows ((h2, dc2, "")) skip_empty_results`, [][]Field{
+		{
+			{"host", "h2"},
+			{"rack", "r0"},
+		},
+	}, [][]Field{
+		{
+			{"host", "h2"},
+			{"dc", "dc2"},
+			{"rack", "r0"},
+		},
+	})
+
+	// keep_original_fields
+	f(`lookup hosts by (host) fields (dc, rack) rows ((h2, dc2, r2)) keep_original_fields`, [][]Field{
+		{
+			{"host", "h2"},
+			{"rack", "r0"},
+		},
+	}, [][]Field{
+		{
+			{"host", "h2"},
+			{"dc", "dc2"},
+			{"rack", "r0"},
+		},
+	})
+
+	// multiple fields match, including empty values
+	f(`lookup apps by (app, env) fields (owner) rows ((nginx, prod, alice), (nginx, "", bob))`, [][]Field{
+		{
+			{"app", "nginx"},
+			{"env", "prod"},
+		},
+		{
+			{"app", "nginx"},
+		},
+		{
+			{"app", "nginx"},
+			{"env", "dev"},
+		},
+	}, [][]Field{
+		{
+			{"app", "nginx"},
+			{"env", "prod"},
+			{"owner", "alice"},
+		},
+		{
+			{"app", "nginx"},
+			{"owner", "bob"},
+		},
+		{
+			{"app", "nginx"},
+			{"env", "dev"},
+		},
+	})
+}
+
+func TestPipeLookupInitLookupRows(t *testing.T) {
+	defer lookupTables.Store(nil)
+
+	lt, err := ParseLookupTableCSV([]byte("host,dc,rack\nh1,dc1,r1\nh2,\"dc 2\",r2\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	SetLookupTables(map[string]*LookupTable{
+		"hosts": lt,
+	})
+
+	f := func(qStr, resultExpected string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query: %s", err)
+		}
+		qNew, err := initLookupPipes(q, getLookupTable)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := qNew.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+
+		// Verify that the initialized query can be parsed, e.g. at remote storage nodes.
+		qRemote, err := ParseQuery(result)
+		if err != nil {
+			t.Fatalf("cannot parse the initialized query: %s", err)
+		}
+		if s := qRemote.String(); s != result {
+			t.Fatalf("unexpected query after parsing the initialized query\ngot\n%s\nwant\n%s", s, result)
+		}
+	}
+
+	f(`* | lookup hosts by (host)`, `* | lookup hosts by (host) fields (dc, rack) rows ((h1, dc1, r1), (h2, "dc 2", r2))`)
+	f(`* | lookup hosts by (host) fields (rack)`, `* | lookup hosts by (host) fields (rack) rows ((h1, r1), (h2, r2))`)
+	f(`* | lookup hosts by (dc, rack) fields (host)`, `* | lookup hosts by (dc, rack) fields (host) rows ((dc1, r1, h1), ("dc 2", r2, h2))`)
+
+	// already initialized rows are left as is
+	f(`* | lookup hosts by (host) fields (dc) rows ((a, b))`, `* | lookup hosts by (host) fields (dc) rows ((a, b))`)
+
+	fFailure := func(qStr string) {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query: %s", err)
+		}
+		if _, err := initLookupPipes(q, getLookupTable); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// unknown table
+	fFailure(`* | lookup foo by (host)`)
+
+	// unknown columns
+	fFailure(`* | lookup hosts by (foo)`)
+	fFailure(`* | lookup hosts by (host) fields (foo)`)
+}
+
+func TestPipeLookupUpdateNeededFields(t *testing.T) {
+	f := func(s string, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected)
+	}
+
+	// all the needed fields
+	f("lookup hosts by (host, app) fields (dc)", "*", "", "*", "")
+
+	// unneeded fields do not intersect with by fields
+	f("lookup hosts by (host, app) fields (dc)", "*", "f1,f2", "*", "f1,f2")
+
+	// unneeded fields intersect with by fields
+	f("lookup hosts by (host, app) fields (dc)", "*", "f1,host", "*", "f1")
+
+	// needed fields do not intersect with by fields
+	f("lookup hosts by (host, app) fields (dc)", "f1,f2", "", "app,f1,f2,host", "")
+
+	// needed fields intersect with by fields
+	f("lookup hosts by (host, app) fields (dc)", "f1,host", "", "app,f1,host", "")
+}
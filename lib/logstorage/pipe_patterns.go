@@ -0,0 +1,305 @@
+package logstorage
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// pipePatterns processes '| patterns ...' queries.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#patterns-pipe
+type pipePatterns struct {
+	// field is the field to extract patterns from.
+	field string
+
+	// limit is the maximum number of patterns to return. Zero means no limit.
+	limit uint64
+
+	mode pipePatternsMode
+}
+
+type pipePatternsMode int
+
+const (
+	// pipePatternsModeDefault calculates patterns from log messages and returns them.
+	pipePatternsModeDefault = pipePatternsMode(0)
+
+	// pipePatternsModeRemote calculates patterns from log messages at vlstorage and returns them without limit,
+	// so they can be merged by pipePatternsModeLocal at vlselect.
+	pipePatternsModeRemote = pipePatternsMode(1)
+
+	// pipePatternsModeLocal merges patterns obtained from pipePatternsModeRemote.
+	pipePatternsModeLocal = pipePatternsMode(2)
+)
+
+// The names of the fields returned by pipePatterns.
+const (
+	pipePatternsPatternField = "pattern"
+	pipePatternsSampleField  = "sample"
+	pipePatternsHitsField    = "hits"
+)
+
+func (pp *pipePatterns) String() string {
+	s := ""
+	switch pp.mode {
+	case pipePatternsModeDefault:
+		s = "patterns"
+	case pipePatternsModeRemote:
+		s = "patterns_remote"
+	case pipePatternsModeLocal:
+		s = "patterns_local"
+	default:
+		logger.Panicf("BUG: unknown mode: %d", pp.mode)
+	}
+
+	if pp.mode != pipePatternsModeLocal && pp.field != "_msg" {
+		s += " from " + quoteTokenIfNeeded(pp.field)
+	}
+	if pp.mode != pipePatternsModeRemote && pp.limit > 0 {
+		s += fmt.Sprintf(" limit %d", pp.limit)
+	}
+	return s
+}
+
+func (pp *pipePatterns) splitToRemoteAndLocal(_ int64) (pipe, []pipe) {
+	if pp.mode == pipePatternsModeLocal {
+		logger.Panicf("BUG: %s cannot be split", pp)
+	}
+
+	ppRemote := *pp
+	ppRemote.mode = pipePatternsModeRemote
+	ppRemote.limit = 0
+
+	// patterns_local without limit returns all the merged patterns, so it can be used as a proxy
+	// between patterns_remote pipes when patterns_remote is split.
+	ppLocal := *pp
+	ppLocal.mode = pipePatternsModeLocal
+
+	return &ppRemote, []pipe{&ppLocal}
+}
+
+func (pp *pipePatterns) canLiveTail() bool {
+	return false
+}
+
+func (pp *pipePatterns) canReturnLastNResults() bool {
+	return false
+}
+
+func (pp *pipePatterns) updateNeededFields(pf *prefixfilter.Filter) {
+	pf.Reset()
+
+	if pp.mode == pipePatternsModeLocal {
+		pf.AddAllowFilter(pipePatternsPatternField)
+		pf.AddAllowFilter(pipePatternsSampleField)
+		pf.AddAllowFilter(pipePatternsHitsField)
+		return
+	}
+	pf.AddAllowFilter(pp.field)
+}
+
+func (pp *pipePatterns) hasFilterInWithQuery() bool {
+	return false
+}
+
+func (pp *pipePatterns) initFilterInValues(_ *inValuesCache, _ getFieldValuesFunc, _ bool) (pipe, error) {
+	return pp, nil
+}
+
+func (pp *pipePatterns) visitSubqueries(_ func(q *Query)) {
+	// nothing to do
+}
+
+func (pp *pipePatterns) newPipeProcessor(_ int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
+	maxStateSize := int64(float64(memory.Allowed()) * 0.4)
+
+	ppp := &pipePatternsProcessor{
+		pp:     pp,
+		stopCh: stopCh,
+		cancel: cancel,
+		ppNext: ppNext,
+	}
+	ppp.initStateSizeLimit(maxStateSize)
+
+	return ppp
+}
+
+type pipePatternsProcessor struct {
+	pp     *pipePatterns
+	stopCh <-chan struct{}
+	cancel func()
+	ppNext pipeProcessor
+
+	shards atomicutil.Slice[pipePatternsProcessorShard]
+
+	errOnce atomic.Bool
+	err     error
+
+	stateSizeLimit
+}
+
+type pipePatternsProcessorShard struct {
+	pt patternsTree
+
+	// stateSizeBudget is the remaining budget for the whole state size for the shard.
+	// The per-shard budget is provided in chunks from the parent pipePatternsProcessor.
+	stateSizeBudget int
+}
+
+func (ppp *pipePatternsProcessor) writeBlock(workerID uint, br *blockResult) {
+	if br.rowsLen == 0 {
+		return
+	}
+
+	shard := ppp.shards.Get(workerID)
+
+	for shard.stateSizeBudget < 0 {
+		// steal some budget for the state size from the global budget.
+		remaining := ppp.stateSizeBudget.Add(-stateSizeBudgetChunk)
+		if remaining < 0 {
+			// The state size is too big. Stop processing data in order to avoid OOM crash.
+			if remaining+stateSizeBudgetChunk >= 0 {
+				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+				ppp.cancel()
+			}
+			return
+		}
+		shard.stateSizeBudget += stateSizeBudgetChunk
+	}
+
+	if ppp.pp.mode == pipePatternsModeLocal {
+		ppp.writeBlockLocal(shard, br)
+		return
+	}
+
+	c := br.getColumnByName(ppp.pp.field)
+	if c.isConst {
+		v := c.valuesEncoded[0]
+		shard.stateSizeBudget -= shard.pt.addMessage(v, uint64(br.rowsLen))
+		return
+	}
+	if c.valueType == valueTypeDict {
+		c.forEachDictValueWithHits(br, func(v string, hits uint64) {
+			shard.stateSizeBudget -= shard.pt.addMessage(v, hits)
+		})
+		return
+	}
+
+	values := c.getValues(br)
+	hits := uint64(1)
+	for rowIdx := 1; rowIdx < len(values); rowIdx++ {
+		if values[rowIdx-1] == values[rowIdx] {
+			hits++
+			continue
+		}
+		shard.stateSizeBudget -= shard.pt.addMessage(values[rowIdx-1], hits)
+		hits = 1
+	}
+	shard.stateSizeBudget -= shard.pt.addMessage(values[len(values)-1], hits)
+}
+
+// writeBlockLocal merges patterns returned by pipePatternsModeRemote into shard.
+func (ppp *pipePatternsProcessor) writeBlockLocal(shard *pipePatternsProcessorShard, br *blockResult) {
+	patterns := br.getColumnByName(pipePatternsPatternField).getValues(br)
+	samples := br.getColumnByName(pipePatternsSampleField).getValues(br)
+	hits := br.getColumnByName(pipePatternsHitsField).getValues(br)
+
+	for rowIdx := range patterns {
+		n, ok := tryParseUint64(hits[rowIdx])
+		if !ok {
+			ppp.setError(fmt.Errorf("unexpected hits received from the remote storage at the %q field: %q; it must be uint64", pipePatternsHitsField, hits[rowIdx]))
+			return
+		}
+		shard.stateSizeBudget -= shard.pt.addPattern(patterns[rowIdx], samples[rowIdx], n)
+	}
+}
+
+func (ppp *pipePatternsProcessor) setError(err error) {
+	if ppp.errOnce.CompareAndSwap(false, true) {
+		ppp.err = err
+		ppp.cancel()
+	}
+}
+
+func (ppp *pipePatternsProcessor) flush() error {
+	if ppp.err != nil {
+		return ppp.err
+	}
+	if n := ppp.stateSizeBudget.Load(); n <= 0 {
+		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", ppp.pp.String(), ppp.maxStateSize/(1<<20))
+	}
+
+	// Merge patterns across shards.
+	shards := ppp.shards.All()
+	if len(shards) == 0 {
+		return nil
+	}
+	pt := &shards[0].pt
+	for _, shard := range shards[1:] {
+		if needStop(ppp.stopCh) {
+			return nil
+		}
+		pt.mergeState(&shard.pt, ppp.stopCh)
+	}
+
+	// Write the resulting patterns to the next pipe.
+	// patterns_remote has zero limit, so it returns all the patterns for merging them with patterns from other storage nodes.
+	pcs := pt.getTopClusters(ppp.pp.limit)
+
+	wctx := newPipeFixedFieldsWriteContext(ppp.ppNext, []string{pipePatternsPatternField, pipePatternsSampleField, pipePatternsHitsField})
+	rowValues := make([]string, 3)
+	for _, pc := range pcs {
+		if needStop(ppp.stopCh) {
+			return nil
+		}
+		rowValues[0] = pc.pattern()
+		rowValues[1] = pc.sample
+		rowValues[2] = string(marshalUint64String(nil, pc.hits))
+		wctx.writeRow(rowValues)
+	}
+	wctx.flush()
+
+	return nil
+}
+
+func parsePipePatterns(lex *lexer) (pipe, error) {
+	mode := pipePatternsModeDefault
+	switch {
+	case lex.isKeyword("patterns"):
+	case lex.isKeyword("patterns_remote"):
+		mode = pipePatternsModeRemote
+	default:
+		return nil, fmt.Errorf("expecting 'patterns' or 'patterns_remote'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	pp := &pipePatterns{
+		field: "_msg",
+		mode:  mode,
+	}
+
+	if lex.isKeyword("from") {
+		lex.nextToken()
+		field, err := parseFieldName(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'from' field name: %w", err)
+		}
+		pp.field = field
+	}
+
+	if lex.isKeyword("limit") && mode == pipePatternsModeDefault {
+		n, err := parseLimit(lex)
+		if err != nil {
+			return nil, err
+		}
+		pp.limit = n
+	}
+
+	return pp, nil
+}
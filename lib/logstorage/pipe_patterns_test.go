@@ -0,0 +1,180 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipePatternsSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`patterns`)
+	f(`patterns limit 10`)
+	f(`patterns from x`)
+	f(`patterns from x limit 10`)
+	f(`patterns_remote`)
+	f(`patterns_remote from x`)
+}
+
+func TestParsePipePatternsFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`patterns from`)
+	f(`patterns from (x)`)
+	f(`patterns limit`)
+	f(`patterns limit foo`)
+	f(`patterns limit -1`)
+	f(`patterns_remote limit 10`)
+	f(`patterns foo`)
+}
+
+func TestPipePatterns(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	rows := [][]Field{
+		{
+			{"_msg", "user alice logged in from 10.0.0.1"},
+			{"host", "a"},
+		},
+		{
+			{"_msg", "user bob logged in from 10.0.0.2"},
+			{"host", "b"},
+		},
+		{
+			{"_msg", "user bob logged in from 10.0.0.2"},
+			{"host", "b"},
+		},
+		{
+			{"_msg", "connection closed"},
+			{"host", "a"},
+		},
+	}
+
+	f(`patterns`, rows, [][]Field{
+		{
+			{"pattern", "user <*> logged in from <*>"},
+			{"sample", "user alice logged in from 10.0.0.1"},
+			{"hits", "3"},
+		},
+		{
+			{"pattern", "connection closed"},
+			{"sample", "connection closed"},
+			{"hits", "1"},
+		},
+	})
+
+	// limit
+	f(`patterns limit 1`, rows, [][]Field{
+		{
+			{"pattern", "user <*> logged in from <*>"},
+			{"sample", "user alice logged in from 10.0.0.1"},
+			{"hits", "3"},
+		},
+	})
+
+	// from field
+	f(`patterns from host`, rows, [][]Field{
+		{
+			{"pattern", "a"},
+			{"sample", "a"},
+			{"hits", "2"},
+		},
+		{
+			{"pattern", "b"},
+			{"sample", "b"},
+			{"hits", "2"},
+		},
+	})
+
+	// missing field
+	f(`patterns from x`, rows, [][]Field{
+		{
+			{"pattern", ""},
+			{"sample", ""},
+			{"hits", "4"},
+		},
+	})
+}
+
+func TestPipePatternsRemoteAndLocal(t *testing.T) {
+	// Patterns calculated at multiple storage nodes must be merged into the same patterns.
+	pp := &pipePatterns{
+		field: "_msg",
+		limit: 10,
+	}
+	ppRemote, ppsLocal := pp.splitToRemoteAndLocal(0)
+	if len(ppsLocal) != 1 {
+		t.Fatalf("unexpected number of local pipes; got %d; want 1", len(ppsLocal))
+	}
+
+	stopCh := make(chan struct{})
+	cancel := func() {}
+	ppTest := newTestPipeProcessor()
+	pLocal := ppsLocal[0].newPipeProcessor(1, stopCh, cancel, ppTest)
+
+	nodeRows := [][]string{
+		{
+			"request GET /api/users status 200",
+			"request GET /api/users status 200",
+			"disk is full",
+		},
+		{
+			"request GET /api/orders status 500",
+			"disk is full",
+		},
+	}
+	for _, msgs := range nodeRows {
+		pRemote := ppRemote.newPipeProcessor(1, stopCh, cancel, pLocal)
+		brw := newTestBlockResultWriter(1, pRemote)
+		for _, msg := range msgs {
+			brw.writeRow([]Field{
+				{"_msg", msg},
+			})
+		}
+		brw.flush()
+		if err := pRemote.flush(); err != nil {
+			t.Fatalf("unexpected error at remote pipe: %s", err)
+		}
+	}
+	if err := pLocal.flush(); err != nil {
+		t.Fatalf("unexpected error at local pipe: %s", err)
+	}
+
+	ppTest.expectRows(t, [][]Field{
+		{
+			{"pattern", "request GET <*> status <*>"},
+			{"sample", "request GET /api/orders status 500"},
+			{"hits", "3"},
+		},
+		{
+			{"pattern", "disk is full"},
+			{"sample", "disk is full"},
+			{"hits", "2"},
+		},
+	})
+}
+
+func TestPipePatternsUpdateNeededFields(t *testing.T) {
+	f := func(s, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected)
+	}
+
+	// all the needed fields
+	f("patterns", "*", "", "_msg", "")
+	f("patterns from x", "*", "", "x", "")
+
+	// unneeded fields
+	f("patterns from x", "*", "x,y", "x", "")
+
+	// needed fields do not intersect with the source field
+	f("patterns from x", "pattern,hits", "", "x", "")
+}
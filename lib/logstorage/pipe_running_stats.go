@@ -5,7 +5,6 @@ import (
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
@@ -134,11 +133,9 @@ func (ps *pipeRunningStats) newPipeProcessor(_ int, stopCh <-chan struct{}, canc
 		stopCh: stopCh,
 		cancel: cancel,
 		ppNext: ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 
-	psp.stateSizeBudget.Store(maxStateSize)
+	psp.initStateSizeLimit(maxStateSize)
 
 	return psp
 }
@@ -151,8 +148,7 @@ type pipeRunningStatsProcessor struct {
 
 	shards atomicutil.Slice[pipeRunningStatsProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
 }
 
 type pipeRunningStatsProcessorShard struct {
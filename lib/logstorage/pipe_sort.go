@@ -8,7 +8,6 @@ import (
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
@@ -153,13 +152,15 @@ func newPipeSortProcessor(ps *pipeSort, stopCh <-chan struct{}, cancel func(), p
 		stopCh: stopCh,
 		cancel: cancel,
 		ppNext: ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	psp.shards.Init = func(shard *pipeSortProcessorShard) {
 		shard.ps = ps
 	}
-	psp.stateSizeBudget.Store(maxStateSize)
+	psp.initStateSizeLimit(maxStateSize)
+
+	if getQueryTempDir() != "" {
+		psp.spill = &pipeSortSpill{}
+	}
 
 	return psp
 }
@@ -172,8 +173,12 @@ type pipeSortProcessor struct {
 
 	shards atomicutil.Slice[pipeSortProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
+
+	// spill is used for spilling sorted runs to disk when the state doesn't fit the memory limit.
+	//
+	// spill is nil if spilling to disk is disabled.
+	spill *pipeSortSpill
 }
 
 type pipeSortProcessorShard struct {
@@ -195,6 +200,11 @@ type pipeSortProcessorShard struct {
 	// The per-shard budget is provided in chunks from the parent pipeSortProcessor.
 	stateSizeBudget int
 
+	// stateSizeBudgetTaken is the budget taken by the shard from the parent pipeSortProcessor.
+	//
+	// It is returned to the parent when the shard state is spilled to disk.
+	stateSizeBudgetTaken int
+
 	// columnValues is used as temporary buffer at pipeSortProcessorShard.writeBlock
 	columnValues [][]string
 }
@@ -434,21 +444,41 @@ func (psp *pipeSortProcessor) writeBlock(workerID uint, br *blockResult) {
 		// steal some budget for the state size from the global budget.
 		remaining := psp.stateSizeBudget.Add(-stateSizeBudgetChunk)
 		if remaining < 0 {
-			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				psp.cancel()
+			if psp.spill != nil && shard.stateSizeBudgetTaken > 0 {
+				// Spill the shard state to disk and return its budget to the global budget.
+				psp.spill.spillShard(shard)
+				psp.stateSizeBudget.Add(int64(shard.stateSizeBudgetTaken))
+				shard.stateSizeBudgetTaken = 0
+				shard.stateSizeBudget = 0
+			} else if psp.spill == nil {
+				// The state size is too big. Stop processing data in order to avoid OOM crash.
+				if remaining+stateSizeBudgetChunk >= 0 {
+					// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+					psp.cancel()
+				}
+				return
 			}
-			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
+		shard.stateSizeBudgetTaken += stateSizeBudgetChunk
 	}
 
 	shard.writeBlock(br)
 }
 
+// isStateSizeExceeded implements stateSizeLimiter interface.
+//
+// The state size is never exceeded when spilling to disk is enabled, since the state is spilled to disk instead.
+func (psp *pipeSortProcessor) isStateSizeExceeded() bool {
+	return psp.spill == nil && psp.stateSizeLimit.isStateSizeExceeded()
+}
+
 func (psp *pipeSortProcessor) flush() error {
-	if n := psp.stateSizeBudget.Load(); n <= 0 {
+	if psp.spill != nil && psp.spill.hasRuns() {
+		return psp.flushSpilled()
+	}
+
+	if n := psp.stateSizeBudget.Load(); psp.spill == nil && n <= 0 {
 		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", psp.ps.String(), psp.maxStateSize/(1<<20))
 	}
 
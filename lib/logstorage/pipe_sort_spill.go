@@ -0,0 +1,485 @@
+package logstorage
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/stringsutil"
+)
+
+// pipeSortSpill holds sorted runs of rows spilled to a temporary file by `sort` pipe
+// when the collected rows do not fit the memory limit.
+type pipeSortSpill struct {
+	mu sync.Mutex
+
+	// sf is the temporary file with sorted runs. It is created on the first spill.
+	sf *spillFile
+
+	// runs contains byte ranges for sorted runs at sf.
+	runs []pipeSortSpillRun
+
+	// err is the first error occurred during spilling.
+	err error
+}
+
+type pipeSortSpillRun struct {
+	start int64
+	end   int64
+}
+
+func (sps *pipeSortSpill) hasRuns() bool {
+	sps.mu.Lock()
+	defer sps.mu.Unlock()
+
+	return len(sps.runs) > 0 || sps.err != nil
+}
+
+// spillShard sorts the rows collected by shard, writes them as a sorted run to the temporary file and resets the shard.
+func (sps *pipeSortSpill) spillShard(shard *pipeSortProcessorShard) {
+	if len(shard.rowRefs) == 0 {
+		return
+	}
+
+	sort.Sort(shard)
+
+	sps.mu.Lock()
+	defer sps.mu.Unlock()
+
+	if err := sps.writeRunLocked(shard); err != nil && sps.err == nil {
+		sps.err = err
+	}
+
+	shard.blocks = nil
+	shard.rowRefs = nil
+	shard.rowRefNext = 0
+}
+
+func (sps *pipeSortSpill) writeRunLocked(shard *pipeSortProcessorShard) error {
+	if sps.err != nil {
+		return nil
+	}
+	if sps.sf == nil {
+		sf, err := newSpillFile()
+		if err != nil {
+			return err
+		}
+		sps.sf = sf
+	}
+
+	sf := sps.sf
+	start := sf.size
+	bb := bbPool.Get()
+	for i := range shard.rowRefs {
+		bb.B = shard.marshalSpillRow(bb.B[:0], i)
+		if err := sf.writeRecord(bb.B); err != nil {
+			bbPool.Put(bb)
+			return err
+		}
+	}
+	bbPool.Put(bb)
+
+	sps.runs = append(sps.runs, pipeSortSpillRun{
+		start: start,
+		end:   sf.size,
+	})
+	return nil
+}
+
+func (sps *pipeSortSpill) mustClose() {
+	if sps.sf != nil {
+		sps.sf.mustClose()
+		sps.sf = nil
+	}
+	sps.runs = nil
+}
+
+// marshalSpillRow appends the row at rowRefs[idx] to dst and returns the result.
+func (shard *pipeSortProcessorShard) marshalSpillRow(dst []byte, idx int) []byte {
+	rr := shard.rowRefs[idx]
+	b := &shard.blocks[rr.blockIdx]
+	br := b.br
+
+	for i := range b.byColumns {
+		bc := &b.byColumns[i]
+		if bc.c.isTime {
+			timestamps := br.getTimestamps()
+			dst = append(dst, 1)
+			dst = encoding.MarshalVarInt64(dst, timestamps[rr.rowIdx])
+		} else {
+			dst = append(dst, 0)
+			dst = encoding.MarshalVarInt64(dst, bc.getI64ValueAtRow(rr.rowIdx))
+			dst = encoding.MarshalUint64(dst, math.Float64bits(bc.getF64ValueAtRow(rr.rowIdx)))
+		}
+		v := bc.c.getValueAtRow(br, rr.rowIdx)
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(v))
+	}
+
+	dst = encoding.MarshalVarUint64(dst, uint64(len(b.otherColumns)))
+	for _, c := range b.otherColumns {
+		v := c.getValueAtRow(br, rr.rowIdx)
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(c.name))
+		dst = encoding.MarshalBytes(dst, bytesutil.ToUnsafeBytes(v))
+	}
+
+	return dst
+}
+
+// pipeSortSpillRow is a row read from pipeSortSpill.
+type pipeSortSpillRow struct {
+	byColumns    []pipeSortSpillValue
+	otherColumns []Field
+}
+
+// pipeSortSpillValue is a value for 'by(...)' column at pipeSortSpillRow.
+type pipeSortSpillValue struct {
+	isTime    bool
+	timestamp int64
+
+	i64 int64
+	f64 float64
+
+	value string
+}
+
+func (row *pipeSortSpillRow) unmarshal(src []byte, byColumnsLen int) error {
+	row.byColumns = make([]pipeSortSpillValue, byColumnsLen)
+	for i := range row.byColumns {
+		v := &row.byColumns[i]
+		if len(src) < 1 {
+			return fmt.Errorf("cannot read column type")
+		}
+		v.isTime = src[0] == 1
+		src = src[1:]
+
+		if v.isTime {
+			ts, n := encoding.UnmarshalVarInt64(src)
+			if n <= 0 {
+				return fmt.Errorf("cannot read timestamp")
+			}
+			src = src[n:]
+			v.timestamp = ts
+		} else {
+			i64, n := encoding.UnmarshalVarInt64(src)
+			if n <= 0 {
+				return fmt.Errorf("cannot read int64 value")
+			}
+			src = src[n:]
+			v.i64 = i64
+
+			if len(src) < 8 {
+				return fmt.Errorf("cannot read float64 value")
+			}
+			v.f64 = math.Float64frombits(encoding.UnmarshalUint64(src))
+			src = src[8:]
+		}
+
+		data, n := encoding.UnmarshalBytes(src)
+		if n <= 0 {
+			return fmt.Errorf("cannot read value")
+		}
+		src = src[n:]
+		v.value = string(data)
+	}
+
+	fieldsLen, n := encoding.UnmarshalVarUint64(src)
+	if n <= 0 {
+		return fmt.Errorf("cannot read the number of fields")
+	}
+	src = src[n:]
+	if fieldsLen > uint64(len(src)) {
+		return fmt.Errorf("too big number of fields: %d", fieldsLen)
+	}
+
+	row.otherColumns = make([]Field, fieldsLen)
+	for i := range row.otherColumns {
+		name, n := encoding.UnmarshalBytes(src)
+		if n <= 0 {
+			return fmt.Errorf("cannot read field name")
+		}
+		src = src[n:]
+
+		value, n := encoding.UnmarshalBytes(src)
+		if n <= 0 {
+			return fmt.Errorf("cannot read field value")
+		}
+		src = src[n:]
+
+		row.otherColumns[i] = Field{
+			Name:  string(name),
+			Value: string(value),
+		}
+	}
+
+	if len(src) > 0 {
+		return fmt.Errorf("unexpected tail left after reading the row; len(tail)=%d", len(src))
+	}
+	return nil
+}
+
+// pipeSortSpillRunReader reads rows from a single sorted run.
+type pipeSortSpillRunReader struct {
+	sr *spillReader
+
+	byColumnsLen int
+
+	// row is the current row
+	row pipeSortSpillRow
+}
+
+// next reads the next row into psr.row. It returns false if there are no more rows.
+func (psr *pipeSortSpillRunReader) next() (bool, error) {
+	data, err := psr.sr.readRecord()
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := psr.row.unmarshal(data, psr.byColumnsLen); err != nil {
+		return false, fmt.Errorf("cannot unmarshal row from temporary file %q: %w", psr.sr.path, err)
+	}
+	return true, nil
+}
+
+type pipeSortSpillRunReadersHeap struct {
+	ps      *pipeSort
+	readers []*pipeSortSpillRunReader
+}
+
+func (h *pipeSortSpillRunReadersHeap) Len() int {
+	return len(h.readers)
+}
+
+func (h *pipeSortSpillRunReadersHeap) Swap(i, j int) {
+	a := h.readers
+	a[i], a[j] = a[j], a[i]
+}
+
+func (h *pipeSortSpillRunReadersHeap) Less(i, j int) bool {
+	return sortSpillRowLess(h.ps, &h.readers[i].row, &h.readers[j].row)
+}
+
+func (h *pipeSortSpillRunReadersHeap) Push(x any) {
+	h.readers = append(h.readers, x.(*pipeSortSpillRunReader))
+}
+
+func (h *pipeSortSpillRunReadersHeap) Pop() any {
+	a := h.readers
+	x := a[len(a)-1]
+	a[len(a)-1] = nil
+	h.readers = a[:len(a)-1]
+	return x
+}
+
+// sortSpillRowLess returns true if rowA is smaller than rowB.
+//
+// It must be consistent with sortBlockLess.
+func sortSpillRowLess(ps *pipeSort, rowA, rowB *pipeSortSpillRow) bool {
+	byFields := ps.byFields
+
+	for idx := range rowA.byColumns {
+		cA := &rowA.byColumns[idx]
+		cB := &rowB.byColumns[idx]
+		isDesc := len(byFields) > 0 && byFields[idx].isDesc
+		if ps.isDesc {
+			isDesc = !isDesc
+		}
+
+		if cA.isTime && cB.isTime {
+			tA := cA.timestamp
+			tB := cB.timestamp
+			if tA == tB {
+				continue
+			}
+			if isDesc {
+				return tB < tA
+			}
+			return tA < tB
+		}
+		if cA.isTime {
+			// treat timestamps as smaller than other values
+			return true
+		}
+		if cB.isTime {
+			// treat timestamps as smaller than other values
+			return false
+		}
+
+		uA := cA.i64
+		uB := cB.i64
+		if uA != 0 && uB != 0 {
+			if uA == uB {
+				continue
+			}
+			if isDesc {
+				return uB < uA
+			}
+			return uA < uB
+		}
+
+		fA := cA.f64
+		fB := cB.f64
+		if !math.IsNaN(fA) && !math.IsNaN(fB) {
+			if fA == fB {
+				continue
+			}
+			if isDesc {
+				return fB < fA
+			}
+			return fA < fB
+		}
+
+		sA := cA.value
+		sB := cB.value
+		if sA == sB {
+			continue
+		}
+		if isDesc {
+			sA, sB = sB, sA
+		}
+		return stringsutil.LessNatural(sA, sB)
+	}
+	return false
+}
+
+// flushSpilled spills the remaining rows from all the shards to disk and then merges all the sorted runs into the output.
+func (psp *pipeSortProcessor) flushSpilled() error {
+	sps := psp.spill
+	defer sps.mustClose()
+
+	shards := psp.shards.All()
+	for _, shard := range shards {
+		if needStop(psp.stopCh) {
+			return nil
+		}
+		sps.spillShard(shard)
+	}
+	if sps.err != nil {
+		return fmt.Errorf("cannot spill the state of [%s] to disk: %w", psp.ps.String(), sps.err)
+	}
+	if err := sps.sf.flush(); err != nil {
+		return fmt.Errorf("cannot spill the state of [%s] to disk: %w", psp.ps.String(), err)
+	}
+
+	byColumnsLen := len(psp.ps.byFields)
+	if byColumnsLen == 0 {
+		byColumnsLen = 1
+	}
+
+	h := &pipeSortSpillRunReadersHeap{
+		ps: psp.ps,
+	}
+	for _, run := range sps.runs {
+		psr := &pipeSortSpillRunReader{
+			sr:           sps.sf.newReader(run.start, run.end),
+			byColumnsLen: byColumnsLen,
+		}
+		ok, err := psr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.readers = append(h.readers, psr)
+		}
+	}
+	heap.Init(h)
+
+	wctx := &pipeSortWriteContext{
+		psp: psp,
+	}
+	rowsProcessed := 0
+	for len(h.readers) > 0 {
+		psr := h.readers[0]
+		wctx.writeSpillRow(&psr.row)
+
+		ok, err := psr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+
+		rowsProcessed++
+		if rowsProcessed%1000 == 0 && needStop(psp.stopCh) {
+			return nil
+		}
+	}
+	wctx.flush()
+
+	return nil
+}
+
+func (wctx *pipeSortWriteContext) writeSpillRow(row *pipeSortSpillRow) {
+	ps := wctx.psp.ps
+	rankFieldName := ps.rankFieldName
+	rankFields := 0
+	if rankFieldName != "" {
+		rankFields = 1
+	}
+
+	wctx.rowsWritten++
+	if wctx.rowsWritten <= ps.offset {
+		return
+	}
+
+	byFields := ps.byFields
+	rcs := wctx.rcs
+
+	areEqualColumns := len(rcs) == rankFields+len(byFields)+len(row.otherColumns)
+	if areEqualColumns {
+		for i, f := range row.otherColumns {
+			if rcs[rankFields+len(byFields)+i].name != f.Name {
+				areEqualColumns = false
+				break
+			}
+		}
+	}
+	if !areEqualColumns {
+		// send the current block to ppNext and construct a block with new set of columns
+		wctx.flush()
+
+		rcs = wctx.rcs[:0]
+		if rankFieldName != "" {
+			rcs = appendResultColumnWithName(rcs, rankFieldName)
+		}
+		for _, bf := range byFields {
+			rcs = appendResultColumnWithName(rcs, bf.name)
+		}
+		for _, f := range row.otherColumns {
+			rcs = appendResultColumnWithName(rcs, f.Name)
+		}
+		wctx.rcs = rcs
+	}
+
+	if rankFieldName != "" {
+		bufLen := len(wctx.buf)
+		wctx.buf = marshalUint64String(wctx.buf, wctx.rowsWritten)
+		v := bytesutil.ToUnsafeString(wctx.buf[bufLen:])
+		rcs[0].addValue(v)
+	}
+
+	for i := range byFields {
+		v := row.byColumns[i].value
+		rcs[rankFields+i].addValue(v)
+		wctx.valuesLen += len(v)
+	}
+
+	for i, f := range row.otherColumns {
+		rcs[rankFields+len(byFields)+i].addValue(f.Value)
+		wctx.valuesLen += len(f.Value)
+	}
+
+	wctx.rowsCount++
+	if wctx.valuesLen >= 1_000_000 {
+		wctx.flush()
+	}
+}
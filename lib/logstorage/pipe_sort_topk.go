@@ -7,7 +7,6 @@ import (
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
@@ -26,13 +25,11 @@ func newPipeTopkProcessor(ps *pipeSort, stopCh <-chan struct{}, cancel func(), p
 		stopCh: stopCh,
 		cancel: cancel,
 		ppNext: ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	ptp.shards.Init = func(shard *pipeTopkProcessorShard) {
 		shard.ps = ps
 	}
-	ptp.stateSizeBudget.Store(maxStateSize)
+	ptp.initStateSizeLimit(maxStateSize)
 
 	return ptp
 }
@@ -45,8 +42,7 @@ type pipeTopkProcessor struct {
 
 	shards atomicutil.Slice[pipeTopkProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
 }
 
 type pipeTopkProcessorShard struct {
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
@@ -324,21 +323,31 @@ const stateSizeBudgetChunk = 1 << 20
 func (ps *pipeStats) newPipeProcessor(concurrency int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
 	maxStateSize := int64(float64(memory.Allowed()) * 0.4)
 
+	psp := newPipeStatsProcessor(ps, concurrency, stopCh, cancel, ppNext, maxStateSize)
+	if getQueryTempDir() != "" {
+		psp.spill = &pipeStatsSpill{
+			psp: psp,
+		}
+	}
+
+	return psp
+}
+
+// newPipeStatsProcessor returns new processor for ps, which keeps its state in memory.
+func newPipeStatsProcessor(ps *pipeStats, concurrency int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor, maxStateSize int64) *pipeStatsProcessor {
 	psp := &pipeStatsProcessor{
 		ps:          ps,
 		concurrency: concurrency,
 		stopCh:      stopCh,
 		cancel:      cancel,
 		ppNext:      ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	psp.shards.Init = func(shard *pipeStatsProcessorShard) {
 		shard.psp = psp
 		shard.init()
 	}
 
-	psp.stateSizeBudget.Store(maxStateSize)
+	psp.initStateSizeLimit(maxStateSize)
 
 	return psp
 }
@@ -352,8 +361,15 @@ type pipeStatsProcessor struct {
 
 	shards atomicutil.Slice[pipeStatsProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
+
+	// spill is used for spilling partially aggregated groups to disk when the state doesn't fit the memory limit.
+	//
+	// spill is nil if spilling to disk is disabled.
+	spill *pipeStatsSpill
+
+	// mergeImportedState must be set to true if the imported state for the same group may be passed multiple times to the same shard.
+	mergeImportedState bool
 
 	errLock sync.Mutex
 	err     error
@@ -382,6 +398,11 @@ type pipeStatsProcessorShard struct {
 	keyBuf       []byte
 
 	stateSizeBudget int
+
+	// stateSizeBudgetTaken is the budget taken by the shard from the parent pipeStatsProcessor.
+	//
+	// It is returned to the parent when the shard state is spilled to disk.
+	stateSizeBudgetTaken int
 }
 
 type pipeStatsGroupMapShard struct {
@@ -658,7 +679,7 @@ func (shard *pipeStatsProcessorShard) writeBlockLocal(br *blockResult) {
 			return
 		}
 		psg := shard.getPipeStatsGroupString(nil)
-		stateSize, err := psg.importStateFromRow(columnValues, 0, stopCh)
+		stateSize, err := shard.importStateFromRow(psg, columnValues, 0)
 		if err != nil {
 			shard.psp.setError(err)
 			return
@@ -670,7 +691,7 @@ func (shard *pipeStatsProcessorShard) writeBlockLocal(br *blockResult) {
 		for rowIdx := 0; rowIdx < br.rowsLen; rowIdx++ {
 			v := byFieldValues[0][rowIdx]
 			psg := shard.getPipeStatsGroupGeneric(v)
-			stateSize, err := psg.importStateFromRow(columnValues, rowIdx, stopCh)
+			stateSize, err := shard.importStateFromRow(psg, columnValues, rowIdx)
 			if err != nil {
 				shard.psp.setError(err)
 				return
@@ -691,7 +712,7 @@ func (shard *pipeStatsProcessorShard) writeBlockLocal(br *blockResult) {
 			keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(values[rowIdx]))
 		}
 		psg := shard.getPipeStatsGroupString(keyBuf)
-		stateSize, err := psg.importStateFromRow(columnValues, rowIdx, stopCh)
+		stateSize, err := shard.importStateFromRow(psg, columnValues, rowIdx)
 		if err != nil {
 			shard.psp.setError(err)
 			return
@@ -705,6 +726,23 @@ func (shard *pipeStatsProcessorShard) writeBlockLocal(br *blockResult) {
 	shard.keyBuf = keyBuf
 }
 
+// importStateFromRow imports the state from the row at rowIdx into psg.
+func (shard *pipeStatsProcessorShard) importStateFromRow(psg *pipeStatsGroup, columnValues [][]string, rowIdx int) (int, error) {
+	stopCh := shard.psp.stopCh
+	if !shard.psp.mergeImportedState {
+		return psg.importStateFromRow(columnValues, rowIdx, stopCh)
+	}
+
+	// The state for the same group may be imported multiple times, so merge it into psg.
+	psgImported := shard.newPipeStatsGroup()
+	stateSize, err := psgImported.importStateFromRow(columnValues, rowIdx, stopCh)
+	if err != nil {
+		return 0, err
+	}
+	psg.mergeState(&shard.a, psgImported)
+	return stateSize, nil
+}
+
 func (shard *pipeStatsProcessorShard) updateStatsSingleColumn(br *blockResult, bf *byStatsField) {
 	c := br.getColumnByName(bf.name)
 	if c.isConst {
@@ -1001,14 +1039,23 @@ func (psp *pipeStatsProcessor) writeBlock(workerID uint, br *blockResult) {
 		// steal some budget for the state size from the global budget.
 		remaining := psp.stateSizeBudget.Add(-stateSizeBudgetChunk)
 		if remaining < 0 {
-			// The state size is too big. Stop processing data in order to avoid OOM crash.
-			if remaining+stateSizeBudgetChunk >= 0 {
-				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
-				psp.cancel()
+			if psp.spill != nil && shard.stateSizeBudgetTaken > 0 {
+				// Spill the shard state to disk and return its budget to the global budget.
+				psp.spill.spillShard(shard)
+				psp.stateSizeBudget.Add(int64(shard.stateSizeBudgetTaken))
+				shard.stateSizeBudgetTaken = 0
+				shard.stateSizeBudget = 0
+			} else if psp.spill == nil {
+				// The state size is too big. Stop processing data in order to avoid OOM crash.
+				if remaining+stateSizeBudgetChunk >= 0 {
+					// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+					psp.cancel()
+				}
+				return
 			}
-			return
 		}
 		shard.stateSizeBudget += stateSizeBudgetChunk
+		shard.stateSizeBudgetTaken += stateSizeBudgetChunk
 	}
 
 	if psp.ps.mode.needImportState() {
@@ -1018,12 +1065,28 @@ func (psp *pipeStatsProcessor) writeBlock(workerID uint, br *blockResult) {
 	}
 }
 
+// isStateSizeExceeded implements stateSizeLimiter interface.
+//
+// The state size is never exceeded when spilling to disk is enabled, since the state is spilled to disk instead.
+func (psp *pipeStatsProcessor) isStateSizeExceeded() bool {
+	return psp.spill == nil && psp.stateSizeLimit.isStateSizeExceeded()
+}
+
 func (psp *pipeStatsProcessor) flush() error {
+	if psp.spill != nil {
+		// Remove the spilled files on all the return paths, including errors and query cancelation.
+		defer psp.spill.mustClose()
+	}
+
 	if psp.err != nil {
 		return psp.err
 	}
 
-	if n := psp.stateSizeBudget.Load(); n <= 0 {
+	if psp.spill != nil && psp.spill.hasSpilled() {
+		return psp.flushSpilled()
+	}
+
+	if n := psp.stateSizeBudget.Load(); psp.spill == nil && n <= 0 {
 		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", psp.ps.String(), psp.maxStateSize/(1<<20))
 	}
 
@@ -0,0 +1,257 @@
+package logstorage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// pipeStatsSpillPartitions is the number of partitions for the spilled state of `stats` pipe.
+//
+// Groups are distributed among partitions by the hash of their `by(...)` values, so every group
+// belongs to a single partition. Partitions are merged one by one at flush, so the memory required
+// for the merge is reduced by pipeStatsSpillPartitions times comparing to the in-memory merge.
+const pipeStatsSpillPartitions = 32
+
+// pipeStatsSpill holds partially aggregated groups spilled to temporary files by `stats` pipe
+// when the state doesn't fit the memory limit.
+//
+// pipeStatsSpill implements pipeProcessor, which accepts the exported state of groups
+// in the same format as it is passed from remote storage nodes to `stats` pipe in pipeStatsModeLocal mode.
+type pipeStatsSpill struct {
+	psp *pipeStatsProcessor
+
+	mu sync.Mutex
+
+	// partitions contains temporary files per every partition. Files are created on demand.
+	partitions [pipeStatsSpillPartitions]*spillFile
+
+	// spilled is set to true after the first spill.
+	spilled bool
+
+	// err is the first error occurred during spilling.
+	err error
+
+	// buf is a temporary buffer for marshaling the spilled rows.
+	buf []byte
+
+	columnValues [][]string
+}
+
+func (pss *pipeStatsSpill) hasSpilled() bool {
+	pss.mu.Lock()
+	defer pss.mu.Unlock()
+
+	return pss.spilled
+}
+
+// spillShard writes the state of shard to temporary files and resets the shard.
+func (pss *pipeStatsSpill) spillShard(shard *pipeStatsProcessorShard) {
+	psp := pss.psp
+
+	// Export the collected state via pipeStatsWriter to pss.
+	psExport := *psp.ps
+	psExport.mode = pipeStatsModeRemote
+	pspExport := &pipeStatsProcessor{
+		ps:     &psExport,
+		stopCh: psp.stopCh,
+		ppNext: pss,
+	}
+	psw := newPipeStatsWriter(pspExport, 0)
+	if shard.groupMapShards == nil {
+		psw.writeShardData(&shard.groupMap)
+	} else {
+		for i := range shard.groupMapShards {
+			psw.writeShardData(&shard.groupMapShards[i].pipeStatsGroupMap)
+		}
+	}
+	psw.flush()
+
+	shard.groupMap.reset()
+	shard.groupMap.init(shard)
+	shard.groupMapShards = nil
+	shard.a = chunkedAllocator{}
+}
+
+func (pss *pipeStatsSpill) writeBlock(_ uint, br *blockResult) {
+	if br.rowsLen == 0 {
+		return
+	}
+
+	pss.mu.Lock()
+	defer pss.mu.Unlock()
+
+	if pss.err != nil {
+		return
+	}
+	pss.spilled = true
+
+	cs := br.getColumns()
+	pss.columnValues = pss.columnValues[:0]
+	for _, c := range cs {
+		pss.columnValues = append(pss.columnValues, c.getValues(br))
+	}
+	byFieldsLen := len(pss.psp.ps.byFields)
+
+	for rowIdx := 0; rowIdx < br.rowsLen; rowIdx++ {
+		buf := pss.buf[:0]
+		for _, values := range pss.columnValues[:byFieldsLen] {
+			buf = encoding.MarshalBytes(buf, bytesutil.ToUnsafeBytes(values[rowIdx]))
+		}
+		h := xxhash.Sum64(buf)
+		for _, values := range pss.columnValues[byFieldsLen:] {
+			buf = encoding.MarshalBytes(buf, bytesutil.ToUnsafeBytes(values[rowIdx]))
+		}
+		pss.buf = buf
+
+		if err := pss.writeRowLocked(h%pipeStatsSpillPartitions, buf); err != nil {
+			pss.err = err
+			return
+		}
+	}
+}
+
+func (pss *pipeStatsSpill) writeRowLocked(partitionIdx uint64, data []byte) error {
+	sf := pss.partitions[partitionIdx]
+	if sf == nil {
+		var err error
+		sf, err = newSpillFile()
+		if err != nil {
+			return err
+		}
+		pss.partitions[partitionIdx] = sf
+	}
+	return sf.writeRecord(data)
+}
+
+func (pss *pipeStatsSpill) flush() error {
+	return nil
+}
+
+func (pss *pipeStatsSpill) mustClose() {
+	for i, sf := range pss.partitions {
+		if sf != nil {
+			sf.mustClose()
+			pss.partitions[i] = nil
+		}
+	}
+}
+
+// flushSpilled spills the remaining state from all the shards to disk and then merges the spilled state
+// partition by partition into the output.
+func (psp *pipeStatsProcessor) flushSpilled() error {
+	pss := psp.spill
+
+	shards := psp.shards.All()
+	for _, shard := range shards {
+		if needStop(psp.stopCh) {
+			return nil
+		}
+		pss.spillShard(shard)
+	}
+	if pss.err != nil {
+		return fmt.Errorf("cannot spill the state of [%s] to disk: %w", psp.ps.String(), pss.err)
+	}
+
+	// Merge partitions with a `stats` pipe processor, which imports the spilled state.
+	psMerge := *psp.ps
+	if psp.ps.mode.needExportState() {
+		psMerge.mode = pipeStatsModeProxy
+	} else {
+		psMerge.mode = pipeStatsModeLocal
+	}
+
+	for _, sf := range pss.partitions {
+		if sf == nil {
+			continue
+		}
+		if needStop(psp.stopCh) {
+			return nil
+		}
+		if err := sf.flush(); err != nil {
+			return fmt.Errorf("cannot spill the state of [%s] to disk: %w", psp.ps.String(), err)
+		}
+
+		pspMerge := newPipeStatsProcessor(&psMerge, psp.concurrency, psp.stopCh, psp.cancel, psp.ppNext, psp.maxStateSize)
+		pspMerge.mergeImportedState = true
+		if err := pss.readPartition(pspMerge, sf); err != nil {
+			return err
+		}
+		if err := pspMerge.flush(); err != nil {
+			return fmt.Errorf("cannot merge the state of [%s] spilled to disk: %w", psp.ps.String(), err)
+		}
+	}
+
+	return nil
+}
+
+// readPartition reads the spilled state from sf and passes it to pspMerge.
+func (pss *pipeStatsSpill) readPartition(pspMerge *pipeStatsProcessor, sf *spillFile) error {
+	psp := pss.psp
+	byFields := psp.ps.byFields
+
+	rcs := make([]resultColumn, 0, len(byFields)+len(psp.ps.funcs))
+	for _, bf := range byFields {
+		rcs = appendResultColumnWithName(rcs, bf.name)
+	}
+	for _, f := range psp.ps.funcs {
+		rcs = appendResultColumnWithName(rcs, f.resultName)
+	}
+
+	var br blockResult
+	rowsCount := 0
+	valuesLen := 0
+	writeBlock := func() {
+		br.setResultColumns(rcs, rowsCount)
+		pspMerge.writeBlock(0, &br)
+		br.reset()
+		for i := range rcs {
+			rcs[i].resetValues()
+		}
+		rowsCount = 0
+		valuesLen = 0
+	}
+
+	sr := sf.newReader(0, sf.size)
+	for {
+		data, err := sr.readRecord()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		for i := range rcs {
+			v, n := encoding.UnmarshalBytes(data)
+			if n <= 0 {
+				return fmt.Errorf("cannot unmarshal value for %q column from temporary file %q", rcs[i].name, sr.path)
+			}
+			data = data[n:]
+			rcs[i].addValue(string(v))
+			valuesLen += len(v)
+		}
+		if len(data) > 0 {
+			return fmt.Errorf("unexpected tail left after reading the row from temporary file %q; len(tail)=%d", sr.path, len(data))
+		}
+		rowsCount++
+
+		// Global stats without `by(...)` fields must be passed by a single row per block.
+		if len(byFields) == 0 || valuesLen >= 1_000_000 {
+			writeBlock()
+			if needStop(psp.stopCh) {
+				return nil
+			}
+		}
+	}
+	if rowsCount > 0 {
+		writeBlock()
+	}
+
+	return nil
+}
@@ -7,7 +7,6 @@ import (
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"unsafe"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
@@ -102,13 +101,11 @@ func (pc *pipeStreamContext) newPipeProcessor(_ int, stopCh <-chan struct{}, can
 		stopCh: stopCh,
 		cancel: cancel,
 		ppNext: ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	pcp.shards.Init = func(shard *pipeStreamContextProcessorShard) {
 		shard.pc = pc
 	}
-	pcp.stateSizeBudget.Store(maxStateSize)
+	pcp.initStateSizeLimit(maxStateSize)
 
 	return pcp
 }
@@ -121,8 +118,7 @@ type pipeStreamContextProcessor struct {
 
 	shards atomicutil.Slice[pipeStreamContextProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
 }
 
 type timeRange struct {
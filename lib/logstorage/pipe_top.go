@@ -8,7 +8,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -107,14 +106,12 @@ func (pt *pipeTop) newPipeProcessor(concurrency int, stopCh <-chan struct{}, can
 		stopCh: stopCh,
 		cancel: cancel,
 		ppNext: ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	ptp.shards.Init = func(shard *pipeTopProcessorShard) {
 		shard.pt = pt
 		shard.m.init(uint(concurrency), &shard.stateSizeBudget)
 	}
-	ptp.stateSizeBudget.Store(maxStateSize)
+	ptp.initStateSizeLimit(maxStateSize)
 
 	return ptp
 }
@@ -127,8 +124,7 @@ type pipeTopProcessor struct {
 
 	shards atomicutil.Slice[pipeTopProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
 }
 
 type pipeTopProcessorShard struct {
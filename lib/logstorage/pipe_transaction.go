@@ -0,0 +1,507 @@
+package logstorage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// pipeTransaction processes '| transaction ...' queries.
+//
+// See https://docs.victoriametrics.com/victorialogs/logsql/#transaction-pipe
+type pipeTransaction struct {
+	// byFields contains field names from 'by(...)' clause.
+	byFields []string
+
+	// maxSpan is the maximum duration in nanoseconds between the first and the last log entry in a transaction.
+	//
+	// Zero means no limit.
+	maxSpan    int64
+	maxSpanStr string
+
+	// maxPause is the maximum duration in nanoseconds between adjacent log entries in a transaction.
+	//
+	// Zero means no limit.
+	maxPause    int64
+	maxPauseStr string
+
+	// startFilter is an optional filter for log entries, which start a new transaction.
+	startFilter *ifFilter
+
+	// endFilter is an optional filter for log entries, which end the current transaction.
+	endFilter *ifFilter
+}
+
+func (pt *pipeTransaction) String() string {
+	s := "transaction"
+	if len(pt.byFields) > 0 {
+		s += " by (" + fieldNamesString(pt.byFields) + ")"
+	}
+	if pt.maxSpanStr != "" {
+		s += " maxspan " + pt.maxSpanStr
+	}
+	if pt.maxPauseStr != "" {
+		s += " maxpause " + pt.maxPauseStr
+	}
+	if pt.startFilter != nil {
+		s += " startswith (" + pt.startFilter.f.String() + ")"
+	}
+	if pt.endFilter != nil {
+		s += " endswith (" + pt.endFilter.f.String() + ")"
+	}
+	return s
+}
+
+func (pt *pipeTransaction) splitToRemoteAndLocal(_ int64) (pipe, []pipe) {
+	return nil, []pipe{pt}
+}
+
+func (pt *pipeTransaction) canLiveTail() bool {
+	return false
+}
+
+func (pt *pipeTransaction) canReturnLastNResults() bool {
+	return false
+}
+
+func (pt *pipeTransaction) updateNeededFields(pf *prefixfilter.Filter) {
+	pf.Reset()
+
+	pf.AddAllowFilters(pt.byFields)
+	pf.AddAllowFilter("_time")
+	pf.AddAllowFilter("_msg")
+	if pt.startFilter != nil {
+		pf.AddAllowFilters(pt.startFilter.allowFilters)
+	}
+	if pt.endFilter != nil {
+		pf.AddAllowFilters(pt.endFilter.allowFilters)
+	}
+}
+
+func (pt *pipeTransaction) hasFilterInWithQuery() bool {
+	return pt.startFilter.hasFilterInWithQuery() || pt.endFilter.hasFilterInWithQuery()
+}
+
+func (pt *pipeTransaction) initFilterInValues(cache *inValuesCache, getFieldValuesFunc getFieldValuesFunc, keepSubquery bool) (pipe, error) {
+	startFilterNew, err := pt.startFilter.initFilterInValues(cache, getFieldValuesFunc, keepSubquery)
+	if err != nil {
+		return nil, err
+	}
+	endFilterNew, err := pt.endFilter.initFilterInValues(cache, getFieldValuesFunc, keepSubquery)
+	if err != nil {
+		return nil, err
+	}
+	ptNew := *pt
+	ptNew.startFilter = startFilterNew
+	ptNew.endFilter = endFilterNew
+	return &ptNew, nil
+}
+
+func (pt *pipeTransaction) visitSubqueries(visitFunc func(q *Query)) {
+	pt.startFilter.visitSubqueries(visitFunc)
+	pt.endFilter.visitSubqueries(visitFunc)
+}
+
+func (pt *pipeTransaction) newPipeProcessor(_ int, stopCh <-chan struct{}, cancel func(), ppNext pipeProcessor) pipeProcessor {
+	maxStateSize := int64(float64(memory.Allowed()) * 0.4)
+
+	ptp := &pipeTransactionProcessor{
+		pt:     pt,
+		stopCh: stopCh,
+		cancel: cancel,
+		ppNext: ppNext,
+	}
+
+	ptp.initStateSizeLimit(maxStateSize)
+
+	return ptp
+}
+
+type pipeTransactionProcessor struct {
+	pt     *pipeTransaction
+	stopCh <-chan struct{}
+	cancel func()
+	ppNext pipeProcessor
+
+	shards atomicutil.Slice[pipeTransactionProcessorShard]
+
+	stateSizeLimit
+}
+
+type pipeTransactionProcessorShard struct {
+	// groups contains the collected events per each 'by(...)' key.
+	groups map[string]*pipeTransactionGroup
+
+	startBm bitmap
+	endBm   bitmap
+
+	byValues   [][]string
+	keyBuf     []byte
+	timestamps []int64
+
+	stateSizeBudget int
+}
+
+type pipeTransactionGroup struct {
+	// byValues contains values for pipeTransaction.byFields
+	byValues []string
+
+	events []pipeTransactionEvent
+}
+
+type pipeTransactionEvent struct {
+	timestamp int64
+	msg       string
+
+	isStart bool
+	isEnd   bool
+}
+
+func (shard *pipeTransactionProcessorShard) writeBlock(pt *pipeTransaction, br *blockResult) {
+	timestamps := shard.getTimestamps(br)
+
+	if pt.startFilter != nil {
+		shard.startBm.init(br.rowsLen)
+		shard.startBm.setBits()
+		pt.startFilter.f.applyToBlockResult(br, &shard.startBm)
+	}
+	if pt.endFilter != nil {
+		shard.endBm.init(br.rowsLen)
+		shard.endBm.setBits()
+		pt.endFilter.f.applyToBlockResult(br, &shard.endBm)
+	}
+
+	byValues := shard.byValues[:0]
+	for _, bf := range pt.byFields {
+		c := br.getColumnByName(bf)
+		byValues = append(byValues, c.getValues(br))
+	}
+	shard.byValues = byValues
+
+	msgs := br.getColumnByName("_msg").getValues(br)
+
+	if shard.groups == nil {
+		shard.groups = make(map[string]*pipeTransactionGroup)
+	}
+
+	var g *pipeTransactionGroup
+	for rowIdx := 0; rowIdx < br.rowsLen; rowIdx++ {
+		if g == nil || !isSameTransactionGroup(byValues, rowIdx) {
+			keyBuf := shard.keyBuf[:0]
+			for _, values := range byValues {
+				keyBuf = encoding.MarshalBytes(keyBuf, bytesutil.ToUnsafeBytes(values[rowIdx]))
+			}
+			shard.keyBuf = keyBuf
+
+			g = shard.groups[string(keyBuf)]
+			if g == nil {
+				groupByValues := make([]string, len(byValues))
+				for i, values := range byValues {
+					groupByValues[i] = strings.Clone(values[rowIdx])
+					shard.stateSizeBudget -= len(values[rowIdx])
+				}
+				g = &pipeTransactionGroup{
+					byValues: groupByValues,
+				}
+				key := string(keyBuf)
+				shard.groups[key] = g
+				shard.stateSizeBudget -= len(key) + int(unsafe.Sizeof(*g)) + int(unsafe.Sizeof(groupByValues[0]))*len(groupByValues)
+			}
+		}
+
+		if timestamps[rowIdx] == missingTransactionTimestamp {
+			// Logs without valid _time field cannot be grouped into transactions.
+			continue
+		}
+
+		msg := strings.Clone(msgs[rowIdx])
+		shard.stateSizeBudget -= len(msg)
+
+		g.events = append(g.events, pipeTransactionEvent{
+			timestamp: timestamps[rowIdx],
+			msg:       msg,
+			isStart:   pt.startFilter != nil && shard.startBm.isSetBit(rowIdx),
+			isEnd:     pt.endFilter != nil && shard.endBm.isSetBit(rowIdx),
+		})
+		shard.stateSizeBudget -= int(unsafe.Sizeof(g.events[0]))
+	}
+}
+
+// isSameTransactionGroup returns true if the row at rowIdx has the same 'by(...)' values as the previous row.
+func isSameTransactionGroup(byValues [][]string, rowIdx int) bool {
+	if rowIdx == 0 {
+		return false
+	}
+	for _, values := range byValues {
+		if values[rowIdx] != values[rowIdx-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// missingTransactionTimestamp is used for logs without valid _time field.
+const missingTransactionTimestamp = math.MinInt64
+
+func (shard *pipeTransactionProcessorShard) getTimestamps(br *blockResult) []int64 {
+	c := br.getColumnByName("_time")
+	if c.isTime {
+		return br.getTimestamps()
+	}
+
+	timestamps := shard.timestamps[:0]
+	for _, v := range c.getValues(br) {
+		ts, ok := TryParseTimestampRFC3339Nano(v)
+		if !ok {
+			ts = missingTransactionTimestamp
+		}
+		timestamps = append(timestamps, ts)
+	}
+	shard.timestamps = timestamps
+	return timestamps
+}
+
+func (ptp *pipeTransactionProcessor) writeBlock(workerID uint, br *blockResult) {
+	if br.rowsLen == 0 {
+		return
+	}
+
+	shard := ptp.shards.Get(workerID)
+
+	for shard.stateSizeBudget < 0 {
+		// steal some budget for the state size from the global budget.
+		remaining := ptp.stateSizeBudget.Add(-stateSizeBudgetChunk)
+		if remaining < 0 {
+			// The state size is too big. Stop processing data in order to avoid OOM crash.
+			if remaining+stateSizeBudgetChunk >= 0 {
+				// Notify worker goroutines to stop calling writeBlock() in order to save CPU time.
+				ptp.cancel()
+			}
+			return
+		}
+		shard.stateSizeBudget += stateSizeBudgetChunk
+	}
+
+	shard.writeBlock(ptp.pt, br)
+}
+
+func (ptp *pipeTransactionProcessor) flush() error {
+	if n := ptp.stateSizeBudget.Load(); n <= 0 {
+		return fmt.Errorf("cannot calculate [%s], since it requires more than %dMB of memory", ptp.pt.String(), ptp.maxStateSize/(1<<20))
+	}
+
+	// Merge groups from all the shards
+	m := make(map[string]*pipeTransactionGroup)
+	shards := ptp.shards.All()
+	for _, shard := range shards {
+		for key, g := range shard.groups {
+			if needStop(ptp.stopCh) {
+				return nil
+			}
+			gDst := m[key]
+			if gDst == nil {
+				m[key] = g
+				continue
+			}
+			gDst.events = append(gDst.events, g.events...)
+		}
+	}
+
+	// Sort output by keys
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Write output
+	wctx := &pipeRunningStatsWriter{
+		ppNext: ptp.ppNext,
+	}
+
+	pt := ptp.pt
+	writeTransaction := func(byValues []string, events []pipeTransactionEvent) {
+		first := &events[0]
+		last := &events[len(events)-1]
+
+		fields := make([]Field, 0, len(byValues)+5)
+		for i, v := range byValues {
+			fields = append(fields, Field{
+				Name:  pt.byFields[i],
+				Value: v,
+			})
+		}
+		fields = append(fields, Field{
+			Name:  "_time",
+			Value: string(marshalTimestampRFC3339NanoString(nil, first.timestamp)),
+		}, Field{
+			Name:  "duration",
+			Value: string(marshalDurationString(nil, last.timestamp-first.timestamp)),
+		}, Field{
+			Name:  "event_count",
+			Value: strconv.Itoa(len(events)),
+		}, Field{
+			Name:  "first_msg",
+			Value: first.msg,
+		}, Field{
+			Name:  "last_msg",
+			Value: last.msg,
+		})
+		wctx.writeRow(fields)
+	}
+
+	for _, key := range keys {
+		if needStop(ptp.stopCh) {
+			return nil
+		}
+
+		g := m[key]
+		events := g.events
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].timestamp < events[j].timestamp
+		})
+
+		start := 0
+		for i := range events {
+			e := &events[i]
+			if i > start {
+				prev := &events[i-1]
+				if e.isStart || pt.maxPause > 0 && e.timestamp-prev.timestamp > pt.maxPause || pt.maxSpan > 0 && e.timestamp-events[start].timestamp > pt.maxSpan {
+					writeTransaction(g.byValues, events[start:i])
+					start = i
+				}
+			}
+			if e.isEnd {
+				writeTransaction(g.byValues, events[start:i+1])
+				start = i + 1
+			}
+		}
+		if start < len(events) {
+			writeTransaction(g.byValues, events[start:])
+		}
+	}
+
+	wctx.flush()
+
+	return nil
+}
+
+func parsePipeTransaction(lex *lexer) (pipe, error) {
+	if !lex.isKeyword("transaction") {
+		return nil, fmt.Errorf("expecting 'transaction'; got %q", lex.token)
+	}
+	lex.nextToken()
+
+	var pt pipeTransaction
+
+	if lex.isKeyword("by", "(") {
+		if lex.isKeyword("by") {
+			lex.nextToken()
+		}
+		bfs, err := parseFieldNamesInParens(lex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse 'by' clause: %w", err)
+		}
+		for _, bf := range bfs {
+			if prefixfilter.IsWildcardFilter(bf) {
+				return nil, fmt.Errorf("wildcard filter %q isn't supported in 'by' clause of 'transaction' pipe", bf)
+			}
+		}
+		pt.byFields = bfs
+	}
+
+	for {
+		switch {
+		case lex.isKeyword("maxspan"):
+			if pt.maxSpanStr != "" {
+				return nil, fmt.Errorf("duplicate 'maxspan'")
+			}
+			d, s, err := parsePipeTransactionDuration(lex, "maxspan")
+			if err != nil {
+				return nil, err
+			}
+			pt.maxSpan = d
+			pt.maxSpanStr = s
+		case lex.isKeyword("maxpause"):
+			if pt.maxPauseStr != "" {
+				return nil, fmt.Errorf("duplicate 'maxpause'")
+			}
+			d, s, err := parsePipeTransactionDuration(lex, "maxpause")
+			if err != nil {
+				return nil, err
+			}
+			pt.maxPause = d
+			pt.maxPauseStr = s
+		case lex.isKeyword("startswith"):
+			if pt.startFilter != nil {
+				return nil, fmt.Errorf("duplicate 'startswith'")
+			}
+			iff, err := parsePipeTransactionFilter(lex, "startswith")
+			if err != nil {
+				return nil, err
+			}
+			pt.startFilter = iff
+		case lex.isKeyword("endswith"):
+			if pt.endFilter != nil {
+				return nil, fmt.Errorf("duplicate 'endswith'")
+			}
+			iff, err := parsePipeTransactionFilter(lex, "endswith")
+			if err != nil {
+				return nil, err
+			}
+			pt.endFilter = iff
+		default:
+			return &pt, nil
+		}
+	}
+}
+
+func parsePipeTransactionDuration(lex *lexer, name string) (int64, string, error) {
+	lex.nextToken()
+
+	d, s, err := parseDuration(lex)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot parse '%s': %w", name, err)
+	}
+	if d <= 0 {
+		return 0, "", fmt.Errorf("'%s' must be positive; got %s", name, s)
+	}
+	return d, s, nil
+}
+
+func parsePipeTransactionFilter(lex *lexer, name string) (*ifFilter, error) {
+	lex.nextToken()
+
+	if !lex.isKeyword("(") {
+		return nil, fmt.Errorf("unexpected token %q after '%s'; expecting '('", lex.token, name)
+	}
+	lex.nextToken()
+
+	f, err := parseFilter(lex, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s' filter: %w", name, err)
+	}
+	if !lex.isKeyword(")") {
+		return nil, fmt.Errorf("unexpected token %q after '%s' filter; expecting ')'", lex.token, name)
+	}
+	lex.nextToken()
+
+	var pf prefixfilter.Filter
+	f.updateNeededFields(&pf)
+
+	iff := &ifFilter{
+		f:            f,
+		allowFilters: pf.GetAllowFilters(),
+	}
+	return iff, nil
+}
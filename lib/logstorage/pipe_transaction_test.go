@@ -0,0 +1,266 @@
+package logstorage
+
+import (
+	"testing"
+)
+
+func TestParsePipeTransactionSuccess(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeSuccess(t, pipeStr)
+	}
+
+	f(`transaction`)
+	f(`transaction by (user)`)
+	f(`transaction by (user, host)`)
+	f(`transaction maxspan 1h`)
+	f(`transaction maxpause 5m`)
+	f(`transaction by (user) maxspan 1h maxpause 5m`)
+	f(`transaction by (user) startswith (login)`)
+	f(`transaction by (user) endswith (logout)`)
+	f(`transaction by (user) maxpause 30m startswith (event:=login) endswith (event:=logout or error)`)
+	f(`transaction startswith (user:in(foo,bar))`)
+}
+
+func TestParsePipeTransactionFailure(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+		expectParsePipeFailure(t, pipeStr)
+	}
+
+	f(`transaction by`)
+	f(`transaction by (*)`)
+	f(`transaction by (x*)`)
+	f(`transaction foo`)
+	f(`transaction maxspan`)
+	f(`transaction maxspan foo`)
+	f(`transaction maxspan -1h`)
+	f(`transaction maxpause`)
+	f(`transaction maxpause 1h maxpause 2h`)
+	f(`transaction startswith`)
+	f(`transaction startswith foo`)
+	f(`transaction startswith ()`)
+	f(`transaction startswith (foo`)
+	f(`transaction endswith (foo) endswith (bar)`)
+}
+
+func TestPipeTransaction(t *testing.T) {
+	f := func(pipeStr string, rows, rowsExpected [][]Field) {
+		t.Helper()
+		expectPipeResults(t, pipeStr, rows, rowsExpected)
+	}
+
+	// no options
+	f(`transaction`, [][]Field{
+		{
+			{"_time", "2024-01-01T00:00:10Z"},
+			{"_msg", "second"},
+		},
+		{
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"_msg", "first"},
+		},
+		{
+			{"_time", "2024-01-01T00:01:30Z"},
+			{"_msg", "third"},
+		},
+		{
+			{"_time", "invalid"},
+			{"_msg", "skipped"},
+		},
+	}, [][]Field{
+		{
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"duration", "1m30s"},
+			{"event_count", "3"},
+			{"first_msg", "first"},
+			{"last_msg", "third"},
+		},
+	})
+
+	// by fields with maxpause
+	f(`transaction by (user) maxpause 1m`, [][]Field{
+		{
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"_msg", "a1"},
+			{"user", "alice"},
+		},
+		{
+			{"_time", "2024-01-01T00:00:30Z"},
+			{"_msg", "a2"},
+			{"user", "alice"},
+		},
+		{
+			{"_time", "2024-01-01T00:05:00Z"},
+			{"_msg", "a3"},
+			{"user", "alice"},
+		},
+		{
+			{"_time", "2024-01-01T00:00:10Z"},
+			{"_msg", "b1"},
+			{"user", "bob"},
+		},
+		{
+			{"_time", "2024-01-01T00:00:20Z"},
+			{"_msg", "n1"},
+		},
+	}, [][]Field{
+		{
+			{"user", ""},
+			{"_time", "2024-01-01T00:00:20Z"},
+			{"duration", "0"},
+			{"event_count", "1"},
+			{"first_msg", "n1"},
+			{"last_msg", "n1"},
+		},
+		{
+			{"user", "alice"},
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"duration", "30s"},
+			{"event_count", "2"},
+			{"first_msg", "a1"},
+			{"last_msg", "a2"},
+		},
+		{
+			{"user", "alice"},
+			{"_time", "2024-01-01T00:05:00Z"},
+			{"duration", "0"},
+			{"event_count", "1"},
+			{"first_msg", "a3"},
+			{"last_msg", "a3"},
+		},
+		{
+			{"user", "bob"},
+			{"_time", "2024-01-01T00:00:10Z"},
+			{"duration", "0"},
+			{"event_count", "1"},
+			{"first_msg", "b1"},
+			{"last_msg", "b1"},
+		},
+	})
+
+	// maxspan
+	f(`transaction maxspan 1m`, [][]Field{
+		{
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"_msg", "a"},
+		},
+		{
+			{"_time", "2024-01-01T00:00:40Z"},
+			{"_msg", "b"},
+		},
+		{
+			{"_time", "2024-01-01T00:01:20Z"},
+			{"_msg", "c"},
+		},
+		{
+			{"_time", "2024-01-01T00:01:50Z"},
+			{"_msg", "d"},
+		},
+	}, [][]Field{
+		{
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"duration", "40s"},
+			{"event_count", "2"},
+			{"first_msg", "a"},
+			{"last_msg", "b"},
+		},
+		{
+			{"_time", "2024-01-01T00:01:20Z"},
+			{"duration", "30s"},
+			{"event_count", "2"},
+			{"first_msg", "c"},
+			{"last_msg", "d"},
+		},
+	})
+
+	// startswith and endswith
+	f(`transaction by (user) startswith (event:=login) endswith (event:=logout)`, [][]Field{
+		{
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"_msg", "orphan"},
+			{"user", "alice"},
+		},
+		{
+			{"_time", "2024-01-01T00:01:00Z"},
+			{"_msg", "login 1"},
+			{"user", "alice"},
+			{"event", "login"},
+		},
+		{
+			{"_time", "2024-01-01T00:02:00Z"},
+			{"_msg", "click"},
+			{"user", "alice"},
+		},
+		{
+			{"_time", "2024-01-01T00:03:00Z"},
+			{"_msg", "login 2"},
+			{"user", "alice"},
+			{"event", "login"},
+		},
+		{
+			{"_time", "2024-01-01T00:04:00Z"},
+			{"_msg", "logout"},
+			{"user", "alice"},
+			{"event", "logout"},
+		},
+		{
+			{"_time", "2024-01-01T00:05:00Z"},
+			{"_msg", "after logout"},
+			{"user", "alice"},
+		},
+	}, [][]Field{
+		{
+			{"user", "alice"},
+			{"_time", "2024-01-01T00:00:00Z"},
+			{"duration", "0"},
+			{"event_count", "1"},
+			{"first_msg", "orphan"},
+			{"last_msg", "orphan"},
+		},
+		{
+			{"user", "alice"},
+			{"_time", "2024-01-01T00:01:00Z"},
+			{"duration", "1m"},
+			{"event_count", "2"},
+			{"first_msg", "login 1"},
+			{"last_msg", "click"},
+		},
+		{
+			{"user", "alice"},
+			{"_time", "2024-01-01T00:03:00Z"},
+			{"duration", "1m"},
+			{"event_count", "2"},
+			{"first_msg", "login 2"},
+			{"last_msg", "logout"},
+		},
+		{
+			{"user", "alice"},
+			{"_time", "2024-01-01T00:05:00Z"},
+			{"duration", "0"},
+			{"event_count", "1"},
+			{"first_msg", "after logout"},
+			{"last_msg", "after logout"},
+		},
+	})
+}
+
+func TestPipeTransactionUpdateNeededFields(t *testing.T) {
+	f := func(s string, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected string) {
+		t.Helper()
+		expectPipeNeededFields(t, s, allowFilters, denyFilters, allowFiltersExpected, denyFiltersExpected)
+	}
+
+	// all the needed fields
+	f("transaction", "*", "", "_msg,_time", "")
+	f("transaction by (user)", "*", "", "_msg,_time,user", "")
+
+	// unneeded fields
+	f("transaction by (user)", "*", "user,_msg", "_msg,_time,user", "")
+
+	// needed fields
+	f("transaction by (user)", "f1,f2", "", "_msg,_time,user", "")
+
+	// filters
+	f("transaction by (user) startswith (event:=login) endswith (status:=done)", "*", "", "_msg,_time,event,status,user", "")
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"slices"
 	"sync"
-	"sync/atomic"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
@@ -85,14 +84,12 @@ func (pu *pipeUniq) newPipeProcessor(concurrency int, stopCh <-chan struct{}, ca
 		stopCh: stopCh,
 		cancel: cancel,
 		ppNext: ppNext,
-
-		maxStateSize: maxStateSize,
 	}
 	pup.shards.Init = func(shard *pipeUniqProcessorShard) {
 		shard.pu = pu
 		shard.m.init(uint(concurrency), &shard.stateSizeBudget)
 	}
-	pup.stateSizeBudget.Store(maxStateSize)
+	pup.initStateSizeLimit(maxStateSize)
 
 	return pup
 }
@@ -105,8 +102,7 @@ type pipeUniqProcessor struct {
 
 	shards atomicutil.Slice[pipeUniqProcessorShard]
 
-	maxStateSize    int64
-	stateSizeBudget atomic.Int64
+	stateSizeLimit
 }
 
 type pipeUniqProcessorShard struct {
@@ -0,0 +1,153 @@
+package logstorage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// QueryLimits contains resource limits for a single query execution.
+//
+// Zero limit means there is no limit.
+type QueryLimits struct {
+	// MaxBytesRead is the maximum number of bytes the query can read from the storage.
+	//
+	// It is compared against QueryStats.GetBytesReadTotal().
+	MaxBytesRead uint64
+
+	// MaxBlocksProcessed is the maximum number of data blocks the query can process.
+	//
+	// It is compared against QueryStats.BlocksProcessed.
+	MaxBlocksProcessed uint64
+
+	// MaxMemoryBytes is the maximum number of bytes the query can use for the state of pipes such as stats, sort, uniq or top.
+	//
+	// The limit is applied only to pipes executed locally. It isn't propagated to remote storage nodes,
+	// so every storage node applies MaxMemoryBytes from its own QueryLimits.
+	MaxMemoryBytes int64
+}
+
+func (ql *QueryLimits) hasSearchLimits() bool {
+	return ql != nil && (ql.MaxBytesRead > 0 || ql.MaxBlocksProcessed > 0)
+}
+
+// queryLimitsChecker verifies whether the query exceeds the search limits at QueryLimits during the search.
+type queryLimitsChecker struct {
+	limits *QueryLimits
+
+	// qs is the query stats to check against limits.
+	qs *QueryStats
+
+	// cancel must stop the search.
+	cancel func()
+
+	errOnce sync.Once
+	err     error
+}
+
+func newQueryLimitsChecker(limits *QueryLimits, qs *QueryStats, cancel func()) *queryLimitsChecker {
+	return &queryLimitsChecker{
+		limits: limits,
+		qs:     qs,
+		cancel: cancel,
+	}
+}
+
+// updateAndCheck adds qsLocal to qlc.qs, resets qsLocal and verifies whether the updated qlc.qs exceeds qlc.limits.
+//
+// The search is stopped via qlc.cancel if the limits are exceeded.
+func (qlc *queryLimitsChecker) updateAndCheck(qsLocal *QueryStats) {
+	qs := qlc.qs
+	qs.UpdateAtomic(qsLocal)
+	*qsLocal = QueryStats{}
+
+	limits := qlc.limits
+	if n := limits.MaxBlocksProcessed; n > 0 {
+		if blocksProcessed := atomic.LoadUint64(&qs.BlocksProcessed); blocksProcessed > n {
+			qlc.setError(fmt.Errorf("the query exceeds max_blocks_processed=%d limit; processed %d blocks; "+
+				"narrow down the query with more specific filters or with smaller time range", n, blocksProcessed))
+			return
+		}
+	}
+	if n := limits.MaxBytesRead; n > 0 {
+		if bytesRead := qs.GetBytesReadTotal(); bytesRead > n {
+			qlc.setError(fmt.Errorf("the query exceeds max_bytes_read=%d limit; read %d bytes; "+
+				"narrow down the query with more specific filters or with smaller time range", n, bytesRead))
+			return
+		}
+	}
+}
+
+func (qlc *queryLimitsChecker) setError(err error) {
+	qlc.errOnce.Do(func() {
+		qlc.err = err
+		qlc.cancel()
+	})
+}
+
+// getError returns an error if the query exceeded the limits.
+//
+// It must be called after the search is finished.
+func (qlc *queryLimitsChecker) getError() error {
+	return qlc.err
+}
+
+// stateSizeLimiter is implemented by pipe processors, which limit the size of their in-memory state.
+type stateSizeLimiter interface {
+	// setMaxStateSize sets the maximum state size in bytes.
+	//
+	// It must be called before the first writeBlock() call.
+	setMaxStateSize(maxStateSize int64)
+
+	// isStateSizeExceeded returns true if the pipe processor exceeded the maximum state size.
+	isStateSizeExceeded() bool
+}
+
+// stateSizeLimit implements stateSizeLimiter for pipe processors, which embed it.
+//
+// The limit is local to the current process - it doesn't account for the state of pipes executed at remote storage nodes.
+type stateSizeLimit struct {
+	maxStateSize    int64
+	stateSizeBudget atomic.Int64
+}
+
+// initStateSizeLimit initializes ssl with the given maxStateSize.
+func (ssl *stateSizeLimit) initStateSizeLimit(maxStateSize int64) {
+	ssl.maxStateSize = maxStateSize
+	ssl.stateSizeBudget.Store(maxStateSize)
+}
+
+// setMaxStateSize implements stateSizeLimiter interface.
+//
+// It only reduces the maximum state size set at initStateSizeLimit.
+func (ssl *stateSizeLimit) setMaxStateSize(maxStateSize int64) {
+	if maxStateSize < ssl.maxStateSize {
+		ssl.initStateSizeLimit(maxStateSize)
+	}
+}
+
+// isStateSizeExceeded implements stateSizeLimiter interface.
+func (ssl *stateSizeLimit) isStateSizeExceeded() bool {
+	return ssl.stateSizeBudget.Load() <= 0
+}
+
+// applyMaxMemoryLimit evenly splits maxMemoryBytes among stateful pipe processors at pps.
+//
+// It returns the pipe processors with the limited state size.
+func applyMaxMemoryLimit(pps []pipeProcessor, maxMemoryBytes int64) []stateSizeLimiter {
+	var ssls []stateSizeLimiter
+	for _, pp := range pps {
+		if ssl, ok := pp.(stateSizeLimiter); ok {
+			ssls = append(ssls, ssl)
+		}
+	}
+	if len(ssls) == 0 {
+		return nil
+	}
+
+	maxStateSize := maxMemoryBytes / int64(len(ssls))
+	for _, ssl := range ssls {
+		ssl.setMaxStateSize(maxStateSize)
+	}
+	return ssls
+}
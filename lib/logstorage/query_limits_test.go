@@ -0,0 +1,116 @@
+package logstorage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageRunQueryWithLimits(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	cfg := &StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, cfg)
+
+	tenantIDs := []TenantID{
+		{
+			AccountID: 12,
+			ProjectID: 34,
+		},
+	}
+	storeRowsForProcessDeleteTaskTest(s, tenantIDs, time.Now().UnixNano())
+
+	runQuery := func(qStr string, limits *QueryLimits) error {
+		t.Helper()
+
+		q, err := ParseQuery(qStr)
+		if err != nil {
+			t.Fatalf("cannot parse query %q: %s", qStr, err)
+		}
+
+		var qs QueryStats
+		qctx := NewQueryContext(t.Context(), &qs, tenantIDs, q, false, nil)
+		qctx.Limits = limits
+
+		return s.RunQuery(qctx, func(_ uint, _ *DataBlock) {})
+	}
+
+	f := func(qStr string, limits *QueryLimits, errExpected string) {
+		t.Helper()
+
+		err := runQuery(qStr, limits)
+		if errExpected == "" {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			return
+		}
+		if err == nil {
+			t.Fatalf("expecting non-nil error containing %q", errExpected)
+		}
+		if !strings.Contains(err.Error(), errExpected) {
+			t.Fatalf("unexpected error; got %q; want error containing %q", err, errExpected)
+		}
+	}
+
+	// No limits
+	f("* | stats by (_msg) count()", nil, "")
+	f("* | stats by (_msg) count()", &QueryLimits{}, "")
+
+	// Limits, which aren't exceeded
+	f("* | stats by (_msg) count()", &QueryLimits{
+		MaxBytesRead:       1e9,
+		MaxBlocksProcessed: 1e6,
+		MaxMemoryBytes:     1e9,
+	}, "")
+
+	// Exceeded limits
+	f("*", &QueryLimits{
+		MaxBlocksProcessed: 1,
+	}, "max_blocks_processed=1")
+	f("*", &QueryLimits{
+		MaxBytesRead: 1,
+	}, "max_bytes_read=1")
+	f("* | stats by (_msg) count()", &QueryLimits{
+		MaxMemoryBytes: 1,
+	}, "max_memory_bytes=1")
+	f("* | sort by (_msg)", &QueryLimits{
+		MaxMemoryBytes: 1,
+	}, "max_memory_bytes=1")
+	f("* | patterns", &QueryLimits{
+		MaxMemoryBytes: 1,
+	}, "max_memory_bytes=1")
+
+	s.MustClose()
+
+	fs.MustRemoveDir(path)
+}
+
+func TestStateSizeLimit(t *testing.T) {
+	var ssl stateSizeLimit
+	ssl.initStateSizeLimit(100)
+	if ssl.isStateSizeExceeded() {
+		t.Fatalf("unexpected exceeded state size")
+	}
+
+	// The maximum state size cannot be increased.
+	ssl.setMaxStateSize(200)
+	if ssl.maxStateSize != 100 {
+		t.Fatalf("unexpected maxStateSize; got %d; want 100", ssl.maxStateSize)
+	}
+
+	ssl.setMaxStateSize(10)
+	if ssl.maxStateSize != 10 {
+		t.Fatalf("unexpected maxStateSize; got %d; want 10", ssl.maxStateSize)
+	}
+	ssl.stateSizeBudget.Add(-10)
+	if !ssl.isStateSizeExceeded() {
+		t.Fatalf("expecting exceeded state size")
+	}
+}
@@ -0,0 +1,355 @@
+package logstorage
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// QueryPlan describes how the query is executed.
+//
+// See https://docs.victoriametrics.com/victorialogs/querying/#query-plan
+type QueryPlan struct {
+	// Query is the optimized query, which is going to be executed.
+	Query string `json:"query"`
+
+	// Start is the start of the time range selected by the query in RFC3339Nano format. It is empty if the query has no lower time bound.
+	Start string `json:"start,omitempty"`
+
+	// End is the end of the time range selected by the query in RFC3339Nano format. It is empty if the query has no upper time bound.
+	End string `json:"end,omitempty"`
+
+	// Filter is the optimized filter tree of the query.
+	Filter *FilterPlan `json:"filter"`
+
+	// NeededFields contains fields, which are read from the storage and passed to pipes.
+	//
+	// Fields ending with * match all the fields with the given prefix.
+	NeededFields []string `json:"needed_fields"`
+
+	// UnneededFields contains fields, which are excluded from NeededFields.
+	UnneededFields []string `json:"unneeded_fields,omitempty"`
+
+	// Pipes contains the query pipes together with their split into remote and local parts.
+	Pipes []PipePlan `json:"pipes"`
+
+	// RemoteQuery is the query, which is executed at vlstorage nodes in VictoriaLogs cluster.
+	//
+	// The results of the remote query are processed by the local parts of Pipes at vlselect.
+	RemoteQuery string `json:"remote_query"`
+
+	// Partitions contains the estimated per-day partitions to scan.
+	//
+	// It is set only when the query is executed against the local storage.
+	Partitions []PartitionPlan `json:"partitions,omitempty"`
+
+	// Stages contains the actual per-pipe execution stats for the locally executed pipes.
+	//
+	// It is set only when the query is executed via explain with analyze=1.
+	Stages []PipeStageStats `json:"stages,omitempty"`
+
+	// QueryStats contains the actual query execution stats in the same format as `query_stats` pipe returns.
+	//
+	// It is set only when the query is executed via explain with analyze=1.
+	QueryStats map[string]uint64 `json:"query_stats,omitempty"`
+}
+
+// FilterPlan describes a single filter in the filter tree.
+type FilterPlan struct {
+	// Type is the filter type such as and, or, not, phrase, exact, time, stream, etc.
+	Type string `json:"type"`
+
+	// Filter is the string representation of the filter.
+	Filter string `json:"filter"`
+
+	// Fields contains fields, which are read by the filter.
+	Fields []string `json:"fields,omitempty"`
+
+	// Index is the index used for skipping data blocks, which cannot match the filter.
+	//
+	// It is one of bloom_filter, time_index or stream_index. It is empty if the filter needs reading the field values for every block.
+	Index string `json:"index,omitempty"`
+
+	// BloomFilterTokens contains tokens, which are searched in bloom filters.
+	BloomFilterTokens []string `json:"bloom_filter_tokens,omitempty"`
+
+	// Children contains child filters for and, or and not filters.
+	Children []*FilterPlan `json:"children,omitempty"`
+}
+
+// PipePlan describes a single pipe in the query.
+type PipePlan struct {
+	// Pipe is the string representation of the pipe.
+	Pipe string `json:"pipe"`
+
+	// Remote is the part of the pipe, which is executed at vlstorage nodes in VictoriaLogs cluster.
+	Remote string `json:"remote,omitempty"`
+
+	// Local contains the parts of the pipe, which are executed locally at vlselect in VictoriaLogs cluster.
+	Local []string `json:"local,omitempty"`
+}
+
+// PartitionPlan describes a per-day partition, which is scanned by the query.
+type PartitionPlan struct {
+	// Name is the partition name in the YYYYMMDD format.
+	Name string `json:"name"`
+
+	// Parts is the number of parts in the partition, which intersect the query time range.
+	Parts uint64 `json:"parts"`
+
+	// Blocks is the number of blocks in the selected parts.
+	Blocks uint64 `json:"blocks"`
+
+	// Rows is the number of rows in the selected parts.
+	Rows uint64 `json:"rows"`
+
+	// CompressedSizeBytes is the compressed size of the selected parts.
+	CompressedSizeBytes uint64 `json:"compressed_size_bytes"`
+}
+
+// PipeStageStats contains execution stats for a single pipe.
+type PipeStageStats struct {
+	// Pipe is the string representation of the pipe.
+	Pipe string `json:"pipe"`
+
+	// RowsIn is the number of rows passed to the pipe.
+	RowsIn uint64 `json:"rows_in"`
+
+	// RowsOut is the number of rows returned by the pipe.
+	RowsOut uint64 `json:"rows_out"`
+
+	// DurationSeconds is the total time spent in the pipe across all the workers, excluding the time spent in the next pipes.
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// NewQueryPlan returns the execution plan for q.
+//
+// The returned plan doesn't contain Partitions, Stages and QueryStats. They must be filled by the caller if needed.
+func NewQueryPlan(q *Query) *QueryPlan {
+	qp := &QueryPlan{
+		Query:  q.String(),
+		Filter: newFilterPlan(q.f),
+	}
+
+	start, end := q.GetFilterTimeRange()
+	if start != math.MinInt64 {
+		qp.Start = string(marshalTimestampRFC3339NanoString(nil, start))
+	}
+	if end != math.MaxInt64 {
+		qp.End = string(marshalTimestampRFC3339NanoString(nil, end))
+	}
+
+	pf := getNeededColumns(q.pipes)
+	qp.NeededFields = pf.GetAllowFilters()
+	qp.UnneededFields = pf.GetDenyFilters()
+
+	timestamp := q.GetTimestamp()
+	isLocal := false
+	for _, p := range q.pipes {
+		pp := PipePlan{
+			Pipe: p.String(),
+		}
+		if isLocal {
+			// All the pipes after the first locally executed pipe are executed locally.
+			pp.Local = []string{pp.Pipe}
+		} else {
+			pRemote, psLocal := p.splitToRemoteAndLocal(timestamp)
+			if pRemote != nil {
+				pp.Remote = pRemote.String()
+			}
+			for _, pLocal := range psLocal {
+				pp.Local = append(pp.Local, pLocal.String())
+			}
+			isLocal = len(psLocal) > 0
+		}
+		qp.Pipes = append(qp.Pipes, pp)
+	}
+
+	qRemote, _ := splitQueryToRemoteAndLocal(q)
+	qp.RemoteQuery = qRemote.String()
+
+	return qp
+}
+
+// SetQueryStats sets qp.QueryStats from qs and the given queryDurationNsecs.
+func (qp *QueryPlan) SetQueryStats(qs *QueryStats, queryDurationNsecs int64) {
+	m := make(map[string]uint64)
+	addUint64Entry := func(name string, value uint64) {
+		m[name] = value
+	}
+	qs.addEntries(addUint64Entry, queryDurationNsecs)
+	qp.QueryStats = m
+}
+
+func newFilterPlan(f filter) *FilterPlan {
+	fp := &FilterPlan{
+		Type:   getFilterType(f),
+		Filter: f.String(),
+	}
+
+	var pf prefixfilter.Filter
+	f.updateNeededFields(&pf)
+	fp.Fields = pf.GetAllowFilters()
+
+	switch t := f.(type) {
+	case *filterAnd:
+		for _, fc := range t.filters {
+			fp.Children = append(fp.Children, newFilterPlan(fc))
+		}
+	case *filterOr:
+		for _, fc := range t.filters {
+			fp.Children = append(fp.Children, newFilterPlan(fc))
+		}
+	case *filterNot:
+		fp.Children = append(fp.Children, newFilterPlan(t.f))
+	case *filterTime:
+		fp.Index = "time_index"
+	case *filterStream, *filterStreamID:
+		fp.Index = "stream_index"
+	case *filterIn, *filterContainsAll, *filterContainsAny:
+		fp.Index = "bloom_filter"
+	case interface{ getTokens() []string }:
+		if tokens := t.getTokens(); len(tokens) > 0 {
+			fp.Index = "bloom_filter"
+			fp.BloomFilterTokens = tokens
+		}
+	case interface{ getTokensHashes() []uint64 }:
+		if len(t.getTokensHashes()) > 0 {
+			fp.Index = "bloom_filter"
+		}
+	}
+
+	return fp
+}
+
+// getFilterType returns the type of f in snake case, e.g. "any_case_phrase" for filterAnyCasePhrase.
+func getFilterType(f filter) string {
+	s := fmt.Sprintf("%T", f)
+	if n := strings.LastIndexByte(s, '.'); n >= 0 {
+		s = s[n+1:]
+	}
+	s = strings.TrimPrefix(s, "filter")
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' {
+			if i > 0 && (s[i-1] >= 'a' && s[i-1] <= 'z' || s[i-1] >= '0' && s[i-1] <= '9') {
+				b = append(b, '_')
+			}
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// GetQueryPartitionsPlan returns the estimated partitions to scan for the given q.
+func (s *Storage) GetQueryPartitionsPlan(q *Query) []PartitionPlan {
+	minTimestamp, maxTimestamp := q.GetFilterTimeRange()
+
+	ptws, ptwsDecRef := s.getPartitionsForTimeRange(minTimestamp, maxTimestamp)
+	defer ptwsDecRef()
+
+	var pps []PartitionPlan
+	for _, ptw := range ptws {
+		pws, pwsDecRef := ptw.pt.ddb.getPartsForTimeRange(minTimestamp, maxTimestamp)
+		if len(pws) > 0 {
+			pp := PartitionPlan{
+				Name:                ptw.pt.name,
+				Parts:               uint64(len(pws)),
+				Blocks:              getBlocksCount(pws),
+				Rows:                getRowsCount(pws),
+				CompressedSizeBytes: getCompressedSize(pws),
+			}
+			pps = append(pps, pp)
+		}
+		pwsDecRef()
+	}
+	return pps
+}
+
+// QueryStageStats collects per-pipe execution stats for the query.
+//
+// It must be set to QueryContext.StageStats before the query execution.
+type QueryStageStats struct {
+	mu     sync.Mutex
+	stages []PipeStageStats
+}
+
+// GetStages returns per-pipe execution stats collected during the query execution.
+func (qss *QueryStageStats) GetStages() []PipeStageStats {
+	qss.mu.Lock()
+	defer qss.mu.Unlock()
+
+	return append([]PipeStageStats{}, qss.stages...)
+}
+
+func (qss *QueryStageStats) setStages(stages []PipeStageStats) {
+	qss.mu.Lock()
+	qss.stages = stages
+	qss.mu.Unlock()
+}
+
+// pipeStageCounters collects execution stats for a single pipe.
+type pipeStageCounters struct {
+	rowsIn atomic.Uint64
+
+	// writeBlockNsecs is the total duration of writeBlock calls for the pipe, including the time spent in the next pipes.
+	writeBlockNsecs atomic.Int64
+
+	// flushNsecs is the duration of flush call for the pipe, including the time spent in the next pipes.
+	flushNsecs int64
+}
+
+// pipeStageProcessor collects execution stats for pp.
+type pipeStageProcessor struct {
+	psc *pipeStageCounters
+	pp  pipeProcessor
+}
+
+func newPipeStageProcessor(psc *pipeStageCounters, pp pipeProcessor) pipeProcessor {
+	return &pipeStageProcessor{
+		psc: psc,
+		pp:  pp,
+	}
+}
+
+func (psp *pipeStageProcessor) writeBlock(workerID uint, br *blockResult) {
+	startTime := time.Now()
+	psp.psc.rowsIn.Add(uint64(br.rowsLen))
+	psp.pp.writeBlock(workerID, br)
+	psp.psc.writeBlockNsecs.Add(time.Since(startTime).Nanoseconds())
+}
+
+func (psp *pipeStageProcessor) flush() error {
+	return psp.pp.flush()
+}
+
+// getPipeStageStats returns per-pipe stats from pscs collected for the given pipes.
+//
+// pscs must contain an additional entry for the output of the last pipe.
+func getPipeStageStats(pipes []pipe, pscs []*pipeStageCounters) []PipeStageStats {
+	stages := make([]PipeStageStats, len(pipes))
+	for i, p := range pipes {
+		psc := pscs[i]
+		pscNext := pscs[i+1]
+
+		// Subtract the time spent in the next pipes.
+		nsecs := psc.writeBlockNsecs.Load() + psc.flushNsecs - pscNext.writeBlockNsecs.Load()
+		nsecs = max(nsecs, 0)
+
+		stages[i] = PipeStageStats{
+			Pipe:            p.String(),
+			RowsIn:          psc.rowsIn.Load(),
+			RowsOut:         pscNext.rowsIn.Load(),
+			DurationSeconds: float64(nsecs) / 1e9,
+		}
+	}
+	return stages
+}
@@ -0,0 +1,146 @@
+package logstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestGetFilterType(t *testing.T) {
+	f := func(fl filter, typeExpected string) {
+		t.Helper()
+
+		typeResult := getFilterType(fl)
+		if typeResult != typeExpected {
+			t.Fatalf("unexpected type; got %q; want %q", typeResult, typeExpected)
+		}
+	}
+
+	f(&filterAnd{}, "and")
+	f(&filterNoop{}, "noop")
+	f(&filterPhrase{}, "phrase")
+	f(&filterAnyCasePhrase{}, "any_case_phrase")
+	f(&filterStreamID{}, "stream_id")
+	f(&filterIPv4Range{}, "ipv4_range")
+}
+
+func TestNewQueryPlan(t *testing.T) {
+	f := func(qStr, resultExpected string) {
+		t.Helper()
+
+		q, err := ParseQueryAtTimestamp(qStr, 0)
+		if err != nil {
+			t.Fatalf("cannot parse [%s]: %s", qStr, err)
+		}
+		qp := NewQueryPlan(q)
+		data, err := json.Marshal(qp)
+		if err != nil {
+			t.Fatalf("cannot marshal query plan: %s", err)
+		}
+		result := string(data)
+		if result != resultExpected {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(`*`, `{"query":"*","filter":{"type":"noop","filter":"*"},"needed_fields":["*"],"pipes":null,"remote_query":"*"}`)
+
+	f(`_time:[2024-01-01Z, 2024-01-02Z) error foo:~"bar"`, `{"query":"_time:[2024-01-01Z,2024-01-02Z) error foo:~bar","start":"2024-01-01T00:00:00Z","end":"2024-01-01T23:59:59.999999999Z",`+
+		`"filter":{"type":"and","filter":"_time:[2024-01-01Z,2024-01-02Z) error foo:~bar","fields":["_msg","_time","foo"],"children":[`+
+		`{"type":"time","filter":"_time:[2024-01-01Z,2024-01-02Z)","fields":["_time"],"index":"time_index"},`+
+		`{"type":"phrase","filter":"error","fields":["_msg"],"index":"bloom_filter","bloom_filter_tokens":["error"]},`+
+		`{"type":"regexp","filter":"foo:~bar","fields":["foo"]}]},`+
+		`"needed_fields":["*"],"pipes":null,"remote_query":"_time:[2024-01-01Z,2024-01-02Z) error foo:~bar"}`)
+
+	f(`{app="nginx"} -i("Foo") | stats by (host) count() rows | sort by (rows desc) | limit 5`, `{"query":"{app=\"nginx\"} !i(Foo) | stats by (host) count(*) as rows | sort by (rows desc) limit 5",`+
+		`"filter":{"type":"and","filter":"{app=\"nginx\"} !i(Foo)","fields":["_msg","_stream"],"children":[`+
+		`{"type":"stream","filter":"{app=\"nginx\"}","fields":["_stream"],"index":"stream_index"},`+
+		`{"type":"not","filter":"!i(Foo)","fields":["_msg"],"children":[{"type":"any_case_phrase","filter":"i(Foo)","fields":["_msg"],"index":"bloom_filter"}]}]},`+
+		`"needed_fields":["host"],"pipes":[`+
+		`{"pipe":"stats by (host) count(*) as rows","remote":"stats_remote by (host) count(*) as rows","local":["stats_local by (host) import_state(rows) as rows"]},`+
+		`{"pipe":"sort by (rows desc) limit 5","local":["sort by (rows desc) limit 5"]}],`+
+		`"remote_query":"{app=\"nginx\"} !i(Foo) | stats_remote by (host) count(*) as rows | fields host, rows"}`)
+}
+
+func TestStorageQueryPlan(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+
+	sc := &StorageConfig{
+		Retention: 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, sc)
+
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	baseTimestamp := time.Now().UnixNano() - 3600*1e9
+	const rowsCount = 100
+	lr := GetLogRows(nil, nil, nil, nil, "")
+	for i := 0; i < rowsCount; i++ {
+		fields := []Field{
+			{
+				Name:  "_msg",
+				Value: fmt.Sprintf("message %d", i),
+			},
+			{
+				Name:  "host",
+				Value: fmt.Sprintf("host-%d", i%3),
+			},
+		}
+		lr.mustAdd(tenantID, baseTimestamp+int64(i), fields)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.DebugFlush()
+
+	q := mustParseQuery(`message | stats by (host) count() rows | sort by (host) | limit 2`)
+
+	// Verify partitions plan
+	pps := s.GetQueryPartitionsPlan(q)
+	if len(pps) != 1 {
+		t.Fatalf("unexpected number of partitions; got %d; want 1", len(pps))
+	}
+	if pps[0].Rows != rowsCount {
+		t.Fatalf("unexpected number of rows in the partition; got %d; want %d", pps[0].Rows, rowsCount)
+	}
+	if pps[0].Parts == 0 || pps[0].Blocks == 0 || pps[0].CompressedSizeBytes == 0 {
+		t.Fatalf("unexpected zero stats for the partition: %+v", pps[0])
+	}
+
+	// Verify stage stats
+	qctx := newTestQueryContext([]TenantID{tenantID}, q)
+	qctx.StageStats = &QueryStageStats{}
+	writeBlock := func(_ uint, _ *DataBlock) {}
+	if err := s.RunQuery(qctx, writeBlock); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stages := qctx.StageStats.GetStages()
+	for i := range stages {
+		stages[i].DurationSeconds = 0
+	}
+	stagesExpected := []PipeStageStats{
+		{
+			Pipe:    "stats by (host) count(*) as rows",
+			RowsIn:  rowsCount,
+			RowsOut: 3,
+		},
+		{
+			Pipe:    "sort by (host) limit 2",
+			RowsIn:  3,
+			RowsOut: 2,
+		},
+	}
+	if !reflect.DeepEqual(stages, stagesExpected) {
+		t.Fatalf("unexpected stages\ngot\n%+v\nwant\n%+v", stages, stagesExpected)
+	}
+
+	s.MustClose()
+	fs.MustRemoveDir(path)
+}
@@ -47,8 +47,12 @@ type QueryStats struct {
 }
 
 // GetBytesReadTotal returns the total number of bytes read, which is tracked by qs.
+//
+// It is safe calling GetBytesReadTotal concurrently with UpdateAtomic.
 func (qs *QueryStats) GetBytesReadTotal() uint64 {
-	return qs.BytesReadColumnsHeaders + qs.BytesReadColumnsHeaderIndexes + qs.BytesReadBloomFilters + qs.BytesReadValues + qs.BytesReadTimestamps + qs.BytesReadBlockHeaders
+	return atomic.LoadUint64(&qs.BytesReadColumnsHeaders) + atomic.LoadUint64(&qs.BytesReadColumnsHeaderIndexes) +
+		atomic.LoadUint64(&qs.BytesReadBloomFilters) + atomic.LoadUint64(&qs.BytesReadValues) +
+		atomic.LoadUint64(&qs.BytesReadTimestamps) + atomic.LoadUint64(&qs.BytesReadBlockHeaders)
 }
 
 // UpdateAtomic add src to qs in an atomic manner.
@@ -0,0 +1,190 @@
+package logstorage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/contextutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+)
+
+// RetentionFilter contains the retention for logs matching the given Filter at the given TenantIDs.
+//
+// See https://docs.victoriametrics.com/victorialogs/#retention-filters
+type RetentionFilter struct {
+	// TenantIDs is an optional list of tenants the Retention is applied to.
+	//
+	// The Retention is applied to all the tenants if TenantIDs is empty.
+	TenantIDs []TenantID
+
+	// Filter is an optional filter for logs the Retention is applied to.
+	//
+	// The Retention is applied to all the logs at TenantIDs if Filter is nil.
+	Filter *Filter
+
+	// Retention is the retention for logs matching TenantIDs and Filter.
+	//
+	// Logs matching TenantIDs and Filter with timestamps older than now-Retention are automatically deleted.
+	Retention time.Duration
+}
+
+// String returns human-readable representation of rf.
+func (rf *RetentionFilter) String() string {
+	tenants := "*"
+	if len(rf.TenantIDs) > 0 {
+		a := make([]string, len(rf.TenantIDs))
+		for i, tenantID := range rf.TenantIDs {
+			a[i] = fmt.Sprintf("%d:%d", tenantID.AccountID, tenantID.ProjectID)
+		}
+		tenants = strings.Join(a, ",")
+	}
+	filter := "*"
+	if s := rf.Filter.String(); s != "" {
+		filter = s
+	}
+	return fmt.Sprintf("{tenants=%q, filter=%q, retention=%s}", tenants, filter, rf.Retention)
+}
+
+// UpdateRetentionFilters replaces retention filters at s with rfs.
+//
+// The updated retention filters are applied in background shortly after the call.
+func (s *Storage) UpdateRetentionFilters(rfs []RetentionFilter) {
+	rfs = append([]RetentionFilter{}, rfs...)
+	s.retentionFilters.Store(&rfs)
+
+	// Notify the retention filters watcher about the update.
+	select {
+	case s.retentionFiltersUpdateCh <- struct{}{}:
+	default:
+	}
+}
+
+// RetentionFilters returns the retention filters, which are currently applied at s.
+func (s *Storage) RetentionFilters() []RetentionFilter {
+	rfs := s.retentionFilters.Load()
+	if rfs == nil {
+		return nil
+	}
+	return append([]RetentionFilter{}, *rfs...)
+}
+
+func (s *Storage) runRetentionFiltersWatcher() {
+	s.wg.Go(s.watchRetentionFilters)
+}
+
+func (s *Storage) watchRetentionFilters() {
+	// Do not apply retention filters immediately after the start, since the storage may be busy with other tasks at this time.
+	d := timeutil.AddJitterToDuration(time.Minute)
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-s.retentionFiltersUpdateCh:
+			t.Stop()
+		case <-t.C:
+		}
+
+		d := time.Hour
+		if !s.applyRetentionFilters() {
+			// Some of retention filters couldn't be applied at the moment because of concurrently running merges or delete tasks.
+			// Try applying them again soon.
+			d = time.Minute
+		}
+		t.Reset(timeutil.AddJitterToDuration(d))
+	}
+}
+
+// applyRetentionFilters deletes logs outside the retention configured via retention filters at s.
+//
+// false is returned if some of the retention filters couldn't be applied at the moment, so they must be applied later.
+func (s *Storage) applyRetentionFilters() bool {
+	rfs := s.RetentionFilters()
+	if len(rfs) == 0 {
+		return true
+	}
+
+	ctx, cancel := contextutil.NewStopChanContext(s.stopCh)
+	defer cancel()
+
+	ok := true
+	now := time.Now().UnixNano()
+	for i := range rfs {
+		rf := &rfs[i]
+		if !s.applyRetentionFilter(ctx, rf, now) {
+			ok = false
+		}
+		if needStop(s.stopCh) {
+			return false
+		}
+	}
+	return ok
+}
+
+// applyRetentionFilter deletes logs matching rf, which are older than now-rf.Retention.
+//
+// false is returned if the logs couldn't be deleted at the moment, so the deletion must be retried later.
+func (s *Storage) applyRetentionFilter(ctx context.Context, rf *RetentionFilter, now int64) bool {
+	if rf.Retention >= s.retention {
+		// Logs outside the global retention are dropped together with per-day partitions.
+		return true
+	}
+
+	minTimestamp := int64(math.MinInt64)
+	maxTimestamp := now - rf.Retention.Nanoseconds() - 1
+	if !s.hasPartitionsForTimeRange(minTimestamp, maxTimestamp) {
+		// Fast path - there are no logs older than rf.Retention.
+		return true
+	}
+
+	tenantIDs := rf.TenantIDs
+	if len(tenantIDs) == 0 {
+		a, err := s.getTenantIDs(ctx, minTimestamp, maxTimestamp)
+		if err != nil {
+			logger.Errorf("cannot obtain tenants for the retention filter %s: %s", rf, err)
+			return false
+		}
+		tenantIDs = a
+	}
+	if len(tenantIDs) == 0 {
+		return true
+	}
+
+	var f filter = &filterNoop{}
+	if rf.Filter != nil && rf.Filter.f != nil {
+		f = rf.Filter.f
+	}
+	q := &Query{
+		f:         f,
+		timestamp: now,
+	}
+	q.AddTimeFilter(minTimestamp, maxTimestamp)
+
+	startTime := time.Now()
+	ok, err := s.deleteRowsByQuery(ctx, tenantIDs, q)
+	if err != nil {
+		logger.Errorf("cannot apply the retention filter %s: %s", rf, err)
+		return false
+	}
+	if !ok {
+		if !needStop(s.stopCh) {
+			logger.Warnf("cannot apply the retention filter %s in %.3f seconds; retrying it later", rf, time.Since(startTime).Seconds())
+		}
+		return false
+	}
+
+	return true
+}
+
+func (s *Storage) hasPartitionsForTimeRange(minTimestamp, maxTimestamp int64) bool {
+	ptws, ptwsDecRef := s.getPartitionsForTimeRange(minTimestamp, maxTimestamp)
+	defer ptwsDecRef()
+
+	return len(ptws) > 0
+}
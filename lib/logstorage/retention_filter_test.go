@@ -0,0 +1,92 @@
+package logstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageApplyRetentionFilter(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+	ctx := t.Context()
+
+	cfg := &StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+	}
+	s := MustOpenStorage(path, cfg)
+
+	now := time.Now().UnixNano()
+
+	allTenantIDs := []TenantID{
+		{
+			AccountID: 0,
+			ProjectID: 100,
+		},
+		{
+			AccountID: 123,
+			ProjectID: 0,
+		},
+		{
+			AccountID: 123,
+			ProjectID: 456,
+		},
+	}
+
+	storeRowsForProcessDeleteTaskTest(s, allTenantIDs, now)
+
+	check := func(tenantIDs []TenantID, qStr string, rowsExpected []string) {
+		t.Helper()
+		checkQueryResults(t, s, tenantIDs, qStr, nil, rowsExpected)
+	}
+
+	applyRetentionFilter := func(tenantIDs []TenantID, filter string, retention time.Duration) {
+		t.Helper()
+
+		rf := &RetentionFilter{
+			TenantIDs: tenantIDs,
+			Retention: retention,
+		}
+		if filter != "" {
+			f, err := ParseFilter(filter)
+			if err != nil {
+				t.Fatalf("cannot parse filter %q: %s", filter, err)
+			}
+			rf.Filter = f
+		}
+		for !s.applyRetentionFilter(ctx, rf, now) {
+			// Unsuccessful attempt because of concurrently executed background merges.
+			// Wait for a bit and try again.
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	check(allTenantIDs, "* | count(host) rows", []string{`{"rows":"10500"}`})
+
+	// The retention filter with the retention exceeding the global retention must be no-op.
+	applyRetentionFilter(nil, "", 60*24*time.Hour)
+	check(allTenantIDs, "* | count(host) rows", []string{`{"rows":"10500"}`})
+
+	// Apply the retention filter to the particular tenant
+	applyRetentionFilter([]TenantID{allTenantIDs[0]}, "", 3*24*time.Hour)
+	check([]TenantID{allTenantIDs[0]}, "* | count(host) rows", []string{`{"rows":"2000"}`})
+	check([]TenantID{allTenantIDs[1]}, "* | count(host) rows", []string{`{"rows":"3500"}`})
+	check([]TenantID{allTenantIDs[2]}, "* | count(host) rows", []string{`{"rows":"3500"}`})
+
+	// Apply the retention filter to the particular log stream at the particular tenant
+	applyRetentionFilter([]TenantID{allTenantIDs[2]}, `{host="host-1"}`, 24*time.Hour)
+	check([]TenantID{allTenantIDs[2]}, "* | count(host) rows", []string{`{"rows":"3000"}`})
+	check([]TenantID{allTenantIDs[2]}, `{host="host-1"} | count(host) rows`, []string{`{"rows":"200"}`})
+	check([]TenantID{allTenantIDs[1]}, "* | count(host) rows", []string{`{"rows":"3500"}`})
+
+	// Apply the retention filter to all the tenants
+	applyRetentionFilter(nil, "row_id:=42", 24*time.Hour)
+	check(allTenantIDs, "row_id:=42 | count(host) rows", []string{`{"rows":"30"}`})
+	check(allTenantIDs, "* | count(host) rows", []string{`{"rows":"8445"}`})
+
+	s.MustClose()
+
+	fs.MustRemoveDir(path)
+}
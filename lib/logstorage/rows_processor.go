@@ -0,0 +1,416 @@
+package logstorage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/prefixfilter"
+)
+
+// RowsProcessor applies LogsQL pipes to log rows before they are stored.
+//
+// It supports only pipes, which process every log row independently of other log rows.
+type RowsProcessor struct {
+	pipes []pipe
+
+	// needStream is set to true if the pipes need the _stream field.
+	needStream bool
+
+	// keepTime is set to true if all the pipes pass the _time field to the output.
+	//
+	// Otherwise rows with distinct timestamps cannot be processed in a single block,
+	// since the original timestamps cannot be restored for the resulting rows.
+	keepTime bool
+}
+
+// ParseRowsProcessor parses LogsQL pipes from s and returns RowsProcessor for them.
+//
+// For example, `filter level:!debug | delete password`.
+func ParseRowsProcessor(s string) (*RowsProcessor, error) {
+	timestamp := time.Now().UnixNano()
+	lex := newLexer(s, timestamp)
+	pipes, err := parsePipes(lex)
+	if err != nil {
+		return nil, fmt.Errorf("%w; context: [%s]", err, lex.context())
+	}
+	if !lex.isEnd() {
+		return nil, fmt.Errorf("unexpected unparsed tail; context: [%s]; tail: [%s]", lex.context(), lex.rawToken+lex.s)
+	}
+	for _, p := range pipes {
+		if !isRowsProcessorPipe(p) {
+			return nil, fmt.Errorf("unsupported pipe [%s]; it must process every log entry independently of other log entries", p)
+		}
+		if p.hasFilterInWithQuery() {
+			return nil, fmt.Errorf("unsupported pipe [%s]; subqueries aren't allowed", p)
+		}
+	}
+
+	pf := getNeededColumns(pipes)
+	rp := &RowsProcessor{
+		pipes:      pipes,
+		needStream: pf.MatchString("_stream"),
+		keepTime:   pipesKeepTime(pipes),
+	}
+	return rp, nil
+}
+
+// pipesKeepTime returns true if every pipe from pipes passes the input _time field to the output.
+//
+// It returns false if some pipe drops or overwrites the _time field.
+func pipesKeepTime(pipes []pipe) bool {
+	for _, p := range pipes {
+		var pf prefixfilter.Filter
+		pf.AddAllowFilter("_time")
+		p.updateNeededFields(&pf)
+		if !pf.MatchString("_time") {
+			return false
+		}
+	}
+	return true
+}
+
+// isRowsProcessorPipe returns true if p can be used in RowsProcessor.
+func isRowsProcessorPipe(p pipe) bool {
+	switch p.(type) {
+	case *pipeCollapseNums,
+		*pipeCopy,
+		*pipeDecolorize,
+		*pipeDelete,
+		*pipeDropEmptyFields,
+		*pipeExtract,
+		*pipeExtractRegexp,
+		*pipeFields,
+		*pipeFilter,
+		*pipeFormat,
+		*pipeGrok,
+		*pipeHash,
+		*pipeJSONArrayLen,
+		*pipeLen,
+		*pipeMath,
+		*pipePackJSON,
+		*pipePackLogfmt,
+		*pipeRename,
+		*pipeReplace,
+		*pipeReplaceRegexp,
+		*pipeSplit,
+		*pipeTimeAdd,
+		*pipeUnpackJSON,
+		*pipeUnpackLogfmt,
+		*pipeUnpackSyslog,
+		*pipeUnpackWords,
+		*pipeUnroll:
+		return true
+	default:
+		return false
+	}
+}
+
+// String returns string representation of rp.
+func (rp *RowsProcessor) String() string {
+	a := make([]string, len(rp.pipes))
+	for i, p := range rp.pipes {
+		a[i] = p.String()
+	}
+	return strings.Join(a, " | ")
+}
+
+// ProcessRows applies rp pipes to rows from lr and calls addRow for every resulting row.
+//
+// See RowsProcessorContext.AddRow for details.
+func (rp *RowsProcessor) ProcessRows(lr *LogRows, addRow func(streamHash uint64, r *InsertRow)) error {
+	ctx := rp.NewContext(addRow)
+	lr.ForEachRow(ctx.AddRow)
+	return ctx.Flush()
+}
+
+// rowsProcessorMaxBlockRows is the maximum number of rows, which are passed to pipes in a single block.
+const rowsProcessorMaxBlockRows = 1024
+
+// RowsProcessorContext applies RowsProcessor pipes to the added rows.
+//
+// Rows are collected into blocks with the same tenant, log stream and field names, and every block is passed to pipes at once.
+//
+// It must be used from a single goroutine.
+type RowsProcessorContext struct {
+	rp *RowsProcessor
+
+	// pps contains pipe processors for rp.pipes.
+	pps []pipeProcessor
+
+	// rpo is the last pipe processor, which passes the resulting rows to addRow.
+	rpo *rowsProcessorOutput
+
+	// a holds copies of the added rows until they are passed to pipes.
+	a arena
+
+	// blocks contains pending blocks in the order of their creation.
+	blocks []*rowsProcessorBlock
+
+	// blocksByKey contains pending blocks by their keys.
+	blocksByKey map[string]*rowsProcessorBlock
+
+	// blocksFree contains blocks, which can be re-used.
+	blocksFree []*rowsProcessorBlock
+
+	keyBuf []byte
+	br     blockResult
+
+	// streamTagsCanonical and streamStr cache the _stream value for the last processed block.
+	streamTagsCanonical string
+	streamStr           string
+}
+
+// rowsProcessorBlock contains rows with the same tenant, log stream and field names, which are passed to pipes at once.
+type rowsProcessorBlock struct {
+	// key is the key of the block at RowsProcessorContext.blocksByKey.
+	key string
+
+	tenantID            TenantID
+	streamHash          uint64
+	streamTagsCanonical string
+
+	fieldNames   []string
+	columnValues [][]string
+	timestamps   []int64
+}
+
+func (b *rowsProcessorBlock) reset() {
+	b.key = ""
+	b.tenantID.Reset()
+	b.streamHash = 0
+	b.streamTagsCanonical = ""
+
+	clear(b.fieldNames)
+	b.fieldNames = b.fieldNames[:0]
+
+	for i := range b.columnValues {
+		clear(b.columnValues[i])
+		b.columnValues[i] = b.columnValues[i][:0]
+	}
+	b.columnValues = b.columnValues[:0]
+
+	b.timestamps = b.timestamps[:0]
+}
+
+// NewContext returns new RowsProcessorContext, which calls addRow for every resulting row.
+//
+// Call Flush on the returned context when all the rows are added.
+func (rp *RowsProcessor) NewContext(addRow func(streamHash uint64, r *InsertRow)) *RowsProcessorContext {
+	rpo := &rowsProcessorOutput{
+		addRow: addRow,
+	}
+
+	stopCh := make(chan struct{})
+	cancel := func() {}
+	pp := pipeProcessor(rpo)
+	pps := make([]pipeProcessor, len(rp.pipes))
+	for i := len(rp.pipes) - 1; i >= 0; i-- {
+		pp = rp.pipes[i].newPipeProcessor(1, stopCh, cancel, pp)
+		pps[i] = pp
+	}
+
+	return &RowsProcessorContext{
+		rp:          rp,
+		pps:         pps,
+		rpo:         rpo,
+		blocksByKey: make(map[string]*rowsProcessorBlock),
+	}
+}
+
+// AddRow adds r to ctx.
+//
+// The pipes are applied to r either when the block with r becomes full or at Flush call.
+// Then addRow passed to NewContext is called for every resulting row.
+//
+// The resulting rows keep the tenant, the streamHash and the log stream of r.
+// The resulting rows may be passed to addRow in the order, which differs from the order of the added rows.
+// The timestamp of the resulting row is taken from the _time field if it is modified by pipes.
+//
+// r is copied, so the caller may re-use it after returning from AddRow.
+func (ctx *RowsProcessorContext) AddRow(streamHash uint64, r *InsertRow) {
+	b := ctx.getBlock(streamHash, r)
+
+	a := &ctx.a
+	for i, f := range r.Fields {
+		b.columnValues[i] = append(b.columnValues[i], a.copyString(f.Value))
+	}
+	b.timestamps = append(b.timestamps, r.Timestamp)
+
+	if len(b.timestamps) >= rowsProcessorMaxBlockRows {
+		ctx.flushBlock(b)
+	}
+}
+
+// getBlock returns the pending block for r with the given streamHash.
+//
+// A new block is created if there is no pending block for r.
+func (ctx *RowsProcessorContext) getBlock(streamHash uint64, r *InsertRow) *rowsProcessorBlock {
+	key := r.TenantID.marshal(ctx.keyBuf[:0])
+	key = encoding.MarshalUint64(key, streamHash)
+	if !ctx.rp.keepTime {
+		key = encoding.MarshalInt64(key, r.Timestamp)
+	}
+	for _, f := range r.Fields {
+		key = encoding.MarshalBytes(key, bytesutil.ToUnsafeBytes(f.Name))
+	}
+	ctx.keyBuf = key
+
+	if b := ctx.blocksByKey[string(key)]; b != nil {
+		return b
+	}
+
+	var b *rowsProcessorBlock
+	if n := len(ctx.blocksFree); n > 0 {
+		b = ctx.blocksFree[n-1]
+		ctx.blocksFree = ctx.blocksFree[:n-1]
+	} else {
+		b = &rowsProcessorBlock{}
+	}
+
+	a := &ctx.a
+	b.key = a.copyBytesToString(key)
+	b.tenantID = r.TenantID
+	b.streamHash = streamHash
+	b.streamTagsCanonical = a.copyString(r.StreamTagsCanonical)
+	for _, f := range r.Fields {
+		b.fieldNames = append(b.fieldNames, a.copyString(f.Name))
+	}
+	b.columnValues = slicesutil.SetLength(b.columnValues, len(r.Fields))
+
+	ctx.blocks = append(ctx.blocks, b)
+	ctx.blocksByKey[b.key] = b
+	return b
+}
+
+// flushBlock passes rows from b to pipes and releases b.
+func (ctx *RowsProcessorContext) flushBlock(b *rowsProcessorBlock) {
+	ctx.processBlock(b)
+
+	delete(ctx.blocksByKey, b.key)
+
+	idx := slices.Index(ctx.blocks, b)
+	ctx.blocks = slices.Delete(ctx.blocks, idx, idx+1)
+
+	b.reset()
+	ctx.blocksFree = append(ctx.blocksFree, b)
+}
+
+// processBlock passes rows from b to pipes.
+func (ctx *RowsProcessorContext) processBlock(b *rowsProcessorBlock) {
+	rpo := ctx.rpo
+	rpo.streamHash = b.streamHash
+	rpo.r.TenantID = b.tenantID
+	rpo.r.StreamTagsCanonical = b.streamTagsCanonical
+
+	// The last timestamp in the block is used for the resulting rows without valid _time field.
+	// All the rows in the block have the same timestamp if pipes may drop or overwrite the _time field.
+	rpo.r.Timestamp = b.timestamps[len(b.timestamps)-1]
+
+	br := &ctx.br
+	br.reset()
+	br.rowsLen = len(b.timestamps)
+	for i, name := range b.fieldNames {
+		br.addResultColumn(resultColumn{
+			name:   getCanonicalColumnName(name),
+			values: b.columnValues[i],
+		})
+	}
+	br.timestampsBuf = append(br.timestampsBuf[:0], b.timestamps...)
+	br.addTimeColumn()
+	if ctx.rp.needStream {
+		if b.streamTagsCanonical != ctx.streamTagsCanonical {
+			ctx.streamTagsCanonical = b.streamTagsCanonical
+			ctx.streamStr = getStreamTagsString(b.streamTagsCanonical)
+		}
+		br.addResultColumnConst(resultColumn{
+			name:   "_stream",
+			values: []string{ctx.streamStr},
+		})
+	}
+
+	if len(ctx.pps) > 0 {
+		ctx.pps[0].writeBlock(0, br)
+	} else {
+		rpo.writeBlock(0, br)
+	}
+}
+
+// Flush passes all the pending rows to pipes and flushes the pipes at ctx.
+//
+// ctx cannot be used after the Flush call.
+func (ctx *RowsProcessorContext) Flush() error {
+	for _, b := range ctx.blocks {
+		ctx.processBlock(b)
+	}
+	ctx.blocks = nil
+	ctx.blocksByKey = nil
+	ctx.a.reset()
+
+	// Supported pipes pass every row to the next pipe inside writeBlock, so flush cannot produce new rows.
+	var errFlush error
+	for _, pp := range ctx.pps {
+		if err := pp.flush(); err != nil && errFlush == nil {
+			errFlush = err
+		}
+	}
+	return errFlush
+}
+
+// rowsProcessorOutput is the last pipeProcessor in RowsProcessor, which converts the resulting rows into InsertRow.
+type rowsProcessorOutput struct {
+	addRow func(streamHash uint64, r *InsertRow)
+
+	// streamHash and r contain the properties of the currently processed input block.
+	streamHash uint64
+	r          InsertRow
+
+	db DataBlock
+}
+
+func (rpo *rowsProcessorOutput) writeBlock(_ uint, br *blockResult) {
+	if br.rowsLen == 0 {
+		return
+	}
+
+	db := &rpo.db
+	db.initFromBlockResult(br)
+
+	r := &rpo.r
+	for rowIdx := 0; rowIdx < br.rowsLen; rowIdx++ {
+		timestamp := r.Timestamp
+		fields := r.Fields[:0]
+		for _, c := range db.Columns {
+			v := c.Values[rowIdx]
+			switch c.Name {
+			case "_time":
+				if ts, ok := TryParseTimestampRFC3339Nano(v); ok {
+					timestamp = ts
+				}
+				continue
+			case "_stream", "_stream_id":
+				// These fields are derived from the log stream, which cannot be changed by pipes.
+				continue
+			}
+			fields = append(fields, Field{
+				Name:  getCanonicalFieldName(c.Name),
+				Value: v,
+			})
+		}
+
+		origTimestamp := r.Timestamp
+		r.Fields = fields
+		r.Timestamp = timestamp
+		rpo.addRow(rpo.streamHash, r)
+		r.Timestamp = origTimestamp
+	}
+}
+
+func (rpo *rowsProcessorOutput) flush() error {
+	return nil
+}
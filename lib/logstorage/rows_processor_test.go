@@ -0,0 +1,223 @@
+package logstorage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseRowsProcessorSuccess(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		rp, err := ParseRowsProcessor(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := rp.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected result; got %q; want %q", result, resultExpected)
+		}
+	}
+
+	f(`filter level:!debug`, `filter !level:debug`)
+	f(`level:!debug`, `filter !level:debug`)
+	f(`drop password | rename host as hostname`, `delete password | rename host as hostname`)
+	f(`replace_regexp ("password=[^ ]+", "password=***")`, `replace_regexp ("password=[^ ]+", "password=***")`)
+	f(`unpack_json from _msg | fields _msg, level`, `unpack_json | fields _msg, level`)
+}
+
+func TestParseRowsProcessorFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		rp, err := ParseRowsProcessor(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if rp != nil {
+			t.Fatalf("expecting nil rp")
+		}
+	}
+
+	// invalid pipes
+	f(``)
+	f(`rename foo`)
+	f(`filter foo |`)
+
+	// pipes, which depend on multiple log entries
+	f(`stats count()`)
+	f(`sort by (_time)`)
+	f(`limit 10`)
+	f(`uniq by (host)`)
+
+	// subqueries
+	f(`filter host:in(* | fields host)`)
+}
+
+func TestRowsProcessorProcessRows(t *testing.T) {
+	f := func(s string, rows []string, resultExpected []string) {
+		t.Helper()
+
+		rp, err := ParseRowsProcessor(s)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s, err)
+		}
+
+		lr := GetLogRows([]string{"host"}, nil, nil, nil, "")
+		defer PutLogRows(lr)
+
+		tid := TenantID{
+			AccountID: 123,
+			ProjectID: 456,
+		}
+		p := GetJSONParser()
+		defer PutJSONParser(p)
+		for i, r := range rows {
+			if err := p.ParseLogMessage([]byte(r), nil); err != nil {
+				t.Fatalf("unexpected error when parsing %q: %s", r, err)
+			}
+			timestamp := int64(i+1) * 1_000_000_000
+			lr.MustAdd(tid, timestamp, p.Fields, -1)
+		}
+
+		var result []string
+		err = rp.ProcessRows(lr, func(_ uint64, r *InsertRow) {
+			if r.TenantID != tid {
+				t.Fatalf("unexpected tenant; got %s; want %s", r.TenantID, tid)
+			}
+			s := string(marshalTimestampRFC3339NanoString(nil, r.Timestamp)) + " " + getStreamTagsString(r.StreamTagsCanonical) + " " + string(MarshalFieldsToJSON(nil, r.Fields))
+			result = append(result, s)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	rows := []string{
+		`{"_msg":"foo password=secret","host":"a","level":"info"}`,
+		`{"_msg":"bar","host":"b","level":"debug"}`,
+		`{"_msg":"{\"x\":\"y\"}","host":"a"}`,
+	}
+
+	// filter
+	f(`filter level:!debug`, rows, []string{
+		`1970-01-01T00:00:01Z {host="a"} {"_msg":"foo password=secret","host":"a","level":"info"}`,
+		`1970-01-01T00:00:03Z {host="a"} {"_msg":"{\"x\":\"y\"}","host":"a"}`,
+	})
+
+	// stream filter
+	f(`filter {host="b"}`, rows, []string{
+		`1970-01-01T00:00:02Z {host="b"} {"_msg":"bar","host":"b","level":"debug"}`,
+	})
+
+	// redact secrets and drop fields
+	f(`replace_regexp ("password=[^ ]+", "password=***") | delete level`, rows, []string{
+		`1970-01-01T00:00:01Z {host="a"} {"_msg":"foo password=***","host":"a"}`,
+		`1970-01-01T00:00:02Z {host="b"} {"_msg":"bar","host":"b"}`,
+		`1970-01-01T00:00:03Z {host="a"} {"_msg":"{\"x\":\"y\"}","host":"a"}`,
+	})
+
+	// unpack json and rename fields
+	f(`filter host:=a | unpack_json | rename x as z | fields _msg, z`, rows, []string{
+		`1970-01-01T00:00:01Z {host="a"} {"_msg":"foo password=secret"}`,
+		`1970-01-01T00:00:03Z {host="a"} {"_msg":"{\"x\":\"y\"}","z":"y"}`,
+	})
+
+	// modify _time
+	f(`filter level:=info | time_add 1h`, rows, []string{
+		`1970-01-01T01:00:01Z {host="a"} {"_msg":"foo password=secret","host":"a","level":"info"}`,
+	})
+
+	// unroll into multiple rows
+	f(`filter host:=b | format '["x","y"]' as items | unroll items | fields items`, rows, []string{
+		`1970-01-01T00:00:02Z {host="b"} {"items":"x"}`,
+		`1970-01-01T00:00:02Z {host="b"} {"items":"y"}`,
+	})
+
+	rowsSameFields := []string{
+		`{"_msg":"a1","host":"a"}`,
+		`{"_msg":"a2","host":"a"}`,
+		`{"_msg":"b1","host":"b"}`,
+		`{"_msg":"a3","host":"a"}`,
+	}
+
+	// rows with the same log stream and fields are processed in a single block
+	f(`format "<_msg>!" as _msg`, rowsSameFields, []string{
+		`1970-01-01T00:00:01Z {host="a"} {"_msg":"a1!","host":"a"}`,
+		`1970-01-01T00:00:02Z {host="a"} {"_msg":"a2!","host":"a"}`,
+		`1970-01-01T00:00:04Z {host="a"} {"_msg":"a3!","host":"a"}`,
+		`1970-01-01T00:00:03Z {host="b"} {"_msg":"b1!","host":"b"}`,
+	})
+
+	// the original timestamps are preserved if pipes drop the _time field
+	f(`fields _msg`, rowsSameFields, []string{
+		`1970-01-01T00:00:01Z {host="a"} {"_msg":"a1"}`,
+		`1970-01-01T00:00:02Z {host="a"} {"_msg":"a2"}`,
+		`1970-01-01T00:00:03Z {host="b"} {"_msg":"b1"}`,
+		`1970-01-01T00:00:04Z {host="a"} {"_msg":"a3"}`,
+	})
+}
+
+func TestRowsProcessorProcessRowsManyRows(t *testing.T) {
+	rp, err := ParseRowsProcessor(`filter _msg:~"[02468]$" | format "<_msg>!" as _msg`)
+	if err != nil {
+		t.Fatalf("cannot parse pipes: %s", err)
+	}
+
+	lr := GetLogRows([]string{"host"}, nil, nil, nil, "")
+	defer PutLogRows(lr)
+
+	const rowsCount = 3*rowsProcessorMaxBlockRows + 10
+	for i := 0; i < rowsCount; i++ {
+		fields := []Field{
+			{Name: "host", Value: "a"},
+			{Name: "_msg", Value: fmt.Sprintf("%d", i)},
+		}
+		lr.MustAdd(TenantID{}, int64(i), fields, -1)
+	}
+
+	var result []string
+	err = rp.ProcessRows(lr, func(_ uint64, r *InsertRow) {
+		result = append(result, fmt.Sprintf("%d %s", r.Timestamp, MarshalFieldsToJSON(nil, r.Fields)))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var resultExpected []string
+	for i := 0; i < rowsCount; i += 2 {
+		resultExpected = append(resultExpected, fmt.Sprintf(`%d {"host":"a","_msg":"%d!"}`, i, i))
+	}
+	if !reflect.DeepEqual(result, resultExpected) {
+		t.Fatalf("unexpected result\ngot\n%q\nwant\n%q", result, resultExpected)
+	}
+}
+
+func TestPipesKeepTime(t *testing.T) {
+	f := func(s string, resultExpected bool) {
+		t.Helper()
+
+		rp, err := ParseRowsProcessor(s)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s, err)
+		}
+		if rp.keepTime != resultExpected {
+			t.Fatalf("unexpected keepTime for %q; got %v; want %v", s, rp.keepTime, resultExpected)
+		}
+	}
+
+	f(`filter level:!debug`, true)
+	f(`delete password | rename host as hostname`, true)
+	f(`time_add 1h`, true)
+	f(`unpack_json | unroll items`, true)
+	f(`fields _msg, _time`, true)
+
+	f(`fields _msg`, false)
+	f(`delete _time`, false)
+	f(`rename _time as ts`, false)
+	f(`format "<foo>" as _time`, false)
+}
@@ -0,0 +1,158 @@
+package logstorage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var queryTempDir atomic.Pointer[string]
+
+// SetQueryTempDir sets the directory for temporary files, which are used by `sort` and `stats` pipes
+// when their state doesn't fit the memory limit.
+//
+// The directory is created if it is missing. The existing contents of the directory is removed,
+// since it may contain temporary files left after unclean shutdown.
+//
+// Empty dir disables spilling the state of pipes to disk.
+func SetQueryTempDir(dir string) {
+	if dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Panicf("FATAL: cannot remove the previous contents of %q: %s", dir, err)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logger.Panicf("FATAL: cannot create %q: %s", dir, err)
+		}
+	}
+	queryTempDir.Store(&dir)
+}
+
+func getQueryTempDir() string {
+	p := queryTempDir.Load()
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+var (
+	spillFilesCreated = metrics.NewCounter(`vl_query_spill_files_created_total`)
+	spillBytesWritten = metrics.NewCounter(`vl_query_spill_bytes_written_total`)
+)
+
+// spillFile is a temporary file for storing the state of pipes, which doesn't fit the memory limit.
+//
+// spillFile consists of length-prefixed records. Records are written sequentially and can be read back
+// via readers for the given byte ranges.
+type spillFile struct {
+	f  *os.File
+	bw *bufio.Writer
+
+	// size is the number of bytes written to the file.
+	size int64
+
+	lenBuf []byte
+}
+
+// newSpillFile creates new spillFile at the directory set via SetQueryTempDir.
+//
+// The caller must call mustClose when the file is no longer needed.
+func newSpillFile() (*spillFile, error) {
+	dir := getQueryTempDir()
+	if dir == "" {
+		return nil, fmt.Errorf("BUG: the directory for temporary files isn't set")
+	}
+	f, err := os.CreateTemp(dir, "spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary file: %w", err)
+	}
+	spillFilesCreated.Inc()
+
+	sf := &spillFile{
+		f:  f,
+		bw: bufio.NewWriterSize(f, 64*1024),
+	}
+	return sf, nil
+}
+
+// writeRecord appends the record with the given data to sf.
+func (sf *spillFile) writeRecord(data []byte) error {
+	sf.lenBuf = binary.AppendUvarint(sf.lenBuf[:0], uint64(len(data)))
+	if _, err := sf.bw.Write(sf.lenBuf); err != nil {
+		return fmt.Errorf("cannot write to temporary file %q: %w", sf.f.Name(), err)
+	}
+	if _, err := sf.bw.Write(data); err != nil {
+		return fmt.Errorf("cannot write to temporary file %q: %w", sf.f.Name(), err)
+	}
+	n := len(sf.lenBuf) + len(data)
+	sf.size += int64(n)
+	spillBytesWritten.Add(n)
+	return nil
+}
+
+// flush flushes the buffered records to the file, so they can be read via newReader.
+func (sf *spillFile) flush() error {
+	if err := sf.bw.Flush(); err != nil {
+		return fmt.Errorf("cannot write to temporary file %q: %w", sf.f.Name(), err)
+	}
+	return nil
+}
+
+// newReader returns a reader for the records stored in the byte range [start, end) of sf.
+//
+// flush must be called before reading the records.
+func (sf *spillFile) newReader(start, end int64) *spillReader {
+	r := io.NewSectionReader(sf.f, start, end-start)
+	return &spillReader{
+		path: sf.f.Name(),
+		br:   bufio.NewReaderSize(r, 16*1024),
+	}
+}
+
+// mustClose closes and removes sf.
+func (sf *spillFile) mustClose() {
+	path := sf.f.Name()
+	if err := sf.f.Close(); err != nil {
+		logger.Panicf("FATAL: cannot close temporary file %q: %s", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		logger.Panicf("FATAL: cannot remove temporary file %q: %s", path, err)
+	}
+}
+
+// spillReader reads records from spillFile.
+type spillReader struct {
+	path string
+	br   *bufio.Reader
+	buf  []byte
+}
+
+// readRecord returns the next record.
+//
+// The returned data is valid until the next readRecord call. io.EOF is returned when there are no more records.
+func (sr *spillReader) readRecord() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr.br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("cannot read record length from temporary file %q: %w", sr.path, err)
+	}
+	if n > 1<<30 {
+		return nil, fmt.Errorf("too big record length read from temporary file %q: %d bytes", sr.path, n)
+	}
+	if uint64(cap(sr.buf)) < n {
+		sr.buf = make([]byte, n)
+	}
+	sr.buf = sr.buf[:n]
+	if _, err := io.ReadFull(sr.br, sr.buf); err != nil {
+		return nil, fmt.Errorf("cannot read record from temporary file %q: %w", sr.path, err)
+	}
+	return sr.buf, nil
+}
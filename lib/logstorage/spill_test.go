@@ -0,0 +1,185 @@
+package logstorage
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestPipeSortSpill(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+
+		rows := newTestSpillRows(30_000)
+		rowsExpected := runTestSpillPipe(t, pipeStr, rows, false)
+		rowsResult := runTestSpillPipe(t, pipeStr, rows, true)
+
+		// The order of rows must be preserved
+		if len(rowsResult) != len(rowsExpected) {
+			t.Fatalf("unexpected number of rows; got %d; want %d", len(rowsResult), len(rowsExpected))
+		}
+		for i := range rowsResult {
+			if got, want := rowToString(rowsResult[i]), rowToString(rowsExpected[i]); got != want {
+				t.Fatalf("unexpected row #%d\ngot\n%s\nwant\n%s", i, got, want)
+			}
+		}
+	}
+
+	f(`sort by (n)`)
+	f(`sort by (n desc)`)
+	f(`sort by (s, n) desc`)
+	f(`sort by (_time desc) rank as position`)
+	f(`sort by (n) offset 12345`)
+	f(`sort`)
+}
+
+func TestPipeStatsSpill(t *testing.T) {
+	f := func(pipeStr string) {
+		t.Helper()
+
+		rows := newTestSpillRows(30_000)
+		rowsExpected := runTestSpillPipe(t, pipeStr, rows, false)
+		rowsResult := runTestSpillPipe(t, pipeStr, rows, true)
+		assertRowsEqual(t, rowsResult, rowsExpected)
+	}
+
+	f(`stats by (user_id) count() rows, sum(n) total, count_uniq(s) uniqs`)
+	f(`stats by (user_id, host) min(n), max(n), values(host)`)
+	f(`stats by (n) count(), count_uniq(s, host)`)
+	f(`stats by (_time:1h, host) count_uniq(user_id) users, uniq_values(s) values`)
+}
+
+func TestPipeStatsSpillCleanupOnError(t *testing.T) {
+	dir := t.TempDir()
+	SetQueryTempDir(dir)
+	defer SetQueryTempDir("")
+
+	pipeStr := `stats by (user_id) count() rows, count_uniq(s) uniqs`
+	lex := newLexer(pipeStr, 0)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	workersCount := 3
+	stopCh := make(chan struct{})
+	cancel := func() {}
+	pp := p.newPipeProcessor(workersCount, stopCh, cancel, newTestPipeProcessor())
+	pp.(stateSizeLimiter).setMaxStateSize(2 * stateSizeBudgetChunk)
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range newTestSpillRows(30_000) {
+		brw.writeRow(row)
+	}
+	brw.flush()
+
+	psp := pp.(*pipeStatsProcessor)
+	if !psp.spill.hasSpilled() {
+		t.Fatalf("expecting spilled state for [%s]", pipeStr)
+	}
+
+	psp.setError(fmt.Errorf("some error"))
+	if err := pp.flush(); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("cannot read %q: %s", dir, err)
+	}
+	if len(des) > 0 {
+		t.Fatalf("unexpected temporary files left at %q: %d", dir, len(des))
+	}
+}
+
+func newTestSpillRows(rowsCount int) [][]Field {
+	r := rand.New(rand.NewSource(1))
+	rows := make([][]Field, rowsCount)
+	for i := range rows {
+		rows[i] = []Field{
+			{
+				Name:  "_time",
+				Value: fmt.Sprintf("2024-01-01T%02d:%02d:%02d.%09dZ", i/3600%24, i/60%60, i%60, i),
+			},
+			{
+				Name:  "n",
+				Value: fmt.Sprintf("%d", i*7919%rowsCount),
+			},
+			{
+				Name:  "s",
+				Value: fmt.Sprintf("value_%d_%064d", i, r.Int63()),
+			},
+			{
+				Name:  "user_id",
+				Value: fmt.Sprintf("user_%d", r.Intn(rowsCount/3)),
+			},
+			{
+				Name:  "host",
+				Value: fmt.Sprintf("host_%d", r.Intn(10)),
+			},
+		}
+	}
+	return rows
+}
+
+func runTestSpillPipe(t *testing.T, pipeStr string, rows [][]Field, needSpill bool) [][]Field {
+	t.Helper()
+
+	if needSpill {
+		dir := t.TempDir()
+		SetQueryTempDir(dir)
+		defer func() {
+			SetQueryTempDir("")
+
+			des, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("cannot read %q: %s", dir, err)
+			}
+			if len(des) > 0 {
+				t.Fatalf("unexpected temporary files left at %q: %d", dir, len(des))
+			}
+		}()
+	}
+
+	lex := newLexer(pipeStr, 0)
+	p, err := parsePipe(lex)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", pipeStr, err)
+	}
+
+	workersCount := 3
+	stopCh := make(chan struct{})
+	cancel := func() {}
+	ppTest := newTestPipeProcessor()
+	pp := p.newPipeProcessor(workersCount, stopCh, cancel, ppTest)
+	if needSpill {
+		// Set small memory limit, so the pipe state is spilled to disk
+		pp.(stateSizeLimiter).setMaxStateSize(2 * stateSizeBudgetChunk)
+	}
+
+	brw := newTestBlockResultWriter(workersCount, pp)
+	for _, row := range rows {
+		brw.writeRow(row)
+	}
+	brw.flush()
+
+	if needSpill {
+		switch t2 := pp.(type) {
+		case *pipeSortProcessor:
+			if !t2.spill.hasRuns() {
+				t.Fatalf("expecting spilled state for [%s]", pipeStr)
+			}
+		case *pipeStatsProcessor:
+			if !t2.spill.hasSpilled() {
+				t.Fatalf("expecting spilled state for [%s]", pipeStr)
+			}
+		}
+	}
+
+	if err := pp.flush(); err != nil {
+		t.Fatalf("unexpected error at [%s]: %s", pipeStr, err)
+	}
+
+	return ppTest.resultRows
+}
@@ -32,6 +32,9 @@ type StorageStats struct {
 	// PartitionsCount is the number of partitions in the storage.
 	PartitionsCount uint64
 
+	// ColdPartitionsCount is the number of partitions stored at StorageConfig.ColdPath.
+	ColdPartitionsCount uint64
+
 	// MaxDiskSpaceUsageBytes is the maximum disk space logs can use.
 	MaxDiskSpaceUsageBytes int64
 
@@ -62,6 +65,17 @@ type StorageConfig struct {
 	// Older data is automatically deleted.
 	Retention time.Duration
 
+	// ColdPath is an optional path for storing partitions older than ColdAfter.
+	//
+	// It is usually located on a cheaper and slower storage than the main storage path.
+	// Partitions at ColdPath remain available for querying.
+	ColdPath string
+
+	// ColdAfter is the age after which partitions are moved from the main storage path to ColdPath.
+	//
+	// Partitions aren't moved if ColdAfter is zero or if ColdPath is empty.
+	ColdAfter time.Duration
+
 	// DefaultParallelReaders is the default number of parallel readers to use per each query execution.
 	//
 	// Higher value can help improving query performance on storage with high disk read latency such as S3.
@@ -109,6 +123,12 @@ type StorageConfig struct {
 	//
 	// This can be useful for debugging of data ingestion.
 	LogIngestedRows bool
+
+	// RetentionFilters is an optional list of retention filters for logs, which must be deleted before the Retention.
+	//
+	// Retention filters can be updated via Storage.UpdateRetentionFilters().
+	// See https://docs.victoriametrics.com/victorialogs/#retention-filters
+	RetentionFilters []RetentionFilter
 }
 
 // Storage is the storage for log entries.
@@ -119,6 +139,12 @@ type Storage struct {
 	// path is the path to the Storage directory
 	path string
 
+	// coldPath is an optional path for storing partitions older than coldAfter.
+	coldPath string
+
+	// coldAfter is the age after which partitions are moved from path to coldPath.
+	coldAfter time.Duration
+
 	// retention is the retention for the stored data
 	//
 	// older data is automatically deleted
@@ -163,6 +189,9 @@ type Storage struct {
 	// flockF is a file, which makes sure that the Storage is opened by a single process
 	flockF *os.File
 
+	// coldFlockF is a file, which makes sure that the coldPath is used by a single process
+	coldFlockF *os.File
+
 	// partitions is a list of partitions for the Storage.
 	//
 	// It must be accessed under partitionsLock.
@@ -207,6 +236,14 @@ type Storage struct {
 
 	// deleteTasks contains a list of active and pending delete tasks
 	deleteTasks []*DeleteTask
+
+	// retentionFilters contains retention filters for deleting logs before the retention.
+	//
+	// See https://docs.victoriametrics.com/victorialogs/#retention-filters
+	retentionFilters atomic.Pointer[[]RetentionFilter]
+
+	// retentionFiltersUpdateCh is used for notifying the retention filters watcher about retentionFilters update.
+	retentionFiltersUpdateCh chan struct{}
 }
 
 // PartitionAttach attaches the partition with the given name to s.
@@ -237,12 +274,22 @@ func (s *Storage) PartitionAttach(name string) error {
 	// Open the partition and add it to the s.partitions.
 	partitionsPath := filepath.Join(s.path, partitionsDirname)
 	partitionPath := filepath.Join(partitionsPath, name)
+	isCold := false
+	if !fs.IsPathExist(partitionPath) && s.coldPath != "" {
+		// Try attaching the partition from the cold path.
+		coldPartitionPath := filepath.Join(s.coldPath, partitionsDirname, name)
+		if fs.IsPathExist(coldPartitionPath) {
+			partitionPath = coldPartitionPath
+			isCold = true
+		}
+	}
 	if !fs.IsPathExist(partitionPath) {
 		return fmt.Errorf("cannot attach the partition %q, because there is no the corresponding directory %q", name, partitionPath)
 	}
 
 	pt := mustOpenPartition(s, partitionPath)
 	ptw := newPartitionWrapper(pt, day)
+	ptw.isCold = isCold
 
 	s.partitions = append(s.partitions, ptw)
 	sortPartitions(s.partitions)
@@ -549,6 +596,9 @@ type partitionWrapper struct {
 	// pt is the wrapped partition.
 	pt *partition
 
+	// isCold is set to true if the partition is located at Storage.coldPath.
+	isCold bool
+
 	// doneCh is closed when refCount reaches zero, e.g. when the partitionWrapper is no longer accessed.
 	doneCh chan struct{}
 }
@@ -657,6 +707,8 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 
 	s := &Storage{
 		path:                   path,
+		coldPath:               cfg.ColdPath,
+		coldAfter:              cfg.ColdAfter,
 		retention:              retention,
 		defaultParallelReaders: cfg.DefaultParallelReaders,
 		maxDiskSpaceUsageBytes: cfg.MaxDiskSpaceUsageBytes,
@@ -674,13 +726,44 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 		filterStreamCache: filterStreamCache,
 
 		deleteTasks: deleteTasks,
+
+		retentionFiltersUpdateCh: make(chan struct{}, 1),
 	}
 	s.logNewStreams.Store(cfg.LogNewStreams)
+	retentionFilters := append([]RetentionFilter{}, cfg.RetentionFilters...)
+	s.retentionFilters.Store(&retentionFilters)
 
 	partitionsPath := filepath.Join(path, partitionsDirname)
 	fs.MustMkdirIfNotExist(partitionsPath)
 	fs.MustSyncPath(path)
 
+	if s.coldPath != "" {
+		s.mustPrepareColdPath()
+	}
+
+	ptws := s.mustOpenPartitions(partitionsPath)
+	if s.coldPath != "" {
+		ptwsCold := s.mustOpenPartitions(filepath.Join(s.coldPath, partitionsDirname))
+		for _, ptw := range ptwsCold {
+			ptw.isCold = true
+		}
+		ptws = append(ptws, ptwsCold...)
+	}
+
+	sortPartitions(ptws)
+
+	s.partitions = ptws
+	s.runRetentionWatcher()
+	s.runMaxDiskSpaceUsageWatcher()
+	s.runDeleteTasksWatcher()
+	s.runSnapshotsMaxAgeWatcher()
+	s.runRetentionFiltersWatcher()
+	s.runColdPartitionsMover()
+	return s
+}
+
+// mustOpenPartitions opens all the partitions at the given partitionsPath.
+func (s *Storage) mustOpenPartitions(partitionsPath string) []*partitionWrapper {
 	des := fs.MustReadDir(partitionsPath)
 	ptws := make([]*partitionWrapper, len(des))
 
@@ -736,12 +819,7 @@ func MustOpenStorage(path string, cfg *StorageConfig) *Storage {
 	}
 	ptws = ptws[:j]
 
-	s.partitions = ptws
-	s.runRetentionWatcher()
-	s.runMaxDiskSpaceUsageWatcher()
-	s.runDeleteTasksWatcher()
-	s.runSnapshotsMaxAgeWatcher()
-	return s
+	return ptws
 }
 
 func sortPartitions(ptws []*partitionWrapper) {
@@ -996,32 +1074,18 @@ func (s *Storage) processDeleteTask(ctx context.Context, dt *DeleteTask) bool {
 	end := dt.StartTime.UnixNano()
 	q.AddTimeFilter(start, end)
 
-	var qs QueryStats
-	qctx := NewQueryContext(ctx, &qs, dt.TenantIDs, q, false, nil)
-
-	// Initialize subqueries
-	qNew, err := initSubqueries(qctx, s.runQuery, true)
+	ok, err := s.deleteRowsByQuery(ctx, dt.TenantIDs, q)
 	if err != nil {
-		logger.Errorf("cannot process delete task with task_id=%q while initializing subqueries: %s; retrying later", dt.TaskID, err)
+		logger.Errorf("cannot process delete task with task_id=%q: %s; retrying later", dt.TaskID, err)
 		return false
 	}
-	q = qNew
-
-	sso := s.getSearchOptions(dt.TenantIDs, q, qctx.HiddenFieldsFilters)
-
-	// reset fieldsFilter in order to avoid loading all the log fields
-	// during search for parts which contain rows to delete, since these fields aren't needed.
-	sso.fieldsFilter.Reset()
-
-	// delete rows matching q.f
-	stopCh := ctx.Done()
-	if !s.deleteRows(sso, stopCh) {
+	if !ok {
 		if needStop(s.stopCh) {
 			logger.Infof("the storage is stopped while executing the delete task with task_id=%q; postponing the task for later execution", dt.TaskID)
 			return false
 		}
 
-		if needStop(stopCh) {
+		if needStop(ctx.Done()) {
 			// The task has been canceled explicitly. Return true, so it isn't re-scheduled for later execution.
 			logger.Infof("the delete task with task_id=%q is explicitly canceled after %.3f seconds", dt.TaskID, time.Since(startTime).Seconds())
 			return true
@@ -1036,6 +1100,30 @@ func (s *Storage) processDeleteTask(ctx context.Context, dt *DeleteTask) bool {
 	return true
 }
 
+// deleteRowsByQuery deletes rows matching q.f at the given tenantIDs.
+//
+// false is returned if the rows couldn't be deleted at the moment, so the deletion must be retried later.
+func (s *Storage) deleteRowsByQuery(ctx context.Context, tenantIDs []TenantID, q *Query) (bool, error) {
+	var qs QueryStats
+	qctx := NewQueryContext(ctx, &qs, tenantIDs, q, false, nil)
+
+	// Initialize subqueries
+	qNew, err := initSubqueries(qctx, s.runQuery, true)
+	if err != nil {
+		return false, fmt.Errorf("cannot initialize subqueries: %w", err)
+	}
+	q = qNew
+
+	sso := s.getSearchOptions(tenantIDs, q, qctx.HiddenFieldsFilters)
+
+	// reset fieldsFilter in order to avoid loading all the log fields
+	// during search for parts which contain rows to delete, since these fields aren't needed.
+	sso.fieldsFilter.Reset()
+
+	// delete rows matching q.f
+	return s.deleteRows(sso, ctx.Done()), nil
+}
+
 func (s *Storage) deleteRows(sso *storageSearchOptions, stopCh <-chan struct{}) bool {
 	ptws, ptwsDecRef := s.getPartitionsForTimeRange(sso.minTimestamp, sso.maxTimestamp)
 	defer ptwsDecRef()
@@ -1103,6 +1191,10 @@ func (s *Storage) MustClose() {
 	// release lock file
 	fs.MustClose(s.flockF)
 	s.flockF = nil
+	if s.coldFlockF != nil {
+		fs.MustClose(s.coldFlockF)
+		s.coldFlockF = nil
+	}
 
 	s.path = ""
 }
@@ -1239,6 +1331,7 @@ func (tf *TimeFormatter) String() string {
 //   - When the partition is outside the configured retention.
 //   - When the partition has been detached via Storage.PartitionDetach().
 //   - When the partition directory has been manually added, but wasn't attached yet via Storage.PartitionAttach().
+//   - When the partition is being moved to the cold path. See StorageConfig.ColdPath.
 //
 // The caller must log this case and drop pending logs for this partition.
 func (s *Storage) getPartitionForWriting(day int64) *partitionWrapper {
@@ -1266,10 +1359,11 @@ func (s *Storage) getPartitionForWriting(day int64) *partitionWrapper {
 
 		fname := getPartitionNameFromDay(day)
 		partitionPath := filepath.Join(s.path, partitionsDirname, fname)
-		if fs.IsPathExist(partitionPath) {
+		if fs.IsPathExist(partitionPath) || s.coldPath != "" && fs.IsPathExist(filepath.Join(s.coldPath, partitionsDirname, fname)) {
 			// The partition directory exists. This can happen in the following cases:
 			// - When the partition directory has been manually added, but it wasn't attached yet via Storage.PartitionAttach().
 			// - When the partition has been detached via Storage.PartitionDetach().
+			// - When the partition is being moved to the cold path.
 			return nil
 		}
 
@@ -1308,6 +1402,9 @@ func (s *Storage) UpdateStats(ss *StorageStats) {
 	ss.PartitionsCount += uint64(len(s.partitions))
 	for _, ptw := range s.partitions {
 		ptw.pt.updateStats(&ss.PartitionStats)
+		if ptw.isCold {
+			ss.ColdPartitionsCount++
+		}
 	}
 
 	if len(s.partitions) > 0 {
@@ -0,0 +1,243 @@
+package logstorage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timeutil"
+)
+
+// mustPrepareColdPath prepares s.coldPath for opening the partitions stored there.
+//
+// It must be called before opening partitions at s.path and s.coldPath.
+func (s *Storage) mustPrepareColdPath() {
+	fs.MustMkdirIfNotExist(s.coldPath)
+	s.coldFlockF = fs.MustCreateFlockFile(s.coldPath)
+
+	// Drop partitions, which were partially copied to s.coldPath before unclean shutdown.
+	tmpPath := filepath.Join(s.coldPath, tmpDirname)
+	if fs.IsPathExist(tmpPath) {
+		fs.MustRemoveDir(tmpPath)
+	}
+
+	coldPartitionsPath := filepath.Join(s.coldPath, partitionsDirname)
+	fs.MustMkdirIfNotExist(coldPartitionsPath)
+	fs.MustSyncPath(s.coldPath)
+
+	// Drop hot copies of partitions, which were already moved to s.coldPath.
+	// This may happen when unclean shutdown occurs after the partition is moved to s.coldPath,
+	// but before its' hot copy is deleted.
+	partitionsPath := filepath.Join(s.path, partitionsDirname)
+	for _, de := range fs.MustReadDir(coldPartitionsPath) {
+		fname := de.Name()
+		if fs.IsPartiallyRemovedDir(filepath.Join(coldPartitionsPath, fname)) {
+			continue
+		}
+		partitionPath := filepath.Join(partitionsPath, fname)
+		if fs.IsPathExist(partitionPath) {
+			logger.Infof("removing partition %q, since it has been already moved to %q", partitionPath, coldPartitionsPath)
+			fs.MustRemoveDir(partitionPath)
+		}
+	}
+}
+
+func (s *Storage) runColdPartitionsMover() {
+	if s.coldPath == "" || s.coldAfter <= 0 {
+		return // nothing to move
+	}
+	s.wg.Go(s.watchColdPartitions)
+}
+
+func (s *Storage) watchColdPartitions() {
+	d := timeutil.AddJitterToDuration(time.Hour)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		s.moveColdPartitions()
+
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// moveColdPartitions moves partitions older than s.coldAfter from s.path to s.coldPath.
+//
+// Partitions are moved one by one starting from the oldest one.
+func (s *Storage) moveColdPartitions() {
+	for !needStop(s.stopCh) {
+		ptw := s.getPartitionForMovingToCold()
+		if ptw == nil {
+			return
+		}
+		if !s.mustMovePartitionToCold(ptw) {
+			return
+		}
+	}
+}
+
+// getPartitionForMovingToCold returns the oldest partition, which must be moved to s.coldPath.
+//
+// nil is returned if there are no partitions to move.
+//
+// The returned partition must be passed to mustMovePartitionToCold.
+func (s *Storage) getPartitionForMovingToCold() *partitionWrapper {
+	maxColdDay := (time.Now().UnixNano() - s.coldAfter.Nanoseconds()) / nsecsPerDay
+
+	s.partitionsLock.Lock()
+	defer s.partitionsLock.Unlock()
+
+	for _, ptw := range s.partitions {
+		if ptw.day >= maxColdDay {
+			// s.partitions are sorted by day, so the remaining partitions are too young.
+			return nil
+		}
+		if ptw.isCold || ptw == s.ptwHot {
+			continue
+		}
+		snapshotsPath := filepath.Join(ptw.pt.path, snapshotsDirname)
+		if fs.IsPathExist(snapshotsPath) && len(fs.MustReadDir(snapshotsPath)) > 0 {
+			// Do not move partitions with snapshots, since snapshots cannot be hard-linked across filesystems.
+			// The partition will be moved after its' snapshots are deleted.
+			continue
+		}
+		ptw.incRef()
+		return ptw
+	}
+	return nil
+}
+
+// mustMovePartitionToCold moves the given ptw from s.path to s.coldPath.
+//
+// The partition remains available for querying and writing while its' contents is copied to s.coldPath.
+// Then it is detached for a short period of time needed for copying the changes made during the copying,
+// and is attached back from s.coldPath.
+//
+// false is returned if the partition couldn't be moved, e.g. because it is actively written to.
+func (s *Storage) mustMovePartitionToCold(ptw *partitionWrapper) bool {
+	name := ptw.pt.name
+	day := ptw.day
+	partitionPath := ptw.pt.path
+	coldPartitionPath := filepath.Join(s.coldPath, partitionsDirname, name)
+	tmpPartitionPath := filepath.Join(s.coldPath, tmpDirname, name)
+
+	logger.Infof("moving partition %q to %q, since it is older than -storageDataPath.coldAfter=%dd", partitionPath, coldPartitionPath, durationToDays(s.coldAfter))
+	startTime := time.Now()
+
+	// Copy the partition snapshot while the partition remains available for querying and writing.
+	fs.MustMkdirIfNotExist(filepath.Dir(tmpPartitionPath))
+	snapshotPath := ptw.pt.mustCreateSnapshot()
+	mustSyncPartitionDir(snapshotPath, tmpPartitionPath)
+	if err := ptw.pt.deleteSnapshot(filepath.Base(snapshotPath)); err != nil {
+		logger.Panicf("BUG: cannot delete just created snapshot: %s", err)
+	}
+	ptw.decRef()
+
+	// Detach the partition, so it could be safely moved to s.coldPath.
+	ptw = func() *partitionWrapper {
+		s.partitionsLock.Lock()
+		defer s.partitionsLock.Unlock()
+
+		for i, ptw := range s.partitions {
+			if ptw.day != day || ptw.isCold {
+				continue
+			}
+			if ptw == s.ptwHot {
+				// The partition became hot during the copying. Postpone moving it to s.coldPath.
+				return nil
+			}
+			s.partitions = append(s.partitions[:i], s.partitions[i+1:]...)
+			return ptw
+		}
+		return nil
+	}()
+	if ptw == nil {
+		logger.Infof("cancelling the move of the partition %q to %q, since it has been detached or became hot", partitionPath, coldPartitionPath)
+		fs.MustRemoveDir(tmpPartitionPath)
+		return false
+	}
+	ptw.decRef()
+	<-ptw.doneCh
+
+	// Copy the changes made since the snapshot creation and atomically publish the partition at s.coldPath.
+	mustSyncPartitionDir(partitionPath, tmpPartitionPath)
+	if err := os.Rename(tmpPartitionPath, coldPartitionPath); err != nil {
+		logger.Panicf("FATAL: cannot rename %q to %q: %s", tmpPartitionPath, coldPartitionPath, err)
+	}
+	fs.MustSyncPath(filepath.Dir(coldPartitionPath))
+	fs.MustSyncPath(filepath.Dir(tmpPartitionPath))
+
+	// Attach the partition from s.coldPath.
+	s.partitionsLock.Lock()
+	isDeleted := slices.Contains(s.deletedPartitions, day)
+	if !isDeleted {
+		pt := mustOpenPartition(s, coldPartitionPath)
+		ptw = newPartitionWrapper(pt, day)
+		ptw.isCold = true
+		s.partitions = append(s.partitions, ptw)
+		sortPartitions(s.partitions)
+	}
+	s.partitionsLock.Unlock()
+
+	mustDeletePartition(partitionPath)
+	if isDeleted {
+		// The partition has been deleted because of retention while it was moved.
+		mustDeletePartition(coldPartitionPath)
+	}
+
+	logger.Infof("moved partition %q to %q in %.3f seconds", partitionPath, coldPartitionPath, time.Since(startTime).Seconds())
+
+	return true
+}
+
+// mustSyncPartitionDir makes the contents of dstDir identical to the contents of srcDir.
+//
+// Files with the same name and size are skipped, since part files are immutable.
+// parts.json files are always copied, since they are updated in place.
+// The snapshots directory is skipped.
+func mustSyncPartitionDir(srcDir, dstDir string) {
+	fs.MustMkdirIfNotExist(dstDir)
+
+	srcDes := fs.MustReadDir(srcDir)
+	srcNames := make(map[string]struct{}, len(srcDes))
+	for _, de := range srcDes {
+		name := de.Name()
+		if name == snapshotsDirname || name == fs.FlockFilename {
+			continue
+		}
+		srcNames[name] = struct{}{}
+
+		srcPath := filepath.Join(srcDir, name)
+		dstPath := filepath.Join(dstDir, name)
+		if de.IsDir() {
+			mustSyncPartitionDir(srcPath, dstPath)
+			continue
+		}
+		if name != partsFilename && fs.IsPathExist(dstPath) && fs.MustFileSize(srcPath) == fs.MustFileSize(dstPath) {
+			continue
+		}
+		fs.MustCopyFile(srcPath, dstPath)
+	}
+
+	// Remove entries, which are missing in srcDir. These are usually parts, which were merged during the copying.
+	for _, de := range fs.MustReadDir(dstDir) {
+		name := de.Name()
+		if _, ok := srcNames[name]; ok {
+			continue
+		}
+		dstPath := filepath.Join(dstDir, name)
+		if de.IsDir() {
+			fs.MustRemoveDir(dstPath)
+		} else {
+			fs.MustRemovePath(dstPath)
+		}
+	}
+
+	fs.MustSyncPath(dstDir)
+}
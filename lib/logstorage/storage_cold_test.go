@@ -0,0 +1,117 @@
+package logstorage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestStorageMoveColdPartitions(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+	coldPath := t.Name() + "-cold"
+
+	cfg := &StorageConfig{
+		Retention: 30 * 24 * time.Hour,
+		ColdPath:  coldPath,
+	}
+	s := MustOpenStorage(path, cfg)
+
+	// Write logs for the last 5 days
+	const days = 5
+	const rowsPerDay = 10
+	tenantID := TenantID{
+		AccountID: 1,
+		ProjectID: 2,
+	}
+	now := time.Now().UnixNano()
+	for i := days - 1; i >= 0; i-- {
+		// Write logs day by day, so the partition for the current day becomes hot.
+		lr := GetLogRows(nil, nil, nil, nil, "")
+		for j := 0; j < rowsPerDay; j++ {
+			fields := []Field{
+				{
+					Name:  "_msg",
+					Value: fmt.Sprintf("message %d at day %d", j, i),
+				},
+			}
+			lr.mustAdd(tenantID, now-int64(i)*nsecsPerDay+int64(j), fields)
+		}
+		s.MustAddRows(lr)
+		PutLogRows(lr)
+	}
+	s.DebugFlush()
+
+	// Move partitions older than 2 days to coldPath
+	s.coldAfter = 2 * 24 * time.Hour
+	s.moveColdPartitions()
+
+	f := func(s *Storage, coldPartitionsExpected uint64) {
+		t.Helper()
+
+		var ss StorageStats
+		s.UpdateStats(&ss)
+		if ss.PartitionsCount != days {
+			t.Fatalf("unexpected number of partitions; got %d; want %d", ss.PartitionsCount, days)
+		}
+		if ss.ColdPartitionsCount != coldPartitionsExpected {
+			t.Fatalf("unexpected number of cold partitions; got %d; want %d", ss.ColdPartitionsCount, coldPartitionsExpected)
+		}
+		if n := ss.RowsCount(); n != days*rowsPerDay {
+			t.Fatalf("unexpected number of rows; got %d; want %d", n, days*rowsPerDay)
+		}
+
+		// Make sure that all the logs are available for querying
+		var rowsCount atomic.Uint64
+		writeBlock := func(_ uint, db *DataBlock) {
+			rowsCount.Add(uint64(db.RowsCount()))
+		}
+		q := mustParseQuery("*")
+		qctx := newTestQueryContext([]TenantID{tenantID}, q)
+		if err := s.RunQuery(qctx, writeBlock); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n := rowsCount.Load(); n != days*rowsPerDay {
+			t.Fatalf("unexpected number of rows returned from the query; got %d; want %d", n, days*rowsPerDay)
+		}
+
+		// Verify the location of partitions
+		for i := 0; i < days; i++ {
+			name := getPartitionNameFromDay(now/nsecsPerDay - int64(i))
+			hotPath := filepath.Join(path, partitionsDirname, name)
+			coldPartitionPath := filepath.Join(coldPath, partitionsDirname, name)
+			isCold := uint64(days-i) <= coldPartitionsExpected
+			if fs.IsPathExist(hotPath) == isCold {
+				t.Fatalf("unexpected existence of the partition %q; got %v; want %v", hotPath, !isCold, isCold)
+			}
+			if fs.IsPathExist(coldPartitionPath) != isCold {
+				t.Fatalf("unexpected existence of the partition %q; got %v; want %v", coldPartitionPath, !isCold, isCold)
+			}
+		}
+	}
+
+	f(s, 2)
+
+	// Moving partitions again must be no-op
+	s.moveColdPartitions()
+	f(s, 2)
+	s.MustClose()
+
+	// Re-open the storage and verify that cold partitions are opened from coldPath
+	s = MustOpenStorage(path, cfg)
+	f(s, 2)
+
+	// Move more partitions to coldPath
+	s.coldAfter = 24 * time.Hour
+	s.moveColdPartitions()
+	f(s, 3)
+	s.MustClose()
+
+	fs.MustRemoveDir(path)
+	fs.MustRemoveDir(coldPath)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/atomicutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/cgroup"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/contextutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/slicesutil"
@@ -44,6 +45,21 @@ type QueryContext struct {
 	// Prefix match all the fields starting with the given prefix.
 	HiddenFieldsFilters []string
 
+	// Limits is an optional resource limits for the query execution.
+	//
+	// The limits are applied only to the local storage. They aren't sent to remote storage nodes.
+	Limits *QueryLimits
+
+	// StageStats is an optional collector for per-pipe execution stats.
+	//
+	// It is propagated to the locally executed pipes of the query, but isn't propagated to subqueries.
+	StageStats *QueryStageStats
+
+	// StreamOwnerFilter is an optional filter, which limits the query to log streams owned by the given storage node.
+	//
+	// It is used when logs are replicated among multiple storage nodes, so every log stream is queried at a single storage node.
+	StreamOwnerFilter *StreamOwnerFilter
+
 	// startTime is creation time for the QueryContext.
 	//
 	// It is used for calculating query druation.
@@ -58,17 +74,27 @@ func NewQueryContext(ctx context.Context, qs *QueryStats, tenantIDs []TenantID,
 
 // WithQuery returns new QueryContext with the given q, while preserving other fields from qctx.
 func (qctx *QueryContext) WithQuery(q *Query) *QueryContext {
-	return newQueryContext(qctx.Context, qctx.QueryStats, qctx.TenantIDs, q, qctx.AllowPartialResponse, qctx.HiddenFieldsFilters, qctx.startTime)
+	qctxNew := newQueryContext(qctx.Context, qctx.QueryStats, qctx.TenantIDs, q, qctx.AllowPartialResponse, qctx.HiddenFieldsFilters, qctx.startTime)
+	qctxNew.Limits = qctx.Limits
+	qctxNew.StreamOwnerFilter = qctx.StreamOwnerFilter
+	return qctxNew
 }
 
 // WithContext returns new QueryContext with the given ctx, while preserving other fields from qctx.
 func (qctx *QueryContext) WithContext(ctx context.Context) *QueryContext {
-	return newQueryContext(ctx, qctx.QueryStats, qctx.TenantIDs, qctx.Query, qctx.AllowPartialResponse, qctx.HiddenFieldsFilters, qctx.startTime)
+	qctxNew := newQueryContext(ctx, qctx.QueryStats, qctx.TenantIDs, qctx.Query, qctx.AllowPartialResponse, qctx.HiddenFieldsFilters, qctx.startTime)
+	qctxNew.Limits = qctx.Limits
+	qctxNew.StageStats = qctx.StageStats
+	qctxNew.StreamOwnerFilter = qctx.StreamOwnerFilter
+	return qctxNew
 }
 
 // WithContextAndQuery returns new QueryContext with the given ctx and q, while preserving other fields from qctx.
 func (qctx *QueryContext) WithContextAndQuery(ctx context.Context, q *Query) *QueryContext {
-	return newQueryContext(ctx, qctx.QueryStats, qctx.TenantIDs, q, qctx.AllowPartialResponse, qctx.HiddenFieldsFilters, qctx.startTime)
+	qctxNew := newQueryContext(ctx, qctx.QueryStats, qctx.TenantIDs, q, qctx.AllowPartialResponse, qctx.HiddenFieldsFilters, qctx.startTime)
+	qctxNew.Limits = qctx.Limits
+	qctxNew.StreamOwnerFilter = qctx.StreamOwnerFilter
+	return qctxNew
 }
 
 // QueryDurationNsecs returns the duration in nanoseconds since the NewQueryContext call.
@@ -128,6 +154,9 @@ type storageSearchOptions struct {
 
 	// timeOffset is the offset in nanoseconds, which must be subtracted from the selected the _time values before these values are passed to query pipes.
 	timeOffset int64
+
+	// streamOwnerFilter is an optional filter for log streams owned by the current storage node
+	streamOwnerFilter *StreamOwnerFilter
 }
 
 // partitionSearchOptions is search options for the partition.
@@ -156,6 +185,14 @@ type partitionSearchOptions struct {
 
 	// hiddenFieldsFilter is the filter of fields, which must be hidden during query
 	hiddenFieldsFilter *prefixfilter.Filter
+
+	// streamOwnerFilter is an optional filter for log streams owned by the current storage node
+	streamOwnerFilter *StreamOwnerFilter
+}
+
+// isStreamOwned returns true if the log stream with the given sid must be searched according to pso.streamOwnerFilter.
+func (pso *partitionSearchOptions) isStreamOwned(sid *streamID) bool {
+	return pso.streamOwnerFilter == nil || pso.streamOwnerFilter.matchStreamID(sid)
 }
 
 func (pso *partitionSearchOptions) matchStreamID(sid *streamID) bool {
@@ -221,11 +258,21 @@ func (s *Storage) runQuery(qctx *QueryContext, writeBlock writeBlockResultFunc)
 	q := qNew
 
 	sso := s.getSearchOptions(qctx.TenantIDs, q, qctx.HiddenFieldsFilters)
+	sso.streamOwnerFilter = qctx.StreamOwnerFilter
 
 	search := func(stopCh <-chan struct{}, writeBlockToPipes writeBlockResultFunc) error {
 		workersCount := q.GetParallelReaders(s.defaultParallelReaders)
-		s.searchParallel(workersCount, sso, qctx.QueryStats, stopCh, writeBlockToPipes)
-		return nil
+		if !qctx.Limits.hasSearchLimits() {
+			s.searchParallel(workersCount, sso, qctx.QueryStats, stopCh, nil, writeBlockToPipes)
+			return nil
+		}
+
+		ctx, cancel := contextutil.NewStopChanContext(stopCh)
+		defer cancel()
+
+		qlc := newQueryLimitsChecker(qctx.Limits, qctx.QueryStats, cancel)
+		s.searchParallel(workersCount, sso, qctx.QueryStats, ctx.Done(), qlc, writeBlockToPipes)
+		return qlc.getError()
 	}
 
 	concurrency := q.GetConcurrency()
@@ -277,6 +324,17 @@ func runPipes(qctx *QueryContext, pipes []pipe, search searchFunc, writeBlock wr
 	}
 
 	pp := newNoopPipeProcessor(stopCh, writeBlock)
+
+	var pscs []*pipeStageCounters
+	if qctx.StageStats != nil {
+		// Collect per-pipe execution stats. pscs[len(pipes)] collects the output of the last pipe.
+		pscs = make([]*pipeStageCounters, len(pipes)+1)
+		for i := range pscs {
+			pscs[i] = &pipeStageCounters{}
+		}
+		pp = newPipeStageProcessor(pscs[len(pipes)], pp)
+	}
+
 	cancels := make([]func(), len(pipes))
 	pps := make([]pipeProcessor, len(pipes))
 
@@ -287,11 +345,19 @@ func runPipes(qctx *QueryContext, pipes []pipe, search searchFunc, writeBlock wr
 
 		cancels[i] = cancel
 		pps[i] = pp
+		if pscs != nil {
+			pp = newPipeStageProcessor(pscs[i], pp)
+		}
 
 		stopCh = ctxChild.Done()
 		ctx = ctxChild
 	}
 
+	var ssls []stateSizeLimiter
+	if qctx.Limits != nil && qctx.Limits.MaxMemoryBytes > 0 {
+		ssls = applyMaxMemoryLimit(pps, qctx.Limits.MaxMemoryBytes)
+	}
+
 	errSearch := search(stopCh, pp.writeBlock)
 	if errSearch != nil {
 		// Cancel the whole query in order to free up resources occupied by pipes.
@@ -307,20 +373,36 @@ func runPipes(qctx *QueryContext, pipes []pipe, search searchFunc, writeBlock wr
 			t.setQueryStats(qctx.QueryStats, qctx.QueryDurationNsecs())
 		}
 
+		startTime := time.Now()
 		if err := pp.flush(); err != nil && errFlush == nil {
 			// Cancel the whole query in order to free up resources occupied by the remaining pipes.
 			topCancel()
 
 			errFlush = err
 		}
+		if pscs != nil {
+			pscs[i].flushNsecs = time.Since(startTime).Nanoseconds()
+		}
 		cancel := cancels[i]
 		cancel()
 	}
 
+	if pscs != nil {
+		qctx.StageStats.setStages(getPipeStageStats(pipes, pscs))
+	}
+
 	if errSearch != nil {
 		return errSearch
 	}
 
+	if errFlush != nil {
+		for _, ssl := range ssls {
+			if ssl.isStateSizeExceeded() {
+				return fmt.Errorf("%w; the query exceeds max_memory_bytes=%d limit", errFlush, qctx.Limits.MaxMemoryBytes)
+			}
+		}
+	}
+
 	return errFlush
 }
 
@@ -576,7 +658,22 @@ func (s *Storage) GetStreamFieldNames(qctx *QueryContext) ([]ValueWithHits, erro
 	if err != nil {
 		return nil, err
 	}
+	return GetStreamFieldNamesFromStreams(streams), nil
+}
 
+// GetStreamFieldValues returns stream field values for the given fieldName and the given qctx.
+//
+// If limit > 0, then up to limit unique values are returned.
+func (s *Storage) GetStreamFieldValues(qctx *QueryContext, fieldName string, limit uint64) ([]ValueWithHits, error) {
+	streams, err := s.GetStreams(qctx, math.MaxUint64)
+	if err != nil {
+		return nil, err
+	}
+	return GetStreamFieldValuesFromStreams(streams, fieldName, limit), nil
+}
+
+// GetStreamFieldNamesFromStreams returns stream field names for the given streams obtained via GetStreams().
+func GetStreamFieldNamesFromStreams(streams []ValueWithHits) []ValueWithHits {
 	m := make(map[string]*uint64)
 	forEachStreamField(streams, func(f Field, hits uint64) {
 		pHits := m[f.Name]
@@ -588,19 +685,13 @@ func (s *Storage) GetStreamFieldNames(qctx *QueryContext) ([]ValueWithHits, erro
 		}
 		*pHits += hits
 	})
-	names := toValuesWithHits(m)
-	return names, nil
+	return toValuesWithHits(m)
 }
 
-// GetStreamFieldValues returns stream field values for the given fieldName and the given qctx.
+// GetStreamFieldValuesFromStreams returns stream field values for the given fieldName from the given streams obtained via GetStreams().
 //
 // If limit > 0, then up to limit unique values are returned.
-func (s *Storage) GetStreamFieldValues(qctx *QueryContext, fieldName string, limit uint64) ([]ValueWithHits, error) {
-	streams, err := s.GetStreams(qctx, math.MaxUint64)
-	if err != nil {
-		return nil, err
-	}
-
+func GetStreamFieldValuesFromStreams(streams []ValueWithHits, fieldName string, limit uint64) []ValueWithHits {
 	m := make(map[string]*uint64)
 	forEachStreamField(streams, func(f Field, hits uint64) {
 		if f.Name != fieldName {
@@ -620,7 +711,7 @@ func (s *Storage) GetStreamFieldValues(qctx *QueryContext, fieldName string, lim
 		values = values[:limit]
 		resetHits(values)
 	}
-	return values, nil
+	return values
 }
 
 // GetStreams returns streams from qctx results.
@@ -772,6 +863,11 @@ func initSubqueries(qctx *QueryContext, runQuery runQueryFunc, keepInSubquery bo
 		return nil, fmt.Errorf("cannot initialize `join` subqueries: %w", err)
 	}
 
+	qNew, err = initLookupPipes(qNew, getLookupTable)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize `lookup` pipes: %w", err)
+	}
+
 	runUnionQuery := func(ctx context.Context, q *Query, writeBlock writeBlockResultFunc) error {
 		qctxLocal := qctx.WithContextAndQuery(ctx, q)
 		return runQuery(qctxLocal, writeBlock)
@@ -898,6 +994,40 @@ func hasJoinPipes(pipes []pipe) bool {
 	return false
 }
 
+type getLookupTableFunc func(name string) (*LookupTable, error)
+
+func initLookupPipes(q *Query, getLookupTable getLookupTableFunc) (*Query, error) {
+	if !hasLookupPipes(q.pipes) {
+		return q, nil
+	}
+
+	pipesNew := make([]pipe, len(q.pipes))
+	for i, p := range q.pipes {
+		if pl, ok := p.(*pipeLookup); ok {
+			pNew, err := pl.initLookupRows(getLookupTable)
+			if err != nil {
+				return nil, err
+			}
+			p = pNew
+		}
+		pipesNew[i] = p
+	}
+
+	qNew := q.cloneShallow()
+	qNew.pipes = pipesNew
+
+	return qNew, nil
+}
+
+func hasLookupPipes(pipes []pipe) bool {
+	for _, p := range pipes {
+		if _, ok := p.(*pipeLookup); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (iff *ifFilter) visitSubqueries(visitFunc func(q *Query)) {
 	if iff != nil {
 		visitSubqueriesInFilter(iff.f, visitFunc)
@@ -1271,7 +1401,9 @@ func (db *DataBlock) initFromBlockResult(br *blockResult) {
 // search searches for the matching rows according to sso.
 //
 // It uses workersCount parallel workers for the search and calls writeBlock for each matching block.
-func (s *Storage) searchParallel(workersCount int, sso *storageSearchOptions, qs *QueryStats, stopCh <-chan struct{}, writeBlock writeBlockResultFunc) {
+//
+// If qlc isn't nil, then it is used for verifying whether the search exceeds query limits.
+func (s *Storage) searchParallel(workersCount int, sso *storageSearchOptions, qs *QueryStats, stopCh <-chan struct{}, qlc *queryLimitsChecker, writeBlock writeBlockResultFunc) {
 	// spin up workers
 	var wg sync.WaitGroup
 	workCh := make(chan *blockSearchWorkBatch, workersCount)
@@ -1305,6 +1437,10 @@ func (s *Storage) searchParallel(workersCount int, sso *storageSearchOptions, qs
 					qsLocal.BlocksProcessed++
 					qsLocal.RowsProcessed += rowsProcessed
 					qsLocal.RowsFound += uint64(bs.br.rowsLen)
+
+					if qlc != nil {
+						qlc.updateAndCheck(qsLocal)
+					}
 				}
 				bswb.bsws = bswb.bsws[:0]
 				putBlockSearchWorkBatch(bswb)
@@ -1429,6 +1565,7 @@ func (pt *partition) getSearchOptions(sso *storageSearchOptions) *partitionSearc
 		filter:             f,
 		fieldsFilter:       sso.fieldsFilter,
 		hiddenFieldsFilter: sso.hiddenFieldsFilter,
+		streamOwnerFilter:  sso.streamOwnerFilter,
 	}
 }
 
@@ -1617,6 +1754,10 @@ func (p *part) searchByTenantIDs(pso *partitionSearchOptions, qs *QueryStats, bh
 
 	bswb := getBlockSearchWorkBatch()
 	scheduleBlockSearch := func(bh *blockHeader) bool {
+		if !pso.isStreamOwned(&bh.streamID) {
+			// The log stream is searched at another storage node.
+			return true
+		}
 		if bswb.appendBlockSearchWork(p, pso, bh) {
 			return true
 		}
@@ -1719,6 +1860,10 @@ func (p *part) searchByStreamIDs(pso *partitionSearchOptions, qs *QueryStats, bh
 
 	bswb := getBlockSearchWorkBatch()
 	scheduleBlockSearch := func(bh *blockHeader) bool {
+		if !pso.isStreamOwned(&bh.streamID) {
+			// The log stream is searched at another storage node.
+			return true
+		}
 		if bswb.appendBlockSearchWork(p, pso, bh) {
 			return true
 		}
@@ -1850,7 +1995,7 @@ func forEachStreamField(streams []ValueWithHits, f func(f Field, hits uint64)) {
 	var fields []Field
 	for i := range streams {
 		var err error
-		fields, err = parseStreamFields(fields[:0], streams[i].Value)
+		fields, err = ParseStreamFields(fields[:0], streams[i].Value)
 		if err != nil {
 			continue
 		}
@@ -1861,7 +2006,10 @@ func forEachStreamField(streams []ValueWithHits, f func(f Field, hits uint64)) {
 	}
 }
 
-func parseStreamFields(dst []Field, s string) ([]Field, error) {
+// ParseStreamFields appends fields parsed from the _stream value s to dst and returns the result.
+//
+// s must have the form {name1="value1",...,nameN="valueN"}.
+func ParseStreamFields(dst []Field, s string) ([]Field, error) {
 	if len(s) == 0 || s[0] != '{' {
 		return dst, fmt.Errorf("missing '{' at the beginning of stream name")
 	}
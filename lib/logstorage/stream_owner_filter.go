@@ -0,0 +1,108 @@
+package logstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// StreamOwnerFilter selects log streams owned by a single storage node, when logs are replicated among multiple storage nodes.
+//
+// Logs for every log stream are stored at ReplicationFactor consecutive storage nodes starting from the node with the index
+// GetStreamHash() % NodesCount. The log stream is owned by the first available storage node among these nodes,
+// so every log stream is queried at a single storage node, and query pipes can be executed at storage nodes.
+type StreamOwnerFilter struct {
+	// NodesCount is the number of storage nodes in the cluster.
+	NodesCount int `json:"nodes_count"`
+
+	// ReplicationFactor is the number of storage nodes every log stream is stored to.
+	ReplicationFactor int `json:"replication_factor"`
+
+	// NodeIdx is the index of the storage node to select log streams for.
+	NodeIdx int `json:"node_idx"`
+
+	// UnavailableNodes contains indexes of unavailable storage nodes, which cannot own log streams.
+	UnavailableNodes []int `json:"unavailable_nodes,omitempty"`
+
+	// FailedNodes contains indexes of storage nodes, which failed during the query execution.
+	//
+	// If FailedNodes isn't empty, then only log streams, which were owned by FailedNodes, are selected.
+	FailedNodes []int `json:"failed_nodes,omitempty"`
+}
+
+// MarshalStreamOwnerFilterToJSON marshals f to JSON.
+func MarshalStreamOwnerFilterToJSON(f *StreamOwnerFilter) []byte {
+	data, err := json.Marshal(f)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal StreamOwnerFilter to JSON: %s", err)
+	}
+	return data
+}
+
+// UnmarshalStreamOwnerFilterFromJSON unmarshals StreamOwnerFilter from JSON data.
+//
+// nil is returned if data contains JSON null.
+func UnmarshalStreamOwnerFilterFromJSON(data []byte) (*StreamOwnerFilter, error) {
+	var f *StreamOwnerFilter
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal StreamOwnerFilter from JSON: %w", err)
+	}
+	if f == nil {
+		return nil, nil
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *StreamOwnerFilter) validate() error {
+	if f.NodesCount <= 0 {
+		return fmt.Errorf("nodes_count must be positive; got %d", f.NodesCount)
+	}
+	if f.ReplicationFactor < 1 || f.ReplicationFactor > f.NodesCount {
+		return fmt.Errorf("replication_factor must be in the range [1..%d]; got %d", f.NodesCount, f.ReplicationFactor)
+	}
+	if f.NodeIdx < 0 || f.NodeIdx >= f.NodesCount {
+		return fmt.Errorf("node_idx must be in the range [0..%d]; got %d", f.NodesCount-1, f.NodeIdx)
+	}
+	return nil
+}
+
+func (f *StreamOwnerFilter) matchStreamID(sid *streamID) bool {
+	streamHash := sid.id.lo ^ sid.id.hi
+	return f.matchStreamHash(streamHash)
+}
+
+func (f *StreamOwnerFilter) matchStreamHash(streamHash uint64) bool {
+	ownerIdx := GetStreamOwnerNodeIdx(streamHash, f.NodesCount, f.ReplicationFactor, f.UnavailableNodes, f.FailedNodes)
+	if ownerIdx != f.NodeIdx {
+		return false
+	}
+	if len(f.FailedNodes) == 0 {
+		return true
+	}
+
+	// Select only log streams, which were owned by the failed nodes, since the remaining log streams
+	// have been already selected from their owners.
+	prevOwnerIdx := GetStreamOwnerNodeIdx(streamHash, f.NodesCount, f.ReplicationFactor, f.UnavailableNodes, nil)
+	return slices.Contains(f.FailedNodes, prevOwnerIdx)
+}
+
+// GetStreamOwnerNodeIdx returns the index of the storage node, which owns the log stream with the given streamHash.
+//
+// The log stream is stored at replicationFactor consecutive storage nodes starting from streamHash % nodesCount.
+// The owner is the first of these nodes, which is missing in unavailableNodes and failedNodes.
+// -1 is returned if all these nodes are unavailable.
+func GetStreamOwnerNodeIdx(streamHash uint64, nodesCount, replicationFactor int, unavailableNodes, failedNodes []int) int {
+	firstIdx := int(streamHash % uint64(nodesCount))
+	for i := 0; i < replicationFactor; i++ {
+		idx := (firstIdx + i) % nodesCount
+		if !slices.Contains(unavailableNodes, idx) && !slices.Contains(failedNodes, idx) {
+			return idx
+		}
+	}
+	return -1
+}
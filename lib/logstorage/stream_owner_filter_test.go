@@ -0,0 +1,223 @@
+package logstorage
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+)
+
+func TestGetStreamOwnerNodeIdx(t *testing.T) {
+	f := func(streamHash uint64, nodesCount, replicationFactor int, unavailableNodes, failedNodes []int, idxExpected int) {
+		t.Helper()
+
+		idx := GetStreamOwnerNodeIdx(streamHash, nodesCount, replicationFactor, unavailableNodes, failedNodes)
+		if idx != idxExpected {
+			t.Fatalf("unexpected owner node index; got %d; want %d", idx, idxExpected)
+		}
+	}
+
+	f(0, 1, 1, nil, nil, 0)
+	f(7, 3, 1, nil, nil, 1)
+	f(7, 3, 2, nil, nil, 1)
+	f(7, 3, 2, []int{1}, nil, 2)
+	f(7, 3, 2, nil, []int{1}, 2)
+	f(7, 3, 2, []int{1}, []int{2}, -1)
+	f(8, 3, 3, []int{2, 0}, nil, 1)
+	f(8, 3, 2, []int{2, 0}, nil, -1)
+}
+
+func TestStreamOwnerFilterSelectsEveryStreamOnce(t *testing.T) {
+	f := func(nodesCount, replicationFactor int, unavailableNodes, failedNodes []int) {
+		t.Helper()
+
+		for streamHash := uint64(0); streamHash < 1000; streamHash++ {
+			selects := 0
+
+			// The initial query to all the available nodes. The failed nodes do not return data.
+			for nodeIdx := 0; nodeIdx < nodesCount; nodeIdx++ {
+				if slices.Contains(unavailableNodes, nodeIdx) || slices.Contains(failedNodes, nodeIdx) {
+					continue
+				}
+				sof := &StreamOwnerFilter{
+					NodesCount:        nodesCount,
+					ReplicationFactor: replicationFactor,
+					NodeIdx:           nodeIdx,
+					UnavailableNodes:  unavailableNodes,
+				}
+				if sof.matchStreamHash(streamHash) {
+					selects++
+				}
+			}
+
+			// The query for log streams owned by the failed nodes.
+			if len(failedNodes) > 0 {
+				for nodeIdx := 0; nodeIdx < nodesCount; nodeIdx++ {
+					if slices.Contains(unavailableNodes, nodeIdx) || slices.Contains(failedNodes, nodeIdx) {
+						continue
+					}
+					sof := &StreamOwnerFilter{
+						NodesCount:        nodesCount,
+						ReplicationFactor: replicationFactor,
+						NodeIdx:           nodeIdx,
+						UnavailableNodes:  unavailableNodes,
+						FailedNodes:       failedNodes,
+					}
+					if sof.matchStreamHash(streamHash) {
+						selects++
+					}
+				}
+			}
+
+			if selects != 1 {
+				t.Fatalf("unexpected number of nodes selecting the stream with hash %d; got %d; want 1", streamHash, selects)
+			}
+		}
+	}
+
+	f(1, 1, nil, nil)
+	f(3, 1, nil, nil)
+	f(3, 2, nil, nil)
+	f(3, 3, nil, nil)
+	f(5, 3, nil, nil)
+
+	// unavailable nodes
+	f(3, 2, []int{0}, nil)
+	f(5, 3, []int{1, 2}, nil)
+
+	// failed nodes
+	f(3, 2, nil, []int{2})
+	f(5, 3, nil, []int{0, 4})
+	f(5, 3, []int{1}, []int{2})
+}
+
+func TestUnmarshalStreamOwnerFilterFromJSON(t *testing.T) {
+	f := func(s string, resultExpected *StreamOwnerFilter) {
+		t.Helper()
+
+		sof, err := UnmarshalStreamOwnerFilterFromJSON([]byte(s))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if sof == nil || resultExpected == nil {
+			if sof != resultExpected {
+				t.Fatalf("unexpected result; got %v; want %v", sof, resultExpected)
+			}
+			return
+		}
+		data := MarshalStreamOwnerFilterToJSON(sof)
+		dataExpected := MarshalStreamOwnerFilterToJSON(resultExpected)
+		if string(data) != string(dataExpected) {
+			t.Fatalf("unexpected result\ngot\n%s\nwant\n%s", data, dataExpected)
+		}
+	}
+
+	f(`null`, nil)
+	f(`{"nodes_count":3,"replication_factor":2,"node_idx":1}`, &StreamOwnerFilter{
+		NodesCount:        3,
+		ReplicationFactor: 2,
+		NodeIdx:           1,
+	})
+	f(`{"nodes_count":5,"replication_factor":3,"node_idx":4,"unavailable_nodes":[1],"failed_nodes":[0,2]}`, &StreamOwnerFilter{
+		NodesCount:        5,
+		ReplicationFactor: 3,
+		NodeIdx:           4,
+		UnavailableNodes:  []int{1},
+		FailedNodes:       []int{0, 2},
+	})
+
+	fFailure := func(s string) {
+		t.Helper()
+
+		if _, err := UnmarshalStreamOwnerFilterFromJSON([]byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error for %s", s)
+		}
+	}
+
+	fFailure(``)
+	fFailure(`foo`)
+	fFailure(`{"nodes_count":0,"replication_factor":1,"node_idx":0}`)
+	fFailure(`{"nodes_count":3,"replication_factor":4,"node_idx":0}`)
+	fFailure(`{"nodes_count":3,"replication_factor":2,"node_idx":3}`)
+}
+
+func TestStorageRunQueryWithStreamOwnerFilter(t *testing.T) {
+	t.Parallel()
+
+	path := t.Name()
+	s := MustOpenStorage(path, &StorageConfig{
+		Retention: 24 * time.Hour,
+	})
+
+	tenantID := TenantID{AccountID: 1, ProjectID: 2}
+	const streamsCount = 100
+	const rowsPerStream = 10
+	now := time.Now().UnixNano()
+	lr := GetLogRows([]string{"app"}, nil, nil, nil, "")
+	for i := 0; i < streamsCount*rowsPerStream; i++ {
+		fields := []Field{
+			{Name: "app", Value: fmt.Sprintf("app_%d", i%streamsCount)},
+			{Name: "_msg", Value: fmt.Sprintf("message %d", i)},
+		}
+		lr.MustAdd(tenantID, now-int64(i), fields, -1)
+	}
+	s.MustAddRows(lr)
+	PutLogRows(lr)
+	s.DebugFlush()
+
+	f := func(nodesCount, replicationFactor int, unavailableNodes []int) {
+		t.Helper()
+
+		q, err := ParseQuery(`* | stats count() rows`)
+		if err != nil {
+			t.Fatalf("cannot parse query: %s", err)
+		}
+
+		rowsTotal := uint64(0)
+		for nodeIdx := 0; nodeIdx < nodesCount; nodeIdx++ {
+			if slices.Contains(unavailableNodes, nodeIdx) {
+				continue
+			}
+			var qs QueryStats
+			qctx := NewQueryContext(t.Context(), &qs, []TenantID{tenantID}, q, false, nil)
+			qctx.StreamOwnerFilter = &StreamOwnerFilter{
+				NodesCount:        nodesCount,
+				ReplicationFactor: replicationFactor,
+				NodeIdx:           nodeIdx,
+				UnavailableNodes:  unavailableNodes,
+			}
+			var rowsLock sync.Mutex
+			writeBlock := func(_ uint, db *DataBlock) {
+				rowsLock.Lock()
+				defer rowsLock.Unlock()
+
+				for _, v := range db.Columns[0].Values {
+					n, err := strconv.ParseUint(v, 10, 64)
+					if err != nil {
+						t.Fatalf("cannot parse rows count: %s", err)
+					}
+					rowsTotal += n
+				}
+			}
+			if err := s.RunQuery(qctx, writeBlock); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		if rowsTotal != streamsCount*rowsPerStream {
+			t.Fatalf("unexpected number of rows selected from all the nodes; got %d; want %d", rowsTotal, streamsCount*rowsPerStream)
+		}
+	}
+
+	f(1, 1, nil)
+	f(3, 2, nil)
+	f(3, 2, []int{1})
+	f(5, 3, []int{0, 3})
+
+	s.MustClose()
+	fs.MustRemoveDir(path)
+}
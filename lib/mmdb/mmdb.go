@@ -0,0 +1,429 @@
+// Package mmdb implements reader for MaxMind DB files.
+//
+// See https://maxmind.github.io/MaxMind-DB/
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataStartMarker is the marker, which precedes the metadata section at the end of MaxMind DB file.
+var metadataStartMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparatorSize is the size of zero bytes between the search tree and the data section.
+const dataSectionSeparatorSize = 16
+
+// maxDecodeDepth is the maximum nesting depth for the decoded values.
+//
+// It protects from stack overflow on malicious data.
+const maxDecodeDepth = 64
+
+// Metadata contains MaxMind DB metadata.
+type Metadata struct {
+	// DatabaseType is the database type such as GeoLite2-City or GeoLite2-ASN.
+	DatabaseType string
+
+	// IPVersion is the IP version of the search tree - 4 or 6.
+	IPVersion int
+
+	// NodeCount is the number of nodes in the search tree.
+	NodeCount uint32
+
+	// RecordSize is the size of a single record in the search tree in bits - 24, 28 or 32.
+	RecordSize int
+
+	// BuildEpoch is the database build time in Unix seconds.
+	BuildEpoch uint64
+}
+
+// Reader reads MaxMind DB data.
+//
+// Reader is safe for concurrent use by multiple goroutines.
+type Reader struct {
+	// Metadata is the database metadata.
+	Metadata Metadata
+
+	tree        []byte
+	dataSection []byte
+	nodeSize    int
+
+	// ipv4Start is the node to start the search for IPv4 addresses at IPv6 search tree.
+	ipv4Start uint32
+}
+
+// Open returns Reader for the MaxMind DB data.
+//
+// The data must remain unchanged while the returned Reader is in use.
+func Open(data []byte) (*Reader, error) {
+	n := bytes.LastIndex(data, metadataStartMarker)
+	if n < 0 {
+		return nil, fmt.Errorf("cannot find metadata section; the data isn't in MaxMind DB format")
+	}
+	metadataSection := data[n+len(metadataStartMarker):]
+
+	d := decoder{
+		data: metadataSection,
+	}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode metadata: %w", err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected metadata type; got %T; want map", v)
+	}
+
+	var md Metadata
+	md.DatabaseType, _ = m["database_type"].(string)
+	nodeCount, ok := getUint64(m["node_count"])
+	if !ok || nodeCount > math.MaxUint32 {
+		return nil, fmt.Errorf("missing or invalid node_count in metadata")
+	}
+	md.NodeCount = uint32(nodeCount)
+	recordSize, ok := getUint64(m["record_size"])
+	if !ok {
+		return nil, fmt.Errorf("missing record_size in metadata")
+	}
+	md.RecordSize = int(recordSize)
+	ipVersion, ok := getUint64(m["ip_version"])
+	if !ok {
+		return nil, fmt.Errorf("missing ip_version in metadata")
+	}
+	md.IPVersion = int(ipVersion)
+	md.BuildEpoch, _ = getUint64(m["build_epoch"])
+
+	switch md.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record_size=%d; supported values: 24, 28, 32", md.RecordSize)
+	}
+	if md.IPVersion != 4 && md.IPVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip_version=%d; supported values: 4, 6", md.IPVersion)
+	}
+
+	nodeSize := md.RecordSize / 4
+	treeSize := uint64(md.NodeCount) * uint64(nodeSize)
+	if treeSize+dataSectionSeparatorSize > uint64(n) {
+		return nil, fmt.Errorf("search tree size %d exceeds the available data size %d", treeSize, n)
+	}
+
+	r := &Reader{
+		Metadata:    md,
+		tree:        data[:treeSize],
+		dataSection: data[treeSize+dataSectionSeparatorSize : n],
+		nodeSize:    nodeSize,
+	}
+
+	if md.IPVersion == 6 {
+		// IPv4 addresses are stored at ::/96 subtree.
+		node := uint32(0)
+		for i := 0; i < 96 && node < md.NodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Lookup returns the offset of the data record for the given addr.
+//
+// false is returned if the addr is missing in the database.
+// The returned offset can be decoded with Decode.
+func (r *Reader) Lookup(addr netip.Addr) (uint32, bool, error) {
+	node := uint32(0)
+	var ip []byte
+	if addr.Is4() || addr.Is4In6() {
+		ip4 := addr.Unmap().As4()
+		ip = ip4[:]
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.Metadata.IPVersion == 4 {
+			return 0, false, nil
+		}
+		ip16 := addr.As16()
+		ip = ip16[:]
+	}
+
+	nodeCount := r.Metadata.NodeCount
+	bitsCount := len(ip) * 8
+	for i := 0; i < bitsCount && node < nodeCount; i++ {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	if node == nodeCount {
+		// The addr isn't found
+		return 0, false, nil
+	}
+	if node < nodeCount {
+		return 0, false, fmt.Errorf("invalid search tree: cannot reach data record for %s", addr)
+	}
+	offset := node - nodeCount - dataSectionSeparatorSize
+	if node-nodeCount < dataSectionSeparatorSize || int(offset) >= len(r.dataSection) {
+		return 0, false, fmt.Errorf("invalid search tree: data record offset %d is out of data section with size %d", node-nodeCount, len(r.dataSection))
+	}
+	return offset, true, nil
+}
+
+// Decode decodes data record at the given offset obtained via Lookup.
+//
+// The returned value may contain map[string]any, []any, string, []byte, float64, float32, uint64, int32 and bool values.
+// The returned byte slices refer to the underlying data passed to Open.
+func (r *Reader) Decode(offset uint32) (any, error) {
+	d := decoder{
+		data: r.dataSection,
+	}
+	v, _, err := d.decode(int(offset), 0)
+	return v, err
+}
+
+func (r *Reader) readRecord(node uint32, bit byte) uint32 {
+	b := r.tree[int(node)*r.nodeSize:]
+	switch r.Metadata.RecordSize {
+	case 24:
+		if bit != 0 {
+			b = b[3:]
+		}
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		if bit != 0 {
+			b = b[4:]
+		}
+		return binary.BigEndian.Uint32(b)
+	}
+}
+
+// Data types at MaxMind DB data section.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+type decoder struct {
+	data []byte
+}
+
+// decode decodes the value at the given offset and returns the offset for the next value.
+func (d *decoder) decode(offset, depth int) (any, int, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("too deep nesting of values; it exceeds %d", maxDecodeDepth)
+	}
+
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		ptr, offsetNext, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Pointers to pointers aren't allowed by the spec, so the depth is increased in order to prevent from infinite loops.
+		v, _, err := d.decode(ptr, depth+1)
+		return v, offsetNext, err
+	}
+
+	end := offset + size
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 1024))
+		for range size {
+			k, offsetNext, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, fmt.Errorf("cannot decode map key: %w", err)
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("unexpected map key type; got %T; want string", k)
+			}
+			v, offsetNext, err := d.decode(offsetNext, depth+1)
+			if err != nil {
+				return nil, 0, fmt.Errorf("cannot decode map value for key %q: %w", key, err)
+			}
+			m[key] = v
+			offset = offsetNext
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 1024))
+		for range size {
+			v, offsetNext, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, fmt.Errorf("cannot decode array item: %w", err)
+			}
+			a = append(a, v)
+			offset = offsetNext
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("invalid size for bool value; got %d; want 0 or 1", size)
+		}
+		return size == 1, offset, nil
+	case typeContainer, typeEnd:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+
+	if end > len(d.data) {
+		return nil, 0, fmt.Errorf("value with size %d at offset %d exceeds the data size %d", size, offset, len(d.data))
+	}
+	b := d.data[offset:end]
+
+	switch typ {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return b, end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid size for double value; got %d; want 8", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid size for float value; got %d; want 4", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		maxSize := 8
+		switch typ {
+		case typeUint16:
+			maxSize = 2
+		case typeUint32:
+			maxSize = 4
+		}
+		if size > maxSize {
+			return nil, 0, fmt.Errorf("invalid size for unsigned int; got %d; want up to %d", size, maxSize)
+		}
+		return decodeUint(b), end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid size for int32; got %d; want up to 4", size)
+		}
+		return int32(uint32(decodeUint(b))), end, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid size for uint128; got %d; want up to 16", size)
+		}
+		// Return uint128 as bytes, since there is no native type for it.
+		return b, end, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %d", typ)
+	}
+}
+
+func (d *decoder) decodeControl(offset int) (int, int, int, error) {
+	if offset >= len(d.data) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+	}
+	ctrl := d.data[offset]
+	offset++
+
+	typ := int(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= len(d.data) {
+			return 0, 0, 0, fmt.Errorf("unexpected end of data when reading extended type at offset %d", offset)
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+		if typ <= typeMap {
+			return 0, 0, 0, fmt.Errorf("invalid extended type %d", typ)
+		}
+	}
+
+	size := int(ctrl & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > len(d.data) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of data when reading size at offset %d", offset)
+	}
+	v := int(decodeUint(d.data[offset : offset+n]))
+	offset += n
+	switch size {
+	case 29:
+		size = 29 + v
+	case 30:
+		size = 285 + v
+	default:
+		size = 65821 + v
+	}
+	return typ, size, offset, nil
+}
+
+func (d *decoder) decodePointer(ctrlSize, offset int) (int, int, error) {
+	ss := (ctrlSize >> 3) & 0x3
+	n := ss + 1
+	if offset+n > len(d.data) {
+		return 0, 0, fmt.Errorf("unexpected end of data when reading pointer at offset %d", offset)
+	}
+	b := d.data[offset : offset+n]
+	vvv := uint64(ctrlSize & 0x7)
+
+	var ptr uint64
+	switch ss {
+	case 0:
+		ptr = vvv<<8 | decodeUint(b)
+	case 1:
+		ptr = (vvv<<16 | decodeUint(b)) + 2048
+	case 2:
+		ptr = (vvv<<24 | decodeUint(b)) + 526336
+	default:
+		ptr = decodeUint(b)
+	}
+	if ptr >= uint64(len(d.data)) {
+		return 0, 0, fmt.Errorf("pointer %d is out of data with size %d", ptr, len(d.data))
+	}
+	return int(ptr), offset + n, nil
+}
+
+func decodeUint(b []byte) uint64 {
+	n := uint64(0)
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+func getUint64(v any) (uint64, bool) {
+	switch t := v.(type) {
+	case uint64:
+		return t, true
+	case int32:
+		if t < 0 {
+			return 0, false
+		}
+		return uint64(t), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,225 @@
+package mmdb
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReaderLookup(t *testing.T) {
+	f := func(ipVersion, recordSize int) {
+		t.Helper()
+
+		w := NewWriter(ipVersion, recordSize, "Test-City")
+		values := map[string]any{
+			"1.2.3.0/24": map[string]any{
+				"country": map[string]any{
+					"iso_code": "AU",
+					"names": map[string]any{
+						"en": "Australia",
+					},
+				},
+				"location": map[string]any{
+					"latitude":  -33.494,
+					"longitude": 143.2104,
+				},
+			},
+			"10.0.0.0/8": map[string]any{
+				"country": map[string]any{
+					"iso_code": "US",
+					"names": map[string]any{
+						"en": "United States",
+					},
+				},
+				"subdivisions": []any{
+					map[string]any{
+						"iso_code": "CA",
+					},
+				},
+				"autonomous_system_number": uint32(15169),
+				"is_anycast":               true,
+				"big":                      uint64(1) << 40,
+				"negative":                 int32(-123),
+				"f32":                      float32(1.5),
+				"raw":                      []byte("foo"),
+				"long_string":              strings.Repeat("x", 300),
+			},
+		}
+		prefixes := []string{"1.2.3.0/24", "10.0.0.0/8"}
+		if ipVersion == 6 {
+			values["2001:db8::/32"] = map[string]any{
+				"country": map[string]any{
+					"iso_code": "DE",
+				},
+			}
+			prefixes = append(prefixes, "2001:db8::/32")
+		}
+		for _, prefix := range prefixes {
+			if err := w.Insert(netip.MustParsePrefix(prefix), values[prefix]); err != nil {
+				t.Fatalf("cannot insert %s: %s", prefix, err)
+			}
+		}
+		data, err := w.Bytes()
+		if err != nil {
+			t.Fatalf("cannot build database: %s", err)
+		}
+
+		r, err := Open(data)
+		if err != nil {
+			t.Fatalf("cannot open database: %s", err)
+		}
+		if r.Metadata.DatabaseType != "Test-City" {
+			t.Fatalf("unexpected database type; got %q; want %q", r.Metadata.DatabaseType, "Test-City")
+		}
+		if r.Metadata.IPVersion != ipVersion {
+			t.Fatalf("unexpected ip version; got %d; want %d", r.Metadata.IPVersion, ipVersion)
+		}
+		if r.Metadata.RecordSize != recordSize {
+			t.Fatalf("unexpected record size; got %d; want %d", r.Metadata.RecordSize, recordSize)
+		}
+
+		lookup := func(ip string, prefixExpected string) {
+			t.Helper()
+
+			offset, ok, err := r.Lookup(netip.MustParseAddr(ip))
+			if err != nil {
+				t.Fatalf("unexpected error when looking up %s: %s", ip, err)
+			}
+			if prefixExpected == "" {
+				if ok {
+					t.Fatalf("unexpected data found for %s", ip)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("cannot find data for %s", ip)
+			}
+			v, err := r.Decode(offset)
+			if err != nil {
+				t.Fatalf("cannot decode data for %s: %s", ip, err)
+			}
+			vExpected := normalizeValue(values[prefixExpected])
+			if !reflect.DeepEqual(v, vExpected) {
+				t.Fatalf("unexpected data for %s\ngot\n%#v\nwant\n%#v", ip, v, vExpected)
+			}
+		}
+
+		lookup("1.2.3.4", "1.2.3.0/24")
+		lookup("1.2.3.255", "1.2.3.0/24")
+		lookup("1.2.4.0", "")
+		lookup("10.20.30.40", "10.0.0.0/8")
+		lookup("11.0.0.1", "")
+		lookup("::ffff:10.20.30.40", "10.0.0.0/8")
+		if ipVersion == 6 {
+			lookup("2001:db8::1", "2001:db8::/32")
+			lookup("2001:db9::1", "")
+		} else {
+			lookup("2001:db8::1", "")
+		}
+	}
+
+	f(4, 24)
+	f(4, 28)
+	f(4, 32)
+	f(6, 24)
+	f(6, 28)
+	f(6, 32)
+}
+
+func normalizeValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, v := range t {
+			m[k] = normalizeValue(v)
+		}
+		return m
+	case []any:
+		a := make([]any, len(t))
+		for i, v := range t {
+			a[i] = normalizeValue(v)
+		}
+		return a
+	case uint32:
+		return uint64(t)
+	case uint16:
+		return uint64(t)
+	default:
+		return v
+	}
+}
+
+func TestOpenFailure(t *testing.T) {
+	f := func(data []byte) {
+		t.Helper()
+
+		if _, err := Open(data); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	f(nil)
+	f([]byte("foobar"))
+	f(metadataStartMarker)
+
+	// metadata isn't a map
+	e := encoder{}
+	_ = e.encode("foo")
+	f(append(append([]byte{}, metadataStartMarker...), e.buf...))
+
+	// unsupported record size
+	e = encoder{}
+	_ = e.encode(map[string]any{
+		"node_count":  uint32(0),
+		"record_size": uint16(20),
+		"ip_version":  uint16(4),
+	})
+	f(append(make([]byte, 16), append(append([]byte{}, metadataStartMarker...), e.buf...)...))
+
+	// too big node count
+	e = encoder{}
+	_ = e.encode(map[string]any{
+		"node_count":  uint32(100),
+		"record_size": uint16(24),
+		"ip_version":  uint16(4),
+	})
+	f(append(make([]byte, 16), append(append([]byte{}, metadataStartMarker...), e.buf...)...))
+}
+
+func TestReaderCorruptedData(t *testing.T) {
+	w := NewWriter(6, 28, "Test")
+	if err := w.Insert(netip.MustParsePrefix("1.2.3.0/24"), map[string]any{
+		"country": map[string]any{
+			"iso_code": "AU",
+		},
+		"asn": uint32(123),
+	}); err != nil {
+		t.Fatalf("cannot insert value: %s", err)
+	}
+	data, err := w.Bytes()
+	if err != nil {
+		t.Fatalf("cannot build database: %s", err)
+	}
+
+	r, err := Open(data)
+	if err != nil {
+		t.Fatalf("cannot open database: %s", err)
+	}
+	offset, ok, err := r.Lookup(netip.MustParseAddr("1.2.3.4"))
+	if err != nil || !ok {
+		t.Fatalf("cannot find data; ok=%v, err=%v", ok, err)
+	}
+
+	// Corrupt every byte of the data section and verify the decoder doesn't panic.
+	for i := range r.dataSection {
+		dataCopy := append([]byte{}, r.dataSection...)
+		for _, c := range []byte{0x00, 0xff, 0x20, 0xe0, 0x3f} {
+			dataCopy[i] = c
+			d := decoder{
+				data: dataCopy,
+			}
+			_, _, _ = d.decode(int(offset), 0)
+		}
+	}
+}
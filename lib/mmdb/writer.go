@@ -0,0 +1,310 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+)
+
+// Writer builds MaxMind DB data.
+//
+// It is used mainly for building MaxMind DB data in tests.
+type Writer struct {
+	ipVersion    int
+	recordSize   int
+	databaseType string
+
+	root *writerNode
+
+	e encoder
+}
+
+type writerNode struct {
+	children [2]writerRecord
+}
+
+type writerRecord struct {
+	node *writerNode
+
+	// dataOffset is the offset of the data at the data section if isData is set
+	dataOffset int
+	isData     bool
+}
+
+// NewWriter returns new Writer for the given ipVersion (4 or 6), recordSize (24, 28 or 32) and databaseType.
+func NewWriter(ipVersion, recordSize int, databaseType string) *Writer {
+	return &Writer{
+		ipVersion:    ipVersion,
+		recordSize:   recordSize,
+		databaseType: databaseType,
+		root:         &writerNode{},
+		e: encoder{
+			strings: make(map[string]int),
+		},
+	}
+}
+
+// Insert inserts the value for the given prefix.
+//
+// The value may contain map[string]any, []any, string, []byte, float64, float32, uint16, uint32, uint64, int32, int and bool values.
+func (w *Writer) Insert(prefix netip.Prefix, value any) error {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	var ip []byte
+	if addr.Is4() {
+		if w.ipVersion == 6 {
+			ip16 := netip.AddrFrom4(addr.As4()).As16()
+			// IPv4 addresses are stored at ::/96 subtree, so zero the ::ffff:0:0/96 prefix.
+			clear(ip16[:12])
+			ip = ip16[:]
+			bits += 96
+		} else {
+			ip4 := addr.As4()
+			ip = ip4[:]
+		}
+	} else {
+		if w.ipVersion == 4 {
+			return fmt.Errorf("cannot insert IPv6 prefix %s into IPv4 database", prefix)
+		}
+		ip16 := addr.As16()
+		ip = ip16[:]
+	}
+	if bits <= 0 {
+		return fmt.Errorf("prefix %s must have non-zero length", prefix)
+	}
+
+	dataOffset := len(w.e.buf)
+	if err := w.e.encode(value); err != nil {
+		return fmt.Errorf("cannot encode value for %s: %w", prefix, err)
+	}
+
+	node := w.root
+	for i := range bits {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		r := &node.children[bit]
+		if i == bits-1 {
+			r.node = nil
+			r.dataOffset = dataOffset
+			r.isData = true
+			return nil
+		}
+		if r.node == nil {
+			if r.isData {
+				return fmt.Errorf("prefix %s overlaps with the previously inserted prefix", prefix)
+			}
+			r.node = &writerNode{}
+		}
+		node = r.node
+	}
+	return nil
+}
+
+// Bytes returns MaxMind DB data for the inserted values.
+func (w *Writer) Bytes() ([]byte, error) {
+	// Enumerate nodes in breadth-first order.
+	nodes := []*writerNode{w.root}
+	nodeIDs := map[*writerNode]uint32{
+		w.root: 0,
+	}
+	for i := 0; i < len(nodes); i++ {
+		for _, r := range nodes[i].children {
+			if r.node != nil {
+				nodeIDs[r.node] = uint32(len(nodes))
+				nodes = append(nodes, r.node)
+			}
+		}
+	}
+	nodeCount := uint64(len(nodes))
+
+	maxRecord := uint64(1)<<w.recordSize - 1
+	var tree []byte
+	for _, n := range nodes {
+		var records [2]uint64
+		for i, r := range n.children {
+			switch {
+			case r.node != nil:
+				records[i] = uint64(nodeIDs[r.node])
+			case r.isData:
+				records[i] = nodeCount + dataSectionSeparatorSize + uint64(r.dataOffset)
+			default:
+				records[i] = nodeCount
+			}
+			if records[i] > maxRecord {
+				return nil, fmt.Errorf("record value %d exceeds the maximum value %d for record_size=%d", records[i], maxRecord, w.recordSize)
+			}
+		}
+		left, right := records[0], records[1]
+		switch w.recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte((left>>20)&0xf0|(right>>24)&0x0f), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, uint32(left))
+			tree = binary.BigEndian.AppendUint32(tree, uint32(right))
+		default:
+			return nil, fmt.Errorf("unsupported record_size=%d", w.recordSize)
+		}
+	}
+
+	data := append(tree, make([]byte, dataSectionSeparatorSize)...)
+	data = append(data, w.e.buf...)
+	data = append(data, metadataStartMarker...)
+
+	md := encoder{}
+	err := md.encode(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(0),
+		"database_type":               w.databaseType,
+		"description": map[string]any{
+			"en": w.databaseType,
+		},
+		"ip_version":  uint16(w.ipVersion),
+		"languages":   []any{"en"},
+		"node_count":  uint32(nodeCount),
+		"record_size": uint16(w.recordSize),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode metadata: %w", err)
+	}
+	data = append(data, md.buf...)
+
+	return data, nil
+}
+
+type encoder struct {
+	buf []byte
+
+	// strings contains offsets for the previously encoded strings.
+	//
+	// The previously encoded strings are written as pointers in order to save space.
+	strings map[string]int
+}
+
+func (e *encoder) encode(v any) error {
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.writeControl(typeMap, len(t))
+		for _, k := range keys {
+			e.encodeString(k)
+			if err := e.encode(t[k]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		e.writeControl(typeArray, len(t))
+		for _, x := range t {
+			if err := e.encode(x); err != nil {
+				return err
+			}
+		}
+	case string:
+		e.encodeString(t)
+	case []byte:
+		e.writeControl(typeBytes, len(t))
+		e.buf = append(e.buf, t...)
+	case float64:
+		e.writeControl(typeDouble, 8)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(t))
+	case float32:
+		e.writeControl(typeFloat, 4)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(t))
+	case uint16:
+		e.encodeUint(typeUint16, uint64(t))
+	case uint32:
+		e.encodeUint(typeUint32, uint64(t))
+	case uint64:
+		e.encodeUint(typeUint64, t)
+	case int:
+		if t < 0 || t > math.MaxUint32 {
+			return fmt.Errorf("int value %d is out of uint32 range", t)
+		}
+		e.encodeUint(typeUint32, uint64(t))
+	case int32:
+		e.writeControl(typeInt32, 4)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(t))
+	case bool:
+		size := 0
+		if t {
+			size = 1
+		}
+		e.writeControl(typeBool, size)
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
+
+func (e *encoder) encodeString(s string) {
+	if offset, ok := e.strings[s]; ok {
+		e.writePointer(offset)
+		return
+	}
+	if e.strings != nil {
+		e.strings[s] = len(e.buf)
+	}
+	e.writeControl(typeString, len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) encodeUint(typ int, n uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	i := 0
+	for i < len(b) && b[i] == 0 {
+		i++
+	}
+	e.writeControl(typ, len(b)-i)
+	e.buf = append(e.buf, b[i:]...)
+}
+
+func (e *encoder) writePointer(offset int) {
+	p := uint64(offset)
+	switch {
+	case p < 2048:
+		e.buf = append(e.buf, byte(typePointer<<5)|byte(p>>8), byte(p))
+	case p < 526336:
+		p -= 2048
+		e.buf = append(e.buf, byte(typePointer<<5)|1<<3|byte(p>>16), byte(p>>8), byte(p))
+	case p < 134744064:
+		p -= 526336
+		e.buf = append(e.buf, byte(typePointer<<5)|2<<3|byte(p>>24), byte(p>>16), byte(p>>8), byte(p))
+	default:
+		e.buf = append(e.buf, byte(typePointer<<5)|3<<3)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(p))
+	}
+}
+
+func (e *encoder) writeControl(typ, size int) {
+	var sizeBytes []byte
+	ctrlSize := size
+	switch {
+	case size < 29:
+	case size < 285:
+		ctrlSize = 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrlSize = 30
+		n := size - 285
+		sizeBytes = []byte{byte(n >> 8), byte(n)}
+	default:
+		ctrlSize = 31
+		n := size - 65821
+		sizeBytes = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	if typ <= typeMap {
+		e.buf = append(e.buf, byte(typ<<5)|byte(ctrlSize))
+	} else {
+		e.buf = append(e.buf, byte(ctrlSize), byte(typ-7))
+	}
+	e.buf = append(e.buf, sizeBytes...)
+}